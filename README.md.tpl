@@ -18,6 +18,16 @@ This repository is organised into multiple components:
   contains basic wrappers for readers and writers to read/write requests and
   responses.
 * [client](./client/) contains a minimalist pooled client.
+* [redeobench](./redeobench/) is a load generator with redis-benchmark
+  compatible workloads, see also [cmd/redeo-benchmark](./cmd/redeo-benchmark/).
+* [cmd/redeo-server](./cmd/redeo-server/) and [cmd/redeo-cli](./cmd/redeo-cli/)
+  are reference binaries, a server with an in-memory store and a simple REPL
+  client.
+
+Commands which extend a server in the style of Redis modules, such as the
+JSON and search modules of the reference server, should be named
+`<module>.<command>`, e.g. `JSON.SET`, so they do not clash with core commands
+or the commands of other modules.
 
 For full documentation and examples, please see the individual packages and the
 official API documentation: https://godoc.org/github.com/johntech-o/redeo.
@@ -0,0 +1,82 @@
+package redeo
+
+// backlog is a fixed size ring buffer holding the most recent bytes of
+// the replication stream, like Redis' repl_backlog. Its memory is
+// allocated on first use.
+type backlog struct {
+	buf  []byte
+	size int
+
+	pos int   // write position
+	n   int   // number of bytes held
+	end int64 // replication offset of the last byte held
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{size: size}
+}
+
+// write appends p, which ends at replication offset end, evicting the
+// oldest bytes if full
+func (b *backlog) write(p []byte, end int64) {
+	b.end = end
+	if len(p) >= b.size {
+		p = p[len(p)-b.size:]
+	}
+	if b.buf == nil {
+		b.buf = make([]byte, b.size)
+	}
+
+	for len(p) != 0 {
+		n := copy(b.buf[b.pos:], p)
+		p = p[n:]
+		b.pos = (b.pos + n) % b.size
+		if b.n += n; b.n > b.size {
+			b.n = b.size
+		}
+	}
+}
+
+// first returns the replication offset of the first byte held
+func (b *backlog) first() int64 { return b.end - int64(b.n) + 1 }
+
+// histLen returns the number of bytes held
+func (b *backlog) histLen() int { return b.n }
+
+// readFrom returns the bytes from replication offset start, it returns
+// false if they are no longer held. The result is empty if start is the
+// offset following the last byte.
+func (b *backlog) readFrom(start int64) ([]byte, bool) {
+	if start < b.first() || start > b.end+1 {
+		return nil, false
+	}
+
+	n := int(b.end - start + 1)
+	from := b.pos - n
+	if from >= 0 {
+		return append(make([]byte, 0, n), b.buf[from:b.pos]...), true
+	}
+
+	res := make([]byte, 0, n)
+	res = append(res, b.buf[b.size+from:]...)
+	return append(res, b.buf[:b.pos]...), true
+}
+
+// resize changes the size, retaining the most recent bytes
+func (b *backlog) resize(size int) {
+	if size == b.size {
+		return
+	}
+
+	data, _ := b.readFrom(b.first())
+	end := b.end
+	*b = backlog{size: size, end: end}
+	if len(data) != 0 {
+		b.write(data, end)
+	}
+}
+
+// reset discards all bytes, the offset is retained
+func (b *backlog) reset() {
+	b.pos, b.n = 0, 0
+}
@@ -0,0 +1,68 @@
+package redeo
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backlog", func() {
+	var subject *backlog
+
+	read := func(start int64) string {
+		p, ok := subject.readFrom(start)
+		ExpectWithOffset(1, ok).To(BeTrue())
+		return string(p)
+	}
+
+	BeforeEach(func() {
+		subject = newBacklog(8)
+	})
+
+	It("should retain the most recent bytes", func() {
+		Expect(read(1)).To(BeEmpty())
+		Expect(subject.histLen()).To(Equal(0))
+
+		subject.write([]byte("abcde"), 5)
+		Expect(subject.first()).To(Equal(int64(1)))
+		Expect(read(1)).To(Equal("abcde"))
+		Expect(read(4)).To(Equal("de"))
+		Expect(read(6)).To(Equal(""))
+
+		subject.write([]byte("fghij"), 10)
+		Expect(subject.first()).To(Equal(int64(3)))
+		Expect(subject.histLen()).To(Equal(8))
+		Expect(read(3)).To(Equal("cdefghij"))
+		Expect(read(9)).To(Equal("ij"))
+
+		_, ok := subject.readFrom(2)
+		Expect(ok).To(BeFalse())
+		_, ok = subject.readFrom(12)
+		Expect(ok).To(BeFalse())
+
+		subject.write([]byte("klmnopqrstuvwxyz"), 26)
+		Expect(subject.first()).To(Equal(int64(19)))
+		Expect(read(19)).To(Equal("stuvwxyz"))
+	})
+
+	It("should resize", func() {
+		subject.write([]byte("abcdefghij"), 10)
+		subject.resize(4)
+		Expect(subject.first()).To(Equal(int64(7)))
+		Expect(read(7)).To(Equal("ghij"))
+
+		subject.resize(16)
+		subject.write([]byte("klm"), 13)
+		Expect(read(7)).To(Equal("ghijklm"))
+	})
+
+	It("should reset", func() {
+		subject.write([]byte("abcde"), 5)
+		subject.reset()
+		Expect(subject.histLen()).To(Equal(0))
+		Expect(read(6)).To(Equal(""))
+
+		_, ok := subject.readFrom(5)
+		Expect(ok).To(BeFalse())
+	})
+
+})
@@ -1,40 +1,145 @@
 package redeo
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/johntech-o/redeo/resp"
 )
 
 var (
-	clientInc  = uint64(0)
-	readerPool sync.Pool
-	writerPool sync.Pool
+	clientInc   = uint64(0)
+	requestInc  = uint64(0)
+	readerPools sizedPool
+	writerPools sizedPool
+	framePool   sync.Pool
+)
+
+// ErrInvalidClientName is returned by SetName if the name contains spaces,
+// newlines or other special characters
+var ErrInvalidClientName = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+
+// ErrFrameQueueFull is returned by WriteFrame if a busy client has
+// accumulated too many queued frames, the client is disconnected.
+var ErrFrameQueueFull = errors.New("redeo: frame queue full")
+
+const (
+	// maxPendingSize is the max size of frames queued for a busy
+	// client, slow clients are disconnected beyond it
+	maxPendingSize = 8 * 1024 * 1024
+
+	// maxPendingRetain is the max capacity of the frame queue
+	// retained after it has been flushed
+	maxPendingRetain = 64 * 1024
 )
 
 type ctxKeyClient struct{}
 
+type ctxKeyRequestID struct{}
+
+// commandContext carries the client and the request ID of a command,
+// which saves an allocation over nesting context values
+type commandContext struct {
+	context.Context
+	client *Client
+	id     uint64
+}
+
+func (c *commandContext) Value(key interface{}) interface{} {
+	switch key.(type) {
+	case ctxKeyClient:
+		return c.client
+	case ctxKeyRequestID:
+		return c.id
+	}
+	return c.Context.Value(key)
+}
+
+func (c *Client) commandContext(parent context.Context) context.Context {
+	if !c.deadline.IsZero() {
+		parent, c.cancel = context.WithDeadline(parent, c.deadline)
+	}
+	return &commandContext{Context: parent, client: c, id: atomic.AddUint64(&requestInc, 1)}
+}
+
+// endCmd releases the deadline of the current command context
+func (c *Client) endCmd() {
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+}
+
 // Client contains information about a client connection
 type Client struct {
+	// accessed atomically, must remain 64-bit aligned
+	bytesRead    int64
+	bytesWritten int64
+
 	id uint64
 	cn net.Conn
 
 	rd *resp.RequestReader
 	wr resp.ResponseWriter
 
-	ctx    context.Context
-	closed bool
+	ctx       context.Context
+	closed    bool
+	pubsub    int32
+	replica   int32
+	noEvict   int32
+	readWrite int32
+	proto     int32
+
+	cmd     *resp.Command
+	scmd    *resp.CommandStream
+	aw      arityWriter
+	cluster clusterClient
+
+	// rejected counts the commands rejected before reaching their
+	// handlers, see Rejected
+	rejected int64
 
-	cmd  *resp.Command
-	scmd *resp.CommandStream
+	// deadline of the current pipeline, cancel
+	// releases the current command context
+	deadline time.Time
+	cancel   context.CancelFunc
+
+	wmu     sync.Mutex
+	busy    bool
+	pending []byte
+
+	poll   *poller
+	fd     int
+	parked int32
+
+	rdSize, wrSize int
+	rdMax          int
+
+	clock    Clock
+	created  time.Time
+	smu      sync.Mutex // protects the fields below
+	accessed time.Time
+	lastCmd  string
+	commands int64
+	name     string
+	libName  string
+	libVer   string
 }
 
 func newClient(cn net.Conn) *Client {
+	return newClientSize(cn, SystemClock, resp.MaxBufferSize, resp.MaxBufferSize)
+}
+
+func newClientSize(cn net.Conn, clock Clock, rdSize, wrSize int) *Client {
 	c := new(Client)
-	c.reset(cn)
+	c.reset(cn, clock, rdSize, wrSize)
 	return c
 }
 
@@ -49,9 +154,159 @@ func GetClient(ctx context.Context) *Client {
 	return nil
 }
 
+// RequestID retrieves the ID of the current request from the context.
+// Request IDs are unique within the process and increase monotonically,
+// 0 is returned if an ID is not set.
+func RequestID(ctx context.Context) uint64 {
+	if ctx != nil {
+		if id, ok := ctx.Value(ctxKeyRequestID{}).(uint64); ok {
+			return id
+		}
+	}
+	return 0
+}
+
 // ID return the unique client id
 func (c *Client) ID() uint64 { return c.id }
 
+// CreateTime returns the time at which the client has connected
+func (c *Client) CreateTime() time.Time { return c.created }
+
+// AccessTime returns the time of the client's last command
+func (c *Client) AccessTime() time.Time {
+	c.smu.Lock()
+	t := c.accessed
+	c.smu.Unlock()
+	return t
+}
+
+// LastCmd returns the name of the last command called by the client
+func (c *Client) LastCmd() string {
+	c.smu.Lock()
+	s := c.lastCmd
+	c.smu.Unlock()
+	return s
+}
+
+// NumCommands returns the number of commands processed
+func (c *Client) NumCommands() int64 {
+	c.smu.Lock()
+	n := c.commands
+	c.smu.Unlock()
+	return n
+}
+
+// Rejected returns the number of commands of the client which the server
+// rejected before they reached their handlers, e.g. unknown commands,
+// cluster redirections and writes denied by a WriteChecker. It must only
+// be called by handlers of the client's commands. Handlers which queue
+// commands, like MULTI, use it to detect commands missing from the queue.
+func (c *Client) Rejected() int64 { return c.rejected }
+
+// Name returns the name of the client, as set by CLIENT SETNAME
+func (c *Client) Name() string {
+	c.smu.Lock()
+	s := c.name
+	c.smu.Unlock()
+	return s
+}
+
+// SetName assigns a name to the client, an empty name removes it. Names
+// may only contain printable ASCII characters other than space, otherwise
+// ErrInvalidClientName is returned.
+func (c *Client) SetName(name string) error {
+	if !validClientAttr(name) {
+		return ErrInvalidClientName
+	}
+
+	c.smu.Lock()
+	c.name = name
+	c.smu.Unlock()
+	return nil
+}
+
+// LibName returns the name of the client library, as set by CLIENT SETINFO
+func (c *Client) LibName() string {
+	c.smu.Lock()
+	s := c.libName
+	c.smu.Unlock()
+	return s
+}
+
+// LibVersion returns the version of the client library, as set by
+// CLIENT SETINFO
+func (c *Client) LibVersion() string {
+	c.smu.Lock()
+	s := c.libVer
+	c.smu.Unlock()
+	return s
+}
+
+// setLibInfo stores the lib-name or lib-ver attribute
+func (c *Client) setLibInfo(name, ver *string) {
+	c.smu.Lock()
+	if name != nil {
+		c.libName = *name
+	}
+	if ver != nil {
+		c.libVer = *ver
+	}
+	c.smu.Unlock()
+}
+
+// BytesRead returns the number of bytes read from the client
+func (c *Client) BytesRead() int64 { return atomic.LoadInt64(&c.bytesRead) }
+
+// BytesWritten returns the number of bytes written to the client
+func (c *Client) BytesWritten() int64 { return atomic.LoadInt64(&c.bytesWritten) }
+
+// SetNoEvict protects the client from being disconnected by the server's
+// resource limits, e.g. replication links or admin connections. Protected
+// clients are exempt from Config.Timeout read deadlines while they are
+// idle, and from the frame queue limit of WriteFrame.
+func (c *Client) SetNoEvict(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.noEvict, v)
+}
+
+// NoEvict returns true if the client is protected from eviction
+func (c *Client) NoEvict() bool { return atomic.LoadInt32(&c.noEvict) == 1 }
+
+// SetReadWrite allows the client to execute write commands while the server
+// is read-only, see Server.SetReadOnly.
+func (c *Client) SetReadWrite(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.readWrite, v)
+}
+
+// ReadWrite returns true if the client may write to read-only servers
+func (c *Client) ReadWrite() bool { return atomic.LoadInt32(&c.readWrite) == 1 }
+
+// Protocol returns the protocol version negotiated by HELLO, resp.RESP2
+// by default.
+func (c *Client) Protocol() int {
+	if v := atomic.LoadInt32(&c.proto); v != 0 {
+		return int(v)
+	}
+	return resp.RESP2
+}
+
+// SetProtocol switches the client to a protocol version, either
+// resp.RESP2 or resp.RESP3. It must only be called by handlers, before
+// the reply is written.
+func (c *Client) SetProtocol(v int) {
+	atomic.StoreInt32(&c.proto, int32(v))
+	if c.wr != nil {
+		resp.SetProtocol(c.wr, v)
+	}
+}
+
 // Context return the client context
 func (c *Client) Context() context.Context {
 	if c.ctx != nil {
@@ -70,16 +325,203 @@ func (c *Client) RemoteAddr() net.Addr {
 	return c.cn.RemoteAddr()
 }
 
+// LocalAddr return the local address the client is connected to
+func (c *Client) LocalAddr() net.Addr {
+	return c.cn.LocalAddr()
+}
+
+// TLSConnectionState returns the state of the TLS connection. The second
+// return value is false if the client is not connected via TLS.
+func (c *Client) TLSConnectionState() (tls.ConnectionState, bool) {
+	if tc, ok := c.tlsConn(); ok {
+		return tc.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// tlsConn returns the TLS connection, if any
+func (c *Client) tlsConn() (*tls.Conn, bool) {
+	cn := c.cn
+	if rc, ok := cn.(*recordingConn); ok {
+		cn = rc.Conn
+	}
+	tc, ok := cn.(*tls.Conn)
+	return tc, ok
+}
+
+// PeerCertificates returns the certificate chain presented by the client,
+// leaf first. It returns nil for plain connections or if the client did
+// not present a certificate.
+func (c *Client) PeerCertificates() []*x509.Certificate {
+	state, _ := c.TLSConnectionState()
+	return state.PeerCertificates
+}
+
+// WriteFrame allows to write to the client outside the command-cycle, e.g.
+// to push messages from a different goroutine. Everything fn writes is
+// delivered as a single block and will never be interleaved
+// with replies to the client's own commands. If the client is currently
+// executing commands, the frame is queued and sent after the pending replies.
+// Clients which fail to keep up and accumulate more than 8MiB of queued
+// frames are disconnected and ErrFrameQueueFull is returned, unless they
+// are protected via SetNoEvict. The writer passed to fn uses the protocol
+// version of the client.
+func (c *Client) WriteFrame(fn func(w resp.ResponseWriter)) error {
+	f := fetchFrame()
+	defer framePool.Put(f)
+
+	resp.SetProtocol(f.wr, c.Protocol())
+	fn(f.wr)
+	if err := f.wr.Flush(); err != nil {
+		return err
+	}
+	return c.writeRaw(f.buf.Bytes())
+}
+
+// writeRaw writes pre-encoded data, it is queued like a frame
+// while the client is busy
+func (c *Client) writeRaw(p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.busy {
+		if len(c.pending)+len(p) > maxPendingSize && !c.NoEvict() {
+			c.pending = nil
+			c.kill()
+			return ErrFrameQueueFull
+		}
+		c.pending = append(c.pending, p...)
+		return nil
+	}
+
+	_, err := c.conn().Write(p)
+	return err
+}
+
+// writeDirect writes the pending replies followed by bufs to the
+// connection, bypassing the limit of queued frames. It must only be
+// called by handlers of the client's commands, e.g. to send snapshots.
+func (c *Client) writeDirect(bufs ...[]byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if err := c.wr.Flush(); err != nil {
+		return err
+	}
+	for _, p := range bufs {
+		if _, err := c.conn().Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close will disconnect as soon as all pending replies have been written
 // to the client
 func (c *Client) Close() {
 	c.closed = true
 }
 
+// kill disconnects the client immediately, discarding pending replies
+func (c *Client) kill() {
+	if c.poll == nil {
+		_ = c.cn.Close()
+		return
+	}
+
+	// deregister before the fd can be reused, then wake
+	// parked clients to notice the closed connection
+	c.poll.remove(c)
+	_ = c.cn.Close()
+	c.poll.wake(c)
+}
+
+func (c *Client) markPubSub() {
+	atomic.StoreInt32(&c.pubsub, 1)
+}
+
+// resetState restores the connection state to its defaults
+func (c *Client) resetState() {
+	c.ctx = nil
+	atomic.StoreInt32(&c.pubsub, 0)
+	atomic.StoreInt32(&c.readWrite, 0)
+	atomic.StoreInt32(&c.noEvict, 0)
+	c.SetProtocol(resp.RESP2)
+	c.cluster = clusterClient{}
+
+	c.smu.Lock()
+	c.name = ""
+	c.smu.Unlock()
+}
+
+// validClientAttr reports whether s only contains the characters
+// '!' to '~', as required for names and library attributes
+func validClientAttr(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '!' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// clientType returns the type of the client, as accepted by CLIENT LIST
+// and CLIENT KILL, with "slave" normalised to "replica"
+func (c *Client) clientType() string {
+	if atomic.LoadInt32(&c.replica) == 1 {
+		return "replica"
+	}
+	if atomic.LoadInt32(&c.pubsub) == 1 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// begin marks the client as busy, frames written via WriteFrame are
+// queued until flush is called
+func (c *Client) begin() {
+	c.wmu.Lock()
+	c.busy = true
+	c.wmu.Unlock()
+}
+
+// flush writes pending replies, followed by queued frames
+func (c *Client) flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.busy = false
+	if err := c.wr.Flush(); err != nil {
+		return err
+	}
+
+	if len(c.pending) != 0 {
+		_, err := c.conn().Write(c.pending)
+		if cap(c.pending) > maxPendingRetain {
+			c.pending = nil
+		} else {
+			c.pending = c.pending[:0]
+		}
+		return err
+	}
+	return nil
+}
+
+// track records a processed command
+func (c *Client) track(cmd string) {
+	now := c.clock.Now()
+
+	c.smu.Lock()
+	c.accessed = now
+	c.lastCmd = cmd
+	c.commands++
+	c.smu.Unlock()
+}
+
 func (c *Client) readCmd(cmd *resp.Command) (*resp.Command, error) {
 	var err error
 	if cmd, err = c.rd.ReadCmd(cmd); err == nil {
-		cmd.SetContext(context.WithValue(cmd.Context(), ctxKeyClient{}, c))
+		cmd.SetContext(c.commandContext(cmd.Context()))
 	}
 	return cmd, err
 }
@@ -87,19 +529,25 @@ func (c *Client) readCmd(cmd *resp.Command) (*resp.Command, error) {
 func (c *Client) streamCmd(cmd *resp.CommandStream) (*resp.CommandStream, error) {
 	var err error
 	if cmd, err = c.rd.StreamCmd(cmd); err == nil {
-		cmd.SetContext(context.WithValue(cmd.Context(), ctxKeyClient{}, c))
+		cmd.SetContext(c.commandContext(cmd.Context()))
 	}
 	return cmd, err
 }
 
-func (c *Client) pipeline(fn func(string) error) error {
-	for more := true; more; more = c.rd.Buffered() != 0 {
+// pipeline performs buffered commands, up to Config.CommandBudget
+func (c *Client) pipeline(srv *Server) error {
+	budget := srv.conf().CommandBudget
+	for n, more := 0, true; more; more = c.rd.Buffered() != 0 {
+		if n++; budget > 0 && n > budget {
+			break
+		}
+
 		name, err := c.rd.PeekCmd()
 		if err != nil {
 			_ = c.rd.SkipCmd()
 			return err
 		}
-		if err := fn(name); err != nil {
+		if err := srv.perform(c, name); err != nil {
 			return err
 		}
 	}
@@ -107,30 +555,125 @@ func (c *Client) pipeline(fn func(string) error) error {
 }
 
 func (c *Client) release() {
+	if c.poll != nil {
+		c.poll.remove(c)
+	}
 	_ = c.cn.Close()
-	readerPool.Put(c.rd)
-	writerPool.Put(c.wr)
+	c.releaseBuffers()
 }
 
-func (c *Client) reset(cn net.Conn) {
+func (c *Client) reset(cn net.Conn, clock Clock, rdSize, wrSize int) {
+	now := clock.Now()
 	*c = Client{
-		id: atomic.AddUint64(&clientInc, 1),
-		cn: cn,
+		id:       atomic.AddUint64(&clientInc, 1),
+		cn:       cn,
+		clock:    clock,
+		rdSize:   rdSize,
+		wrSize:   wrSize,
+		created:  now,
+		accessed: now,
 	}
+	c.acquireBuffers()
+}
 
-	if v := readerPool.Get(); v != nil {
+// acquireBuffers fetches reader and writer from the pools
+func (c *Client) acquireBuffers() {
+	if v := readerPools.get(c.rdSize).Get(); v != nil {
 		rd := v.(*resp.RequestReader)
-		rd.Reset(cn)
+		rd.Reset(c.conn())
 		c.rd = rd
 	} else {
-		c.rd = resp.NewRequestReader(cn)
+		c.rd = resp.NewRequestReaderSize(c.conn(), c.rdSize)
 	}
+	c.rd.SetMaxBufferSize(c.rdMax)
 
-	if v := writerPool.Get(); v != nil {
+	if v := writerPools.get(c.wrSize).Get(); v != nil {
 		wr := v.(resp.ResponseWriter)
-		wr.Reset(cn)
+		wr.Reset(c.conn())
 		c.wr = wr
 	} else {
-		c.wr = resp.NewResponseWriter(cn)
+		c.wr = resp.NewResponseWriterSize(c.conn(), c.wrSize)
+	}
+	resp.SetProtocol(c.wr, c.Protocol())
+}
+
+// releaseBuffers returns reader and writer to the pools, buffers
+// of idle clients are released while they are parked
+func (c *Client) releaseBuffers() {
+	if c.rd != nil {
+		c.rd.Reset(nil) // shrinks grown buffers
+		readerPools.get(c.rdSize).Put(c.rd)
+		c.rd = nil
+	}
+	if c.wr != nil {
+		writerPools.get(c.wrSize).Put(c.wr)
+		c.wr = nil
+	}
+}
+
+// conn returns the connection, metered
+func (c *Client) conn() *clientConn { return (*clientConn)(c) }
+
+// clientConn counts the bytes transferred over the client connection
+type clientConn Client
+
+func (c *clientConn) Read(p []byte) (int, error) {
+	n, err := c.cn.Read(p)
+	atomic.AddInt64(&c.bytesRead, int64(n))
+	return n, err
+}
+
+func (c *clientConn) Write(p []byte) (int, error) {
+	n, err := c.cn.Write(p)
+	atomic.AddInt64(&c.bytesWritten, int64(n))
+	return n, err
+}
+
+// --------------------------------------------------------------------
+
+// sizedPool maintains a pool per buffer size, so
+// servers with different sizes can share them
+type sizedPool struct {
+	pools map[int]*sync.Pool
+	mu    sync.RWMutex
+}
+
+func (p *sizedPool) get(size int) *sync.Pool {
+	p.mu.RLock()
+	pool, ok := p.pools[size]
+	p.mu.RUnlock()
+	if ok {
+		return pool
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pool, ok = p.pools[size]; !ok {
+		if p.pools == nil {
+			p.pools = make(map[int]*sync.Pool)
+		}
+		pool = new(sync.Pool)
+		p.pools[size] = pool
+	}
+	return pool
+}
+
+// --------------------------------------------------------------------
+
+type frame struct {
+	buf bytes.Buffer
+	wr  resp.ResponseWriter
+}
+
+func fetchFrame() *frame {
+	if v := framePool.Get(); v != nil {
+		f := v.(*frame)
+		f.buf.Reset()
+		return f
 	}
+
+	f := new(frame)
+	f.wr = resp.NewResponseWriter(&f.buf)
+	return f
 }
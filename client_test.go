@@ -1,6 +1,9 @@
 package redeo
 
 import (
+	"strings"
+
+	"github.com/johntech-o/redeo/resp"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -12,4 +15,92 @@ var _ = Describe("Client", func() {
 		Expect(b.ID() - 1).To(Equal(a.ID()))
 	})
 
+	It("should pool buffers by size", func() {
+		// sync.Pool may drop items at random (e.g. with -race), so
+		// only check that pools are selected by size
+		Expect(readerPools.get(1024)).To(BeIdenticalTo(readerPools.get(1024)))
+		Expect(readerPools.get(1024)).NotTo(BeIdenticalTo(readerPools.get(4096)))
+		Expect(writerPools.get(2048)).To(BeIdenticalTo(writerPools.get(2048)))
+
+		c := newClientSize(&mockConn{}, SystemClock, 1024, 2048)
+		c.release()
+		Expect(c.rd).To(BeNil())
+		Expect(c.wr).To(BeNil())
+	})
+
+	It("should write frames", func() {
+		cn := &mockConn{}
+		c := newClient(cn)
+
+		Expect(c.WriteFrame(func(w resp.ResponseWriter) {
+			w.AppendArrayLen(2)
+			w.AppendBulkString("message")
+			w.AppendInt(1)
+		})).To(Succeed())
+		Expect(cn.String()).To(Equal("*2\r\n$7\r\nmessage\r\n:1\r\n"))
+	})
+
+	It("should queue frames while busy", func() {
+		cn := &mockConn{}
+		c := newClient(cn)
+
+		c.begin()
+		c.wr.AppendArrayLen(2)
+		Expect(c.WriteFrame(func(w resp.ResponseWriter) { w.AppendInt(3) })).To(Succeed())
+		c.wr.AppendInt(1)
+		c.wr.AppendInt(2)
+		Expect(cn.String()).To(BeEmpty())
+
+		Expect(c.flush()).To(Succeed())
+		Expect(cn.String()).To(Equal("*2\r\n:1\r\n:2\r\n:3\r\n"))
+
+		Expect(c.WriteFrame(func(w resp.ResponseWriter) { w.AppendInt(4) })).To(Succeed())
+		Expect(cn.String()).To(Equal("*2\r\n:1\r\n:2\r\n:3\r\n:4\r\n"))
+	})
+
+	It("should disconnect clients with too many queued frames", func() {
+		cn := &mockConn{}
+		c := newClient(cn)
+		large := strings.Repeat("x", 1024*1024)
+
+		c.begin()
+		for i := 0; i < 7; i++ {
+			Expect(c.WriteFrame(func(w resp.ResponseWriter) { w.AppendBulkString(large) })).To(Succeed())
+		}
+		Expect(cn.closed).To(BeFalse())
+
+		Expect(c.WriteFrame(func(w resp.ResponseWriter) { w.AppendBulkString(large) })).To(Equal(ErrFrameQueueFull))
+		Expect(cn.closed).To(BeTrue())
+		Expect(c.pending).To(BeNil())
+	})
+
+	It("should not disconnect protected clients with many queued frames", func() {
+		cn := &mockConn{}
+		c := newClient(cn)
+		c.SetNoEvict(true)
+		large := strings.Repeat("x", 1024*1024)
+
+		c.begin()
+		for i := 0; i < 10; i++ {
+			Expect(c.WriteFrame(func(w resp.ResponseWriter) { w.AppendBulkString(large) })).To(Succeed())
+		}
+		Expect(cn.closed).To(BeFalse())
+	})
+
+	It("should release large queues after flush", func() {
+		cn := &mockConn{}
+		c := newClient(cn)
+
+		c.begin()
+		Expect(c.WriteFrame(func(w resp.ResponseWriter) { w.AppendBulkString(strings.Repeat("x", 100000)) })).To(Succeed())
+		Expect(c.flush()).To(Succeed())
+		Expect(cn.Len()).To(Equal(100011))
+		Expect(c.pending).To(BeNil())
+
+		c.begin()
+		Expect(c.WriteFrame(func(w resp.ResponseWriter) { w.AppendInt(1) })).To(Succeed())
+		Expect(c.flush()).To(Succeed())
+		Expect(cap(c.pending)).To(BeNumerically(">", 0))
+	})
+
 })
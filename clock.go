@@ -0,0 +1,63 @@
+package redeo
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time. Servers use it to track client
+// activity, uptime and command durations, so it can be replaced by a
+// MockClock in tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// SystemClock is the default Clock, backed by time.Now
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// MockClock is a Clock which only advances when told to, for
+// deterministic tests of time-based behaviour.
+type MockClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+// NewMockClock creates a new clock, starting at t.
+func NewMockClock(t time.Time) *MockClock {
+	return &MockClock{now: t}
+}
+
+// Now returns the current time of the clock.
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Add advances the clock by d.
+func (c *MockClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Set sets the clock to t.
+func (c *MockClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// clockOrDefault returns c, or SystemClock if c is nil
+func clockOrDefault(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
@@ -0,0 +1,45 @@
+package redeo
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MockClock", func() {
+	var subject *MockClock
+	var start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		subject = NewMockClock(start)
+	})
+
+	It("should advance on demand", func() {
+		Expect(subject.Now()).To(Equal(start))
+		subject.Add(time.Minute)
+		Expect(subject.Now()).To(Equal(start.Add(time.Minute)))
+		subject.Set(start)
+		Expect(subject.Now()).To(Equal(start))
+	})
+
+	It("should drive server uptime", func() {
+		srv := NewServer(&Config{Clock: subject})
+		Expect(srv.Info().Uptime()).To(Equal(time.Duration(0)))
+
+		subject.Add(49 * time.Hour)
+		Expect(srv.Info().Uptime()).To(Equal(49 * time.Hour))
+		Expect(srv.Info().Find("Server").String()).To(ContainSubstring("uptime_in_seconds:176400\nuptime_in_days:2\n"))
+	})
+
+	It("should drive client times", func() {
+		c := newClientSize(&mockConn{}, subject, 1024, 1024)
+		Expect(c.CreateTime()).To(Equal(start))
+
+		subject.Add(3 * time.Second)
+		c.track("get")
+		subject.Add(2 * time.Second)
+		Expect(newClientInfo(c).String()).To(ContainSubstring(" age=5 idle=2 cmd=get "))
+	})
+
+})
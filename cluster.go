@@ -0,0 +1,721 @@
+package redeo
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// ClusterDownError is returned for keys in slots which are not assigned
+// to any node
+const ClusterDownError = "CLUSTERDOWN Hash slot not served"
+
+// CrossSlotError is returned for commands with keys in different slots
+const CrossSlotError = "CROSSSLOT Keys in request don't hash to the same slot"
+
+// TryAgainError is returned for multi-key commands on slots which are
+// being migrated, while only some of their keys have been moved
+const TryAgainError = "TRYAGAIN Multiple keys request during rehashing of slot"
+
+var errInvalidSlot = errors.New("ERR Invalid or out of range slot")
+
+// KeysFunc returns the keys of a command, see Cluster.SetKeys
+type KeysFunc func(cmd *resp.Command) []string
+
+// KeyRange returns a KeysFunc for commands which accept keys at regular
+// positions, like the first key, last key and step of Redis' command
+// table. Positions are zero based argument indexes, negative last
+// positions count from the end, e.g. KeyRange(0, 0, 1) for GET,
+// KeyRange(0, -1, 1) for DEL and KeyRange(0, -1, 2) for MSET.
+func KeyRange(first, last, step int) KeysFunc {
+	if step < 1 {
+		step = 1
+	}
+	return func(cmd *resp.Command) []string {
+		n := cmd.ArgN()
+		end := last
+		if end < 0 {
+			end += n
+		}
+		if end >= n {
+			end = n - 1
+		}
+
+		var keys []string
+		for i := first; i <= end; i += step {
+			keys = append(keys, cmd.Arg(i).String())
+		}
+		return keys
+	}
+}
+
+// ClusterOptions configure a Cluster
+type ClusterOptions struct {
+	// ID is the node ID.
+	// Default: the run ID of the server
+	ID string
+
+	// Keyspace enumerates the local keys for CLUSTER GETKEYSINSLOT and
+	// CLUSTER COUNTKEYSINSLOT.
+	// Default: nil (no keys are reported)
+	Keyspace Scanner
+
+	// Topology provides the initial nodes and slot owners, see Load.
+	// Default: nil
+	Topology ClusterTopology
+
+	// Exists reports whether a key exists locally. Commands on keys
+	// which do not exist in slots that are migrating to another node
+	// are redirected to that node via ASK errors.
+	// Default: nil (keys are assumed to exist)
+	Exists func(key string) bool
+}
+
+// Cluster maintains the slot ownership table of a cluster node, which
+// can be resharded manually via CLUSTER SETSLOT, like in Redis cluster.
+// Once attached to a server, commands with keys registered via SetKeys
+// are only served if the slot of their keys is owned by this node,
+// otherwise clients are redirected via MOVED and ASK errors. Clients
+// redirected via ASK can send commands to importing nodes after ASKING.
+// All keys of a command, and of the commands sent between MULTI and
+// EXEC or DISCARD, must hash to the same slot, or commands are rejected
+// with CROSSSLOT errors. Commands applied via Server.Apply are never
+// redirected. Clusters implement ClusterTopology.
+type Cluster struct {
+	opt ClusterOptions
+
+	mu        sync.RWMutex
+	keys      map[string]KeysFunc
+	nodes     map[string]clusterNode
+	owners    [NumSlots]string
+	migrating map[uint16]string
+	importing map[uint16]string
+}
+
+type clusterNode struct {
+	addr    string
+	busPort int
+	master  string
+	epoch   int64
+	fail    bool
+}
+
+// NewCluster attaches a cluster slot table to srv, initially no slots
+// are assigned
+func NewCluster(srv *Server, opt *ClusterOptions) *Cluster {
+	var o ClusterOptions
+	if opt != nil {
+		o = *opt
+	}
+	if o.ID == "" {
+		o.ID = srv.RunID()
+	}
+
+	cl := &Cluster{
+		opt:       o,
+		keys:      make(map[string]KeysFunc),
+		nodes:     make(map[string]clusterNode),
+		migrating: make(map[uint16]string),
+		importing: make(map[uint16]string),
+	}
+	if o.Topology != nil {
+		cl.Load(o.Topology)
+	}
+
+	srv.mu.Lock()
+	srv.cluster = cl
+	srv.mu.Unlock()
+	return cl
+}
+
+// ID returns the node ID
+func (cl *Cluster) ID() string { return cl.opt.ID }
+
+// SetKeys registers the keys of a command, commands without keys are
+// always served locally
+func (cl *Cluster) SetKeys(name string, fn KeysFunc) {
+	cl.mu.Lock()
+	cl.keys[resp.CanonicalName(name)] = fn
+	cl.mu.Unlock()
+}
+
+// SetNode adds or updates a node, clients are redirected to addr
+func (cl *Cluster) SetNode(id, addr string) {
+	cl.mu.Lock()
+	n := cl.nodes[id]
+	n.addr = addr
+	cl.nodes[id] = n
+	cl.mu.Unlock()
+}
+
+// Load replaces the nodes and slot owners with those of a topology, e.g.
+// on startup or whenever it changes. Migrating and importing states of
+// slots are retained, unless their nodes were removed.
+func (cl *Cluster) Load(t ClusterTopology) {
+	nodes := t.ClusterNodes()
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.nodes = make(map[string]clusterNode, len(nodes))
+	cl.owners = [NumSlots]string{}
+	for _, n := range nodes {
+		cl.nodes[n.ID] = clusterNode{addr: n.Addr(), busPort: n.BusPort, master: n.Master, epoch: n.Epoch, fail: n.Fail}
+		for _, r := range n.Slots {
+			for slot := int(r.Start); slot <= int(r.End) && slot < NumSlots; slot++ {
+				cl.owners[slot] = n.ID
+			}
+		}
+	}
+	for slot, node := range cl.migrating {
+		if !cl.known(node) {
+			delete(cl.migrating, slot)
+		}
+	}
+	for slot, node := range cl.importing {
+		if !cl.known(node) {
+			delete(cl.importing, slot)
+		}
+	}
+}
+
+// ClusterNodes implements ClusterTopology, nodes are sorted by ID. The
+// address of this node is only reported if it was set via SetNode or
+// Load.
+func (cl *Cluster) ClusterNodes() []ClusterNode {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	byID := make(map[string]*ClusterNode, len(cl.nodes)+1)
+	byID[cl.opt.ID] = &ClusterNode{ID: cl.opt.ID}
+	for id, n := range cl.nodes {
+		node := &ClusterNode{ID: id, BusPort: n.busPort, Master: n.master, Epoch: n.epoch, Fail: n.fail}
+		if host, port, err := net.SplitHostPort(n.addr); err == nil {
+			node.Host = host
+			node.Port, _ = strconv.Atoi(port)
+		}
+		byID[id] = node
+	}
+
+	for slot := 0; slot < NumSlots; slot++ {
+		owner := cl.owners[slot]
+		n, ok := byID[owner]
+		if !ok {
+			continue
+		}
+		if last := len(n.Slots) - 1; last > -1 && int(n.Slots[last].End) == slot-1 {
+			n.Slots[last].End = uint16(slot)
+		} else {
+			n.Slots = append(n.Slots, SlotRange{Start: uint16(slot), End: uint16(slot)})
+		}
+	}
+
+	res := make([]ClusterNode, 0, len(byID))
+	for _, n := range byID {
+		res = append(res, *n)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// RemoveNode removes a node, its slots become unassigned
+func (cl *Cluster) RemoveNode(id string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	delete(cl.nodes, id)
+	for slot, owner := range cl.owners {
+		if owner == id {
+			cl.owners[slot] = ""
+		}
+	}
+	for slot, node := range cl.migrating {
+		if node == id {
+			delete(cl.migrating, slot)
+		}
+	}
+	for slot, node := range cl.importing {
+		if node == id {
+			delete(cl.importing, slot)
+		}
+	}
+}
+
+// SlotOwner returns the ID of the node which owns slot, or an empty
+// string if unassigned
+func (cl *Cluster) SlotOwner(slot uint16) string {
+	cl.mu.RLock()
+	owner := cl.owners[slot%NumSlots]
+	cl.mu.RUnlock()
+	return owner
+}
+
+// AddSlots assigns slots to this node, see CLUSTER ADDSLOTS
+func (cl *Cluster) AddSlots(slots ...uint16) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if err := cl.checkSlots(slots, func(owner string) bool { return owner != "" }, "is already busy"); err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		cl.owners[slot] = cl.opt.ID
+		delete(cl.importing, slot)
+	}
+	return nil
+}
+
+// DelSlots unassigns slots, see CLUSTER DELSLOTS
+func (cl *Cluster) DelSlots(slots ...uint16) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if err := cl.checkSlots(slots, func(owner string) bool { return owner == "" }, "is already unassigned"); err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		cl.owners[slot] = ""
+		delete(cl.migrating, slot)
+		delete(cl.importing, slot)
+	}
+	return nil
+}
+
+func (cl *Cluster) checkSlots(slots []uint16, invalid func(owner string) bool, msg string) error {
+	seen := make(map[uint16]struct{}, len(slots))
+	for _, slot := range slots {
+		if slot >= NumSlots {
+			return errInvalidSlot
+		}
+		if invalid(cl.owners[slot]) {
+			return errors.New("ERR Slot " + strconv.Itoa(int(slot)) + " " + msg)
+		}
+		if _, ok := seen[slot]; ok {
+			return errors.New("ERR Slot " + strconv.Itoa(int(slot)) + " specified multiple times")
+		}
+		seen[slot] = struct{}{}
+	}
+	return nil
+}
+
+// SetSlotMigrating marks a slot owned by this node as migrating to
+// another node, see CLUSTER SETSLOT MIGRATING
+func (cl *Cluster) SetSlotMigrating(slot uint16, id string) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if slot >= NumSlots {
+		return errInvalidSlot
+	}
+	if cl.owners[slot] != cl.opt.ID {
+		return errors.New("ERR I'm not the owner of hash slot " + strconv.Itoa(int(slot)))
+	}
+	if !cl.known(id) {
+		return errors.New("ERR I don't know about node " + id)
+	}
+	cl.migrating[slot] = id
+	return nil
+}
+
+// SetSlotImporting marks a slot as importing from another node, see
+// CLUSTER SETSLOT IMPORTING
+func (cl *Cluster) SetSlotImporting(slot uint16, id string) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if slot >= NumSlots {
+		return errInvalidSlot
+	}
+	if cl.owners[slot] == cl.opt.ID {
+		return errors.New("ERR I'm already the owner of hash slot " + strconv.Itoa(int(slot)))
+	}
+	if !cl.known(id) {
+		return errors.New("ERR I don't know about node " + id)
+	}
+	cl.importing[slot] = id
+	return nil
+}
+
+// SetSlotStable clears the migrating and importing states of a slot,
+// see CLUSTER SETSLOT STABLE
+func (cl *Cluster) SetSlotStable(slot uint16) error {
+	if slot >= NumSlots {
+		return errInvalidSlot
+	}
+
+	cl.mu.Lock()
+	delete(cl.migrating, slot)
+	delete(cl.importing, slot)
+	cl.mu.Unlock()
+	return nil
+}
+
+// SetSlotNode assigns a slot to a node, see CLUSTER SETSLOT NODE. Slots
+// owned by this node can only be assigned to other nodes once they hold
+// no more keys, which also ends their migration. Assigning an importing
+// slot to this node ends the import.
+func (cl *Cluster) SetSlotNode(slot uint16, id string) error {
+	if slot >= NumSlots {
+		return errInvalidSlot
+	}
+	empty := cl.countKeys(slot) == 0
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if !cl.known(id) {
+		return errors.New("ERR I don't know about node " + id)
+	}
+	if cl.owners[slot] == cl.opt.ID && id != cl.opt.ID && !empty {
+		return errors.New("ERR Can't assign hashslot " + strconv.Itoa(int(slot)) + " to a different node while I still hold keys for this hash slot.")
+	}
+	if empty {
+		delete(cl.migrating, slot)
+	}
+	if _, ok := cl.importing[slot]; ok && id == cl.opt.ID {
+		delete(cl.importing, slot)
+		cl.bumpEpoch()
+	}
+	cl.owners[slot] = id
+	return nil
+}
+
+// bumpEpoch assigns a configuration epoch greater than those of all other
+// nodes to this node, must be called with the lock held
+func (cl *Cluster) bumpEpoch() {
+	var max int64
+	for _, n := range cl.nodes {
+		if n.epoch > max {
+			max = n.epoch
+		}
+	}
+	self := cl.nodes[cl.opt.ID]
+	self.epoch = max + 1
+	cl.nodes[cl.opt.ID] = self
+}
+
+func (cl *Cluster) migrations() (migrating, importing map[uint16]string) {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	migrating = make(map[uint16]string, len(cl.migrating))
+	for slot, id := range cl.migrating {
+		migrating[slot] = id
+	}
+	importing = make(map[uint16]string, len(cl.importing))
+	for slot, id := range cl.importing {
+		importing[slot] = id
+	}
+	return
+}
+
+// known reports whether a node is known, must be called with the lock
+// held
+func (cl *Cluster) known(id string) bool {
+	if id == cl.opt.ID {
+		return true
+	}
+	_, ok := cl.nodes[id]
+	return ok
+}
+
+// KeysInSlot returns up to count local keys of a slot
+func (cl *Cluster) KeysInSlot(slot uint16, count int) []string {
+	keys := []string{}
+	cl.scanSlot(slot, func(key string) bool {
+		if len(keys) >= count {
+			return false
+		}
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (cl *Cluster) countKeys(slot uint16) int {
+	var n int
+	cl.scanSlot(slot, func(string) bool { n++; return true })
+	return n
+}
+
+// scanSlot iterates over the local keys of a slot until fn returns false
+func (cl *Cluster) scanSlot(slot uint16, fn func(key string) bool) {
+	if cl.opt.Keyspace == nil {
+		return
+	}
+
+	var cursor uint64
+	more := true
+	for more {
+		cursor = cl.opt.Keyspace.Scan(cursor, DefaultScanCount, func(key string, _ ...string) {
+			if more && KeySlot(key) == slot {
+				more = fn(key)
+			}
+		})
+		if cursor == 0 {
+			return
+		}
+	}
+}
+
+// clusterClient is the cluster state of a client, it is only accessed
+// while serving its commands
+type clusterClient struct {
+	asking bool
+	multi  bool
+	slot   int // slot of the keys of the current transaction, -1 if none
+}
+
+// begin updates the state of a client before serving the command name
+// and reports whether it was preceded by ASKING. Like in Redis, ASKING
+// only applies to the next command, or to the transaction it starts.
+func (cl *Cluster) begin(st *clusterClient, name string) bool {
+	asking := st.asking
+	switch name {
+	case "multi":
+		if !st.multi {
+			st.multi, st.slot = true, -1
+		}
+	case "exec", "discard":
+		st.multi = false
+	}
+	if !st.multi {
+		st.asking = false
+	}
+	return asking
+}
+
+// redirect returns the error to redirect cmd with, or an empty string if
+// it is served locally. All keys of a command, and of the commands of a
+// transaction, must hash to the same slot.
+func (cl *Cluster) redirect(st *clusterClient, asking bool, name string, cmd *resp.Command) string {
+	cl.mu.RLock()
+	fn, ok := cl.keys[name]
+	cl.mu.RUnlock()
+	if !ok {
+		return ""
+	}
+
+	keys := fn(cmd)
+	if len(keys) == 0 {
+		return ""
+	}
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return CrossSlotError
+		}
+	}
+	if st.multi && st.slot != -1 && st.slot != int(slot) {
+		return CrossSlotError
+	}
+
+	cl.mu.RLock()
+	owner := cl.owners[slot]
+	target, migrating := cl.migrating[slot]
+	_, importing := cl.importing[slot]
+	addr, askAddr := cl.nodes[owner].addr, cl.nodes[target].addr
+	cl.mu.RUnlock()
+
+	var missing int
+	if (migrating || importing) && cl.opt.Exists != nil {
+		for _, key := range keys {
+			if !cl.opt.Exists(key) {
+				missing++
+			}
+		}
+	}
+
+	switch {
+	case owner == "":
+		return ClusterDownError
+	case owner == cl.opt.ID && migrating && missing == len(keys):
+		return "ASK " + strconv.Itoa(int(slot)) + " " + askAddr
+	case owner == cl.opt.ID && migrating && missing != 0:
+		return TryAgainError
+	case owner != cl.opt.ID && importing && asking:
+		if len(keys) > 1 && missing != 0 {
+			return TryAgainError
+		}
+	case owner != cl.opt.ID:
+		return "MOVED " + strconv.Itoa(int(slot)) + " " + addr
+	}
+
+	if st.multi {
+		st.slot = int(slot)
+	}
+	return ""
+}
+
+// Asking returns an ASKING handler, which allows the calling client to
+// send its next command, or the transaction it starts, to a node which
+// imports the slot of its keys. It is sent by clients redirected via ASK
+// errors.
+// https://redis.io/commands/asking
+func (cl *Cluster) Asking() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		if client := GetClient(c.Context()); client != nil {
+			client.cluster.asking = true
+		}
+		w.AppendOK()
+	})
+}
+
+// Handler returns a CLUSTER handler, which supports the SLOTS, NODES,
+// MYID, ADDSLOTS, DELSLOTS, SETSLOT, GETKEYSINSLOT, COUNTKEYSINSLOT and
+// KEYSLOT sub-commands. Further sub-commands can be added to the result, e.g.:
+//
+//   h := cluster.Handler()
+//   h["failover"] = failover.ClusterFailover()
+//   srv.Handle("cluster", h)
+//
+// https://redis.io/commands/cluster-setslot
+func (cl *Cluster) Handler() SubCommands {
+	return SubCommands{
+		"slots":           ClusterSlots(cl),
+		"nodes":           ClusterNodes(cl, cl.opt.ID),
+		"myid":            ClusterMyID(cl.opt.ID),
+		"addslots":        HandlerFunc(cl.serveAddSlots),
+		"delslots":        HandlerFunc(cl.serveDelSlots),
+		"setslot":         HandlerFunc(cl.serveSetSlot),
+		"getkeysinslot":   HandlerFunc(cl.serveGetKeysInSlot),
+		"countkeysinslot": HandlerFunc(cl.serveCountKeysInSlot),
+		"keyslot":         HandlerFunc(cl.serveKeySlot),
+	}
+}
+
+func (cl *Cluster) serveAddSlots(w resp.ResponseWriter, c *resp.Command) {
+	cl.serveSlots(w, c, cl.AddSlots)
+}
+
+func (cl *Cluster) serveDelSlots(w resp.ResponseWriter, c *resp.Command) {
+	cl.serveSlots(w, c, cl.DelSlots)
+}
+
+func (cl *Cluster) serveSlots(w resp.ResponseWriter, c *resp.Command, fn func(...uint16) error) {
+	if c.ArgN() == 0 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	slots := make([]uint16, 0, c.ArgN())
+	for _, arg := range c.Args {
+		slot, err := parseSlot(arg.String())
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		slots = append(slots, slot)
+	}
+
+	if err := fn(slots...); err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendOK()
+}
+
+func (cl *Cluster) serveSetSlot(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	slot, err := parseSlot(c.Arg(0).String())
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+
+	switch action := strings.ToLower(c.Arg(1).String()); {
+	case action == "migrating" && c.ArgN() == 3:
+		err = cl.SetSlotMigrating(slot, c.Arg(2).String())
+	case action == "importing" && c.ArgN() == 3:
+		err = cl.SetSlotImporting(slot, c.Arg(2).String())
+	case action == "stable" && c.ArgN() == 2:
+		err = cl.SetSlotStable(slot)
+	case action == "node" && c.ArgN() == 3:
+		err = cl.SetSlotNode(slot, c.Arg(2).String())
+	default:
+		w.AppendError("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+		return
+	}
+
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendOK()
+}
+
+func (cl *Cluster) serveGetKeysInSlot(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	slot, err := strconv.Atoi(c.Arg(0).String())
+	if err != nil {
+		w.AppendError("ERR value is not an integer or out of range")
+		return
+	}
+	count, err := strconv.Atoi(c.Arg(1).String())
+	if err != nil {
+		w.AppendError("ERR value is not an integer or out of range")
+		return
+	}
+	if slot < 0 || slot >= NumSlots || count < 0 {
+		w.AppendError("ERR Invalid slot or number of keys")
+		return
+	}
+
+	keys := cl.KeysInSlot(uint16(slot), count)
+	w.AppendArrayLen(len(keys))
+	for _, key := range keys {
+		w.AppendBulkString(key)
+	}
+}
+
+func (cl *Cluster) serveCountKeysInSlot(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	slot, err := strconv.Atoi(c.Arg(0).String())
+	if err != nil {
+		w.AppendError("ERR value is not an integer or out of range")
+		return
+	}
+	if slot < 0 || slot >= NumSlots {
+		w.AppendError("ERR Invalid slot")
+		return
+	}
+	w.AppendInt(int64(cl.countKeys(uint16(slot))))
+}
+
+func (cl *Cluster) serveKeySlot(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+	w.AppendInt(int64(KeySlot(c.Arg(0).String())))
+}
+
+func parseSlot(s string) (uint16, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= NumSlots {
+		return 0, errInvalidSlot
+	}
+	return uint16(n), nil
+}
@@ -0,0 +1,236 @@
+package redeo
+
+import (
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cluster", func() {
+	var srv *Server
+	var subject *Cluster
+	var data map[string]string
+
+	var call = func(args ...string) interface{} {
+		cmd := resp.NewCommand("CLUSTER")
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		w := redeotest.NewRecorder()
+		subject.Handler().ServeRedeo(w, cmd)
+		v, _ := w.Response()
+		return v
+	}
+
+	BeforeEach(func() {
+		data = map[string]string{"a": "1", "b": "2", "{a}x": "3"}
+		srv = NewServer(nil)
+		srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(data[c.Arg(0).String()])
+		})
+		srv.HandleFunc("mget", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendArrayLen(c.ArgN())
+			for _, arg := range c.Args {
+				w.AppendBulkString(data[arg.String()])
+			}
+		})
+		srv.HandleFunc("ping", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendInlineString("PONG")
+		})
+		for _, name := range []string{"multi", "exec", "discard"} {
+			srv.HandleFunc(name, func(w resp.ResponseWriter, c *resp.Command) {
+				w.AppendOK()
+			})
+		}
+
+		subject = NewCluster(srv, &ClusterOptions{
+			ID: "me",
+			Keyspace: ScannerFunc(func(cursor uint64, count int, fn func(string, ...string)) uint64 {
+				for key := range data {
+					fn(key)
+				}
+				return 0
+			}),
+			Exists: func(key string) bool {
+				_, ok := data[key]
+				return ok
+			},
+		})
+		subject.SetKeys("get", KeyRange(0, 0, 1))
+		subject.SetKeys("mget", KeyRange(0, -1, 1))
+		subject.SetNode("other", "10.0.0.2:6379")
+		srv.Handle("cluster", subject.Handler())
+		srv.Handle("asking", subject.Asking())
+	})
+
+	It("should assign slots", func() {
+		Expect(call("addslots", "15495", "3300")).To(Equal("OK"))
+		Expect(subject.SlotOwner(15495)).To(Equal("me"))
+		Expect(subject.SlotOwner(1)).To(Equal(""))
+
+		Expect(call("addslots", "1", "3300")).To(MatchError("ERR Slot 3300 is already busy"))
+		Expect(call("addslots", "1", "1")).To(MatchError("ERR Slot 1 specified multiple times"))
+		Expect(call("addslots", "16384")).To(MatchError("ERR Invalid or out of range slot"))
+		Expect(call("addslots")).To(MatchError("ERR wrong number of arguments for 'CLUSTER addslots' command"))
+		Expect(subject.SlotOwner(1)).To(Equal(""))
+
+		Expect(call("delslots", "3300")).To(Equal("OK"))
+		Expect(call("delslots", "3300")).To(MatchError("ERR Slot 3300 is already unassigned"))
+		Expect(subject.SlotOwner(3300)).To(Equal(""))
+	})
+
+	It("should list keys in slots", func() {
+		Expect(call("keyslot", "{a}x")).To(Equal(int64(15495)))
+		Expect(call("countkeysinslot", "15495")).To(Equal(int64(2)))
+		Expect(call("getkeysinslot", "15495", "1")).To(HaveLen(1))
+		Expect(call("getkeysinslot", "15495", "5")).To(ConsistOf("a", "{a}x"))
+		Expect(call("getkeysinslot", "1", "5")).To(Equal([]interface{}{}))
+		Expect(call("getkeysinslot", "1", "-1")).To(MatchError("ERR Invalid slot or number of keys"))
+		Expect(call("countkeysinslot", "16384")).To(MatchError("ERR Invalid slot"))
+	})
+
+	It("should redirect commands", func() {
+		Expect(redeotest.Transcript(srv, "GET a\r\n", "PING\r\n")).To(Equal([]byte("-CLUSTERDOWN Hash slot not served\r\n+PONG\r\n")))
+
+		Expect(call("addslots", "15495")).To(Equal("OK"))
+		Expect(call("setslot", "3300", "node", "other")).To(Equal("OK"))
+		Expect(redeotest.Transcript(srv, "GET a\r\n", "GET b\r\n")).To(Equal([]byte("$1\r\n1\r\n-MOVED 3300 10.0.0.2:6379\r\n")))
+
+		// missing keys of migrating slots are redirected via ASK
+		Expect(call("setslot", "15495", "migrating", "other")).To(Equal("OK"))
+		Expect(redeotest.Transcript(srv, "GET a\r\n", "GET {a}y\r\n")).To(Equal([]byte("$1\r\n1\r\n-ASK 15495 10.0.0.2:6379\r\n")))
+
+		Expect(call("setslot", "15495", "stable")).To(Equal("OK"))
+		Expect(redeotest.Transcript(srv, "GET {a}y\r\n")).To(Equal([]byte("$0\r\n\r\n")))
+
+		// commands applied directly are never redirected
+		Expect(srv.Apply(redeotest.NewCommand("GET", "b"))).To(Succeed())
+	})
+
+	It("should reshard slots", func() {
+		Expect(call("setslot", "3300", "importing", "unknown")).To(MatchError("ERR I don't know about node unknown"))
+		Expect(call("setslot", "3300", "importing", "other")).To(Equal("OK"))
+		Expect(call("setslot", "3300", "migrating", "other")).To(MatchError("ERR I'm not the owner of hash slot 3300"))
+		Expect(call("setslot", "3300", "node", "me")).To(Equal("OK"))
+		Expect(call("setslot", "3300", "importing", "other")).To(MatchError("ERR I'm already the owner of hash slot 3300"))
+		Expect(subject.SlotOwner(3300)).To(Equal("me"))
+
+		Expect(call("setslot", "3300", "migrating", "other")).To(Equal("OK"))
+		Expect(call("setslot", "3300", "node", "other")).To(MatchError("ERR Can't assign hashslot 3300 to a different node while I still hold keys for this hash slot."))
+
+		delete(data, "b")
+		Expect(call("setslot", "3300", "node", "other")).To(Equal("OK"))
+		Expect(subject.SlotOwner(3300)).To(Equal("other"))
+		Expect(redeotest.Transcript(srv, "GET b\r\n")).To(Equal([]byte("-MOVED 3300 10.0.0.2:6379\r\n")))
+
+		Expect(call("setslot", "3300", "bad")).To(MatchError("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP"))
+		Expect(call("setslot", "x", "stable")).To(MatchError("ERR Invalid or out of range slot"))
+	})
+
+	It("should serve importing slots after ASKING", func() {
+		Expect(call("setslot", "15495", "node", "other")).To(Equal("OK"))
+		Expect(call("setslot", "15495", "importing", "other")).To(Equal("OK"))
+		Expect(redeotest.Transcript(srv,
+			"GET a\r\n",
+			"ASKING\r\n",
+			"GET a\r\n",
+			"GET a\r\n",
+			"ASKING\r\n",
+			"MGET a {a}y\r\n",
+			"ASKING\r\n",
+			"MULTI\r\n",
+			"GET a\r\n",
+			"MGET a {a}x\r\n",
+			"EXEC\r\n",
+			"GET a\r\n",
+		)).To(Equal([]byte("-MOVED 15495 10.0.0.2:6379\r\n" +
+			"+OK\r\n$1\r\n1\r\n" +
+			"-MOVED 15495 10.0.0.2:6379\r\n" +
+			"+OK\r\n-TRYAGAIN Multiple keys request during rehashing of slot\r\n" +
+			"+OK\r\n+OK\r\n$1\r\n1\r\n*2\r\n$1\r\n1\r\n$1\r\n3\r\n+OK\r\n" +
+			"-MOVED 15495 10.0.0.2:6379\r\n")))
+	})
+
+	It("should reject cross-slot requests", func() {
+		Expect(call("addslots", "15495", "3300")).To(Equal("OK"))
+		Expect(redeotest.Transcript(srv,
+			"MGET a {a}x\r\n",
+			"MGET a b\r\n",
+			"MULTI\r\n",
+			"PING\r\n",
+			"GET a\r\n",
+			"GET b\r\n",
+			"MGET {a}x\r\n",
+			"DISCARD\r\n",
+			"GET b\r\n",
+		)).To(Equal([]byte("*2\r\n$1\r\n1\r\n$1\r\n3\r\n" +
+			"-CROSSSLOT Keys in request don't hash to the same slot\r\n" +
+			"+OK\r\n+PONG\r\n$1\r\n1\r\n" +
+			"-CROSSSLOT Keys in request don't hash to the same slot\r\n" +
+			"*1\r\n$1\r\n3\r\n+OK\r\n" +
+			"$1\r\n2\r\n")))
+	})
+
+	It("should count rejected commands of transactions", func() {
+		srv.HandleFunc("exec", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendInt(GetClient(c.Context()).Rejected())
+		})
+		Expect(call("addslots", "15495")).To(Equal("OK"))
+		Expect(call("setslot", "3300", "node", "other")).To(Equal("OK"))
+		Expect(redeotest.Transcript(srv,
+			"MULTI\r\n",
+			"GET b\r\n",
+			"GET a\r\n",
+			"GET {a}x\r\n",
+			"MGET a b\r\n",
+			"EXEC\r\n",
+		)).To(Equal([]byte("+OK\r\n" +
+			"-MOVED 3300 10.0.0.2:6379\r\n" +
+			"$1\r\n1\r\n$1\r\n3\r\n" +
+			"-CROSSSLOT Keys in request don't hash to the same slot\r\n" +
+			":2\r\n")))
+	})
+
+	It("should load topologies", func() {
+		subject.Load(StaticTopology{
+			{ID: "me", Host: "10.0.0.1", Port: 6379, Slots: []SlotRange{{Start: 0, End: 8191}}},
+			{ID: "peer", Host: "10.0.0.3", Port: 6379, Slots: []SlotRange{{Start: 8192, End: 16383}}},
+			{ID: "replica", Host: "10.0.0.4", Port: 6379, Master: "me"},
+		})
+		Expect(subject.SlotOwner(3300)).To(Equal("me"))
+		Expect(subject.SlotOwner(15495)).To(Equal("peer"))
+		Expect(call("setslot", "3300", "migrating", "other")).To(MatchError("ERR I don't know about node other"))
+		Expect(redeotest.Transcript(srv, "GET a\r\n", "GET b\r\n")).To(Equal([]byte("-MOVED 15495 10.0.0.3:6379\r\n$1\r\n2\r\n")))
+
+		Expect(call("addslots", "8192")).To(MatchError("ERR Slot 8192 is already busy"))
+		Expect(call("setslot", "8191", "node", "peer")).To(Equal("OK"))
+		Expect(subject.ClusterNodes()).To(Equal([]ClusterNode{
+			{ID: "me", Host: "10.0.0.1", Port: 6379, Slots: []SlotRange{{Start: 0, End: 8190}}},
+			{ID: "peer", Host: "10.0.0.3", Port: 6379, Slots: []SlotRange{{Start: 8191, End: 16383}}},
+			{ID: "replica", Host: "10.0.0.4", Port: 6379, Master: "me"},
+		}))
+		Expect(call("slots")).To(HaveLen(2))
+	})
+
+	It("should describe nodes", func() {
+		subject.SetNode("me", "10.0.0.1:6379")
+		Expect(call("addslots", "0", "1", "2", "3300")).To(Equal("OK"))
+		Expect(call("setslot", "3300", "migrating", "other")).To(Equal("OK"))
+		Expect(call("setslot", "15495", "importing", "other")).To(Equal("OK"))
+		Expect(call("nodes")).To(Equal("" +
+			"me 10.0.0.1:6379@16379 myself,master - 0 0 0 connected 0-2 3300 [3300->-other] [15495-<-other]\n" +
+			"other 10.0.0.2:6379@16379 master - 0 0 0 connected\n"))
+		Expect(call("myid")).To(Equal("me"))
+
+		// servers default to their own node IDs
+		other := NewServer(nil)
+		Expect(NewCluster(other, nil).ID()).To(Equal(other.RunID()))
+		Expect(other.RunID()).NotTo(Equal(srv.RunID()))
+
+		// taking over imported slots bumps the epoch
+		Expect(call("setslot", "15495", "node", "me")).To(Equal("OK"))
+		Expect(call("nodes")).To(HavePrefix("me 10.0.0.1:6379@16379 myself,master - 0 0 1 connected 0-2 3300 15495 [3300->-other]\n"))
+	})
+
+})
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/johntech-o/redeo/redeobench"
+)
+
+var flags struct {
+	addr  string
+	tests string
+	opt   redeobench.Options
+}
+
+func init() {
+	flag.StringVar(&flags.addr, "addr", "", "The TCP address of the server, starts an in-memory server if blank")
+	flag.StringVar(&flags.tests, "t", "ping,set,get", "Comma-separated list of tests to run")
+	flag.IntVar(&flags.opt.Clients, "c", 50, "Number of parallel connections")
+	flag.IntVar(&flags.opt.Requests, "n", 100000, "Total number of requests")
+	flag.IntVar(&flags.opt.Pipeline, "P", 1, "Pipeline <numreq> requests")
+	flag.IntVar(&flags.opt.DataSize, "d", 3, "Data size of SET values in bytes")
+	flag.IntVar(&flags.opt.KeySpace, "r", 0, "Use random keys for SET/GET in the specified range")
+}
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run() error {
+	workloads, err := redeobench.ParseWorkloads(flags.tests)
+	if err != nil {
+		return err
+	}
+
+	addr := flags.addr
+	if addr == "" {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer lis.Close()
+
+		go redeobench.NewServer().Serve(lis)
+		addr = lis.Addr().String()
+	}
+
+	for _, wl := range workloads {
+		res, err := redeobench.Run(addr, wl, &flags.opt)
+		if err != nil {
+			return err
+		}
+		fmt.Println(res)
+	}
+	return nil
+}
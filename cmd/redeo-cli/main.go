@@ -0,0 +1,211 @@
+// Command redeo-cli is a simple interactive client, similar to
+// redis-cli. Commands are read line by line, arguments may be quoted.
+// When arguments are passed on the command line, they are executed as a
+// single command instead.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/johntech-o/redeo/client"
+	"github.com/johntech-o/redeo/resp"
+)
+
+var flags struct {
+	addr string
+}
+
+func init() {
+	flag.StringVar(&flags.addr, "addr", "127.0.0.1:6379", "The TCP address of the server")
+}
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run() error {
+	cn, err := net.Dial("tcp", flags.addr)
+	if err != nil {
+		return err
+	}
+
+	conn := client.Wrap(cn)
+	defer conn.Close()
+
+	if flag.NArg() != 0 {
+		return execute(conn, flag.Args())
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print(flags.addr + "> ")
+		if !in.Scan() {
+			fmt.Println()
+			return in.Err()
+		}
+
+		args, err := splitArgs(in.Text())
+		if err != nil {
+			fmt.Println("(error) " + err.Error())
+			continue
+		} else if len(args) == 0 {
+			continue
+		}
+
+		if strings.EqualFold(args[0], "quit") || strings.EqualFold(args[0], "exit") {
+			return nil
+		}
+		if err := execute(conn, args); err != nil {
+			return err
+		}
+	}
+}
+
+// execute sends a command and prints the reply, subscriptions print
+// messages until the connection is closed
+func execute(conn client.Conn, args []string) error {
+	conn.WriteCmdString(args[0], args[1:]...)
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(strings.ToLower(args[0]), "subscribe") {
+		return printReply(conn, "")
+	}
+	for {
+		if err := printReply(conn, ""); err != nil {
+			return err
+		}
+	}
+}
+
+// printReply prints a reply in the format of redis-cli
+func printReply(r resp.ResponseParser, indent string) error {
+	typ, err := r.PeekType()
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case resp.TypeInline:
+		s, err := r.ReadInlineString()
+		if err != nil {
+			return err
+		}
+		fmt.Println(s)
+	case resp.TypeError:
+		s, err := r.ReadError()
+		if err != nil {
+			return err
+		}
+		fmt.Println("(error) " + s)
+	case resp.TypeInt:
+		n, err := r.ReadInt()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("(integer) %d\n", n)
+	case resp.TypeNil:
+		if err := r.ReadNil(); err != nil {
+			return err
+		}
+		fmt.Println("(nil)")
+	case resp.TypeBulk:
+		s, err := r.ReadBulkString()
+		if err != nil {
+			return err
+		}
+		fmt.Println(strconv.Quote(s))
+	case resp.TypeArray:
+		n, err := r.ReadArrayLen()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			fmt.Println("(empty array)")
+			return nil
+		}
+
+		width := len(strconv.Itoa(n))
+		for i := 0; i < n; i++ {
+			prefix := fmt.Sprintf("%*d) ", width, i+1)
+			if i != 0 {
+				fmt.Print(indent)
+			}
+			fmt.Print(prefix)
+			if err := printReply(r, indent+strings.Repeat(" ", len(prefix))); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected reply type %v", typ)
+	}
+	return nil
+}
+
+var errUnbalancedQuotes = errors.New("invalid argument(s)")
+
+// splitArgs splits a line into arguments, like redis-cli. Arguments
+// may be enclosed in double quotes, supporting backslash escapes, or
+// in single quotes.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	rd := strings.NewReader(line)
+	for {
+		c, _, err := rd.ReadRune()
+		if err == io.EOF {
+			return args, nil
+		}
+		if c == ' ' || c == '\t' {
+			continue
+		}
+
+		var arg strings.Builder
+		switch c {
+		case '"', '\'':
+			quote := c
+			for {
+				c, _, err = rd.ReadRune()
+				if err == io.EOF {
+					return nil, errUnbalancedQuotes
+				}
+				if c == quote {
+					break
+				}
+				if c == '\\' && quote == '"' {
+					if c, _, err = rd.ReadRune(); err == io.EOF {
+						return nil, errUnbalancedQuotes
+					}
+					switch c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					}
+				}
+				arg.WriteRune(c)
+			}
+		default:
+			for err != io.EOF && c != ' ' && c != '\t' {
+				arg.WriteRune(c)
+				c, _, err = rd.ReadRune()
+			}
+		}
+		args = append(args, arg.String())
+	}
+}
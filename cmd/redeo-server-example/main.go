@@ -30,6 +30,7 @@ func run() error {
 	srv.Handle("ping", redeo.Ping())
 	srv.Handle("echo", redeo.Echo())
 	srv.Handle("info", redeo.Info(srv))
+	srv.Handle("role", redeo.Role(srv))
 	srv.Handle("publish", broker.Publish())
 	srv.Handle("subscribe", broker.Subscribe())
 
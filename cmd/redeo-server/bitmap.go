@@ -0,0 +1,340 @@
+package main
+
+import (
+	"math/bits"
+	"strings"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Bitmaps are strings, addressed bit by bit, with the most significant
+// bit of the first byte at offset 0.
+
+// Error replies of bitmap commands
+const (
+	errBitOffset = "ERR bit offset is not an integer or out of range"
+	errBitValue  = "ERR bit is not an integer or out of range"
+	errBitArg    = "ERR The bit argument must be 1 or 0."
+)
+
+// parseBit parses a bit value, 0 or 1
+func parseBit(arg resp.CommandArgument) (byte, bool) {
+	switch string(arg) {
+	case "0":
+		return 0, true
+	case "1":
+		return 1, true
+	}
+	return 0, false
+}
+
+// getBit returns the bit at offset, zero beyond the end
+func getBit(p []byte, offset int64) byte {
+	if i := offset >> 3; i < int64(len(p)) {
+		return p[i] >> (7 - uint(offset&7)) & 1
+	}
+	return 0
+}
+
+func (s *store) setbit(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	offset, err := c.Arg(1).Int()
+	if err != nil || offset < 0 || offset>>3 >= maxStringSize {
+		w.AppendError(errBitOffset)
+		return
+	}
+	bit, ok := parseBit(c.Arg(2))
+	if !ok {
+		w.AppendError(errBitValue)
+		return
+	}
+
+	var old byte
+	if !s.modifyTyped(w, c.Arg(0).String(), "string", func(cur *entry) (*entry, bool) {
+		var val []byte
+		if cur != nil {
+			val = cur.val
+		}
+
+		n := len(val)
+		if i := int(offset>>3) + 1; i > n {
+			n = i
+		}
+		next := make([]byte, n)
+		copy(next, val)
+
+		old = getBit(val, offset)
+		mask := byte(1) << (7 - uint(offset&7))
+		if bit == 1 {
+			next[offset>>3] |= mask
+		} else {
+			next[offset>>3] &^= mask
+		}
+		return cur.replace(next), true
+	}) {
+		return
+	}
+	w.AppendInt(int64(old))
+}
+
+func (s *store) getbit(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	offset, err := c.Arg(1).Int()
+	if err != nil || offset < 0 || offset>>3 >= maxStringSize {
+		w.AppendError(errBitOffset)
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "string", func(e *entry) {
+		if e == nil {
+			w.AppendInt(0)
+			return
+		}
+		w.AppendInt(int64(getBit(e.val, offset)))
+	})
+}
+
+// parseBitRange parses "start end [BYTE|BIT]" and returns the inclusive
+// bit range over p, or false if it is empty. An error reply is returned
+// on failure. If end is optional and missing, the range ends at the end
+// of p.
+func parseBitRange(args []resp.CommandArgument, p []byte) (start, end int64, ok bool, msg string) {
+	if len(args) > 3 {
+		return 0, 0, false, "ERR syntax error"
+	}
+
+	end = -1
+	var err error
+	if len(args) > 0 {
+		if start, err = args[0].Int(); err != nil {
+			return 0, 0, false, errNotInteger
+		}
+	}
+	if len(args) > 1 {
+		if end, err = args[1].Int(); err != nil {
+			return 0, 0, false, errNotInteger
+		}
+	}
+
+	unit := int64(8)
+	if len(args) > 2 {
+		switch strings.ToLower(args[2].String()) {
+		case "byte":
+		case "bit":
+			unit = 1
+		default:
+			return 0, 0, false, "ERR syntax error"
+		}
+	}
+
+	size := int64(len(p)) * 8 / unit
+	if start, end, ok = normRange(start, end, size); !ok {
+		return 0, 0, false, ""
+	}
+	if unit == 8 {
+		start, end = start*8, end*8+7
+	}
+	return start, end, true, ""
+}
+
+// bitcount implements BITCOUNT key [start end [BYTE|BIT]]
+// https://redis.io/commands/bitcount
+func (s *store) bitcount(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	if c.ArgN() == 2 {
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "string", func(e *entry) {
+		var val []byte
+		if e != nil {
+			val = e.val
+		}
+
+		start, end, ok, msg := parseBitRange(c.Args[1:], val)
+		if msg != "" {
+			w.AppendError(msg)
+			return
+		} else if !ok {
+			w.AppendInt(0)
+			return
+		}
+
+		var n int
+		first, last := start>>3, end>>3
+		for i := first; i <= last; i++ {
+			b := val[i]
+			if i == first {
+				b &= 0xff >> uint(start&7)
+			}
+			if i == last {
+				b &= 0xff << uint(7-end&7)
+			}
+			n += bits.OnesCount8(b)
+		}
+		w.AppendInt(int64(n))
+	})
+}
+
+// bitpos implements BITPOS key bit [start [end [BYTE|BIT]]]
+// https://redis.io/commands/bitpos
+func (s *store) bitpos(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	bit, ok := parseBit(c.Arg(1))
+	if !ok {
+		w.AppendError(errBitArg)
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "string", func(e *entry) {
+		if e == nil {
+			if bit == 1 {
+				w.AppendInt(-1)
+			} else {
+				w.AppendInt(0)
+			}
+			return
+		}
+
+		start, end, ok, msg := parseBitRange(c.Args[2:], e.val)
+		if msg != "" {
+			w.AppendError(msg)
+			return
+		} else if !ok {
+			w.AppendInt(-1)
+			return
+		}
+
+		// skip whole bytes which cannot contain the bit
+		skip := byte(0)
+		if bit == 0 {
+			skip = 0xff
+		}
+		for i := start; i <= end; {
+			if i&7 == 0 && i+7 <= end && e.val[i>>3] == skip {
+				i += 8
+				continue
+			}
+			if getBit(e.val, i) == bit {
+				w.AppendInt(i)
+				return
+			}
+			i++
+		}
+
+		// when looking for clear bits without an explicit end, the
+		// string is considered to be padded with zeros
+		if bit == 0 && c.ArgN() < 4 {
+			w.AppendInt(end + 1)
+			return
+		}
+		w.AppendInt(-1)
+	})
+}
+
+// bitop implements BITOP AND|OR|XOR|NOT destkey key [key ...]
+// https://redis.io/commands/bitop
+func (s *store) bitop(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	op := strings.ToLower(c.Arg(0).String())
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if c.ArgN() != 3 {
+			w.AppendError("ERR BITOP NOT must be called with a single source key.")
+			return
+		}
+	default:
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	// the destination may also be a source
+	dst := c.Arg(1).String()
+	keys := []string{dst}
+	pos := map[string]int{dst: 0}
+	for _, arg := range c.Args[2:] {
+		if _, ok := pos[arg.String()]; !ok {
+			pos[arg.String()] = len(keys)
+			keys = append(keys, arg.String())
+		}
+	}
+
+	var size int
+	var msg string
+	s.keys.modifyAll(keys, func(cur []*entry) ([]*entry, bool) {
+		srcs := make([][]byte, 0, c.ArgN()-2)
+		for _, arg := range c.Args[2:] {
+			var val []byte
+			if e := cur[pos[arg.String()]]; !typed(e, "string") {
+				msg = errWrongType
+				return nil, false
+			} else if e != nil {
+				val = e.val
+			}
+			if srcs = append(srcs, val); len(val) > size {
+				size = len(val)
+			}
+		}
+
+		next := append([]*entry(nil), cur...)
+		if size == 0 {
+			next[0] = nil
+			return next, cur[0] != nil
+		}
+
+		res := make([]byte, size)
+		copy(res, srcs[0])
+		if op == "not" {
+			for i := range res {
+				res[i] = ^res[i]
+			}
+		}
+		for _, src := range srcs[1:] {
+			for i := range res {
+				var b byte
+				if i < len(src) {
+					b = src[i]
+				}
+				switch op {
+				case "and":
+					res[i] &= b
+				case "or":
+					res[i] |= b
+				case "xor":
+					res[i] ^= b
+				}
+			}
+		}
+
+		next[0] = newEntry(res)
+		return next, true
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendInt(int64(size))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("bitmap", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+	})
+
+	It("should set and get bits", func() {
+		Expect(call(subject.setbit, "SETBIT", "b", "7", "1")).To(Equal(int64(0)))
+		Expect(call(subject.setbit, "SETBIT", "b", "7", "1")).To(Equal(int64(1)))
+		Expect(call(subject.setbit, "SETBIT", "b", "9", "1")).To(Equal(int64(0)))
+		Expect(call(subject.get, "GET", "b")).To(Equal("\x01\x40"))
+		Expect(call(subject.getbit, "GETBIT", "b", "7")).To(Equal(int64(1)))
+		Expect(call(subject.getbit, "GETBIT", "b", "8")).To(Equal(int64(0)))
+		Expect(call(subject.getbit, "GETBIT", "b", "100")).To(Equal(int64(0)))
+		Expect(call(subject.setbit, "SETBIT", "b", "7", "0")).To(Equal(int64(1)))
+		Expect(call(subject.get, "GET", "b")).To(Equal("\x00\x40"))
+
+		Expect(call(subject.setbit, "SETBIT", "b", "-1", "1")).To(MatchError("ERR bit offset is not an integer or out of range"))
+		Expect(call(subject.setbit, "SETBIT", "b", "4294967296", "1")).To(MatchError("ERR bit offset is not an integer or out of range"))
+		Expect(call(subject.setbit, "SETBIT", "b", "1", "2")).To(MatchError("ERR bit is not an integer or out of range"))
+	})
+
+	It("should count bits", func() {
+		Expect(call(subject.set, "SET", "s", "foobar")).To(Equal("OK"))
+		Expect(call(subject.bitcount, "BITCOUNT", "s")).To(Equal(int64(26)))
+		Expect(call(subject.bitcount, "BITCOUNT", "s", "0", "0")).To(Equal(int64(4)))
+		Expect(call(subject.bitcount, "BITCOUNT", "s", "1", "1")).To(Equal(int64(6)))
+		Expect(call(subject.bitcount, "BITCOUNT", "s", "1", "1", "BYTE")).To(Equal(int64(6)))
+		Expect(call(subject.bitcount, "BITCOUNT", "s", "5", "30", "BIT")).To(Equal(int64(17)))
+		Expect(call(subject.bitcount, "BITCOUNT", "s", "-2", "-1")).To(Equal(int64(7)))
+		Expect(call(subject.bitcount, "BITCOUNT", "s", "3", "1")).To(Equal(int64(0)))
+		Expect(call(subject.bitcount, "BITCOUNT", "x")).To(Equal(int64(0)))
+
+		Expect(call(subject.bitcount, "BITCOUNT", "s", "1")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.bitcount, "BITCOUNT", "s", "0", "1", "WORD")).To(MatchError("ERR syntax error"))
+	})
+
+	It("should find bits", func() {
+		Expect(call(subject.set, "SET", "s", "\xff\xf0\x00")).To(Equal("OK"))
+		Expect(call(subject.bitpos, "BITPOS", "s", "0")).To(Equal(int64(12)))
+		Expect(call(subject.set, "SET", "s", "\x00\xff\xf0")).To(Equal("OK"))
+		Expect(call(subject.bitpos, "BITPOS", "s", "1", "0")).To(Equal(int64(8)))
+		Expect(call(subject.bitpos, "BITPOS", "s", "1", "2")).To(Equal(int64(16)))
+		Expect(call(subject.bitpos, "BITPOS", "s", "1", "2", "-1", "BYTE")).To(Equal(int64(16)))
+		Expect(call(subject.bitpos, "BITPOS", "s", "1", "7", "15", "BIT")).To(Equal(int64(8)))
+		Expect(call(subject.bitpos, "BITPOS", "s", "1", "7", "7", "BIT")).To(Equal(int64(-1)))
+
+		Expect(call(subject.set, "SET", "s", "\xff\xff\xff")).To(Equal("OK"))
+		Expect(call(subject.bitpos, "BITPOS", "s", "0")).To(Equal(int64(24)))
+		Expect(call(subject.bitpos, "BITPOS", "s", "0", "0", "-1")).To(Equal(int64(-1)))
+		Expect(call(subject.bitpos, "BITPOS", "x", "0")).To(Equal(int64(0)))
+		Expect(call(subject.bitpos, "BITPOS", "x", "1")).To(Equal(int64(-1)))
+		Expect(call(subject.bitpos, "BITPOS", "s", "2")).To(MatchError("ERR The bit argument must be 1 or 0."))
+	})
+
+	It("should perform bitwise operations", func() {
+		Expect(call(subject.set, "SET", "a", "foobar")).To(Equal("OK"))
+		Expect(call(subject.set, "SET", "b", "abcdef")).To(Equal("OK"))
+		Expect(call(subject.bitop, "BITOP", "AND", "d", "a", "b")).To(Equal(int64(6)))
+		Expect(call(subject.get, "GET", "d")).To(Equal("`bc`ab"))
+		Expect(call(subject.bitop, "BITOP", "OR", "d", "a", "b")).To(Equal(int64(6)))
+		Expect(call(subject.get, "GET", "d")).To(Equal("goofev"))
+		Expect(call(subject.bitop, "BITOP", "XOR", "a", "a", "a")).To(Equal(int64(6)))
+		Expect(call(subject.get, "GET", "a")).To(Equal("\x00\x00\x00\x00\x00\x00"))
+
+		Expect(call(subject.set, "SET", "c", "\x0f")).To(Equal("OK"))
+		Expect(call(subject.bitop, "BITOP", "NOT", "d", "c")).To(Equal(int64(1)))
+		Expect(call(subject.get, "GET", "d")).To(Equal("\xf0"))
+		Expect(call(subject.bitop, "BITOP", "AND", "d", "c", "x")).To(Equal(int64(1)))
+		Expect(call(subject.get, "GET", "d")).To(Equal("\x00"))
+		Expect(call(subject.bitop, "BITOP", "OR", "d", "x", "y")).To(Equal(int64(0)))
+		Expect(call(subject.exists, "EXISTS", "d")).To(Equal(int64(0)))
+
+		Expect(call(subject.bitop, "BITOP", "NOT", "d", "a", "b")).To(MatchError("ERR BITOP NOT must be called with a single source key."))
+		Expect(call(subject.bitop, "BITOP", "NAND", "d", "a")).To(MatchError("ERR syntax error"))
+	})
+
+})
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Error replies of blocking commands
+const (
+	errTimeout         = "ERR timeout is not a float or out of range"
+	errTimeoutNegative = "ERR timeout is negative"
+)
+
+// blocking tracks clients blocked on keys, e.g. by BZPOPMIN. Writes
+// signal their keys as ready and the signalling goroutine serves the
+// clients blocked on them, in the order they blocked, until an attempt
+// fails, like Redis' handleClientsBlockedOnKeys.
+type blocking struct {
+	// gate is held for reading while attempting and registering, so
+	// blocked clients do not observe transactions partially, see
+	// store.commit
+	gate *sync.RWMutex
+
+	// n is the number of blocked clients, accessed atomically, so writes
+	// can skip signalling while there are none
+	n int32
+
+	mu      sync.Mutex
+	waiters map[string][]*waiter
+	ready   []string
+	serving bool
+	held    bool
+}
+
+// waiter is a blocked client
+type waiter struct {
+	keys []string
+	try  func(key string) bool
+
+	mu     sync.Mutex
+	done   bool
+	served bool
+	ch     chan struct{}
+}
+
+// serve calls try with key, unless the waiter is done. It reports
+// whether the waiter is done.
+func (wt *waiter) serve(key string) bool {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	if !wt.done && wt.try(key) {
+		wt.done, wt.served = true, true
+		close(wt.ch)
+	}
+	return wt.done
+}
+
+// cancel stops serving the waiter, it reports whether it was served
+func (wt *waiter) cancel() bool {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	wt.done = true
+	return wt.served
+}
+
+// block calls try with each key, in order, until it succeeds. Otherwise
+// it blocks until try succeeds with a key signalled as ready, until the
+// timeout expires, unless zero, or ctx is done. try is called by the
+// signalling goroutines while blocked. It reports whether try succeeded.
+// Commands executed by EXEC do not block, like in Redis.
+func (b *blocking) block(ctx context.Context, keys []string, timeout time.Duration, try func(key string) bool) bool {
+	if inTxn(ctx) {
+		return attempt(keys, try)
+	}
+
+	b.gate.RLock()
+	if attempt(keys, try) {
+		b.gate.RUnlock()
+		return true
+	}
+
+	wt := &waiter{keys: keys, try: try, ch: make(chan struct{})}
+	b.mu.Lock()
+	if b.waiters == nil {
+		b.waiters = make(map[string][]*waiter)
+	}
+	for _, key := range keys {
+		b.waiters[key] = append(b.waiters[key], wt)
+	}
+	atomic.AddInt32(&b.n, 1)
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.remove(wt)
+		atomic.AddInt32(&b.n, -1)
+		b.mu.Unlock()
+	}()
+
+	// keys may have been written after the attempts, but before the
+	// waiter was registered
+	for _, key := range keys {
+		b.signal(key)
+	}
+	b.gate.RUnlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	// the writes which serve the waiter must not wait for it, see
+	// redeo.Wait
+	served := false
+	redeo.Wait(ctx, func() {
+		select {
+		case <-wt.ch:
+			served = true
+			return
+		case <-expired:
+		case <-ctx.Done():
+		}
+		served = wt.cancel()
+	})
+	return served
+}
+
+// attempt calls try with each key, in order, until it succeeds
+func attempt(keys []string, try func(key string) bool) bool {
+	for _, key := range keys {
+		if try(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// signal serves the clients blocked on key
+func (b *blocking) signal(key string) {
+	if atomic.LoadInt32(&b.n) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.waiters[key]) == 0 {
+		return
+	}
+	b.ready = append(b.ready, key)
+	if b.serving || b.held {
+		// attempts write keys, which are served by the outer call,
+		// transactions on release
+		return
+	}
+	b.serve()
+}
+
+// hold defers serving the keys signalled as ready until release
+func (b *blocking) hold() {
+	b.mu.Lock()
+	b.held = true
+	b.mu.Unlock()
+}
+
+// release serves the keys signalled as ready since hold
+func (b *blocking) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.held = false; !b.serving {
+		b.serve()
+	}
+}
+
+// serve serves the clients blocked on ready keys, must be called with mu
+// held
+func (b *blocking) serve() {
+	b.serving = true
+	for len(b.ready) != 0 {
+		key := b.ready[0]
+		b.ready = b.ready[1:]
+
+		for len(b.waiters[key]) != 0 {
+			wt := b.waiters[key][0]
+			b.mu.Unlock()
+			done := wt.serve(key)
+			b.mu.Lock()
+
+			if !done {
+				break
+			}
+			b.remove(wt)
+		}
+	}
+	b.serving = false
+}
+
+// remove unregisters a waiter, must be called with mu held
+func (b *blocking) remove(wt *waiter) {
+	for _, key := range wt.keys {
+		waiters := b.waiters[key][:0]
+		for _, w := range b.waiters[key] {
+			if w != wt {
+				waiters = append(waiters, w)
+			}
+		}
+		if len(waiters) == 0 {
+			delete(b.waiters, key)
+		} else {
+			b.waiters[key] = waiters
+		}
+	}
+}
+
+// parseTimeout parses the timeout of blocking commands in seconds, zero
+// blocks indefinitely. It returns an error reply on failure.
+func parseTimeout(arg resp.CommandArgument) (time.Duration, string) {
+	secs, err := strconv.ParseFloat(arg.String(), 64)
+	if err != nil || math.IsNaN(secs) || secs > float64(1<<63-1)/float64(time.Second) {
+		return 0, errTimeout
+	}
+	if secs < 0 {
+		return 0, errTimeoutNegative
+	}
+	return time.Duration(secs * float64(time.Second)), ""
+}
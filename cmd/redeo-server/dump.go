@@ -0,0 +1,679 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/client"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// DUMP payloads have the layout
+//
+//   type (1 byte) | value | version (2 bytes, LE) | CRC-64 (8 bytes, LE)
+//
+// like the RDB-based payloads of Redis, but values are encoded natively
+// and the checksum uses the ECMA polynomial, so payloads can only be
+// restored by redeo-server. The checksum covers all preceding bytes.
+// Values of type dumpString are the raw bytes of the string. Values of
+// type dumpZSet are the number of members, followed by each member,
+// length-prefixed, and its score, as little-endian IEEE 754 bits; counts
+// and lengths are uvarints. Values of type dumpSet are encoded like
+// sorted sets, without scores, and values of type dumpList are encoded
+// like sets, from head to tail. Values of type dumpStream are the last ID
+// and the entries, each with its ID and fields, encoded like lists,
+// followed by the consumer groups, each with its name, last ID, consumers
+// and pending entries; see appendStream. Values of type dumpJSON are the
+// serialised documents. Values of type dumpHash are the number of
+// fields, followed by each field and its value, both length-prefixed.
+// Changes to the encoding of existing types must increment dumpVersion.
+const (
+	dumpVersion    = 1
+	dumpString     = 0
+	dumpZSet       = 1
+	dumpSet        = 2
+	dumpList       = 3
+	dumpStream     = 4
+	dumpJSON       = 5
+	dumpHash       = 6
+	dumpFooterSize = 10
+)
+
+var dumpTable = crc64.MakeTable(crc64.ECMA)
+
+// Error replies of DUMP, RESTORE and MIGRATE
+const (
+	errDumpPayload = "ERR DUMP payload version or checksum are wrong"
+	errDumpFormat  = "ERR Bad data format"
+	errBusyKey     = "BUSYKEY Target key name already exists."
+)
+
+// dumpValue serializes the value of an entry, objects must be locked
+// for reading, see keyspace.view
+func dumpValue(e *entry) []byte {
+	p := make([]byte, 0, 1+e.size()+dumpFooterSize)
+	switch obj := e.obj.(type) {
+	case nil:
+		p = append(p, dumpString)
+		p = append(p, e.val...)
+	case *zset:
+		var buf [8]byte
+		p = append(p, dumpZSet)
+		p = appendUvarint(p, uint64(obj.len()))
+		for x := obj.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			p = appendString(p, x.member)
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(x.score))
+			p = append(p, buf[:]...)
+		}
+	case *set:
+		p = append(p, dumpSet)
+		p = appendUvarint(p, uint64(obj.len()))
+		for member := range obj.members {
+			p = appendString(p, member)
+		}
+	case *list:
+		p = append(p, dumpList)
+		p = appendUvarint(p, uint64(obj.len()))
+		for i := 0; i < obj.len(); i++ {
+			p = appendString(p, obj.index(i))
+		}
+	case *stream:
+		p = append(p, dumpStream)
+		p = appendStream(p, obj)
+	case *jsonDoc:
+		p = append(p, dumpJSON)
+		p = append(p, marshalJSON(obj.root)...)
+	case *hash:
+		p = append(p, dumpHash)
+		p = appendUvarint(p, uint64(obj.len()))
+		for field, val := range obj.fields {
+			p = appendString(p, field)
+			p = appendString(p, val)
+		}
+	}
+	p = append(p, byte(dumpVersion), byte(dumpVersion>>8))
+
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], crc64.Checksum(p, dumpTable))
+	return append(p, sum[:]...)
+}
+
+// appendUvarint appends v as a uvarint
+func appendUvarint(p []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(p, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendString appends s, length-prefixed
+func appendString(p []byte, s string) []byte {
+	return append(appendUvarint(p, uint64(len(s))), s...)
+}
+
+// appendStream appends the encoding of a stream
+func appendStream(p []byte, st *stream) []byte {
+	p = appendUvarint(p, st.lastID.ms)
+	p = appendUvarint(p, st.lastID.seq)
+	p = appendUvarint(p, uint64(st.len()))
+	for _, se := range st.entries {
+		p = appendUvarint(p, se.id.ms)
+		p = appendUvarint(p, se.id.seq)
+		p = appendUvarint(p, uint64(len(se.fields)))
+		for _, f := range se.fields {
+			p = appendString(p, f)
+		}
+	}
+
+	p = appendUvarint(p, uint64(len(st.groups)))
+	for name, g := range st.groups {
+		p = appendString(p, name)
+		p = appendUvarint(p, g.lastID.ms)
+		p = appendUvarint(p, g.lastID.seq)
+		p = appendUvarint(p, uint64(len(g.consumers)))
+		for _, c := range g.consumers {
+			p = appendString(p, c.name)
+			p = appendUvarint(p, uint64(c.seen))
+		}
+		p = appendUvarint(p, uint64(len(g.pel)))
+		for id, nack := range g.pel {
+			p = appendUvarint(p, id.ms)
+			p = appendUvarint(p, id.seq)
+			p = appendString(p, nack.consumer.name)
+			p = appendUvarint(p, uint64(nack.delivered))
+			p = appendUvarint(p, uint64(nack.count))
+		}
+	}
+	return p
+}
+
+// restoreValue deserializes a DUMP payload into a new entry, it returns
+// an error reply on failure
+func restoreValue(p []byte) (*entry, string) {
+	if len(p) < 1+dumpFooterSize {
+		return nil, errDumpPayload
+	}
+
+	body, sum := p[:len(p)-8], p[len(p)-8:]
+	if crc64.Checksum(body, dumpTable) != binary.LittleEndian.Uint64(sum) {
+		return nil, errDumpPayload
+	}
+	if binary.LittleEndian.Uint16(body[len(body)-2:]) != dumpVersion {
+		return nil, errDumpPayload
+	}
+
+	val := body[1 : len(body)-2]
+	switch body[0] {
+	case dumpString:
+		return newEntry(append([]byte(nil), val...)), ""
+	case dumpZSet:
+		if z := restoreZSet(val); z != nil {
+			e := newEntry(nil)
+			e.obj = z
+			return e, ""
+		}
+	case dumpSet:
+		if st := restoreSet(val); st != nil {
+			e := newEntry(nil)
+			e.obj = st
+			return e, ""
+		}
+	case dumpList:
+		if l := restoreList(val); l != nil {
+			e := newEntry(nil)
+			e.obj = l
+			return e, ""
+		}
+	case dumpStream:
+		if st := restoreStream(val); st != nil {
+			e := newEntry(nil)
+			e.obj = st
+			return e, ""
+		}
+	case dumpJSON:
+		if root, ok := parseJSON(val); ok {
+			e := newEntry(nil)
+			e.obj = newJSONDoc(root)
+			return e, ""
+		}
+	case dumpHash:
+		if h := restoreHash(val); h != nil {
+			e := newEntry(nil)
+			e.obj = h
+			return e, ""
+		}
+	}
+	return nil, errDumpFormat
+}
+
+// restoreZSet decodes a sorted set, or returns nil if p is invalid
+func restoreZSet(p []byte) *zset {
+	n, sz := binary.Uvarint(p)
+	if sz <= 0 || n == 0 {
+		return nil
+	}
+	p = p[sz:]
+
+	z := newZSet()
+	for ; n > 0; n-- {
+		size, sz := binary.Uvarint(p)
+		if sz <= 0 || len(p)-sz < 8 || size > uint64(len(p)-sz-8) {
+			return nil
+		}
+		p = p[sz:]
+		member := string(p[:size])
+		score := math.Float64frombits(binary.LittleEndian.Uint64(p[size:]))
+		if math.IsNaN(score) || !z.set(member, score) {
+			return nil
+		}
+		p = p[size+8:]
+	}
+	if len(p) != 0 {
+		return nil
+	}
+	return z
+}
+
+// restoreSet decodes a set, or returns nil if p is invalid
+func restoreSet(p []byte) *set {
+	n, sz := binary.Uvarint(p)
+	if sz <= 0 || n == 0 {
+		return nil
+	}
+	p = p[sz:]
+
+	st := newSet()
+	for ; n > 0; n-- {
+		size, sz := binary.Uvarint(p)
+		if sz <= 0 || size > uint64(len(p)-sz) {
+			return nil
+		}
+		p = p[sz:]
+		if !st.add(string(p[:size])) {
+			return nil
+		}
+		p = p[size:]
+	}
+	if len(p) != 0 {
+		return nil
+	}
+	return st
+}
+
+// restoreList decodes a list, or returns nil if p is invalid
+func restoreList(p []byte) *list {
+	n, sz := binary.Uvarint(p)
+	if sz <= 0 || n == 0 {
+		return nil
+	}
+	p = p[sz:]
+
+	l := newList()
+	for ; n > 0; n-- {
+		size, sz := binary.Uvarint(p)
+		if sz <= 0 || size > uint64(len(p)-sz) {
+			return nil
+		}
+		p = p[sz:]
+		l.push(string(p[:size]), false)
+		p = p[size:]
+	}
+	if len(p) != 0 {
+		return nil
+	}
+	return l
+}
+
+// restoreHash decodes a hash, or returns nil if p is invalid
+func restoreHash(p []byte) *hash {
+	r := &dumpReader{p: p}
+	n := r.uvarint()
+	if r.err || n == 0 {
+		return nil
+	}
+
+	h := newHash()
+	for ; n > 0 && !r.err; n-- {
+		field, val := r.string(), r.string()
+		if r.err || !h.set(field, val) {
+			return nil
+		}
+	}
+	if r.err || len(r.p) != 0 {
+		return nil
+	}
+	return h
+}
+
+// dumpReader decodes the values of DUMP payloads, failing on the first
+// invalid value
+type dumpReader struct {
+	p   []byte
+	err bool
+}
+
+func (r *dumpReader) uvarint() uint64 {
+	v, sz := binary.Uvarint(r.p)
+	if sz <= 0 {
+		r.err = true
+		return 0
+	}
+	r.p = r.p[sz:]
+	return v
+}
+
+func (r *dumpReader) string() string {
+	n := r.uvarint()
+	if n > uint64(len(r.p)) {
+		r.err = true
+		return ""
+	}
+	s := string(r.p[:n])
+	r.p = r.p[n:]
+	return s
+}
+
+func (r *dumpReader) streamID() streamID {
+	return streamID{ms: r.uvarint(), seq: r.uvarint()}
+}
+
+// restoreStream decodes a stream, or returns nil if p is invalid
+func restoreStream(p []byte) *stream {
+	r := &dumpReader{p: p}
+	st := newStream()
+	lastID := r.streamID()
+	for n := r.uvarint(); n > 0 && !r.err; n-- {
+		id := r.streamID()
+		fields := make([]string, 0, 2)
+		for m := r.uvarint(); m > 0 && !r.err; m-- {
+			fields = append(fields, r.string())
+		}
+		if len(fields) == 0 || len(fields)%2 != 0 || (st.len() != 0 && !st.lastID.less(id)) {
+			return nil
+		}
+		st.add(id, fields)
+	}
+	if lastID.less(st.lastID) {
+		return nil
+	}
+	st.lastID = lastID
+
+	for n := r.uvarint(); n > 0 && !r.err; n-- {
+		name := r.string()
+		g := newStreamGroup(r.streamID())
+		for m := r.uvarint(); m > 0 && !r.err; m-- {
+			c := &streamConsumer{name: r.string(), seen: int64(r.uvarint())}
+			g.consumers[c.name] = c
+		}
+		for m := r.uvarint(); m > 0 && !r.err; m-- {
+			id := r.streamID()
+			c := g.consumers[r.string()]
+			delivered, count := int64(r.uvarint()), int64(r.uvarint())
+			if c == nil {
+				return nil
+			}
+			g.deliver(id, c, delivered)
+			g.pel[id].count = count
+		}
+		st.groups[name] = g
+	}
+	if r.err || len(r.p) != 0 {
+		return nil
+	}
+	return st
+}
+
+func (s *store) dump(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.keys.view(c.Arg(0).String(), func(e *entry) {
+		if e == nil {
+			w.AppendNil()
+			return
+		}
+		w.AppendBulk(dumpValue(e))
+	})
+}
+
+// restore implements RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+// [IDLETIME seconds] [FREQ frequency]
+// https://redis.io/commands/restore
+func (s *store) restore(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var replace, absTTL bool
+	idle, freq := int64(-1), int64(-1)
+	for i := 3; i < c.ArgN(); i++ {
+		switch opt := strings.ToLower(c.Arg(i).String()); opt {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime", "freq":
+			if i+1 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			n, err := c.Arg(i + 1).Int()
+			if err != nil {
+				w.AppendError(errNotInteger)
+				return
+			}
+			if opt == "idletime" && n < 0 {
+				w.AppendError("ERR Invalid IDLE value, must be >= 0")
+				return
+			} else if opt == "freq" && (n < 0 || n > 255) {
+				w.AppendError("ERR Invalid FREQ value, must be >= 0 and <= 255")
+				return
+			}
+			if opt == "idletime" {
+				idle = n
+			} else {
+				freq = n
+			}
+			i++
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	ttl, err := c.Arg(1).Int()
+	if err != nil {
+		w.AppendError(errNotInteger)
+		return
+	} else if ttl < 0 {
+		w.AppendError("ERR Invalid TTL value, must be >= 0")
+		return
+	}
+
+	now := s.keys.now()
+	var expires int64
+	if ttl != 0 {
+		opt := "px"
+		if absTTL {
+			opt = "pxat"
+		}
+		var msg string
+		if expires, msg = toDeadline(c.Name, opt, ttl, now); msg != "" {
+			w.AppendError(msg)
+			return
+		}
+	}
+
+	e, msg := restoreValue(c.Arg(2))
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	var stored bool
+	s.keys.modify(c.Arg(0).String(), func(cur *entry) (*entry, bool) {
+		if cur != nil && !replace {
+			msg = errBusyKey
+			return nil, false
+		}
+		if expires != 0 && expires <= now.UnixNano() {
+			return nil, true
+		}
+
+		e.expires, stored = expires, true
+		return e, true
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	// writes count as accesses, so the given access time and frequency
+	// are applied once the key is stored
+	if stored && idle >= 0 {
+		atomic.StoreInt64(&e.accessed, now.Add(-time.Duration(idle)*time.Second).UnixNano())
+	}
+	if stored && freq >= 0 {
+		e.setFreq(uint8(freq), now)
+	}
+	w.AppendOK()
+}
+
+// migrate implements MIGRATE host port key|"" destination-db timeout [COPY]
+// [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key ...],
+// restoring keys on the target via RESTORE. SELECT is only sent for
+// databases other than 0.
+// https://redis.io/commands/migrate
+func (s *store) migrate(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 5 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	db, err1 := c.Arg(3).Int()
+	ms, err2 := c.Arg(4).Int()
+	if err1 != nil || err2 != nil {
+		w.AppendError(errNotInteger)
+		return
+	}
+	if ms <= 0 {
+		ms = 1000
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+
+	var copyKeys, replace bool
+	var auth []string
+	keys := []string{c.Arg(2).String()}
+	for i := 5; i < c.ArgN(); i++ {
+		switch strings.ToLower(c.Arg(i).String()) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "auth":
+			if i+1 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			auth = []string{c.Arg(i + 1).String()}
+			i++
+		case "auth2":
+			if i+2 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			auth = []string{c.Arg(i + 1).String(), c.Arg(i + 2).String()}
+			i += 2
+		case "keys":
+			if len(c.Arg(2)) != 0 {
+				w.AppendError("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+				return
+			}
+			keys = keys[:0]
+			for _, arg := range c.Args[i+1:] {
+				keys = append(keys, arg.String())
+			}
+			i = c.ArgN()
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	// replicas must not migrate again, removed keys are propagated as DEL
+	var removed []resp.CommandArgument
+	defer rewrite(c, func() []*resp.Command {
+		if len(removed) == 0 {
+			return nil
+		}
+		return []*resp.Command{resp.NewCommand("DEL", removed...)}
+	})
+
+	type dumped struct {
+		key     string
+		e       *entry
+		payload []byte
+	}
+	var items []dumped
+	for _, key := range keys {
+		s.keys.view(key, func(e *entry) {
+			if e != nil {
+				items = append(items, dumped{key: key, e: e, payload: dumpValue(e)})
+			}
+		})
+	}
+	if len(items) == 0 {
+		w.AppendInlineString("NOKEY")
+		return
+	}
+
+	cn, err := net.DialTimeout("tcp", net.JoinHostPort(c.Arg(0).String(), c.Arg(1).String()), timeout)
+	if err != nil {
+		w.AppendError("IOERR error or timeout connecting to the client")
+		return
+	}
+	defer cn.Close()
+	_ = cn.SetDeadline(time.Now().Add(timeout))
+
+	conn := client.Wrap(cn)
+	pre := 0
+	if len(auth) != 0 {
+		conn.WriteCmdString("AUTH", auth...)
+		pre++
+	}
+	if db != 0 {
+		conn.WriteCmdString("SELECT", strconv.FormatInt(db, 10))
+		pre++
+	}
+
+	now := s.keys.now().UnixNano()
+	for _, it := range items {
+		var ttl int64
+		if it.e.expires != 0 {
+			if ttl = (it.e.expires - now) / int64(time.Millisecond); ttl < 1 {
+				ttl = 1
+			}
+		}
+
+		args := [][]byte{[]byte(it.key), strconv.AppendInt(nil, ttl, 10), it.payload}
+		if replace {
+			args = append(args, []byte("REPLACE"))
+		}
+		conn.WriteCmd("RESTORE", args...)
+	}
+	if err := conn.Flush(); err != nil {
+		w.AppendError("IOERR error or timeout writing to target instance")
+		return
+	}
+
+	var failed string
+	for i := 0; i < pre+len(items); i++ {
+		t, err := conn.PeekType()
+		if err != nil {
+			w.AppendError("IOERR error or timeout reading to target instance")
+			return
+		}
+		if t == resp.TypeError {
+			msg, err := conn.ReadError()
+			if err != nil {
+				w.AppendError("IOERR error or timeout reading to target instance")
+				return
+			}
+			if failed == "" {
+				failed = msg
+			}
+			continue
+		}
+		if _, err := conn.ReadInlineString(); err != nil {
+			w.AppendError("IOERR error or timeout reading to target instance")
+			return
+		}
+
+		// remove migrated keys, unless modified in the meantime
+		if i >= pre && !copyKeys {
+			it := items[i-pre]
+			s.keys.modify(it.key, func(cur *entry) (*entry, bool) {
+				if cur != it.e {
+					return nil, false
+				}
+				removed = append(removed, resp.CommandArgument(it.key))
+				return nil, true
+			})
+		}
+	}
+
+	if failed != "" {
+		w.AppendError("ERR Target instance replied with error: " + failed)
+		return
+	}
+	w.AppendOK()
+}
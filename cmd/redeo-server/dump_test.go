@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dump", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.set, "SET", "k", "v")).To(Equal("OK"))
+	})
+
+	It("should dump and restore values", func() {
+		payload := call(subject.dump, "DUMP", "k").(string)
+		Expect(payload).To(Equal("\x00v\x01\x00\x55\x85\x80\x2f\x9f\xbd\x31\xbe"))
+		Expect(call(subject.dump, "DUMP", "x")).To(BeNil())
+
+		Expect(call(subject.restore, "RESTORE", "k", "0", payload)).To(MatchError("BUSYKEY Target key name already exists."))
+		Expect(call(subject.restore, "RESTORE", "k", "100000", payload, "REPLACE", "IDLETIME", "60")).To(Equal("OK"))
+		info, _ := subject.Inspect("k")
+		Expect(info.IdleTime).To(BeNumerically("~", time.Minute, time.Second))
+		Expect(call(subject.get, "GET", "k")).To(Equal("v"))
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(100)))
+
+		Expect(call(subject.restore, "RESTORE", "e", "1", payload, "ABSTTL")).To(Equal("OK"))
+		Expect(call(subject.exists, "EXISTS", "e")).To(Equal(int64(0)))
+	})
+
+	It("should dump and restore sorted sets", func() {
+		Expect(call(subject.zadd, "ZADD", "z", "1.5", "a", "-inf", "b")).To(Equal(int64(2)))
+		payload := call(subject.dump, "DUMP", "z").(string)
+		Expect(call(subject.restore, "RESTORE", "y", "0", payload)).To(Equal("OK"))
+		Expect(call(subject.keyType, "TYPE", "y")).To(Equal("zset"))
+		Expect(call(subject.zscore, "ZSCORE", "y", "a")).To(Equal("1.5"))
+		Expect(call(subject.zscore, "ZSCORE", "y", "b")).To(Equal("-inf"))
+		Expect(call(subject.zcard, "ZCARD", "y")).To(Equal(int64(2)))
+	})
+
+	It("should dump and restore lists", func() {
+		Expect(call(subject.rpush, "RPUSH", "l", "a", "", "c")).To(Equal(int64(3)))
+		payload := call(subject.dump, "DUMP", "l").(string)
+		Expect(call(subject.restore, "RESTORE", "y", "0", payload)).To(Equal("OK"))
+		Expect(call(subject.keyType, "TYPE", "y")).To(Equal("list"))
+		Expect(call(subject.lrange, "LRANGE", "y", "0", "-1")).To(Equal([]interface{}{"a", "", "c"}))
+	})
+
+	It("should dump and restore streams", func() {
+		Expect(call(subject.xadd, "XADD", "s", "1", "a", "1")).To(Equal("1-0"))
+		Expect(call(subject.xadd, "XADD", "s", "2", "b", "2")).To(Equal("2-0"))
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "s", "g", "0")).To(Equal("OK"))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c", "COUNT", "1", "STREAMS", "s", ">")).To(HaveLen(1))
+
+		payload := call(subject.dump, "DUMP", "s").(string)
+		Expect(call(subject.restore, "RESTORE", "y", "0", payload)).To(Equal("OK"))
+		Expect(call(subject.keyType, "TYPE", "y")).To(Equal("stream"))
+		Expect(call(subject.xlen, "XLEN", "y")).To(Equal(int64(2)))
+		Expect(call(subject.xpending, "XPENDING", "y", "g")).To(Equal([]interface{}{
+			int64(1), "1-0", "1-0", []interface{}{[]interface{}{"c", "1"}},
+		}))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c", "STREAMS", "y", ">")).To(Equal([]interface{}{
+			[]interface{}{"y", []interface{}{[]interface{}{"2-0", []interface{}{"b", "2"}}}},
+		}))
+	})
+
+	It("should reject invalid payloads", func() {
+		payload := call(subject.dump, "DUMP", "k").(string)
+		Expect(call(subject.restore, "RESTORE", "n", "0", payload[1:])).To(MatchError("ERR DUMP payload version or checksum are wrong"))
+		Expect(call(subject.restore, "RESTORE", "n", "0", "x")).To(MatchError("ERR DUMP payload version or checksum are wrong"))
+		Expect(call(subject.restore, "RESTORE", "n", "-1", payload)).To(MatchError("ERR Invalid TTL value, must be >= 0"))
+		Expect(call(subject.restore, "RESTORE", "n", "0", payload, "FREQ", "256")).To(MatchError("ERR Invalid FREQ value, must be >= 0 and <= 255"))
+		Expect(call(subject.restore, "RESTORE", "n", "0", payload, "FOO")).To(MatchError("ERR syntax error"))
+	})
+
+	It("should migrate keys", func() {
+		srv := redeo.NewServer(nil)
+		target := newStore(srv.Info())
+		target.register(srv)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		host, port, _ := net.SplitHostPort(lis.Addr().String())
+		Expect(call(subject.set, "SET", "t", "x", "EX", "100")).To(Equal("OK"))
+		Expect(call(subject.migrate, "MIGRATE", host, port, "k", "0", "1000", "COPY")).To(Equal("OK"))
+		Expect(call(subject.migrate, "MIGRATE", host, port, "", "0", "1000", "KEYS", "k", "t", "x")).To(MatchError(
+			"ERR Target instance replied with error: BUSYKEY Target key name already exists."))
+		Expect(call(subject.exists, "EXISTS", "k", "t")).To(Equal(int64(1)))
+
+		Expect(call(target.get, "GET", "k")).To(Equal("v"))
+		Expect(call(target.get, "GET", "t")).To(Equal("x"))
+		Expect(call(target.ttl, "TTL", "t")).To(Equal(int64(100)))
+
+		Expect(call(subject.migrate, "MIGRATE", host, port, "k", "0", "1000", "REPLACE")).To(Equal("OK"))
+		Expect(call(subject.migrate, "MIGRATE", host, port, "k", "0", "1000")).To(Equal("NOKEY"))
+		Expect(call(subject.dbsize, "DBSIZE")).To(Equal(int64(0)))
+
+		Expect(call(subject.migrate, "MIGRATE", host, port, "x", "0", "1000", "KEYS", "k")).To(MatchError(
+			"ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"))
+	})
+
+})
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Eviction policies, like Redis' maxmemory-policy
+const (
+	noEviction     = "noeviction"
+	allKeysLRU     = "allkeys-lru"
+	allKeysLFU     = "allkeys-lfu"
+	allKeysRandom  = "allkeys-random"
+	volatileLRU    = "volatile-lru"
+	volatileLFU    = "volatile-lfu"
+	volatileRandom = "volatile-random"
+	volatileTTL    = "volatile-ttl"
+)
+
+// evictionPolicies are the supported eviction policies
+var evictionPolicies = []string{
+	noEviction,
+	allKeysLRU, allKeysLFU, allKeysRandom,
+	volatileLRU, volatileLFU, volatileRandom, volatileTTL,
+}
+
+// evictionPoolSize is the number of candidates retained between
+// evictions, like Redis' EVPOOL_SIZE
+const evictionPoolSize = 16
+
+// defaultEvictionSamples is the default number of keys sampled per
+// eviction, like Redis' maxmemory-samples
+const defaultEvictionSamples = 5
+
+// errOOM is replied to commands which may use more memory while the
+// memory limit is exceeded and no keys can be evicted
+const errOOM = "OOM command not allowed when used memory > 'maxmemory'."
+
+// evictionCandidate is a key which may be evicted, the higher the score
+// the better
+type evictionCandidate struct {
+	key   string
+	score uint64
+}
+
+// eviction holds the memory limit and the eviction policy of a store
+type eviction struct {
+	// maxMemory is the memory limit in bytes, zero if unlimited, and
+	// samples the number of keys sampled per eviction, accessed
+	// atomically
+	maxMemory int64
+	samples   int64
+
+	// policy is the eviction policy, a string
+	policy atomic.Value
+
+	// pool holds the best candidates of previous samples, ordered by
+	// score, guarded by poolMu
+	pool   []evictionCandidate
+	poolMu sync.Mutex
+}
+
+// evictionPolicy returns the eviction policy
+func (s *store) evictionPolicy() string {
+	if p, ok := s.evict.policy.Load().(string); ok {
+		return p
+	}
+	return noEviction
+}
+
+// tracksLFU reports whether the eviction policy tracks access
+// frequencies, rather than access times
+func (s *store) tracksLFU() bool {
+	return strings.HasSuffix(s.evictionPolicy(), "-lfu")
+}
+
+// setEviction sets the memory limit, the eviction policy and the number
+// of keys sampled per eviction
+func (s *store) setEviction(maxMemory int64, policy string, samples int) {
+	s.evict.poolMu.Lock()
+	s.evict.pool = s.evict.pool[:0]
+	s.evict.poolMu.Unlock()
+
+	atomic.StoreInt64(&s.evict.maxMemory, maxMemory)
+	atomic.StoreInt64(&s.evict.samples, int64(samples))
+	s.evict.policy.Store(policy)
+}
+
+// configureEviction applies the maxmemory, maxmemory-policy and
+// maxmemory-samples directives of file, which may be nil, falling back to
+// their defaults
+func (s *store) configureEviction(file *redeo.ConfigFile) error {
+	maxMemory, policy, samples := int64(0), noEviction, defaultEvictionSamples
+	if file != nil {
+		if v, ok := file.Get("maxmemory"); ok {
+			n, err := parseMemory(v)
+			if err != nil {
+				return fmt.Errorf("%s: bad directive %q: %v", file.Path(), "maxmemory", err)
+			}
+			maxMemory = n
+		}
+		if v, ok := file.Get("maxmemory-policy"); ok {
+			if policy = strings.ToLower(v); !isEvictionPolicy(policy) {
+				return fmt.Errorf("%s: bad directive %q: unknown policy %q", file.Path(), "maxmemory-policy", v)
+			}
+		}
+		if v, ok := file.Get("maxmemory-samples"); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 64 {
+				return fmt.Errorf("%s: bad directive %q: must be between 1 and 64", file.Path(), "maxmemory-samples")
+			}
+			samples = n
+		}
+	}
+
+	s.setEviction(maxMemory, policy, samples)
+	return nil
+}
+
+func isEvictionPolicy(policy string) bool {
+	for _, p := range evictionPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMemory parses a memory size in bytes, with an optional unit like
+// Redis' config: k, m and g are powers of 1000, kb, mb and gb powers of
+// 1024
+func parseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1e3}, {"m", 1e6}, {"g", 1e9}, {"b", 1},
+	}
+
+	num, mul := strings.ToLower(s), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mul = strings.TrimSuffix(num, u.suffix), u.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return n * mul, nil
+}
+
+// denyOOM wraps handlers of commands which may use more memory, they
+// evict keys first if the memory limit is exceeded and fail if no keys
+// can be evicted
+func (s *store) denyOOM(h redeo.HandlerFunc) redeo.HandlerFunc {
+	return func(w resp.ResponseWriter, c *resp.Command) {
+		if !s.evictKeys() {
+			w.AppendError(errOOM)
+			return
+		}
+		h(w, c)
+	}
+}
+
+// evictKeys evicts keys according to the eviction policy until the
+// memory used is within the limit, it reports false if not enough keys
+// can be evicted
+func (s *store) evictKeys() bool {
+	maxMemory := atomic.LoadInt64(&s.evict.maxMemory)
+	if maxMemory == 0 {
+		return true
+	}
+
+	policy := s.evictionPolicy()
+	for s.keys.usedMemory() > maxMemory {
+		if policy == noEviction {
+			return false
+		}
+		key, ok := s.evictionCandidate(policy)
+		if !ok {
+			return false
+		}
+
+		evicted := false
+		s.keys.modify(key, func(cur *entry) (*entry, bool) {
+			evicted = cur != nil
+			return nil, evicted
+		})
+		if evicted {
+			s.info.Evicted(1)
+		}
+	}
+	return true
+}
+
+// evictionCandidate returns the key to evict next. Random policies pick
+// a sampled key, the others add the sampled keys to the pool and pick
+// the best candidate, like Redis' approximated LRU, LFU and TTL
+// algorithms.
+func (s *store) evictionCandidate(policy string) (string, bool) {
+	volatile := strings.HasPrefix(policy, "volatile-")
+	samples := int(atomic.LoadInt64(&s.evict.samples))
+
+	if strings.HasSuffix(policy, "-random") {
+		var key string
+		s.keys.sample(1, volatile, func(k string, _ *entry) { key = k })
+		return key, key != ""
+	}
+
+	s.evict.poolMu.Lock()
+	defer s.evict.poolMu.Unlock()
+
+	now := s.keys.now()
+	s.keys.sample(samples, volatile, func(key string, e *entry) {
+		var score uint64
+		switch policy {
+		case allKeysLRU, volatileLRU:
+			score = uint64(e.idle(now))
+		case allKeysLFU, volatileLFU:
+			score = uint64(255 - e.freq(now))
+		case volatileTTL:
+			score = math.MaxUint64 - uint64(e.expires)
+		}
+		s.evict.add(evictionCandidate{key: key, score: score})
+	})
+
+	pool := s.evict.pool
+	if len(pool) == 0 {
+		return "", false
+	}
+	best := pool[len(pool)-1]
+	s.evict.pool = pool[:len(pool)-1]
+	return best.key, true
+}
+
+// add adds a candidate to the pool, replacing the worst one if full.
+// Must be called with poolMu held.
+func (ev *eviction) add(c evictionCandidate) {
+	for i, p := range ev.pool {
+		if p.key == c.key {
+			ev.pool = append(ev.pool[:i], ev.pool[i+1:]...)
+			break
+		}
+	}
+
+	i := sort.Search(len(ev.pool), func(i int) bool { return ev.pool[i].score >= c.score })
+	if len(ev.pool) == evictionPoolSize {
+		if i == 0 {
+			return
+		}
+		ev.pool = append(ev.pool[:0], ev.pool[1:]...)
+		i--
+	}
+	ev.pool = append(ev.pool, evictionCandidate{})
+	copy(ev.pool[i+1:], ev.pool[i:])
+	ev.pool[i] = c
+}
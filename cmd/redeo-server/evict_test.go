@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("eviction", func() {
+	var subject *store
+
+	// age sets the last access of key to d ago
+	age := func(key string, d time.Duration) {
+		e, ok := subject.keys.get(key)
+		Expect(ok).To(BeTrue())
+		e.accessed = time.Now().Add(-d).UnixNano()
+	}
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		for i := 0; i < 10; i++ {
+			Expect(call(subject.set, "SET", "k"+strconv.Itoa(i), "0123456789")).To(Equal("OK"))
+		}
+	})
+
+	It("should track the memory used", func() {
+		_, bytes := subject.DatasetSize()
+		Expect(subject.keys.usedMemory()).To(Equal(bytes))
+		Expect(bytes).To(Equal(int64(120)))
+
+		Expect(call(subject.append, "APPEND", "k0", "x")).To(Equal(int64(11)))
+		Expect(call(subject.sadd, "SADD", "s", "a", "b")).To(Equal(int64(2)))
+		Expect(call(subject.del, "DEL", "k1", "k2")).To(Equal(int64(2)))
+		Expect(call(subject.expire("px"), "PEXPIRE", "k3", "1")).To(Equal(int64(1)))
+		time.Sleep(2 * time.Millisecond)
+		Expect(call(subject.exists, "EXISTS", "k3")).To(Equal(int64(0)))
+
+		_, bytes = subject.DatasetSize()
+		Expect(subject.keys.usedMemory()).To(Equal(bytes))
+
+		Expect(call(subject.flushall, "FLUSHALL")).To(Equal("OK"))
+		Expect(subject.keys.usedMemory()).To(Equal(int64(0)))
+	})
+
+	It("should count accesses logarithmically", func() {
+		counter := uint8(lfuInitVal)
+		for i := 0; i < 100; i++ {
+			counter = lfuIncr(counter)
+		}
+		Expect(counter).To(BeNumerically(">", lfuInitVal))
+		Expect(counter).To(BeNumerically("<", 30))
+		Expect(lfuIncr(255)).To(Equal(uint8(255)))
+
+		Expect(lfuDecr(100<<8|20, 100)).To(Equal(uint8(20)))
+		Expect(lfuDecr(100<<8|20, 103)).To(Equal(uint8(17)))
+		Expect(lfuDecr(100<<8|20, 200)).To(Equal(uint8(0)))
+	})
+
+	It("should report access times or frequencies", func() {
+		oi, ok := subject.Inspect("k0")
+		Expect(ok).To(BeTrue())
+		Expect(oi.IdleTime).To(BeNumerically(">=", 0))
+		Expect(oi.Freq).To(Equal(int64(-1)))
+
+		subject.setEviction(0, allKeysLFU, defaultEvictionSamples)
+		oi, _ = subject.Inspect("k0")
+		Expect(oi.IdleTime).To(BeNumerically("<", 0))
+		Expect(oi.Freq).To(BeNumerically(">=", lfuInitVal))
+
+		payload := call(subject.dump, "DUMP", "k0").(string)
+		Expect(call(subject.restore, "RESTORE", "r", "0", payload, "FREQ", "100")).To(Equal("OK"))
+		oi, _ = subject.Inspect("r")
+		Expect(oi.Freq).To(Equal(int64(100)))
+
+		subject.setEviction(0, allKeysLRU, defaultEvictionSamples)
+		Expect(call(subject.restore, "RESTORE", "r", "0", payload, "REPLACE", "IDLETIME", "60")).To(Equal("OK"))
+		oi, _ = subject.Inspect("r")
+		Expect(oi.IdleTime).To(BeNumerically(">=", time.Minute))
+	})
+
+	It("should keep the access frequency on overwrites", func() {
+		e, _ := subject.keys.get("k0")
+		e.setFreq(50, time.Now())
+		Expect(call(subject.set, "SET", "k0", "x")).To(Equal("OK"))
+		e, _ = subject.keys.get("k0")
+		Expect(e.freq(time.Now())).To(BeNumerically(">=", 50))
+	})
+
+	It("should reject writes without eviction", func() {
+		subject.setEviction(100, noEviction, defaultEvictionSamples)
+		Expect(call(subject.denyOOM(subject.set), "SET", "x", "1")).To(MatchError("OOM command not allowed when used memory > 'maxmemory'."))
+		Expect(call(subject.del, "DEL", "k0", "k1")).To(Equal(int64(2)))
+		Expect(call(subject.denyOOM(subject.set), "SET", "x", "1")).To(Equal("OK"))
+	})
+
+	It("should evict the least recently used keys", func() {
+		for i := 0; i < 10; i++ {
+			age("k"+strconv.Itoa(i), time.Duration(i+1)*time.Minute)
+		}
+		subject.setEviction(80, allKeysLRU, 10)
+
+		Expect(call(subject.denyOOM(subject.get), "GET", "k0")).To(Equal("0123456789"))
+		Expect(subject.keys.len()).To(Equal(6))
+		Expect(subject.info.EvictedKeys()).To(Equal(int64(4)))
+		for i := 0; i < 6; i++ {
+			Expect(call(subject.exists, "EXISTS", "k"+strconv.Itoa(i))).To(Equal(int64(1)))
+		}
+	})
+
+	It("should evict the least frequently used keys", func() {
+		subject.setEviction(0, allKeysLFU, 10)
+		for i := 0; i < 10; i++ {
+			e, _ := subject.keys.get("k" + strconv.Itoa(i))
+			e.setFreq(uint8(100-i), time.Now())
+		}
+		subject.setEviction(100, allKeysLFU, 10)
+
+		Expect(subject.evictKeys()).To(BeTrue())
+		Expect(subject.keys.len()).To(Equal(8))
+		Expect(call(subject.exists, "EXISTS", "k8", "k9")).To(Equal(int64(0)))
+	})
+
+	It("should evict volatile keys", func() {
+		Expect(call(subject.expire("ex"), "EXPIRE", "k1", "100")).To(Equal(int64(1)))
+		Expect(call(subject.expire("ex"), "EXPIRE", "k2", "10")).To(Equal(int64(1)))
+		subject.setEviction(110, volatileTTL, 10)
+
+		Expect(subject.evictKeys()).To(BeTrue())
+		Expect(call(subject.exists, "EXISTS", "k1", "k2")).To(Equal(int64(1)))
+		Expect(call(subject.exists, "EXISTS", "k1")).To(Equal(int64(1)))
+
+		subject.setEviction(50, volatileRandom, 10)
+		Expect(subject.evictKeys()).To(BeFalse())
+		Expect(subject.keys.len()).To(Equal(8))
+	})
+
+	It("should evict random keys", func() {
+		subject.setEviction(50, allKeysRandom, defaultEvictionSamples)
+		Expect(subject.evictKeys()).To(BeTrue())
+		Expect(subject.keys.len()).To(Equal(4))
+	})
+
+	It("should parse memory sizes", func() {
+		for s, n := range map[string]int64{
+			"0": 0, "100": 100, "1k": 1000, "1KB": 1024, "2mb": 2 << 20, "1g": 1e9, "3gb": 3 << 30, "7b": 7,
+		} {
+			Expect(parseMemory(s)).To(Equal(n), s)
+		}
+		_, err := parseMemory("1tb")
+		Expect(err).To(HaveOccurred())
+		_, err = parseMemory("-1")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should load the configuration", func() {
+		dir, err := ioutil.TempDir("", "redeo-server")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "redis.conf")
+		Expect(ioutil.WriteFile(path, []byte("maxmemory 1mb\nmaxmemory-policy volatile-lfu\n"), 0600)).To(Succeed())
+		file, err := redeo.ReadConfigFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.configureEviction(file)).To(Succeed())
+		Expect(subject.evict.maxMemory).To(Equal(int64(1 << 20)))
+		Expect(subject.evictionPolicy()).To(Equal(volatileLFU))
+
+		Expect(ioutil.WriteFile(path, []byte("maxmemory-policy lru\n"), 0600)).To(Succeed())
+		Expect(file.Reload()).To(Succeed())
+		Expect(subject.configureEviction(file)).To(MatchError(ContainSubstring(`unknown policy "lru"`)))
+
+		Expect(subject.configureEviction(nil)).To(Succeed())
+		Expect(subject.evictionPolicy()).To(Equal(noEviction))
+	})
+})
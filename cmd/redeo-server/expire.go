@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// errExpireTime is the error reply to invalid expiration times, with the
+// command name
+const errExpireTime = "ERR invalid expire time in '%s' command"
+
+// parseDeadline parses the value of an EX, PX, EXAT or PXAT option into
+// a deadline in unix nanoseconds. It returns an error reply on failure.
+func parseDeadline(cmd, opt string, arg resp.CommandArgument, now time.Time) (int64, string) {
+	n, err := arg.Int()
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if n <= 0 {
+		return 0, fmt.Sprintf(errExpireTime, strings.ToLower(cmd))
+	}
+	return toDeadline(cmd, opt, n, now)
+}
+
+// toDeadline converts n, in the unit of an EX, PX, EXAT or PXAT option,
+// into a deadline in unix nanoseconds. Deadlines may be in the past.
+func toDeadline(cmd, opt string, n int64, now time.Time) (int64, string) {
+	unit := int64(time.Millisecond)
+	if opt == "ex" || opt == "exat" {
+		unit = int64(time.Second)
+	}
+	if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+		return 0, fmt.Sprintf(errExpireTime, strings.ToLower(cmd))
+	}
+
+	deadline := n * unit
+	if opt == "ex" || opt == "px" {
+		if deadline > math.MaxInt64-now.UnixNano() {
+			return 0, fmt.Sprintf(errExpireTime, strings.ToLower(cmd))
+		}
+		deadline += now.UnixNano()
+	}
+	return deadline, ""
+}
+
+// expire returns a handler for EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT,
+// opt is the equivalent SET option. Keys with deadlines in the past are
+// deleted. Like in Redis, they are propagated as PEXPIREAT or DEL.
+// https://redis.io/commands/expire
+func (s *store) expire(opt string) redeo.HandlerFunc {
+	return func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() < 2 {
+			w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		n, err := c.Arg(1).Int()
+		if err != nil {
+			w.AppendError(errNotInteger)
+			return
+		}
+
+		var nx, xx, gt, lt bool
+		for _, arg := range c.Args[2:] {
+			switch strings.ToLower(arg.String()) {
+			case "nx":
+				nx = true
+			case "xx":
+				xx = true
+			case "gt":
+				gt = true
+			case "lt":
+				lt = true
+			default:
+				w.AppendErrorf("ERR Unsupported option %s", arg)
+				return
+			}
+		}
+		if nx && (xx || gt || lt) {
+			w.AppendError("ERR NX and XX, GT or LT options at the same time are not compatible")
+			return
+		}
+		if gt && lt {
+			w.AppendError("ERR GT and LT options at the same time are not compatible")
+			return
+		}
+
+		now := s.keys.now()
+		deadline, msg := toDeadline(c.Name, opt, n, now)
+		if msg != "" {
+			w.AppendError(msg)
+			return
+		}
+
+		var res int64
+		var deleted bool
+		s.keys.modify(c.Arg(0).String(), func(cur *entry) (*entry, bool) {
+			switch {
+			case cur == nil:
+				return nil, false
+			case nx && cur.expires != 0, xx && cur.expires == 0:
+				return cur, false
+			case gt && (cur.expires == 0 || deadline <= cur.expires):
+				return cur, false
+			case lt && cur.expires != 0 && deadline >= cur.expires:
+				return cur, false
+			}
+
+			res = 1
+			if deadline <= now.UnixNano() {
+				deleted = true
+				return nil, true
+			}
+			next := cur.clone()
+			next.expires = deadline
+			return next, true
+		})
+
+		// relative deadlines depend on the time of execution
+		rewrite(c, func() []*resp.Command {
+			switch {
+			case res == 0:
+				return nil
+			case deleted:
+				return []*resp.Command{resp.NewCommand("DEL", c.Arg(0))}
+			}
+			return []*resp.Command{resp.NewCommand("PEXPIREAT", c.Arg(0), unixMillis(deadline))}
+		})
+		w.AppendInt(res)
+	}
+}
+
+func (s *store) persist(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var res int64
+	s.keys.modify(c.Arg(0).String(), func(cur *entry) (*entry, bool) {
+		if cur == nil || cur.expires == 0 {
+			return cur, false
+		}
+		res = 1
+		next := cur.clone()
+		next.expires = 0
+		return next, true
+	})
+	w.AppendInt(res)
+}
+
+func (s *store) expiretime(w resp.ResponseWriter, c *resp.Command) {
+	s.replyDeadline(w, c, time.Second)
+}
+
+func (s *store) pexpiretime(w resp.ResponseWriter, c *resp.Command) {
+	s.replyDeadline(w, c, time.Millisecond)
+}
+
+// replyDeadline replies with the deadline of a key as a unix timestamp in
+// units, -1 if the key does not expire and -2 if it does not exist
+func (s *store) replyDeadline(w resp.ResponseWriter, c *resp.Command, unit time.Duration) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	e, ok := s.keys.get(c.Arg(0).String())
+	switch {
+	case !ok:
+		w.AppendInt(-2)
+	case e.expires == 0:
+		w.AppendInt(-1)
+	default:
+		w.AppendInt(e.expires / int64(unit))
+	}
+}
+
+func (s *store) ttl(w resp.ResponseWriter, c *resp.Command) { s.replyTTL(w, c, time.Second) }
+
+func (s *store) pttl(w resp.ResponseWriter, c *resp.Command) { s.replyTTL(w, c, time.Millisecond) }
+
+// replyTTL replies with the remaining TTL of a key in units, -1 if the key
+// does not expire and -2 if it does not exist
+func (s *store) replyTTL(w resp.ResponseWriter, c *resp.Command, unit time.Duration) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	e, ok := s.keys.get(c.Arg(0).String())
+	switch {
+	case !ok:
+		w.AppendInt(-2)
+	case e.expires == 0:
+		w.AppendInt(-1)
+	default:
+		ttl := time.Duration(e.expires - s.keys.now().UnixNano())
+		if ttl < 0 {
+			ttl = 0
+		}
+		w.AppendInt(int64((ttl + unit/2) / unit))
+	}
+}
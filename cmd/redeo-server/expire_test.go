@@ -0,0 +1,76 @@
+package main
+
+import (
+	"time"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("expire", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+	})
+
+	It("should reply with TTLs", func() {
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(-2)))
+		Expect(call(subject.pttl, "PTTL", "k")).To(Equal(int64(-2)))
+		Expect(call(subject.set, "SET", "k", "v")).To(Equal("OK"))
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(-1)))
+		Expect(call(subject.set, "SET", "k", "v", "PX", "10500")).To(Equal("OK"))
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(10)))
+		Expect(call(subject.pttl, "PTTL", "k")).To(BeNumerically("~", 10500, 100))
+	})
+
+	It("should expire keys", func() {
+		expire, pexpireat := subject.expire("ex"), subject.expire("pxat")
+		Expect(call(expire, "EXPIRE", "k", "100")).To(Equal(int64(0)))
+		Expect(call(subject.set, "SET", "k", "v")).To(Equal("OK"))
+		Expect(call(expire, "EXPIRE", "k", "100", "XX")).To(Equal(int64(0)))
+		Expect(call(expire, "EXPIRE", "k", "100", "GT")).To(Equal(int64(0)))
+		Expect(call(expire, "EXPIRE", "k", "100", "NX")).To(Equal(int64(1)))
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(100)))
+		Expect(call(expire, "EXPIRE", "k", "200", "LT")).To(Equal(int64(0)))
+		Expect(call(expire, "EXPIRE", "k", "200", "GT")).To(Equal(int64(1)))
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(200)))
+
+		Expect(call(pexpireat, "PEXPIREAT", "k", "4102444800000")).To(Equal(int64(1)))
+		Expect(call(subject.expiretime, "EXPIRETIME", "k")).To(Equal(int64(4102444800)))
+		Expect(call(subject.pexpiretime, "PEXPIRETIME", "k")).To(Equal(int64(4102444800000)))
+		Expect(call(subject.persist, "PERSIST", "k")).To(Equal(int64(1)))
+		Expect(call(subject.persist, "PERSIST", "k")).To(Equal(int64(0)))
+		Expect(call(subject.expiretime, "EXPIRETIME", "k")).To(Equal(int64(-1)))
+		Expect(call(subject.expiretime, "EXPIRETIME", "x")).To(Equal(int64(-2)))
+
+		Expect(call(expire, "EXPIRE", "k", "-1")).To(Equal(int64(1)))
+		Expect(call(subject.exists, "EXISTS", "k")).To(Equal(int64(0)))
+	})
+
+	It("should reject invalid options", func() {
+		expire := subject.expire("ex")
+		Expect(call(expire, "EXPIRE", "k", "x")).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(call(expire, "EXPIRE", "k", "1", "FOO")).To(MatchError("ERR Unsupported option FOO"))
+		Expect(call(expire, "EXPIRE", "k", "1", "NX", "GT")).To(MatchError("ERR NX and XX, GT or LT options at the same time are not compatible"))
+		Expect(call(expire, "EXPIRE", "k", "1", "GT", "LT")).To(MatchError("ERR GT and LT options at the same time are not compatible"))
+		Expect(call(expire, "EXPIRE", "k", "9223372036854775807")).To(MatchError("ERR invalid expire time in 'expire' command"))
+	})
+
+	It("should follow the server's clock", func() {
+		clock := redeo.NewMockClock(time.Unix(1700000000, 0))
+		subject = newStore(redeo.NewServer(&redeo.Config{Clock: clock}).Info())
+		Expect(call(subject.set, "SET", "k", "v", "EX", "10")).To(Equal("OK"))
+		Expect(call(subject.expiretime, "EXPIRETIME", "k")).To(Equal(int64(1700000010)))
+
+		clock.Add(9 * time.Second)
+		Expect(call(subject.pttl, "PTTL", "k")).To(Equal(int64(1000)))
+		oi, _ := subject.Inspect("k")
+		Expect(oi.IdleTime).To(Equal(9 * time.Second))
+
+		clock.Add(time.Second)
+		Expect(call(subject.exists, "EXISTS", "k")).To(Equal(int64(0)))
+	})
+
+})
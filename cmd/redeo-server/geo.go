@@ -0,0 +1,536 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Geospatial indexes are sorted sets, scored by the 52-bit geohash of
+// each member's position: the latitude and longitude cell indexes, 26
+// bits each, interleaved with the latitude in the even bits, like Redis.
+// Positions are reported as the centres of their cells. Searches scan
+// the score ranges of the cells covering the bounding box of the shape.
+// https://github.com/redis/redis/blob/unstable/src/geo.c
+const (
+	geoStepMax = 26
+
+	geoLatMin = -85.05112878
+	geoLatMax = 85.05112878
+	geoLonMin = -180
+	geoLonMax = 180
+
+	// geoEarthRadius is the radius of the earth in meters, as used by
+	// Redis' distance calculations
+	geoEarthRadius = 6372797.560856
+)
+
+// Error replies of geo commands
+const (
+	errGeoUnit   = "ERR unsupported unit provided. please use M, KM, FT, MI"
+	errGeoMember = "ERR could not decode requested zset member"
+)
+
+// geoUnits are the supported units and their length in meters
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+// geoSpread moves the bits of v to the even bits of the result
+func geoSpread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// geoSquash is the inverse of geoSpread, odd bits are ignored
+func geoSquash(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
+
+// geoCell returns the index of the cell containing v, in a range divided
+// into 2^step cells
+func geoCell(v, min, max float64, step uint) uint32 {
+	n := (v - min) / (max - min) * float64(uint64(1)<<step)
+	if n >= float64(uint64(1)<<step) {
+		n = float64(uint64(1)<<step - 1)
+	} else if n < 0 {
+		n = 0
+	}
+	return uint32(n)
+}
+
+// geoEncode returns the geohash of a valid position
+func geoEncode(lon, lat float64) uint64 {
+	latOff := (lat - geoLatMin) / (geoLatMax - geoLatMin) * (1 << geoStepMax)
+	lonOff := (lon - geoLonMin) / (geoLonMax - geoLonMin) * (1 << geoStepMax)
+	return geoSpread(uint32(latOff)) | geoSpread(uint32(lonOff))<<1
+}
+
+// geoDecode returns the centre of the cell of a geohash
+func geoDecode(hash uint64) (lon, lat float64) {
+	ilat, ilon := geoSquash(hash), geoSquash(hash>>1)
+
+	const scale = 1 << geoStepMax
+	latMin := geoLatMin + float64(ilat)/scale*(geoLatMax-geoLatMin)
+	latMax := geoLatMin + float64(ilat+1)/scale*(geoLatMax-geoLatMin)
+	lonMin := geoLonMin + float64(ilon)/scale*(geoLonMax-geoLonMin)
+	lonMax := geoLonMin + float64(ilon+1)/scale*(geoLonMax-geoLonMin)
+
+	lon = math.Max(geoLonMin, math.Min(geoLonMax, (lonMin+lonMax)/2))
+	lat = math.Max(geoLatMin, math.Min(geoLatMax, (latMin+latMax)/2))
+	return lon, lat
+}
+
+func geoRad(deg float64) float64 { return deg * math.Pi / 180 }
+func geoDeg(rad float64) float64 { return rad * 180 / math.Pi }
+
+// geoDistance returns the haversine distance between two positions in
+// meters
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := geoRad(lat1), geoRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((geoRad(lon2) - geoRad(lon1)) / 2)
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// parseGeoPos parses and validates a longitude and latitude, it returns
+// an error reply on failure
+func parseGeoPos(lonArg, latArg resp.CommandArgument) (lon, lat float64, msg string) {
+	lon, err1 := strconv.ParseFloat(lonArg.String(), 64)
+	lat, err2 := strconv.ParseFloat(latArg.String(), 64)
+	if err1 != nil || err2 != nil || math.IsNaN(lon) || math.IsNaN(lat) {
+		return 0, 0, errNotFloat
+	}
+	if lon < geoLonMin || lon > geoLonMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, ""
+}
+
+// parseGeoUnit returns the length of a unit in meters
+func parseGeoUnit(arg resp.CommandArgument) (float64, bool) {
+	n, ok := geoUnits[strings.ToLower(arg.String())]
+	return n, ok
+}
+
+// formatGeoCoord formats a coordinate like Redis, with up to 17 decimal
+// places
+func formatGeoCoord(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// geoShape is the area of a search, centred on a position
+type geoShape struct {
+	lon, lat float64
+
+	// radius is the radius of circles, in meters
+	radius float64
+	// width and height are the dimensions of boxes, in meters
+	width, height float64
+	box           bool
+}
+
+// distance returns the distance of a position from the centre, or false
+// if it is outside the shape
+func (sh *geoShape) distance(lon, lat float64) (float64, bool) {
+	if !sh.box {
+		d := geoDistance(sh.lon, sh.lat, lon, lat)
+		return d, d <= sh.radius
+	}
+
+	if geoEarthRadius*math.Abs(geoRad(lat)-geoRad(sh.lat)) > sh.height/2 {
+		return 0, false
+	}
+	if geoDistance(sh.lon, lat, lon, lat) > sh.width/2 {
+		return 0, false
+	}
+	return geoDistance(sh.lon, sh.lat, lon, lat), true
+}
+
+// ranges returns the score ranges of the cells covering the bounding box
+// of the shape
+func (sh *geoShape) ranges() []scoreRange {
+	height, width := sh.radius, sh.radius
+	if sh.box {
+		height, width = sh.height/2, sh.width/2
+	}
+
+	latDelta := geoDeg(height / geoEarthRadius)
+	latMin, latMax := sh.lat-latDelta, sh.lat+latDelta
+
+	// the longitude delta is largest at the edge closer to the pole
+	edge := math.Max(math.Abs(latMin), math.Abs(latMax))
+	lonDelta := geoDeg(width / geoEarthRadius / math.Cos(geoRad(edge)))
+	latMin, latMax = math.Max(latMin, geoLatMin), math.Min(latMax, geoLatMax)
+
+	var res []scoreRange
+	switch lonMin, lonMax := sh.lon-lonDelta, sh.lon+lonDelta; {
+	case edge >= 90 || lonDelta >= 180:
+		res = geoRanges(res, geoLonMin, geoLonMax, latMin, latMax)
+	case lonMin < geoLonMin:
+		res = geoRanges(res, lonMin+360, geoLonMax, latMin, latMax)
+		res = geoRanges(res, geoLonMin, lonMax, latMin, latMax)
+	case lonMax > geoLonMax:
+		res = geoRanges(res, lonMin, geoLonMax, latMin, latMax)
+		res = geoRanges(res, geoLonMin, lonMax-360, latMin, latMax)
+	default:
+		res = geoRanges(res, lonMin, lonMax, latMin, latMax)
+	}
+	return res
+}
+
+// geoRanges appends the score ranges of the cells covering a box, at the
+// finest precision which needs no more than 9 cells
+func geoRanges(res []scoreRange, lonMin, lonMax, latMin, latMax float64) []scoreRange {
+	step := uint(geoStepMax)
+	for ; step > 1; step-- {
+		x := geoCell(lonMax, geoLonMin, geoLonMax, step) - geoCell(lonMin, geoLonMin, geoLonMax, step) + 1
+		y := geoCell(latMax, geoLatMin, geoLatMax, step) - geoCell(latMin, geoLatMin, geoLatMax, step) + 1
+		if x*y <= 9 {
+			break
+		}
+	}
+
+	shift := 2 * (geoStepMax - step)
+	for x := geoCell(lonMin, geoLonMin, geoLonMax, step); x <= geoCell(lonMax, geoLonMin, geoLonMax, step); x++ {
+		for y := geoCell(latMin, geoLatMin, geoLatMax, step); y <= geoCell(latMax, geoLatMin, geoLatMax, step); y++ {
+			hash := geoSpread(y) | geoSpread(x)<<1
+			res = append(res, scoreRange{
+				min:   float64(hash << shift),
+				max:   float64((hash + 1) << shift),
+				maxex: true,
+			})
+		}
+	}
+	return res
+}
+
+// geoadd implements GEOADD key [NX|XX] [CH] longitude latitude member
+// [longitude latitude member ...]
+// https://redis.io/commands/geoadd
+func (s *store) geoadd(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 4 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var f zaddFlags
+	args := c.Args[1:]
+	for ; len(args) != 0; args = args[1:] {
+		opt := strings.ToLower(args[0].String())
+		if opt == "nx" {
+			f.nx = true
+		} else if opt == "xx" {
+			f.xx = true
+		} else if opt == "ch" {
+			f.ch = true
+		} else {
+			break
+		}
+	}
+	if len(args) == 0 || len(args)%3 != 0 {
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	scores := make([]float64, 0, len(args)/3)
+	members := make([]string, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		lon, lat, msg := parseGeoPos(args[i], args[i+1])
+		if msg != "" {
+			w.AppendError(msg)
+			return
+		}
+		scores = append(scores, float64(geoEncode(lon, lat)))
+		members = append(members, args[i+2].String())
+	}
+	s.zaddPairs(w, c.Arg(0).String(), f, scores, members)
+}
+
+func (s *store) geopos(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "zset", func(e *entry) {
+		var z *zset
+		if e != nil {
+			z = e.obj.(*zset)
+		}
+
+		w.AppendArrayLen(c.ArgN() - 1)
+		for _, arg := range c.Args[1:] {
+			score, ok := 0.0, false
+			if z != nil {
+				score, ok = z.score(arg.String())
+			}
+			if !ok {
+				resp.AppendNilArray(w)
+				continue
+			}
+
+			lon, lat := geoDecode(uint64(score))
+			w.AppendArrayLen(2)
+			w.AppendBulkString(formatGeoCoord(lon))
+			w.AppendBulkString(formatGeoCoord(lat))
+		}
+	})
+}
+
+// geodist implements GEODIST key member1 member2 [M|KM|FT|MI]
+// https://redis.io/commands/geodist
+func (s *store) geodist(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 3 && c.ArgN() != 4 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	unit := 1.0
+	if c.ArgN() == 4 {
+		var ok bool
+		if unit, ok = parseGeoUnit(c.Arg(3)); !ok {
+			w.AppendError(errGeoUnit)
+			return
+		}
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "zset", func(e *entry) {
+		if e == nil {
+			w.AppendNil()
+			return
+		}
+
+		z := e.obj.(*zset)
+		score1, ok1 := z.score(c.Arg(1).String())
+		score2, ok2 := z.score(c.Arg(2).String())
+		if !ok1 || !ok2 {
+			w.AppendNil()
+			return
+		}
+
+		lon1, lat1 := geoDecode(uint64(score1))
+		lon2, lat2 := geoDecode(uint64(score2))
+		w.AppendBulkString(strconv.FormatFloat(geoDistance(lon1, lat1, lon2, lat2)/unit, 'f', 4, 64))
+	})
+}
+
+// geoSearchArgs is the number of arguments of GEOSEARCH options
+var geoSearchArgs = map[string]int{
+	"frommember": 1,
+	"fromlonlat": 2,
+	"byradius":   2,
+	"bybox":      3,
+	"count":      1,
+}
+
+// geoResult is a member found by GEOSEARCH
+type geoResult struct {
+	member   string
+	dist     float64
+	hash     uint64
+	lon, lat float64
+}
+
+// geosearch implements GEOSEARCH key FROMMEMBER member|FROMLONLAT
+// longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+// https://redis.io/commands/geosearch
+func (s *store) geosearch(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var shape geoShape
+	var fromMember string
+	var fromMemberSet, fromLonLat, byRadius, byBox, anyMatch, withCoord, withDist, withHash bool
+	var count int64
+	var unit float64
+	sortDir := 0
+	for i := 1; i < c.ArgN(); i++ {
+		opt := strings.ToLower(c.Arg(i).String())
+		need := geoSearchArgs[opt]
+		if i+need >= c.ArgN() {
+			w.AppendError("ERR syntax error")
+			return
+		}
+
+		var msg string
+		var ok bool
+		switch opt {
+		case "frommember":
+			fromMember, fromMemberSet = c.Arg(i+1).String(), true
+		case "fromlonlat":
+			shape.lon, shape.lat, msg = parseGeoPos(c.Arg(i+1), c.Arg(i+2))
+			fromLonLat = true
+		case "byradius":
+			var err error
+			if shape.radius, err = strconv.ParseFloat(c.Arg(i+1).String(), 64); err != nil {
+				msg = "ERR need numeric radius"
+			} else if shape.radius < 0 {
+				msg = "ERR radius cannot be negative"
+			} else if unit, ok = parseGeoUnit(c.Arg(i + 2)); !ok {
+				msg = errGeoUnit
+			}
+			byRadius = true
+		case "bybox":
+			var err1, err2 error
+			shape.width, err1 = strconv.ParseFloat(c.Arg(i+1).String(), 64)
+			shape.height, err2 = strconv.ParseFloat(c.Arg(i+2).String(), 64)
+			if err1 != nil || err2 != nil {
+				msg = errNotFloat
+			} else if shape.width < 0 || shape.height < 0 {
+				msg = "ERR height or width cannot be negative"
+			} else if unit, ok = parseGeoUnit(c.Arg(i + 3)); !ok {
+				msg = errGeoUnit
+			}
+			byBox, shape.box = true, true
+		case "asc":
+			sortDir = 1
+		case "desc":
+			sortDir = -1
+		case "count":
+			var err error
+			if count, err = c.Arg(i + 1).Int(); err != nil {
+				msg = errNotInteger
+			} else if count <= 0 {
+				msg = "ERR COUNT must be > 0"
+			} else if i+2 < c.ArgN() && strings.EqualFold(c.Arg(i+2).String(), "any") {
+				anyMatch = true
+				i++
+			}
+		case "withcoord":
+			withCoord = true
+		case "withdist":
+			withDist = true
+		case "withhash":
+			withHash = true
+		default:
+			msg = "ERR syntax error"
+		}
+		if msg != "" {
+			w.AppendError(msg)
+			return
+		}
+		i += need
+	}
+
+	switch {
+	case fromMemberSet == fromLonLat:
+		w.AppendError("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+		return
+	case byRadius == byBox:
+		w.AppendError("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+		return
+	}
+	shape.radius *= unit
+	shape.width *= unit
+	shape.height *= unit
+
+	// without ANY, the closest members are returned
+	if count != 0 && sortDir == 0 && !anyMatch {
+		sortDir = 1
+	}
+
+	var results []geoResult
+	var msg string
+	if !s.viewTyped(w, c.Arg(0).String(), "zset", func(e *entry) {
+		if e == nil {
+			return
+		}
+
+		z := e.obj.(*zset)
+		if fromMemberSet {
+			score, ok := z.score(fromMember)
+			if !ok {
+				msg = errGeoMember
+				return
+			}
+			shape.lon, shape.lat = geoDecode(uint64(score))
+		}
+
+		for _, r := range shape.ranges() {
+			for x := z.zsl.firstInRange(&r); x != nil && r.lteMax(x); x = x.level[0].forward {
+				hash := uint64(x.score)
+				lon, lat := geoDecode(hash)
+				dist, ok := shape.distance(lon, lat)
+				if !ok {
+					continue
+				}
+				results = append(results, geoResult{member: x.member, dist: dist, hash: hash, lon: lon, lat: lat})
+				if anyMatch && int64(len(results)) == count {
+					return
+				}
+			}
+		}
+	}) {
+		return
+	}
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	if sortDir != 0 {
+		sort.SliceStable(results, func(i, j int) bool {
+			if sortDir < 0 {
+				return results[i].dist > results[j].dist
+			}
+			return results[i].dist < results[j].dist
+		})
+	}
+	if count != 0 && int64(len(results)) > count {
+		results = results[:count]
+	}
+
+	w.AppendArrayLen(len(results))
+	for _, r := range results {
+		n := 1
+		for _, with := range []bool{withDist, withHash, withCoord} {
+			if with {
+				n++
+			}
+		}
+		if n == 1 {
+			w.AppendBulkString(r.member)
+			continue
+		}
+
+		w.AppendArrayLen(n)
+		w.AppendBulkString(r.member)
+		if withDist {
+			w.AppendBulkString(strconv.FormatFloat(r.dist/unit, 'f', 4, 64))
+		}
+		if withHash {
+			w.AppendInt(int64(r.hash))
+		}
+		if withCoord {
+			w.AppendArrayLen(2)
+			w.AppendBulkString(formatGeoCoord(r.lon))
+			w.AppendBulkString(formatGeoCoord(r.lat))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("geo", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.geoadd, "GEOADD", "Sicily",
+			"13.361389", "38.115556", "Palermo",
+			"15.087269", "37.502669", "Catania",
+		)).To(Equal(int64(2)))
+	})
+
+	It("should add positions", func() {
+		Expect(call(subject.zscore, "ZSCORE", "Sicily", "Palermo")).To(Equal("3479099956230698"))
+		Expect(call(subject.geoadd, "GEOADD", "Sicily", "NX", "CH", "13.361389", "38.115556", "Palermo", "0", "0", "Null")).To(Equal(int64(1)))
+		Expect(call(subject.geoadd, "GEOADD", "Sicily", "XX", "CH", "1", "1", "Null", "1", "1", "Other")).To(Equal(int64(1)))
+		Expect(call(subject.zcard, "ZCARD", "Sicily")).To(Equal(int64(3)))
+
+		Expect(call(subject.geoadd, "GEOADD", "Sicily", "181", "0", "x")).To(MatchError("ERR invalid longitude,latitude pair 181.000000,0.000000"))
+		Expect(call(subject.geoadd, "GEOADD", "Sicily", "0", "86", "x")).To(MatchError("ERR invalid longitude,latitude pair 0.000000,86.000000"))
+		Expect(call(subject.geoadd, "GEOADD", "Sicily", "NX", "0", "0")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.geoadd, "GEOADD", "Sicily", "x", "0", "y")).To(MatchError("ERR value is not a valid float"))
+	})
+
+	It("should report positions and distances", func() {
+		Expect(call(subject.geopos, "GEOPOS", "Sicily", "Palermo", "Catania", "NonExisting")).To(Equal([]interface{}{
+			[]interface{}{"13.36138933897018433", "38.11555639549629859"},
+			[]interface{}{"15.08726745843887329", "37.50266842333162032"},
+			nil,
+		}))
+		Expect(call(subject.geopos, "GEOPOS", "x", "Palermo")).To(Equal([]interface{}{nil}))
+
+		Expect(call(subject.geodist, "GEODIST", "Sicily", "Palermo", "Catania")).To(Equal("166274.1516"))
+		Expect(call(subject.geodist, "GEODIST", "Sicily", "Palermo", "Catania", "km")).To(Equal("166.2742"))
+		Expect(call(subject.geodist, "GEODIST", "Sicily", "Palermo", "Catania", "MI")).To(Equal("103.3182"))
+		Expect(call(subject.geodist, "GEODIST", "Sicily", "Palermo", "x")).To(BeNil())
+		Expect(call(subject.geodist, "GEODIST", "Sicily", "Palermo", "Catania", "yd")).To(MatchError(errGeoUnit))
+	})
+
+	It("should search by radius", func() {
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC")).To(Equal([]interface{}{
+			"Catania", "Palermo",
+		}))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km", "WITHDIST", "WITHHASH")).To(Equal([]interface{}{
+			[]interface{}{"Catania", "56.4413", int64(3479447370796909)},
+		}))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "200000", "m", "DESC", "COUNT", "1")).To(Equal([]interface{}{
+			"Catania",
+		}))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "10", "m")).To(Equal([]interface{}{
+			"Palermo",
+		}))
+		Expect(call(subject.geosearch, "GEOSEARCH", "x", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km")).To(BeEmpty())
+	})
+
+	It("should search by box", func() {
+		Expect(call(subject.geoadd, "GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")).To(Equal(int64(2)))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHCOORD", "WITHDIST")).To(Equal([]interface{}{
+			[]interface{}{"Catania", "56.4413", []interface{}{"15.08726745843887329", "37.50266842333162032"}},
+			[]interface{}{"Palermo", "190.4424", []interface{}{"13.36138933897018433", "38.11555639549629859"}},
+			[]interface{}{"edge2", "279.7403", []interface{}{"17.24151045083999634", "38.78813451624225195"}},
+			[]interface{}{"edge1", "279.7405", []interface{}{"12.7584877610206604", "38.78813451624225195"}},
+		}))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "200", "400", "km")).To(ConsistOf("Catania"))
+	})
+
+	It("should search across the antimeridian", func() {
+		Expect(call(subject.geoadd, "GEOADD", "pacific", "179.9", "0", "east", "-179.9", "0", "west", "170", "0", "far")).To(Equal(int64(3)))
+		Expect(call(subject.geosearch, "GEOSEARCH", "pacific", "FROMMEMBER", "east", "BYRADIUS", "50", "km", "ASC")).To(Equal([]interface{}{
+			"east", "west",
+		}))
+	})
+
+	It("should reject invalid searches", func() {
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "BYRADIUS", "1", "km")).To(MatchError("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37")).To(MatchError("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMMEMBER", "x", "BYRADIUS", "1", "km")).To(MatchError(errGeoMember))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "-1", "km")).To(MatchError("ERR radius cannot be negative"))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "yd")).To(MatchError(errGeoUnit))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "COUNT", "0")).To(MatchError("ERR COUNT must be > 0"))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "ANY")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.geosearch, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1")).To(MatchError("ERR syntax error"))
+	})
+
+})
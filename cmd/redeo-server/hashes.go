@@ -0,0 +1,200 @@
+package main
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// hashEntrySize is the approximate overhead of each hash field
+const hashEntrySize = 64
+
+// hash is a hash object, like Redis' hashtable encoding
+type hash struct {
+	fields map[string]string
+
+	// bytes is the approximate size, updated atomically
+	bytes int64
+}
+
+func newHash() *hash {
+	return &hash{fields: make(map[string]string)}
+}
+
+func (h *hash) typ() string      { return "hash" }
+func (h *hash) encoding() string { return "hashtable" }
+func (h *hash) size() int64      { return atomic.LoadInt64(&h.bytes) }
+
+func (h *hash) dup() object {
+	res := newHash()
+	for field, val := range h.fields {
+		res.set(field, val)
+	}
+	return res
+}
+
+// len returns the number of fields
+func (h *hash) len() int { return len(h.fields) }
+
+// get returns the value of field
+func (h *hash) get(field string) (string, bool) {
+	val, ok := h.fields[field]
+	return val, ok
+}
+
+// set sets field to val, it reports whether the field was added
+func (h *hash) set(field, val string) bool {
+	old, exists := h.fields[field]
+	h.fields[field] = val
+	if exists {
+		atomic.AddInt64(&h.bytes, int64(len(val)-len(old)))
+	} else {
+		atomic.AddInt64(&h.bytes, int64(len(field)+len(val)+hashEntrySize))
+	}
+	return !exists
+}
+
+// remove removes field, it reports whether it existed
+func (h *hash) remove(field string) bool {
+	val, ok := h.fields[field]
+	if !ok {
+		return false
+	}
+	delete(h.fields, field)
+	atomic.AddInt64(&h.bytes, -int64(len(field)+len(val)+hashEntrySize))
+	return true
+}
+
+// sortedFields returns the field names in lexicographical order
+func (h *hash) sortedFields() []string {
+	fields := make([]string, 0, len(h.fields))
+	for field := range h.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// hset implements HSET key field value [field value ...], it replies
+// with the number of fields added
+// https://redis.io/commands/hset
+func (s *store) hset(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 3 || c.ArgN()%2 != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int64
+	if !s.modifyTyped(w, c.Arg(0).String(), "hash", func(cur *entry) (*entry, bool) {
+		h := newHash()
+		if cur != nil {
+			h = cur.obj.(*hash)
+		}
+
+		for i := 1; i < c.ArgN(); i += 2 {
+			if h.set(c.Arg(i).String(), c.Arg(i+1).String()) {
+				n++
+			}
+		}
+		if cur == nil {
+			e := newEntry(nil)
+			e.obj = h
+			return e, true
+		}
+		return cur, true
+	}) {
+		return
+	}
+	w.AppendInt(n)
+}
+
+func (s *store) hget(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "hash", func(e *entry) {
+		if e == nil {
+			w.AppendNil()
+			return
+		}
+		if val, ok := e.obj.(*hash).get(c.Arg(1).String()); ok {
+			w.AppendBulkString(val)
+			return
+		}
+		w.AppendNil()
+	})
+}
+
+func (s *store) hdel(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int64
+	if !s.modifyTyped(w, c.Arg(0).String(), "hash", func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		h := cur.obj.(*hash)
+
+		for _, arg := range c.Args[1:] {
+			if h.remove(arg.String()) {
+				n++
+			}
+		}
+		if h.len() == 0 {
+			return nil, true
+		}
+		return cur, n != 0
+	}) {
+		return
+	}
+	w.AppendInt(n)
+}
+
+func (s *store) hlen(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "hash", func(e *entry) {
+		if e == nil {
+			w.AppendInt(0)
+			return
+		}
+		w.AppendInt(int64(e.obj.(*hash).len()))
+	})
+}
+
+// hgetall implements HGETALL key, fields are replied in lexicographical
+// order
+// https://redis.io/commands/hgetall
+func (s *store) hgetall(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "hash", func(e *entry) {
+		if e == nil {
+			resp.AppendMapLen(w, 0)
+			return
+		}
+		appendHash(w, e.obj.(*hash))
+	})
+}
+
+// appendHash appends the fields of h as a map
+func appendHash(w resp.ResponseWriter, h *hash) {
+	resp.AppendMapLen(w, h.len())
+	for _, field := range h.sortedFields() {
+		w.AppendBulkString(field)
+		w.AppendBulkString(h.fields[field])
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("hashes", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.hset, "HSET", "h", "b", "2", "a", "1")).To(Equal(int64(2)))
+	})
+
+	It("should set and get fields", func() {
+		Expect(call(subject.hset, "HSET", "h", "a", "3", "c", "4")).To(Equal(int64(1)))
+		Expect(call(subject.hget, "HGET", "h", "a")).To(Equal("3"))
+		Expect(call(subject.hget, "HGET", "h", "x")).To(BeNil())
+		Expect(call(subject.hget, "HGET", "missing", "a")).To(BeNil())
+		Expect(call(subject.hlen, "HLEN", "h")).To(Equal(int64(3)))
+		Expect(call(subject.hgetall, "HGETALL", "h")).To(Equal([]interface{}{"a", "3", "b", "2", "c", "4"}))
+		Expect(call(subject.hgetall, "HGETALL", "missing")).To(BeEmpty())
+		Expect(call(subject.hset, "HSET", "h", "a")).To(MatchError("ERR wrong number of arguments for 'HSET' command"))
+		Expect(call(subject.keyType, "TYPE", "h")).To(Equal("hash"))
+
+		Expect(call(subject.set, "SET", "str", "v")).To(Equal("OK"))
+		Expect(call(subject.hget, "HGET", "str", "a")).To(MatchError(errWrongType))
+	})
+
+	It("should delete fields", func() {
+		Expect(call(subject.hdel, "HDEL", "h", "a", "x")).To(Equal(int64(1)))
+		Expect(call(subject.hdel, "HDEL", "h", "x")).To(Equal(int64(0)))
+		Expect(call(subject.hdel, "HDEL", "h", "b")).To(Equal(int64(1)))
+		Expect(call(subject.exists, "EXISTS", "h")).To(Equal(int64(0)))
+		Expect(call(subject.hlen, "HLEN", "h")).To(Equal(int64(0)))
+	})
+
+	It("should dump and restore hashes", func() {
+		payload := call(subject.dump, "DUMP", "h").(string)
+		Expect(call(subject.restore, "RESTORE", "copy", "0", payload)).To(Equal("OK"))
+		Expect(call(subject.hgetall, "HGETALL", "copy")).To(Equal([]interface{}{"a", "1", "b", "2"}))
+	})
+
+})
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// HyperLogLogs are strings in the format of Redis: a 16 byte header,
+// "HYLL", the encoding, 3 unused bytes and the cached cardinality, followed
+// by 16384 registers of 6 bits. Registers are stored densely, or as a
+// run-length encoded sparse representation, while small.
+// https://github.com/redis/redis/blob/unstable/src/hyperloglog.c
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllMax       = 1<<hllBits - 1
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	// hllSparseMaxBytes is the max size of sparse HyperLogLogs, like
+	// Redis' hll-sparse-max-bytes
+	hllSparseMaxBytes = 3000
+
+	// sparse opcodes
+	hllOpZero    = 0x00 // 00xxxxxx: run of 1-64 zero registers
+	hllOpXZero   = 0x40 // 01xxxxxx yyyyyyyy: run of 1-16384 zero registers
+	hllOpVal     = 0x80 // 1vvvvvxx: run of 1-4 registers with value 1-32
+	hllSparseVal = 32   // max value of sparse registers
+
+	hllAlphaInf = 0.721347520444481703680 // 0.5/ln(2)
+)
+
+// Error replies of HyperLogLog commands
+const (
+	errHLLType    = "WRONGTYPE Key is not a valid HyperLogLog string value."
+	errHLLCorrupt = "INVALIDOBJ Corrupted HLL object detected"
+)
+
+var hllMagic = []byte("HYLL")
+
+type hllRegs [hllRegisters]uint8
+
+// hllPatLen hashes an element and returns the register it updates and
+// the length of the 000..1 pattern, the register's candidate value
+func hllPatLen(elem []byte) (int, uint8) {
+	hash := murmurHash64A(elem, 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	hash |= 1 << hllQ
+
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// murmurHash64A is MurmurHash2, 64-bit versions, by Austin Appleby, as
+// used by Redis
+func murmurHash64A(p []byte, seed uint64) uint64 {
+	const m, r = 0xc6a4a7935bd1e995, 47
+
+	h := seed ^ uint64(len(p))*m
+	for ; len(p) >= 8; p = p[8:] {
+		k := binary.LittleEndian.Uint64(p)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	if len(p) != 0 {
+		for i := len(p) - 1; i >= 0; i-- {
+			h ^= uint64(p[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllDecode reads the registers of a HyperLogLog string, it returns an
+// error reply on failure
+func hllDecode(p []byte, regs *hllRegs) string {
+	if len(p) < hllHdrSize || !bytes.Equal(p[:4], hllMagic) {
+		return errHLLType
+	}
+
+	switch p[4] {
+	case hllDense:
+		if len(p) != hllDenseSize {
+			return errHLLType
+		}
+		p = p[hllHdrSize:]
+		for i := range regs {
+			b := i * hllBits / 8
+			fb := uint(i * hllBits & 7)
+			v := uint(p[b]) >> fb
+			if b+1 < len(p) {
+				v |= uint(p[b+1]) << (8 - fb)
+			}
+			regs[i] = uint8(v & hllMax)
+		}
+	case hllSparse:
+		i := 0
+		for p = p[hllHdrSize:]; len(p) != 0; {
+			var n int
+			var v uint8
+			switch op := p[0]; {
+			case op&0xc0 == hllOpZero:
+				n, p = int(op&0x3f)+1, p[1:]
+			case op&0xc0 == hllOpXZero:
+				if len(p) < 2 {
+					return errHLLCorrupt
+				}
+				n, p = (int(op&0x3f)<<8|int(p[1]))+1, p[2:]
+			default:
+				v, n, p = (op>>2&0x1f)+1, int(op&0x3)+1, p[1:]
+			}
+			if i+n > hllRegisters {
+				return errHLLCorrupt
+			}
+			for end := i + n; i < end; i++ {
+				regs[i] = v
+			}
+		}
+		if i != hllRegisters {
+			return errHLLCorrupt
+		}
+	default:
+		return errHLLType
+	}
+	return ""
+}
+
+// hllEncode writes the registers, sparsely if possible. The cached
+// cardinality is invalid.
+func hllEncode(regs *hllRegs) []byte {
+	if p := hllEncodeSparse(regs); p != nil {
+		return p
+	}
+
+	p := make([]byte, hllDenseSize)
+	copy(p, hllMagic)
+	p[4] = hllDense
+	p[15] = 0x80
+
+	dense := p[hllHdrSize:]
+	for i, v := range regs {
+		b := i * hllBits / 8
+		fb := uint(i * hllBits & 7)
+		dense[b] |= v << fb
+		if b+1 < len(dense) {
+			dense[b+1] |= uint8(uint(v) >> (8 - fb))
+		}
+	}
+	return p
+}
+
+// hllEncodeSparse returns the sparse representation, or nil if the
+// registers are too large
+func hllEncodeSparse(regs *hllRegs) []byte {
+	p := make([]byte, hllHdrSize, 64)
+	copy(p, hllMagic)
+	p[4] = hllSparse
+	p[15] = 0x80
+
+	for i := 0; i < hllRegisters; {
+		v := regs[i]
+		if v > hllSparseVal {
+			return nil
+		}
+
+		n := 1
+		for i+n < hllRegisters && regs[i+n] == v {
+			n++
+		}
+		i += n
+
+		switch {
+		case v != 0:
+			for ; n > 0; n -= 4 {
+				run := n
+				if run > 4 {
+					run = 4
+				}
+				p = append(p, hllOpVal|(v-1)<<2|uint8(run-1))
+			}
+		case n <= 64:
+			p = append(p, hllOpZero|uint8(n-1))
+		default:
+			p = append(p, hllOpXZero|uint8((n-1)>>8), uint8(n-1))
+		}
+
+		if len(p) > hllSparseMaxBytes {
+			return nil
+		}
+	}
+	return p
+}
+
+// hllCount estimates the cardinality, see "New cardinality estimation
+// algorithms for HyperLogLog sketches" by Otmar Ertl, arXiv:1702.01284
+func hllCount(regs *hllRegs) uint64 {
+	var histo [64]int
+	for _, v := range regs {
+		histo[v]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// hllCached returns the cached cardinality of a HyperLogLog string, if
+// valid
+func hllCached(p []byte) (uint64, bool) {
+	if p[15]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(p[8:hllHdrSize]), true
+}
+
+func (s *store) pfadd(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var updated int64
+	var msg string
+	if !s.modifyTyped(w, c.Arg(0).String(), "string", func(cur *entry) (*entry, bool) {
+		regs := new(hllRegs)
+		if cur == nil {
+			updated = 1
+		} else if msg = hllDecode(cur.val, regs); msg != "" {
+			return nil, false
+		}
+
+		for _, elem := range c.Args[1:] {
+			if i, n := hllPatLen(elem); n > regs[i] {
+				regs[i] = n
+				updated = 1
+			}
+		}
+		if updated == 0 {
+			return nil, false
+		}
+		return cur.replace(hllEncode(regs)), true
+	}) {
+		return
+	}
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendInt(updated)
+}
+
+// pfcount implements PFCOUNT key [key ...], counting the union of
+// multiple keys. Cached cardinalities are used, but not updated.
+// https://redis.io/commands/pfcount
+func (s *store) pfcount(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	union := new(hllRegs)
+	regs := new(hllRegs)
+	for _, arg := range c.Args {
+		e, ok := s.keys.get(arg.String())
+		if !ok {
+			continue
+		}
+		if !typed(e, "string") {
+			w.AppendError(errWrongType)
+			return
+		}
+		e.touch(s.keys.now())
+
+		if msg := hllDecode(e.val, regs); msg != "" {
+			w.AppendError(msg)
+			return
+		}
+		if n, ok := hllCached(e.val); ok && c.ArgN() == 1 {
+			w.AppendInt(int64(n))
+			return
+		}
+		for i, v := range regs {
+			if v > union[i] {
+				union[i] = v
+			}
+		}
+	}
+	w.AppendInt(int64(hllCount(union)))
+}
+
+// pfmerge implements PFMERGE destkey [sourcekey ...], the destination is
+// part of the union
+// https://redis.io/commands/pfmerge
+func (s *store) pfmerge(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	keys := make([]string, 0, c.ArgN())
+	seen := make(map[string]bool, c.ArgN())
+	for _, arg := range c.Args {
+		if key := arg.String(); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	var msg string
+	s.keys.modifyAll(keys, func(cur []*entry) ([]*entry, bool) {
+		if !typedAll(cur, "string") {
+			msg = errWrongType
+			return nil, false
+		}
+
+		union := new(hllRegs)
+		regs := new(hllRegs)
+		for _, e := range cur {
+			if e == nil {
+				continue
+			}
+			if msg = hllDecode(e.val, regs); msg != "" {
+				return nil, false
+			}
+			for i, v := range regs {
+				if v > union[i] {
+					union[i] = v
+				}
+			}
+		}
+
+		next := append([]*entry(nil), cur...)
+		next[0] = cur[0].replace(hllEncode(union))
+		return next, true
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendOK()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("hyperloglog", func() {
+	var subject *store
+
+	add := func(key string, from, to int) {
+		args := []string{key}
+		for i := from; i < to; i++ {
+			args = append(args, strconv.Itoa(i))
+		}
+		call(subject.pfadd, "PFADD", args...)
+	}
+
+	encoding := func(key string) (byte, int) {
+		e, ok := subject.keys.get(key)
+		Expect(ok).To(BeTrue())
+		return e.val[4], len(e.val)
+	}
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+	})
+
+	It("should hash like Redis", func() {
+		Expect(murmurHash64A([]byte("hello"), 0xadc83b19)).To(Equal(uint64(0x0f656f01eecfe400)))
+		Expect(murmurHash64A([]byte("hello world!"), 0xadc83b19)).To(Equal(uint64(0x0fc444011f57220c)))
+	})
+
+	It("should add and count", func() {
+		Expect(call(subject.pfadd, "PFADD", "h")).To(Equal(int64(1)))
+		Expect(call(subject.get, "GET", "h")).To(Equal("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xff"))
+		Expect(call(subject.pfadd, "PFADD", "h")).To(Equal(int64(0)))
+		Expect(call(subject.pfcount, "PFCOUNT", "h")).To(Equal(int64(0)))
+
+		Expect(call(subject.pfadd, "PFADD", "h", "a", "b", "c", "d", "e", "f", "g")).To(Equal(int64(1)))
+		Expect(call(subject.pfadd, "PFADD", "h", "a", "b")).To(Equal(int64(0)))
+		Expect(call(subject.pfcount, "PFCOUNT", "h")).To(Equal(int64(7)))
+		Expect(call(subject.pfcount, "PFCOUNT", "h", "x")).To(Equal(int64(7)))
+		Expect(call(subject.pfcount, "PFCOUNT", "x")).To(Equal(int64(0)))
+	})
+
+	It("should convert to the dense representation", func() {
+		add("h", 0, 100)
+		enc, _ := encoding("h")
+		Expect(enc).To(Equal(byte(hllSparse)))
+
+		add("h", 100, 100000)
+		enc, size := encoding("h")
+		Expect(enc).To(Equal(byte(hllDense)))
+		Expect(size).To(Equal(hllDenseSize))
+		Expect(call(subject.pfcount, "PFCOUNT", "h")).To(BeNumerically("~", 100000, 2000))
+	})
+
+	It("should merge", func() {
+		add("a", 0, 1000)
+		add("b", 500, 5000)
+		Expect(call(subject.pfcount, "PFCOUNT", "a", "b")).To(BeNumerically("~", 5000, 100))
+		Expect(call(subject.pfmerge, "PFMERGE", "c", "a", "b", "x")).To(Equal("OK"))
+		Expect(call(subject.pfcount, "PFCOUNT", "c")).To(BeNumerically("~", 5000, 100))
+		Expect(call(subject.pfmerge, "PFMERGE", "a", "a", "c")).To(Equal("OK"))
+		Expect(call(subject.pfcount, "PFCOUNT", "a")).To(Equal(call(subject.pfcount, "PFCOUNT", "c")))
+	})
+
+	It("should reject other strings", func() {
+		Expect(call(subject.set, "SET", "s", "foo")).To(Equal("OK"))
+		Expect(call(subject.pfadd, "PFADD", "s", "a")).To(MatchError("WRONGTYPE Key is not a valid HyperLogLog string value."))
+		Expect(call(subject.pfcount, "PFCOUNT", "s")).To(MatchError("WRONGTYPE Key is not a valid HyperLogLog string value."))
+		Expect(call(subject.set, "SET", "s", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f")).To(Equal("OK"))
+		Expect(call(subject.pfcount, "PFCOUNT", "s")).To(MatchError("INVALIDOBJ Corrupted HLL object detected"))
+	})
+
+})
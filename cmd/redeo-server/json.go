@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// JSON documents are a module in the style of RedisJSON, see module.
+// Documents are stored as decoded values, numbers retain their literal
+// representation. Unlike in RedisJSON, object members are serialised in
+// lexicographical order.
+// https://redis.io/docs/stack/json/
+
+// Error replies of JSON commands
+const (
+	errJSONPath    = "ERR invalid JSON path"
+	errJSONNewRoot = "ERR new objects must be created at the root"
+)
+
+// jsonDoc is a JSON document object
+type jsonDoc struct {
+	root interface{}
+
+	// bytes is the size of the serialised document, updated atomically
+	bytes int64
+}
+
+func newJSONDoc(root interface{}) *jsonDoc {
+	d := new(jsonDoc)
+	d.set(root)
+	return d
+}
+
+func (d *jsonDoc) typ() string      { return "ReJSON-RL" }
+func (d *jsonDoc) encoding() string { return "raw" }
+func (d *jsonDoc) size() int64      { return atomic.LoadInt64(&d.bytes) }
+func (d *jsonDoc) dup() object      { return newJSONDoc(jsonDup(d.root)) }
+
+// set replaces the root and updates the size
+func (d *jsonDoc) set(root interface{}) {
+	d.root = root
+	atomic.StoreInt64(&d.bytes, int64(len(marshalJSON(root))))
+}
+
+// parseJSON decodes a value, retaining the literal representation of
+// numbers
+func parseJSON(p []byte) (interface{}, bool) {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+// marshalJSON serialises a decoded value
+func marshalJSON(v interface{}) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})
+}
+
+// jsonDup returns a deep copy of a decoded value
+func jsonDup(v interface{}) interface{} {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(c))
+		for k, e := range c {
+			res[k] = jsonDup(e)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(c))
+		for i, e := range c {
+			res[i] = jsonDup(e)
+		}
+		return res
+	}
+	return v
+}
+
+// jsonSeg is a segment of a path: a member name, an array index or a
+// wildcard
+type jsonSeg struct {
+	key   string
+	index int
+	isIdx bool
+	wild  bool
+}
+
+// jsonPath is a parsed path. It supports a subset of JSONPath: the root
+// $, members as .name or ['name'], array indexes as [n], negative ones
+// counting from the end, and wildcards as .* or [*]. Legacy paths, which
+// do not start with $, address a single value, e.g. "." or ".a.b".
+type jsonPath struct {
+	legacy bool
+	segs   []jsonSeg
+}
+
+// parseJSONPath parses a path, it returns false if it is invalid
+func parseJSONPath(s string) (*jsonPath, bool) {
+	p := new(jsonPath)
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s = s[1:]
+	case s == ".":
+		return &jsonPath{legacy: true}, true
+	default:
+		p.legacy = true
+		if !strings.HasPrefix(s, ".") && !strings.HasPrefix(s, "[") {
+			s = "." + s
+		}
+	}
+
+	for len(s) != 0 {
+		var seg jsonSeg
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			n := strings.IndexAny(s, ".[")
+			if n < 0 {
+				n = len(s)
+			}
+			if n == 0 {
+				return nil, false
+			}
+			seg.key, seg.wild, s = s[:n], s[:n] == "*", s[n:]
+		case '[':
+			n := strings.IndexByte(s, ']')
+			if n < 0 {
+				return nil, false
+			}
+			inner := s[1:n]
+			s = s[n+1:]
+
+			switch {
+			case inner == "*":
+				seg.wild = true
+			case len(inner) > 1 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				seg.key = inner[1 : len(inner)-1]
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, false
+				}
+				seg.index, seg.isIdx = i, true
+			}
+		default:
+			return nil, false
+		}
+		p.segs = append(p.segs, seg)
+	}
+	return p, true
+}
+
+// match returns the member names or array indexes of c matched by the
+// segment, sorted
+func (seg jsonSeg) match(c interface{}) (keys []string, indexes []int) {
+	switch c := c.(type) {
+	case map[string]interface{}:
+		if seg.wild {
+			for k := range c {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+		} else if _, ok := c[seg.key]; ok && !seg.isIdx {
+			keys = append(keys, seg.key)
+		}
+	case []interface{}:
+		if seg.wild {
+			for i := range c {
+				indexes = append(indexes, i)
+			}
+		} else if i := seg.index; seg.isIdx {
+			if i < 0 {
+				i += len(c)
+			}
+			if i >= 0 && i < len(c) {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	return
+}
+
+// jsonFind returns the values matched by segs in v
+func jsonFind(v interface{}, segs []jsonSeg) []interface{} {
+	if len(segs) == 0 {
+		return []interface{}{v}
+	}
+
+	var res []interface{}
+	keys, indexes := segs[0].match(v)
+	for _, k := range keys {
+		res = append(res, jsonFind(v.(map[string]interface{})[k], segs[1:])...)
+	}
+	for _, i := range indexes {
+		res = append(res, jsonFind(v.([]interface{})[i], segs[1:])...)
+	}
+	return res
+}
+
+// jsonUpdate calls fn for each value matched by segs in v. fn returns
+// the replacement and whether to keep it, matches which are not kept are
+// deleted, unless v itself is matched. It returns the updated v.
+func jsonUpdate(v interface{}, segs []jsonSeg, fn func(v interface{}) (interface{}, bool)) interface{} {
+	if len(segs) == 0 {
+		v, _ = fn(v)
+		return v
+	}
+
+	update := func(e interface{}) (interface{}, bool) {
+		if len(segs) == 1 {
+			return fn(e)
+		}
+		return jsonUpdate(e, segs[1:], fn), true
+	}
+
+	keys, indexes := segs[0].match(v)
+	if len(keys) != 0 {
+		m := v.(map[string]interface{})
+		for _, k := range keys {
+			if e, keep := update(m[k]); keep {
+				m[k] = e
+			} else {
+				delete(m, k)
+			}
+		}
+	}
+	if len(indexes) != 0 {
+		a := v.([]interface{})
+		deleted := make(map[int]bool)
+		for _, i := range indexes {
+			if e, keep := update(a[i]); keep {
+				a[i] = e
+			} else {
+				deleted[i] = true
+			}
+		}
+		if len(deleted) != 0 {
+			res := make([]interface{}, 0, len(a)-len(deleted))
+			for i, e := range a {
+				if !deleted[i] {
+					res = append(res, e)
+				}
+			}
+			return res
+		}
+	}
+	return v
+}
+
+// registerJSON registers the commands of the JSON module
+func registerJSON(s *store, r registrar) {
+	r.write("set", -4, s.denyOOM(s.jsonSet))
+	r.read("get", -2, s.jsonGet)
+	r.write("del", -2, s.jsonDel)
+}
+
+// jsonSet implements JSON.SET key path value [NX|XX], values are only set
+// if the path does not exist (NX) or exists (XX). Members are added to
+// objects if the last segment of the path is a member name.
+// https://redis.io/commands/json.set
+func (s *store) jsonSet(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 3 && c.ArgN() != 4 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var nx, xx bool
+	if c.ArgN() == 4 {
+		switch strings.ToLower(c.Arg(3).String()) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	path, ok := parseJSONPath(c.Arg(1).String())
+	if !ok {
+		w.AppendError(errJSONPath)
+		return
+	}
+	val, ok := parseJSON(c.Arg(2))
+	if !ok {
+		w.AppendError("ERR invalid JSON value")
+		return
+	}
+
+	var set bool
+	var msg string
+	if !s.modifyTyped(w, c.Arg(0).String(), "ReJSON-RL", func(cur *entry) (*entry, bool) {
+		if len(path.segs) == 0 {
+			if set = !(cur != nil && nx || cur == nil && xx); !set {
+				return cur, false
+			}
+			e := cur.replace(nil)
+			e.obj = newJSONDoc(val)
+			return e, true
+		}
+		if cur == nil {
+			msg = errJSONNewRoot
+			return nil, false
+		}
+
+		d := cur.obj.(*jsonDoc)
+		last := path.segs[len(path.segs)-1]
+		fn := func(v interface{}) (interface{}, bool) {
+			if nx {
+				return v, true
+			}
+			set = true
+			return jsonDup(val), true
+		}
+
+		if last.wild || last.isIdx {
+			d.set(jsonUpdate(d.root, path.segs, fn))
+			return cur, set
+		}
+
+		// members are set on their objects, so missing ones are added
+		d.set(jsonUpdate(d.root, path.segs[:len(path.segs)-1], func(v interface{}) (interface{}, bool) {
+			if m, ok := v.(map[string]interface{}); ok {
+				if _, exists := m[last.key]; exists && !nx || !exists && !xx {
+					m[last.key], set = jsonDup(val), true
+				}
+			}
+			return v, true
+		}))
+		return cur, set
+	}) {
+		return
+	}
+
+	switch {
+	case msg != "":
+		w.AppendError(msg)
+	case set:
+		w.AppendOK()
+	default:
+		w.AppendNil()
+	}
+}
+
+// jsonGet implements JSON.GET key [path ...]. Legacy paths reply with the
+// first value they match, others with an array of all matches. Multiple
+// paths reply with an object of the replies by path.
+// https://redis.io/commands/json.get
+func (s *store) jsonGet(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	args := []string{"."}
+	if c.ArgN() > 1 {
+		args = args[:0]
+		for _, arg := range c.Args[1:] {
+			args = append(args, arg.String())
+		}
+	}
+
+	paths := make([]*jsonPath, len(args))
+	for i, arg := range args {
+		path, ok := parseJSONPath(arg)
+		if !ok {
+			w.AppendError(errJSONPath)
+			return
+		}
+		paths[i] = path
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "ReJSON-RL", func(e *entry) {
+		if e == nil {
+			w.AppendNil()
+			return
+		}
+		d := e.obj.(*jsonDoc)
+
+		res := make(map[string]interface{}, len(paths))
+		for i, path := range paths {
+			matches := jsonFind(d.root, path.segs)
+			if !path.legacy {
+				if matches == nil {
+					matches = []interface{}{}
+				}
+				res[args[i]] = matches
+				continue
+			}
+			if len(matches) == 0 {
+				w.AppendError("ERR Path '" + args[i] + "' does not exist")
+				return
+			}
+			res[args[i]] = matches[0]
+		}
+
+		if len(paths) == 1 {
+			w.AppendBulk(marshalJSON(res[args[0]]))
+			return
+		}
+		w.AppendBulk(marshalJSON(res))
+	})
+}
+
+// jsonDel implements JSON.DEL key [path], it replies with the number of
+// values deleted. Deleting the root deletes the key.
+// https://redis.io/commands/json.del
+func (s *store) jsonDel(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 && c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	path := &jsonPath{legacy: true}
+	if c.ArgN() == 2 {
+		var ok bool
+		if path, ok = parseJSONPath(c.Arg(1).String()); !ok {
+			w.AppendError(errJSONPath)
+			return
+		}
+	}
+
+	var n int64
+	if !s.modifyTyped(w, c.Arg(0).String(), "ReJSON-RL", func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		if len(path.segs) == 0 {
+			n = 1
+			return nil, true
+		}
+
+		d := cur.obj.(*jsonDoc)
+		d.set(jsonUpdate(d.root, path.segs, func(v interface{}) (interface{}, bool) {
+			n++
+			return nil, false
+		}))
+		return cur, n != 0
+	}) {
+		return
+	}
+	w.AppendInt(n)
+}
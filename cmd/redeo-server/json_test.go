@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("json", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.jsonSet, "JSON.SET", "doc", "$", `{"name":"a","tags":["x","y"],"n":1.50,"nested":{"a":{"n":2},"b":{"n":3}}}`)).To(Equal("OK"))
+	})
+
+	It("should set and get documents", func() {
+		Expect(call(subject.jsonGet, "JSON.GET", "doc")).To(Equal(`{"n":1.50,"name":"a","nested":{"a":{"n":2},"b":{"n":3}},"tags":["x","y"]}`))
+		Expect(call(subject.jsonGet, "JSON.GET", "doc", "$.tags[-1]")).To(Equal(`["y"]`))
+		Expect(call(subject.jsonGet, "JSON.GET", "doc", ".nested.a")).To(Equal(`{"n":2}`))
+		Expect(call(subject.jsonGet, "JSON.GET", "doc", "$.nested.*.n")).To(Equal(`[2,3]`))
+		Expect(call(subject.jsonGet, "JSON.GET", "doc", "$['name']", "$.missing")).To(Equal(`{"$.missing":[],"$['name']":["a"]}`))
+		Expect(call(subject.jsonGet, "JSON.GET", "doc", ".missing")).To(MatchError("ERR Path '.missing' does not exist"))
+		Expect(call(subject.jsonGet, "JSON.GET", "doc", "$..n")).To(MatchError("ERR invalid JSON path"))
+		Expect(call(subject.jsonGet, "JSON.GET", "missing")).To(BeNil())
+		Expect(call(subject.keyType, "TYPE", "doc")).To(Equal("ReJSON-RL"))
+	})
+
+	It("should modify documents", func() {
+		Expect(call(subject.jsonSet, "JSON.SET", "doc", "$.nested.*.n", "0")).To(Equal("OK"))
+		Expect(call(subject.jsonSet, "JSON.SET", "doc", "$.tags[0]", `"<z>"`)).To(Equal("OK"))
+		Expect(call(subject.jsonSet, "JSON.SET", "doc", "$.added", "true", "XX")).To(BeNil())
+		Expect(call(subject.jsonSet, "JSON.SET", "doc", "$.added", "true", "NX")).To(Equal("OK"))
+		Expect(call(subject.jsonSet, "JSON.SET", "doc", "$.name", `"b"`, "NX")).To(BeNil())
+		Expect(call(subject.jsonSet, "JSON.SET", "doc", "$.missing.x", "1")).To(BeNil())
+		Expect(call(subject.jsonGet, "JSON.GET", "doc")).To(Equal(`{"added":true,"n":1.50,"name":"a","nested":{"a":{"n":0},"b":{"n":0}},"tags":["<z>","y"]}`))
+
+		Expect(call(subject.jsonSet, "JSON.SET", "new", "$.a", "1")).To(MatchError("ERR new objects must be created at the root"))
+		Expect(call(subject.jsonSet, "JSON.SET", "new", "$", "{")).To(MatchError("ERR invalid JSON value"))
+		Expect(call(subject.jsonSet, "JSON.SET", "new", ".", "[]", "XX")).To(BeNil())
+		Expect(call(subject.exists, "EXISTS", "new")).To(Equal(int64(0)))
+		Expect(call(subject.set, "SET", "str", "v")).To(Equal("OK"))
+		Expect(call(subject.jsonGet, "JSON.GET", "str")).To(MatchError(errWrongType))
+	})
+
+	It("should delete values", func() {
+		Expect(call(subject.jsonDel, "JSON.DEL", "doc", "$.nested.*.n")).To(Equal(int64(2)))
+		Expect(call(subject.jsonDel, "JSON.DEL", "doc", "$.tags[0]")).To(Equal(int64(1)))
+		Expect(call(subject.jsonDel, "JSON.DEL", "doc", "$.missing")).To(Equal(int64(0)))
+		Expect(call(subject.jsonGet, "JSON.GET", "doc")).To(Equal(`{"n":1.50,"name":"a","nested":{"a":{},"b":{}},"tags":["y"]}`))
+
+		Expect(call(subject.jsonDel, "JSON.DEL", "doc")).To(Equal(int64(1)))
+		Expect(call(subject.exists, "EXISTS", "doc")).To(Equal(int64(0)))
+		Expect(call(subject.jsonDel, "JSON.DEL", "doc")).To(Equal(int64(0)))
+	})
+
+	It("should dump and restore documents", func() {
+		payload := call(subject.dump, "DUMP", "doc").(string)
+		Expect(call(subject.restore, "RESTORE", "copy", "0", payload)).To(Equal("OK"))
+		Expect(call(subject.jsonGet, "JSON.GET", "copy", "$.tags")).To(Equal(`[["x","y"]]`))
+	})
+
+})
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// redeo-server has a single logical database, 0
+const numDatabases = 1
+
+// Error replies of key commands
+const (
+	errNoSuchKey   = "ERR no such key"
+	errSameObjects = "ERR source and destination objects are the same"
+	errDBIndex     = "ERR DB index is out of range"
+)
+
+// parseDB parses a database index, it returns an error reply on failure
+func parseDB(arg resp.CommandArgument) (int64, string) {
+	db, err := arg.Int()
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if db < 0 || db >= numDatabases {
+		return 0, errDBIndex
+	}
+	return db, ""
+}
+
+// distinctKeys returns the distinct keys of args, in the order of their
+// first occurrence, and the position of each key
+func distinctKeys(args []resp.CommandArgument) ([]string, map[string]int) {
+	keys := make([]string, 0, len(args))
+	pos := make(map[string]int, len(args))
+	for _, arg := range args {
+		if _, ok := pos[arg.String()]; !ok {
+			pos[arg.String()] = len(keys)
+			keys = append(keys, arg.String())
+		}
+	}
+	return keys, pos
+}
+
+func (s *store) rename(w resp.ResponseWriter, c *resp.Command) {
+	if _, ok := s.renameKey(w, c, false); ok {
+		w.AppendOK()
+	}
+}
+
+func (s *store) renamenx(w resp.ResponseWriter, c *resp.Command) {
+	if res, ok := s.renameKey(w, c, true); ok {
+		w.AppendInt(res)
+	}
+}
+
+// renameKey moves the source key and its TTL to the destination, unless
+// nx is set and the destination exists. It returns 1 if the key was
+// renamed, or false if an error was replied.
+func (s *store) renameKey(w resp.ResponseWriter, c *resp.Command, nx bool) (int64, bool) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return 0, false
+	}
+
+	src, dst := c.Arg(0).String(), c.Arg(1).String()
+	if src == dst {
+		if _, ok := s.keys.get(src); !ok {
+			w.AppendError(errNoSuchKey)
+			return 0, false
+		} else if nx {
+			return 0, true
+		}
+		return 1, true
+	}
+
+	var res int64
+	var msg string
+	s.keys.modifyAll([]string{src, dst}, func(cur []*entry) ([]*entry, bool) {
+		if cur[0] == nil {
+			msg = errNoSuchKey
+			return nil, false
+		}
+		if nx && cur[1] != nil {
+			return nil, false
+		}
+
+		res = 1
+		return []*entry{nil, cur[0].clone()}, true
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return 0, false
+	}
+	return res, true
+}
+
+// copy implements COPY source destination [DB destination-db] [REPLACE]
+// https://redis.io/commands/copy
+func (s *store) copy(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	replace := false
+	for i := 2; i < c.ArgN(); i++ {
+		switch strings.ToLower(c.Arg(i).String()) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			if _, msg := parseDB(c.Arg(i + 1)); msg != "" {
+				w.AppendError(msg)
+				return
+			}
+			i++
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	src, dst := c.Arg(0).String(), c.Arg(1).String()
+	if src == dst {
+		w.AppendError(errSameObjects)
+		return
+	}
+
+	var res int64
+	s.keys.modifyAll([]string{src, dst}, func(cur []*entry) ([]*entry, bool) {
+		if cur[0] == nil || (cur[1] != nil && !replace) {
+			return nil, false
+		}
+
+		res = 1
+		next := cur[0].clone()
+		if next.obj != nil {
+			next.obj = next.obj.dup()
+		}
+		return []*entry{cur[0], next}, true
+	})
+	w.AppendInt(res)
+}
+
+func (s *store) keyType(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	w.AppendInlineString(s.KeyType(c.Arg(0).String()))
+}
+
+// move implements MOVE key db. As there is a single database, it only
+// validates the arguments.
+func (s *store) move(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	if _, msg := parseDB(c.Arg(1)); msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendError(errSameObjects)
+}
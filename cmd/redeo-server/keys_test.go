@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("keys", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.set, "SET", "a", "1", "EX", "100")).To(Equal("OK"))
+		Expect(call(subject.set, "SET", "b", "2")).To(Equal("OK"))
+	})
+
+	It("should rename keys", func() {
+		Expect(call(subject.rename, "RENAME", "a", "c")).To(Equal("OK"))
+		Expect(call(subject.get, "GET", "c")).To(Equal("1"))
+		Expect(call(subject.ttl, "TTL", "c")).To(Equal(int64(100)))
+		Expect(call(subject.exists, "EXISTS", "a")).To(Equal(int64(0)))
+
+		Expect(call(subject.rename, "RENAME", "c", "b")).To(Equal("OK"))
+		Expect(call(subject.get, "GET", "b")).To(Equal("1"))
+		Expect(call(subject.rename, "RENAME", "b", "b")).To(Equal("OK"))
+		Expect(call(subject.dbsize, "DBSIZE")).To(Equal(int64(1)))
+
+		Expect(call(subject.rename, "RENAME", "x", "y")).To(MatchError("ERR no such key"))
+		Expect(call(subject.rename, "RENAME", "x", "x")).To(MatchError("ERR no such key"))
+	})
+
+	It("should rename keys if the destination does not exist", func() {
+		Expect(call(subject.renamenx, "RENAMENX", "a", "b")).To(Equal(int64(0)))
+		Expect(call(subject.renamenx, "RENAMENX", "a", "a")).To(Equal(int64(0)))
+		Expect(call(subject.renamenx, "RENAMENX", "a", "c")).To(Equal(int64(1)))
+		Expect(call(subject.get, "GET", "c")).To(Equal("1"))
+		Expect(call(subject.renamenx, "RENAMENX", "x", "y")).To(MatchError("ERR no such key"))
+	})
+
+	It("should copy keys", func() {
+		Expect(call(subject.copy, "COPY", "a", "b")).To(Equal(int64(0)))
+		Expect(call(subject.copy, "COPY", "a", "b", "REPLACE")).To(Equal(int64(1)))
+		Expect(call(subject.copy, "COPY", "a", "c", "DB", "0")).To(Equal(int64(1)))
+		Expect(call(subject.copy, "COPY", "x", "d")).To(Equal(int64(0)))
+		Expect(call(subject.get, "GET", "b")).To(Equal("1"))
+		Expect(call(subject.get, "GET", "c")).To(Equal("1"))
+		Expect(call(subject.ttl, "TTL", "c")).To(Equal(int64(100)))
+		Expect(call(subject.dbsize, "DBSIZE")).To(Equal(int64(3)))
+
+		Expect(call(subject.copy, "COPY", "a", "a")).To(MatchError("ERR source and destination objects are the same"))
+		Expect(call(subject.copy, "COPY", "a", "c", "DB", "1")).To(MatchError("ERR DB index is out of range"))
+		Expect(call(subject.copy, "COPY", "a", "c", "DB")).To(MatchError("ERR syntax error"))
+	})
+
+	It("should move keys", func() {
+		Expect(call(subject.move, "MOVE", "a", "0")).To(MatchError("ERR source and destination objects are the same"))
+		Expect(call(subject.move, "MOVE", "a", "1")).To(MatchError("ERR DB index is out of range"))
+		Expect(call(subject.move, "MOVE", "a", "x")).To(MatchError("ERR value is not an integer or out of range"))
+	})
+
+})
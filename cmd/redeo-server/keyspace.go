@@ -0,0 +1,736 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johntech-o/redeo"
+)
+
+// numShards is the number of keyspace shards, each guarded by its own lock
+const numShards = 16
+
+// numBuckets is the number of scan buckets of each shard, see
+// snapshot.scan
+const numBuckets = 256
+
+// entry is a version of a value. Strings are never modified in place,
+// writes replace the entry instead, so snapshots can keep reading the
+// versions they observed while writes continue. Values of other types
+// are objects, which are modified in place while holding the shard's
+// lock, all versions of a key share the object, see keyspace.view.
+type entry struct {
+	val     []byte
+	obj     object
+	ver     uint64
+	deleted bool
+
+	// accessed is the last access time in unix nanoseconds, updated
+	// atomically by readers
+	accessed int64
+
+	// lfu is the logarithmic access frequency counter in the lowest 8
+	// bits and the time it was last updated, in unix minutes, in the
+	// others, like Redis' LFU clock, updated atomically by readers
+	lfu uint64
+
+	// expires is the deadline in unix nanoseconds, zero if the entry
+	// does not expire
+	expires int64
+
+	// prev is the previous version, retained while open snapshots may
+	// still need it
+	prev *entry
+}
+
+// newEntry returns an entry of val, its access time and frequency are
+// initialised once it is written, see keyspace.written
+func newEntry(val []byte) *entry {
+	return &entry{val: val}
+}
+
+// replace returns a new version of e, which may be nil, with the value,
+// retaining the TTL
+func (e *entry) replace(val []byte) *entry {
+	next := newEntry(val)
+	if e != nil {
+		next.expires = e.expires
+	}
+	return next
+}
+
+// clone returns a new version of e with the same value and TTL
+func (e *entry) clone() *entry {
+	next := e.replace(e.val)
+	next.obj = e.obj
+	return next
+}
+
+// size returns the approximate size of the value in bytes
+func (e *entry) size() int64 {
+	if e.obj == nil {
+		return int64(len(e.val))
+	}
+	return e.obj.size()
+}
+
+// typ returns the type of the value, as reported by TYPE
+func (e *entry) typ() string {
+	if e.obj == nil {
+		return "string"
+	}
+	return e.obj.typ()
+}
+
+// expired reports whether the deadline has passed at now, in unix
+// nanoseconds
+func (e *entry) expired(now int64) bool { return e.expires != 0 && e.expires <= now }
+
+// LFU parameters, like Redis' defaults for lfu-log-factor and
+// lfu-decay-time
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = 1 // minutes
+)
+
+// touch records an access, updating both the access time and the access
+// frequency
+func (e *entry) touch(now time.Time) {
+	atomic.StoreInt64(&e.accessed, now.UnixNano())
+
+	mins := uint64(now.Unix() / 60)
+	for {
+		lfu := atomic.LoadUint64(&e.lfu)
+		counter := lfuIncr(lfuDecr(lfu, mins))
+		if atomic.CompareAndSwapUint64(&e.lfu, lfu, mins<<8|uint64(counter)) {
+			return
+		}
+	}
+}
+
+// idle returns the time from the last access until now
+func (e *entry) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&e.accessed)))
+}
+
+// freq returns the access frequency counter, decayed by the time since
+// it was last updated until now
+func (e *entry) freq(now time.Time) uint8 {
+	return lfuDecr(atomic.LoadUint64(&e.lfu), uint64(now.Unix()/60))
+}
+
+// setFreq sets the access frequency counter, as updated at now
+func (e *entry) setFreq(counter uint8, now time.Time) {
+	atomic.StoreUint64(&e.lfu, uint64(now.Unix()/60)<<8|uint64(counter))
+}
+
+// lfuDecr returns the counter of lfu, decremented by one for each decay
+// period elapsed by mins
+func lfuDecr(lfu, mins uint64) uint8 {
+	counter, last := uint8(lfu), lfu>>8
+	if mins <= last {
+		return counter
+	}
+	if periods := (mins - last) / lfuDecayTime; periods < uint64(counter) {
+		return counter - uint8(periods)
+	}
+	return 0
+}
+
+// lfuIncr increments counter logarithmically, the more accesses it
+// already counts, the less likely
+func lfuIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+	base := float64(counter) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// visible returns the version of e a snapshot at ver observes, or nil
+func (e *entry) visible(ver uint64) *entry {
+	for ; e != nil; e = e.prev {
+		if e.ver <= ver {
+			if e.deleted {
+				return nil
+			}
+			return e
+		}
+	}
+	return nil
+}
+
+// object is a value of a type other than string
+type object interface {
+	// typ returns the name of the type
+	typ() string
+	// encoding returns the internal representation, as reported by
+	// OBJECT ENCODING
+	encoding() string
+	// size returns the approximate size in bytes, it may be called
+	// without holding the shard's lock
+	size() int64
+	// dup returns a deep copy
+	dup() object
+}
+
+type shard struct {
+	data map[string]*entry
+	live int
+
+	// buckets index the keys of data by hash, so scans can resume at a
+	// bucket, unaffected by writes to others
+	buckets [numBuckets]map[string]struct{}
+
+	mu   sync.RWMutex
+
+	// used is the approximate memory used by the keys and their values,
+	// updated atomically while holding mu
+	used int64
+}
+
+// keyspace is a sharded map of versioned entries. Snapshots provide a
+// consistent, point-in-time view for full iterations, e.g. SCAN, without
+// blocking writes for the duration: while snapshots are open, writes keep
+// the versions they replace.
+type keyspace struct {
+	shards [numShards]shard
+
+	// ver is the version of the latest write, incremented atomically
+	// while holding vmu for reading. Opening a snapshot takes vmu for
+	// writing, so it observes either all or none of each write.
+	ver    uint64
+	vmu    sync.RWMutex
+	snaps  map[uint64]int // open snapshots by version
+	oldest uint64         // version of the oldest open snapshot
+
+	// expired, if set, is called with each key removed by the expiration
+	// of its TTL, while holding the shard's lock
+	expired func(key string)
+
+	// ready, if set, is called with each key a value was written to by
+	// modify or modifyAll, after the locks are released
+	ready func(key string)
+
+	// updated, if set, is called with each key written by modify or
+	// modifyAll and its new entry, or nil if it was deleted or expired,
+	// while holding the shard's lock. It is not called by flush.
+	updated func(key string, e *entry)
+
+	// clock provides the time for expiration and access tracking
+	clock redeo.Clock
+}
+
+func newKeyspace(clock redeo.Clock) *keyspace {
+	ks := &keyspace{snaps: make(map[uint64]int), clock: clock}
+	for i := range ks.shards {
+		ks.shards[i].data = make(map[string]*entry)
+	}
+	return ks
+}
+
+// now returns the current time of the clock
+func (ks *keyspace) now() time.Time { return ks.clock.Now() }
+
+func (ks *keyspace) shard(key string) *shard {
+	return &ks.shards[ks.shardIndex(key)]
+}
+
+func (ks *keyspace) shardIndex(key string) int {
+	return int(keyHash(key) % numShards)
+}
+
+func keyHash(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// bucketIndex returns the scan bucket of key within its shard
+func bucketIndex(key string) int {
+	return int(keyHash(key) / numShards % numBuckets)
+}
+
+// add adds key to data, must be called with the lock held
+func (sh *shard) add(key string, e *entry) {
+	if _, ok := sh.data[key]; !ok {
+		b := &sh.buckets[bucketIndex(key)]
+		if *b == nil {
+			*b = make(map[string]struct{})
+		}
+		(*b)[key] = struct{}{}
+	}
+	sh.data[key] = e
+}
+
+// remove removes key from data, must be called with the lock held
+func (sh *shard) remove(key string) {
+	delete(sh.data, key)
+	delete(sh.buckets[bucketIndex(key)], key)
+}
+
+// clear removes all keys, must be called with the lock held
+func (sh *shard) clear() {
+	sh.data = make(map[string]*entry)
+	sh.buckets = [numBuckets]map[string]struct{}{}
+}
+
+// get returns the current entry of key. Expired keys are removed.
+func (ks *keyspace) get(key string) (*entry, bool) {
+	sh := ks.shard(key)
+	sh.mu.RLock()
+	e := sh.data[key].visible(^uint64(0))
+	sh.mu.RUnlock()
+
+	if e != nil && e.expired(ks.now().UnixNano()) {
+		ks.modify(key, func(cur *entry) (*entry, bool) { return cur, false })
+		return nil, false
+	}
+	return e, e != nil
+}
+
+// view calls fn with the current entry of key, or nil, while holding the
+// shard's lock for reading, so objects can be read. Expired keys are
+// removed.
+func (ks *keyspace) view(key string, fn func(e *entry)) {
+	sh := ks.shard(key)
+	sh.mu.RLock()
+	e := sh.data[key].visible(^uint64(0))
+	if e == nil || !e.expired(ks.now().UnixNano()) {
+		fn(e)
+		sh.mu.RUnlock()
+		return
+	}
+	sh.mu.RUnlock()
+
+	ks.modify(key, func(cur *entry) (*entry, bool) { return cur, false })
+	fn(nil)
+}
+
+// viewAll is like view, but for multiple distinct keys, which are read
+// atomically. Expired keys are passed as nil.
+func (ks *keyspace) viewAll(keys []string, fn func(cur []*entry)) {
+	var shards [numShards]bool
+	for _, key := range keys {
+		shards[ks.shardIndex(key)] = true
+	}
+	for i := range ks.shards {
+		if shards[i] {
+			ks.shards[i].mu.RLock()
+			defer ks.shards[i].mu.RUnlock()
+		}
+	}
+
+	now := ks.now().UnixNano()
+	cur := make([]*entry, len(keys))
+	for i, key := range keys {
+		if e := ks.shard(key).data[key].visible(^uint64(0)); e != nil && !e.expired(now) {
+			cur[i] = e
+		}
+	}
+	fn(cur)
+}
+
+// modify calls fn with the current entry of key, or nil, while holding
+// the shard's lock. fn returns the replacement, or nil to delete the key,
+// and whether anything changed. Replacements must be new entries, unless
+// the object of cur was modified in place, then cur is returned.
+// Expired keys are passed as nil and removed unless replaced.
+func (ks *keyspace) modify(key string, fn func(cur *entry) (next *entry, changed bool)) {
+	if ks.update(key, fn) && ks.ready != nil {
+		ks.ready(key)
+	}
+}
+
+// update implements modify, it reports whether a value was written
+func (ks *keyspace) update(key string, fn func(cur *entry) (next *entry, changed bool)) bool {
+	sh := ks.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	old, cur := ks.current(sh, key)
+	used := usage(key, old)
+	next, changed := fn(cur)
+	if cur != old && !changed {
+		next, changed = nil, true
+	}
+	if !changed || (next == nil && old == nil) {
+		return false
+	}
+
+	ks.vmu.RLock()
+	defer ks.vmu.RUnlock()
+
+	ks.put(sh, key, old, next, atomic.AddUint64(&ks.ver, 1))
+	ks.written(sh, key, old, next, used)
+	return next != nil
+}
+
+// modifyAll is like modify, but for multiple distinct keys, which are
+// modified atomically. fn returns the replacements, in the order of the
+// keys, entries which are returned unchanged are retained.
+func (ks *keyspace) modifyAll(keys []string, fn func(cur []*entry) (next []*entry, changed bool)) {
+	written := ks.updateAll(keys, fn)
+	for i, key := range keys {
+		if written[i] && ks.ready != nil {
+			ks.ready(key)
+		}
+	}
+}
+
+// updateAll implements modifyAll, it reports which keys have values
+// after a change
+func (ks *keyspace) updateAll(keys []string, fn func(cur []*entry) (next []*entry, changed bool)) []bool {
+	var shards [numShards]bool
+	for _, key := range keys {
+		shards[ks.shardIndex(key)] = true
+	}
+	for i := range ks.shards {
+		if shards[i] {
+			ks.shards[i].mu.Lock()
+			defer ks.shards[i].mu.Unlock()
+		}
+	}
+
+	old := make([]*entry, len(keys))
+	cur := make([]*entry, len(keys))
+	used := make([]int64, len(keys))
+	expired := false
+	for i, key := range keys {
+		old[i], cur[i] = ks.current(ks.shard(key), key)
+		used[i] = usage(key, old[i])
+		expired = expired || old[i] != cur[i]
+	}
+
+	written := make([]bool, len(keys))
+	next, changed := fn(cur)
+	if !changed {
+		if !expired {
+			return written
+		}
+		next = cur
+	}
+
+	ks.vmu.RLock()
+	defer ks.vmu.RUnlock()
+
+	ver := atomic.AddUint64(&ks.ver, 1)
+	for i, key := range keys {
+		if next[i] != old[i] {
+			ks.put(ks.shard(key), key, old[i], next[i], ver)
+		}
+		if changed || next[i] != old[i] {
+			ks.written(ks.shard(key), key, old[i], next[i], used[i])
+		}
+		written[i] = changed && next[i] != nil
+	}
+	return written
+}
+
+// usage returns the approximate memory used by key and its entry, as
+// reported by MEMORY USAGE
+func usage(key string, e *entry) int64 {
+	if e == nil {
+		return 0
+	}
+	return int64(len(key)) + e.size()
+}
+
+// written accounts for a write of next, which replaced old, using used
+// bytes before. The write counts as an access of next, which inherits
+// the access frequency of old. Must be called with the shard's lock held.
+func (ks *keyspace) written(sh *shard, key string, old, next *entry, used int64) {
+	atomic.AddInt64(&sh.used, usage(key, next)-used)
+	if ks.updated != nil {
+		ks.updated(key, next)
+	}
+	if next == nil {
+		return
+	}
+	now := ks.now()
+	if old != nil && next != old {
+		atomic.StoreUint64(&next.lfu, atomic.LoadUint64(&old.lfu))
+	} else if atomic.LoadUint64(&next.lfu) == 0 {
+		atomic.StoreUint64(&next.lfu, uint64(now.Unix()/60)<<8|lfuInitVal)
+	}
+	next.touch(now)
+}
+
+// usedMemory returns the approximate memory used by all keys and their
+// values
+func (ks *keyspace) usedMemory() int64 {
+	var n int64
+	for i := range ks.shards {
+		n += atomic.LoadInt64(&ks.shards[i].used)
+	}
+	return n
+}
+
+// sample calls fn with up to n keys and their entries, only keys with a
+// TTL if volatile, while holding their shard's lock for reading. Shards
+// are sampled from a random one, keys in map iteration order.
+func (ks *keyspace) sample(n int, volatile bool, fn func(key string, e *entry)) {
+	now := ks.now().UnixNano()
+	start := rand.Intn(numShards)
+	for i := 0; i < numShards && n > 0; i++ {
+		sh := &ks.shards[(start+i)%numShards]
+		sh.mu.RLock()
+		for key, head := range sh.data {
+			e := head.visible(^uint64(0))
+			if e == nil || e.expired(now) || (volatile && e.expires == 0) {
+				continue
+			}
+			fn(key, e)
+			if n--; n == 0 {
+				break
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// current returns the visible entry of key, and the same entry or nil
+// if it expired. Must be called with the shard's lock held.
+func (ks *keyspace) current(sh *shard, key string) (old, cur *entry) {
+	old = sh.data[key].visible(^uint64(0))
+	if old != nil && old.expired(ks.now().UnixNano()) {
+		if ks.expired != nil {
+			ks.expired(key)
+		}
+		if ks.updated != nil {
+			ks.updated(key, nil)
+		}
+		return old, nil
+	}
+	return old, old
+}
+
+// put replaces the visible entry old of key with next, or deletes it if
+// next is nil, as version ver. Must be called with the shard's lock and
+// vmu held for reading.
+func (ks *keyspace) put(sh *shard, key string, old, next *entry, ver uint64) {
+	if next == old {
+		return
+	}
+
+	head := sh.data[key]
+	open := len(ks.snaps) != 0
+	switch {
+	case next == nil && !open:
+		sh.remove(key)
+	case next == nil:
+		next = &entry{deleted: true}
+		fallthrough
+	default:
+		next.ver, next.prev = ver, nil
+		if open && head != nil {
+			next.prev = head
+			trim(next, ks.oldest)
+		}
+		sh.add(key, next)
+	}
+
+	if old == nil {
+		sh.live++
+	} else if next == nil || next.deleted {
+		sh.live--
+	}
+}
+
+// random returns a random key, or false if there are none. Shards are
+// tried from a random one, keys are picked in map iteration order.
+func (ks *keyspace) random() (string, bool) {
+	now := ks.now().UnixNano()
+	start := rand.Intn(numShards)
+	for i := 0; i < numShards; i++ {
+		sh := &ks.shards[(start+i)%numShards]
+		sh.mu.RLock()
+		for key, head := range sh.data {
+			if e := head.visible(^uint64(0)); e != nil && !e.expired(now) {
+				sh.mu.RUnlock()
+				return key, true
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return "", false
+}
+
+// flush deletes all keys
+func (ks *keyspace) flush() {
+	for i := range ks.shards {
+		sh := &ks.shards[i]
+		sh.mu.Lock()
+		ks.vmu.RLock()
+		if len(ks.snaps) == 0 {
+			sh.clear()
+		} else {
+			ver := atomic.AddUint64(&ks.ver, 1)
+			for key, head := range sh.data {
+				if !head.deleted {
+					e := &entry{deleted: true, ver: ver, prev: head}
+					trim(e, ks.oldest)
+					sh.data[key] = e
+				}
+			}
+		}
+		sh.live = 0
+		atomic.StoreInt64(&sh.used, 0)
+		ks.vmu.RUnlock()
+		sh.mu.Unlock()
+	}
+}
+
+// len returns the number of keys
+func (ks *keyspace) len() int {
+	n := 0
+	for i := range ks.shards {
+		sh := &ks.shards[i]
+		sh.mu.RLock()
+		n += sh.live
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// snapshot opens a consistent view of the keyspace, it must be closed
+// to release the versions it retains
+func (ks *keyspace) snapshot() *snapshot {
+	ks.vmu.Lock()
+	defer ks.vmu.Unlock()
+
+	ver := atomic.LoadUint64(&ks.ver)
+	if len(ks.snaps) == 0 {
+		ks.oldest = ver
+	}
+	ks.snaps[ver]++
+	return &snapshot{ks: ks, ver: ver}
+}
+
+// release closes a snapshot and drops the versions no longer needed
+func (ks *keyspace) release(ver uint64) {
+	ks.vmu.Lock()
+	if ks.snaps[ver]--; ks.snaps[ver] == 0 {
+		delete(ks.snaps, ver)
+	}
+	open := len(ks.snaps) != 0
+	if open && ver == ks.oldest {
+		ks.oldest = ^uint64(0)
+		for v := range ks.snaps {
+			if v < ks.oldest {
+				ks.oldest = v
+			}
+		}
+	}
+	oldest := ks.oldest
+	ks.vmu.Unlock()
+
+	for i := range ks.shards {
+		sh := &ks.shards[i]
+		sh.mu.Lock()
+		for key, head := range sh.data {
+			switch {
+			case head.deleted && (!open || head.ver <= oldest):
+				sh.remove(key)
+			case !open:
+				head.prev = nil
+			default:
+				trim(head, oldest)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// trim drops the versions of e which are older than the one visible to
+// the oldest open snapshot
+func trim(e *entry, oldest uint64) {
+	for ; e != nil; e = e.prev {
+		if e.ver <= oldest {
+			e.prev = nil
+			return
+		}
+	}
+}
+
+// snapshot is a point-in-time view of a keyspace
+type snapshot struct {
+	ks   *keyspace
+	ver  uint64
+	once sync.Once
+}
+
+// each calls fn for each key and its value, in no particular order,
+// until fn returns false. Shards are locked one at a time, only while
+// their keys are collected. Expired keys are skipped.
+func (sn *snapshot) each(fn func(key string, e *entry) bool) {
+	var keys []string
+	var entries []*entry
+	now := sn.ks.now().UnixNano()
+	for i := range sn.ks.shards {
+		keys, entries = keys[:0], entries[:0]
+
+		sh := &sn.ks.shards[i]
+		sh.mu.RLock()
+		for key, head := range sh.data {
+			if e := head.visible(sn.ver); e != nil && !e.expired(now) {
+				keys = append(keys, key)
+				entries = append(entries, e)
+			}
+		}
+		sh.mu.RUnlock()
+
+		for j, key := range keys {
+			if !fn(key, entries[j]) {
+				return
+			}
+		}
+	}
+}
+
+// scan calls fn for the keys of the scan buckets from cursor on, until
+// at least count keys were visited, and returns the cursor to resume at,
+// or 0 once all buckets were visited. A bucket is always visited as a
+// whole, so keys present during a full scan are returned at least once,
+// regardless of other writes. Expired keys are skipped.
+func (sn *snapshot) scan(cursor uint64, count int, fn func(key string, e *entry)) uint64 {
+	now := sn.ks.now().UnixNano()
+	for n := 0; cursor < numShards*numBuckets && n < count; cursor++ {
+		var keys []string
+		var entries []*entry
+
+		sh := &sn.ks.shards[cursor/numBuckets]
+		sh.mu.RLock()
+		for key := range sh.buckets[cursor%numBuckets] {
+			if e := sh.data[key].visible(sn.ver); e != nil && !e.expired(now) {
+				keys = append(keys, key)
+				entries = append(entries, e)
+			}
+		}
+		sh.mu.RUnlock()
+
+		for j, key := range keys {
+			fn(key, entries[j])
+		}
+		n += len(keys)
+	}
+	if cursor >= numShards*numBuckets {
+		return 0
+	}
+	return cursor
+}
+
+// close releases the snapshot
+func (sn *snapshot) close() {
+	sn.once.Do(func() { sn.ks.release(sn.ver) })
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("keyspace", func() {
+	var subject *keyspace
+
+	set := func(key, val string) {
+		subject.modify(key, func(*entry) (*entry, bool) { return newEntry([]byte(val)), true })
+	}
+	del := func(key string) {
+		subject.modify(key, func(cur *entry) (*entry, bool) { return nil, cur != nil })
+	}
+	read := func(sn *snapshot) map[string]string {
+		res := make(map[string]string)
+		sn.each(func(key string, e *entry) bool {
+			res[key] = string(e.val)
+			return true
+		})
+		return res
+	}
+
+	BeforeEach(func() {
+		subject = newKeyspace(redeo.SystemClock)
+		set("a", "1")
+		set("b", "2")
+	})
+
+	It("should get and modify keys", func() {
+		e, ok := subject.get("a")
+		Expect(ok).To(BeTrue())
+		Expect(string(e.val)).To(Equal("1"))
+
+		del("a")
+		del("c")
+		_, ok = subject.get("a")
+		Expect(ok).To(BeFalse())
+		Expect(subject.len()).To(Equal(1))
+	})
+
+	It("should modify multiple keys atomically", func() {
+		sn := subject.snapshot()
+		defer sn.close()
+
+		subject.modifyAll([]string{"a", "b", "c"}, func(cur []*entry) ([]*entry, bool) {
+			Expect(cur[2]).To(BeNil())
+			return []*entry{nil, cur[1], newEntry(cur[0].val)}, true
+		})
+		Expect(read(sn)).To(Equal(map[string]string{"a": "1", "b": "2"}))
+		Expect(read(subject.snapshot())).To(Equal(map[string]string{"b": "2", "c": "1"}))
+		Expect(subject.len()).To(Equal(2))
+	})
+
+	It("should expire keys", func() {
+		var expired []string
+		subject.expired = func(key string) { expired = append(expired, key) }
+		subject.modify("a", func(cur *entry) (*entry, bool) {
+			e := cur.replace(cur.val)
+			e.expires = time.Now().Add(-time.Second).UnixNano()
+			return e, true
+		})
+		Expect(read(subject.snapshot())).To(Equal(map[string]string{"b": "2"}))
+
+		_, ok := subject.get("a")
+		Expect(ok).To(BeFalse())
+		Expect(subject.len()).To(Equal(1))
+		Expect(expired).To(Equal([]string{"a"}))
+	})
+
+	It("should scan all keys despite deletions", func() {
+		for i := 0; i < 100; i++ {
+			set(strconv.Itoa(i), "x")
+		}
+
+		seen := make(map[string]bool)
+		var cursor uint64
+		for {
+			sn := subject.snapshot()
+			cursor = sn.scan(cursor, 1, func(key string, _ *entry) {
+				seen[key] = true
+				del(key)
+			})
+			sn.close()
+			if cursor == 0 {
+				break
+			}
+		}
+		Expect(seen).To(HaveLen(102))
+		Expect(subject.len()).To(Equal(0))
+	})
+
+	It("should iterate consistent snapshots", func() {
+		sn := subject.snapshot()
+		defer sn.close()
+
+		set("a", "3")
+		del("b")
+		set("c", "4")
+		Expect(subject.len()).To(Equal(2))
+
+		sn2 := subject.snapshot()
+		defer sn2.close()
+		subject.flush()
+		Expect(subject.len()).To(Equal(0))
+
+		Expect(read(sn)).To(Equal(map[string]string{"a": "1", "b": "2"}))
+		Expect(read(sn2)).To(Equal(map[string]string{"a": "3", "c": "4"}))
+		Expect(read(subject.snapshot())).To(BeEmpty())
+	})
+
+	It("should release versions", func() {
+		sn := subject.snapshot()
+		set("a", "3")
+		del("b")
+		sn2 := subject.snapshot()
+		set("a", "5")
+
+		sn.close()
+		sn.close()
+		Expect(subject.shards[subject.shardIndex("b")].data).NotTo(HaveKey("b"))
+		Expect(read(sn2)).To(Equal(map[string]string{"a": "3"}))
+
+		sn2.close()
+		e := subject.shards[subject.shardIndex("a")].data["a"]
+		Expect(e.prev).To(BeNil())
+		Expect(read(subject.snapshot())).To(Equal(map[string]string{"a": "5"}))
+	})
+
+})
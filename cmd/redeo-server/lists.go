@@ -0,0 +1,437 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// listEntrySize is the approximate overhead of each list element
+const listEntrySize = 16
+
+// list is a list object, a ring buffer of elements, so pushes and pops
+// at both ends as well as indexing are O(1)
+type list struct {
+	elems []string
+	head  int
+	n     int
+
+	// bytes is the approximate size, updated atomically
+	bytes int64
+}
+
+func newList() *list {
+	return &list{}
+}
+
+func (l *list) typ() string      { return "list" }
+func (l *list) encoding() string { return "quicklist" }
+func (l *list) size() int64      { return atomic.LoadInt64(&l.bytes) }
+
+func (l *list) dup() object {
+	res := newList()
+	for i := 0; i < l.n; i++ {
+		res.push(l.index(i), false)
+	}
+	return res
+}
+
+// len returns the number of elements
+func (l *list) len() int { return l.n }
+
+// index returns the element at index i, which must be in range
+func (l *list) index(i int) string {
+	return l.elems[(l.head+i)%len(l.elems)]
+}
+
+// push adds val to the head, if left, or the tail
+func (l *list) push(val string, left bool) {
+	if l.n == len(l.elems) {
+		elems := make([]string, 2*l.n+4)
+		for i := 0; i < l.n; i++ {
+			elems[i] = l.index(i)
+		}
+		l.elems, l.head = elems, 0
+	}
+
+	if left {
+		l.head = (l.head + len(l.elems) - 1) % len(l.elems)
+		l.elems[l.head] = val
+	} else {
+		l.elems[(l.head+l.n)%len(l.elems)] = val
+	}
+	l.n++
+	atomic.AddInt64(&l.bytes, int64(len(val)+listEntrySize))
+}
+
+// pop removes and returns the head, if left, or the tail of a non-empty
+// list
+func (l *list) pop(left bool) string {
+	i := (l.head + l.n - 1) % len(l.elems)
+	if left {
+		i = l.head
+		l.head = (l.head + 1) % len(l.elems)
+	}
+	val := l.elems[i]
+	l.elems[i] = ""
+	l.n--
+	atomic.AddInt64(&l.bytes, -int64(len(val)+listEntrySize))
+	return val
+}
+
+// parseWhere parses LEFT or RIGHT, it reports whether arg is valid
+func parseWhere(arg resp.CommandArgument) (left bool, ok bool) {
+	switch strings.ToLower(arg.String()) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
+}
+
+func (s *store) lpush(w resp.ResponseWriter, c *resp.Command) {
+	s.push(w, c, true)
+}
+
+func (s *store) rpush(w resp.ResponseWriter, c *resp.Command) {
+	s.push(w, c, false)
+}
+
+// push implements LPUSH and RPUSH key element [element ...]
+func (s *store) push(w resp.ResponseWriter, c *resp.Command, left bool) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int
+	if !s.modifyTyped(w, c.Arg(0).String(), "list", func(cur *entry) (*entry, bool) {
+		l := newList()
+		if cur != nil {
+			l = cur.obj.(*list)
+		}
+
+		for _, arg := range c.Args[1:] {
+			l.push(arg.String(), left)
+		}
+		n = l.len()
+		if cur == nil {
+			e := newEntry(nil)
+			e.obj = l
+			return e, true
+		}
+		return cur, true
+	}) {
+		return
+	}
+	w.AppendInt(int64(n))
+}
+
+// popFromList pops up to count elements from the list at key, it returns
+// false if the key does not exist, or an error reply
+func (s *store) popFromList(key string, count int, left bool) ([]string, bool, string) {
+	var res []string
+	var found bool
+	var msg string
+	s.keys.modify(key, func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		if !typed(cur, "list") {
+			msg = errWrongType
+			return nil, false
+		}
+		l := cur.obj.(*list)
+
+		found = true
+		for ; count > 0 && l.len() != 0; count-- {
+			res = append(res, l.pop(left))
+		}
+		if l.len() == 0 {
+			return nil, true
+		}
+		return cur, len(res) != 0
+	})
+	return res, found, msg
+}
+
+func (s *store) lpop(w resp.ResponseWriter, c *resp.Command) {
+	s.pop(w, c, true)
+}
+
+func (s *store) rpop(w resp.ResponseWriter, c *resp.Command) {
+	s.pop(w, c, false)
+}
+
+// pop implements LPOP and RPOP key [count], which reply with an array
+// if count is given
+func (s *store) pop(w resp.ResponseWriter, c *resp.Command, left bool) {
+	if c.ArgN() != 1 && c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	count := int64(1)
+	if c.ArgN() == 2 {
+		var err error
+		if count, err = c.Arg(1).Int(); err != nil {
+			w.AppendError(errNotInteger)
+			return
+		} else if count < 0 {
+			w.AppendError("ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	res, found, msg := s.popFromList(c.Arg(0).String(), int(count), left)
+	switch {
+	case msg != "":
+		w.AppendError(msg)
+	case c.ArgN() == 1 && !found:
+		w.AppendNil()
+	case c.ArgN() == 1:
+		w.AppendBulkString(res[0])
+	case !found:
+		resp.AppendNilArray(w)
+	default:
+		w.AppendArrayLen(len(res))
+		for _, val := range res {
+			w.AppendBulkString(val)
+		}
+	}
+}
+
+func (s *store) llen(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "list", func(e *entry) {
+		if e == nil {
+			w.AppendInt(0)
+			return
+		}
+		w.AppendInt(int64(e.obj.(*list).len()))
+	})
+}
+
+func (s *store) lrange(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	start, err := c.Arg(1).Int()
+	if err != nil {
+		w.AppendError(errNotInteger)
+		return
+	}
+	stop, err := c.Arg(2).Int()
+	if err != nil {
+		w.AppendError(errNotInteger)
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "list", func(e *entry) {
+		if e == nil {
+			w.AppendArrayLen(0)
+			return
+		}
+
+		l := e.obj.(*list)
+		n := int64(l.len())
+		if start < 0 {
+			start += n
+		}
+		if stop < 0 {
+			stop += n
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= n {
+			stop = n - 1
+		}
+		if start > stop {
+			w.AppendArrayLen(0)
+			return
+		}
+
+		w.AppendArrayLen(int(stop - start + 1))
+		for i := start; i <= stop; i++ {
+			w.AppendBulkString(l.index(int(i)))
+		}
+	})
+}
+
+// moveElem pops an element from the list at src and pushes it to the
+// list at dst, which may be the same key, atomically. It returns false
+// if src does not exist, or an error reply.
+func (s *store) moveElem(src, dst string, from, to bool) (string, bool, string) {
+	keys, pos := []string{src}, map[string]int{src: 0}
+	if dst != src {
+		keys, pos[dst] = append(keys, dst), 1
+	}
+
+	var val string
+	var found bool
+	var msg string
+	s.keys.modifyAll(keys, func(cur []*entry) ([]*entry, bool) {
+		se, de := cur[pos[src]], cur[pos[dst]]
+		if se == nil {
+			return nil, false
+		}
+		if !typed(se, "list") || !typed(de, "list") {
+			msg = errWrongType
+			return nil, false
+		}
+		sl, dl := se.obj.(*list), newList()
+		if de != nil {
+			dl = de.obj.(*list)
+		}
+
+		found = true
+		val = sl.pop(from)
+		dl.push(val, to)
+
+		next := append([]*entry(nil), cur...)
+		if sl.len() == 0 {
+			next[pos[src]] = nil
+		}
+		if de == nil {
+			next[pos[dst]] = newEntry(nil)
+			next[pos[dst]].obj = dl
+		}
+		return next, true
+	})
+	return val, found, msg
+}
+
+// lmove implements LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+// https://redis.io/commands/lmove
+func (s *store) lmove(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 4 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	from, ok1 := parseWhere(c.Arg(2))
+	to, ok2 := parseWhere(c.Arg(3))
+	if !ok1 || !ok2 {
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	val, found, msg := s.moveElem(c.Arg(0).String(), c.Arg(1).String(), from, to)
+	switch {
+	case msg != "":
+		w.AppendError(msg)
+	case !found:
+		w.AppendNil()
+	default:
+		w.AppendBulkString(val)
+	}
+}
+
+// blmove implements BLMOVE source destination LEFT|RIGHT LEFT|RIGHT
+// timeout, blocking until source holds a list. Moves are propagated as
+// LMOVE.
+func (s *store) blmove(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 5 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	from, ok1 := parseWhere(c.Arg(2))
+	to, ok2 := parseWhere(c.Arg(3))
+	if !ok1 || !ok2 {
+		w.AppendError("ERR syntax error")
+		return
+	}
+	timeout, msg := parseTimeout(c.Arg(4))
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	src, dst := c.Arg(0).String(), c.Arg(1).String()
+	var val string
+	redeo.Rewrite(c.Context())
+	ok := s.blocked.block(c.Context(), []string{src}, timeout, func(string) bool {
+		var found bool
+		if val, found, msg = s.moveElem(src, dst, from, to); found && msg == "" {
+			redeo.Rewrite(c.Context(), resp.NewCommand("LMOVE", c.Args[:4]...))
+		}
+		return found || msg != ""
+	})
+
+	switch {
+	case msg != "":
+		w.AppendError(msg)
+	case !ok:
+		w.AppendNil()
+	default:
+		w.AppendBulkString(val)
+	}
+}
+
+func (s *store) blpop(w resp.ResponseWriter, c *resp.Command) {
+	s.bpop(w, c, true)
+}
+
+func (s *store) brpop(w resp.ResponseWriter, c *resp.Command) {
+	s.bpop(w, c, false)
+}
+
+// bpop implements BLPOP and BRPOP key [key ...] timeout, blocking until
+// one of the keys holds a list. Clients blocked on the same key are
+// served in the order they blocked. Pops are propagated as LPOP or RPOP.
+func (s *store) bpop(w resp.ResponseWriter, c *resp.Command, left bool) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	timeout, msg := parseTimeout(c.Arg(c.ArgN() - 1))
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	keys := make([]string, 0, c.ArgN()-1)
+	for _, arg := range c.Args[:c.ArgN()-1] {
+		keys = append(keys, arg.String())
+	}
+
+	name := "RPOP"
+	if left {
+		name = "LPOP"
+	}
+
+	var key string
+	var res []string
+	redeo.Rewrite(c.Context())
+	ok := s.blocked.block(c.Context(), keys, timeout, func(k string) bool {
+		var found bool
+		if res, found, msg = s.popFromList(k, 1, left); found && msg == "" {
+			redeo.Rewrite(c.Context(), resp.NewCommand(name, resp.CommandArgument(k)))
+		}
+		key = k
+		return found || msg != ""
+	})
+
+	switch {
+	case msg != "":
+		w.AppendError(msg)
+	case !ok:
+		resp.AppendNilArray(w)
+	default:
+		w.AppendArrayLen(2)
+		w.AppendBulkString(key)
+		w.AppendBulkString(res[0])
+	}
+}
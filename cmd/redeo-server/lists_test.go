@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("lists", func() {
+	var subject *store
+
+	// blocked waits until n clients are blocked
+	blocked := func(n int) {
+		Eventually(func() int32 { return atomic.LoadInt32(&subject.blocked.n) }).Should(Equal(int32(n)))
+	}
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.rpush, "RPUSH", "l", "b", "c")).To(Equal(int64(2)))
+		Expect(call(subject.lpush, "LPUSH", "l", "a")).To(Equal(int64(3)))
+	})
+
+	It("should push and pop elements", func() {
+		Expect(call(subject.lrange, "LRANGE", "l", "0", "-1")).To(Equal([]interface{}{"a", "b", "c"}))
+		Expect(call(subject.lrange, "LRANGE", "l", "-2", "10")).To(Equal([]interface{}{"b", "c"}))
+		Expect(call(subject.lrange, "LRANGE", "l", "2", "1")).To(BeEmpty())
+		Expect(call(subject.llen, "LLEN", "l")).To(Equal(int64(3)))
+		Expect(call(subject.keyType, "TYPE", "l")).To(Equal("list"))
+
+		Expect(call(subject.lpop, "LPOP", "l")).To(Equal("a"))
+		Expect(call(subject.rpop, "RPOP", "l", "5")).To(Equal([]interface{}{"c", "b"}))
+		Expect(call(subject.exists, "EXISTS", "l")).To(Equal(int64(0)))
+		Expect(call(subject.lpop, "LPOP", "l")).To(BeNil())
+		Expect(call(subject.lpop, "LPOP", "l", "1")).To(BeNil())
+		Expect(call(subject.lpop, "LPOP", "l", "-1")).To(MatchError("ERR value is out of range, must be positive"))
+	})
+
+	It("should grow past its capacity", func() {
+		for i := 0; i < 10; i++ {
+			call(subject.lpush, "LPUSH", "l", "x")
+			call(subject.rpush, "RPUSH", "l", "y")
+		}
+		Expect(call(subject.llen, "LLEN", "l")).To(Equal(int64(23)))
+		Expect(call(subject.lrange, "LRANGE", "l", "9", "13")).To(Equal([]interface{}{"x", "a", "b", "c", "y"}))
+	})
+
+	It("should move elements", func() {
+		Expect(call(subject.lmove, "LMOVE", "l", "l", "LEFT", "RIGHT")).To(Equal("a"))
+		Expect(call(subject.lrange, "LRANGE", "l", "0", "-1")).To(Equal([]interface{}{"b", "c", "a"}))
+		Expect(call(subject.lmove, "LMOVE", "l", "d", "right", "left")).To(Equal("a"))
+		Expect(call(subject.lmove, "LMOVE", "l", "d", "RIGHT", "LEFT")).To(Equal("c"))
+		Expect(call(subject.lrange, "LRANGE", "d", "0", "-1")).To(Equal([]interface{}{"c", "a"}))
+		Expect(call(subject.lmove, "LMOVE", "x", "d", "LEFT", "LEFT")).To(BeNil())
+
+		Expect(call(subject.set, "SET", "s", "v")).To(Equal("OK"))
+		Expect(call(subject.lmove, "LMOVE", "l", "s", "LEFT", "LEFT")).To(MatchError(errWrongType))
+		Expect(call(subject.lrange, "LRANGE", "l", "0", "-1")).To(Equal([]interface{}{"b"}))
+		Expect(call(subject.lmove, "LMOVE", "l", "d", "UP", "LEFT")).To(MatchError("ERR syntax error"))
+	})
+
+	It("should pop without blocking", func() {
+		Expect(call(subject.blpop, "BLPOP", "x", "l", "0")).To(Equal([]interface{}{"l", "a"}))
+		Expect(call(subject.brpop, "BRPOP", "l", "0")).To(Equal([]interface{}{"l", "c"}))
+		Expect(call(subject.blmove, "BLMOVE", "l", "d", "LEFT", "LEFT", "0")).To(Equal("b"))
+		Expect(call(subject.brpop, "BRPOP", "l", "0.01")).To(BeNil())
+		Expect(call(subject.blmove, "BLMOVE", "l", "d", "LEFT", "LEFT", "0.01")).To(BeNil())
+		Expect(call(subject.blpop, "BLPOP", "l", "-1")).To(MatchError("ERR timeout is negative"))
+
+		Expect(call(subject.set, "SET", "s", "v")).To(Equal("OK"))
+		Expect(call(subject.blpop, "BLPOP", "s", "0")).To(MatchError(errWrongType))
+	})
+
+	It("should wake blocked clients in order", func() {
+		var replies [3]chan interface{}
+		for i := range replies {
+			replies[i] = make(chan interface{}, 1)
+			go func(ch chan interface{}) { ch <- call(subject.blpop, "BLPOP", "x", "y", "5") }(replies[i])
+			blocked(i + 1)
+		}
+
+		Expect(call(subject.rpush, "RPUSH", "y", "a", "b")).To(Equal(int64(2)))
+		Expect(<-replies[0]).To(Equal([]interface{}{"y", "a"}))
+		Expect(<-replies[1]).To(Equal([]interface{}{"y", "b"}))
+		Expect(call(subject.exists, "EXISTS", "y")).To(Equal(int64(0)))
+
+		Expect(call(subject.lpush, "LPUSH", "x", "c")).To(Equal(int64(1)))
+		Expect(<-replies[2]).To(Equal([]interface{}{"x", "c"}))
+		Expect(subject.blocked.waiters).To(BeEmpty())
+	})
+
+	It("should wake clients blocked on moved elements", func() {
+		moved := make(chan interface{}, 1)
+		go func() { moved <- call(subject.blmove, "BLMOVE", "x", "y", "LEFT", "RIGHT", "5") }()
+		blocked(1)
+		popped := make(chan interface{}, 1)
+		go func() { popped <- call(subject.brpop, "BRPOP", "y", "5") }()
+		blocked(2)
+
+		Expect(call(subject.lpush, "LPUSH", "x", "a")).To(Equal(int64(1)))
+		Expect(<-moved).To(Equal("a"))
+		Expect(<-popped).To(Equal([]interface{}{"y", "a"}))
+		Expect(call(subject.exists, "EXISTS", "x", "y")).To(Equal(int64(0)))
+	})
+})
@@ -0,0 +1,171 @@
+// Command redeo-server is a reference server with an in-memory string
+// store. It loads its configuration from an optional redis.conf-style
+// file and REDEO_* environment variables, reloads it on SIGHUP and shuts
+// down gracefully on SIGINT and SIGTERM. The memory used by the store is
+// limited by the maxmemory, maxmemory-policy and maxmemory-samples
+// directives of the file, like in Redis. Commands of the store can be
+// executed atomically with MULTI and EXEC. The commands of modules are
+// named <module>.<command>: JSON documents are stored via the JSON.SET,
+// JSON.GET and JSON.DEL commands of the JSON module. Hashes can be
+// queried by field via the secondary indexes of the search (FT) module,
+// see FT.CREATE and FT.SEARCH.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/johntech-o/redeo"
+)
+
+var flags struct {
+	addr     string
+	config   string
+	shutdown time.Duration
+}
+
+func init() {
+	flag.StringVar(&flags.addr, "addr", ":6379", "The TCP address to bind to")
+	flag.StringVar(&flags.config, "config", "", "Path to a redis.conf-style config file")
+	flag.DurationVar(&flags.shutdown, "shutdown-timeout", 10*time.Second, "Max time to wait for clients on shutdown")
+}
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run() error {
+	var file *redeo.ConfigFile
+	if flags.config != "" {
+		f, err := redeo.ReadConfigFile(flags.config)
+		if err != nil {
+			return err
+		}
+		file = f
+	}
+
+	config, err := loadConfig(file)
+	if err != nil {
+		return err
+	}
+
+	srv := redeo.NewServer(config)
+	broker := redeo.NewPubSubBroker()
+	db := newStore(srv.Info())
+	if err := db.configureEviction(file); err != nil {
+		return err
+	}
+	db.register(srv)
+
+	srv.Handle("ping", redeo.Ping())
+	srv.Handle("echo", redeo.Echo())
+	srv.Handle("hello", redeo.Hello(srv))
+	srv.Handle("info", redeo.Info(srv))
+	srv.Handle("role", redeo.Role(srv))
+	srv.Handle("lolwut", redeo.Lolwut(srv))
+	srv.Handle("reset", redeo.Reset(broker))
+	srv.OnDisconnect(broker)
+	srv.Handle("readonly", redeo.ReadOnly())
+	srv.Handle("readwrite", redeo.ReadWrite())
+	srv.Handle("publish", broker.Publish())
+	srv.Handle("subscribe", broker.Subscribe())
+	srv.Handle("pubsub", broker.PubSub())
+	srv.Handle("client", redeo.SubCommands{
+		"list":    redeo.ClientList(srv),
+		"kill":    redeo.ClientKill(srv),
+		"id":      redeo.ClientID(),
+		"info":    redeo.ClientSelf(),
+		"setname": redeo.ClientSetName(),
+		"getname": redeo.ClientGetName(),
+		"setinfo": redeo.ClientSetInfo(),
+	})
+	srv.Handle("config", redeo.SubCommands{
+		"rewrite":   redeo.ConfigRewrite(srv, file),
+		"resetstat": redeo.ResetStat(srv),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go srv.ReloadOnSignal(ctx, func() (*redeo.Config, error) {
+		if file != nil {
+			if err := file.Reload(); err != nil {
+				return nil, err
+			}
+		}
+		config, err := loadConfig(file)
+		if err != nil {
+			return nil, err
+		}
+		// validate before applying anything, the server validates again
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+		if err := db.configureEviction(file); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}, func(restart []string, err error) {
+		if err != nil {
+			log.Printf("reload failed: %v", err)
+		} else if len(restart) != 0 {
+			log.Printf("reloaded, restart required to apply %v", restart)
+		} else {
+			log.Printf("reloaded")
+		}
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("waiting for connections on %s", flags.addr)
+		errs <- srv.ListenAndServe(flags.addr)
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-sigs:
+		log.Printf("received %s, shutting down", sig)
+	}
+
+	sctx, scancel := context.WithTimeout(context.Background(), flags.shutdown)
+	defer scancel()
+
+	if err := srv.Shutdown(sctx); err != nil {
+		return err
+	}
+	if err := <-errs; err != redeo.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// loadConfig parses the config file, if any, and applies the
+// environment on top
+func loadConfig(file *redeo.ConfigFile) (*redeo.Config, error) {
+	config := new(redeo.Config)
+	if file != nil {
+		c, err := file.Config()
+		if err != nil {
+			return nil, err
+		}
+		config = c
+	}
+	if err := config.LoadEnv(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/johntech-o/redeo"
+)
+
+// module is an extension of the store in the style of Redis modules. The
+// commands of modules are named <module>.<command>, e.g. JSON.SET, so they
+// do not clash with core commands or the commands of other modules.
+type module struct {
+	// name is the name of the module, the prefix of its commands
+	name string
+	// register registers the commands of the module via r, which adds
+	// the prefix
+	register func(s *store, r registrar)
+}
+
+// modules are the modules of the store, registered by store.register
+var modules = []module{
+	{name: "json", register: registerJSON},
+	{name: "ft", register: registerSearch},
+}
+
+// registrar registers commands of the store with a server, prefixed for
+// modules. Commands are wrapped with transactional.
+type registrar struct {
+	srv    *redeo.Server
+	s      *store
+	prefix string
+}
+
+// read registers a read command, see store.transactional for arity
+func (r registrar) read(name string, arity int, h redeo.HandlerFunc) {
+	r.srv.HandleFunc(r.prefix+name, r.s.transactional(h, arity, false))
+}
+
+// write registers a write command, see store.transactional for arity
+func (r registrar) write(name string, arity int, h redeo.HandlerFunc) {
+	r.srv.HandleWriteFunc(r.prefix+name, r.s.transactional(h, arity, false))
+}
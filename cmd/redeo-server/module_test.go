@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/redeotest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("modules", func() {
+	var srv *redeo.Server
+
+	BeforeEach(func() {
+		srv = redeo.NewServer(nil)
+		newStore(srv.Info()).register(srv)
+	})
+
+	It("should register commands with the module prefix", func() {
+		Expect(redeotest.Transcript(srv,
+			"JSON.SET doc $ 1\r\n",
+			"json.get doc\r\n",
+			"GET doc\r\n",
+			"FT._LIST\r\n",
+			"_LIST\r\n",
+			"JSON.GET\r\n",
+		)).To(Equal([]byte("+OK\r\n$1\r\n1\r\n" +
+			"-" + errWrongType + "\r\n" +
+			"*0\r\n" +
+			"-ERR unknown command '_LIST'\r\n" +
+			"-ERR wrong number of arguments for 'JSON.GET' command\r\n")))
+	})
+})
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// rewrite replaces the form in which c is propagated to replicas and
+// journals with the commands returned by fn, so commands which depend on
+// the time, randomness or blocking are applied consistently. fn is only
+// called if c is propagated, it may return no commands if c had no
+// effect. See redeo.Rewrite.
+func rewrite(c *resp.Command, fn func() []*resp.Command) {
+	if redeo.Rewrite(c.Context()) {
+		redeo.Rewrite(c.Context(), fn()...)
+	}
+}
+
+// unixMillis formats a deadline in unix nanoseconds as a PXAT or
+// PEXPIREAT argument
+func unixMillis(deadline int64) resp.CommandArgument {
+	return resp.CommandArgument(strconv.FormatInt(deadline/int64(time.Millisecond), 10))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("propagation", func() {
+	var subject *store
+
+	// propagated calls a handler and returns the commands it is
+	// propagated as, space separated
+	propagated := func(h redeo.HandlerFunc, name string, args ...string) []string {
+		cmd := redeotest.NewCommand(name, args...)
+		ctx, p := redeo.WithPropagation(context.Background())
+		cmd.SetContext(ctx)
+		h(redeotest.NewRecorder(), cmd)
+		return formatCmds(p.Commands(cmd))
+	}
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+	})
+
+	It("should propagate absolute deadlines", func() {
+		Expect(propagated(subject.set, "SET", "k", "v")).To(Equal([]string{"SET k v"}))
+		Expect(propagated(subject.set, "SET", "k", "v", "NX")).To(BeEmpty())
+		Expect(propagated(subject.set, "SET", "k", "v", "KEEPTTL", "GET")).To(Equal([]string{"SET k v KEEPTTL"}))
+		Expect(propagated(subject.set, "SET", "k", "v", "EXAT", "4000000000")).To(Equal([]string{"SET k v PXAT 4000000000000"}))
+
+		Expect(propagated(subject.expire("pxat"), "PEXPIREAT", "k", "4000000000001")).To(Equal([]string{"PEXPIREAT k 4000000000001"}))
+		Expect(propagated(subject.expire("ex"), "EXPIRE", "k", "100", "NX")).To(BeEmpty())
+		Expect(propagated(subject.expire("exat"), "EXPIREAT", "x", "4000000000")).To(BeEmpty())
+
+		res := propagated(subject.expire("ex"), "EXPIRE", "k", "100")
+		Expect(res).To(HaveLen(1))
+		Expect(res[0]).To(HavePrefix("PEXPIREAT k "))
+		Expect(expiresOf(subject, "k") / int64(time.Millisecond)).To(BeNumerically("~", time.Now().Add(100*time.Second).UnixNano()/int64(time.Millisecond), 1000))
+
+		Expect(propagated(subject.getex, "GETEX", "k", "PXAT", "4000000000002")).To(Equal([]string{"PEXPIREAT k 4000000000002"}))
+		Expect(propagated(subject.getex, "GETEX", "k", "PERSIST")).To(Equal([]string{"PERSIST k"}))
+		Expect(propagated(subject.getex, "GETEX", "k", "PERSIST")).To(BeEmpty())
+		Expect(propagated(subject.getex, "GETEX", "k")).To(BeEmpty())
+		Expect(propagated(subject.expire("px"), "PEXPIRE", "k", "-1")).To(Equal([]string{"DEL k"}))
+	})
+
+	It("should propagate results", func() {
+		Expect(propagated(subject.incrbyfloat, "INCRBYFLOAT", "f", "0.1")).To(Equal([]string{"SET f 0.1 KEEPTTL"}))
+		Expect(propagated(subject.incrbyfloat, "INCRBYFLOAT", "f", "0.2")).To(Equal([]string{"SET f 0.30000000000000004 KEEPTTL"}))
+
+		Expect(call(subject.sadd, "SADD", "s", "m")).To(Equal(int64(1)))
+		Expect(propagated(subject.spop, "SPOP", "s")).To(Equal([]string{"SREM s m"}))
+		Expect(propagated(subject.spop, "SPOP", "s")).To(BeEmpty())
+
+		id := propagated(subject.xadd, "XADD", "st", "NOMKSTREAM", "*", "f", "v")
+		Expect(id).To(BeEmpty())
+		id = propagated(subject.xadd, "XADD", "st", "*", "f", "v")
+		Expect(id).To(HaveLen(1))
+		Expect(id[0]).To(MatchRegexp(`^XADD st \d+-0 f v$`))
+	})
+
+	It("should propagate blocking commands without blocking", func() {
+		Expect(call(subject.rpush, "RPUSH", "l", "a", "b")).To(Equal(int64(2)))
+		Expect(propagated(subject.blpop, "BLPOP", "x", "l", "0")).To(Equal([]string{"LPOP l"}))
+		Expect(propagated(subject.brpop, "BRPOP", "l", "0")).To(Equal([]string{"RPOP l"}))
+		Expect(propagated(subject.blpop, "BLPOP", "l", "0.01")).To(BeEmpty())
+
+		Expect(call(subject.rpush, "RPUSH", "l", "c")).To(Equal(int64(1)))
+		Expect(propagated(subject.blmove, "BLMOVE", "l", "m", "LEFT", "RIGHT", "0")).To(Equal([]string{"LMOVE l m LEFT RIGHT"}))
+
+		Expect(call(subject.zadd, "ZADD", "z", "1", "a")).To(Equal(int64(1)))
+		Expect(propagated(subject.bzpopmax, "BZPOPMAX", "z", "0")).To(Equal([]string{"ZPOPMAX z"}))
+
+		Expect(call(subject.xadd, "XADD", "st", "1-1", "f", "v")).To(Equal("1-1"))
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "st", "g", "0")).To(Equal("OK"))
+		Expect(propagated(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "block", "COUNT", "1", "BLOCK", "0", "STREAMS", "st", ">")).
+			To(Equal([]string{"XREADGROUP GROUP g block COUNT 1 STREAMS st >"}))
+		Expect(propagated(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "10", "STREAMS", "st", ">")).To(BeEmpty())
+	})
+
+	It("should not hold up writes while blocked", func() {
+		srv := redeo.NewServer(nil)
+		subject.register(srv)
+		prop := new(commandLog)
+		srv.AddPropagator(prop)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		// send writes a request, unless empty, and reads the expected reply
+		send := func(cn net.Conn, req, reply string) string {
+			if req != "" {
+				_, err := cn.Write([]byte(req))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(cn.SetReadDeadline(time.Now().Add(time.Second))).To(Succeed())
+			buf := make([]byte, len(reply))
+			_, err := io.ReadFull(cn, buf)
+			Expect(err).NotTo(HaveOccurred())
+			return string(buf)
+		}
+
+		blocked, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer blocked.Close()
+		writer, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer writer.Close()
+
+		_, err = blocked.Write([]byte("BLPOP l 0\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() int32 { return atomic.LoadInt32(&subject.blocked.n) }).Should(Equal(int32(1)))
+
+		reply := "+OK\r\n:1\r\n+OK\r\n"
+		Expect(send(writer, "SET k v\r\nRPUSH l a\r\nSET k w\r\n", reply)).To(Equal(reply))
+		reply = "*2\r\n$1\r\nl\r\n$1\r\na\r\n"
+		Expect(send(blocked, "", reply)).To(Equal(reply))
+
+		// pops are propagated right after the writes which serve them
+		Eventually(prop.Commands).Should(Equal([]string{"SET k v", "RPUSH l a", "LPOP l", "SET k w"}))
+	})
+
+	It("should propagate transactions", func() {
+		w := redeotest.NewRecorder()
+		ctx, p := redeo.WithPropagation(context.Background())
+		exec := redeotest.NewCommand("EXEC")
+		exec.SetContext(ctx)
+
+		t := subject.begin()
+		for _, q := range []struct {
+			h     redeo.HandlerFunc
+			write bool
+			args  []string
+		}{
+			{subject.set, true, []string{"SET", "k", "v", "PXAT", "4000000000000"}},
+			{subject.get, false, []string{"GET", "k"}},
+			{subject.blpop, true, []string{"BLPOP", "l", "0"}},
+			{subject.rpush, true, []string{"RPUSH", "l", "a"}},
+			{subject.blpop, true, []string{"BLPOP", "l", "0"}},
+		} {
+			cmd := redeotest.NewCommand(q.args[0], q.args[1:]...)
+			if !q.write {
+				t.queue(q.h, cmd)
+				continue
+			}
+
+			// queued write commands are not propagated
+			qctx, qp := redeo.WithPropagation(context.Background())
+			cmd.SetContext(qctx)
+			t.queue(q.h, cmd)
+			Expect(qp.Commands(cmd)).To(BeEmpty())
+		}
+		subject.commit(exec.Context(), w, t)
+
+		Expect(formatCmds(p.Commands(exec))).To(Equal([]string{
+			"MULTI",
+			"SET k v PXAT 4000000000000",
+			"RPUSH l a",
+			"LPOP l",
+			"EXEC",
+		}))
+	})
+
+})
+
+// formatCmds formats commands, space separated
+func formatCmds(cmds []*resp.Command) []string {
+	res := []string{}
+	for _, c := range cmds {
+		parts := []string{c.Name}
+		for _, arg := range c.Args {
+			parts = append(parts, arg.String())
+		}
+		res = append(res, strings.Join(parts, " "))
+	}
+	return res
+}
+
+// expiresOf returns the deadline of a key
+func expiresOf(s *store, key string) int64 {
+	e, ok := s.keys.get(key)
+	Expect(ok).To(BeTrue())
+	return e.expires
+}
+
+// commandLog is a propagator which records commands, space separated
+type commandLog struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (l *commandLog) Propagate(cmd *resp.Command) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cmds = append(l.cmds, formatCmds([]*resp.Command{cmd})...)
+}
+
+func (l *commandLog) Commands() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.cmds...)
+}
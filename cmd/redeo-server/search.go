@@ -0,0 +1,585 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Error replies of the search module, like RediSearch's
+const (
+	errIndexExists = "Index already exists"
+	errNoIndex     = "Unknown Index name"
+	errNoFields    = "Fields arguments are missing"
+)
+
+// tagField is a TAG field of an index. Values are split by sep into
+// tags, which are lower-cased unless the field is case sensitive.
+type tagField struct {
+	name          string
+	sep           string
+	caseSensitive bool
+}
+
+// tags returns the tags of val
+func (f *tagField) tags(val string) []string {
+	var tags []string
+	for _, tag := range strings.Split(val, f.sep) {
+		if tag = f.norm(strings.TrimSpace(tag)); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// norm normalizes a tag for matching
+func (f *tagField) norm(tag string) string {
+	if f.caseSensitive {
+		return tag
+	}
+	return strings.ToLower(tag)
+}
+
+// index is a secondary index over the TAG fields of hashes, with keys
+// starting with any of its prefixes. It is updated with every write,
+// while holding the shard's lock of the key, see keyspace.updated.
+type index struct {
+	name     string
+	prefixes []string
+	fields   []tagField
+
+	mu sync.RWMutex
+	// values holds the keys by field and tag, docs the tags of the
+	// indexed keys by field
+	values map[string]map[string]map[string]struct{}
+	docs   map[string]map[string][]string
+}
+
+func newIndex(name string, prefixes []string, fields []tagField) *index {
+	return &index{
+		name:     name,
+		prefixes: prefixes,
+		fields:   fields,
+		values:   make(map[string]map[string]map[string]struct{}),
+		docs:     make(map[string]map[string][]string),
+	}
+}
+
+// field returns the field called name, or nil if it is not indexed
+func (idx *index) field(name string) *tagField {
+	for i := range idx.fields {
+		if idx.fields[i].name == name {
+			return &idx.fields[i]
+		}
+	}
+	return nil
+}
+
+// covers reports whether key starts with any of the prefixes
+func (idx *index) covers(key string) bool {
+	if len(idx.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range idx.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// update re-indexes key with e, which may be nil if key was deleted.
+// Objects must be locked, see keyspace.view.
+func (idx *index) update(key string, e *entry) {
+	if !idx.covers(key) {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(key)
+	if e == nil {
+		return
+	}
+	h, ok := e.obj.(*hash)
+	if !ok {
+		return
+	}
+
+	doc := make(map[string][]string)
+	for i := range idx.fields {
+		f := &idx.fields[i]
+		val, ok := h.get(f.name)
+		if !ok {
+			continue
+		}
+
+		tags := f.tags(val)
+		for _, tag := range tags {
+			keys := idx.values[f.name][tag]
+			if keys == nil {
+				if idx.values[f.name] == nil {
+					idx.values[f.name] = make(map[string]map[string]struct{})
+				}
+				keys = make(map[string]struct{})
+				idx.values[f.name][tag] = keys
+			}
+			keys[key] = struct{}{}
+		}
+		doc[f.name] = tags
+	}
+	idx.docs[key] = doc
+}
+
+// remove removes key, idx must be locked
+func (idx *index) remove(key string) {
+	for field, tags := range idx.docs[key] {
+		for _, tag := range tags {
+			keys := idx.values[field][tag]
+			if delete(keys, key); len(keys) == 0 {
+				delete(idx.values[field], tag)
+			}
+		}
+	}
+	delete(idx.docs, key)
+}
+
+// reset removes all keys
+func (idx *index) reset() {
+	idx.mu.Lock()
+	idx.values = make(map[string]map[string]map[string]struct{})
+	idx.docs = make(map[string]map[string][]string)
+	idx.mu.Unlock()
+}
+
+// search returns the sorted keys matching all clauses, or all keys if
+// there are none
+func (idx *index) search(clauses []tagClause) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var matched map[string]struct{}
+	if len(clauses) == 0 {
+		matched = make(map[string]struct{}, len(idx.docs))
+		for key := range idx.docs {
+			matched[key] = struct{}{}
+		}
+	}
+	for i, cl := range clauses {
+		keys := idx.match(cl)
+		if i == 0 {
+			matched = keys
+			continue
+		}
+		for key := range matched {
+			if _, ok := keys[key]; !ok {
+				delete(matched, key)
+			}
+		}
+	}
+
+	res := make([]string, 0, len(matched))
+	for key := range matched {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// match returns the keys matching a clause, idx must be locked
+func (idx *index) match(cl tagClause) map[string]struct{} {
+	f := idx.field(cl.field)
+	matched := make(map[string]struct{})
+	for _, v := range cl.values {
+		tag := f.norm(v.tag)
+		if !v.prefix {
+			for key := range idx.values[f.name][tag] {
+				matched[key] = struct{}{}
+			}
+			continue
+		}
+		for t, keys := range idx.values[f.name] {
+			if strings.HasPrefix(t, tag) {
+				for key := range keys {
+					matched[key] = struct{}{}
+				}
+			}
+		}
+	}
+	return matched
+}
+
+// tagValue is an alternative of a query clause, matching tags equal to
+// or, if prefix is set, starting with tag
+type tagValue struct {
+	tag    string
+	prefix bool
+}
+
+// tagClause is a clause of a query, @field:{value | value* ...}
+type tagClause struct {
+	field  string
+	values []tagValue
+}
+
+// parseQuery parses a query, which is either * or space-separated
+// clauses. Special characters of values may be escaped with a backslash.
+func parseQuery(q string) ([]tagClause, bool) {
+	q = strings.TrimSpace(q)
+	if q == "*" {
+		return nil, true
+	}
+
+	var clauses []tagClause
+	for ; q != ""; q = strings.TrimSpace(q) {
+		if q[0] != '@' {
+			return nil, false
+		}
+		n := strings.Index(q, ":")
+		if n < 2 || !strings.HasPrefix(strings.TrimSpace(q[n+1:]), "{") {
+			return nil, false
+		}
+		cl := tagClause{field: q[1:n]}
+		q = strings.TrimSpace(q[n+1:])[1:]
+
+		// star is the position of the last unescaped *
+		var tag []byte
+		var escaped, closed bool
+		star := -1
+		for len(q) != 0 && !closed {
+			c := q[0]
+			q = q[1:]
+			switch {
+			case escaped:
+				tag, escaped = append(tag, c), false
+			case c == '\\':
+				escaped = true
+			case c == '|' || c == '}':
+				raw := strings.TrimRight(string(tag), " ")
+				v := tagValue{tag: strings.TrimSpace(raw)}
+				if star >= 0 && star == len(raw)-1 {
+					v.tag, v.prefix = strings.TrimSuffix(v.tag, "*"), true
+				}
+				if v.tag == "" {
+					return nil, false
+				}
+				cl.values, tag, star = append(cl.values, v), tag[:0], -1
+				closed = c == '}'
+			default:
+				if c == '*' {
+					star = len(tag)
+				}
+				tag = append(tag, c)
+			}
+		}
+		if !closed {
+			return nil, false
+		}
+		clauses = append(clauses, cl)
+	}
+	return clauses, len(clauses) != 0
+}
+
+// indexes are the secondary indexes of a store
+type indexes struct {
+	mu     sync.RWMutex
+	byName map[string]*index
+}
+
+// update re-indexes key in all indexes, see index.update
+func (ix *indexes) update(key string, e *entry) {
+	ix.mu.RLock()
+	for _, idx := range ix.byName {
+		idx.update(key, e)
+	}
+	ix.mu.RUnlock()
+}
+
+// get returns the index called name, or nil
+func (ix *indexes) get(name string) *index {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.byName[name]
+}
+
+// add adds idx, it reports false if an index of the same name exists
+func (ix *indexes) add(idx *index) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if _, ok := ix.byName[idx.name]; ok {
+		return false
+	}
+	if ix.byName == nil {
+		ix.byName = make(map[string]*index)
+	}
+	ix.byName[idx.name] = idx
+	return true
+}
+
+// drop removes the index called name, it reports whether it existed
+func (ix *indexes) drop(name string) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	_, ok := ix.byName[name]
+	delete(ix.byName, name)
+	return ok
+}
+
+// names returns the sorted names of all indexes
+func (ix *indexes) names() []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	names := make([]string, 0, len(ix.byName))
+	for name := range ix.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reset removes all keys from all indexes, after the keyspace was
+// flushed
+func (ix *indexes) reset() {
+	ix.mu.RLock()
+	for _, idx := range ix.byName {
+		idx.reset()
+	}
+	ix.mu.RUnlock()
+}
+
+// registerSearch registers the commands of the search module
+func registerSearch(s *store, r registrar) {
+	r.write("create", -5, s.ftCreate)
+	r.read("search", -3, s.ftSearch)
+	r.write("dropindex", 2, s.ftDropIndex)
+	r.read("_list", 1, s.ftList)
+}
+
+// ftCreate implements FT.CREATE index [ON HASH] [PREFIX count prefix ...]
+// SCHEMA field TAG [SEPARATOR sep] [CASESENSITIVE] ..., indexing the
+// hashes with keys starting with any of the prefixes, or all hashes.
+// Only TAG fields are supported, existing hashes are indexed right away.
+// https://redis.io/commands/ft.create
+func (s *store) ftCreate(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 4 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var prefixes []string
+	i := 1
+	for ; i < c.ArgN() && !strings.EqualFold(c.Arg(i).String(), "schema"); i++ {
+		switch strings.ToLower(c.Arg(i).String()) {
+		case "on":
+			if i+1 >= c.ArgN() || !strings.EqualFold(c.Arg(i+1).String(), "hash") {
+				w.AppendError("ERR Only HASH indexes are supported")
+				return
+			}
+			i++
+		case "prefix":
+			if i+1 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			n, err := c.Arg(i + 1).Int()
+			if err != nil || n < 1 || int64(c.ArgN()-i-2) < n {
+				w.AppendError("ERR Bad arguments for PREFIX")
+				return
+			}
+			for _, arg := range c.Args[i+2 : i+2+int(n)] {
+				prefixes = append(prefixes, arg.String())
+			}
+			i += 1 + int(n)
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	var fields []tagField
+	for i++; i < c.ArgN(); i++ {
+		if i+1 >= c.ArgN() || !strings.EqualFold(c.Arg(i+1).String(), "tag") {
+			w.AppendError("ERR Only TAG fields are supported")
+			return
+		}
+		f := tagField{name: c.Arg(i).String(), sep: ","}
+		for i += 2; i < c.ArgN(); i++ {
+			if opt := strings.ToLower(c.Arg(i).String()); opt == "casesensitive" {
+				f.caseSensitive = true
+			} else if opt == "separator" && i+1 < c.ArgN() && len(c.Arg(i+1)) == 1 {
+				f.sep = c.Arg(i + 1).String()
+				i++
+			} else {
+				break
+			}
+		}
+		i--
+
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		w.AppendError(errNoFields)
+		return
+	}
+
+	idx := newIndex(c.Arg(0).String(), prefixes, fields)
+	if !s.search.add(idx) {
+		w.AppendError(errIndexExists)
+		return
+	}
+
+	// keys written since the index was added are indexed already,
+	// indexing them again while holding their lock is harmless
+	snap := s.keys.snapshot()
+	var keys []string
+	snap.each(func(key string, _ *entry) bool {
+		if idx.covers(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	snap.close()
+
+	for _, key := range keys {
+		s.keys.view(key, func(e *entry) { idx.update(key, e) })
+	}
+	w.AppendOK()
+}
+
+// ftSearch implements FT.SEARCH index query [NOCONTENT] [LIMIT offset
+// num]. The query is either * or clauses like @field:{value}, which all
+// must match. Clauses may list alternatives like @field:{a | b} and
+// match tags by prefix like @field:{val*}. Matching keys are replied in
+// lexicographical order, each with the fields of its hash.
+// https://redis.io/commands/ft.search
+func (s *store) ftSearch(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	noContent, offset, num := false, int64(0), int64(10)
+	for i := 2; i < c.ArgN(); i++ {
+		switch strings.ToLower(c.Arg(i).String()) {
+		case "nocontent":
+			noContent = true
+		case "limit":
+			if i+2 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			var err1, err2 error
+			offset, err1 = c.Arg(i + 1).Int()
+			num, err2 = c.Arg(i + 2).Int()
+			if err1 != nil || err2 != nil || offset < 0 || num < 0 {
+				w.AppendError(errNotInteger)
+				return
+			}
+			i += 2
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	idx := s.search.get(c.Arg(0).String())
+	if idx == nil {
+		w.AppendError(errNoIndex)
+		return
+	}
+	clauses, ok := parseQuery(c.Arg(1).String())
+	if !ok {
+		w.AppendError("ERR Syntax error in query")
+		return
+	}
+	for _, cl := range clauses {
+		if idx.field(cl.field) == nil {
+			w.AppendError("ERR Unknown field '" + cl.field + "'")
+			return
+		}
+	}
+
+	// drop keys which expired since they were indexed, or were flushed
+	matched := idx.search(clauses)
+	keys := matched[:0]
+	for _, key := range matched {
+		if s.KeyType(key) == "hash" {
+			keys = append(keys, key)
+		}
+	}
+
+	total := len(keys)
+	if offset > int64(len(keys)) {
+		offset = int64(len(keys))
+	}
+	keys = keys[offset:]
+	if num < int64(len(keys)) {
+		keys = keys[:num]
+	}
+
+	if noContent {
+		w.AppendArrayLen(1 + len(keys))
+	} else {
+		w.AppendArrayLen(1 + 2*len(keys))
+	}
+	w.AppendInt(int64(total))
+	for _, key := range keys {
+		w.AppendBulkString(key)
+		if noContent {
+			continue
+		}
+
+		s.keys.view(key, func(e *entry) {
+			var h *hash
+			if e != nil {
+				h, _ = e.obj.(*hash)
+			}
+			if h == nil {
+				w.AppendArrayLen(0)
+				return
+			}
+			w.AppendArrayLen(2 * h.len())
+			for _, field := range h.sortedFields() {
+				w.AppendBulkString(field)
+				w.AppendBulkString(h.fields[field])
+			}
+		})
+	}
+}
+
+// ftDropIndex implements FT.DROPINDEX index, the indexed hashes are
+// retained
+// https://redis.io/commands/ft.dropindex
+func (s *store) ftDropIndex(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	if !s.search.drop(c.Arg(0).String()) {
+		w.AppendError(errNoIndex)
+		return
+	}
+	w.AppendOK()
+}
+
+// ftList implements FT._LIST, replying with the names of all indexes
+func (s *store) ftList(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	names := s.search.names()
+	w.AppendArrayLen(len(names))
+	for _, name := range names {
+		w.AppendBulkString(name)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("search", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.hset, "HSET", "user:1", "name", "Alice", "city", "Berlin", "tags", "admin, dev")).To(Equal(int64(3)))
+		Expect(call(subject.hset, "HSET", "user:2", "name", "Bob", "city", "Boston", "tags", "dev")).To(Equal(int64(3)))
+		Expect(call(subject.hset, "HSET", "other:1", "city", "Berlin")).To(Equal(int64(1)))
+		Expect(call(subject.ftCreate, "FT.CREATE", "users", "ON", "HASH", "PREFIX", "1", "user:", "SCHEMA", "city", "TAG", "tags", "TAG", "SEPARATOR", ",")).To(Equal("OK"))
+	})
+
+	It("should create indexes", func() {
+		Expect(call(subject.ftCreate, "FT.CREATE", "users", "SCHEMA", "city", "TAG")).To(MatchError("Index already exists"))
+		Expect(call(subject.ftCreate, "FT.CREATE", "x", "SCHEMA", "city", "TEXT")).To(MatchError("ERR Only TAG fields are supported"))
+		Expect(call(subject.ftCreate, "FT.CREATE", "x", "ON", "JSON", "SCHEMA", "city", "TAG")).To(MatchError("ERR Only HASH indexes are supported"))
+		Expect(call(subject.ftCreate, "FT.CREATE", "x", "PREFIX", "0", "SCHEMA", "city", "TAG")).To(MatchError("ERR Bad arguments for PREFIX"))
+		Expect(call(subject.ftCreate, "FT.CREATE", "x", "PREFIX", "1", "a:", "SCHEMA")).To(MatchError("Fields arguments are missing"))
+		Expect(call(subject.ftCreate, "FT.CREATE", "all", "SCHEMA", "city", "TAG", "CASESENSITIVE")).To(Equal("OK"))
+		Expect(call(subject.ftList, "FT._LIST")).To(Equal([]interface{}{"all", "users"}))
+
+		Expect(call(subject.ftDropIndex, "FT.DROPINDEX", "all")).To(Equal("OK"))
+		Expect(call(subject.ftDropIndex, "FT.DROPINDEX", "all")).To(MatchError("Unknown Index name"))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "all", "*")).To(MatchError("Unknown Index name"))
+		Expect(call(subject.exists, "EXISTS", "other:1")).To(Equal(int64(1)))
+	})
+
+	It("should search by equality and prefix", func() {
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@city:{berlin}")).To(Equal([]interface{}{
+			int64(1), "user:1", []interface{}{"city", "Berlin", "name", "Alice", "tags", "admin, dev"},
+		}))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@city:{B*}", "NOCONTENT")).To(Equal([]interface{}{int64(2), "user:1", "user:2"}))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@tags:{dev} @city:{boston | paris}", "NOCONTENT")).To(Equal([]interface{}{int64(1), "user:2"}))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@tags:{admin}", "NOCONTENT")).To(Equal([]interface{}{int64(1), "user:1"}))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "*", "NOCONTENT", "LIMIT", "1", "5")).To(Equal([]interface{}{int64(2), "user:2"}))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@city:{paris}")).To(Equal([]interface{}{int64(0)}))
+
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@name:{bob}")).To(MatchError("ERR Unknown field 'name'"))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@city:berlin")).To(MatchError("ERR Syntax error in query"))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@city:{berlin")).To(MatchError("ERR Syntax error in query"))
+	})
+
+	It("should follow writes", func() {
+		Expect(call(subject.hset, "HSET", "user:2", "city", "Berlin")).To(Equal(int64(0)))
+		Expect(call(subject.hset, "HSET", "user:3", "city", "Bern")).To(Equal(int64(1)))
+		Expect(call(subject.hdel, "HDEL", "user:1", "city")).To(Equal(int64(1)))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "@city:{berlin | bern}", "NOCONTENT")).To(Equal([]interface{}{int64(2), "user:2", "user:3"}))
+
+		Expect(call(subject.del, "DEL", "user:2")).To(Equal(int64(1)))
+		Expect(call(subject.set, "SET", "user:3", "v")).To(Equal("OK"))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "*", "NOCONTENT")).To(Equal([]interface{}{int64(1), "user:1"}))
+
+		Expect(call(subject.flushall, "FLUSHALL")).To(Equal("OK"))
+		Expect(call(subject.ftSearch, "FT.SEARCH", "users", "*")).To(Equal([]interface{}{int64(0)}))
+	})
+
+	It("should skip expired keys", func() {
+		clock := redeo.NewMockClock(time.Now())
+		subject = newStore(redeo.NewServer(&redeo.Config{Clock: clock}).Info())
+		Expect(call(subject.ftCreate, "FT.CREATE", "idx", "SCHEMA", "color", "TAG")).To(Equal("OK"))
+		Expect(call(subject.hset, "HSET", "u:1", "color", "red")).To(Equal(int64(1)))
+		Expect(call(subject.hset, "HSET", "u:2", "color", "red")).To(Equal(int64(1)))
+		Expect(call(subject.expire("px"), "PEXPIRE", "u:1", "5")).To(Equal(int64(1)))
+
+		clock.Add(time.Second)
+		Expect(call(subject.ftSearch, "FT.SEARCH", "idx", "@color:{red}", "NOCONTENT")).To(Equal([]interface{}{int64(1), "u:2"}))
+		Expect(subject.search.get("idx").docs).NotTo(HaveKey("u:1"))
+	})
+
+})
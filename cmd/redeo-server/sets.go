@@ -0,0 +1,490 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// setEntrySize is the approximate overhead of each set member
+const setEntrySize = 48
+
+// set is a set object, like Redis' hashtable encoding
+type set struct {
+	members map[string]struct{}
+
+	// bytes is the approximate size, updated atomically
+	bytes int64
+}
+
+func newSet() *set {
+	return &set{members: make(map[string]struct{})}
+}
+
+func (st *set) typ() string      { return "set" }
+func (st *set) encoding() string { return "hashtable" }
+func (st *set) size() int64      { return atomic.LoadInt64(&st.bytes) }
+
+func (st *set) dup() object {
+	res := newSet()
+	for member := range st.members {
+		res.add(member)
+	}
+	return res
+}
+
+// len returns the number of members
+func (st *set) len() int { return len(st.members) }
+
+// has reports whether member exists
+func (st *set) has(member string) bool {
+	_, ok := st.members[member]
+	return ok
+}
+
+// add adds member, it reports whether it was added
+func (st *set) add(member string) bool {
+	if st.has(member) {
+		return false
+	}
+	st.members[member] = struct{}{}
+	atomic.AddInt64(&st.bytes, int64(len(member)+setEntrySize))
+	return true
+}
+
+// remove removes member, it reports whether it existed
+func (st *set) remove(member string) bool {
+	if !st.has(member) {
+		return false
+	}
+	delete(st.members, member)
+	atomic.AddInt64(&st.bytes, -int64(len(member)+setEntrySize))
+	return true
+}
+
+// setsOf returns the sets of entries, missing keys are empty sets. It
+// returns false if an entry holds another type.
+func setsOf(entries []*entry) ([]*set, bool) {
+	if !typedAll(entries, "set") {
+		return nil, false
+	}
+
+	sets := make([]*set, len(entries))
+	for i, e := range entries {
+		if e == nil {
+			sets[i] = newSet()
+		} else {
+			sets[i] = e.obj.(*set)
+		}
+	}
+	return sets, true
+}
+
+// setInter returns the intersection of sets, with up to limit members,
+// or all if limit is 0. Members of the smallest set are looked up in the
+// others, from the smallest to the largest.
+func setInter(sets []*set, limit int) *set {
+	sets = append([]*set(nil), sets...)
+	sort.Slice(sets, func(i, j int) bool { return sets[i].len() < sets[j].len() })
+
+	res := newSet()
+	if sets[0].len() == 0 {
+		return res
+	}
+
+outer:
+	for member := range sets[0].members {
+		for _, st := range sets[1:] {
+			if !st.has(member) {
+				continue outer
+			}
+		}
+		if res.add(member); res.len() == limit {
+			break
+		}
+	}
+	return res
+}
+
+// setUnion returns the union of sets
+func setUnion(sets []*set) *set {
+	res := newSet()
+	for _, st := range sets {
+		for member := range st.members {
+			res.add(member)
+		}
+	}
+	return res
+}
+
+// setDiff returns the members of the first set which are not members of
+// the others
+func setDiff(sets []*set) *set {
+	res := newSet()
+
+outer:
+	for member := range sets[0].members {
+		for _, st := range sets[1:] {
+			if st.has(member) {
+				continue outer
+			}
+		}
+		res.add(member)
+	}
+	return res
+}
+
+func (s *store) sadd(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int64
+	if !s.modifyTyped(w, c.Arg(0).String(), "set", func(cur *entry) (*entry, bool) {
+		st := newSet()
+		if cur != nil {
+			st = cur.obj.(*set)
+		}
+
+		for _, arg := range c.Args[1:] {
+			if st.add(arg.String()) {
+				n++
+			}
+		}
+		if cur == nil {
+			e := newEntry(nil)
+			e.obj = st
+			return e, true
+		}
+		return cur, n != 0
+	}) {
+		return
+	}
+	w.AppendInt(n)
+}
+
+func (s *store) srem(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int64
+	if !s.modifyTyped(w, c.Arg(0).String(), "set", func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		st := cur.obj.(*set)
+
+		for _, arg := range c.Args[1:] {
+			if st.remove(arg.String()) {
+				n++
+			}
+		}
+		if st.len() == 0 {
+			return nil, true
+		}
+		return cur, n != 0
+	}) {
+		return
+	}
+	w.AppendInt(n)
+}
+
+// spop implements SPOP key [count], popping random members. Like in
+// Redis, pops are propagated as SREM of the popped members.
+// https://redis.io/commands/spop
+func (s *store) spop(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 && c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	count := int64(1)
+	if c.ArgN() == 2 {
+		n, err := c.Arg(1).Int()
+		if err != nil || n < 0 {
+			w.AppendError("ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+
+	var popped []string
+	if !s.modifyTyped(w, c.Arg(0).String(), "set", func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		st := cur.obj.(*set)
+
+		// map iteration order is random
+		for member := range st.members {
+			if int64(len(popped)) == count {
+				break
+			}
+			popped = append(popped, member)
+		}
+		for _, member := range popped {
+			st.remove(member)
+		}
+		if st.len() == 0 {
+			return nil, true
+		}
+		return cur, len(popped) != 0
+	}) {
+		return
+	}
+
+	rewrite(c, func() []*resp.Command {
+		if len(popped) == 0 {
+			return nil
+		}
+		args := []resp.CommandArgument{c.Arg(0)}
+		for _, member := range popped {
+			args = append(args, resp.CommandArgument(member))
+		}
+		return []*resp.Command{resp.NewCommand("SREM", args...)}
+	})
+
+	switch {
+	case c.ArgN() == 2:
+		w.AppendArrayLen(len(popped))
+		for _, member := range popped {
+			w.AppendBulkString(member)
+		}
+	case len(popped) == 0:
+		w.AppendNil()
+	default:
+		w.AppendBulkString(popped[0])
+	}
+}
+
+func (s *store) sismember(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "set", func(e *entry) {
+		if e == nil {
+			w.AppendInt(0)
+			return
+		}
+
+		if e.obj.(*set).has(c.Arg(1).String()) {
+			w.AppendInt(1)
+		} else {
+			w.AppendInt(0)
+		}
+	})
+}
+
+func (s *store) scard(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "set", func(e *entry) {
+		if e == nil {
+			w.AppendInt(0)
+			return
+		}
+		w.AppendInt(int64(e.obj.(*set).len()))
+	})
+}
+
+func (s *store) smembers(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.setOp(w, c, setUnion)
+}
+
+func (s *store) sinter(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.setOp(w, c, func(sets []*set) *set { return setInter(sets, 0) })
+}
+
+func (s *store) sunion(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.setOp(w, c, setUnion)
+}
+
+func (s *store) sdiff(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.setOp(w, c, setDiff)
+}
+
+// setOp replies with the members of the result of op over the sets at
+// the keys of c
+func (s *store) setOp(w resp.ResponseWriter, c *resp.Command, op func([]*set) *set) {
+	keys, pos := distinctKeys(c.Args)
+
+	var res *set
+	var msg string
+	s.keys.viewAll(keys, func(cur []*entry) {
+		sets, ok := setsOf(cur)
+		if !ok {
+			msg = errWrongType
+			return
+		}
+		for _, e := range cur {
+			if e != nil {
+				e.touch(s.keys.now())
+			}
+		}
+
+		args := make([]*set, 0, c.ArgN())
+		for _, arg := range c.Args {
+			args = append(args, sets[pos[arg.String()]])
+		}
+		res = op(args)
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendArrayLen(res.len())
+	for member := range res.members {
+		w.AppendBulkString(member)
+	}
+}
+
+func (s *store) sinterstore(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.setOpStore(w, c, func(sets []*set) *set { return setInter(sets, 0) })
+}
+
+func (s *store) sunionstore(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.setOpStore(w, c, setUnion)
+}
+
+func (s *store) sdiffstore(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.setOpStore(w, c, setDiff)
+}
+
+// setOpStore stores the result of op over the sets at the keys of c at
+// the destination, the first key, which may also be a source. Empty
+// results delete the destination.
+func (s *store) setOpStore(w resp.ResponseWriter, c *resp.Command, op func([]*set) *set) {
+	keys, pos := distinctKeys(c.Args)
+
+	var n int
+	var msg string
+	s.keys.modifyAll(keys, func(cur []*entry) ([]*entry, bool) {
+		args := make([]*entry, 0, c.ArgN()-1)
+		for _, arg := range c.Args[1:] {
+			args = append(args, cur[pos[arg.String()]])
+		}
+		sets, ok := setsOf(args)
+		if !ok {
+			msg = errWrongType
+			return nil, false
+		}
+
+		res := op(sets)
+		next := append([]*entry(nil), cur...)
+		if n = res.len(); n == 0 {
+			next[0] = nil
+			return next, cur[0] != nil
+		}
+		next[0] = newEntry(nil)
+		next[0].obj = res
+		return next, true
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendInt(int64(n))
+}
+
+// sintercard implements SINTERCARD numkeys key [key ...] [LIMIT limit]
+// https://redis.io/commands/sintercard
+func (s *store) sintercard(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	numKeys, err := c.Arg(0).Int()
+	if err != nil {
+		w.AppendError(errNotInteger)
+		return
+	} else if numKeys <= 0 {
+		w.AppendError("ERR numkeys should be greater than 0")
+		return
+	} else if numKeys > int64(c.ArgN()-1) {
+		w.AppendError("ERR Number of keys can't be greater than number of args")
+		return
+	}
+
+	var limit int64
+	for i := int(numKeys) + 1; i < c.ArgN(); i++ {
+		if !strings.EqualFold(c.Arg(i).String(), "limit") || i+1 >= c.ArgN() {
+			w.AppendError("ERR syntax error")
+			return
+		}
+		if limit, err = c.Arg(i + 1).Int(); err != nil {
+			w.AppendError(errNotInteger)
+			return
+		} else if limit < 0 {
+			w.AppendError("ERR LIMIT can't be negative")
+			return
+		}
+		i++
+	}
+
+	args := c.Args[1 : numKeys+1]
+	keys, pos := distinctKeys(args)
+
+	var n int
+	var msg string
+	s.keys.viewAll(keys, func(cur []*entry) {
+		sets, ok := setsOf(cur)
+		if !ok {
+			msg = errWrongType
+			return
+		}
+
+		inter := make([]*set, 0, len(args))
+		for _, arg := range args {
+			inter = append(inter, sets[pos[arg.String()]])
+		}
+		n = setInter(inter, int(limit)).len()
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendInt(int64(n))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sets", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.sadd, "SADD", "a", "1", "2", "3", "4")).To(Equal(int64(4)))
+		Expect(call(subject.sadd, "SADD", "b", "2", "3", "5")).To(Equal(int64(3)))
+		Expect(call(subject.sadd, "SADD", "c", "3", "4", "5", "6", "7")).To(Equal(int64(5)))
+	})
+
+	It("should add and remove members", func() {
+		Expect(call(subject.sadd, "SADD", "a", "1", "9", "9")).To(Equal(int64(1)))
+		Expect(call(subject.scard, "SCARD", "a")).To(Equal(int64(5)))
+		Expect(call(subject.sismember, "SISMEMBER", "a", "9")).To(Equal(int64(1)))
+		Expect(call(subject.sismember, "SISMEMBER", "a", "8")).To(Equal(int64(0)))
+		Expect(call(subject.smembers, "SMEMBERS", "b")).To(ConsistOf("2", "3", "5"))
+
+		Expect(call(subject.srem, "SREM", "b", "2", "8")).To(Equal(int64(1)))
+		Expect(call(subject.srem, "SREM", "b", "3", "5")).To(Equal(int64(2)))
+		Expect(call(subject.exists, "EXISTS", "b")).To(Equal(int64(0)))
+		Expect(call(subject.smembers, "SMEMBERS", "b")).To(BeEmpty())
+		Expect(call(subject.keyType, "TYPE", "a")).To(Equal("set"))
+	})
+
+	It("should pop members", func() {
+		Expect([]interface{}{"2", "3", "5"}).To(ContainElement(call(subject.spop, "SPOP", "b")))
+		Expect(call(subject.spop, "SPOP", "b", "5")).To(HaveLen(2))
+		Expect(call(subject.exists, "EXISTS", "b")).To(Equal(int64(0)))
+		Expect(call(subject.spop, "SPOP", "b")).To(BeNil())
+		Expect(call(subject.spop, "SPOP", "b", "1")).To(BeEmpty())
+		Expect(call(subject.spop, "SPOP", "a", "-1")).To(MatchError("ERR value is out of range, must be positive"))
+		Expect(call(subject.spop, "SPOP", "a", "0")).To(BeEmpty())
+		Expect(call(subject.scard, "SCARD", "a")).To(Equal(int64(4)))
+	})
+
+	It("should intersect, unite and subtract", func() {
+		Expect(call(subject.sinter, "SINTER", "a", "b", "c")).To(ConsistOf("3"))
+		Expect(call(subject.sinter, "SINTER", "a", "a")).To(ConsistOf("1", "2", "3", "4"))
+		Expect(call(subject.sinter, "SINTER", "a", "x")).To(BeEmpty())
+		Expect(call(subject.sunion, "SUNION", "a", "b", "x")).To(ConsistOf("1", "2", "3", "4", "5"))
+		Expect(call(subject.sdiff, "SDIFF", "a", "b", "x")).To(ConsistOf("1", "4"))
+		Expect(call(subject.sdiff, "SDIFF", "x", "a")).To(BeEmpty())
+	})
+
+	It("should store results", func() {
+		Expect(call(subject.sinterstore, "SINTERSTORE", "d", "a", "c")).To(Equal(int64(2)))
+		Expect(call(subject.smembers, "SMEMBERS", "d")).To(ConsistOf("3", "4"))
+		Expect(call(subject.sunionstore, "SUNIONSTORE", "a", "a", "b")).To(Equal(int64(5)))
+		Expect(call(subject.smembers, "SMEMBERS", "a")).To(ConsistOf("1", "2", "3", "4", "5"))
+		Expect(call(subject.sdiffstore, "SDIFFSTORE", "d", "b", "a")).To(Equal(int64(0)))
+		Expect(call(subject.exists, "EXISTS", "d")).To(Equal(int64(0)))
+
+		Expect(call(subject.set, "SET", "s", "v")).To(Equal("OK"))
+		Expect(call(subject.sunionstore, "SUNIONSTORE", "s", "b")).To(Equal(int64(3)))
+		Expect(call(subject.keyType, "TYPE", "s")).To(Equal("set"))
+		Expect(call(subject.sadd, "SADD", "s", "9")).To(Equal(int64(1)))
+		Expect(call(subject.scard, "SCARD", "b")).To(Equal(int64(3)))
+	})
+
+	It("should count intersections", func() {
+		Expect(call(subject.sintercard, "SINTERCARD", "2", "a", "c")).To(Equal(int64(2)))
+		Expect(call(subject.sintercard, "SINTERCARD", "2", "a", "c", "LIMIT", "1")).To(Equal(int64(1)))
+		Expect(call(subject.sintercard, "SINTERCARD", "2", "a", "c", "LIMIT", "0")).To(Equal(int64(2)))
+		Expect(call(subject.sintercard, "SINTERCARD", "3", "a", "b", "x")).To(Equal(int64(0)))
+
+		Expect(call(subject.sintercard, "SINTERCARD", "0", "a")).To(MatchError("ERR numkeys should be greater than 0"))
+		Expect(call(subject.sintercard, "SINTERCARD", "3", "a", "b")).To(MatchError("ERR Number of keys can't be greater than number of args"))
+		Expect(call(subject.sintercard, "SINTERCARD", "1", "a", "LIMIT", "-1")).To(MatchError("ERR LIMIT can't be negative"))
+		Expect(call(subject.sintercard, "SINTERCARD", "1", "a", "b")).To(MatchError("ERR syntax error"))
+	})
+
+	It("should check types", func() {
+		Expect(call(subject.set, "SET", "s", "v")).To(Equal("OK"))
+		Expect(call(subject.sadd, "SADD", "s", "1")).To(MatchError(errWrongType))
+		Expect(call(subject.sinter, "SINTER", "a", "s")).To(MatchError(errWrongType))
+		Expect(call(subject.sinterstore, "SINTERSTORE", "d", "a", "s")).To(MatchError(errWrongType))
+		Expect(call(subject.sintercard, "SINTERCARD", "2", "a", "s")).To(MatchError(errWrongType))
+		Expect(call(subject.get, "GET", "a")).To(MatchError(errWrongType))
+	})
+
+	It("should dump and restore", func() {
+		payload := call(subject.dump, "DUMP", "b").(string)
+		Expect(call(subject.restore, "RESTORE", "r", "0", payload)).To(Equal("OK"))
+		Expect(call(subject.smembers, "SMEMBERS", "r")).To(ConsistOf("2", "3", "5"))
+	})
+
+})
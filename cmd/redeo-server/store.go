@@ -0,0 +1,463 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// errWrongType is replied when a command is used on a value of another
+// type
+const errWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// store is a minimal in-memory store
+type store struct {
+	info    *redeo.ServerInfo
+	keys    *keyspace
+	blocked blocking
+	evict   eviction
+	search  indexes
+
+	// txmu is held for reading by commands and for writing by
+	// transactions, see commit
+	txmu sync.RWMutex
+}
+
+func newStore(info *redeo.ServerInfo) *store {
+	s := &store{info: info, keys: newKeyspace(info.Clock())}
+	s.keys.expired = func(string) { info.Expired(1) }
+	s.keys.ready = s.blocked.signal
+	s.keys.updated = s.search.update
+	s.blocked.gate = &s.txmu
+	return s
+}
+
+// typed reports whether e is nil or holds a value of type typ, as
+// reported by TYPE
+func typed(e *entry, typ string) bool {
+	return e == nil || e.typ() == typ
+}
+
+// typedAll reports whether all entries are nil or hold values of type typ
+func typedAll(entries []*entry, typ string) bool {
+	for _, e := range entries {
+		if !typed(e, typ) {
+			return false
+		}
+	}
+	return true
+}
+
+// viewTyped calls fn with the entry of key, or nil if it does not exist,
+// and touches it. If key holds a value of another type than typ, it
+// replies with WRONGTYPE instead and returns false.
+func (s *store) viewTyped(w resp.ResponseWriter, key, typ string, fn func(e *entry)) bool {
+	ok := true
+	s.keys.view(key, func(e *entry) {
+		if ok = typed(e, typ); !ok {
+			return
+		}
+		if e != nil {
+			e.touch(s.keys.now())
+		}
+		fn(e)
+	})
+	if !ok {
+		w.AppendError(errWrongType)
+	}
+	return ok
+}
+
+// modifyTyped modifies key like keyspace.modify, if it does not exist or
+// holds a value of type typ. Otherwise it replies with WRONGTYPE and
+// returns false.
+func (s *store) modifyTyped(w resp.ResponseWriter, key, typ string, fn func(cur *entry) (*entry, bool)) bool {
+	ok := true
+	s.keys.modify(key, func(cur *entry) (*entry, bool) {
+		if ok = typed(cur, typ); !ok {
+			return nil, false
+		}
+		return fn(cur)
+	})
+	if !ok {
+		w.AppendError(errWrongType)
+	}
+	return ok
+}
+
+// register registers the store's commands and those of its modules with
+// the server. Commands which may use more memory are wrapped with
+// denyOOM, all are wrapped with transactional.
+func (s *store) register(srv *redeo.Server) {
+	r := registrar{srv: srv, s: s}
+	read, write := r.read, r.write
+
+	srv.HandleFunc("multi", s.multi)
+	srv.HandleWriteFunc("exec", s.exec)
+	srv.HandleFunc("discard", s.discard)
+	read("get", 2, s.get)
+	write("set", -3, s.denyOOM(s.set))
+	write("setnx", 3, s.denyOOM(s.setnx))
+	write("getex", -2, s.getex)
+	write("getdel", 2, s.getdel)
+	write("incr", 2, s.denyOOM(s.incr))
+	write("decr", 2, s.denyOOM(s.decr))
+	write("incrby", 3, s.denyOOM(s.incrby))
+	write("decrby", 3, s.denyOOM(s.decrby))
+	write("incrbyfloat", 3, s.denyOOM(s.incrbyfloat))
+	write("append", 3, s.denyOOM(s.append))
+	read("getrange", 4, s.getrange)
+	write("setrange", 4, s.denyOOM(s.setrange))
+	write("setbit", 4, s.denyOOM(s.setbit))
+	read("getbit", 3, s.getbit)
+	read("bitcount", -2, s.bitcount)
+	read("bitpos", -3, s.bitpos)
+	write("bitop", -4, s.denyOOM(s.bitop))
+	write("pfadd", -2, s.denyOOM(s.pfadd))
+	read("pfcount", -2, s.pfcount)
+	write("pfmerge", -2, s.denyOOM(s.pfmerge))
+	write("sadd", -3, s.denyOOM(s.sadd))
+	write("srem", -3, s.srem)
+	write("spop", -2, s.spop)
+	read("sismember", 3, s.sismember)
+	read("scard", 2, s.scard)
+	read("smembers", 2, s.smembers)
+	read("sinter", -2, s.sinter)
+	read("sunion", -2, s.sunion)
+	read("sdiff", -2, s.sdiff)
+	write("sinterstore", -3, s.denyOOM(s.sinterstore))
+	write("sunionstore", -3, s.denyOOM(s.sunionstore))
+	write("sdiffstore", -3, s.denyOOM(s.sdiffstore))
+	read("sintercard", -3, s.sintercard)
+	write("lpush", -3, s.denyOOM(s.lpush))
+	write("rpush", -3, s.denyOOM(s.rpush))
+	write("lpop", -2, s.lpop)
+	write("rpop", -2, s.rpop)
+	read("llen", 2, s.llen)
+	read("lrange", 4, s.lrange)
+	write("lmove", 5, s.denyOOM(s.lmove))
+	srv.HandleWriteFunc("blmove", s.transactional(s.denyOOM(s.blmove), 6, true))
+	srv.HandleWriteFunc("blpop", s.transactional(s.blpop, -3, true))
+	srv.HandleWriteFunc("brpop", s.transactional(s.brpop, -3, true))
+	write("zadd", -4, s.denyOOM(s.zadd))
+	write("zrem", -3, s.zrem)
+	read("zscore", 3, s.zscore)
+	read("zcard", 2, s.zcard)
+	read("zrange", -4, s.zrange)
+	read("zrevrange", -4, s.zrevrange)
+	read("zrangebyscore", -4, s.zrangebyscore)
+	read("zrevrangebyscore", -4, s.zrevrangebyscore)
+	read("zrangebylex", -4, s.zrangebylex)
+	read("zrevrangebylex", -4, s.zrevrangebylex)
+	write("zrangestore", -5, s.denyOOM(s.zrangestore))
+	write("zpopmin", -2, s.zpopmin)
+	write("zpopmax", -2, s.zpopmax)
+	srv.HandleWriteFunc("bzpopmin", s.transactional(s.bzpopmin, -3, true))
+	srv.HandleWriteFunc("bzpopmax", s.transactional(s.bzpopmax, -3, true))
+	write("geoadd", -5, s.denyOOM(s.geoadd))
+	read("geopos", -2, s.geopos)
+	read("geodist", -4, s.geodist)
+	read("geosearch", -2, s.geosearch)
+	write("xadd", -5, s.denyOOM(s.xadd))
+	read("xlen", 2, s.xlen)
+	read("xrange", -4, s.xrange)
+	read("xrevrange", -4, s.xrevrange)
+	srv.HandleFunc("xread", s.transactional(s.xread, -4, true))
+	write("xgroup", -2, s.denyOOM(s.xgroup))
+	srv.HandleWriteFunc("xreadgroup", s.transactional(s.xreadgroup, -7, true))
+	write("xack", -4, s.xack)
+	read("xpending", -3, s.xpending)
+	write("xclaim", -6, s.xclaim)
+	write("del", -2, s.del)
+	read("exists", -2, s.exists)
+	read("type", 2, s.keyType)
+	write("rename", 3, s.rename)
+	write("renamenx", 3, s.renamenx)
+	write("copy", -3, s.denyOOM(s.copy))
+	write("move", 3, s.move)
+	read("dump", 2, s.dump)
+	write("restore", -4, s.denyOOM(s.restore))
+	write("migrate", -6, s.migrate)
+	write("expire", -3, s.expire("ex"))
+	write("pexpire", -3, s.expire("px"))
+	write("expireat", -3, s.expire("exat"))
+	write("pexpireat", -3, s.expire("pxat"))
+	write("persist", 2, s.persist)
+	read("ttl", 2, s.ttl)
+	read("pttl", 2, s.pttl)
+	read("expiretime", 2, s.expiretime)
+	read("pexpiretime", 2, s.pexpiretime)
+	read("dbsize", 1, s.dbsize)
+	read("randomkey", 1, s.randomkey)
+	read("keys", 2, redeo.Keys(s).ServeRedeo)
+	write("flushall", -1, s.flushall)
+	read("scan", -2, redeo.Scan(s).ServeRedeo)
+	read("object", -2, redeo.Object(s).ServeRedeo)
+	read("memory", -2, redeo.Memory(s).ServeRedeo)
+	write("hset", -4, s.denyOOM(s.hset))
+	read("hget", 3, s.hget)
+	write("hdel", -3, s.hdel)
+	read("hlen", 2, s.hlen)
+	read("hgetall", 2, s.hgetall)
+
+	for _, m := range modules {
+		m.register(s, registrar{srv: srv, s: s, prefix: m.name + "."})
+	}
+}
+
+func (s *store) get(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "string", func(e *entry) {
+		if e == nil {
+			s.replyValue(w, nil, false)
+			return
+		}
+		s.replyValue(w, e.val, true)
+	})
+}
+
+// set implements SET key value [NX|XX] [GET] [EX|PX|EXAT|PXAT ttl|KEEPTTL]
+// https://redis.io/commands/set
+func (s *store) set(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var nx, xx, get, keepTTL, ttl bool
+	var expires int64
+	now := s.keys.now()
+	for i := 2; i < c.ArgN(); i++ {
+		switch opt := strings.ToLower(c.Arg(i).String()); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "get":
+			get = true
+		case "keepttl":
+			keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if ttl || i+1 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			var msg string
+			if expires, msg = parseDeadline(c.Name, opt, c.Arg(i+1), now); msg != "" {
+				w.AppendError(msg)
+				return
+			}
+			ttl = true
+			i++
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+	if (nx && xx) || (keepTTL && ttl) {
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	val := append([]byte(nil), c.Arg(1)...)
+	var old *entry
+	var ok bool
+	s.keys.modify(c.Arg(0).String(), func(cur *entry) (*entry, bool) {
+		if old = cur; get && !typed(cur, "string") {
+			return nil, false
+		}
+		if (nx && cur != nil) || (xx && cur == nil) {
+			return nil, false
+		}
+
+		ok = true
+		e := newEntry(val)
+		if e.expires = expires; keepTTL && cur != nil {
+			e.expires = cur.expires
+		}
+		if e.expired(now.UnixNano()) {
+			return nil, true
+		}
+		return e, true
+	})
+
+	// propagate relative TTLs as PXAT
+	rewrite(c, func() []*resp.Command {
+		switch {
+		case !ok:
+			return nil
+		case ttl:
+			return []*resp.Command{resp.NewCommand(c.Name, c.Arg(0), c.Arg(1), resp.CommandArgument("PXAT"), unixMillis(expires))}
+		case keepTTL:
+			return []*resp.Command{resp.NewCommand(c.Name, c.Arg(0), c.Arg(1), resp.CommandArgument("KEEPTTL"))}
+		}
+		return []*resp.Command{resp.NewCommand(c.Name, c.Arg(0), c.Arg(1))}
+	})
+
+	switch {
+	case get && !typed(old, "string"):
+		w.AppendError(errWrongType)
+	case get && old != nil:
+		w.AppendBulk(old.val)
+	case get || !ok:
+		w.AppendNil()
+	default:
+		w.AppendOK()
+	}
+}
+
+func (s *store) setnx(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int64
+	s.keys.modify(c.Arg(0).String(), func(cur *entry) (*entry, bool) {
+		if cur != nil {
+			return nil, false
+		}
+		n = 1
+		return newEntry(append([]byte(nil), c.Arg(1)...)), true
+	})
+
+	w.AppendInt(n)
+}
+
+func (s *store) del(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int64
+	for _, arg := range c.Args {
+		s.keys.modify(arg.String(), func(cur *entry) (*entry, bool) {
+			if cur != nil {
+				n++
+			}
+			return nil, cur != nil
+		})
+	}
+
+	w.AppendInt(n)
+}
+
+func (s *store) exists(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int64
+	for _, arg := range c.Args {
+		if _, ok := s.keys.get(arg.String()); ok {
+			n++
+		}
+	}
+
+	w.AppendInt(n)
+}
+
+func (s *store) dbsize(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	w.AppendInt(int64(s.keys.len()))
+}
+
+func (s *store) randomkey(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	if key, ok := s.keys.random(); ok {
+		w.AppendBulkString(key)
+	} else {
+		w.AppendNil()
+	}
+}
+
+func (s *store) flushall(w resp.ResponseWriter, c *resp.Command) {
+	s.keys.flush()
+	s.search.reset()
+	w.AppendOK()
+}
+
+// Scan implements redeo.Scanner. The cursor addresses the keyspace's
+// hash buckets, so keys are returned in no particular order, but a full
+// iteration returns every key that existed throughout it.
+func (s *store) Scan(cursor uint64, count int, fn func(elem string, values ...string)) uint64 {
+	snap := s.keys.snapshot()
+	defer snap.close()
+
+	return snap.scan(cursor, count, func(key string, _ *entry) { fn(key) })
+}
+
+// KeyType implements redeo.KeyTyper
+func (s *store) KeyType(key string) string {
+	if e, ok := s.keys.get(key); ok {
+		return e.typ()
+	}
+	return "none"
+}
+
+// Inspect implements redeo.Introspector. Access frequencies are reported
+// with LFU eviction policies, access times with all others.
+func (s *store) Inspect(key string) (*redeo.ObjectInfo, bool) {
+	e, ok := s.keys.get(key)
+	if !ok {
+		return nil, false
+	}
+
+	now := s.keys.now()
+	oi := &redeo.ObjectInfo{IdleTime: e.idle(now), Freq: -1}
+	if s.tracksLFU() {
+		oi.IdleTime, oi.Freq = -1, int64(e.freq(now))
+	}
+
+	if e.obj != nil {
+		oi.Encoding, oi.SerializedLength = e.obj.encoding(), e.obj.size()
+		return oi, true
+	}
+
+	oi.Encoding = "raw"
+	if _, err := strconv.ParseInt(string(e.val), 10, 64); err == nil {
+		oi.Encoding = "int"
+	} else if len(e.val) <= 44 {
+		oi.Encoding = "embstr"
+	}
+	oi.SerializedLength = int64(len(e.val))
+	return oi, true
+}
+
+// MemoryUsage implements redeo.MemoryReporter
+func (s *store) MemoryUsage(key string, _ int) (int64, bool) {
+	e, ok := s.keys.get(key)
+	if !ok {
+		return 0, false
+	}
+	return int64(len(key)) + e.size(), true
+}
+
+// DatasetSize implements redeo.MemoryReporter
+func (s *store) DatasetSize() (keys, bytes int64) {
+	snap := s.keys.snapshot()
+	defer snap.close()
+
+	snap.each(func(key string, e *entry) bool {
+		keys++
+		bytes += int64(len(key)) + e.size()
+		return true
+	})
+	return keys, bytes
+}
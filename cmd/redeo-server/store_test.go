@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/redeotest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("store", func() {
+	var subject *store
+
+	expires := func(key string) int64 {
+		e, ok := subject.keys.get(key)
+		Expect(ok).To(BeTrue())
+		return e.expires
+	}
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+	})
+
+	It("should get and set", func() {
+		Expect(call(subject.get, "GET", "k")).To(BeNil())
+		Expect(call(subject.set, "SET", "k", "v")).To(Equal("OK"))
+		Expect(call(subject.get, "GET", "k")).To(Equal("v"))
+		Expect(call(subject.exists, "EXISTS", "k", "x", "k")).To(Equal(int64(2)))
+		Expect(call(subject.dbsize, "DBSIZE")).To(Equal(int64(1)))
+		Expect(call(subject.del, "DEL", "k", "x")).To(Equal(int64(1)))
+		Expect(call(subject.dbsize, "DBSIZE")).To(Equal(int64(0)))
+	})
+
+	It("should inspect the keyspace", func() {
+		keys := redeo.Keys(subject).ServeRedeo
+		Expect(call(subject.randomkey, "RANDOMKEY")).To(BeNil())
+		Expect(call(keys, "KEYS", "*")).To(BeEmpty())
+
+		Expect(call(subject.set, "SET", "k1", "v")).To(Equal("OK"))
+		Expect(call(subject.set, "SET", "k2", "v")).To(Equal("OK"))
+		Expect(call(subject.set, "SET", "x", "v")).To(Equal("OK"))
+		Expect([]interface{}{"k1", "k2", "x"}).To(ContainElement(call(subject.randomkey, "RANDOMKEY")))
+		Expect(call(keys, "KEYS", "k*")).To(Equal([]interface{}{"k1", "k2"}))
+		Expect(call(subject.dbsize, "DBSIZE", "x")).To(MatchError("ERR wrong number of arguments for 'DBSIZE' command"))
+	})
+
+	It("should set with options", func() {
+		Expect(call(subject.set, "SET", "k", "v1", "XX")).To(BeNil())
+		Expect(call(subject.set, "SET", "k", "v1", "NX", "GET")).To(BeNil())
+		Expect(call(subject.set, "SET", "k", "v2", "NX")).To(BeNil())
+		Expect(call(subject.set, "SET", "k", "v2", "xx", "get", "ex", "100")).To(Equal("v1"))
+		Expect(call(subject.setnx, "SETNX", "k", "v3")).To(Equal(int64(0)))
+		Expect(call(subject.get, "GET", "k")).To(Equal("v2"))
+
+		e, _ := subject.keys.get("k")
+		Expect(time.Until(time.Unix(0, e.expires))).To(BeNumerically("~", 100*time.Second, time.Second))
+		Expect(call(subject.set, "SET", "k", "v3", "KEEPTTL")).To(Equal("OK"))
+		Expect(expires("k")).To(Equal(e.expires))
+		Expect(call(subject.set, "SET", "k", "v4")).To(Equal("OK"))
+		Expect(expires("k")).To(Equal(int64(0)))
+
+		Expect(call(subject.set, "SET", "k", "v5", "PXAT", "1", "GET")).To(Equal("v4"))
+		Expect(call(subject.get, "GET", "k")).To(BeNil())
+		Expect(call(subject.setnx, "SETNX", "k", "v6")).To(Equal(int64(1)))
+	})
+
+	It("should reject invalid SET options", func() {
+		Expect(call(subject.set, "SET", "k", "v", "NX", "XX")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.set, "SET", "k", "v", "EX", "1", "PX", "1")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.set, "SET", "k", "v", "EX", "1", "KEEPTTL")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.set, "SET", "k", "v", "EX")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.set, "SET", "k", "v", "FOO")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.set, "SET", "k", "v", "EX", "x")).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(call(subject.set, "SET", "k", "v", "EX", "0")).To(MatchError("ERR invalid expire time in 'set' command"))
+		Expect(call(subject.set, "SET", "k", "v", "PX", "9223372036854775807")).To(MatchError("ERR invalid expire time in 'set' command"))
+		Expect(call(subject.dbsize, "DBSIZE")).To(Equal(int64(0)))
+	})
+
+	It("should check types of keys", func() {
+		Expect(call(subject.set, "SET", "str", "v")).To(Equal("OK"))
+		Expect(call(subject.sadd, "SADD", "set", "m")).To(Equal(int64(1)))
+
+		w := redeotest.NewRecorder()
+		Expect(subject.viewTyped(w, "str", "set", func(*entry) { Fail("unexpected call") })).To(BeFalse())
+		Expect(w.Response()).To(MatchError(errWrongType))
+
+		w = redeotest.NewRecorder()
+		Expect(subject.modifyTyped(w, "set", "string", func(*entry) (*entry, bool) {
+			Fail("unexpected call")
+			return nil, false
+		})).To(BeFalse())
+		Expect(w.Response()).To(MatchError(errWrongType))
+
+		var seen []*entry
+		Expect(subject.viewTyped(w, "set", "set", func(e *entry) { seen = append(seen, e) })).To(BeTrue())
+		Expect(subject.viewTyped(w, "x", "set", func(e *entry) { seen = append(seen, e) })).To(BeTrue())
+		Expect(seen).To(HaveLen(2))
+		Expect(seen[0].typ()).To(Equal("set"))
+		Expect(seen[1]).To(BeNil())
+
+		Expect(call(subject.get, "GET", "set")).To(MatchError(errWrongType))
+		Expect(call(subject.incr, "INCR", "set")).To(MatchError(errWrongType))
+		Expect(call(subject.scard, "SCARD", "str")).To(MatchError(errWrongType))
+		Expect(call(subject.llen, "LLEN", "set")).To(MatchError(errWrongType))
+		Expect(call(subject.zadd, "ZADD", "str", "1", "m")).To(MatchError(errWrongType))
+		Expect(call(subject.xlen, "XLEN", "set")).To(MatchError(errWrongType))
+		Expect(call(subject.get, "GET", "str")).To(Equal("v"))
+	})
+
+})
+
+// call calls a handler with a command and returns the response
+func call(h redeo.HandlerFunc, name string, args ...string) interface{} {
+	w := redeotest.NewRecorder()
+	h(w, redeotest.NewCommand(name, args...))
+	v, err := w.Response()
+	Expect(err).NotTo(HaveOccurred())
+	return v
+}
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "redeo-server")
+}
@@ -0,0 +1,1190 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// streamEntrySize is the approximate overhead of each stream entry and
+// field
+const streamEntrySize = 32
+
+// Error replies of stream commands
+const (
+	errStreamID      = "ERR Invalid stream ID specified as stream command argument"
+	errStreamIDSmall = "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+	errStreamIDZero  = "ERR The ID specified in XADD must be greater than 0-0"
+	errBusyGroup     = "BUSYGROUP Consumer Group name already exists"
+)
+
+// streamID identifies a stream entry by its millisecond timestamp and a
+// sequence number
+type streamID struct{ ms, seq uint64 }
+
+// maxStreamID is the largest possible ID
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) less(o streamID) bool {
+	return id.ms < o.ms || (id.ms == o.ms && id.seq < o.seq)
+}
+
+// next returns the following ID, or false if id is the largest
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq != math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms != math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the preceding ID, or false if id is the smallest
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq != 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms != 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// parseStreamID parses ms[-seq], seq defaults to defSeq
+func parseStreamID(s string, defSeq uint64) (streamID, bool) {
+	ms, seq := s, ""
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		ms, seq = s[:i], s[i+1:]
+	}
+
+	var id streamID
+	var err error
+	if id.ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return id, false
+	}
+	if id.seq = defSeq; len(ms) != len(s) {
+		if id.seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return id, false
+		}
+	}
+	return id, true
+}
+
+// parseStreamRange parses the bounds of XRANGE and XPENDING, which are
+// IDs, - or +, and exclusive if prefixed with (. It returns false if the
+// range is invalid, and empty if it cannot contain entries.
+func parseStreamRange(start, end string) (min, max streamID, empty, ok bool) {
+	bound := func(s string, isEnd bool) (streamID, bool, bool) {
+		switch s {
+		case "-":
+			return streamID{}, true, true
+		case "+":
+			return maxStreamID, true, true
+		}
+		ex := strings.HasPrefix(s, "(")
+		if ex {
+			s = s[1:]
+		}
+
+		var defSeq uint64
+		if isEnd {
+			defSeq = math.MaxUint64
+		}
+		id, ok := parseStreamID(s, defSeq)
+		if !ok || !ex {
+			return id, true, ok
+		}
+		if isEnd {
+			id, ok = id.prev()
+		} else {
+			id, ok = id.next()
+		}
+		return id, ok, true
+	}
+
+	min, okMin, ok := bound(start, false)
+	if !ok {
+		return
+	}
+	max, okMax, ok := bound(end, true)
+	if !ok {
+		return
+	}
+	return min, max, !okMin || !okMax || max.less(min), true
+}
+
+// streamEntry is an entry of a stream, with its fields and values
+// alternating, entries are immutable
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// streamNACK is a pending entry, delivered to a consumer but not yet
+// acknowledged
+type streamNACK struct {
+	consumer  *streamConsumer
+	delivered int64 // unix time in milliseconds
+	count     int64
+}
+
+// streamConsumer is a consumer of a group
+type streamConsumer struct {
+	name    string
+	seen    int64 // unix time in milliseconds
+	pending int
+}
+
+// streamGroup is a consumer group, with its last delivered ID and its
+// pending entries list
+type streamGroup struct {
+	lastID    streamID
+	pel       map[streamID]*streamNACK
+	consumers map[string]*streamConsumer
+}
+
+func newStreamGroup(lastID streamID) *streamGroup {
+	return &streamGroup{
+		lastID:    lastID,
+		pel:       make(map[streamID]*streamNACK),
+		consumers: make(map[string]*streamConsumer),
+	}
+}
+
+// consumer returns the named consumer, creating it if it does not exist
+func (g *streamGroup) consumer(name string, now int64) *streamConsumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &streamConsumer{name: name}
+		g.consumers[name] = c
+	}
+	c.seen = now
+	return c
+}
+
+// pending returns the IDs of the pending entries in order, optionally of
+// a single consumer
+func (g *streamGroup) pending(c *streamConsumer) []streamID {
+	ids := make([]streamID, 0, len(g.pel))
+	for id, nack := range g.pel {
+		if c == nil || nack.consumer == c {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+// deliver records the delivery of an entry to a consumer
+func (g *streamGroup) deliver(id streamID, c *streamConsumer, now int64) {
+	nack, ok := g.pel[id]
+	if !ok {
+		nack = &streamNACK{}
+		g.pel[id] = nack
+	}
+	if nack.consumer != nil {
+		nack.consumer.pending--
+	}
+	nack.consumer, nack.delivered = c, now
+	c.pending++
+}
+
+// ack removes an entry from the pending entries list, it reports whether
+// it was pending
+func (g *streamGroup) ack(id streamID) bool {
+	nack, ok := g.pel[id]
+	if ok {
+		nack.consumer.pending--
+		delete(g.pel, id)
+	}
+	return ok
+}
+
+// stream is a stream object, an append-only log of entries with consumer
+// groups
+type stream struct {
+	entries []streamEntry
+	lastID  streamID
+	groups  map[string]*streamGroup
+
+	// bytes is the approximate size, updated atomically
+	bytes int64
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*streamGroup)}
+}
+
+func (st *stream) typ() string      { return "stream" }
+func (st *stream) encoding() string { return "stream" }
+func (st *stream) size() int64      { return atomic.LoadInt64(&st.bytes) }
+
+func (st *stream) dup() object {
+	res := newStream()
+	res.entries = append(res.entries, st.entries...)
+	res.lastID, res.bytes = st.lastID, st.size()
+	for name, g := range st.groups {
+		dg := newStreamGroup(g.lastID)
+		for cname, c := range g.consumers {
+			dc := *c
+			dg.consumers[cname] = &dc
+		}
+		for id, nack := range g.pel {
+			dn := *nack
+			dn.consumer = dg.consumers[nack.consumer.name]
+			dg.pel[id] = &dn
+		}
+		res.groups[name] = dg
+	}
+	return res
+}
+
+// len returns the number of entries
+func (st *stream) len() int { return len(st.entries) }
+
+// add appends an entry, id must be greater than the last ID
+func (st *stream) add(id streamID, fields []string) {
+	st.entries = append(st.entries, streamEntry{id: id, fields: fields})
+	st.lastID = id
+
+	n := streamEntrySize
+	for _, f := range fields {
+		n += len(f) + streamEntrySize
+	}
+	atomic.AddInt64(&st.bytes, int64(n))
+}
+
+// search returns the index of the first entry with an ID not less than id
+func (st *stream) search(id streamID) int {
+	return sort.Search(len(st.entries), func(i int) bool { return !st.entries[i].id.less(id) })
+}
+
+// get returns the entry with id
+func (st *stream) get(id streamID) (streamEntry, bool) {
+	if i := st.search(id); i < len(st.entries) && st.entries[i].id == id {
+		return st.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// rangeOf returns up to count entries between min and max, inclusive, in
+// reverse if rev, or all if count is negative
+func (st *stream) rangeOf(min, max streamID, count int, rev bool) []streamEntry {
+	lo, hi := st.search(min), st.search(max)
+	if hi < len(st.entries) && st.entries[hi].id == max {
+		hi++
+	}
+
+	var res []streamEntry
+	for i := lo; i < hi && count != 0; i, count = i+1, count-1 {
+		if rev {
+			res = append(res, st.entries[hi-1-(i-lo)])
+		} else {
+			res = append(res, st.entries[i])
+		}
+	}
+	return res
+}
+
+// nowMillis returns the current unix time in milliseconds
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// errNoGroup returns the error reply for missing keys or groups
+func errNoGroup(key, group, cmd string) string {
+	msg := "NOGROUP No such key '" + key + "' or consumer group '" + group + "'"
+	if cmd != "" {
+		msg += " in " + cmd + " with GROUP option"
+	}
+	return msg
+}
+
+// replyStreamEntries replies with entries, as pairs of IDs and fields, or
+// their IDs only
+func replyStreamEntries(w resp.ResponseWriter, entries []streamEntry, justID bool) {
+	w.AppendArrayLen(len(entries))
+	for _, se := range entries {
+		if justID {
+			w.AppendBulkString(se.id.String())
+			continue
+		}
+		w.AppendArrayLen(2)
+		w.AppendBulkString(se.id.String())
+		w.AppendArrayLen(len(se.fields))
+		for _, f := range se.fields {
+			w.AppendBulkString(f)
+		}
+	}
+}
+
+// xadd implements XADD key [NOMKSTREAM] *|id field value [field value ...]
+// https://redis.io/commands/xadd
+func (s *store) xadd(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 4 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	args := c.Args
+	noMkStream := strings.EqualFold(args[1].String(), "nomkstream")
+	if noMkStream {
+		args = append(args[:1:1], args[2:]...)
+	}
+	if len(args) < 4 || len(args)%2 != 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	arg := args[1].String()
+	auto, autoSeq := arg == "*", strings.HasSuffix(arg, "-*")
+	var id streamID
+	if autoSeq {
+		arg = strings.TrimSuffix(arg, "-*")
+	}
+	if !auto {
+		var ok bool
+		if id, ok = parseStreamID(arg, 0); !ok {
+			w.AppendError(errStreamID)
+			return
+		} else if !autoSeq && id == (streamID{}) {
+			w.AppendError(errStreamIDZero)
+			return
+		}
+	}
+
+	fields := make([]string, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		fields = append(fields, arg.String())
+	}
+
+	var msg string
+	var added bool
+	if !s.modifyTyped(w, args[0].String(), "stream", func(cur *entry) (*entry, bool) {
+		if cur == nil && noMkStream {
+			return nil, false
+		}
+		st := newStream()
+		if cur != nil {
+			st = cur.obj.(*stream)
+		}
+
+		next, ok := st.lastID.next()
+		switch {
+		case auto:
+			if ms := uint64(nowMillis()); next.less(streamID{ms, 0}) {
+				next, ok = streamID{ms, 0}, true
+			}
+			id = next
+		case autoSeq && id.ms == st.lastID.ms:
+			id = next
+		case autoSeq:
+			ok = st.lastID.less(id)
+		default:
+			ok = ok && !id.less(next)
+		}
+		if !ok {
+			msg = errStreamIDSmall
+			return nil, false
+		}
+
+		added = true
+		st.add(id, fields)
+		if cur == nil {
+			e := newEntry(nil)
+			e.obj = st
+			return e, true
+		}
+		return cur, true
+	}) {
+		return
+	}
+
+	// propagate generated IDs explicitly
+	rewrite(c, func() []*resp.Command {
+		if !added {
+			return nil
+		}
+		prop := append([]resp.CommandArgument{args[0], resp.CommandArgument(id.String())}, args[2:]...)
+		return []*resp.Command{resp.NewCommand(c.Name, prop...)}
+	})
+
+	switch {
+	case msg != "":
+		w.AppendError(msg)
+	case !added:
+		w.AppendNil()
+	default:
+		w.AppendBulkString(id.String())
+	}
+}
+
+func (s *store) xlen(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "stream", func(e *entry) {
+		if e == nil {
+			w.AppendInt(0)
+			return
+		}
+		w.AppendInt(int64(e.obj.(*stream).len()))
+	})
+}
+
+func (s *store) xrange(w resp.ResponseWriter, c *resp.Command) {
+	s.xrangeGeneric(w, c, false)
+}
+
+func (s *store) xrevrange(w resp.ResponseWriter, c *resp.Command) {
+	s.xrangeGeneric(w, c, true)
+}
+
+// xrangeGeneric implements XRANGE key start end [COUNT count] and
+// XREVRANGE key end start [COUNT count]
+func (s *store) xrangeGeneric(w resp.ResponseWriter, c *resp.Command, rev bool) {
+	if c.ArgN() != 3 && c.ArgN() != 5 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	start, end := c.Arg(1).String(), c.Arg(2).String()
+	if rev {
+		start, end = end, start
+	}
+	min, max, empty, ok := parseStreamRange(start, end)
+	if !ok {
+		w.AppendError(errStreamID)
+		return
+	}
+
+	count := int64(-1)
+	if c.ArgN() == 5 {
+		if !strings.EqualFold(c.Arg(3).String(), "count") {
+			w.AppendError("ERR syntax error")
+			return
+		}
+		var err error
+		if count, err = c.Arg(4).Int(); err != nil {
+			w.AppendError(errNotInteger)
+			return
+		} else if count < 0 {
+			count = 0
+		}
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "stream", func(e *entry) {
+		if e == nil || empty {
+			w.AppendArrayLen(0)
+			return
+		}
+		replyStreamEntries(w, e.obj.(*stream).rangeOf(min, max, int(count), rev), false)
+	})
+}
+
+// xreadArgs are the arguments of XREAD and XREADGROUP
+type xreadArgs struct {
+	group, consumer string
+	count           int
+	block           bool
+	timeout         time.Duration
+	noAck           bool
+	keys            []string
+	ids             []string
+}
+
+// parseXRead parses [GROUP group consumer] [COUNT count] [BLOCK ms]
+// [NOACK] STREAMS key [key ...] id [id ...], it returns an error reply on
+// failure
+func parseXRead(c *resp.Command, group bool) (*xreadArgs, string) {
+	xa := &xreadArgs{count: -1}
+	i := 0
+	for ; i < c.ArgN(); i++ {
+		opt := strings.ToLower(c.Arg(i).String())
+		if opt == "streams" {
+			i++
+			break
+		}
+
+		switch {
+		case opt == "group" && group && i+2 < c.ArgN():
+			xa.group, xa.consumer = c.Arg(i+1).String(), c.Arg(i+2).String()
+			i += 2
+		case opt == "noack" && group:
+			xa.noAck = true
+		case opt == "count" && i+1 < c.ArgN():
+			n, err := c.Arg(i + 1).Int()
+			if err != nil {
+				return nil, errNotInteger
+			}
+			if xa.count = int(n); n <= 0 {
+				xa.count = -1
+			}
+			i++
+		case opt == "block" && i+1 < c.ArgN():
+			ms, err := c.Arg(i + 1).Int()
+			if err != nil {
+				return nil, "ERR timeout is not an integer or out of range"
+			} else if ms < 0 {
+				return nil, errTimeoutNegative
+			}
+			xa.block, xa.timeout = true, time.Duration(ms)*time.Millisecond
+			i++
+		default:
+			return nil, "ERR syntax error"
+		}
+	}
+
+	if group && xa.group == "" {
+		return nil, "ERR Missing GROUP option for XREADGROUP"
+	}
+	rest := c.Args[i:]
+	if i > c.ArgN() || len(rest) == 0 || len(rest)%2 != 0 {
+		return nil, "ERR Unbalanced '" + strings.ToLower(c.Name) + "' list of streams: for each stream key an ID or '$' must be specified."
+	}
+	for j, arg := range rest {
+		if j < len(rest)/2 {
+			xa.keys = append(xa.keys, arg.String())
+		} else {
+			xa.ids = append(xa.ids, arg.String())
+		}
+	}
+	return xa, ""
+}
+
+// streamRead is the result of reading a stream
+type streamRead struct {
+	key     string
+	entries []streamEntry
+}
+
+// replyStreamReads replies with the results of XREAD and XREADGROUP, or
+// nil if there are none
+func replyStreamReads(w resp.ResponseWriter, res []streamRead) {
+	if len(res) == 0 {
+		resp.AppendNilArray(w)
+		return
+	}
+	w.AppendArrayLen(len(res))
+	for _, r := range res {
+		w.AppendArrayLen(2)
+		w.AppendBulkString(r.key)
+		replyStreamEntries(w, r.entries, false)
+	}
+}
+
+// xread implements XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...]
+// id [id ...]
+// https://redis.io/commands/xread
+func (s *store) xread(w resp.ResponseWriter, c *resp.Command) {
+	xa, msg := parseXRead(c, false)
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	keys, pos := distinctKeys(c.Args[c.ArgN()-2*len(xa.keys) : c.ArgN()-len(xa.keys)])
+	after := make([]streamID, len(xa.keys))
+	resolved := make([]bool, len(xa.keys))
+	for i, arg := range xa.ids {
+		if arg == "$" {
+			continue
+		}
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			w.AppendError(errStreamID)
+			return
+		}
+		after[i], resolved[i] = id, true
+	}
+
+	var res []streamRead
+	read := func() bool {
+		res = res[:0]
+		s.keys.viewAll(keys, func(cur []*entry) {
+			if !typedAll(cur, "stream") {
+				msg = errWrongType
+				return
+			}
+			for i, key := range xa.keys {
+				e := cur[pos[key]]
+				if e == nil {
+					resolved[i] = true
+					continue
+				}
+				st := e.obj.(*stream)
+				if !resolved[i] {
+					after[i], resolved[i] = st.lastID, true
+				}
+				if from, ok := after[i].next(); ok {
+					if entries := st.rangeOf(from, maxStreamID, xa.count, false); len(entries) != 0 {
+						res = append(res, streamRead{key: key, entries: entries})
+					}
+				}
+			}
+		})
+		return len(res) != 0 || msg != ""
+	}
+
+	if xa.block {
+		s.blocked.block(c.Context(), xa.keys, xa.timeout, func(string) bool { return read() })
+	} else {
+		s.atomically(c.Context(), func() { read() })
+	}
+
+	// reads are propagated without BLOCK, so replicas never block
+	rewrite(c, func() []*resp.Command {
+		if len(res) == 0 || msg != "" {
+			return nil
+		}
+		prop := make([]resp.CommandArgument, 0, c.ArgN())
+		for i := 0; i < c.ArgN(); i++ {
+			switch strings.ToLower(c.Arg(i).String()) {
+			case "block":
+				i++
+			case "group":
+				prop = append(prop, c.Args[i:i+3]...)
+				i += 2
+			case "count":
+				prop = append(prop, c.Args[i:i+2]...)
+				i++
+			case "streams":
+				prop = append(prop, c.Args[i:]...)
+				return []*resp.Command{resp.NewCommand(c.Name, prop...)}
+			default:
+				prop = append(prop, c.Arg(i))
+			}
+		}
+		return nil
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	replyStreamReads(w, res)
+}
+
+// xreadgroup implements XREADGROUP GROUP group consumer [COUNT count]
+// [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...]. New entries, read
+// with >, are added to the pending entries list of the consumer, unless
+// NOACK, other IDs read the consumer's pending entries after them. It
+// only blocks if all IDs are >.
+// https://redis.io/commands/xreadgroup
+func (s *store) xreadgroup(w resp.ResponseWriter, c *resp.Command) {
+	xa, msg := parseXRead(c, true)
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	keys, pos := distinctKeys(c.Args[c.ArgN()-2*len(xa.keys) : c.ArgN()-len(xa.keys)])
+	after := make([]streamID, len(xa.keys))
+	history := false
+	for i, arg := range xa.ids {
+		if arg == ">" {
+			continue
+		}
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			w.AppendError(errStreamID)
+			return
+		}
+		after[i], history = id, true
+	}
+
+	// reads are propagated without BLOCK, so replicas never block. They
+	// are recorded when served, as blocked reads are served by writes.
+	propagate := func() []*resp.Command {
+		prop := make([]resp.CommandArgument, 0, c.ArgN())
+		for i := 0; i < c.ArgN(); i++ {
+			switch strings.ToLower(c.Arg(i).String()) {
+			case "block":
+				i++
+			case "group":
+				prop = append(prop, c.Args[i:i+3]...)
+				i += 2
+			case "count":
+				prop = append(prop, c.Args[i:i+2]...)
+				i++
+			case "streams":
+				prop = append(prop, c.Args[i:]...)
+				return []*resp.Command{resp.NewCommand(c.Name, prop...)}
+			default:
+				prop = append(prop, c.Arg(i))
+			}
+		}
+		return nil
+	}
+
+	var res []streamRead
+	read := func() bool {
+		res = res[:0]
+		s.keys.modifyAll(keys, func(cur []*entry) ([]*entry, bool) {
+			changed := false
+			now := nowMillis()
+			for i, key := range xa.keys {
+				e := cur[pos[key]]
+				if !typed(e, "stream") {
+					msg = errWrongType
+					return nil, false
+				}
+				var st *stream
+				var g *streamGroup
+				if e != nil {
+					st = e.obj.(*stream)
+					g = st.groups[xa.group]
+				}
+				if g == nil {
+					msg = errNoGroup(key, xa.group, "XREADGROUP")
+					return nil, false
+				}
+
+				consumer := g.consumer(xa.consumer, now)
+				if xa.ids[i] != ">" {
+					var entries []streamEntry
+					for _, id := range g.pending(consumer) {
+						if !after[i].less(id) {
+							continue
+						} else if len(entries) == xa.count {
+							break
+						}
+						se, _ := st.get(id)
+						entries = append(entries, se)
+						g.deliver(id, consumer, now)
+						g.pel[id].count++
+						changed = true
+					}
+					res = append(res, streamRead{key: key, entries: entries})
+					continue
+				}
+
+				from, ok := g.lastID.next()
+				if !ok {
+					continue
+				}
+				entries := st.rangeOf(from, maxStreamID, xa.count, false)
+				if len(entries) == 0 {
+					continue
+				}
+				g.lastID, changed = entries[len(entries)-1].id, true
+				if !xa.noAck {
+					for _, se := range entries {
+						g.deliver(se.id, consumer, now)
+						g.pel[se.id].count++
+					}
+				}
+				res = append(res, streamRead{key: key, entries: entries})
+			}
+			return cur, changed
+		})
+		if len(res) != 0 && msg == "" {
+			rewrite(c, propagate)
+		}
+		return len(res) != 0 || msg != ""
+	}
+
+	// not propagated unless entries are read
+	redeo.Rewrite(c.Context())
+	if xa.block && !history {
+		s.blocked.block(c.Context(), xa.keys, xa.timeout, func(string) bool { return read() })
+	} else {
+		s.atomically(c.Context(), func() { read() })
+	}
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	replyStreamReads(w, res)
+}
+
+// xgroup implements XGROUP CREATE key group id|$ [MKSTREAM] and XGROUP
+// DESTROY key group
+// https://redis.io/commands/xgroup
+func (s *store) xgroup(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	switch strings.ToLower(c.Arg(0).String()) {
+	case "create":
+		s.xgroupCreate(w, c)
+	case "destroy":
+		s.xgroupDestroy(w, c)
+	default:
+		w.AppendError("ERR unknown subcommand '" + c.Arg(0).String() + "'. Try XGROUP HELP.")
+	}
+}
+
+func (s *store) xgroupCreate(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 4 && c.ArgN() != 5 {
+		w.AppendError("ERR wrong number of arguments for 'xgroup|create' command")
+		return
+	}
+	mkStream := c.ArgN() == 5
+	if mkStream && !strings.EqualFold(c.Arg(4).String(), "mkstream") {
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	var id streamID
+	last := c.Arg(3).String() == "$"
+	if !last {
+		var ok bool
+		if id, ok = parseStreamID(c.Arg(3).String(), 0); !ok {
+			w.AppendError(errStreamID)
+			return
+		}
+	}
+
+	var msg string
+	if !s.modifyTyped(w, c.Arg(1).String(), "stream", func(cur *entry) (*entry, bool) {
+		st := newStream()
+		if cur == nil && !mkStream {
+			msg = "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."
+			return nil, false
+		} else if cur != nil {
+			st = cur.obj.(*stream)
+		}
+
+		group := c.Arg(2).String()
+		if _, ok := st.groups[group]; ok {
+			msg = errBusyGroup
+			return nil, false
+		}
+		if last {
+			id = st.lastID
+		}
+		st.groups[group] = newStreamGroup(id)
+
+		if cur == nil {
+			e := newEntry(nil)
+			e.obj = st
+			return e, true
+		}
+		return cur, true
+	}) {
+		return
+	}
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendOK()
+}
+
+func (s *store) xgroupDestroy(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 3 {
+		w.AppendError("ERR wrong number of arguments for 'xgroup|destroy' command")
+		return
+	}
+
+	var n int64
+	var msg string
+	if !s.modifyTyped(w, c.Arg(1).String(), "stream", func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			msg = "ERR The XGROUP subcommand requires the key to exist."
+			return nil, false
+		}
+
+		st := cur.obj.(*stream)
+		if _, ok := st.groups[c.Arg(2).String()]; ok {
+			delete(st.groups, c.Arg(2).String())
+			n = 1
+		}
+		return cur, n != 0
+	}) {
+		return
+	}
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendInt(n)
+}
+
+// xack implements XACK key group id [id ...]
+// https://redis.io/commands/xack
+func (s *store) xack(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	ids := make([]streamID, 0, c.ArgN()-2)
+	for _, arg := range c.Args[2:] {
+		id, ok := parseStreamID(arg.String(), 0)
+		if !ok {
+			w.AppendError(errStreamID)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	var n int64
+	if !s.modifyTyped(w, c.Arg(0).String(), "stream", func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		g := cur.obj.(*stream).groups[c.Arg(1).String()]
+		if g == nil {
+			return nil, false
+		}
+
+		for _, id := range ids {
+			if g.ack(id) {
+				n++
+			}
+		}
+		return cur, n != 0
+	}) {
+		return
+	}
+	w.AppendInt(n)
+}
+
+// xpending implements XPENDING key group [[IDLE min-idle-time] start end
+// count [consumer]]. The short form replies with a summary, the extended
+// form with the pending entries, their consumers, idle times in
+// milliseconds and delivery counts.
+// https://redis.io/commands/xpending
+func (s *store) xpending(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	args := c.Args[2:]
+	extended := len(args) != 0
+	var minIdle int64
+	if len(args) >= 2 && strings.EqualFold(args[0].String(), "idle") {
+		var err error
+		if minIdle, err = args[1].Int(); err != nil {
+			w.AppendError(errNotInteger)
+			return
+		}
+		args = args[2:]
+	}
+	if extended && len(args) != 3 && len(args) != 4 {
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	var min, max streamID
+	var empty bool
+	var count int64
+	if extended {
+		var ok bool
+		if min, max, empty, ok = parseStreamRange(args[0].String(), args[1].String()); !ok {
+			w.AppendError(errStreamID)
+			return
+		}
+		var err error
+		if count, err = args[2].Int(); err != nil {
+			w.AppendError(errNotInteger)
+			return
+		}
+	}
+
+	key, group := c.Arg(0).String(), c.Arg(1).String()
+	s.viewTyped(w, key, "stream", func(e *entry) {
+		var g *streamGroup
+		if e != nil {
+			g = e.obj.(*stream).groups[group]
+		}
+		if g == nil {
+			w.AppendError(errNoGroup(key, group, ""))
+			return
+		}
+
+		if !extended {
+			ids := g.pending(nil)
+			if len(ids) == 0 {
+				w.AppendArrayLen(4)
+				w.AppendInt(0)
+				w.AppendNil()
+				w.AppendNil()
+				resp.AppendNilArray(w)
+				return
+			}
+
+			names := make([]string, 0, len(g.consumers))
+			for name, c := range g.consumers {
+				if c.pending != 0 {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+
+			w.AppendArrayLen(4)
+			w.AppendInt(int64(len(ids)))
+			w.AppendBulkString(ids[0].String())
+			w.AppendBulkString(ids[len(ids)-1].String())
+			w.AppendArrayLen(len(names))
+			for _, name := range names {
+				w.AppendArrayLen(2)
+				w.AppendBulkString(name)
+				w.AppendBulkString(strconv.Itoa(g.consumers[name].pending))
+			}
+			return
+		}
+
+		var consumer *streamConsumer
+		if len(args) == 4 {
+			if consumer = g.consumers[args[3].String()]; consumer == nil {
+				w.AppendArrayLen(0)
+				return
+			}
+		}
+
+		now := nowMillis()
+		var ids []streamID
+		for _, id := range g.pending(consumer) {
+			if empty || int64(len(ids)) >= count {
+				break
+			}
+			if !id.less(min) && !max.less(id) && now-g.pel[id].delivered >= minIdle {
+				ids = append(ids, id)
+			}
+		}
+
+		w.AppendArrayLen(len(ids))
+		for _, id := range ids {
+			nack := g.pel[id]
+			w.AppendArrayLen(4)
+			w.AppendBulkString(id.String())
+			w.AppendBulkString(nack.consumer.name)
+			w.AppendInt(now - nack.delivered)
+			w.AppendInt(nack.count)
+		}
+	})
+}
+
+// xclaim implements XCLAIM key group consumer min-idle-time id [id ...]
+// [IDLE ms] [TIME unix-time-ms] [RETRYCOUNT count] [FORCE] [JUSTID],
+// transferring pending entries idle for at least min-idle-time
+// milliseconds to consumer
+// https://redis.io/commands/xclaim
+func (s *store) xclaim(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 5 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	minIdle, err := c.Arg(3).Int()
+	if err != nil {
+		w.AppendError("ERR Invalid min-idle-time argument for XCLAIM")
+		return
+	}
+
+	var ids []streamID
+	i := 4
+	for ; i < c.ArgN(); i++ {
+		id, ok := parseStreamID(c.Arg(i).String(), 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		w.AppendError(errStreamID)
+		return
+	}
+
+	now := nowMillis()
+	delivered, retryCount := now, int64(-1)
+	var force, justID bool
+	for ; i < c.ArgN(); i++ {
+		switch opt := strings.ToLower(c.Arg(i).String()); {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case (opt == "idle" || opt == "time" || opt == "retrycount") && i+1 < c.ArgN():
+			n, err := c.Arg(i + 1).Int()
+			if err != nil {
+				w.AppendError("ERR Invalid " + strings.ToUpper(opt) + " option argument for XCLAIM")
+				return
+			}
+			switch opt {
+			case "idle":
+				delivered = now - n
+			case "time":
+				delivered = n
+			case "retrycount":
+				retryCount = n
+			}
+			i++
+		default:
+			w.AppendError("ERR Unrecognized XCLAIM option '" + c.Arg(i).String() + "'")
+			return
+		}
+	}
+	if delivered > now {
+		delivered = now
+	}
+
+	key, group := c.Arg(0).String(), c.Arg(1).String()
+	var res []streamEntry
+	var msg string
+	if !s.modifyTyped(w, key, "stream", func(cur *entry) (*entry, bool) {
+		var st *stream
+		var g *streamGroup
+		if cur != nil {
+			st = cur.obj.(*stream)
+			g = st.groups[group]
+		}
+		if g == nil {
+			msg = errNoGroup(key, group, "")
+			return nil, false
+		}
+
+		consumer := g.consumer(c.Arg(2).String(), now)
+		for _, id := range ids {
+			se, exists := st.get(id)
+			nack, pending := g.pel[id]
+			switch {
+			case !exists:
+				if pending {
+					g.ack(id)
+				}
+				continue
+			case !pending && !force:
+				continue
+			case pending && now-nack.delivered < minIdle:
+				continue
+			}
+
+			g.deliver(id, consumer, delivered)
+			nack = g.pel[id]
+			if retryCount >= 0 {
+				nack.count = retryCount
+			} else if !justID {
+				nack.count++
+			}
+			res = append(res, se)
+		}
+		return cur, len(res) != 0
+	}) {
+		return
+	}
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	replyStreamEntries(w, res, justID)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("streams", func() {
+	var subject *store
+
+	entry := func(id string, fields ...interface{}) []interface{} {
+		return []interface{}{id, fields}
+	}
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.xadd, "XADD", "s", "1-1", "a", "1")).To(Equal("1-1"))
+		Expect(call(subject.xadd, "XADD", "s", "1-*", "b", "2")).To(Equal("1-2"))
+		Expect(call(subject.xadd, "XADD", "s", "2", "c", "3", "d", "4")).To(Equal("2-0"))
+	})
+
+	It("should add entries", func() {
+		Expect(call(subject.xlen, "XLEN", "s")).To(Equal(int64(3)))
+		Expect(call(subject.keyType, "TYPE", "s")).To(Equal("stream"))
+		Expect(call(subject.xadd, "XADD", "s", "*", "e", "5")).To(MatchRegexp(`^\d{13}-0$`))
+		Expect(call(subject.xadd, "XADD", "s", "2-0", "e", "5")).To(MatchError("ERR The ID specified in XADD is equal or smaller than the target stream top item"))
+		Expect(call(subject.xadd, "XADD", "s", "1-*", "e", "5")).To(MatchError("ERR The ID specified in XADD is equal or smaller than the target stream top item"))
+		Expect(call(subject.xadd, "XADD", "t", "0-0", "e", "5")).To(MatchError("ERR The ID specified in XADD must be greater than 0-0"))
+		Expect(call(subject.xadd, "XADD", "t", "0-*", "e", "5")).To(Equal("0-1"))
+		Expect(call(subject.xadd, "XADD", "t", "x", "e", "5")).To(MatchError("ERR Invalid stream ID specified as stream command argument"))
+		Expect(call(subject.xadd, "XADD", "t", "1", "e")).To(MatchError("ERR wrong number of arguments for 'XADD' command"))
+
+		Expect(call(subject.xadd, "XADD", "u", "NOMKSTREAM", "1", "e", "5")).To(BeNil())
+		Expect(call(subject.exists, "EXISTS", "u")).To(Equal(int64(0)))
+		Expect(call(subject.set, "SET", "u", "v")).To(Equal("OK"))
+		Expect(call(subject.xadd, "XADD", "u", "1", "e", "5")).To(MatchError(errWrongType))
+	})
+
+	It("should query ranges", func() {
+		Expect(call(subject.xrange, "XRANGE", "s", "-", "+")).To(Equal([]interface{}{
+			entry("1-1", "a", "1"),
+			entry("1-2", "b", "2"),
+			entry("2-0", "c", "3", "d", "4"),
+		}))
+		Expect(call(subject.xrange, "XRANGE", "s", "(1-1", "1")).To(Equal([]interface{}{entry("1-2", "b", "2")}))
+		Expect(call(subject.xrange, "XRANGE", "s", "1", "+", "COUNT", "1")).To(Equal([]interface{}{entry("1-1", "a", "1")}))
+		Expect(call(subject.xrevrange, "XREVRANGE", "s", "+", "(1-1", "COUNT", "1")).To(Equal([]interface{}{entry("2-0", "c", "3", "d", "4")}))
+		Expect(call(subject.xrange, "XRANGE", "s", "2", "1")).To(BeEmpty())
+		Expect(call(subject.xrange, "XRANGE", "x", "-", "+")).To(BeEmpty())
+		Expect(call(subject.xrange, "XRANGE", "s", "-", "x")).To(MatchError("ERR Invalid stream ID specified as stream command argument"))
+	})
+
+	It("should read entries", func() {
+		Expect(call(subject.xread, "XREAD", "COUNT", "2", "STREAMS", "s", "x", "0", "0")).To(Equal([]interface{}{
+			[]interface{}{"s", []interface{}{entry("1-1", "a", "1"), entry("1-2", "b", "2")}},
+		}))
+		Expect(call(subject.xread, "XREAD", "STREAMS", "s", "$")).To(BeNil())
+		Expect(call(subject.xread, "XREAD", "BLOCK", "10", "STREAMS", "s", "2-0")).To(BeNil())
+		Expect(call(subject.xread, "XREAD", "STREAMS", "s")).To(MatchError(
+			"ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."))
+
+		reply := make(chan interface{}, 1)
+		go func() { reply <- call(subject.xread, "XREAD", "BLOCK", "5000", "STREAMS", "x", "s", "$", "$") }()
+		Eventually(func() int32 { return atomic.LoadInt32(&subject.blocked.n) }).Should(Equal(int32(1)))
+		Expect(call(subject.xadd, "XADD", "x", "5", "e", "5")).To(Equal("5-0"))
+		Expect(<-reply).To(Equal([]interface{}{
+			[]interface{}{"x", []interface{}{entry("5-0", "e", "5")}},
+		}))
+	})
+
+	It("should manage consumer groups", func() {
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "s", "g", "$")).To(Equal("OK"))
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "s", "g", "0")).To(MatchError("BUSYGROUP Consumer Group name already exists"))
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "x", "g", "0")).To(MatchError(HavePrefix("ERR The XGROUP subcommand requires the key to exist.")))
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "x", "g", "0", "MKSTREAM")).To(Equal("OK"))
+		Expect(call(subject.xlen, "XLEN", "x")).To(Equal(int64(0)))
+		Expect(call(subject.xgroup, "XGROUP", "DESTROY", "x", "g")).To(Equal(int64(1)))
+		Expect(call(subject.xgroup, "XGROUP", "DESTROY", "x", "g")).To(Equal(int64(0)))
+		Expect(call(subject.xgroup, "XGROUP", "FOO")).To(MatchError("ERR unknown subcommand 'FOO'. Try XGROUP HELP."))
+
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c", "STREAMS", "s", ">")).To(BeNil())
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "h", "c", "STREAMS", "s", ">")).To(MatchError(
+			"NOGROUP No such key 's' or consumer group 'h' in XREADGROUP with GROUP option"))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "STREAMS", "s", ">")).To(MatchError("ERR Missing GROUP option for XREADGROUP"))
+	})
+
+	It("should deliver entries to consumers", func() {
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "s", "g", "0")).To(Equal("OK"))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c1", "COUNT", "2", "STREAMS", "s", ">")).To(Equal([]interface{}{
+			[]interface{}{"s", []interface{}{entry("1-1", "a", "1"), entry("1-2", "b", "2")}},
+		}))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c2", "STREAMS", "s", ">")).To(Equal([]interface{}{
+			[]interface{}{"s", []interface{}{entry("2-0", "c", "3", "d", "4")}},
+		}))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c2", "STREAMS", "s", ">")).To(BeNil())
+
+		// history of the consumer's pending entries
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c1", "STREAMS", "s", "1-1")).To(Equal([]interface{}{
+			[]interface{}{"s", []interface{}{entry("1-2", "b", "2")}},
+		}))
+
+		Expect(call(subject.xpending, "XPENDING", "s", "g")).To(Equal([]interface{}{
+			int64(3), "1-1", "2-0", []interface{}{
+				[]interface{}{"c1", "2"},
+				[]interface{}{"c2", "1"},
+			},
+		}))
+		pending := call(subject.xpending, "XPENDING", "s", "g", "-", "+", "10", "c1").([]interface{})
+		Expect(pending).To(HaveLen(2))
+		Expect(pending[1]).To(ConsistOf("1-2", "c1", BeNumerically(">=", 0), int64(2)))
+
+		Expect(call(subject.xack, "XACK", "s", "g", "1-1", "1-2", "9-0")).To(Equal(int64(2)))
+		Expect(call(subject.xack, "XACK", "s", "h", "2-0")).To(Equal(int64(0)))
+		Expect(call(subject.xpending, "XPENDING", "s", "g", "-", "+", "10")).To(HaveLen(1))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c1", "STREAMS", "s", "0")).To(Equal([]interface{}{
+			[]interface{}{"s", []interface{}{}},
+		}))
+
+		Expect(call(subject.xack, "XACK", "s", "g", "2-0")).To(Equal(int64(1)))
+		Expect(call(subject.xpending, "XPENDING", "s", "g")).To(Equal([]interface{}{int64(0), nil, nil, nil}))
+		Expect(call(subject.xpending, "XPENDING", "s", "h")).To(MatchError("NOGROUP No such key 's' or consumer group 'h'"))
+	})
+
+	It("should not track entries read with NOACK", func() {
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "s", "g", "0")).To(Equal("OK"))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c", "NOACK", "STREAMS", "s", ">")).To(HaveLen(1))
+		Expect(call(subject.xpending, "XPENDING", "s", "g")).To(Equal([]interface{}{int64(0), nil, nil, nil}))
+	})
+
+	It("should block until entries are added", func() {
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "s", "g", "$")).To(Equal("OK"))
+
+		var replies [2]chan interface{}
+		for i := range replies {
+			replies[i] = make(chan interface{}, 1)
+			go func(ch chan interface{}) {
+				ch <- call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c", "COUNT", "1", "BLOCK", "5000", "STREAMS", "s", ">")
+			}(replies[i])
+			Eventually(func() int32 { return atomic.LoadInt32(&subject.blocked.n) }).Should(Equal(int32(i + 1)))
+		}
+
+		Expect(call(subject.xadd, "XADD", "s", "3", "e", "5", "f", "6")).To(Equal("3-0"))
+		Expect(<-replies[0]).To(Equal([]interface{}{
+			[]interface{}{"s", []interface{}{entry("3-0", "e", "5", "f", "6")}},
+		}))
+		Expect(call(subject.xadd, "XADD", "s", "4", "g", "7")).To(Equal("4-0"))
+		Expect(<-replies[1]).To(Equal([]interface{}{
+			[]interface{}{"s", []interface{}{entry("4-0", "g", "7")}},
+		}))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "10", "STREAMS", "s", ">")).To(BeNil())
+	})
+
+	It("should claim idle entries", func() {
+		Expect(call(subject.xgroup, "XGROUP", "CREATE", "s", "g", "0")).To(Equal("OK"))
+		Expect(call(subject.xreadgroup, "XREADGROUP", "GROUP", "g", "c1", "STREAMS", "s", ">")).To(HaveLen(1))
+
+		Expect(call(subject.xclaim, "XCLAIM", "s", "g", "c2", "60000", "1-1")).To(BeEmpty())
+		Expect(call(subject.xclaim, "XCLAIM", "s", "g", "c2", "0", "1-1", "1-2")).To(Equal([]interface{}{
+			entry("1-1", "a", "1"),
+			entry("1-2", "b", "2"),
+		}))
+		Expect(call(subject.xclaim, "XCLAIM", "s", "g", "c3", "0", "2-0", "IDLE", "120000", "JUSTID")).To(Equal([]interface{}{"2-0"}))
+		Expect(call(subject.xpending, "XPENDING", "s", "g", "IDLE", "60000", "-", "+", "10")).To(ConsistOf(
+			ConsistOf("2-0", "c3", BeNumerically(">=", 120000), int64(1)),
+		))
+		Expect(call(subject.xclaim, "XCLAIM", "s", "g", "c1", "60000", "2-0", "1-1", "RETRYCOUNT", "5", "JUSTID")).To(Equal([]interface{}{"2-0"}))
+		Expect(call(subject.xpending, "XPENDING", "s", "g", "-", "(2", "10", "c2")).To(ConsistOf(
+			ConsistOf("1-1", "c2", BeNumerically(">=", 0), int64(2)),
+			ConsistOf("1-2", "c2", BeNumerically(">=", 0), int64(2)),
+		))
+		Expect(call(subject.xpending, "XPENDING", "s", "g", "2", "2", "10", "c1")).To(ConsistOf(
+			ConsistOf("2-0", "c1", BeNumerically(">=", 0), int64(5)),
+		))
+
+		Expect(call(subject.xack, "XACK", "s", "g", "1-1")).To(Equal(int64(1)))
+		Expect(call(subject.xclaim, "XCLAIM", "s", "g", "c1", "0", "1-1")).To(BeEmpty())
+		Expect(call(subject.xclaim, "XCLAIM", "s", "g", "c1", "0", "1-1", "3-0", "FORCE", "JUSTID")).To(Equal([]interface{}{"1-1"}))
+		Expect(call(subject.xclaim, "XCLAIM", "s", "g", "c1", "x", "1-1")).To(MatchError("ERR Invalid min-idle-time argument for XCLAIM"))
+		Expect(call(subject.xclaim, "XCLAIM", "s", "g", "c1", "0", "1-1", "FOO")).To(MatchError("ERR Unrecognized XCLAIM option 'FOO'"))
+		Expect(call(subject.xclaim, "XCLAIM", "s", "h", "c1", "0", "1-1")).To(MatchError("NOGROUP No such key 's' or consumer group 'h'"))
+	})
+})
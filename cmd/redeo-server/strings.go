@@ -0,0 +1,387 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// maxStringSize is the max size of string values, like Redis'
+// proto-max-bulk-len
+const maxStringSize = 512 * 1024 * 1024
+
+// Error replies, as sent by Redis
+const (
+	errNotInteger   = "ERR value is not an integer or out of range"
+	errNotFloat     = "ERR value is not a valid float"
+	errOverflow     = "ERR increment or decrement would overflow"
+	errDecrOverflow = "ERR decrement would overflow"
+	errNaNOrInf     = "ERR increment would produce NaN or Infinity"
+	errOffset       = "ERR offset is out of range"
+	errTooLarge     = "ERR string exceeds maximum allowed size (proto-max-bulk-len)"
+)
+
+func (s *store) incr(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.incrBy(w, c.Arg(0).String(), 1)
+}
+
+func (s *store) decr(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	s.incrBy(w, c.Arg(0).String(), -1)
+}
+
+func (s *store) incrby(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	n, err := c.Arg(1).Int()
+	if err != nil {
+		w.AppendError(errNotInteger)
+		return
+	}
+	s.incrBy(w, c.Arg(0).String(), n)
+}
+
+func (s *store) decrby(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	n, err := c.Arg(1).Int()
+	if err != nil {
+		w.AppendError(errNotInteger)
+		return
+	}
+	if n == math.MinInt64 {
+		w.AppendError(errDecrOverflow)
+		return
+	}
+	s.incrBy(w, c.Arg(0).String(), -n)
+}
+
+// incrBy adds n to the integer value of key, missing keys count as 0
+func (s *store) incrBy(w resp.ResponseWriter, key string, n int64) {
+	var res int64
+	var msg string
+	if !s.modifyTyped(w, key, "string", func(cur *entry) (*entry, bool) {
+		if cur != nil {
+			v, err := strconv.ParseInt(string(cur.val), 10, 64)
+			if err != nil {
+				msg = errNotInteger
+				return nil, false
+			}
+			if (n < 0 && v < 0 && n < math.MinInt64-v) || (n > 0 && v > 0 && n > math.MaxInt64-v) {
+				msg = errOverflow
+				return nil, false
+			}
+			res = v
+		}
+		res += n
+		return cur.replace(strconv.AppendInt(nil, res, 10)), true
+	}) {
+		return
+	}
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendInt(res)
+}
+
+func (s *store) incrbyfloat(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	n, ok := parseFloat(c.Arg(1))
+	if !ok {
+		w.AppendError(errNotFloat)
+		return
+	}
+
+	var val []byte
+	var msg string
+	if !s.modifyTyped(w, c.Arg(0).String(), "string", func(cur *entry) (*entry, bool) {
+		var v float64
+		if cur != nil {
+			if v, ok = parseFloat(cur.val); !ok {
+				msg = errNotFloat
+				return nil, false
+			}
+		}
+		if v += n; math.IsNaN(v) || math.IsInf(v, 0) {
+			msg = errNaNOrInf
+			return nil, false
+		}
+		val = strconv.AppendFloat(nil, v, 'f', -1, 64)
+		return cur.replace(val), true
+	}) {
+		return
+	}
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	// like in Redis, propagate the result to avoid rounding differences
+	rewrite(c, func() []*resp.Command {
+		return []*resp.Command{resp.NewCommand("SET", c.Arg(0), val, resp.CommandArgument("KEEPTTL"))}
+	})
+	w.AppendBulk(val)
+}
+
+// parseFloat parses finite floats
+func parseFloat(p []byte) (float64, bool) {
+	f, err := strconv.ParseFloat(string(p), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+func (s *store) append(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int
+	var msg string
+	if !s.modifyTyped(w, c.Arg(0).String(), "string", func(cur *entry) (*entry, bool) {
+		var val []byte
+		if cur != nil {
+			val = cur.val
+		}
+		if n = len(val) + len(c.Arg(1)); n > maxStringSize {
+			msg = errTooLarge
+			return nil, false
+		}
+
+		next := make([]byte, 0, n)
+		next = append(next, val...)
+		next = append(next, c.Arg(1)...)
+		return cur.replace(next), true
+	}) {
+		return
+	}
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendInt(int64(n))
+}
+
+func (s *store) getrange(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	start, err1 := c.Arg(1).Int()
+	end, err2 := c.Arg(2).Int()
+	if err1 != nil || err2 != nil {
+		w.AppendError(errNotInteger)
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "string", func(e *entry) {
+		if e == nil {
+			resp.AppendEmptyBulk(w)
+			return
+		}
+
+		start, end, ok := normRange(start, end, int64(len(e.val)))
+		if !ok {
+			resp.AppendEmptyBulk(w)
+			return
+		}
+		w.AppendBulk(e.val[start : end+1])
+	})
+}
+
+// normRange applies the index semantics of GETRANGE to an inclusive
+// range over size elements: negative indexes count from the end and
+// the range is clamped. It returns false if the range is empty.
+func normRange(start, end, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start += size
+	}
+	if end < 0 {
+		end += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return start, end, start <= end && size != 0
+}
+
+func (s *store) setrange(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	offset, err := c.Arg(1).Int()
+	if err != nil {
+		w.AppendError(errNotInteger)
+		return
+	}
+	if offset < 0 {
+		w.AppendError(errOffset)
+		return
+	}
+	val := c.Arg(2)
+	if offset+int64(len(val)) > maxStringSize {
+		w.AppendError(errTooLarge)
+		return
+	}
+
+	var n int
+	if !s.modifyTyped(w, c.Arg(0).String(), "string", func(cur *entry) (*entry, bool) {
+		var old []byte
+		if cur != nil {
+			old = cur.val
+		}
+		if n = len(old); len(val) == 0 {
+			return nil, false
+		}
+
+		if end := int(offset) + len(val); end > n {
+			n = end
+		}
+		next := make([]byte, n)
+		copy(next, old)
+		copy(next[offset:], val)
+		return cur.replace(next), true
+	}) {
+		return
+	}
+	w.AppendInt(int64(n))
+}
+
+// getex implements GETEX key [EX|PX|EXAT|PXAT ttl|PERSIST]
+// https://redis.io/commands/getex
+func (s *store) getex(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var persist, ttl bool
+	var expires int64
+	now := s.keys.now()
+	for i := 1; i < c.ArgN(); i++ {
+		switch opt := strings.ToLower(c.Arg(i).String()); opt {
+		case "persist":
+			if persist || ttl {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			persist = true
+		case "ex", "px", "exat", "pxat":
+			if persist || ttl || i+1 >= c.ArgN() {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			var msg string
+			if expires, msg = parseDeadline(c.Name, opt, c.Arg(i+1), now); msg != "" {
+				w.AppendError(msg)
+				return
+			}
+			ttl = true
+			i++
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+	}
+
+	if !persist && !ttl {
+		rewrite(c, func() []*resp.Command { return nil })
+		s.get(w, c)
+		return
+	}
+
+	var val []byte
+	var ok, changed bool
+	if !s.modifyTyped(w, c.Arg(0).String(), "string", func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		if val, ok = cur.val, true; persist && cur.expires == 0 {
+			return cur, false
+		}
+
+		changed = true
+		if expires != 0 && expires <= now.UnixNano() {
+			return nil, true
+		}
+		next := cur.replace(cur.val)
+		next.expires = expires
+		return next, true
+	}) {
+		return
+	}
+
+	rewrite(c, func() []*resp.Command {
+		switch {
+		case !changed:
+			return nil
+		case persist:
+			return []*resp.Command{resp.NewCommand("PERSIST", c.Arg(0))}
+		}
+		return []*resp.Command{resp.NewCommand("PEXPIREAT", c.Arg(0), unixMillis(expires))}
+	})
+	s.replyValue(w, val, ok)
+}
+
+func (s *store) getdel(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var val []byte
+	var ok bool
+	if !s.modifyTyped(w, c.Arg(0).String(), "string", func(cur *entry) (*entry, bool) {
+		if cur != nil {
+			val, ok = cur.val, true
+		}
+		return nil, ok
+	}) {
+		return
+	}
+	s.replyValue(w, val, ok)
+}
+
+// replyValue replies with a value read from the keyspace, or nil, and
+// records the hit or miss
+func (s *store) replyValue(w resp.ResponseWriter, val []byte, ok bool) {
+	if !ok {
+		s.info.Miss()
+		w.AppendNil()
+		return
+	}
+	s.info.Hit()
+	w.AppendBulk(val)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("strings", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+	})
+
+	It("should increment and decrement", func() {
+		Expect(call(subject.incr, "INCR", "n")).To(Equal(int64(1)))
+		Expect(call(subject.incrby, "INCRBY", "n", "9")).To(Equal(int64(10)))
+		Expect(call(subject.decr, "DECR", "n")).To(Equal(int64(9)))
+		Expect(call(subject.decrby, "DECRBY", "n", "-1")).To(Equal(int64(10)))
+		Expect(call(subject.get, "GET", "n")).To(Equal("10"))
+
+		Expect(call(subject.incrby, "INCRBY", "n", "x")).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(call(subject.decrby, "DECRBY", "n", strconv.FormatInt(math.MinInt64, 10))).To(MatchError("ERR decrement would overflow"))
+		Expect(call(subject.incrby, "INCRBY", "n", strconv.FormatInt(math.MaxInt64, 10))).To(MatchError("ERR increment or decrement would overflow"))
+		Expect(call(subject.set, "SET", "s", "abc")).To(Equal("OK"))
+		Expect(call(subject.incr, "INCR", "s")).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(call(subject.get, "GET", "n")).To(Equal("10"))
+	})
+
+	It("should increment floats", func() {
+		Expect(call(subject.set, "SET", "f", "10.50")).To(Equal("OK"))
+		Expect(call(subject.incrbyfloat, "INCRBYFLOAT", "f", "0.1")).To(Equal("10.6"))
+		Expect(call(subject.incrbyfloat, "INCRBYFLOAT", "f", "-5")).To(Equal("5.6"))
+		Expect(call(subject.incrbyfloat, "INCRBYFLOAT", "g", "5.0e3")).To(Equal("5000"))
+		Expect(call(subject.incrbyfloat, "INCRBYFLOAT", "f", "inf")).To(MatchError("ERR value is not a valid float"))
+		Expect(call(subject.set, "SET", "f", "1.7e308")).To(Equal("OK"))
+		Expect(call(subject.incrbyfloat, "INCRBYFLOAT", "f", "1.7e308")).To(MatchError("ERR increment would produce NaN or Infinity"))
+	})
+
+	It("should append and read ranges", func() {
+		Expect(call(subject.append, "APPEND", "s", "Hello")).To(Equal(int64(5)))
+		Expect(call(subject.append, "APPEND", "s", " World")).To(Equal(int64(11)))
+		Expect(call(subject.getrange, "GETRANGE", "s", "0", "4")).To(Equal("Hello"))
+		Expect(call(subject.getrange, "GETRANGE", "s", "-5", "-1")).To(Equal("World"))
+		Expect(call(subject.getrange, "GETRANGE", "s", "0", "100")).To(Equal("Hello World"))
+		Expect(call(subject.getrange, "GETRANGE", "s", "5", "3")).To(Equal(""))
+		Expect(call(subject.getrange, "GETRANGE", "x", "0", "-1")).To(Equal(""))
+		Expect(call(subject.getrange, "GETRANGE", "s", "a", "1")).To(MatchError("ERR value is not an integer or out of range"))
+	})
+
+	It("should overwrite ranges", func() {
+		Expect(call(subject.set, "SET", "s", "Hello World")).To(Equal("OK"))
+		Expect(call(subject.setrange, "SETRANGE", "s", "6", "Redis")).To(Equal(int64(11)))
+		Expect(call(subject.get, "GET", "s")).To(Equal("Hello Redis"))
+
+		Expect(call(subject.setrange, "SETRANGE", "p", "3", "ab")).To(Equal(int64(5)))
+		Expect(call(subject.get, "GET", "p")).To(Equal("\x00\x00\x00ab"))
+		Expect(call(subject.setrange, "SETRANGE", "e", "3", "")).To(Equal(int64(0)))
+		Expect(call(subject.exists, "EXISTS", "e")).To(Equal(int64(0)))
+
+		Expect(call(subject.setrange, "SETRANGE", "s", "-1", "x")).To(MatchError("ERR offset is out of range"))
+		Expect(call(subject.setrange, "SETRANGE", "s", "536870912", "x")).To(MatchError("ERR string exceeds maximum allowed size (proto-max-bulk-len)"))
+	})
+
+	It("should get and change TTLs", func() {
+		Expect(call(subject.getex, "GETEX", "k", "EX", "100")).To(BeNil())
+		Expect(call(subject.set, "SET", "k", "v")).To(Equal("OK"))
+		Expect(call(subject.getex, "GETEX", "k")).To(Equal("v"))
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(-1)))
+		Expect(call(subject.getex, "GETEX", "k", "ex", "100")).To(Equal("v"))
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(100)))
+		Expect(call(subject.getex, "GETEX", "k", "PERSIST")).To(Equal("v"))
+		Expect(call(subject.ttl, "TTL", "k")).To(Equal(int64(-1)))
+		Expect(call(subject.getex, "GETEX", "k", "PXAT", "1")).To(Equal("v"))
+		Expect(call(subject.exists, "EXISTS", "k")).To(Equal(int64(0)))
+
+		Expect(call(subject.getex, "GETEX", "k", "EX", "1", "PERSIST")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.getex, "GETEX", "k", "EX", "-1")).To(MatchError("ERR invalid expire time in 'getex' command"))
+	})
+
+	It("should get and delete", func() {
+		Expect(call(subject.set, "SET", "k", "v")).To(Equal("OK"))
+		Expect(call(subject.getdel, "GETDEL", "k")).To(Equal("v"))
+		Expect(call(subject.getdel, "GETDEL", "k")).To(BeNil())
+		Expect(call(subject.dbsize, "DBSIZE")).To(Equal(int64(0)))
+	})
+
+})
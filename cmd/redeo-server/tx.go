@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// txn is a transaction, a batch of commands which are executed
+// atomically on commit: other clients observe either none or all of
+// their effects. Like in Redis, commands which fail on commit do not roll
+// back the others, but transactions with commands rejected when queued
+// are discarded.
+type txn struct {
+	cmds []queuedCmd
+}
+
+// queuedCmd is a command queued in a transaction, propagate is set for
+// write commands, see redeo.Rewrite
+type queuedCmd struct {
+	h         redeo.HandlerFunc
+	cmd       *resp.Command
+	propagate bool
+}
+
+// errExecAbort is replied by EXEC to transactions with rejected commands
+const errExecAbort = "EXECABORT Transaction discarded because of previous errors."
+
+// ctxKeyTxn marks the contexts of commands executed by commit
+type ctxKeyTxn struct{}
+
+// ctxKeyClientTxn holds the *clientTxn of a client in its context
+type ctxKeyClientTxn struct{}
+
+// clientTxn holds the transaction a client started with MULTI
+type clientTxn struct {
+	t *txn
+
+	// dirty is set once a command was rejected when queued, rejected is
+	// the number of commands rejected by the server when t was started,
+	// see redeo.Client.Rejected
+	dirty    bool
+	rejected int64
+}
+
+// begin starts a transaction
+func (s *store) begin() *txn {
+	return &txn{}
+}
+
+// queue adds a command to t, which is executed by h on commit. The
+// command is copied, as clients reuse their commands. Write commands are
+// not propagated when queued, but on commit.
+func (t *txn) queue(h redeo.HandlerFunc, c *resp.Command) {
+	args := make([]resp.CommandArgument, 0, c.ArgN())
+	for _, arg := range c.Args {
+		args = append(args, append(resp.CommandArgument(nil), arg...))
+	}
+	t.cmds = append(t.cmds, queuedCmd{
+		h:         h,
+		cmd:       resp.NewCommand(c.Name, args...),
+		propagate: redeo.Rewrite(c.Context()),
+	})
+}
+
+// commit executes the commands of t while holding txmu for writing and
+// replies with an array of their replies. Clients blocked on keys
+// written by the transaction are served once all commands have been
+// executed. Write commands are propagated in place of the command of ctx,
+// wrapped in MULTI and EXEC.
+func (s *store) commit(ctx context.Context, w resp.ResponseWriter, t *txn) {
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	s.blocked.hold()
+	defer s.blocked.release()
+
+	var prop []*resp.Command
+	ctx = context.WithValue(ctx, ctxKeyTxn{}, t)
+	w.AppendArrayLen(len(t.cmds))
+	for _, q := range t.cmds {
+		qctx, p := redeo.WithPropagation(ctx)
+		q.cmd.SetContext(qctx)
+		q.h(w, q.cmd)
+		if q.propagate {
+			prop = append(prop, p.Commands(q.cmd)...)
+		}
+	}
+
+	if len(prop) == 0 {
+		redeo.Rewrite(ctx)
+		return
+	}
+	prop = append([]*resp.Command{resp.NewCommand("MULTI")}, prop...)
+	redeo.Rewrite(ctx, append(prop, resp.NewCommand("EXEC"))...)
+}
+
+// inTxn reports whether ctx is the context of a command executed by
+// commit
+func inTxn(ctx context.Context) bool {
+	return ctx.Value(ctxKeyTxn{}) != nil
+}
+
+// atomically calls fn while holding txmu for reading, so it does not
+// interleave with transactions, unless ctx is the context of a command
+// executed by commit
+func (s *store) atomically(ctx context.Context, fn func()) {
+	if !inTxn(ctx) {
+		s.txmu.RLock()
+		defer s.txmu.RUnlock()
+	}
+	fn()
+}
+
+// transactional wraps the handlers of the store's commands: commands
+// sent after MULTI are queued, once their number of arguments was checked
+// against arity, others are executed atomically. Like in Redis, arity is
+// the number of arguments including the command name, negative if it is
+// the minimum. Handlers of commands which may block must lock on their
+// own, as they must not hold txmu while blocked, see blocking.block.
+func (s *store) transactional(h redeo.HandlerFunc, arity int, blocks bool) redeo.HandlerFunc {
+	return func(w resp.ResponseWriter, c *resp.Command) {
+		if ct := clientTxnOf(c.Context(), false); ct != nil && ct.t != nil {
+			if n := c.ArgN() + 1; n != arity && (arity >= 0 || n < -arity) {
+				ct.dirty = true
+				w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+				return
+			}
+			ct.t.queue(h, c)
+			w.AppendInlineString("QUEUED")
+			return
+		}
+		if blocks {
+			h(w, c)
+			return
+		}
+		s.atomically(c.Context(), func() { h(w, c) })
+	}
+}
+
+// clientTxnOf returns the transaction state of the client of ctx, if
+// any. It is created if create is set.
+func clientTxnOf(ctx context.Context, create bool) *clientTxn {
+	client := redeo.GetClient(ctx)
+	if client == nil {
+		return nil
+	}
+
+	ct, _ := client.Context().Value(ctxKeyClientTxn{}).(*clientTxn)
+	if ct == nil && create {
+		ct = new(clientTxn)
+		client.SetContext(context.WithValue(client.Context(), ctxKeyClientTxn{}, ct))
+	}
+	return ct
+}
+
+// multi implements MULTI, commands of the store are queued until EXEC.
+// https://redis.io/commands/multi
+func (s *store) multi(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	ct := clientTxnOf(c.Context(), true)
+	switch {
+	case ct == nil:
+		w.AppendError("ERR MULTI requires a client connection")
+	case ct.t != nil:
+		w.AppendError("ERR MULTI calls can not be nested")
+	default:
+		ct.t, ct.dirty = s.begin(), false
+		ct.rejected = redeo.GetClient(c.Context()).Rejected()
+		w.AppendOK()
+	}
+}
+
+// exec implements EXEC. It is registered as a write command, to propagate
+// the write commands of transactions.
+// https://redis.io/commands/exec
+func (s *store) exec(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	ct := clientTxnOf(c.Context(), false)
+	if ct == nil || ct.t == nil {
+		w.AppendError("ERR EXEC without MULTI")
+		return
+	}
+
+	t, dirty := ct.t, ct.dirty || redeo.GetClient(c.Context()).Rejected() != ct.rejected
+	ct.t = nil
+	if dirty {
+		redeo.Rewrite(c.Context())
+		w.AppendError(errExecAbort)
+		return
+	}
+	s.commit(c.Context(), w, t)
+}
+
+// discard implements DISCARD
+// https://redis.io/commands/discard
+func (s *store) discard(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	ct := clientTxnOf(c.Context(), false)
+	if ct == nil || ct.t == nil {
+		w.AppendError("ERR DISCARD without MULTI")
+		return
+	}
+	ct.t = nil
+	w.AppendOK()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("transactions", func() {
+	var subject *store
+	var srv *redeo.Server
+
+	BeforeEach(func() {
+		srv = redeo.NewServer(nil)
+		subject = newStore(srv.Info())
+		subject.register(srv)
+	})
+
+	It("should queue commands until EXEC", func() {
+		Expect(redeotest.Transcript(srv,
+			"MULTI\r\n",
+			"SET k 1\r\n",
+			"INCR k\r\n",
+			"SADD k m\r\n",
+			"GET k\r\n",
+			"EXEC\r\n",
+			"GET k\r\n",
+		)).To(Equal([]byte("+OK\r\n" +
+			"+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n" +
+			"*4\r\n+OK\r\n:2\r\n-" + errWrongType + "\r\n$1\r\n2\r\n" +
+			"$1\r\n2\r\n")))
+	})
+
+	It("should discard transactions", func() {
+		Expect(redeotest.Transcript(srv,
+			"MULTI\r\n",
+			"SET k 1\r\n",
+			"MULTI\r\n",
+			"DISCARD\r\n",
+			"GET k\r\n",
+			"EXEC\r\n",
+			"DISCARD\r\n",
+		)).To(Equal([]byte("+OK\r\n+QUEUED\r\n" +
+			"-ERR MULTI calls can not be nested\r\n" +
+			"+OK\r\n$-1\r\n" +
+			"-ERR EXEC without MULTI\r\n" +
+			"-ERR DISCARD without MULTI\r\n")))
+	})
+
+	It("should abort transactions with rejected commands", func() {
+		Expect(redeotest.Transcript(srv,
+			"MULTI\r\n",
+			"SET k 1\r\n",
+			"GET\r\n",
+			"EXEC\r\n",
+			"MULTI\r\n",
+			"SET k 1\r\n",
+			"NOSUCH\r\n",
+			"EXEC\r\n",
+			"GET k\r\n",
+		)).To(Equal([]byte("+OK\r\n+QUEUED\r\n" +
+			"-ERR wrong number of arguments for 'GET' command\r\n" +
+			"-" + errExecAbort + "\r\n" +
+			"+OK\r\n+QUEUED\r\n" +
+			"-ERR unknown command 'NOSUCH'\r\n" +
+			"-" + errExecAbort + "\r\n" +
+			"$-1\r\n")))
+	})
+
+	It("should abort transactions with denied writes", func() {
+		srv.AddPropagator(denySet{})
+		Expect(redeotest.Transcript(srv,
+			"MULTI\r\n",
+			"GET k\r\n",
+			"SET k 1\r\n",
+			"EXEC\r\n",
+		)).To(Equal([]byte("+OK\r\n+QUEUED\r\n" +
+			"-NOREPLICAS Not enough good replicas to write.\r\n" +
+			"-" + errExecAbort + "\r\n")))
+	})
+
+	It("should not block within transactions", func() {
+		Expect(redeotest.Transcript(srv,
+			"MULTI\r\n",
+			"BLPOP l 0\r\n",
+			"RPUSH l a\r\n",
+			"BLPOP l 0\r\n",
+			"EXEC\r\n",
+		)).To(Equal([]byte("+OK\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n" +
+			"*3\r\n*-1\r\n:1\r\n*2\r\n$1\r\nl\r\n$1\r\na\r\n")))
+	})
+
+	It("should commit atomically", func() {
+		t := subject.begin()
+		t.queue(subject.rpush, redeotest.NewCommand("RPUSH", "l", "a"))
+		t.queue(subject.lpop, redeotest.NewCommand("LPOP", "l"))
+
+		// clients blocked on written keys are served after the commit
+		res := make(chan interface{}, 1)
+		go func() { res <- call(subject.blpop, "BLPOP", "l", "0") }()
+		Eventually(func() int32 { return atomic.LoadInt32(&subject.blocked.n) }).Should(Equal(int32(1)))
+
+		w := redeotest.NewRecorder()
+		subject.commit(context.Background(), w, t)
+		Expect(w.Response()).To(Equal([]interface{}{int64(1), "a"}))
+		Consistently(res).ShouldNot(Receive())
+
+		Expect(call(subject.rpush, "RPUSH", "l", "b")).To(Equal(int64(1)))
+		Eventually(res).Should(Receive(Equal([]interface{}{"l", "b"})))
+	})
+
+	It("should copy queued commands", func() {
+		cmd := redeotest.NewCommand("SET", "k", "v")
+		t := subject.begin()
+		t.queue(subject.set, cmd)
+		cmd.Args[1][0] = 'x'
+
+		subject.commit(context.Background(), redeotest.NewRecorder(), t)
+		Expect(call(subject.get, "GET", "k")).To(Equal("v"))
+	})
+
+})
+
+// denySet is a propagator which rejects SET commands
+type denySet struct{}
+
+func (denySet) Propagate(*resp.Command) {}
+
+func (denySet) CheckWrite(cmd *resp.Command) string {
+	if cmd.CanonicalName() == "set" {
+		return "NOREPLICAS Not enough good replicas to write."
+	}
+	return ""
+}
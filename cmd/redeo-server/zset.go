@@ -0,0 +1,960 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Sorted sets are a map of members to scores and a skip list, ordered by
+// score, then member, like Redis' skiplist encoding.
+// https://github.com/redis/redis/blob/unstable/src/t_zset.c
+const (
+	zslMaxLevel = 32
+
+	// zsetEntrySize is the approximate overhead of each member
+	zsetEntrySize = 64
+)
+
+// Error replies of sorted set commands
+const (
+	errZAddXXNX  = "ERR XX and NX options at the same time are not compatible"
+	errZAddGTLT  = "ERR GT, LT, and/or NX options at the same time are not compatible"
+	errZAddIncr  = "ERR INCR option supports a single increment-element pair"
+	errZScoreNaN = "ERR resulting score is not a number (NaN)"
+)
+
+type zslNode struct {
+	member   string
+	score    float64
+	backward *zslNode
+	level    []zslLevel
+}
+
+type zslLevel struct {
+	forward *zslNode
+	span    int
+}
+
+// before reports whether n is ordered before score and member
+func (n *zslNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+type zskiplist struct {
+	header *zslNode
+	tail   *zslNode
+	length int
+	level  int
+}
+
+func newZSkiplist() *zskiplist {
+	return &zskiplist{
+		header: &zslNode{level: make([]zslLevel, zslMaxLevel)},
+		level:  1,
+	}
+}
+
+// zslRandomLevel returns the level of a new node, each level with a
+// probability of 1/4
+func zslRandomLevel() int {
+	level := 1
+	for level < zslMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// insert adds a node, the member must not exist
+func (zsl *zskiplist) insert(score float64, member string) {
+	var update [zslMaxLevel]*zslNode
+	var rank [zslMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i != zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for y := x.level[i].forward; y != nil && y.before(score, member); y = x.level[i].forward {
+			rank[i] += x.level[i].span
+			x = y
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	for i := zsl.level; i < level; i++ {
+		update[i] = zsl.header
+		update[i].level[i].span = zsl.length
+	}
+	if level > zsl.level {
+		zsl.level = level
+	}
+
+	x = &zslNode{member: member, score: score, level: make([]zslLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+// delete removes a node, it reports whether it was found
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	var update [zslMaxLevel]*zslNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for y := x.level[i].forward; y != nil && y.before(score, member); y = x.level[i].forward {
+			x = y
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if next := x.level[0].forward; next != nil {
+		next.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// zslRange is a range of skip list nodes
+type zslRange interface {
+	// gteMin reports whether x is not before the start of the range
+	gteMin(x *zslNode) bool
+	// lteMax reports whether x is not after the end of the range
+	lteMax(x *zslNode) bool
+}
+
+// scoreRange is a range of scores, bounds are optionally exclusive
+type scoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r *scoreRange) gteMin(x *zslNode) bool {
+	if r.minex {
+		return x.score > r.min
+	}
+	return x.score >= r.min
+}
+
+func (r *scoreRange) lteMax(x *zslNode) bool {
+	if r.maxex {
+		return x.score < r.max
+	}
+	return x.score <= r.max
+}
+
+// lexBound is a bound of a lexicographical range, inf is -1 for "-",
+// 1 for "+" and 0 for a member
+type lexBound struct {
+	member string
+	ex     bool
+	inf    int
+}
+
+// cmp compares the bound with member
+func (b *lexBound) cmp(member string) int {
+	if b.inf != 0 {
+		return b.inf
+	}
+	return strings.Compare(b.member, member)
+}
+
+// lexRange is a range of members, which assumes equal scores
+type lexRange struct {
+	min, max lexBound
+}
+
+func (r *lexRange) gteMin(x *zslNode) bool {
+	if r.min.ex {
+		return r.min.cmp(x.member) < 0
+	}
+	return r.min.cmp(x.member) <= 0
+}
+
+func (r *lexRange) lteMax(x *zslNode) bool {
+	if r.max.ex {
+		return r.max.cmp(x.member) > 0
+	}
+	return r.max.cmp(x.member) >= 0
+}
+
+// firstInRange returns the first node in the range, or nil
+func (zsl *zskiplist) firstInRange(r zslRange) *zslNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for y := x.level[i].forward; y != nil && !r.gteMin(y); y = x.level[i].forward {
+			x = y
+		}
+	}
+	if x = x.level[0].forward; x == nil || !r.lteMax(x) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last node in the range, or nil
+func (zsl *zskiplist) lastInRange(r zslRange) *zslNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for y := x.level[i].forward; y != nil && r.lteMax(y); y = x.level[i].forward {
+			x = y
+		}
+	}
+	if x == zsl.header || !r.gteMin(x) {
+		return nil
+	}
+	return x
+}
+
+// byRank returns the node at the 1-based rank, or nil
+func (zsl *zskiplist) byRank(rank int) *zslNode {
+	x, traversed := zsl.header, 0
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// zset is a sorted set object
+type zset struct {
+	dict map[string]float64
+	zsl  *zskiplist
+
+	// bytes is the approximate size, updated atomically
+	bytes int64
+}
+
+func newZSet() *zset {
+	return &zset{dict: make(map[string]float64), zsl: newZSkiplist()}
+}
+
+func (z *zset) typ() string      { return "zset" }
+func (z *zset) encoding() string { return "skiplist" }
+func (z *zset) size() int64      { return atomic.LoadInt64(&z.bytes) }
+
+func (z *zset) dup() object {
+	res := newZSet()
+	for x := z.zsl.tail; x != nil; x = x.backward {
+		res.set(x.member, x.score)
+	}
+	return res
+}
+
+// len returns the number of members
+func (z *zset) len() int { return len(z.dict) }
+
+// score returns the score of member
+func (z *zset) score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// set sets the score of member, it reports whether the member was added
+func (z *zset) set(member string, score float64) bool {
+	cur, ok := z.dict[member]
+	if ok {
+		if cur != score {
+			z.zsl.delete(cur, member)
+			z.zsl.insert(score, member)
+			z.dict[member] = score
+		}
+		return false
+	}
+
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	atomic.AddInt64(&z.bytes, int64(len(member)+zsetEntrySize))
+	return true
+}
+
+// remove removes member, it reports whether it existed
+func (z *zset) remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	atomic.AddInt64(&z.bytes, -int64(len(member)+zsetEntrySize))
+	return true
+}
+
+// parseScore parses a score, infinities are valid
+func parseScore(arg resp.CommandArgument) (float64, bool) {
+	f, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// zaddFlags are the options of ZADD
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// parseZAddFlags parses the options of ZADD from args, it returns the
+// number of arguments consumed
+func parseZAddFlags(args []resp.CommandArgument, f *zaddFlags) int {
+	for i, arg := range args {
+		switch strings.ToLower(arg.String()) {
+		case "nx":
+			f.nx = true
+		case "xx":
+			f.xx = true
+		case "gt":
+			f.gt = true
+		case "lt":
+			f.lt = true
+		case "ch":
+			f.ch = true
+		case "incr":
+			f.incr = true
+		default:
+			return i
+		}
+	}
+	return len(args)
+}
+
+// zadd implements ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member
+// [score member ...]
+// https://redis.io/commands/zadd
+func (s *store) zadd(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var f zaddFlags
+	args := c.Args[1:]
+	args = args[parseZAddFlags(args, &f):]
+	if len(args) == 0 || len(args)%2 != 0 {
+		w.AppendError("ERR syntax error")
+		return
+	}
+
+	scores := make([]float64, 0, len(args)/2)
+	members := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		score, ok := parseScore(args[i])
+		if !ok {
+			w.AppendError(errNotFloat)
+			return
+		}
+		scores = append(scores, score)
+		members = append(members, args[i+1].String())
+	}
+	s.zaddPairs(w, c.Arg(0).String(), f, scores, members)
+}
+
+// zaddPairs adds members with their scores to the sorted set at key, it
+// validates the options and replies like ZADD
+func (s *store) zaddPairs(w resp.ResponseWriter, key string, f zaddFlags, scores []float64, members []string) {
+	switch {
+	case f.nx && f.xx:
+		w.AppendError(errZAddXXNX)
+		return
+	case (f.gt && f.nx) || (f.lt && f.nx) || (f.gt && f.lt):
+		w.AppendError(errZAddGTLT)
+		return
+	case f.incr && len(members) != 1:
+		w.AppendError(errZAddIncr)
+		return
+	}
+
+	var added, changed int64
+	var res float64
+	var updated bool
+	var msg string
+	if !s.modifyTyped(w, key, "zset", func(cur *entry) (*entry, bool) {
+		var z *zset
+		switch {
+		case cur != nil:
+			z = cur.obj.(*zset)
+		case f.xx:
+			return nil, false
+		default:
+			z = newZSet()
+		}
+
+		for i, member := range members {
+			score := scores[i]
+			old, exists := z.score(member)
+			if f.incr && exists {
+				if score += old; math.IsNaN(score) {
+					msg = errZScoreNaN
+					break
+				}
+			}
+
+			switch {
+			case exists && (f.nx || (f.gt && score <= old) || (f.lt && score >= old)):
+				continue
+			case !exists && f.xx:
+				continue
+			case !exists:
+				added++
+			case score != old:
+				changed++
+			}
+			z.set(member, score)
+			res, updated = score, true
+		}
+
+		switch {
+		case z.len() == 0:
+			return nil, false
+		case cur == nil:
+			e := newEntry(nil)
+			e.obj = z
+			return e, true
+		}
+		return cur, added+changed != 0
+	}) {
+		return
+	}
+
+	switch {
+	case msg != "":
+		w.AppendError(msg)
+	case f.incr && !updated:
+		w.AppendNil()
+	case f.incr:
+		resp.AppendFloat(w, res)
+	case f.ch:
+		w.AppendInt(added + changed)
+	default:
+		w.AppendInt(added)
+	}
+}
+
+func (s *store) zrem(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var n int64
+	if !s.modifyTyped(w, c.Arg(0).String(), "zset", func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		z := cur.obj.(*zset)
+
+		for _, arg := range c.Args[1:] {
+			if z.remove(arg.String()) {
+				n++
+			}
+		}
+		if z.len() == 0 {
+			return nil, true
+		}
+		return cur, n != 0
+	}) {
+		return
+	}
+	w.AppendInt(n)
+}
+
+func (s *store) zscore(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "zset", func(e *entry) {
+		if e == nil {
+			w.AppendNil()
+			return
+		}
+
+		if score, ok := e.obj.(*zset).score(c.Arg(1).String()); ok {
+			resp.AppendFloat(w, score)
+		} else {
+			w.AppendNil()
+		}
+	})
+}
+
+func (s *store) zcard(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "zset", func(e *entry) {
+		if e == nil {
+			w.AppendInt(0)
+			return
+		}
+		w.AppendInt(int64(e.obj.(*zset).len()))
+	})
+}
+
+// Kinds of ZRANGE queries
+const (
+	zrangeAuto = iota
+	zrangeRank
+	zrangeScore
+	zrangeLex
+)
+
+// Error replies of range queries
+const (
+	errZRangeScore = "ERR min or max is not a float"
+	errZRangeLex   = "ERR min or max not valid string range item"
+)
+
+// zrangeSpec is a parsed range query of ZRANGE and its variants
+type zrangeSpec struct {
+	by         int
+	rev        bool
+	withScores bool
+
+	// offset and count are the LIMIT, count is negative if unlimited
+	offset, count int64
+
+	// start and stop are the ranks of rank queries, rng is the range of
+	// score and lex queries
+	start, stop int64
+	rng         zslRange
+}
+
+// parseScoreBound parses a bound of a score range, "(" marks exclusive
+// bounds
+func parseScoreBound(arg resp.CommandArgument) (float64, bool, bool) {
+	ex := len(arg) != 0 && arg[0] == '('
+	if ex {
+		arg = arg[1:]
+	}
+	f, ok := parseScore(arg)
+	return f, ex, ok
+}
+
+// parseLexBound parses a bound of a lex range, "[" and "(" mark
+// inclusive and exclusive bounds, "-" and "+" are infinite
+func parseLexBound(arg resp.CommandArgument) (lexBound, bool) {
+	switch {
+	case string(arg) == "-":
+		return lexBound{inf: -1}, true
+	case string(arg) == "+":
+		return lexBound{inf: 1}, true
+	case len(arg) != 0 && arg[0] == '[':
+		return lexBound{member: string(arg[1:])}, true
+	case len(arg) != 0 && arg[0] == '(':
+		return lexBound{member: string(arg[1:]), ex: true}, true
+	}
+	return lexBound{}, false
+}
+
+// parseZRange parses "min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+// [WITHSCORES]". BYSCORE, BYLEX and REV are only accepted if by is
+// zrangeAuto, WITHSCORES only unless the result is stored. It returns an
+// error reply on failure.
+func parseZRange(args []resp.CommandArgument, by int, rev, store bool) (*zrangeSpec, string) {
+	sp := &zrangeSpec{by: by, rev: rev, count: -1}
+	limit := false
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i].String()); {
+		case opt == "withscores" && !store:
+			sp.withScores = true
+		case opt == "limit" && i+2 < len(args):
+			var err1, err2 error
+			sp.offset, err1 = args[i+1].Int()
+			sp.count, err2 = args[i+2].Int()
+			if err1 != nil || err2 != nil {
+				return nil, errNotInteger
+			}
+			limit = true
+			i += 2
+		case opt == "byscore" && by == zrangeAuto && sp.by == zrangeAuto:
+			sp.by = zrangeScore
+		case opt == "bylex" && by == zrangeAuto && sp.by == zrangeAuto:
+			sp.by = zrangeLex
+		case opt == "rev" && by == zrangeAuto:
+			sp.rev = true
+		default:
+			return nil, "ERR syntax error"
+		}
+	}
+	if sp.by == zrangeAuto {
+		sp.by = zrangeRank
+	}
+
+	switch {
+	case limit && sp.by == zrangeRank:
+		return nil, "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"
+	case sp.withScores && sp.by == zrangeLex:
+		return nil, "ERR syntax error, WITHSCORES not supported in combination with BYLEX"
+	}
+
+	// reverse score and lex ranges are given as max, min
+	min, max := args[0], args[1]
+	if sp.rev && sp.by != zrangeRank {
+		min, max = max, min
+	}
+
+	switch sp.by {
+	case zrangeRank:
+		var err1, err2 error
+		sp.start, err1 = min.Int()
+		sp.stop, err2 = max.Int()
+		if err1 != nil || err2 != nil {
+			return nil, errNotInteger
+		}
+	case zrangeScore:
+		r := new(scoreRange)
+		var ok1, ok2 bool
+		r.min, r.minex, ok1 = parseScoreBound(min)
+		r.max, r.maxex, ok2 = parseScoreBound(max)
+		if !ok1 || !ok2 {
+			return nil, errZRangeScore
+		}
+		sp.rng = r
+	case zrangeLex:
+		r := new(lexRange)
+		var ok1, ok2 bool
+		r.min, ok1 = parseLexBound(min)
+		r.max, ok2 = parseLexBound(max)
+		if !ok1 || !ok2 {
+			return nil, errZRangeLex
+		}
+		sp.rng = r
+	}
+	return sp, ""
+}
+
+// rangeOf returns the nodes in the range, in order
+func (z *zset) rangeOf(sp *zrangeSpec) []*zslNode {
+	next := func(x *zslNode) *zslNode {
+		if sp.rev {
+			return x.backward
+		}
+		return x.level[0].forward
+	}
+
+	var res []*zslNode
+	if sp.by == zrangeRank {
+		start, stop, ok := normRange(sp.start, sp.stop, int64(z.len()))
+		if !ok {
+			return nil
+		}
+
+		x := z.zsl.byRank(int(start) + 1)
+		if sp.rev {
+			x = z.zsl.byRank(z.len() - int(start))
+		}
+		for n := stop - start + 1; n > 0; n-- {
+			res = append(res, x)
+			x = next(x)
+		}
+		return res
+	}
+
+	if sp.offset < 0 {
+		return nil
+	}
+	x, inRange := z.zsl.firstInRange(sp.rng), sp.rng.lteMax
+	if sp.rev {
+		x, inRange = z.zsl.lastInRange(sp.rng), sp.rng.gteMin
+	}
+	for skip, n := sp.offset, sp.count; x != nil && n != 0 && inRange(x); x = next(x) {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		res = append(res, x)
+		n--
+	}
+	return res
+}
+
+// replyNodes replies with the members of nodes, and their scores if set
+func replyNodes(w resp.ResponseWriter, nodes []*zslNode, withScores bool) {
+	if withScores {
+		w.AppendArrayLen(2 * len(nodes))
+	} else {
+		w.AppendArrayLen(len(nodes))
+	}
+	for _, x := range nodes {
+		w.AppendBulkString(x.member)
+		if withScores {
+			resp.AppendFloat(w, x.score)
+		}
+	}
+}
+
+// zrange implements ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT
+// offset count] [WITHSCORES]
+// https://redis.io/commands/zrange
+func (s *store) zrange(w resp.ResponseWriter, c *resp.Command) {
+	s.zrangeGeneric(w, c, zrangeAuto, false)
+}
+
+func (s *store) zrevrange(w resp.ResponseWriter, c *resp.Command) {
+	s.zrangeGeneric(w, c, zrangeRank, true)
+}
+
+func (s *store) zrangebyscore(w resp.ResponseWriter, c *resp.Command) {
+	s.zrangeGeneric(w, c, zrangeScore, false)
+}
+
+func (s *store) zrevrangebyscore(w resp.ResponseWriter, c *resp.Command) {
+	s.zrangeGeneric(w, c, zrangeScore, true)
+}
+
+func (s *store) zrangebylex(w resp.ResponseWriter, c *resp.Command) {
+	s.zrangeGeneric(w, c, zrangeLex, false)
+}
+
+func (s *store) zrevrangebylex(w resp.ResponseWriter, c *resp.Command) {
+	s.zrangeGeneric(w, c, zrangeLex, true)
+}
+
+// zrangeGeneric implements the range queries of ZRANGE and its variants
+func (s *store) zrangeGeneric(w resp.ResponseWriter, c *resp.Command, by int, rev bool) {
+	if c.ArgN() < 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	sp, msg := parseZRange(c.Args[1:], by, rev, false)
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	s.viewTyped(w, c.Arg(0).String(), "zset", func(e *entry) {
+		if e == nil {
+			w.AppendArrayLen(0)
+			return
+		}
+		replyNodes(w, e.obj.(*zset).rangeOf(sp), sp.withScores)
+	})
+}
+
+// zrangestore implements ZRANGESTORE dst src min max [BYSCORE|BYLEX]
+// [REV] [LIMIT offset count], empty results delete the destination
+// https://redis.io/commands/zrangestore
+func (s *store) zrangestore(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 4 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	sp, msg := parseZRange(c.Args[2:], zrangeAuto, false, true)
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	keys, pos := distinctKeys(c.Args[:2])
+	var n int
+	s.keys.modifyAll(keys, func(cur []*entry) ([]*entry, bool) {
+		res := newZSet()
+		src := cur[pos[c.Arg(1).String()]]
+		if !typed(src, "zset") {
+			msg = errWrongType
+			return nil, false
+		} else if src != nil {
+			for _, x := range src.obj.(*zset).rangeOf(sp) {
+				res.set(x.member, x.score)
+			}
+		}
+
+		next := append([]*entry(nil), cur...)
+		if n = res.len(); n == 0 {
+			next[0] = nil
+			return next, cur[0] != nil
+		}
+		next[0] = newEntry(nil)
+		next[0].obj = res
+		return next, true
+	})
+
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	w.AppendInt(int64(n))
+}
+
+// pop removes up to count members with the lowest, or highest scores
+func (z *zset) pop(count int, max bool) []*zslNode {
+	var res []*zslNode
+	for ; count > 0 && z.len() != 0; count-- {
+		x := z.zsl.header.level[0].forward
+		if max {
+			x = z.zsl.tail
+		}
+		z.remove(x.member)
+		res = append(res, x)
+	}
+	return res
+}
+
+// popFrom pops up to count members from the sorted set at key, it
+// returns false if the key does not exist, or an error reply
+func (s *store) popFrom(key string, count int, max bool) ([]*zslNode, bool, string) {
+	var res []*zslNode
+	var found bool
+	var msg string
+	s.keys.modify(key, func(cur *entry) (*entry, bool) {
+		if cur == nil {
+			return nil, false
+		}
+		if !typed(cur, "zset") {
+			msg = errWrongType
+			return nil, false
+		}
+		z := cur.obj.(*zset)
+
+		found = true
+		if res = z.pop(count, max); z.len() == 0 {
+			return nil, true
+		}
+		return cur, len(res) != 0
+	})
+	return res, found, msg
+}
+
+func (s *store) zpopmin(w resp.ResponseWriter, c *resp.Command) {
+	s.zpop(w, c, false)
+}
+
+func (s *store) zpopmax(w resp.ResponseWriter, c *resp.Command) {
+	s.zpop(w, c, true)
+}
+
+// zpop implements ZPOPMIN and ZPOPMAX key [count]
+func (s *store) zpop(w resp.ResponseWriter, c *resp.Command, max bool) {
+	if c.ArgN() != 1 && c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	count := int64(1)
+	if c.ArgN() == 2 {
+		var err error
+		if count, err = c.Arg(1).Int(); err != nil {
+			w.AppendError(errNotInteger)
+			return
+		} else if count < 0 {
+			w.AppendError("ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	res, _, msg := s.popFrom(c.Arg(0).String(), int(count), max)
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+	replyNodes(w, res, true)
+}
+
+func (s *store) bzpopmin(w resp.ResponseWriter, c *resp.Command) {
+	s.bzpop(w, c, false)
+}
+
+func (s *store) bzpopmax(w resp.ResponseWriter, c *resp.Command) {
+	s.bzpop(w, c, true)
+}
+
+// bzpop implements BZPOPMIN and BZPOPMAX key [key ...] timeout, blocking
+// until one of the keys holds a sorted set. Pops are propagated as ZPOPMIN
+// or ZPOPMAX. As a redeo.Master serializes write handlers, blocking
+// commands must not be served through one.
+func (s *store) bzpop(w resp.ResponseWriter, c *resp.Command, max bool) {
+	if c.ArgN() < 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	timeout, msg := parseTimeout(c.Arg(c.ArgN() - 1))
+	if msg != "" {
+		w.AppendError(msg)
+		return
+	}
+
+	keys := make([]string, 0, c.ArgN()-1)
+	for _, arg := range c.Args[:c.ArgN()-1] {
+		keys = append(keys, arg.String())
+	}
+
+	name := "ZPOPMIN"
+	if max {
+		name = "ZPOPMAX"
+	}
+
+	var key string
+	var res []*zslNode
+	redeo.Rewrite(c.Context())
+	ok := s.blocked.block(c.Context(), keys, timeout, func(k string) bool {
+		var found bool
+		if res, found, msg = s.popFrom(k, 1, max); found && msg == "" {
+			redeo.Rewrite(c.Context(), resp.NewCommand(name, resp.CommandArgument(k)))
+		}
+		key = k
+		return found || msg != ""
+	})
+
+	switch {
+	case msg != "":
+		w.AppendError(msg)
+	case !ok:
+		resp.AppendNilArray(w)
+	default:
+		w.AppendArrayLen(3)
+		w.AppendBulkString(key)
+		w.AppendBulkString(res[0].member)
+		resp.AppendFloat(w, res[0].score)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/johntech-o/redeo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("zset", func() {
+	var subject *store
+
+	BeforeEach(func() {
+		subject = newStore(redeo.NewServer(nil).Info())
+		Expect(call(subject.zadd, "ZADD", "z", "1", "a", "2", "b", "3", "c")).To(Equal(int64(3)))
+	})
+
+	It("should add and remove members", func() {
+		Expect(call(subject.zadd, "ZADD", "z", "CH", "5", "a", "2", "b", "4", "d")).To(Equal(int64(2)))
+		Expect(call(subject.zscore, "ZSCORE", "z", "a")).To(Equal("5"))
+		Expect(call(subject.zscore, "ZSCORE", "z", "x")).To(BeNil())
+		Expect(call(subject.zcard, "ZCARD", "z")).To(Equal(int64(4)))
+
+		Expect(call(subject.zrem, "ZREM", "z", "a", "x")).To(Equal(int64(1)))
+		Expect(call(subject.zrem, "ZREM", "z", "b", "c", "d")).To(Equal(int64(3)))
+		Expect(call(subject.exists, "EXISTS", "z")).To(Equal(int64(0)))
+		Expect(call(subject.zcard, "ZCARD", "z")).To(Equal(int64(0)))
+	})
+
+	It("should support options", func() {
+		Expect(call(subject.zadd, "ZADD", "z", "NX", "5", "a", "4", "d")).To(Equal(int64(1)))
+		Expect(call(subject.zadd, "ZADD", "z", "XX", "CH", "5", "a", "5", "e")).To(Equal(int64(1)))
+		Expect(call(subject.zadd, "ZADD", "z", "GT", "CH", "1", "a", "3", "b")).To(Equal(int64(1)))
+		Expect(call(subject.zadd, "ZADD", "z", "LT", "CH", "1", "a", "9", "c")).To(Equal(int64(1)))
+		Expect(call(subject.zscore, "ZSCORE", "z", "a")).To(Equal("1"))
+		Expect(call(subject.zscore, "ZSCORE", "z", "e")).To(BeNil())
+
+		Expect(call(subject.zadd, "ZADD", "z", "INCR", "1.5", "a")).To(Equal("2.5"))
+		Expect(call(subject.zadd, "ZADD", "z", "NX", "INCR", "1", "a")).To(BeNil())
+		Expect(call(subject.zadd, "ZADD", "z", "INCR", "-inf", "a")).To(Equal("-inf"))
+		Expect(call(subject.zadd, "ZADD", "z", "INCR", "+inf", "a")).To(MatchError("ERR resulting score is not a number (NaN)"))
+		Expect(call(subject.zadd, "ZADD", "y", "XX", "1", "a")).To(Equal(int64(0)))
+		Expect(call(subject.exists, "EXISTS", "y")).To(Equal(int64(0)))
+	})
+
+	It("should reject invalid arguments", func() {
+		Expect(call(subject.zadd, "ZADD", "z", "1")).To(MatchError("ERR wrong number of arguments for 'ZADD' command"))
+		Expect(call(subject.zadd, "ZADD", "z", "1", "a", "2")).To(MatchError("ERR syntax error"))
+		Expect(call(subject.zadd, "ZADD", "z", "x", "a")).To(MatchError("ERR value is not a valid float"))
+		Expect(call(subject.zadd, "ZADD", "z", "nan", "a")).To(MatchError("ERR value is not a valid float"))
+		Expect(call(subject.zadd, "ZADD", "z", "NX", "XX", "1", "a")).To(MatchError("ERR XX and NX options at the same time are not compatible"))
+		Expect(call(subject.zadd, "ZADD", "z", "GT", "LT", "1", "a")).To(MatchError("ERR GT, LT, and/or NX options at the same time are not compatible"))
+		Expect(call(subject.zadd, "ZADD", "z", "INCR", "1", "a", "2", "b")).To(MatchError("ERR INCR option supports a single increment-element pair"))
+	})
+
+	It("should check types", func() {
+		Expect(call(subject.set, "SET", "s", "v")).To(Equal("OK"))
+		Expect(call(subject.zadd, "ZADD", "s", "1", "a")).To(MatchError(errWrongType))
+		Expect(call(subject.zscore, "ZSCORE", "s", "a")).To(MatchError(errWrongType))
+		Expect(call(subject.get, "GET", "z")).To(MatchError(errWrongType))
+		Expect(call(subject.append, "APPEND", "z", "x")).To(MatchError(errWrongType))
+		Expect(call(subject.incr, "INCR", "z")).To(MatchError(errWrongType))
+		Expect(call(subject.setbit, "SETBIT", "z", "1", "1")).To(MatchError(errWrongType))
+		Expect(call(subject.pfadd, "PFADD", "z", "a")).To(MatchError(errWrongType))
+		Expect(call(subject.set, "SET", "z", "v", "GET")).To(MatchError(errWrongType))
+
+		Expect(call(subject.keyType, "TYPE", "z")).To(Equal("zset"))
+		Expect(call(subject.keyType, "TYPE", "s")).To(Equal("string"))
+		Expect(call(subject.keyType, "TYPE", "x")).To(Equal("none"))
+		Expect(call(subject.set, "SET", "z", "v")).To(Equal("OK"))
+		Expect(call(subject.keyType, "TYPE", "z")).To(Equal("string"))
+	})
+
+	It("should copy and expire sorted sets", func() {
+		Expect(call(subject.copy, "COPY", "z", "y")).To(Equal(int64(1)))
+		Expect(call(subject.zrem, "ZREM", "y", "a")).To(Equal(int64(1)))
+		Expect(call(subject.zcard, "ZCARD", "z")).To(Equal(int64(3)))
+		Expect(call(subject.zcard, "ZCARD", "y")).To(Equal(int64(2)))
+
+		Expect(call(subject.expire("ex"), "EXPIRE", "z", "100")).To(Equal(int64(1)))
+		Expect(call(subject.rename, "RENAME", "z", "x")).To(Equal("OK"))
+		Expect(call(subject.ttl, "TTL", "x")).To(Equal(int64(100)))
+		Expect(call(subject.zscore, "ZSCORE", "x", "c")).To(Equal("3"))
+	})
+
+	It("should maintain ranks", func() {
+		z := newZSet()
+		for i := 0; i < 1000; i++ {
+			z.set(string(rune('a'+i%26))+string(rune('a'+i/26)), float64(i%100))
+		}
+		for i := 0; i < 1000; i += 3 {
+			z.remove(string(rune('a'+i%26)) + string(rune('a'+i/26)))
+		}
+		Expect(z.len()).To(Equal(666))
+		Expect(z.zsl.length).To(Equal(666))
+
+		ranks := make(map[*zslNode]int)
+		var prev *zslNode
+		for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			if prev != nil {
+				Expect(prev.before(x.score, x.member)).To(BeTrue())
+			}
+			Expect(x.backward).To(Equal(prev))
+			prev = x
+			ranks[x] = len(ranks) + 1
+		}
+		Expect(ranks).To(HaveLen(666))
+		Expect(z.zsl.tail).To(Equal(prev))
+
+		for i := 0; i < z.zsl.level; i++ {
+			rank := 0
+			for x := z.zsl.header; x.level[i].forward != nil; x = x.level[i].forward {
+				rank += x.level[i].span
+				Expect(rank).To(Equal(ranks[x.level[i].forward]))
+			}
+		}
+	})
+
+	It("should query ranges by rank", func() {
+		Expect(call(subject.zadd, "ZADD", "z", "4", "d")).To(Equal(int64(1)))
+		Expect(call(subject.zrange, "ZRANGE", "z", "0", "-1")).To(Equal([]interface{}{"a", "b", "c", "d"}))
+		Expect(call(subject.zrange, "ZRANGE", "z", "1", "2", "WITHSCORES")).To(Equal([]interface{}{"b", "2", "c", "3"}))
+		Expect(call(subject.zrange, "ZRANGE", "z", "0", "1", "REV")).To(Equal([]interface{}{"d", "c"}))
+		Expect(call(subject.zrevrange, "ZREVRANGE", "z", "-2", "-1")).To(Equal([]interface{}{"b", "a"}))
+		Expect(call(subject.zrange, "ZRANGE", "z", "5", "10")).To(BeEmpty())
+		Expect(call(subject.zrange, "ZRANGE", "x", "0", "-1")).To(BeEmpty())
+
+		Expect(call(subject.zrange, "ZRANGE", "z", "0", "1", "LIMIT", "0", "1")).To(MatchError(
+			"ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"))
+		Expect(call(subject.zrange, "ZRANGE", "z", "a", "1")).To(MatchError("ERR value is not an integer or out of range"))
+	})
+
+	It("should query ranges by score", func() {
+		Expect(call(subject.zadd, "ZADD", "z", "4", "d", "5", "e")).To(Equal(int64(2)))
+		Expect(call(subject.zrangebyscore, "ZRANGEBYSCORE", "z", "2", "4")).To(Equal([]interface{}{"b", "c", "d"}))
+		Expect(call(subject.zrangebyscore, "ZRANGEBYSCORE", "z", "(2", "+inf", "WITHSCORES", "LIMIT", "1", "2")).To(Equal([]interface{}{"d", "4", "e", "5"}))
+		Expect(call(subject.zrangebyscore, "ZRANGEBYSCORE", "z", "-inf", "(1")).To(BeEmpty())
+		Expect(call(subject.zrevrangebyscore, "ZREVRANGEBYSCORE", "z", "4", "(2")).To(Equal([]interface{}{"d", "c"}))
+		Expect(call(subject.zrange, "ZRANGE", "z", "(5", "0", "BYSCORE", "REV", "LIMIT", "0", "2")).To(Equal([]interface{}{"d", "c"}))
+		Expect(call(subject.zrangebyscore, "ZRANGEBYSCORE", "z", "0", "9", "LIMIT", "-1", "2")).To(BeEmpty())
+
+		Expect(call(subject.zrangebyscore, "ZRANGEBYSCORE", "z", "x", "1")).To(MatchError("ERR min or max is not a float"))
+		Expect(call(subject.zrangebyscore, "ZRANGEBYSCORE", "z", "0", "1", "REV")).To(MatchError("ERR syntax error"))
+	})
+
+	It("should query ranges by member", func() {
+		Expect(call(subject.zadd, "ZADD", "l", "0", "a", "0", "b", "0", "c", "0", "d")).To(Equal(int64(4)))
+		Expect(call(subject.zrangebylex, "ZRANGEBYLEX", "l", "-", "+")).To(Equal([]interface{}{"a", "b", "c", "d"}))
+		Expect(call(subject.zrangebylex, "ZRANGEBYLEX", "l", "(a", "[c")).To(Equal([]interface{}{"b", "c"}))
+		Expect(call(subject.zrangebylex, "ZRANGEBYLEX", "l", "[b", "+", "LIMIT", "1", "1")).To(Equal([]interface{}{"c"}))
+		Expect(call(subject.zrevrangebylex, "ZREVRANGEBYLEX", "l", "(c", "-")).To(Equal([]interface{}{"b", "a"}))
+		Expect(call(subject.zrange, "ZRANGE", "l", "[c", "[a", "BYLEX", "REV")).To(Equal([]interface{}{"c", "b", "a"}))
+		Expect(call(subject.zrangebylex, "ZRANGEBYLEX", "l", "+", "-")).To(BeEmpty())
+
+		Expect(call(subject.zrangebylex, "ZRANGEBYLEX", "l", "a", "+")).To(MatchError("ERR min or max not valid string range item"))
+		Expect(call(subject.zrange, "ZRANGE", "l", "-", "+", "BYLEX", "WITHSCORES")).To(MatchError(
+			"ERR syntax error, WITHSCORES not supported in combination with BYLEX"))
+	})
+
+	It("should store ranges", func() {
+		Expect(call(subject.zrangestore, "ZRANGESTORE", "d", "z", "1", "+inf", "BYSCORE", "LIMIT", "1", "5")).To(Equal(int64(2)))
+		Expect(call(subject.zrange, "ZRANGE", "d", "0", "-1", "WITHSCORES")).To(Equal([]interface{}{"b", "2", "c", "3"}))
+		Expect(call(subject.zrangestore, "ZRANGESTORE", "z", "z", "0", "0")).To(Equal(int64(1)))
+		Expect(call(subject.zrange, "ZRANGE", "z", "0", "-1")).To(Equal([]interface{}{"a"}))
+		Expect(call(subject.zrangestore, "ZRANGESTORE", "d", "x", "0", "-1")).To(Equal(int64(0)))
+		Expect(call(subject.exists, "EXISTS", "d")).To(Equal(int64(0)))
+		Expect(call(subject.zrangestore, "ZRANGESTORE", "d", "z", "0", "-1", "WITHSCORES")).To(MatchError("ERR syntax error"))
+	})
+
+	It("should pop members", func() {
+		Expect(call(subject.zpopmin, "ZPOPMIN", "z")).To(Equal([]interface{}{"a", "1"}))
+		Expect(call(subject.zpopmax, "ZPOPMAX", "z", "5")).To(Equal([]interface{}{"c", "3", "b", "2"}))
+		Expect(call(subject.exists, "EXISTS", "z")).To(Equal(int64(0)))
+		Expect(call(subject.zpopmin, "ZPOPMIN", "z")).To(BeEmpty())
+		Expect(call(subject.zpopmin, "ZPOPMIN", "z", "-1")).To(MatchError("ERR value is out of range, must be positive"))
+	})
+
+	It("should block until members are added", func() {
+		Expect(call(subject.bzpopmin, "BZPOPMIN", "x", "z", "0")).To(Equal([]interface{}{"z", "a", "1"}))
+		Expect(call(subject.bzpopmax, "BZPOPMAX", "x", "0.01")).To(BeNil())
+		Expect(call(subject.bzpopmin, "BZPOPMIN", "x", "-1")).To(MatchError("ERR timeout is negative"))
+		Expect(call(subject.bzpopmin, "BZPOPMIN", "x", "y")).To(MatchError("ERR timeout is not a float or out of range"))
+
+		var replies [3]chan interface{}
+		for i := range replies {
+			replies[i] = make(chan interface{}, 1)
+			go func(ch chan interface{}) { ch <- call(subject.bzpopmin, "BZPOPMIN", "x", "y", "5") }(replies[i])
+			Eventually(func() int32 { return atomic.LoadInt32(&subject.blocked.n) }).Should(Equal(int32(i + 1)))
+		}
+
+		Expect(call(subject.zadd, "ZADD", "y", "2", "m", "1", "n")).To(Equal(int64(2)))
+		Expect(<-replies[0]).To(Equal([]interface{}{"y", "n", "1"}))
+		Expect(<-replies[1]).To(Equal([]interface{}{"y", "m", "2"}))
+		Expect(call(subject.set, "SET", "x", "v")).To(Equal("OK"))
+		Expect(<-replies[2]).To(MatchError(errWrongType))
+		Expect(subject.blocked.waiters).To(BeEmpty())
+	})
+
+})
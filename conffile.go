@@ -0,0 +1,330 @@
+package redeo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// ConfigFile is a redis.conf-style configuration file, with one
+// "directive value" pair per line. Comments, blank lines and unknown
+// directives are preserved when the file is rewritten. The supported
+// directives are:
+//
+//   timeout                  Timeout, in seconds
+//   tcp-keepalive            TCPKeepAlive, in seconds
+//   close-on-protocol-error  CloseOnProtocolError, yes or no
+//   read-buffer-size         ReadBufferSize
+//   max-read-buffer-size     MaxReadBufferSize
+//   write-buffer-size        WriteBufferSize
+//   read-only                ReadOnly, yes or no
+//   acceptors                Acceptors
+//   event-loop               EventLoop, yes or no
+//   command-budget           CommandBudget
+//
+type ConfigFile struct {
+	path  string
+	lines []string
+	mu    sync.Mutex
+}
+
+// configRewriteMarker precedes directives appended by Rewrite
+const configRewriteMarker = "# Generated by CONFIG REWRITE"
+
+type configDirective struct {
+	name string
+	get  func(*Config) string
+	set  func(*Config, string) error
+}
+
+var configDirectives = []configDirective{
+	secondsDirective("timeout", func(c *Config) *time.Duration { return &c.Timeout }),
+	secondsDirective("tcp-keepalive", func(c *Config) *time.Duration { return &c.TCPKeepAlive }),
+	boolDirective("close-on-protocol-error", func(c *Config) *bool { return &c.CloseOnProtocolError }),
+	intDirective("read-buffer-size", func(c *Config) *int { return &c.ReadBufferSize }),
+	intDirective("max-read-buffer-size", func(c *Config) *int { return &c.MaxReadBufferSize }),
+	intDirective("write-buffer-size", func(c *Config) *int { return &c.WriteBufferSize }),
+	boolDirective("read-only", func(c *Config) *bool { return &c.ReadOnly }),
+	intDirective("acceptors", func(c *Config) *int { return &c.Acceptors }),
+	boolDirective("event-loop", func(c *Config) *bool { return &c.EventLoop }),
+	intDirective("command-budget", func(c *Config) *int { return &c.CommandBudget }),
+}
+
+func secondsDirective(name string, field func(*Config) *time.Duration) configDirective {
+	return configDirective{
+		name: name,
+		get:  func(c *Config) string { return strconv.FormatInt(int64(*field(c)/time.Second), 10) },
+		set: func(c *Config, s string) error {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			*field(c) = time.Duration(n) * time.Second
+			return nil
+		},
+	}
+}
+
+func intDirective(name string, field func(*Config) *int) configDirective {
+	return configDirective{
+		name: name,
+		get:  func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, s string) (err error) {
+			*field(c), err = strconv.Atoi(s)
+			return err
+		},
+	}
+}
+
+func boolDirective(name string, field func(*Config) *bool) configDirective {
+	return configDirective{
+		name: name,
+		get: func(c *Config) string {
+			if *field(c) {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *Config, s string) error {
+			switch strings.ToLower(s) {
+			case "yes":
+				*field(c) = true
+			case "no":
+				*field(c) = false
+			default:
+				return fmt.Errorf("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+	}
+}
+
+// ReadConfigFile reads a configuration file
+func ReadConfigFile(path string) (*ConfigFile, error) {
+	lines, err := readConfigLines(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigFile{path: path, lines: lines}, nil
+}
+
+func readConfigLines(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines, s.Err()
+}
+
+// Reload re-reads the file, e.g. after it has been edited
+func (f *ConfigFile) Reload() error {
+	lines, err := readConfigLines(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.lines = lines
+	f.mu.Unlock()
+	return nil
+}
+
+// Path returns the path of the file
+func (f *ConfigFile) Path() string { return f.path }
+
+// Get returns the value of the last occurrence of a directive
+func (f *ConfigFile) Get(name string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := len(f.lines) - 1; i > -1; i-- {
+		if key, val, ok := parseConfigLine(f.lines[i]); ok && key == name {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+// Config parses the supported directives into a new Config. Values
+// which are not set in the file remain at their defaults.
+func (f *ConfigFile) Config() (*Config, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	config := new(Config)
+	for n, line := range f.lines {
+		key, val, ok := parseConfigLine(line)
+		if !ok {
+			continue
+		}
+		for _, d := range configDirectives {
+			if d.name == key {
+				if err := d.set(config, val); err != nil {
+					return nil, fmt.Errorf("redeo: %s:%d: bad directive %q: %v", f.path, n+1, key, err)
+				}
+				break
+			}
+		}
+	}
+	return config, config.Validate()
+}
+
+// Rewrite writes the supported directives of config back to the file,
+// like CONFIG REWRITE. Directives are updated in place and duplicates
+// removed, missing ones are appended unless they are at their defaults.
+// The file is replaced atomically.
+func (f *ConfigFile) Rewrite(config *Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var defaults Config
+	lines := make([]string, 0, len(f.lines))
+	last := make(map[string]int)
+	removed := make(map[int]bool)
+
+	for _, line := range f.lines {
+		if strings.TrimSpace(line) == configRewriteMarker {
+			continue
+		}
+		if key, _, ok := parseConfigLine(line); ok && isConfigDirective(key) {
+			if i, dup := last[key]; dup {
+				removed[i] = true
+			}
+			last[key] = len(lines)
+		}
+		lines = append(lines, line)
+	}
+
+	var appended []string
+	for _, d := range configDirectives {
+		line := d.name + " " + d.get(config)
+		if i, ok := last[d.name]; ok {
+			lines[i] = line
+		} else if d.get(config) != d.get(&defaults) {
+			appended = append(appended, line)
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if removed[i] {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if len(appended) != 0 {
+		buf.WriteString(configRewriteMarker + "\n")
+		for _, line := range appended {
+			buf.WriteString(line + "\n")
+		}
+	}
+
+	if err := writeFileAtomic(f.path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	next, err := readConfigLines(f.path)
+	if err != nil {
+		return err
+	}
+	f.lines = next
+	return nil
+}
+
+func isConfigDirective(name string) bool {
+	for _, d := range configDirectives {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfigLine splits a line into the lower-case directive and its
+// value, it returns false for blank lines and comments
+func parseConfigLine(line string) (string, string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return "", "", false
+	}
+
+	val := strings.Join(fields[1:], " ")
+	if len(val) > 1 && val[0] == '"' && val[len(val)-1] == '"' {
+		val = val[1 : len(val)-1]
+	}
+	return strings.ToLower(fields[0]), val, true
+}
+
+// writeFileAtomic replaces the file by renaming a temporary file
+// written to the same directory
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ConfigRewrite returns a CONFIG REWRITE handler, for use as a
+// sub-command of CONFIG. It writes the current configuration of the
+// server, including changes applied via Server.Reload, back to the file
+// the configuration was loaded from. A nil file makes the server run
+// without a config file.
+// https://redis.io/commands/config-rewrite
+func ConfigRewrite(s *Server, f *ConfigFile) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		if f == nil {
+			w.AppendError("ERR The server is running without a config file")
+			return
+		}
+
+		config := *s.conf().Config
+		config.ReadOnly = s.ReadOnly()
+		if err := f.Rewrite(&config); err != nil {
+			w.AppendError("ERR Rewriting config file: " + err.Error())
+			return
+		}
+		w.AppendOK()
+	})
+}
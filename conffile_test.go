@@ -0,0 +1,96 @@
+package redeo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigFile", func() {
+	var dir, path string
+
+	write := func(data string) *ConfigFile {
+		Expect(ioutil.WriteFile(path, []byte(data), 0600)).To(Succeed())
+		f, err := ReadConfigFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return f
+	}
+
+	read := func() string {
+		data, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "redeo-conf")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "redis.conf")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should parse directives", func() {
+		f := write("# server\ntimeout 30\nTCP-KEEPALIVE 60\nread-only yes\nsave \"900 1\"\ntimeout 10\n")
+		v, ok := f.Get("save")
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal("900 1"))
+		v, ok = f.Get("timeout")
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal("10"))
+		_, ok = f.Get("missing")
+		Expect(ok).To(BeFalse())
+
+		config, err := f.Config()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(Equal(&Config{Timeout: 10 * time.Second, TCPKeepAlive: time.Minute, ReadOnly: true}))
+	})
+
+	It("should reject bad directives", func() {
+		_, err := write("read-only maybe\n").Config()
+		Expect(err).To(MatchError(`redeo: ` + path + `:1: bad directive "read-only": argument must be 'yes' or 'no'`))
+
+		_, err = write("timeout -1\n").Config()
+		Expect(err).To(MatchError("redeo: invalid config: Timeout -1s must not be negative"))
+	})
+
+	It("should rewrite preserving comments and unknown directives", func() {
+		f := write("# server\ntimeout 30\nsave 900 1\n\nread-only no\ntimeout 10\n")
+		Expect(f.Rewrite(&Config{Timeout: time.Minute, ReadOnly: true, CommandBudget: 100})).To(Succeed())
+		Expect(read()).To(Equal("# server\nsave 900 1\n\nread-only yes\ntimeout 60\n" +
+			configRewriteMarker + "\ncommand-budget 100\n"))
+
+		Expect(f.Rewrite(&Config{Timeout: time.Minute, ReadOnly: true, Acceptors: 2})).To(Succeed())
+		Expect(read()).To(Equal("# server\nsave 900 1\n\nread-only yes\ntimeout 60\n" +
+			"command-budget 0\n" + configRewriteMarker + "\nacceptors 2\n"))
+	})
+
+	It("should serve CONFIG REWRITE", func() {
+		srv := NewServer(&Config{Timeout: time.Second})
+		srv.SetReadOnly(true)
+
+		w := redeotest.NewRecorder()
+		ConfigRewrite(srv, nil).ServeRedeo(w, resp.NewCommand("CONFIG rewrite"))
+		Expect(w.Response()).To(MatchError("ERR The server is running without a config file"))
+
+		f := write("# empty\n")
+		w = redeotest.NewRecorder()
+		ConfigRewrite(srv, f).ServeRedeo(w, resp.NewCommand("CONFIG rewrite"))
+		Expect(w.Response()).To(Equal("OK"))
+		Expect(read()).To(Equal("# empty\n" + configRewriteMarker + "\ntimeout 1\nread-only yes\n"))
+
+		w = redeotest.NewRecorder()
+		ConfigRewrite(srv, f).ServeRedeo(w, resp.NewCommand("CONFIG rewrite", resp.CommandArgument("x")))
+		Expect(w.Response()).To(MatchError("ERR wrong number of arguments for 'CONFIG rewrite' command"))
+	})
+
+})
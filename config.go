@@ -1,13 +1,33 @@
 package redeo
 
-import "time"
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Config holds the server configuration
 type Config struct {
 	// Timeout represents the per-request socket read/write timeout.
+	// The resulting deadline is exposed to handlers via the command
+	// context, so they can bound downstream calls to the remaining time.
 	// Default: 0 (disabled)
 	Timeout time.Duration
 
+	// CommandTimeouts overrides Timeout for individual commands, keyed by
+	// command name, e.g. to allow blocking commands like BLPOP to wait
+	// longer than regular ones. A zero duration disables the deadline
+	// for the command. Overrides require a Timeout and do not apply to
+	// clients protected by Client.SetNoEvict.
+	// Default: nil (none)
+	CommandTimeouts map[string]time.Duration
+
 	// IdleTimeout forces servers to close idle connection once timeout is reached.
 	// Default: 0 (disabled)
 	IdleTimeout time.Duration
@@ -22,4 +42,297 @@ type Config struct {
 	// On other kernels the period depends on the kernel configuration.
 	// Default: 0 (disabled)
 	TCPKeepAlive time.Duration
+
+	// TLSAuth is an optional callback which, when set, is invoked with the
+	// leaf certificate presented by each client connecting via TLS, once the
+	// handshake has completed. The certificate is nil if the client did not
+	// present one. Returning an error rejects the client; the error message is
+	// sent to the client before the connection is closed. Messages that
+	// start with an upper-case error code, e.g. "NOAUTH certificate required",
+	// are sent as they are, all others are prefixed with "ERR".
+	// Whether certificates are requested and verified at all is controlled by
+	// the ClientAuth mode of the tls.Config passed to ServeTLS.
+	// Default: nil (disabled)
+	TLSAuth func(cert *x509.Certificate) error
+
+	// OnAccept is an optional callback which, when set, is invoked with
+	// each accepted connection before it is served and before the client is
+	// registered. It allows to reject connections, e.g. when the server is
+	// overloaded. Returning an error sends the error message to the client
+	// and closes the connection; return ErrDropConn to close the connection
+	// without a reply.
+	// Default: nil (disabled)
+	OnAccept func(cn net.Conn) error
+
+	// CloseOnProtocolError closes client connections after replying to
+	// malformed requests with a protocol error, just like Redis does.
+	// By default, the server tries to resync with the request stream and
+	// continues to serve the client.
+	// Default: false
+	CloseOnProtocolError bool
+
+	// ReadBufferSize is the size of the per-connection request buffer.
+	// Smaller buffers reduce memory usage per connection, but limit the
+	// max length of inline requests and command names, see
+	// MaxReadBufferSize. Larger arguments are always supported. Buffers
+	// are pooled across connections.
+	// Default: 64KiB (max), min: 512
+	ReadBufferSize int
+
+	// MaxReadBufferSize allows read buffers to grow temporarily, to fit
+	// inline requests and command names which exceed ReadBufferSize.
+	// Grown buffers are shrunk back as soon as all pending requests have
+	// been read, so only connections which actually send large requests
+	// pay for the extra memory.
+	// Default: 0 (no growth)
+	MaxReadBufferSize int
+
+	// WriteBufferSize is the initial size of the per-connection reply
+	// buffer. The buffer grows as needed but is flushed whenever it exceeds
+	// half this size.
+	// Default: 64KiB (max), min: 512
+	WriteBufferSize int
+
+	// MaxReplySize limits the size of individual replies, in bytes. Replies
+	// exceeding it are discarded and replaced with an error, which protects
+	// the server from handlers that accidentally produce huge responses.
+	// Clients are disconnected if parts of the reply have already been
+	// written, e.g. by CopyBulk. Oversized replies are reported via OnError.
+	// Default: 0 (unlimited)
+	MaxReplySize int
+
+	// ReadOnly starts the server in read-only mode, see Server.SetReadOnly.
+	// Default: false
+	ReadOnly bool
+
+	// Acceptors is the number of listening sockets opened by
+	// ListenAndServe. Values greater than one open multiple sockets on the
+	// same address with SO_REUSEPORT, each served by its own accept loop,
+	// and let the kernel balance incoming connections between them. Only
+	// supported on Linux.
+	// Default: 1
+	Acceptors int
+
+	// EventLoop enables an epoll-based event loop for idle connections.
+	// Instead of blocking a goroutine per connection, idle clients are
+	// parked and their buffers released until the next request arrives,
+	// which reduces memory usage considerably when serving large numbers
+	// of mostly idle clients. Only supported on Linux with plain TCP
+	// connections, TLS connections are always served
+	// goroutine-per-connection.
+	// Default: false
+	EventLoop bool
+
+	// Version is the Redis compatibility version reported as redis_version
+	// by INFO and by LOLWUT. Client libraries and tools check it on
+	// connect, e.g. to enable features.
+	// Default: DefaultVersion
+	Version string
+
+	// CommandBudget limits the number of pipelined commands a connection
+	// executes in one go. Once the budget is spent, the replies are
+	// flushed and the connection yields to others before it continues
+	// with the rest of the pipeline. This prevents clients with deep
+	// pipelines from starving other connections, in particular when
+	// GOMAXPROCS is low or the event loop is enabled.
+	// Default: 0 (unlimited)
+	CommandBudget int
+
+	// ProfilerLabels sets pprof labels around handler execution, so CPU
+	// profiles break down by command. The label key is "command", its
+	// value the lower-cased command name. Labels are also attached to the
+	// command context and inherited by goroutines started via pprof.Do.
+	// Default: false
+	ProfilerLabels bool
+
+	// Clock is the source of time for client create and access times,
+	// uptime and command durations. Socket deadlines always use the
+	// system clock. The clock is fixed when the server is created and
+	// not changed by Reload.
+	// Default: SystemClock
+	Clock Clock
+
+	// RecordDir enables session recording, for debugging and load
+	// testing. All data received from each client is recorded, with
+	// timestamps, to a file in the directory, named after the connection
+	// time and the client ID. See ReadSession and ReplaySession. Failures
+	// to record are reported via OnError and stop the recording of the
+	// affected session.
+	// Default: "" (disabled)
+	RecordDir string
+}
+
+// ConfigError lists the problems found by Config.Validate
+type ConfigError []string
+
+// Error implements error
+func (e ConfigError) Error() string {
+	return "redeo: invalid config: " + strings.Join(e, "; ")
+}
+
+// versionPattern matches Redis compatibility versions
+var versionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// Validate checks the configuration for negative limits and nonsensical
+// combinations, it returns a ConfigError listing all problems found.
+// Validate is called by Serve, ServeTLS and ListenAndServe, so bad
+// configurations fail before the first connection is accepted.
+func (c *Config) Validate() error {
+	var errs ConfigError
+	addf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"Timeout", c.Timeout},
+		{"IdleTimeout", c.IdleTimeout},
+		{"TCPKeepAlive", c.TCPKeepAlive},
+	} {
+		if d.value < 0 {
+			addf("%s %v must not be negative", d.name, d.value)
+		}
+	}
+
+	// keep-alive periods are set in whole seconds
+	if c.TCPKeepAlive > 0 && c.TCPKeepAlive < time.Second {
+		addf("TCPKeepAlive %v must be at least 1s", c.TCPKeepAlive)
+	}
+
+	if len(c.CommandTimeouts) != 0 {
+		if c.Timeout == 0 {
+			addf("CommandTimeouts require a Timeout")
+		}
+
+		names := make([]string, 0, len(c.CommandTimeouts))
+		for name := range c.CommandTimeouts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if d := c.CommandTimeouts[name]; d < 0 {
+				addf("timeout %v of command %q must not be negative", d, name)
+			}
+		}
+	}
+
+	for _, n := range []struct {
+		name  string
+		value int
+	}{
+		{"ReadBufferSize", c.ReadBufferSize},
+		{"MaxReadBufferSize", c.MaxReadBufferSize},
+		{"WriteBufferSize", c.WriteBufferSize},
+		{"MaxReplySize", c.MaxReplySize},
+		{"Acceptors", c.Acceptors},
+		{"CommandBudget", c.CommandBudget},
+	} {
+		if n.value < 0 {
+			addf("%s %d must not be negative", n.name, n.value)
+		}
+	}
+
+	if c.Version != "" && !versionPattern.MatchString(c.Version) {
+		addf("Version %q must have the form major.minor.patch", c.Version)
+	}
+
+	if c.RecordDir != "" {
+		if fi, err := os.Stat(c.RecordDir); err != nil || !fi.IsDir() {
+			addf("RecordDir %q must be a directory", c.RecordDir)
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// EnvPrefix is the prefix of environment variables read by Config.LoadEnv
+const EnvPrefix = "REDEO_"
+
+// LoadEnv overrides the configuration with environment variables, which
+// take precedence over values set in code. Variables are named after the
+// fields with EnvPrefix, e.g.:
+//
+//   REDEO_TIMEOUT=5s
+//   REDEO_TCP_KEEPALIVE=1m
+//   REDEO_READ_BUFFER_SIZE=4096
+//   REDEO_READONLY=true
+//   REDEO_VERSION=6.2.14
+//
+// Durations require a unit, booleans accept the values of
+// strconv.ParseBool. Unset variables leave fields unchanged, empty ones
+// reset them to their defaults.
+func (c *Config) LoadEnv() error {
+	return c.loadEnv(os.LookupEnv)
+}
+
+func (c *Config) loadEnv(lookup func(string) (string, bool)) error {
+	duration := func(d *time.Duration) func(string) error {
+		return func(s string) (err error) {
+			*d = 0
+			if s != "" {
+				*d, err = time.ParseDuration(s)
+			}
+			return err
+		}
+	}
+	integer := func(n *int) func(string) error {
+		return func(s string) (err error) {
+			*n = 0
+			if s != "" {
+				*n, err = strconv.Atoi(s)
+			}
+			return err
+		}
+	}
+	boolean := func(b *bool) func(string) error {
+		return func(s string) (err error) {
+			*b = false
+			if s != "" {
+				*b, err = strconv.ParseBool(s)
+			}
+			return err
+		}
+	}
+
+	vars := []struct {
+		name  string
+		apply func(string) error
+	}{
+		{"TIMEOUT", duration(&c.Timeout)},
+		{"IDLE_TIMEOUT", duration(&c.IdleTimeout)},
+		{"TCP_KEEPALIVE", duration(&c.TCPKeepAlive)},
+		{"CLOSE_ON_PROTOCOL_ERROR", boolean(&c.CloseOnProtocolError)},
+		{"READ_BUFFER_SIZE", integer(&c.ReadBufferSize)},
+		{"MAX_READ_BUFFER_SIZE", integer(&c.MaxReadBufferSize)},
+		{"WRITE_BUFFER_SIZE", integer(&c.WriteBufferSize)},
+		{"MAX_REPLY_SIZE", integer(&c.MaxReplySize)},
+		{"READONLY", boolean(&c.ReadOnly)},
+		{"ACCEPTORS", integer(&c.Acceptors)},
+		{"EVENT_LOOP", boolean(&c.EventLoop)},
+		{"VERSION", func(s string) error { c.Version = s; return nil }},
+		{"COMMAND_BUDGET", integer(&c.CommandBudget)},
+		{"PROFILER_LABELS", boolean(&c.ProfilerLabels)},
+		{"RECORD_DIR", func(s string) error { c.RecordDir = s; return nil }},
+	}
+
+	var errs ConfigError
+	for _, v := range vars {
+		name := EnvPrefix + v.name
+		if s, ok := lookup(name); ok {
+			if err := v.apply(strings.TrimSpace(s)); err != nil {
+				errs = append(errs, fmt.Sprintf("%s %q is invalid", name, s))
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
 }
@@ -0,0 +1,93 @@
+package redeo
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+
+	DescribeTable("should reject bad settings",
+		func(c *Config, exp string) {
+			Expect(c.Validate()).To(MatchError("redeo: invalid config: " + exp))
+		},
+		Entry("negative timeout", &Config{Timeout: -time.Second}, "Timeout -1s must not be negative"),
+		Entry("negative idle timeout", &Config{IdleTimeout: -time.Second}, "IdleTimeout -1s must not be negative"),
+		Entry("sub-second keep-alive", &Config{TCPKeepAlive: time.Millisecond}, "TCPKeepAlive 1ms must be at least 1s"),
+		Entry("overrides without timeout", &Config{CommandTimeouts: map[string]time.Duration{"blpop": time.Minute}}, "CommandTimeouts require a Timeout"),
+		Entry("negative override", &Config{Timeout: time.Second, CommandTimeouts: map[string]time.Duration{"blpop": -time.Minute}}, `timeout -1m0s of command "blpop" must not be negative`),
+		Entry("negative buffer size", &Config{ReadBufferSize: -1}, "ReadBufferSize -1 must not be negative"),
+		Entry("negative reply size", &Config{MaxReplySize: -1}, "MaxReplySize -1 must not be negative"),
+		Entry("negative acceptors", &Config{Acceptors: -2}, "Acceptors -2 must not be negative"),
+		Entry("bad version", &Config{Version: "7.x"}, `Version "7.x" must have the form major.minor.patch`),
+		Entry("missing record dir", &Config{RecordDir: "/does/not/exist"}, `RecordDir "/does/not/exist" must be a directory`),
+	)
+
+	It("should aggregate errors", func() {
+		err := (&Config{Timeout: -time.Second, CommandBudget: -1, Version: "7"}).Validate()
+		Expect(err).To(Equal(ConfigError{
+			"Timeout -1s must not be negative",
+			"CommandBudget -1 must not be negative",
+			`Version "7" must have the form major.minor.patch`,
+		}))
+		Expect(err).To(MatchError(`redeo: invalid config: Timeout -1s must not be negative; CommandBudget -1 must not be negative; Version "7" must have the form major.minor.patch`))
+	})
+
+	It("should accept valid settings", func() {
+		Expect(new(Config).Validate()).To(Succeed())
+		Expect((&Config{
+			Timeout:         time.Second,
+			TCPKeepAlive:    time.Minute,
+			CommandTimeouts: map[string]time.Duration{"BLPOP": 0, "wait": time.Minute},
+			ReadBufferSize:  1024,
+			Version:         "6.2.14",
+		}).Validate()).To(Succeed())
+	})
+
+	It("should fail to serve with bad settings", func() {
+		srv := NewServer(&Config{Timeout: -time.Second})
+		Expect(srv.ListenAndServe("127.0.0.1:0")).To(MatchError("redeo: invalid config: Timeout -1s must not be negative"))
+		Expect(srv.Serve(nil)).To(MatchError("redeo: invalid config: Timeout -1s must not be negative"))
+	})
+
+	It("should load environment variables", func() {
+		env := map[string]string{
+			"REDEO_TIMEOUT":          "5s",
+			"REDEO_IDLE_TIMEOUT":     "",
+			"REDEO_READ_BUFFER_SIZE": " 4096 ",
+			"REDEO_READONLY":         "true",
+			"REDEO_VERSION":          "6.2.14",
+			"TIMEOUT":                "9s",
+		}
+		lookup := func(name string) (string, bool) {
+			s, ok := env[name]
+			return s, ok
+		}
+
+		c := &Config{Timeout: time.Second, IdleTimeout: time.Minute, WriteBufferSize: 1024}
+		Expect(c.loadEnv(lookup)).To(Succeed())
+		Expect(c).To(Equal(&Config{
+			Timeout:         5 * time.Second,
+			ReadBufferSize:  4096,
+			WriteBufferSize: 1024,
+			ReadOnly:        true,
+			Version:         "6.2.14",
+		}))
+	})
+
+	It("should reject invalid environment variables", func() {
+		env := map[string]string{
+			"REDEO_TIMEOUT":  "30",
+			"REDEO_READONLY": "maybe",
+		}
+		c := new(Config)
+		Expect(c.loadEnv(func(name string) (string, bool) {
+			s, ok := env[name]
+			return s, ok
+		})).To(MatchError(`redeo: invalid config: REDEO_TIMEOUT "30" is invalid; REDEO_READONLY "maybe" is invalid`))
+	})
+
+})
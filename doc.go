@@ -32,5 +32,9 @@ A simple server example with two commands:
 	// Start serving (blocking)
 	srv.Serve(lis)
 
+Commands which extend a server in the style of Redis modules should be named
+<module>.<command>, e.g. JSON.SET, so they do not clash with core commands or
+the commands of other modules.
+
 */
 package redeo
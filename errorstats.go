@@ -0,0 +1,209 @@
+package redeo
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// MaxErrorStatsNames limits the number of distinct command names tracked
+// per kind of error, as names of unknown commands are chosen by clients.
+// Further names are included in the totals only.
+const MaxErrorStatsNames = 128
+
+// maxErrorStatsNameLen truncates long names
+const maxErrorStatsNameLen = 64
+
+// wrongNumberOfArgsPrefix and wrongNumberOfArgsSuffix enclose the command
+// names of replies created by WrongNumberOfArgs
+const (
+	wrongNumberOfArgsPrefix = "ERR wrong number of arguments for '"
+	wrongNumberOfArgsSuffix = "' command"
+)
+
+// errorStats counts unknown commands and arity errors by command name
+type errorStats struct {
+	unknown, arity           map[string]int64
+	unknownTotal, arityTotal int64
+	mu                       sync.Mutex
+}
+
+func newErrorStats() *errorStats {
+	return &errorStats{
+		unknown: make(map[string]int64),
+		arity:   make(map[string]int64),
+	}
+}
+
+func (s *errorStats) UnknownCommand(name string) {
+	s.mu.Lock()
+	s.unknownTotal++
+	inc(s.unknown, name)
+	s.mu.Unlock()
+}
+
+func (s *errorStats) ArityError(name string) {
+	s.mu.Lock()
+	s.arityTotal++
+	inc(s.arity, name)
+	s.mu.Unlock()
+}
+
+func (s *errorStats) Totals() (unknown, arity int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.unknownTotal, s.arityTotal
+}
+
+func (s *errorStats) Counts() (unknown, arity map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return copyCounts(s.unknown), copyCounts(s.arity)
+}
+
+func (s *errorStats) Reset() {
+	s.mu.Lock()
+	s.unknown = make(map[string]int64)
+	s.arity = make(map[string]int64)
+	s.unknownTotal, s.arityTotal = 0, 0
+	s.mu.Unlock()
+}
+
+// writeInfo emits the stats for the Errorstats section
+func (s *errorStats) writeInfo(emit func(string, string)) {
+	unknown, arity := s.Counts()
+	unknownTotal, arityTotal := s.Totals()
+
+	emit("total_unknown_commands", strconv.FormatInt(unknownTotal, 10))
+	emit("total_arity_errors", strconv.FormatInt(arityTotal, 10))
+	for _, name := range sortedNames(unknown) {
+		emit("unknowncmd_"+infoSafeName(name), "count="+strconv.FormatInt(unknown[name], 10))
+	}
+	for _, name := range sortedNames(arity) {
+		emit("arityerr_"+infoSafeName(name), "count="+strconv.FormatInt(arity[name], 10))
+	}
+}
+
+func inc(counts map[string]int64, name string) {
+	if len(name) > maxErrorStatsNameLen {
+		name = name[:maxErrorStatsNameLen]
+	}
+	if _, ok := counts[name]; ok || len(counts) < MaxErrorStatsNames {
+		counts[name]++
+	}
+}
+
+func copyCounts(counts map[string]int64) map[string]int64 {
+	res := make(map[string]int64, len(counts))
+	for name, n := range counts {
+		res[name] = n
+	}
+	return res
+}
+
+func sortedNames(counts map[string]int64) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// infoSafeName replaces characters which would break the INFO format
+func infoSafeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == ':' || r == '=' || r == ',' || r > '~' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// --------------------------------------------------------------------
+
+// arityWriter notes arity errors replied by handlers, including the
+// name of the failed (sub-)command, e.g. "config|rewrite". Errors are
+// arity errors if they were created by ErrWrongNumberOfArgs or if their
+// message is exactly one created by WrongNumberOfArgs.
+type arityWriter struct {
+	resp.ResponseWriter
+	failed string
+}
+
+func (w *arityWriter) reset(wr resp.ResponseWriter) {
+	w.ResponseWriter = wr
+	w.failed = ""
+}
+
+func (w *arityWriter) AppendError(msg string) {
+	if strings.HasPrefix(msg, wrongNumberOfArgsPrefix) && strings.HasSuffix(msg, wrongNumberOfArgsSuffix) {
+		name := msg[len(wrongNumberOfArgsPrefix) : len(msg)-len(wrongNumberOfArgsSuffix)]
+		if !strings.Contains(name, "'") {
+			w.fail(name)
+		}
+	}
+	w.ResponseWriter.AppendError(msg)
+}
+
+// Append notes arity errors appended as values, e.g. by WrapperFunc
+func (w *arityWriter) Append(v interface{}) error {
+	switch err := v.(type) {
+	case *wrongNumberOfArgsError:
+		w.fail(err.cmd)
+	case error:
+		// like resp's Append
+		msg := err.Error()
+		if !strings.HasPrefix(msg, "ERR ") {
+			msg = "ERR " + msg
+		}
+		w.AppendError(msg)
+		return nil
+	}
+	return w.ResponseWriter.Append(v)
+}
+
+func (w *arityWriter) fail(name string) {
+	w.failed = resp.CanonicalName(strings.Replace(name, " ", "|", -1))
+}
+
+func (w *arityWriter) AppendErrorf(pattern string, args ...interface{}) {
+	w.AppendError(fmt.Sprintf(pattern, args...))
+}
+
+// The extended methods are forwarded, so handlers may type-assert
+// resp.ExtendedResponseWriter.
+
+func (w *arityWriter) AppendNilArray()   { resp.AppendNilArray(w.ResponseWriter) }
+func (w *arityWriter) AppendEmptyArray() { resp.AppendEmptyArray(w.ResponseWriter) }
+func (w *arityWriter) AppendEmptyBulk()  { resp.AppendEmptyBulk(w.ResponseWriter) }
+
+func (w *arityWriter) AppendFloat(f float64)   { resp.AppendFloat(w.ResponseWriter, f) }
+func (w *arityWriter) AppendBool(b bool)       { resp.AppendBool(w.ResponseWriter, b) }
+func (w *arityWriter) AppendBigInt(n *big.Int) { resp.AppendBigInt(w.ResponseWriter, n) }
+
+func (w *arityWriter) AppendVerbatimString(format, s string) {
+	resp.AppendVerbatimString(w.ResponseWriter, format, s)
+}
+
+func (w *arityWriter) AppendBulkStream() io.WriteCloser {
+	return resp.AppendBulkStream(w.ResponseWriter)
+}
+
+func (w *arityWriter) AppendMapLen(n int)  { resp.AppendMapLen(w.ResponseWriter, n) }
+func (w *arityWriter) AppendPushLen(n int) { resp.AppendPushLen(w.ResponseWriter, n) }
+
+func (w *arityWriter) AppendAttributes(attrs map[string]interface{}) error {
+	return resp.AppendAttributes(w.ResponseWriter, attrs)
+}
+
+func (w *arityWriter) Protocol() int     { return resp.Protocol(w.ResponseWriter) }
+func (w *arityWriter) SetProtocol(v int) { resp.SetProtocol(w.ResponseWriter, v) }
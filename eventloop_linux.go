@@ -0,0 +1,170 @@
+//go:build linux && go1.9
+// +build linux,go1.9
+
+package redeo
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+var errClientRemoved = errors.New("redeo: client removed from event loop")
+
+// poller multiplexes idle client connections using epoll. Clients are
+// registered one-shot, so each readiness event is delivered once and
+// dispatched to a new goroutine, which re-arms the client once it is idle.
+type poller struct {
+	fd       int
+	wakeR    int
+	wakeW    int
+	dispatch func(*Client)
+
+	clients map[int]*Client
+	mu      sync.Mutex
+
+	closeOnce sync.Once
+}
+
+func newPoller(dispatch func(*Client)) (*poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(pipe[0])}
+	if err := syscall.EpollCtl(fd, syscall.EPOLL_CTL_ADD, pipe[0], &ev); err != nil {
+		_ = syscall.Close(fd)
+		_ = syscall.Close(pipe[0])
+		_ = syscall.Close(pipe[1])
+		return nil, err
+	}
+
+	p := &poller{
+		fd:       fd,
+		wakeR:    pipe[0],
+		wakeW:    pipe[1],
+		dispatch: dispatch,
+		clients:  make(map[int]*Client),
+	}
+	go p.loop()
+	return p, nil
+}
+
+// add registers a client, returns false if the client's
+// connection cannot be polled
+func (p *poller) add(c *Client) bool {
+	sc, ok := c.cn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	fd := -1
+	if err := rc.Control(func(s uintptr) { fd = int(s) }); err != nil || fd < 0 {
+		return false
+	}
+
+	p.mu.Lock()
+	p.clients[fd] = c
+	p.mu.Unlock()
+
+	c.poll = p
+	c.fd = fd
+	return true
+}
+
+// park arms the client, it is dispatched as soon as it becomes readable
+func (p *poller) park(c *Client) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the client may have been removed (and its fd
+	// reused) while it was served
+	if p.clients[c.fd] != c {
+		return errClientRemoved
+	}
+
+	atomic.StoreInt32(&c.parked, 1)
+
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(c.fd)}
+	err := syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, c.fd, &ev)
+	if err == syscall.ENOENT {
+		err = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, c.fd, &ev)
+	}
+	if err != nil {
+		atomic.StoreInt32(&c.parked, 0)
+	}
+	return err
+}
+
+// wake dispatches a parked client immediately
+func (p *poller) wake(c *Client) {
+	if atomic.CompareAndSwapInt32(&c.parked, 1, 0) {
+		go p.dispatch(c)
+	}
+}
+
+// remove deregisters the client, must be called before
+// the connection is closed
+func (p *poller) remove(c *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the fd may have been reused already, if the
+	// connection was closed elsewhere
+	if p.clients[c.fd] == c {
+		delete(p.clients, c.fd)
+		_ = syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	}
+}
+
+// close stops the event loop
+func (p *poller) close() {
+	p.closeOnce.Do(func() {
+		_, _ = syscall.Write(p.wakeW, []byte{0})
+	})
+}
+
+func (p *poller) loop() {
+	defer func() {
+		_ = syscall.Close(p.fd)
+		_ = syscall.Close(p.wakeR)
+		_ = syscall.Close(p.wakeW)
+	}()
+
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		n, err := syscall.EpollWait(p.fd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return
+		}
+
+		for _, ev := range events[:n] {
+			fd := int(ev.Fd)
+			if fd == p.wakeR {
+				return
+			}
+
+			p.mu.Lock()
+			c := p.clients[fd]
+			p.mu.Unlock()
+
+			if c != nil {
+				p.wake(c)
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux || !go1.9
+// +build !linux !go1.9
+
+package redeo
+
+import "errors"
+
+// poller is only supported on Linux
+type poller struct{}
+
+func newPoller(_ func(*Client)) (*poller, error) {
+	return nil, errors.New("redeo: event loop is not supported on this platform")
+}
+
+func (p *poller) add(_ *Client) bool   { return false }
+func (p *poller) park(_ *Client) error { return nil }
+func (p *poller) wake(_ *Client)       {}
+func (p *poller) remove(_ *Client)     {}
+func (p *poller) close()               {}
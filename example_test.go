@@ -1,6 +1,7 @@
 package redeo_test
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
 
@@ -38,6 +39,29 @@ func ExampleServer() {
 	srv.Serve(lis)
 }
 
+func ExampleServer_ServeTLS() {
+	srv := redeo.NewServer(nil)
+	srv.Handle("ping", redeo.Ping())
+
+	// Reload certificates on change
+	reloader, err := redeo.NewCertReloader("cert.pem", "key.pem")
+	if err != nil {
+		panic(err)
+	}
+
+	// Open a new listener
+	lis, err := net.Listen("tcp", ":9736")
+	if err != nil {
+		panic(err)
+	}
+	defer lis.Close()
+
+	// Start serving (blocking)
+	srv.ServeTLS(lis, &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+	})
+}
+
 func ExampleClient() {
 	srv := redeo.NewServer(nil)
 	srv.HandleFunc("myip", func(w resp.ResponseWriter, cmd *resp.Command) {
@@ -60,6 +84,22 @@ func ExampleInfo() {
 	srv.Handle("info", redeo.Info(srv))
 }
 
+func ExampleReset() {
+	broker := redeo.NewPubSubBroker()
+
+	srv := redeo.NewServer(nil)
+	srv.Handle("subscribe", broker.Subscribe())
+	srv.Handle("reset", redeo.Reset(broker))
+	srv.OnDisconnect(broker)
+}
+
+func ExampleClientKill() {
+	srv := redeo.NewServer(nil)
+	srv.Handle("client", redeo.SubCommands{
+		"kill": redeo.ClientKill(srv),
+	})
+}
+
 func ExampleCommandDescriptions() {
 	srv := redeo.NewServer(nil)
 	srv.Handle("command", redeo.CommandDescriptions{
@@ -84,6 +124,7 @@ func ExamplePubSubBroker() {
 	srv := redeo.NewServer(nil)
 	srv.Handle("publish", broker.Publish())
 	srv.Handle("subscribe", broker.Subscribe())
+	srv.OnDisconnect(broker)
 }
 
 func ExampleHandlerFunc() {
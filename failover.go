@@ -0,0 +1,443 @@
+package redeo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// FailoverNotify selects how clients are notified about role transitions
+type FailoverNotify int
+
+const (
+	// NotifyNone keeps clients connected, writes sent to replicas are
+	// rejected with READONLY errors
+	NotifyNone FailoverNotify = iota
+
+	// NotifyMoved rejects writes sent to replicas with MOVED errors,
+	// which redirect clients to the master. The slot is derived from
+	// the first argument, see KeySlot.
+	NotifyMoved
+
+	// NotifyDisconnect disconnects all clients on role transitions, so
+	// they re-discover the master
+	NotifyDisconnect
+)
+
+// FailoverOptions configure a Failover
+type FailoverOptions struct {
+	// Replica configures the replication link while the server is a
+	// replica.
+	// Default: nil (default options)
+	Replica *ReplicaOptions
+
+	// Master serves downstream replicas while the server is a master,
+	// its replicas are disconnected on demotion.
+	// Default: nil (replicas are not served)
+	Master *Master
+
+	// Notify selects how clients are notified about transitions.
+	// Default: NotifyNone
+	Notify FailoverNotify
+
+	// OnTransition is called after each transition with the new role,
+	// i.e. RoleMaster or RoleSlave.
+	// Default: nil
+	OnTransition func(role string)
+
+	// OnFailover performs coordinated failovers requested via FAILOVER
+	// or CLUSTER FAILOVER. It is called asynchronously, the failover is
+	// reported as in progress until it returns. The context is cancelled
+	// by FAILOVER ABORT or when the requested timeout expires.
+	// Default: nil (failovers are rejected)
+	OnFailover func(ctx context.Context, req *FailoverRequest) error
+}
+
+// FailoverRequest describes a failover requested via FAILOVER or
+// CLUSTER FAILOVER
+type FailoverRequest struct {
+	// Target is the address of the replica to hand over to, as given
+	// by FAILOVER TO, empty if not specified. For CLUSTER FAILOVER, it
+	// is the address of the current master.
+	Target string
+
+	// Force is set by the FORCE option
+	Force bool
+
+	// Takeover is set by CLUSTER FAILOVER TAKEOVER
+	Takeover bool
+
+	// Timeout is the FAILOVER TIMEOUT, zero if not specified
+	Timeout time.Duration
+}
+
+var (
+	errFailoverUnsupported = errors.New("ERR FAILOVER is not supported")
+	errFailoverInProgress  = errors.New("ERR FAILOVER already in progress.")
+)
+
+// Failover drives the role transitions of a server, e.g. on behalf
+// of HA controllers. Transitions atomically flip read-only mode,
+// start or stop replication and update the state reported by ROLE
+// and INFO.
+type Failover struct {
+	srv *Server
+	opt FailoverOptions
+	rpo ReplicaOptions
+
+	mu      sync.Mutex
+	replica *Replica
+	cancel  context.CancelFunc
+	abort   *context.CancelFunc // set while a failover is in progress
+}
+
+// NewFailover inits a failover controller for a server, which starts
+// as a master
+func NewFailover(srv *Server, opt *FailoverOptions) *Failover {
+	var o FailoverOptions
+	if opt != nil {
+		o = *opt
+	}
+
+	var rpo ReplicaOptions
+	if o.Replica != nil {
+		rpo = *o.Replica
+	}
+	rpo.norm()
+
+	f := &Failover{srv: srv, opt: o, rpo: rpo}
+	f.setMasterInfo()
+	return f
+}
+
+// Role returns the current role, i.e. RoleMaster or RoleSlave
+func (f *Failover) Role() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.replica != nil {
+		return RoleSlave
+	}
+	return RoleMaster
+}
+
+// Master returns the address of the master, if the server is a replica
+func (f *Failover) Master() (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.replica != nil {
+		return f.replica.Addr(), true
+	}
+	return "", false
+}
+
+// Promote turns the server into a writable master. Replication
+// is stopped, but previously replicated data is retained.
+func (f *Failover) Promote() {
+	f.promote(nil)
+}
+
+// Demote turns the server into a read-only replica of the master at addr.
+// The server reconnects whenever the link is lost, until promoted.
+// Returns false if the server already replicates from addr.
+func (f *Failover) Demote(addr string) bool {
+	return f.demote(addr, nil)
+}
+
+func (f *Failover) promote(self *Client) {
+	f.mu.Lock()
+	if f.replica == nil {
+		f.mu.Unlock()
+		return
+	}
+
+	f.cancel()
+	f.replica, f.cancel = nil, nil
+	f.setMasterInfo()
+	f.srv.setRedirect("")
+	f.srv.SetReadOnly(false)
+	f.mu.Unlock()
+
+	f.notify(self, RoleMaster)
+}
+
+func (f *Failover) demote(addr string, self *Client) bool {
+	f.mu.Lock()
+	if f.replica != nil && f.replica.Addr() == addr {
+		f.mu.Unlock()
+		return false
+	}
+
+	// block writes before the link is established
+	f.srv.SetReadOnly(true)
+	if f.opt.Notify == NotifyMoved {
+		f.srv.setRedirect(addr)
+	}
+
+	if f.cancel != nil {
+		f.cancel()
+	}
+	if m := f.opt.Master; m != nil {
+		m.reset()
+	}
+
+	var ctx context.Context
+	ctx, f.cancel = context.WithCancel(context.Background())
+	f.replica = NewReplica(f.srv, addr, &f.rpo)
+	f.srv.info.SetReplicationProvider(f.replica)
+	go f.run(ctx, f.replica)
+	f.mu.Unlock()
+
+	f.notify(self, RoleSlave)
+	return true
+}
+
+// setMasterInfo reports the master state, must be called with the lock held
+func (f *Failover) setMasterInfo() {
+	f.srv.info.SetReplicationProvider(ReplicationProviderFunc(f.masterInfo))
+}
+
+func (f *Failover) masterInfo() *ReplicationInfo {
+	var ri *ReplicationInfo
+	if m := f.opt.Master; m != nil {
+		ri = m.ReplicationInfo()
+	} else {
+		ri = standalone()
+	}
+
+	ri.FailoverState = "no-failover"
+	if f.InProgress() {
+		ri.FailoverState = "failover-in-progress"
+	}
+	return ri
+}
+
+// InProgress reports whether a failover requested via FAILOVER or
+// CLUSTER FAILOVER is in progress
+func (f *Failover) InProgress() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.abort != nil
+}
+
+// start runs OnFailover in the background
+func (f *Failover) start(req *FailoverRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fn := f.opt.OnFailover
+	if fn == nil {
+		return errFailoverUnsupported
+	} else if f.abort != nil {
+		return errFailoverInProgress
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if req.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), req.Timeout)
+	}
+	abort := &cancel
+	f.abort = abort
+
+	go func() {
+		defer cancel()
+		_ = fn(ctx, req)
+
+		f.mu.Lock()
+		if f.abort == abort {
+			f.abort = nil
+		}
+		f.mu.Unlock()
+	}()
+	return nil
+}
+
+// stop cancels the failover in progress
+func (f *Failover) stop() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.abort == nil {
+		return false
+	}
+	(*f.abort)()
+	f.abort = nil
+	return true
+}
+
+func (f *Failover) notify(self *Client, role string) {
+	if f.opt.Notify == NotifyDisconnect {
+		f.srv.info.clients.Kill(self, func(ci *ClientInfo) bool { return ci.client != self })
+	}
+	if fn := f.opt.OnTransition; fn != nil {
+		fn(role)
+	}
+}
+
+// run replicates until ctx is cancelled
+func (f *Failover) run(ctx context.Context, r *Replica) {
+	for !f.srv.isClosing() {
+		_ = r.Run(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.rpo.RetryInterval):
+		}
+	}
+}
+
+// ReplicaOf returns a REPLICAOF handler, also known as SLAVEOF, which
+// triggers transitions. REPLICAOF host port demotes the server to a
+// replica of the given master, REPLICAOF NO ONE promotes it.
+// https://redis.io/commands/replicaof
+func (f *Failover) ReplicaOf() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		self := GetClient(c.Context())
+		host, port := c.Arg(0).String(), c.Arg(1).String()
+		if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+			f.promote(self)
+			w.AppendOK()
+			return
+		}
+
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			w.AppendError("ERR Invalid master port")
+			return
+		}
+
+		if !f.demote(net.JoinHostPort(host, port), self) {
+			w.AppendInlineString("OK Already connected to specified master")
+			return
+		}
+		w.AppendOK()
+	})
+}
+
+// Failover returns a FAILOVER handler, which starts a coordinated
+// failover on a master via OnFailover. Supports the TO host port, FORCE,
+// ABORT and TIMEOUT options.
+// https://redis.io/commands/failover
+func (f *Failover) Failover() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if f.Role() != RoleMaster {
+			w.AppendError("ERR FAILOVER is not valid when server is a replica.")
+			return
+		}
+
+		req := new(FailoverRequest)
+		abort := false
+		for i := 0; i < c.ArgN(); i++ {
+			switch strings.ToLower(c.Arg(i).String()) {
+			case "to":
+				if i+2 >= c.ArgN() {
+					w.AppendError("ERR syntax error")
+					return
+				}
+				host, port := c.Arg(i+1).String(), c.Arg(i+2).String()
+				if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+					w.AppendError("ERR Invalid target port")
+					return
+				}
+				req.Target = net.JoinHostPort(host, port)
+				i += 2
+			case "force":
+				req.Force = true
+			case "abort":
+				abort = true
+			case "timeout":
+				if i+1 >= c.ArgN() {
+					w.AppendError("ERR syntax error")
+					return
+				}
+				ms, err := strconv.ParseInt(c.Arg(i+1).String(), 10, 64)
+				if err != nil {
+					w.AppendError("ERR value is not an integer or out of range")
+					return
+				} else if ms <= 0 {
+					w.AppendError("ERR FAILOVER timeout must be greater than 0")
+					return
+				}
+				req.Timeout = time.Duration(ms) * time.Millisecond
+				i++
+			default:
+				w.AppendError("ERR syntax error")
+				return
+			}
+		}
+
+		if abort {
+			if c.ArgN() != 1 {
+				w.AppendError("ERR syntax error")
+			} else if !f.stop() {
+				w.AppendError("ERR No failover in progress.")
+			} else {
+				w.AppendOK()
+			}
+			return
+		}
+
+		if req.Force && (req.Target == "" || req.Timeout == 0) {
+			w.AppendError("ERR FAILOVER with force option requires both a timeout and target HOST and IP.")
+			return
+		}
+
+		if err := f.start(req); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendOK()
+	})
+}
+
+// ClusterFailover returns a CLUSTER FAILOVER handler, for use as a
+// sub-command, which starts a manual failover on a replica via OnFailover.
+// Supports the FORCE and TAKEOVER options.
+// https://redis.io/commands/cluster-failover
+func (f *Failover) ClusterFailover() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		req := new(FailoverRequest)
+		switch c.ArgN() {
+		case 0:
+		case 1:
+			switch strings.ToLower(c.Arg(0).String()) {
+			case "force":
+				req.Force = true
+			case "takeover":
+				req.Takeover = true
+			default:
+				w.AppendError("ERR syntax error")
+				return
+			}
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+
+		addr, ok := f.Master()
+		if !ok {
+			w.AppendError("ERR You should send CLUSTER FAILOVER to a replica")
+			return
+		}
+		req.Target = addr
+
+		if err := f.start(req); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendOK()
+	})
+}
@@ -0,0 +1,194 @@
+package redeo
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Failover", func() {
+	var srv *Server
+	var lis net.Listener
+	var roles []string
+
+	var setup = func(opt *FailoverOptions) *Failover {
+		opt.Replica = &ReplicaOptions{RetryInterval: 10 * time.Millisecond}
+		opt.OnTransition = func(role string) { roles = append(roles, role) }
+		return NewFailover(srv, opt)
+	}
+
+	var set = func(cn net.Conn, rd *bufio.Reader) (string, error) {
+		w := resp.NewRequestWriter(cn)
+		w.WriteCmdString("SET", "key", "value")
+		if err := w.Flush(); err != nil {
+			return "", err
+		}
+		return rd.ReadString('\n')
+	}
+
+	BeforeEach(func() {
+		roles = nil
+		srv = NewServer(nil)
+		srv.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendOK()
+		})
+
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go srv.Serve(lis)
+	})
+
+	AfterEach(func() {
+		lis.Close()
+	})
+
+	It("should transition roles", func() {
+		master := NewMaster(srv, SnapshotterFunc(func(w io.Writer) error { return nil }), nil)
+		defer master.Close()
+
+		subject := setup(&FailoverOptions{Master: master})
+		Expect(subject.Role()).To(Equal(RoleMaster))
+		Expect(srv.Info().Replication().Role).To(Equal(RoleMaster))
+		replID := master.ReplID()
+
+		Expect(subject.Demote("127.0.0.1:1")).To(BeTrue())
+		Expect(subject.Demote("127.0.0.1:1")).To(BeFalse())
+		Expect(subject.Role()).To(Equal(RoleSlave))
+		addr, ok := subject.Master()
+		Expect(ok).To(BeTrue())
+		Expect(addr).To(Equal("127.0.0.1:1"))
+		Expect(srv.ReadOnly()).To(BeTrue())
+		Expect(srv.Info().Replication().Role).To(Equal(RoleSlave))
+		Expect(srv.Info().Replication().MasterPort).To(Equal(1))
+		Expect(master.ReplID()).NotTo(Equal(replID))
+
+		subject.Promote()
+		subject.Promote()
+		Expect(subject.Role()).To(Equal(RoleMaster))
+		Expect(srv.ReadOnly()).To(BeFalse())
+		Expect(srv.Info().Replication().Role).To(Equal(RoleMaster))
+		Expect(roles).To(Equal([]string{RoleSlave, RoleMaster}))
+	})
+
+	It("should redirect clients", func() {
+		subject := setup(&FailoverOptions{Notify: NotifyMoved})
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+		rd := bufio.NewReader(cn)
+
+		subject.Demote("127.0.0.1:1")
+		Expect(set(cn, rd)).To(Equal("-MOVED 12539 127.0.0.1:1\r\n"))
+
+		subject.Promote()
+		Expect(set(cn, rd)).To(Equal("+OK\r\n"))
+	})
+
+	It("should disconnect clients", func() {
+		subject := setup(&FailoverOptions{Notify: NotifyDisconnect})
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+		rd := bufio.NewReader(cn)
+
+		Expect(set(cn, rd)).To(Equal("+OK\r\n"))
+		Eventually(srv.Info().NumClients).Should(Equal(1))
+
+		subject.Demote("127.0.0.1:1")
+		_, err = rd.ReadString('\n')
+		Expect(err).To(Equal(io.EOF))
+		subject.Promote()
+	})
+
+	It("should serve FAILOVER", func() {
+		reqs := make(chan *FailoverRequest, 1)
+		release := make(chan struct{})
+		subject := setup(&FailoverOptions{OnFailover: func(ctx context.Context, req *FailoverRequest) error {
+			reqs <- req
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return ctx.Err()
+		}})
+
+		var call = func(args ...string) interface{} {
+			cmd := resp.NewCommand("FAILOVER")
+			for _, arg := range args {
+				cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+			}
+			w := redeotest.NewRecorder()
+			subject.Failover().ServeRedeo(w, cmd)
+			v, _ := w.Response()
+			return v
+		}
+
+		Expect(call("ABORT")).To(MatchError("ERR No failover in progress."))
+		Expect(call("FORCE", "TIMEOUT", "10")).To(MatchError("ERR FAILOVER with force option requires both a timeout and target HOST and IP."))
+		Expect(call("TIMEOUT", "0")).To(MatchError("ERR FAILOVER timeout must be greater than 0"))
+		Expect(call("TO", "host")).To(MatchError("ERR syntax error"))
+		Expect(call("TO", "host", "x")).To(MatchError("ERR Invalid target port"))
+		Expect(call("ABORT", "FORCE")).To(MatchError("ERR syntax error"))
+		Expect(subject.InProgress()).To(BeFalse())
+		Expect(srv.Info().Replication().FailoverState).To(Equal("no-failover"))
+
+		Expect(call("TO", "10.0.0.1", "6380", "FORCE", "TIMEOUT", "5000")).To(Equal("OK"))
+		Expect(<-reqs).To(Equal(&FailoverRequest{Target: "10.0.0.1:6380", Force: true, Timeout: 5 * time.Second}))
+		Expect(subject.InProgress()).To(BeTrue())
+		Expect(srv.Info().String()).To(ContainSubstring("master_failover_state:failover-in-progress"))
+		Expect(call()).To(MatchError("ERR FAILOVER already in progress."))
+
+		Expect(call("ABORT")).To(Equal("OK"))
+		Expect(subject.InProgress()).To(BeFalse())
+
+		Expect(call()).To(Equal("OK"))
+		Expect(<-reqs).To(Equal(&FailoverRequest{}))
+		close(release)
+		Eventually(subject.InProgress).Should(BeFalse())
+
+		subject.Demote("127.0.0.1:1")
+		defer subject.Promote()
+		Expect(call()).To(MatchError("ERR FAILOVER is not valid when server is a replica."))
+	})
+
+	It("should serve CLUSTER FAILOVER", func() {
+		reqs := make(chan *FailoverRequest, 1)
+		subject := setup(&FailoverOptions{OnFailover: func(_ context.Context, req *FailoverRequest) error {
+			reqs <- req
+			return nil
+		}})
+		handler := SubCommands{"failover": subject.ClusterFailover()}
+
+		var call = func(args ...string) interface{} {
+			cmd := resp.NewCommand("CLUSTER")
+			for _, arg := range args {
+				cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+			}
+			w := redeotest.NewRecorder()
+			handler.ServeRedeo(w, cmd)
+			v, _ := w.Response()
+			return v
+		}
+
+		Expect(call("failover")).To(MatchError("ERR You should send CLUSTER FAILOVER to a replica"))
+
+		subject.Demote("127.0.0.1:1")
+		defer subject.Promote()
+		Expect(call("failover", "bad")).To(MatchError("ERR syntax error"))
+		Expect(call("failover", "TAKEOVER")).To(Equal("OK"))
+		Expect(<-reqs).To(Equal(&FailoverRequest{Target: "127.0.0.1:1", Takeover: true}))
+
+		Expect(NewFailover(srv, nil).start(&FailoverRequest{})).To(MatchError("ERR FAILOVER is not supported"))
+	})
+
+})
@@ -0,0 +1,139 @@
+package redeo
+
+// maxGlobNesting limits the recursion of '*' wildcards
+const maxGlobNesting = 1000
+
+// GlobMatch reports whether s matches the glob-style pattern, as used by
+// KEYS, SCAN MATCH, PSUBSCRIBE or CLIENT KILL. It is compatible with redis'
+// stringmatchlen:
+//
+//   h?llo     matches hello, hallo and hxllo
+//   h*llo     matches hllo and heeeello
+//   h[ae]llo  matches hello and hallo, but not hillo
+//   h[^e]llo  matches hallo, hbllo, ... but not hello
+//   h[a-b]llo matches hallo and hbllo
+//
+// Use \ to escape special characters. Like in redis, the empty string
+// is only matched by the empty pattern.
+func GlobMatch(pattern, s string) bool {
+	return stringMatch(pattern, s, false)
+}
+
+// GlobMatchFold is like GlobMatch, but ignores ASCII case
+func GlobMatchFold(pattern, s string) bool {
+	return stringMatch(pattern, s, true)
+}
+
+func stringMatch(pattern, s string, nocase bool) bool {
+	skip := false
+	return stringMatchImpl(pattern, s, nocase, &skip, 0)
+}
+
+// stringMatchImpl sets skip once a '*' failed to match the remainder, as
+// longer matches cannot succeed either; this avoids exponential runtime
+func stringMatchImpl(p, s string, nocase bool, skip *bool, nesting int) bool {
+	if nesting > maxGlobNesting {
+		return false
+	}
+
+	for len(p) != 0 && len(s) != 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for len(s) != 0 {
+				if stringMatchImpl(p[1:], s, nocase, skip, nesting+1) {
+					return true
+				}
+				if *skip {
+					return false
+				}
+				s = s[1:]
+			}
+			*skip = true
+			return false
+		case '?':
+			s = s[1:]
+		case '[':
+			p = p[1:]
+			not := len(p) != 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+
+			match := false
+			for len(p) != 0 {
+				if p[0] == '\\' && len(p) >= 2 {
+					p = p[1:]
+					if p[0] == s[0] {
+						match = true
+					}
+				} else if p[0] == ']' {
+					break
+				} else if len(p) >= 3 && p[1] == '-' {
+					start, end, c := p[0], p[2], s[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					p = p[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				} else if equalByte(p[0], s[0], nocase) {
+					match = true
+				}
+				p = p[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(p) >= 2 {
+				p = p[1:]
+			}
+			fallthrough
+		default:
+			if !equalByte(p[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+
+		// unterminated classes consume the whole pattern
+		if len(p) != 0 {
+			p = p[1:]
+		}
+		if len(s) == 0 {
+			for len(p) != 0 && p[0] == '*' {
+				p = p[1:]
+			}
+			break
+		}
+	}
+	return len(p) == 0 && len(s) == 0
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
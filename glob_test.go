@@ -0,0 +1,180 @@
+package redeo
+
+import (
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GlobMatch", func() {
+
+	DescribeTable("should match",
+		func(pattern, s string, exp bool) {
+			Expect(GlobMatch(pattern, s)).To(Equal(exp))
+		},
+		Entry("literal", "hello", "hello", true),
+		Entry("literal mismatch", "hello", "hellO", false),
+		Entry("question", "h?llo", "hallo", true),
+		Entry("question requires a char", "h?llo", "hllo", false),
+		Entry("star", "h*llo", "heeeello", true),
+		Entry("empty star", "h*llo", "hllo", true),
+		Entry("trailing stars", "h**", "h", true),
+		Entry("class", "h[ae]llo", "hello", true),
+		Entry("class mismatch", "h[ae]llo", "hillo", false),
+		Entry("negated class", "h[^e]llo", "hallo", true),
+		Entry("negated class mismatch", "h[^e]llo", "hello", false),
+		Entry("range", "h[a-b]llo", "hbllo", true),
+		Entry("reversed range", "h[b-a]llo", "hallo", true),
+		Entry("escaped class member", `[\]]`, "]", true),
+		Entry("empty class", "[]", "a", false),
+		Entry("negated empty class", "[^]", "a", true),
+		Entry("unterminated class", "[ab", "a", true),
+		Entry("escape", `h\*llo`, "h*llo", true),
+		Entry("escape mismatch", `h\*llo`, "hello", false),
+		Entry("trailing backslash", `h\`, `h\`, true),
+		Entry("empty", "", "", true),
+		Entry("star on empty", "*", "", false),
+	)
+
+	It("should ignore case", func() {
+		Expect(GlobMatchFold("H[A-C]LLO", "hbllo")).To(BeTrue())
+		Expect(GlobMatchFold("h?LLo", "HeLlO")).To(BeTrue())
+		Expect(GlobMatch("h?LLo", "HeLlO")).To(BeFalse())
+		Expect(GlobMatchFold(`[\A]`, "a")).To(BeFalse())
+	})
+
+	It("should not backtrack exponentially", func() {
+		pattern := strings.Repeat("a*", 30) + "b"
+		s := strings.Repeat("a", 50)
+
+		start := time.Now()
+		Expect(GlobMatch(pattern, s)).To(BeFalse())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+		Expect(GlobMatch(strings.Repeat("*", 2000)+"a", "a")).To(BeTrue())
+		Expect(GlobMatch(strings.Repeat("a*", 1100), strings.Repeat("a", 1100))).To(BeFalse())
+	})
+
+	It("should match the reference semantics", func() {
+		rnd := rand.New(rand.NewSource(1))
+		gen := func(alphabet string, max int) string {
+			b := make([]byte, rnd.Intn(max+1))
+			for i := range b {
+				b[i] = alphabet[rnd.Intn(len(alphabet))]
+			}
+			return string(b)
+		}
+
+		for i := 0; i < 20000; i++ {
+			pattern := gen(`ab?*[]^-\A`, 8)
+			s := gen(`abAB-^]\`, 6)
+			nocase := i%2 == 1
+
+			exp := globRef(pattern, s, nocase)
+			Expect(stringMatch(pattern, s, nocase)).To(Equal(exp), "pattern %q, string %q, nocase %v", pattern, s, nocase)
+		}
+	})
+
+})
+
+// globRef is a reference implementation of redis' stringmatchlen, which
+// translates patterns into regular expressions
+func globRef(pattern, s string, nocase bool) bool {
+	if s == "" {
+		return pattern == ""
+	}
+
+	// every pattern position is translated into an explicit set
+	// of bytes, U+10FFFF keeps empty sets valid
+	var re strings.Builder
+	class := func(set *[256]bool, not bool) {
+		re.WriteString(`[`)
+		for c, ok := range set {
+			if ok != not {
+				re.WriteString(`\x{` + strconv.FormatInt(int64(c), 16) + `}`)
+			}
+		}
+		re.WriteString(`\x{10FFFF}]`)
+	}
+	literal := func(b byte) *[256]bool {
+		var set [256]bool
+		for c := range set {
+			set[c] = equalByte(byte(c), b, nocase)
+		}
+		return &set
+	}
+
+	re.WriteString(`(?s)^`)
+	for p := pattern; len(p) != 0; {
+		switch p[0] {
+		case '*':
+			re.WriteString(`.*`)
+			p = p[1:]
+		case '?':
+			re.WriteString(`.`)
+			p = p[1:]
+		case '[':
+			var set [256]bool
+			p = p[1:]
+			not := len(p) != 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			for len(p) != 0 && p[0] != ']' {
+				if p[0] == '\\' && len(p) >= 2 {
+					set[p[1]] = true
+					p = p[2:]
+				} else if len(p) >= 3 && p[1] == '-' {
+					lo, hi := p[0], p[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if nocase {
+						lo, hi = toLower(lo), toLower(hi)
+					}
+					for c := range set {
+						x := byte(c)
+						if nocase {
+							x = toLower(x)
+						}
+						if x >= lo && x <= hi {
+							set[c] = true
+						}
+					}
+					p = p[3:]
+				} else {
+					for c, ok := range literal(p[0]) {
+						set[c] = set[c] || ok
+					}
+					p = p[1:]
+				}
+			}
+			if len(p) != 0 {
+				p = p[1:]
+			}
+			class(&set, not)
+		default:
+			if p[0] == '\\' && len(p) >= 2 {
+				p = p[1:]
+			}
+			class(literal(p[0]), false)
+			p = p[1:]
+		}
+	}
+	re.WriteString(`$`)
+
+	return regexp.MustCompile(re.String()).MatchString(s)
+}
+
+func BenchmarkGlobMatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		GlobMatch("user:*:sess[0-9]", "user:123456:sess7")
+	}
+}
@@ -0,0 +1,173 @@
+package redeo
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// ErrorKind classifies the errors reported via OnError
+type ErrorKind int
+
+const (
+	// ErrorProtocol is reported for malformed requests
+	ErrorProtocol ErrorKind = iota
+	// ErrorRead is reported when requests cannot be read, e.g. on timeouts
+	ErrorRead
+	// ErrorWrite is reported when replies cannot be written
+	ErrorWrite
+	// ErrorHandshake is reported when TLS handshakes fail
+	ErrorHandshake
+	// ErrorRecord is reported when sessions cannot be recorded, see
+	// Config.RecordDir
+	ErrorRecord
+	// ErrorReply is reported when replies exceed Config.MaxReplySize
+	ErrorReply
+	// ErrorJournal is reported when commands cannot be appended to a
+	// Journal
+	ErrorJournal
+)
+
+// String returns the name of the kind
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorProtocol:
+		return "protocol"
+	case ErrorRead:
+		return "read"
+	case ErrorWrite:
+		return "write"
+	case ErrorHandshake:
+		return "handshake"
+	case ErrorRecord:
+		return "record"
+	case ErrorReply:
+		return "reply"
+	case ErrorJournal:
+		return "journal"
+	}
+	return "unknown"
+}
+
+// ErrorContext describes an error which occurred while serving a client
+type ErrorContext struct {
+	// Kind classifies the error
+	Kind ErrorKind
+
+	// Err is the error
+	Err error
+
+	// Client is the affected client, e.g. to report its RemoteAddr or
+	// LastCmd. It must not be retained after the callback returns.
+	Client *Client
+}
+
+// CommandEvent describes the execution of a command, as observed by
+// BeforeCommand and AfterCommand hooks
+type CommandEvent struct {
+	// Client is the client connection
+	Client *Client
+
+	// Command is the command being executed, nil for stream commands.
+	// It must neither be modified nor retained after the hook returns.
+	Command *resp.Command
+
+	// Name is the lower-case command name
+	Name string
+
+	// Duration is the execution time of the handler, including
+	// the time to write buffered replies (AfterCommand only)
+	Duration time.Duration
+
+	// Err is the error which occurred while writing the reply
+	// (AfterCommand only)
+	Err error
+
+	start time.Time
+}
+
+// BeforeCommand registers a hook which is called before each command
+// is executed. Unlike handler wrappers, hooks cannot alter replies,
+// which makes them a cheap and safe option for pure observability
+// integrations. Hooks are called synchronously and should return fast.
+// Unknown and rejected commands are not reported.
+func (srv *Server) BeforeCommand(fn func(*CommandEvent)) {
+	srv.mu.Lock()
+	srv.before = append(srv.before[:len(srv.before):len(srv.before)], fn)
+	srv.mu.Unlock()
+}
+
+// AfterCommand registers a hook which is called after each command has
+// been executed, see BeforeCommand.
+func (srv *Server) AfterCommand(fn func(*CommandEvent)) {
+	srv.mu.Lock()
+	srv.after = append(srv.after[:len(srv.after):len(srv.after)], fn)
+	srv.mu.Unlock()
+}
+
+func beginCommand(c *Client, cmd *resp.Command, name string, before []func(*CommandEvent)) *CommandEvent {
+	ev := &CommandEvent{Client: c, Command: cmd, Name: name}
+	for _, fn := range before {
+		fn(ev)
+	}
+	ev.start = c.clock.Now()
+	return ev
+}
+
+func endCommand(ev *CommandEvent, err error, after []func(*CommandEvent)) {
+	ev.Duration = ev.Client.clock.Now().Sub(ev.start)
+	ev.Err = err
+	for _, fn := range after {
+		fn(ev)
+	}
+}
+
+// OnDisconnect registers resetters which are called with every client
+// once it disconnected, before it is released, e.g. a PubSubBroker to drop
+// the client's subscriptions.
+func (srv *Server) OnDisconnect(resetters ...ClientResetter) {
+	srv.mu.Lock()
+	srv.disconnect = append(srv.disconnect[:len(srv.disconnect):len(srv.disconnect)], resetters...)
+	srv.mu.Unlock()
+}
+
+// OnError sets a callback which is invoked whenever a client cannot be
+// served due to protocol, network or TLS errors. Clients disconnecting
+// regularly, i.e. by closing the connection, are not reported.
+// The callback is called synchronously and should return fast.
+func (srv *Server) OnError(fn func(ErrorContext)) {
+	srv.mu.Lock()
+	srv.onError = fn
+	srv.mu.Unlock()
+}
+
+// reportError passes an error to the OnError callback
+func (srv *Server) reportError(kind ErrorKind, c *Client, err error) {
+	if err == io.EOF {
+		return
+	}
+
+	srv.mu.RLock()
+	fn := srv.onError
+	srv.mu.RUnlock()
+
+	if fn != nil {
+		fn(ErrorContext{Kind: kind, Err: err, Client: c})
+	}
+}
+
+// errorKind classifies errors returned while serving pipelines
+func errorKind(err error) ErrorKind {
+	if resp.IsProtocolError(err) {
+		return ErrorProtocol
+	}
+	if err == errPartialReply {
+		return ErrorReply
+	}
+	if oe, ok := err.(*net.OpError); ok && oe.Op == "write" {
+		return ErrorWrite
+	}
+	return ErrorRead
+}
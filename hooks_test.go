@@ -0,0 +1,177 @@
+package redeo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Command hooks", func() {
+	var subject *Server
+	var lis net.Listener
+	var events []CommandEvent
+	var mu sync.Mutex
+
+	var recorded = func() []CommandEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]CommandEvent(nil), events...)
+	}
+
+	BeforeEach(func() {
+		events = nil
+		subject = NewServer(nil)
+		subject.HandleFunc("sleep", func(w resp.ResponseWriter, c *resp.Command) {
+			time.Sleep(5 * time.Millisecond)
+			w.AppendOK()
+		})
+		subject.HandleStreamFunc("stream", func(w resp.ResponseWriter, c *resp.CommandStream) {
+			w.AppendOK()
+		})
+
+		subject.BeforeCommand(func(ev *CommandEvent) {
+			mu.Lock()
+			events = append(events, CommandEvent{Name: "before:" + ev.Name, Client: ev.Client})
+			if ev.Command != nil {
+				events[len(events)-1].Command = resp.NewCommand(ev.Command.Name)
+			}
+			mu.Unlock()
+		})
+		subject.AfterCommand(func(ev *CommandEvent) {
+			mu.Lock()
+			events = append(events, CommandEvent{Name: "after:" + ev.Name, Duration: ev.Duration, Err: ev.Err})
+			mu.Unlock()
+		})
+
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		lis.Close()
+	})
+
+	It("should observe commands", func() {
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		w := resp.NewRequestWriter(cn)
+		w.WriteCmd("SLEEP")
+		w.WriteCmd("UNKNOWN")
+		w.WriteCmdString("STREAM", "x")
+		Expect(w.Flush()).To(Succeed())
+
+		rd := bufio.NewReader(cn)
+		Expect(rd.ReadString('\n')).To(Equal("+OK\r\n"))
+		Expect(rd.ReadString('\n')).To(Equal("-ERR unknown command 'UNKNOWN'\r\n"))
+		Expect(rd.ReadString('\n')).To(Equal("+OK\r\n"))
+
+		Eventually(recorded).Should(HaveLen(4))
+		ev := recorded()
+		Expect(ev[0].Name).To(Equal("before:sleep"))
+		Expect(ev[0].Client).NotTo(BeNil())
+		Expect(ev[0].Command.Name).To(Equal("SLEEP"))
+		Expect(ev[1].Name).To(Equal("after:sleep"))
+		Expect(ev[1].Duration).To(BeNumerically(">=", 5*time.Millisecond))
+		Expect(ev[1].Err).NotTo(HaveOccurred())
+		Expect(ev[2].Name).To(Equal("before:stream"))
+		Expect(ev[2].Command).To(BeNil())
+		Expect(ev[3].Name).To(Equal("after:stream"))
+	})
+
+})
+
+var _ = Describe("OnError", func() {
+	var subject *Server
+	var lis net.Listener
+	var reported chan ErrorContext
+
+	BeforeEach(func() {
+		reported = make(chan ErrorContext, 10)
+		subject = NewServer(&Config{Timeout: 50 * time.Millisecond})
+		subject.Handle("ping", Ping())
+		subject.OnError(func(ec ErrorContext) { reported <- ec })
+
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go subject.Serve(lis)
+	})
+
+	AfterEach(func() {
+		lis.Close()
+	})
+
+	It("should report protocol errors", func() {
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		_, err = cn.Write([]byte("*x\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bufio.NewReader(cn).ReadString('\n')).To(Equal("-ERR Protocol error: invalid multibulk length\r\n"))
+
+		var ec ErrorContext
+		Eventually(reported).Should(Receive(&ec))
+		Expect(ec.Kind).To(Equal(ErrorProtocol))
+		Expect(ec.Err).To(MatchError("Protocol error: invalid multibulk length"))
+		Expect(ec.Client).NotTo(BeNil())
+	})
+
+	It("should report timeouts but not disconnects", func() {
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		var ec ErrorContext
+		Eventually(reported).Should(Receive(&ec))
+		Expect(ec.Kind).To(Equal(ErrorRead))
+		Expect(ec.Err.(net.Error).Timeout()).To(BeTrue())
+
+		cn2, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cn2.Close()).To(Succeed())
+		Consistently(reported, "30ms").ShouldNot(Receive())
+	})
+
+	It("should report oversized replies", func() {
+		_, err := subject.Reload(&Config{Timeout: 50 * time.Millisecond, MaxReplySize: 64})
+		Expect(err).NotTo(HaveOccurred())
+		subject.HandleFunc("big", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulk(bytes.Repeat([]byte{'x'}, 100))
+		})
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		_, err = cn.Write([]byte("BIG\r\nPING\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		rd := bufio.NewReader(cn)
+		Expect(rd.ReadString('\n')).To(Equal("-ERR reply exceeds the maximum reply size\r\n"))
+		Expect(rd.ReadString('\n')).To(Equal("+PONG\r\n"))
+
+		var ec ErrorContext
+		Eventually(reported).Should(Receive(&ec))
+		Expect(ec.Kind).To(Equal(ErrorReply))
+		Expect(ec.Err).To(MatchError("reply to big of at least 108 bytes exceeds MaxReplySize"))
+	})
+
+	It("should classify errors", func() {
+		Expect(errorKind(&net.OpError{Op: "write", Err: errors.New("broken pipe")})).To(Equal(ErrorWrite))
+		Expect(errorKind(&net.OpError{Op: "read", Err: errors.New("reset")})).To(Equal(ErrorRead))
+		Expect(errorKind(errPartialReply)).To(Equal(ErrorReply))
+		Expect(ErrorHandshake.String()).To(Equal("handshake"))
+	})
+
+})
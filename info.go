@@ -6,9 +6,11 @@ import (
 	"fmt"
 	mathrand "math/rand"
 	"os"
+	"runtime"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/johntech-o/redeo/info"
@@ -53,6 +55,9 @@ type ClientInfo struct {
 	// RemoteAddr is the remote address string
 	RemoteAddr string
 
+	// LocalAddr is the local address string, the client is connected to
+	LocalAddr string
+
 	// LastCmd is the last command called by this client
 	LastCmd string
 
@@ -62,35 +67,98 @@ type ClientInfo struct {
 
 	// AccessTime returns the time of the last access
 	AccessTime time.Time
+
+	// Commands is the number of commands processed
+	Commands int64
+
+	// BytesRead is the number of bytes read from the client
+	BytesRead int64
+
+	// BytesWritten is the number of bytes written to the client
+	BytesWritten int64
+
+	// NoEvict is true if the client is protected from eviction
+	NoEvict bool
+
+	// Name is the client name, as set by CLIENT SETNAME
+	Name string
+
+	// LibName and LibVersion identify the client library, as set
+	// by CLIENT SETINFO
+	LibName, LibVersion string
+
+	client *Client
 }
 
-func newClientInfo(c *Client, now time.Time) *ClientInfo {
+func newClientInfo(c *Client) *ClientInfo {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
 	return &ClientInfo{
-		ID:         c.id,
-		RemoteAddr: c.RemoteAddr().String(),
-		CreateTime: now,
-		AccessTime: now,
+		ID:           c.id,
+		RemoteAddr:   c.RemoteAddr().String(),
+		LocalAddr:    c.LocalAddr().String(),
+		LastCmd:      c.lastCmd,
+		CreateTime:   c.created,
+		AccessTime:   c.accessed,
+		Commands:     c.commands,
+		BytesRead:    c.BytesRead(),
+		BytesWritten: c.BytesWritten(),
+		NoEvict:      c.NoEvict(),
+		Name:         c.name,
+		LibName:      c.libName,
+		LibVersion:   c.libVer,
+		client:       c,
 	}
 }
 
 // String generates an info string
 func (i *ClientInfo) String() string {
-	now := time.Now()
-	return fmt.Sprintf("id=%d addr=%s age=%d idle=%d cmd=%s",
+	now := i.now()
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d cmd=%s tot-net-in=%d tot-net-out=%d tot-cmds=%d lib-name=%s lib-ver=%s",
 		i.ID,
 		i.RemoteAddr,
+		i.Name,
 		now.Sub(i.CreateTime)/time.Second,
 		now.Sub(i.AccessTime)/time.Second,
 		i.LastCmd,
+		i.BytesRead,
+		i.BytesWritten,
+		i.Commands,
+		i.LibName,
+		i.LibVersion,
 	)
 }
 
+// now returns the current time of the client's clock
+func (i *ClientInfo) now() time.Time {
+	if i.client != nil {
+		return i.client.clock.Now()
+	}
+	return time.Now()
+}
+
 // --------------------------------------------------------------------
 
+// DefaultVersion is the default Redis compatibility version
+const DefaultVersion = "7.0.0"
+
+// newRunID generates a random 40 character hex ID
+func newRunID() string {
+	b := make([]byte, 20)
+	if _, err := cryptorand.Read(b); err != nil {
+		_, _ = mathrand.Read(b)
+	}
+	return hex.EncodeToString(b)
+}
+
 // ServerInfo contains server stats
 type ServerInfo struct {
 	registry *info.Registry
+	version  *info.StringValue
+	runID    string
 
+	clock     Clock
 	startTime time.Time
 	port      string
 	socket    string
@@ -99,16 +167,35 @@ type ServerInfo struct {
 	clients     clientStats
 	connections *info.IntValue
 	commands    *info.IntValue
+
+	// bytes transferred by disconnected clients
+	netIn, netOut *info.IntValue
+
+	hits, misses     *info.IntValue
+	expired, evicted *info.IntValue
+	errors           *errorStats
+
+	replication atomic.Value
 }
 
 // newServerInfo creates a new server info container
-func newServerInfo() *ServerInfo {
+func newServerInfo(clock Clock) *ServerInfo {
 	info := &ServerInfo{
 		registry:    info.New(),
-		startTime:   time.Now(),
+		version:     info.NewStringValue(DefaultVersion),
+		runID:       newRunID(),
+		clock:       clock,
+		startTime:   clock.Now(),
 		connections: info.NewIntValue(0),
 		commands:    info.NewIntValue(0),
-		clients:     clientStats{stats: make(map[uint64]*ClientInfo)},
+		netIn:       info.NewIntValue(0),
+		netOut:      info.NewIntValue(0),
+		hits:        info.NewIntValue(0),
+		misses:      info.NewIntValue(0),
+		expired:     info.NewIntValue(0),
+		evicted:     info.NewIntValue(0),
+		errors:      newErrorStats(),
+		clients:     clientStats{stats: make(map[uint64]*Client)},
 	}
 	info.initDefaults()
 	return info
@@ -125,6 +212,12 @@ func (i *ServerInfo) Find(name string) *info.Section { return i.registry.FindSec
 // String generates an info string
 func (i *ServerInfo) String() string { return i.registry.String() }
 
+// Sections returns all info sections
+func (i *ServerInfo) Sections() []*info.Section { return i.registry.Sections() }
+
+// Version returns the Redis compatibility version, see Config.Version
+func (i *ServerInfo) Version() string { return i.version.String() }
+
 // NumClients returns the number of connected clients
 func (i *ServerInfo) NumClients() int { return i.clients.Len() }
 
@@ -139,22 +232,160 @@ func (i *ServerInfo) TotalConnections() int64 { return i.connections.Value() }
 // of the server.
 func (i *ServerInfo) TotalCommands() int64 { return i.commands.Value() }
 
-// Apply default info
-func (i *ServerInfo) initDefaults() {
-	runID := make([]byte, 20)
-	if _, err := cryptorand.Read(runID); err != nil {
-		_, _ = mathrand.Read(runID)
+// TotalNetInputBytes returns the total number of bytes read from clients
+func (i *ServerInfo) TotalNetInputBytes() int64 {
+	in, _ := i.clients.Bytes()
+	return i.netIn.Value() + in
+}
+
+// TotalNetOutputBytes returns the total number of bytes written to clients
+func (i *ServerInfo) TotalNetOutputBytes() int64 {
+	_, out := i.clients.Bytes()
+	return i.netOut.Value() + out
+}
+
+// Hit records a successful key lookup. Storage handlers should call
+// Hit and Miss on reads, to report keyspace_hits and keyspace_misses.
+func (i *ServerInfo) Hit() { i.hits.Inc(1) }
+
+// Miss records a lookup of a missing key, see Hit
+func (i *ServerInfo) Miss() { i.misses.Inc(1) }
+
+// KeyspaceHits returns the number of successful key lookups
+func (i *ServerInfo) KeyspaceHits() int64 { return i.hits.Value() }
+
+// KeyspaceMisses returns the number of failed key lookups
+func (i *ServerInfo) KeyspaceMisses() int64 { return i.misses.Value() }
+
+// Expired records the removal of n keys by the expiration of their TTL,
+// reported as expired_keys. See PubSubBroker.PublishKeyspaceEvent to
+// notify subscribers.
+func (i *ServerInfo) Expired(n int64) { i.expired.Inc(n) }
+
+// Evicted records the removal of n keys due to the memory limit,
+// reported as evicted_keys
+func (i *ServerInfo) Evicted(n int64) { i.evicted.Inc(n) }
+
+// ExpiredKeys returns the number of expired keys
+func (i *ServerInfo) ExpiredKeys() int64 { return i.expired.Value() }
+
+// EvictedKeys returns the number of evicted keys
+func (i *ServerInfo) EvictedKeys() int64 { return i.evicted.Value() }
+
+// UnknownCommands returns the number of requests for unknown commands,
+// by lower-cased name. At most MaxErrorStatsNames names are tracked.
+func (i *ServerInfo) UnknownCommands() map[string]int64 {
+	unknown, _ := i.errors.Counts()
+	return unknown
+}
+
+// ArityErrors returns the number of commands rejected with
+// WrongNumberOfArgs, by lower-cased name. Sub-commands are named like
+// "config|rewrite". At most MaxErrorStatsNames names are tracked.
+func (i *ServerInfo) ArityErrors() map[string]int64 {
+	_, arity := i.errors.Counts()
+	return arity
+}
+
+// Uptime returns the time since the start of the server
+func (i *ServerInfo) Uptime() time.Duration { return i.clock.Now().Sub(i.startTime) }
+
+// Clock returns the clock of the server, see Config.Clock
+func (i *ServerInfo) Clock() Clock { return i.clock }
+
+// ServerStats is a snapshot of the server statistics
+type ServerStats struct {
+	// Uptime is the time since the start of the server
+	Uptime time.Duration
+
+	// ConnectedClients is the number of connected clients
+	ConnectedClients int
+
+	// TotalConnections is the number of connections received
+	TotalConnections int64
+
+	// TotalCommands is the number of commands processed
+	TotalCommands int64
+
+	// TotalNetInputBytes is the number of bytes read from clients
+	TotalNetInputBytes int64
+
+	// TotalNetOutputBytes is the number of bytes written to clients
+	TotalNetOutputBytes int64
+
+	// KeyspaceHits is the number of successful key lookups
+	KeyspaceHits int64
+
+	// KeyspaceMisses is the number of failed key lookups
+	KeyspaceMisses int64
+
+	// ExpiredKeys is the number of expired keys
+	ExpiredKeys int64
+
+	// EvictedKeys is the number of evicted keys
+	EvictedKeys int64
+
+	// UnknownCommands is the number of requests for unknown commands
+	UnknownCommands int64
+
+	// ArityErrors is the number of commands rejected for a wrong number
+	// of arguments
+	ArityErrors int64
+}
+
+// Snapshot returns the current server statistics, e.g. for exporting
+// them to external telemetry systems.
+func (i *ServerInfo) Snapshot() *ServerStats {
+	in, out := i.clients.Bytes()
+	unknown, arity := i.errors.Totals()
+	return &ServerStats{
+		Uptime:              i.Uptime(),
+		ConnectedClients:    i.NumClients(),
+		TotalConnections:    i.connections.Value(),
+		TotalCommands:       i.commands.Value(),
+		TotalNetInputBytes:  i.netIn.Value() + in,
+		TotalNetOutputBytes: i.netOut.Value() + out,
+		KeyspaceHits:        i.hits.Value(),
+		KeyspaceMisses:      i.misses.Value(),
+		ExpiredKeys:         i.expired.Value(),
+		EvictedKeys:         i.evicted.Value(),
+		UnknownCommands:     unknown,
+		ArityErrors:         arity,
 	}
+}
+
+// Reset resets the statistics, like CONFIG RESETSTAT. This includes all
+// values registered in the Stats section which implement info.Resetter,
+// e.g. info.IntValue counters, and the error stats.
+func (i *ServerInfo) Reset() {
+	i.Fetch("Stats").Reset()
+	i.errors.Reset()
+
+	// offset the bytes transferred by connected clients
+	in, out := i.clients.Bytes()
+	i.netIn.Set(-in)
+	i.netOut.Set(-out)
+}
 
+// RunID returns the run ID, a random 40 character hex string which
+// identifies the server for its lifetime
+func (i *ServerInfo) RunID() string { return i.runID }
+
+// Apply default info
+func (i *ServerInfo) initDefaults() {
 	server := i.Fetch("Server")
+	server.Register("redis_version", i.version)
+	server.Register("redis_mode", info.StaticString("standalone"))
+	server.Register("os", info.StaticString(runtime.GOOS+" "+runtime.GOARCH))
+	server.Register("arch_bits", info.StaticInt(strconv.IntSize))
 	server.Register("process_id", info.StaticInt(int64(os.Getpid())))
-	server.Register("run_id", info.StaticString(hex.EncodeToString(runID)))
+	server.Register("run_id", info.StaticString(i.runID))
 	server.Register("uptime_in_seconds", info.Callback(func() string {
-		d := time.Since(i.startTime) / time.Second
+		d := i.Uptime() / time.Second
 		return strconv.FormatInt(int64(d), 10)
 	}))
 	server.Register("uptime_in_days", info.Callback(func() string {
-		d := time.Since(i.startTime) / time.Hour / 24
+		d := i.Uptime() / time.Hour / 24
 		return strconv.FormatInt(int64(d), 10)
 	}))
 
@@ -166,6 +397,19 @@ func (i *ServerInfo) initDefaults() {
 	stats := i.Fetch("Stats")
 	stats.Register("total_connections_received", i.connections)
 	stats.Register("total_commands_processed", i.commands)
+	stats.Register("total_net_input_bytes", info.Callback(func() string {
+		return strconv.FormatInt(i.TotalNetInputBytes(), 10)
+	}))
+	stats.Register("total_net_output_bytes", info.Callback(func() string {
+		return strconv.FormatInt(i.TotalNetOutputBytes(), 10)
+	}))
+	stats.Register("expired_keys", i.expired)
+	stats.Register("evicted_keys", i.evicted)
+	stats.Register("keyspace_hits", i.hits)
+	stats.Register("keyspace_misses", i.misses)
+
+	i.Fetch("Replication").RegisterFunc(i.writeReplication)
+	i.Fetch("Errorstats").RegisterFunc(i.errors.writeInfo)
 }
 
 func (i *ServerInfo) register(c *Client) {
@@ -173,35 +417,27 @@ func (i *ServerInfo) register(c *Client) {
 	i.connections.Inc(1)
 }
 
-func (i *ServerInfo) deregister(clientID uint64) {
-	i.clients.Del(clientID)
+func (i *ServerInfo) deregister(c *Client) {
+	i.clients.Del(c.id)
+	i.netIn.Inc(c.BytesRead())
+	i.netOut.Inc(c.BytesWritten())
 }
 
-func (i *ServerInfo) command(clientID uint64, cmd string) {
-	i.clients.Cmd(clientID, cmd)
+func (i *ServerInfo) command(c *Client, cmd string) {
+	c.track(cmd)
 	i.commands.Inc(1)
 }
 
 // --------------------------------------------------------------------
 
 type clientStats struct {
-	stats map[uint64]*ClientInfo
+	stats map[uint64]*Client
 	mu    sync.RWMutex
 }
 
 func (s *clientStats) Add(c *Client) {
-	info := newClientInfo(c, time.Now())
-	s.mu.Lock()
-	s.stats[c.id] = info
-	s.mu.Unlock()
-}
-
-func (s *clientStats) Cmd(clientID uint64, cmd string) {
 	s.mu.Lock()
-	if info, ok := s.stats[clientID]; ok {
-		info.AccessTime = time.Now()
-		info.LastCmd = cmd
-	}
+	s.stats[c.id] = c
 	s.mu.Unlock()
 }
 
@@ -211,6 +447,30 @@ func (s *clientStats) Del(clientID uint64) {
 	s.mu.Unlock()
 }
 
+// Kill disconnects all clients matching fn and returns the number of
+// clients killed. The calling client (self, may be nil) is disconnected only
+// after its pending replies have been written.
+func (s *clientStats) Kill(self *Client, fn func(*ClientInfo) bool) int {
+	var matched []*Client
+
+	s.mu.RLock()
+	for _, c := range s.stats {
+		if fn(newClientInfo(c)) {
+			matched = append(matched, c)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, c := range matched {
+		if c == self {
+			c.Close()
+		} else {
+			c.kill()
+		}
+	}
+	return len(matched)
+}
+
 func (s *clientStats) Len() int {
 	s.mu.RLock()
 	n := len(s.stats)
@@ -223,13 +483,25 @@ func (s *clientStats) All() []ClientInfo {
 	defer s.mu.RUnlock()
 
 	res := make(clientInfoSlice, 0, len(s.stats))
-	for _, info := range s.stats {
-		res = append(res, *info)
+	for _, c := range s.stats {
+		res = append(res, *newClientInfo(c))
 	}
 	sort.Sort(res)
 	return res
 }
 
+// Bytes returns the number of bytes transferred by connected clients
+func (s *clientStats) Bytes() (in, out int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.stats {
+		in += c.BytesRead()
+		out += c.BytesWritten()
+	}
+	return
+}
+
 type clientInfoSlice []ClientInfo
 
 func (p clientInfoSlice) Len() int           { return len(p) }
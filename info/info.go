@@ -72,6 +72,14 @@ func (r *Registry) String() string {
 	return buf.String()
 }
 
+// Sections returns all sections, in the order of their registration
+func (r *Registry) Sections() []*Section {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]*Section(nil), r.sections...)
+}
+
 func (r *Registry) findSection(name string) *Section {
 	for _, s := range r.sections {
 		if strings.ToLower(s.name) == strings.ToLower(name) {
@@ -88,10 +96,21 @@ type Section struct {
 	mu   sync.RWMutex
 }
 
+// Name returns the section name
+func (s *Section) Name() string { return s.name }
+
 // Register registers a value under a name
 func (s *Section) Register(name string, value Value) {
 	s.mu.Lock()
-	s.kvs = append(s.kvs, kv{name, value})
+	s.kvs = append(s.kvs, kv{name: name, value: value})
+	s.mu.Unlock()
+}
+
+// RegisterFunc registers a callback which emits a variable number of
+// values, e.g. one per connected replica
+func (s *Section) RegisterFunc(fn func(emit func(name, value string))) {
+	s.mu.Lock()
+	s.kvs = append(s.kvs, kv{fn: fn})
 	s.mu.Unlock()
 }
 
@@ -102,6 +121,18 @@ func (s *Section) Clear() {
 	s.mu.Unlock()
 }
 
+// Reset resets all values of the section which implement Resetter
+func (s *Section) Reset() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, kv := range s.kvs {
+		if r, ok := kv.value.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
+
 // Replace replaces the section enties
 func (s *Section) Replace(fn func(*Section)) {
 	t := &Section{name: s.name}
@@ -112,13 +143,31 @@ func (s *Section) Replace(fn func(*Section)) {
 	s.mu.Unlock()
 }
 
-func (s *Section) writeTo(buf *bytes.Buffer) {
-	buf.WriteString("# " + s.name + "\n")
+// Each calls fn with the name and value of each entry
+func (s *Section) Each(fn func(name, value string)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.each(fn)
+}
+
+func (s *Section) each(fn func(name, value string)) {
 	for _, kv := range s.kvs {
-		buf.WriteString(kv.name + ":" + kv.value.String() + "\n")
+		if kv.fn != nil {
+			kv.fn(fn)
+			continue
+		}
+		fn(kv.name, kv.value.String())
 	}
 }
 
+func (s *Section) writeTo(buf *bytes.Buffer) {
+	buf.WriteString("# " + s.name + "\n")
+	s.each(func(name, value string) {
+		buf.WriteString(name + ":" + value + "\n")
+	})
+}
+
 // String generates an info string output
 func (s *Section) String() string {
 	if s == nil {
@@ -140,4 +189,5 @@ func (s *Section) String() string {
 type kv struct {
 	name  string
 	value Value
+	fn    func(func(string, string))
 }
@@ -31,6 +31,15 @@ var _ = Describe("Registry", func() {
 		Expect(subject.sections).To(BeEmpty())
 	})
 
+	It("should reset", func() {
+		n := NewIntValue(5)
+		subject.FetchSection("Stats").Register("static", StaticInt(7))
+		subject.FetchSection("Stats").Register("counter", n)
+		subject.FetchSection("Stats").Reset()
+		Expect(n.Value()).To(Equal(int64(0)))
+		Expect(subject.FindSection("stats").String()).To(Equal("# Stats\nstatic:7\ncounter:0\n"))
+	})
+
 	It("should replace", func() {
 		subject.FetchSection("Server").Replace(func(s *Section) {
 			s.Register("test", StaticString("string"))
@@ -39,6 +48,19 @@ var _ = Describe("Registry", func() {
 		Expect(s).To(Equal("# Server\ntest:string\n"))
 	})
 
+	It("should register funcs", func() {
+		n := 1
+		subject.FetchSection("Clients").RegisterFunc(func(emit func(string, string)) {
+			for i := 0; i < n; i++ {
+				emit("client"+string('0'+rune(i)), "ok")
+			}
+		})
+		Expect(subject.FindSection("clients").String()).To(Equal("# Clients\ncount:17\ntotal:123456\nclient0:ok\n"))
+
+		n = 2
+		Expect(subject.FindSection("clients").String()).To(Equal("# Clients\ncount:17\ntotal:123456\nclient0:ok\nclient1:ok\n"))
+	})
+
 	It("should generate section strings", func() {
 		s := subject.FindSection("clients").String()
 		Expect(s).To(Equal("# Clients\ncount:17\ntotal:123456\n"))
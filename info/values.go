@@ -10,6 +10,11 @@ type Value interface {
 	String() string
 }
 
+// Resetter is implemented by values which can be reset, e.g. counters
+type Resetter interface {
+	Reset()
+}
+
 // StaticString is the simplest value type
 type StaticString string
 
@@ -45,6 +50,9 @@ func (v *IntValue) Value() int64 { return atomic.LoadInt64(&v.n) }
 // String return the value of IntValue as string
 func (v *IntValue) String() string { return strconv.FormatInt(v.Value(), 10) }
 
+// Reset implements Resetter and sets the value to 0
+func (v *IntValue) Reset() { v.Set(0) }
+
 // --------------------------------------------------------------------
 
 // StringValue is a string value with thread-safe atomic modifiers.
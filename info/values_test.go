@@ -46,6 +46,8 @@ var _ = Describe("IntValue", func() {
 		Expect(subject.Value()).To(Equal(int64(10)))
 		subject.Set(21)
 		Expect(subject.Value()).To(Equal(int64(21)))
+		subject.Reset()
+		Expect(subject.Value()).To(Equal(int64(0)))
 	})
 
 	It("should generate strings", func() {
@@ -1,8 +1,10 @@
 package redeo
 
 import (
+	"strconv"
 	"time"
 
+	"github.com/johntech-o/redeo/info"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -13,19 +15,24 @@ var _ = Describe("ServerInfo", func() {
 	BeforeEach(func() {
 		c1 := newClient(&mockConn{Port: 10001})
 
-		subject = newServerInfo()
+		subject = newServerInfo(SystemClock)
 		subject.connections.Inc(5)
 		subject.commands.Inc(12)
 		subject.clients.Add(c1)
 		subject.clients.Add(newClient(&mockConn{Port: 10002}))
 		subject.clients.Add(newClient(&mockConn{Port: 10004}))
-		subject.clients.Cmd(c1.ID(), "get")
+		c1.track("get")
 	})
 
 	It("should generate info string", func() {
 		str := subject.String()
 		Expect(str).To(ContainSubstring("# Server\n"))
+		Expect(str).To(ContainSubstring("# Server\nredis_version:" + DefaultVersion + "\nredis_mode:standalone\n"))
+		Expect(str).To(MatchRegexp(`os:\w+ \w+\narch_bits:(32|64)\n`))
 		Expect(str).To(MatchRegexp(`process_id:\d+\n`))
+		Expect(str).To(ContainSubstring("run_id:" + subject.RunID() + "\n"))
+		Expect(subject.RunID()).To(MatchRegexp(`^[0-9a-f]{40}$`))
+		Expect(newServerInfo(SystemClock).RunID()).NotTo(Equal(subject.RunID()))
 		Expect(str).To(MatchRegexp(`uptime_in_seconds:\d+\n`))
 		Expect(str).To(MatchRegexp(`uptime_in_days:\d+\n`))
 
@@ -33,10 +40,77 @@ var _ = Describe("ServerInfo", func() {
 		Expect(str).To(ContainSubstring("# Stats\ntotal_connections_received:5\ntotal_commands_processed:12\n"))
 	})
 
+	It("should snapshot and reset stats", func() {
+		c := newClient(&mockConn{Port: 10005})
+		subject.clients.Add(c)
+		c.bytesRead, c.bytesWritten = 100, 200
+		subject.netIn.Inc(10)
+		subject.Hit()
+		subject.Hit()
+		subject.Miss()
+		subject.Expired(4)
+		subject.Evicted(1)
+
+		rejected := info.NewIntValue(3)
+		subject.Fetch("Stats").Register("rejected", rejected)
+
+		stats := subject.Snapshot()
+		Expect(stats.Uptime).To(BeNumerically(">", 0))
+		Expect(*stats).To(Equal(ServerStats{
+			Uptime:              stats.Uptime,
+			ConnectedClients:    4,
+			TotalConnections:    5,
+			TotalCommands:       12,
+			TotalNetInputBytes:  110,
+			TotalNetOutputBytes: 200,
+			KeyspaceHits:        2,
+			KeyspaceMisses:      1,
+			ExpiredKeys:         4,
+			EvictedKeys:         1,
+		}))
+		Expect(subject.TotalNetInputBytes()).To(Equal(int64(110)))
+		Expect(subject.TotalNetOutputBytes()).To(Equal(int64(200)))
+		Expect(subject.String()).To(ContainSubstring("\nexpired_keys:4\nevicted_keys:1\nkeyspace_hits:2\nkeyspace_misses:1\n"))
+
+		subject.Reset()
+		Expect(rejected.Value()).To(Equal(int64(0)))
+		Expect(subject.Snapshot().TotalCommands).To(Equal(int64(0)))
+		Expect(subject.String()).To(ContainSubstring("total_connections_received:0\ntotal_commands_processed:0\ntotal_net_input_bytes:0\ntotal_net_output_bytes:0\nexpired_keys:0\nevicted_keys:0\nkeyspace_hits:0\nkeyspace_misses:0\n"))
+
+		c.bytesRead += 5
+		Expect(subject.TotalNetInputBytes()).To(Equal(int64(5)))
+		Expect(subject.NumClients()).To(Equal(4))
+	})
+
+	It("should count unknown commands and arity errors", func() {
+		subject.errors.UnknownCommand("foo")
+		subject.errors.UnknownCommand("foo")
+		subject.errors.UnknownCommand("bad:name\r\n")
+		subject.errors.ArityError("config|rewrite")
+		Expect(subject.UnknownCommands()).To(Equal(map[string]int64{"foo": 2, "bad:name\r\n": 1}))
+		Expect(subject.ArityErrors()).To(Equal(map[string]int64{"config|rewrite": 1}))
+		Expect(subject.Snapshot().UnknownCommands).To(Equal(int64(3)))
+		Expect(subject.Snapshot().ArityErrors).To(Equal(int64(1)))
+		Expect(subject.Find("Errorstats").String()).To(Equal("# Errorstats\n" +
+			"total_unknown_commands:3\ntotal_arity_errors:1\n" +
+			"unknowncmd_bad_name__:count=1\nunknowncmd_foo:count=2\n" +
+			"arityerr_config|rewrite:count=1\n"))
+
+		for i := 0; i < MaxErrorStatsNames+10; i++ {
+			subject.errors.UnknownCommand(strconv.Itoa(i))
+		}
+		Expect(subject.UnknownCommands()).To(HaveLen(MaxErrorStatsNames))
+		Expect(subject.Snapshot().UnknownCommands).To(Equal(int64(MaxErrorStatsNames + 13)))
+
+		subject.Reset()
+		Expect(subject.UnknownCommands()).To(BeEmpty())
+		Expect(subject.Snapshot().ArityErrors).To(Equal(int64(0)))
+	})
+
 	It("should retrieve a list of clients", func() {
 		stats := subject.ClientInfo()
 		Expect(stats).To(HaveLen(3))
-		Expect(stats[0].String()).To(MatchRegexp(`id=\d+ addr=1\.2\.3\.4\:10001 age=\d+ idle=\d+ cmd=get`))
+		Expect(stats[0].String()).To(MatchRegexp(`id=\d+ addr=1\.2\.3\.4\:10001 name= age=\d+ idle=\d+ cmd=get tot-net-in=0 tot-net-out=0 tot-cmds=1`))
 	})
 
 })
@@ -47,8 +121,11 @@ var _ = Describe("ClientInfo", func() {
 		c := newClient(&mockConn{Port: 10001})
 		c.id = 12
 
-		info := newClientInfo(c, time.Now().Add(-3*time.Second))
-		Expect(info.String()).To(Equal(`id=12 addr=1.2.3.4:10001 age=3 idle=3 cmd=`))
+		c.created = time.Now().Add(-3 * time.Second)
+		c.accessed = c.created
+
+		info := newClientInfo(c)
+		Expect(info.String()).To(Equal(`id=12 addr=1.2.3.4:10001 name= age=3 idle=3 cmd= tot-net-in=0 tot-net-out=0 tot-cmds=0 lib-name= lib-ver=`))
 	})
 
 })
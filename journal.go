@@ -0,0 +1,200 @@
+package redeo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// FsyncPolicy determines when journals are synced to disk, like Redis'
+// appendfsync
+type FsyncPolicy int
+
+const (
+	// FsyncEverySec syncs once per second, up to a second of writes may
+	// be lost on crashes
+	FsyncEverySec FsyncPolicy = iota
+	// FsyncAlways syncs after every command
+	FsyncAlways
+	// FsyncNo leaves syncing to the operating system
+	FsyncNo
+)
+
+// JournalOptions configure a Journal
+type JournalOptions struct {
+	// Fsync determines when the journal is synced to disk.
+	// Default: FsyncEverySec
+	Fsync FsyncPolicy
+}
+
+// Journal appends the write commands executed by a server to a file,
+// like Redis' AOF. Once attached to a server, all commands registered via
+// HandleWrite are serialised and appended in RESP format, see Propagator.
+// Journals can be replayed on startup via ReplayJournal. Errors are
+// reported via Config.OnError, no further commands are appended once
+// writing failed.
+type Journal struct {
+	srv *Server
+	opt JournalOptions
+
+	mu    sync.Mutex
+	f     *os.File
+	w     *resp.RequestWriter
+	dirty bool
+	err   error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenJournal opens or creates the journal at path and attaches it to srv
+func OpenJournal(srv *Server, path string, opt *JournalOptions) (*Journal, error) {
+	var o JournalOptions
+	if opt != nil {
+		o = *opt
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		srv:  srv,
+		opt:  o,
+		f:    f,
+		w:    resp.NewRequestWriter(f),
+		done: make(chan struct{}),
+	}
+	srv.AddPropagator(j)
+
+	if o.Fsync == FsyncEverySec {
+		go j.loop()
+	}
+	return j, nil
+}
+
+// Propagate implements Propagator
+func (j *Journal) Propagate(cmd *resp.Command) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil || j.err != nil {
+		return
+	}
+
+	appendCmd(j.w, cmd)
+	err := j.w.Flush()
+	if err == nil && j.opt.Fsync == FsyncAlways {
+		err = j.f.Sync()
+	}
+	if err != nil {
+		j.err = err
+		j.srv.reportError(ErrorJournal, nil, err)
+		return
+	}
+	j.dirty = true
+}
+
+// Err returns the error which stopped the journal, if any
+func (j *Journal) Err() error {
+	j.mu.Lock()
+	err := j.err
+	j.mu.Unlock()
+	return err
+}
+
+// Close detaches the journal from the server, syncs and closes the file
+func (j *Journal) Close() (err error) {
+	j.closeOnce.Do(func() {
+		close(j.done)
+		j.srv.RemovePropagator(j)
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		if err = j.f.Sync(); err == nil {
+			err = j.f.Close()
+		} else {
+			_ = j.f.Close()
+		}
+		j.f = nil
+	})
+	return
+}
+
+// loop syncs the journal every second
+func (j *Journal) loop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.f != nil && j.dirty && j.err == nil {
+				if err := j.f.Sync(); err != nil {
+					j.err = err
+					j.srv.reportError(ErrorJournal, nil, err)
+				}
+				j.dirty = false
+			}
+			j.mu.Unlock()
+		case <-j.done:
+			return
+		}
+	}
+}
+
+// JournalTruncatedError is returned by ReplayJournal if a journal ends
+// with an incomplete command, e.g. after a crash. Journals can be repaired
+// by truncating them to Offset.
+type JournalTruncatedError struct {
+	// Offset is the size of the complete commands
+	Offset int64
+}
+
+// Error implements error
+func (e *JournalTruncatedError) Error() string {
+	return fmt.Sprintf("redeo: journal truncated at offset %d", e.Offset)
+}
+
+// ReplayJournal applies the commands of the journal at path to srv, see
+// Apply, and returns the number of commands applied. Like in Redis,
+// error replies are ignored, but unknown commands abort the replay.
+func ReplayJournal(srv *Server, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	cr := &countingReader{r: f}
+	rd := resp.NewRequestReader(cr)
+
+	var cmd *resp.Command
+	for n := 0; ; n++ {
+		offset := cr.n - int64(rd.Buffered())
+		if cmd, err = rd.ReadCmd(cmd); err == io.EOF {
+			if offset != cr.n {
+				return n, &JournalTruncatedError{Offset: offset}
+			}
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("redeo: journal offset %d: %v", offset, err)
+		}
+
+		srv.mu.RLock()
+		_, ok := srv.cmds[cmd.CanonicalName()]
+		srv.mu.RUnlock()
+
+		if !ok {
+			return n, fmt.Errorf("redeo: journal offset %d: %v", offset, ErrUnknownCommand(cmd.Name))
+		}
+		_ = srv.Apply(cmd)
+	}
+}
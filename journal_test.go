@@ -0,0 +1,95 @@
+package redeo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal", func() {
+	var dir, path string
+	var srv *Server
+	var data map[string]string
+
+	var newServer = func() *Server {
+		data = make(map[string]string)
+		srv := NewServer(nil)
+		srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendBulkString(data[c.Arg(0).String()])
+		})
+		srv.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			if c.ArgN() != 2 {
+				w.AppendError(WrongNumberOfArgs(c.Name))
+				return
+			}
+			data[c.Arg(0).String()] = c.Arg(1).String()
+			w.AppendOK()
+		})
+		return srv
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "redeo-journal")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = filepath.Join(dir, "appendonly.aof")
+		srv = newServer()
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should append write commands", func() {
+		subject, err := OpenJournal(srv, path, &JournalOptions{Fsync: FsyncAlways})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(srv.IsWrite("SET")).To(BeTrue())
+		Expect(srv.IsWrite("GET")).To(BeFalse())
+
+		Expect(redeotest.Transcript(srv,
+			"SET k v\r\n",
+			"GET k\r\n",
+			"SET k\r\n",
+		)).To(Equal([]byte("+OK\r\n$1\r\nv\r\n-ERR wrong number of arguments for 'SET' command\r\n")))
+		Expect(subject.Close()).To(Succeed())
+
+		// commands are no longer appended once closed
+		Expect(redeotest.Transcript(srv, "SET x y\r\n")).To(Equal([]byte("+OK\r\n")))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*2\r\n$3\r\nSET\r\n$1\r\nk\r\n")))
+	})
+
+	It("should replay journals", func() {
+		subject, err := OpenJournal(srv, path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(redeotest.Transcript(srv, "SET a 1\r\n", "SET b 2\r\n", "SET a 3\r\n")).To(HaveLen(15))
+		Expect(subject.Close()).To(Succeed())
+
+		srv = newServer()
+		Expect(ReplayJournal(srv, path)).To(Equal(3))
+		Expect(data).To(Equal(map[string]string{"a": "3", "b": "2"}))
+	})
+
+	It("should reject truncated journals", func() {
+		Expect(ioutil.WriteFile(path, []byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*3\r\n$3\r\nSET\r\n$1"), 0600)).To(Succeed())
+
+		n, err := ReplayJournal(srv, path)
+		Expect(n).To(Equal(1))
+		Expect(err).To(Equal(&JournalTruncatedError{Offset: 27}))
+		Expect(data).To(Equal(map[string]string{"k": "v"}))
+	})
+
+	It("should reject unknown commands", func() {
+		Expect(ioutil.WriteFile(path, []byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*1\r\n$4\r\nSAVE\r\n"), 0600)).To(Succeed())
+
+		n, err := ReplayJournal(srv, path)
+		Expect(n).To(Equal(1))
+		Expect(err).To(MatchError("redeo: journal offset 27: ERR unknown command 'SAVE'"))
+	})
+
+})
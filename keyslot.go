@@ -0,0 +1,52 @@
+package redeo
+
+import "strings"
+
+// NumSlots is the number of hash slots of a Redis cluster
+const NumSlots = 16384
+
+// crc16tab is the lookup table of CRC16-CCITT (XMODEM), as used by
+// Redis cluster
+var crc16tab [256]uint16
+
+func init() {
+	for i := range crc16tab {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16tab[i] = crc
+	}
+}
+
+// CRC16 returns the CRC16-CCITT (XMODEM) checksum of s
+func CRC16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16tab[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// HashTag returns the part of the key which is hashed to find its slot.
+// If the key contains a non-empty substring between the first '{' and
+// the following '}', only that substring is hashed, otherwise the
+// whole key.
+// https://redis.io/topics/cluster-spec#hash-tags
+func HashTag(key string) string {
+	if i := strings.IndexByte(key, '{'); i > -1 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			return key[i+1 : i+1+j]
+		}
+	}
+	return key
+}
+
+// KeySlot returns the cluster hash slot of a key, respecting hash tags
+func KeySlot(key string) uint16 {
+	return CRC16(HashTag(key)) % NumSlots
+}
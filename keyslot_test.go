@@ -0,0 +1,46 @@
+package redeo
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeySlot", func() {
+
+	It("should calculate checksums", func() {
+		Expect(CRC16("123456789")).To(Equal(uint16(0x31C3)))
+		Expect(CRC16("")).To(Equal(uint16(0)))
+	})
+
+	DescribeTable("HashTag",
+		func(key, tag string) {
+			Expect(HashTag(key)).To(Equal(tag))
+		},
+		Entry("no tag", "foo", "foo"),
+		Entry("tag", "{user1000}.following", "user1000"),
+		Entry("first tag", "foo{bar}{zap}", "bar"),
+		Entry("nested braces", "foo{{bar}}zap", "{bar"),
+		Entry("empty tag", "foo{}{bar}", "foo{}{bar}"),
+		Entry("unclosed", "foo{bar", "foo{bar"),
+		Entry("closing first", "foo}bar{", "foo}bar{"),
+	)
+
+	It("should calculate slots", func() {
+		Expect(KeySlot("key")).To(Equal(uint16(12539)))
+		Expect(KeySlot("foo")).To(Equal(uint16(12182)))
+		Expect(KeySlot("123456789")).To(Equal(uint16(12739)))
+		Expect(KeySlot("{user1000}.following")).To(Equal(KeySlot("{user1000}.followers")))
+		Expect(KeySlot("{user1000}.following")).To(Equal(KeySlot("user1000")))
+	})
+
+})
+
+func BenchmarkKeySlot(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		KeySlot("{user1000}.following")
+	}
+}
@@ -0,0 +1,536 @@
+package redeo
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Snapshotter creates point-in-time snapshots of the data set, used for
+// the full resynchronisation of replicas
+type Snapshotter interface {
+	// Snapshot writes the data set to w, in RDB format
+	Snapshot(w io.Writer) error
+}
+
+// SnapshotterFunc is a function which implements Snapshotter
+type SnapshotterFunc func(w io.Writer) error
+
+// Snapshot implements Snapshotter
+func (f SnapshotterFunc) Snapshot(w io.Writer) error { return f(w) }
+
+// MasterOptions configure a Master
+type MasterOptions struct {
+	// BacklogSize is the size of the replication backlog, which allows
+	// disconnected replicas to resume via partial resynchronisation.
+	// Default: 1MiB
+	BacklogSize int
+
+	// PingInterval is the interval at which replicas are pinged, to keep
+	// links to idle masters from timing out.
+	// Default: 10s
+	PingInterval time.Duration
+
+	// MinReplicasToWrite is the minimum number of good replicas, which
+	// acknowledged within MinReplicasMaxLag, for write commands to be
+	// accepted, like Redis' min-replicas-to-write. Otherwise, writes are
+	// rejected with NoReplicasError.
+	// Default: 0 (disabled)
+	MinReplicasToWrite int
+
+	// MinReplicasMaxLag is the maximum time since the last acknowledgement
+	// of good replicas, see MinReplicasToWrite.
+	// Default: 10s
+	MinReplicasMaxLag time.Duration
+}
+
+func (o *MasterOptions) norm() {
+	if o.BacklogSize <= 0 {
+		o.BacklogSize = 1024 * 1024
+	}
+	if o.PingInterval <= 0 {
+		o.PingInterval = 10 * time.Second
+	}
+	if o.MinReplicasToWrite < 0 {
+		o.MinReplicasToWrite = 0
+	}
+	if o.MinReplicasMaxLag <= 0 {
+		o.MinReplicasMaxLag = 10 * time.Second
+	}
+}
+
+// Master serves replicas via PSYNC and REPLCONF, including Redis
+// replicas. Once attached to a server, all commands registered via
+// HandleWrite are serialised and propagated to connected replicas.
+// Streaming write handlers are not propagated. Masters implement
+// ReplicationProvider, Propagator and WriteChecker.
+type Master struct {
+	srv  *Server
+	snap Snapshotter
+	opt  MasterOptions
+
+	mu       sync.Mutex
+	replID   string
+	offset   int64
+	backlog  *backlog
+	replicas map[*Client]*masterReplica
+	ports    map[*Client]int
+	acks     chan struct{} // closed on every ack
+
+	buf bytes.Buffer
+	w   *resp.RequestWriter
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type masterReplica struct {
+	port   int
+	offset int64
+	acked  time.Time
+
+	// syncing is set while the replica receives its snapshot or backlog,
+	// the stream fed meanwhile is buffered in pending
+	syncing bool
+	pending []byte
+}
+
+// NewMaster attaches a master to srv, snap is used to create the
+// snapshots sent on full resynchronisation
+func NewMaster(srv *Server, snap Snapshotter, opt *MasterOptions) *Master {
+	var o MasterOptions
+	if opt != nil {
+		o = *opt
+	}
+	o.norm()
+
+	m := &Master{
+		srv:      srv,
+		snap:     snap,
+		opt:      o,
+		replID:   newReplID(),
+		replicas: make(map[*Client]*masterReplica),
+		ports:    make(map[*Client]int),
+		backlog:  newBacklog(o.BacklogSize),
+		acks:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	m.w = resp.NewRequestWriter(&m.buf)
+
+	srv.AddPropagator(m)
+
+	go m.loop()
+	return m
+}
+
+// ReplID returns the replication ID, a random ID like the run ID of the
+// server. A new ID is generated whenever the history diverges, e.g. when
+// the server is demoted.
+func (m *Master) ReplID() string {
+	m.mu.Lock()
+	id := m.replID
+	m.mu.Unlock()
+	return id
+}
+
+// Offset returns the replication offset
+func (m *Master) Offset() int64 {
+	m.mu.Lock()
+	n := m.offset
+	m.mu.Unlock()
+	return n
+}
+
+// Close detaches the master from the server and disconnects all replicas
+func (m *Master) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+
+		m.srv.RemovePropagator(m)
+
+		m.mu.Lock()
+		for c := range m.replicas {
+			c.kill()
+			delete(m.replicas, c)
+		}
+		m.mu.Unlock()
+	})
+}
+
+// reset disconnects all replicas and starts a new replication history
+func (m *Master) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for c := range m.replicas {
+		c.kill()
+		delete(m.replicas, c)
+	}
+	m.replID = newReplID()
+	m.backlog.reset()
+}
+
+// SetBacklogSize resizes the replication backlog, retaining as much of
+// the most recent history as fits, see MasterOptions.BacklogSize
+func (m *Master) SetBacklogSize(n int) {
+	if n <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	m.backlog.resize(n)
+	m.mu.Unlock()
+}
+
+// SetMinReplicas changes MinReplicasToWrite and MinReplicasMaxLag, see
+// MasterOptions
+func (m *Master) SetMinReplicas(n int, maxLag time.Duration) {
+	m.mu.Lock()
+	m.opt.MinReplicasToWrite, m.opt.MinReplicasMaxLag = n, maxLag
+	m.opt.norm()
+	m.mu.Unlock()
+}
+
+// CheckWrite implements WriteChecker, write commands are rejected while
+// fewer than MinReplicasToWrite good replicas are attached
+func (m *Master) CheckWrite(_ *resp.Command) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n := m.opt.MinReplicasToWrite; n != 0 && m.numGood(time.Now()) < n {
+		return NoReplicasError
+	}
+	return ""
+}
+
+// ReplicationInfo implements ReplicationProvider
+func (m *Master) ReplicationInfo() *ReplicationInfo {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ri := &ReplicationInfo{Role: RoleMaster, Offset: m.offset, Backlog: &BacklogInfo{
+		Size:            int64(m.backlog.size),
+		FirstByteOffset: m.backlog.first(),
+		HistLen:         int64(m.backlog.histLen()),
+	}}
+	for c, r := range m.replicas {
+		var host string
+		if addr := c.RemoteAddr(); addr != nil {
+			host, _, _ = net.SplitHostPort(addr.String())
+		}
+		state := "online"
+		if r.syncing {
+			state = "send_bulk"
+		}
+		ri.Replicas = append(ri.Replicas, ReplicaInfo{
+			Host:   host,
+			Port:   r.port,
+			State:  state,
+			Offset: r.offset,
+			Lag:    int64(now.Sub(r.acked) / time.Second),
+		})
+	}
+	return ri
+}
+
+// WaitForReplicas blocks until at least n replicas have acknowledged all
+// writes propagated so far or the timeout expires, a timeout of zero
+// blocks indefinitely. Lagging replicas are asked to acknowledge
+// immediately. It returns the number of replicas which acknowledged.
+func (m *Master) WaitForReplicas(n int, timeout time.Duration) int {
+	m.mu.Lock()
+	target := m.offset
+	if m.numAcked(target) < n && len(m.replicas) != 0 {
+		m.feed([]byte("*3\r\n$8\r\nREPLCONF\r\n$6\r\nGETACK\r\n$1\r\n*\r\n"))
+	}
+	m.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		m.mu.Lock()
+		acked, acks := m.numAcked(target), m.acks
+		m.mu.Unlock()
+
+		if acked >= n {
+			return acked
+		}
+
+		select {
+		case <-acks:
+		case <-expired:
+			return acked
+		case <-m.done:
+			return acked
+		}
+	}
+}
+
+// ResetClient implements ClientResetter and detaches the client, if it is
+// a replica.
+func (m *Master) ResetClient(c *Client) {
+	m.mu.Lock()
+	delete(m.replicas, c)
+	delete(m.ports, c)
+	m.mu.Unlock()
+}
+
+// PSync returns a PSYNC handler. Replicas resume from the backlog if
+// possible, otherwise they receive a full snapshot. Write commands are
+// blocked while the snapshot is created.
+// https://redis.io/commands/psync
+func (m *Master) PSync() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		client := GetClient(c.Context())
+		if client == nil {
+			w.AppendError("ERR PSYNC requires a client connection")
+			return
+		}
+
+		offset, err := strconv.ParseInt(c.Arg(1).String(), 10, 64)
+		if err != nil {
+			offset = -1
+		}
+		if err := m.sync(client, c.Arg(0).String(), offset); err != nil {
+			w.AppendError(errorReply(err))
+		}
+	})
+}
+
+// ReplConf returns a REPLCONF handler, which accepts the listening-port,
+// ip-address and capa options sent by replicas before PSYNC and tracks
+// the offsets acknowledged via REPLCONF ACK.
+func (m *Master) ReplConf() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN()%2 != 0 {
+			w.AppendError("ERR syntax error")
+			return
+		}
+
+		client := GetClient(c.Context())
+		for i := 0; i < c.ArgN(); i += 2 {
+			opt, val := strings.ToLower(c.Arg(i).String()), c.Arg(i+1).String()
+
+			switch opt {
+			case "listening-port":
+				port, err := strconv.Atoi(val)
+				if err != nil {
+					w.AppendError("ERR value is not an integer or out of range")
+					return
+				}
+				if client != nil {
+					m.mu.Lock()
+					m.ports[client] = port
+					m.mu.Unlock()
+				}
+			case "ack":
+				// acknowledgements are never answered
+				if offset, err := strconv.ParseInt(val, 10, 64); err == nil && client != nil {
+					m.ack(client, offset)
+				}
+				return
+			case "ip-address", "capa", "getack":
+			default:
+				w.AppendErrorf("ERR Unrecognized REPLCONF option: %s", c.Arg(i).String())
+				return
+			}
+		}
+		w.AppendOK()
+	})
+}
+
+// Wait returns a WAIT handler, which blocks the client until its writes
+// were acknowledged by the requested number of replicas.
+// https://redis.io/commands/wait
+func (m *Master) Wait() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		n, err := strconv.Atoi(c.Arg(0).String())
+		if err != nil {
+			w.AppendError("ERR value is not an integer or out of range")
+			return
+		}
+		ms, err := strconv.ParseInt(c.Arg(1).String(), 10, 64)
+		if err != nil {
+			w.AppendError("ERR timeout is not an integer or out of range")
+			return
+		} else if ms < 0 {
+			w.AppendError("ERR timeout is negative")
+			return
+		}
+
+		w.AppendInt(int64(m.WaitForReplicas(n, time.Duration(ms)*time.Millisecond)))
+	})
+}
+
+// Propagate implements Propagator
+func (m *Master) Propagate(cmd *resp.Command) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buf.Reset()
+	appendCmd(m.w, cmd)
+	_ = m.w.Flush()
+	m.feed(m.buf.Bytes())
+}
+
+// feed appends p to the backlog and sends it to all replicas,
+// must be called with the lock held. Replicas which are still syncing
+// and accumulate more than 8MiB are disconnected, like clients which fail
+// to keep up with their frames, see Client.WriteFrame.
+func (m *Master) feed(p []byte) {
+	m.offset += int64(len(p))
+	m.backlog.write(p, m.offset)
+
+	for c, r := range m.replicas {
+		if r.syncing {
+			if len(r.pending)+len(p) <= maxPendingSize {
+				r.pending = append(r.pending, p...)
+				continue
+			}
+		} else if err := c.writeRaw(p); err == nil {
+			continue
+		}
+		c.kill()
+		delete(m.replicas, c)
+	}
+}
+
+// sync performs a partial or full resynchronisation. The snapshot or
+// backlog is written to the connection directly, as it may exceed the
+// limit of queued frames, the stream fed meanwhile is sent afterwards.
+func (m *Master) sync(c *Client, replID string, offset int64) error {
+	// block writes, so snapshots match the offset
+	m.srv.wmu.Lock()
+	r, head, data, err := m.attach(c, replID, offset)
+	m.srv.wmu.Unlock()
+
+	if err == nil {
+		err = c.writeDirect([]byte(head), data)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.replicas[c] != r {
+		// dropped while syncing
+		return err
+	}
+	if err == nil {
+		r.syncing = false
+		err = c.writeRaw(r.pending)
+		r.pending = nil
+	}
+	if err != nil {
+		delete(m.replicas, c)
+	}
+	return err
+}
+
+// attach attaches c as a syncing replica and returns the reply to PSYNC,
+// followed by the backlog or snapshot to send, must be called with writes
+// blocked. The lock is not held while the snapshot is created.
+func (m *Master) attach(c *Client, replID string, offset int64) (*masterReplica, string, []byte, error) {
+	m.mu.Lock()
+	r := &masterReplica{port: m.ports[c], acked: time.Now(), syncing: true}
+	delete(m.ports, c)
+	atomic.StoreInt32(&c.replica, 1)
+	m.replicas[c] = r
+
+	// replicas request the offset following the last byte received
+	if data, ok := m.backlog.readFrom(offset); ok && replID == m.replID {
+		head := "+CONTINUE " + m.replID + "\r\n"
+		m.mu.Unlock()
+		return r, head, data, nil
+	}
+	head := "+FULLRESYNC " + m.replID + " " + strconv.FormatInt(m.offset, 10) + "\r\n"
+	m.mu.Unlock()
+
+	var rdb bytes.Buffer
+	if err := m.snap.Snapshot(&rdb); err != nil {
+		return r, "", nil, err
+	}
+	return r, head + "$" + strconv.Itoa(rdb.Len()) + "\r\n", rdb.Bytes(), nil
+}
+
+func (m *Master) ack(c *Client, offset int64) {
+	m.mu.Lock()
+	if r, ok := m.replicas[c]; ok {
+		r.offset = offset
+		r.acked = time.Now()
+
+		close(m.acks)
+		m.acks = make(chan struct{})
+	}
+	m.mu.Unlock()
+}
+
+// numGood counts the replicas which acknowledged within
+// MinReplicasMaxLag, must be called with the lock held
+func (m *Master) numGood(now time.Time) int {
+	n := 0
+	for _, r := range m.replicas {
+		if now.Sub(r.acked) <= m.opt.MinReplicasMaxLag {
+			n++
+		}
+	}
+	return n
+}
+
+// numAcked counts the replicas which acknowledged offset,
+// must be called with the lock held
+func (m *Master) numAcked(offset int64) int {
+	n := 0
+	for _, r := range m.replicas {
+		if r.offset >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// loop pings replicas periodically
+func (m *Master) loop() {
+	ticker := time.NewTicker(m.opt.PingInterval)
+	defer ticker.Stop()
+
+	ping := []byte("*1\r\n$4\r\nPING\r\n")
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			if len(m.replicas) != 0 {
+				m.feed(ping)
+			}
+			m.mu.Unlock()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// newReplID generates a random 40 character replication ID
+func newReplID() string { return newRunID() }
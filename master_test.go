@@ -0,0 +1,273 @@
+package redeo
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Master", func() {
+	var subject *Master
+	var srv *Server
+	var lis net.Listener
+
+	var dial = func() (net.Conn, *bufio.Reader) {
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		return cn, bufio.NewReader(cn)
+	}
+
+	var send = func(cn net.Conn, args ...string) {
+		w := resp.NewRequestWriter(cn)
+		w.WriteCmdString(args[0], args[1:]...)
+		ExpectWithOffset(1, w.Flush()).To(Succeed())
+	}
+
+	var read = func(rd *bufio.Reader, n int) string {
+		p := make([]byte, n)
+		_, err := io.ReadFull(rd, p)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		return string(p)
+	}
+
+	var set = func(cn net.Conn, rd *bufio.Reader, key, value string) {
+		send(cn, "SET", key, value)
+		ExpectWithOffset(1, read(rd, 5)).To(Equal("+OK\r\n"))
+	}
+
+	BeforeEach(func() {
+		srv = NewServer(nil)
+		srv.Handle("ping", Ping())
+		srv.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendOK()
+		})
+
+		subject = NewMaster(srv, SnapshotterFunc(func(w io.Writer) error {
+			_, err := w.Write([]byte("REDIS0011\xff"))
+			return err
+		}), &MasterOptions{BacklogSize: 64, PingInterval: time.Hour})
+		srv.Handle("psync", subject.PSync())
+		srv.Handle("replconf", subject.ReplConf())
+		srv.Handle("wait", subject.Wait())
+
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go srv.Serve(lis)
+	})
+
+	AfterEach(func() {
+		subject.Close()
+		lis.Close()
+	})
+
+	It("should resynchronise replicas", func() {
+		Expect(subject.ReplID()).To(HaveLen(40))
+		Expect(subject.ReplID()).NotTo(Equal(srv.RunID()))
+
+		cn, rd := dial()
+		defer cn.Close()
+		send(cn, "REPLCONF", "listening-port", "6380")
+		Expect(read(rd, 5)).To(Equal("+OK\r\n"))
+		send(cn, "PSYNC", "?", "-1")
+		Expect(read(rd, 71)).To(Equal("+FULLRESYNC " + subject.ReplID() + " 0\r\n$10\r\nREDIS0011\xff"))
+		Expect(srv.Info().ClientInfo()[0].client.clientType()).To(Equal("replica"))
+
+		// propagate writes
+		wcn, wrd := dial()
+		defer wcn.Close()
+		set(wcn, wrd, "key", "value")
+		Expect(read(rd, 33)).To(Equal("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"))
+		Expect(subject.Offset()).To(Equal(int64(33)))
+
+		// acknowledge
+		send(cn, "REPLCONF", "ACK", "33")
+		Eventually(func() []ReplicaInfo { return subject.ReplicationInfo().Replicas }).Should(Equal([]ReplicaInfo{
+			{Host: "127.0.0.1", Port: 6380, State: "online", Offset: 33},
+		}))
+
+		// resume
+		pcn, prd := dial()
+		defer pcn.Close()
+		send(pcn, "PSYNC", subject.ReplID(), "1")
+		Expect(read(prd, 52)).To(Equal("+CONTINUE " + subject.ReplID() + "\r\n"))
+		Expect(read(prd, 33)).To(Equal("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"))
+	})
+
+	It("should fully resynchronise when the backlog is exceeded", func() {
+		wcn, wrd := dial()
+		defer wcn.Close()
+		for i := 0; i < 4; i++ {
+			set(wcn, wrd, "key", "value")
+		}
+		Expect(subject.Offset()).To(Equal(int64(132)))
+
+		cn, rd := dial()
+		defer cn.Close()
+		send(cn, "PSYNC", subject.ReplID(), "69")
+		Expect(read(rd, 52)).To(Equal("+CONTINUE " + subject.ReplID() + "\r\n"))
+		Expect(read(rd, 64)).To(HaveSuffix("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"))
+
+		cn2, rd2 := dial()
+		defer cn2.Close()
+		send(cn2, "PSYNC", subject.ReplID(), "68")
+		Expect(read(rd2, 73)).To(Equal("+FULLRESYNC " + subject.ReplID() + " 132\r\n$10\r\nREDIS0011\xff"))
+	})
+
+	It("should stream large snapshots", func() {
+		rdb := append([]byte("REDIS0011"), make([]byte, maxPendingSize)...)
+		subject.snap = SnapshotterFunc(func(w io.Writer) error {
+			_, err := w.Write(rdb)
+			return err
+		})
+
+		cn, rd := dial()
+		defer cn.Close()
+		send(cn, "PSYNC", "?", "-1")
+		head := "+FULLRESYNC " + subject.ReplID() + " 0\r\n$" + strconv.Itoa(len(rdb)) + "\r\n"
+		Expect(read(rd, len(head))).To(Equal(head))
+
+		// writes are sent after the snapshot
+		wcn, wrd := dial()
+		defer wcn.Close()
+		set(wcn, wrd, "key", "value")
+		Expect(read(rd, len(rdb))).To(Equal(string(rdb)))
+		Expect(read(rd, 33)).To(Equal("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"))
+		Expect(subject.ReplicationInfo().Replicas).To(HaveLen(1))
+	})
+
+	It("should resize the backlog", func() {
+		wcn, wrd := dial()
+		defer wcn.Close()
+		for i := 0; i < 2; i++ {
+			set(wcn, wrd, "key", "value")
+		}
+		Expect(subject.ReplicationInfo().Backlog).To(Equal(&BacklogInfo{Size: 64, FirstByteOffset: 3, HistLen: 64}))
+
+		subject.SetBacklogSize(33)
+		Expect(subject.ReplicationInfo().Backlog).To(Equal(&BacklogInfo{Size: 33, FirstByteOffset: 34, HistLen: 33}))
+
+		cn, rd := dial()
+		defer cn.Close()
+		send(cn, "PSYNC", subject.ReplID(), "33")
+		Expect(read(rd, 72)).To(Equal("+FULLRESYNC " + subject.ReplID() + " 66\r\n$10\r\nREDIS0011\xff"))
+	})
+
+	It("should reject invalid options", func() {
+		cn, rd := dial()
+		defer cn.Close()
+
+		send(cn, "REPLCONF", "bad", "1")
+		Expect(rd.ReadString('\n')).To(Equal("-ERR Unrecognized REPLCONF option: bad\r\n"))
+		send(cn, "REPLCONF", "listening-port")
+		Expect(rd.ReadString('\n')).To(Equal("-ERR syntax error\r\n"))
+	})
+
+	It("should feed replicas", func() {
+		var (
+			data = make(map[string]string)
+			mu   sync.Mutex
+		)
+
+		rsrv := NewServer(nil)
+		rsrv.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			mu.Lock()
+			data[c.Arg(0).String()] = c.Arg(1).String()
+			mu.Unlock()
+			w.AppendOK()
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		replica := NewReplica(rsrv, lis.Addr().String(), &ReplicaOptions{AckInterval: 10 * time.Millisecond})
+		go replica.Run(ctx)
+		Eventually(func() []ReplicaInfo { return subject.ReplicationInfo().Replicas }).Should(HaveLen(1))
+
+		wcn, wrd := dial()
+		defer wcn.Close()
+		set(wcn, wrd, "key", "value")
+
+		Eventually(func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			return data["key"]
+		}).Should(Equal("value"))
+		Eventually(replica.Offset).Should(Equal(int64(33)))
+		Eventually(func() int64 { return subject.ReplicationInfo().Replicas[0].Offset }).Should(Equal(int64(33)))
+	})
+
+	It("should wait for replicas", func() {
+		rsrv := NewServer(nil)
+		rsrv.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendOK()
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		replica := NewReplica(rsrv, lis.Addr().String(), &ReplicaOptions{AckInterval: time.Hour})
+		go replica.Run(ctx)
+		Eventually(func() []ReplicaInfo { return subject.ReplicationInfo().Replicas }).Should(HaveLen(1))
+
+		wcn, wrd := dial()
+		defer wcn.Close()
+		set(wcn, wrd, "key", "value")
+
+		// GETACK is sent to the lagging replica
+		send(wcn, "WAIT", "1", "0")
+		Expect(wrd.ReadString('\n')).To(Equal(":1\r\n"))
+		Expect(subject.Offset()).To(Equal(int64(70)))
+
+		start := time.Now()
+		send(wcn, "WAIT", "2", "50")
+		Expect(wrd.ReadString('\n')).To(Equal(":1\r\n"))
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+
+		send(wcn, "WAIT", "1", "-1")
+		Expect(wrd.ReadString('\n')).To(Equal("-ERR timeout is negative\r\n"))
+		send(wcn, "WAIT", "x", "0")
+		Expect(wrd.ReadString('\n')).To(Equal("-ERR value is not an integer or out of range\r\n"))
+	})
+
+	It("should require good replicas to write", func() {
+		subject.SetMinReplicas(1, 200*time.Millisecond)
+
+		wcn, wrd := dial()
+		defer wcn.Close()
+		send(wcn, "SET", "key", "value")
+		Expect(wrd.ReadString('\n')).To(Equal("-" + NoReplicasError + "\r\n"))
+		Expect(subject.Offset()).To(Equal(int64(0)))
+
+		rsrv := NewServer(nil)
+		rsrv.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendOK()
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		replica := NewReplica(rsrv, lis.Addr().String(), &ReplicaOptions{AckInterval: 10 * time.Millisecond})
+		go replica.Run(ctx)
+		Eventually(func() []ReplicaInfo { return subject.ReplicationInfo().Replicas }).Should(HaveLen(1))
+		set(wcn, wrd, "key", "value")
+
+		// replicas which disconnect or stop acknowledging are not counted
+		cancel()
+		Eventually(func() string {
+			send(wcn, "SET", "key", "value")
+			s, _ := wrd.ReadString('\n')
+			return s
+		}).Should(Equal("-" + NoReplicasError + "\r\n"))
+
+		subject.SetMinReplicas(0, 0)
+		set(wcn, wrd, "key", "value")
+	})
+
+})
@@ -0,0 +1,76 @@
+package redeo
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// maxSniffLen is the max number of bytes MatchRESP inspects
+const maxSniffLen = 1024
+
+// MatchRESP returns a matcher which recognises connections that start with a
+// RESP request, i.e. a multi-bulk, a bulk or an inline command. It can be
+// used with protocol multiplexers like github.com/soheilhy/cmux to share a
+// port with HTTP or gRPC; the matched listener can be passed to Serve:
+//
+//	m := cmux.New(lis)
+//	go srv.Serve(m.Match(redeo.MatchRESP()))
+//	go httpServer.Serve(m.Match(cmux.HTTP1Fast()))
+//	m.Serve()
+//
+// Inline requests which look like HTTP request lines are rejected.
+func MatchRESP() func(io.Reader) bool {
+	return matchRESP
+}
+
+func matchRESP(r io.Reader) bool {
+	line, err := bufio.NewReaderSize(io.LimitReader(r, maxSniffLen), maxSniffLen).ReadSlice('\n')
+	if err != nil {
+		return false
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	if len(line) == 0 {
+		return false
+	}
+
+	switch line[0] {
+	case '*', '$':
+		return isDigits(bytes.TrimPrefix(line[1:], []byte{'-'}))
+	}
+	return isInline(line)
+}
+
+// isInline checks if line is an inline command, starting with a word
+// of letters and not followed by an HTTP version
+func isInline(line []byte) bool {
+	name := line
+	if i := bytes.IndexByte(line, ' '); i > -1 {
+		name = line[:i]
+	}
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+
+	if i := bytes.LastIndexByte(line, ' '); i > -1 && bytes.HasPrefix(line[i+1:], []byte("HTTP/")) {
+		return false
+	}
+	return true
+}
+
+func isDigits(p []byte) bool {
+	if len(p) == 0 {
+		return false
+	}
+	for _, c := range p {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,30 @@
+package redeo
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("MatchRESP",
+	func(s string, exp bool) {
+		Expect(MatchRESP()(strings.NewReader(s))).To(Equal(exp))
+	},
+
+	Entry("multi-bulk", "*1\r\n$4\r\nPING\r\n", true),
+	Entry("blank multi-bulk", "*0\r\n", true),
+	Entry("bulk", "$4\r\nPING\r\n", true),
+	Entry("inline", "PING\r\n", true),
+	Entry("inline with args", "SET key value\r\n", true),
+	Entry("inline without CR", "PING\n", true),
+
+	Entry("empty", "", false),
+	Entry("incomplete", "*1", false),
+	Entry("bad multi-bulk", "*x\r\n", false),
+	Entry("blank line", "\r\n", false),
+	Entry("HTTP/1", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", false),
+	Entry("HTTP/2", "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", false),
+	Entry("TLS", "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03\n", false),
+	Entry("too long", strings.Repeat("x", 2000)+"\r\n", false),
+)
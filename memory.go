@@ -0,0 +1,155 @@
+package redeo
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// DefaultMemorySamples is the default number of elements sampled by
+// MEMORY USAGE
+const DefaultMemorySamples = 5
+
+// MemoryReporter is implemented by storage modules which report
+// their memory usage to MEMORY
+type MemoryReporter interface {
+	// MemoryUsage returns the number of bytes used by the key and its
+	// value, or false if the key does not exist. The size of aggregate
+	// values may be estimated from the given number of sampled elements,
+	// 0 requests an exact count.
+	MemoryUsage(key string, samples int) (int64, bool)
+
+	// DatasetSize returns the number of keys and the number of bytes
+	// used by them
+	DatasetSize() (keys, bytes int64)
+}
+
+// Memory returns a MEMORY handler, which supports the USAGE, STATS and
+// DOCTOR sub-commands. STATS and DOCTOR combine the dataset size
+// reported by r with the heap statistics of the Go runtime.
+// https://redis.io/commands/memory-stats
+func Memory(r MemoryReporter) Handler {
+	return SubCommands{
+		"usage":  memoryUsage(r),
+		"stats":  memoryStats(r),
+		"doctor": memoryDoctor(),
+	}
+}
+
+func memoryUsage(r MemoryReporter) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 && c.ArgN() != 3 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		samples := DefaultMemorySamples
+		if c.ArgN() == 3 {
+			if !strings.EqualFold(c.Arg(1).String(), "samples") {
+				w.AppendError("ERR syntax error")
+				return
+			}
+			n, err := strconv.Atoi(c.Arg(2).String())
+			if err != nil || n < 0 {
+				w.AppendError("ERR value is not an integer or out of range")
+				return
+			}
+			samples = n
+		}
+
+		n, ok := r.MemoryUsage(c.Arg(0).String(), samples)
+		if !ok {
+			w.AppendNil()
+			return
+		}
+		w.AppendInt(n)
+	})
+}
+
+func memoryStats(r MemoryReporter) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+
+		keys, dataset := r.DatasetSize()
+		allocated := int64(ms.HeapAlloc)
+		overhead := allocated - dataset
+		if overhead < 0 {
+			overhead = 0
+		}
+
+		var perKey, percentage float64
+		if keys > 0 {
+			perKey = float64(allocated) / float64(keys)
+		}
+		if allocated > 0 {
+			percentage = float64(dataset) * 100 / float64(allocated)
+		}
+
+		w.AppendArrayLen(20)
+		w.AppendBulkString("total.allocated")
+		w.AppendInt(allocated)
+		w.AppendBulkString("overhead.total")
+		w.AppendInt(overhead)
+		w.AppendBulkString("keys.count")
+		w.AppendInt(keys)
+		w.AppendBulkString("keys.bytes-per-key")
+		w.AppendInt(int64(perKey))
+		w.AppendBulkString("dataset.bytes")
+		w.AppendInt(dataset)
+		w.AppendBulkString("dataset.percentage")
+		w.AppendBulkString(strconv.FormatFloat(percentage, 'f', -1, 64))
+		w.AppendBulkString("allocator.allocated")
+		w.AppendInt(allocated)
+		w.AppendBulkString("allocator.active")
+		w.AppendInt(int64(ms.HeapInuse))
+		w.AppendBulkString("allocator.resident")
+		w.AppendInt(int64(ms.HeapSys - ms.HeapReleased))
+		w.AppendBulkString("allocator-fragmentation.ratio")
+		w.AppendBulkString(strconv.FormatFloat(heapFragmentation(&ms), 'f', 3, 64))
+	})
+}
+
+// memoryDoctorMinHeap is the heap size below which MEMORY DOCTOR
+// does not report issues
+const memoryDoctorMinHeap = 5 * 1024 * 1024
+
+func memoryDoctor() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		w.AppendBulkString(memoryReport(&ms))
+	})
+}
+
+func memoryReport(ms *runtime.MemStats) string {
+	if ms.HeapAlloc < memoryDoctorMinHeap {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting.\n"
+	}
+	if ratio := heapFragmentation(ms); ratio > 1.4 {
+		return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" +
+			" * High allocator fragmentation: This instance has an allocator fragmentation greater than 1.4 (" + strconv.FormatFloat(ratio, 'f', 2, 64) + "), the Go heap holds much more memory in use than the live objects require. This is usually temporary after many values were deleted.\n\n" +
+			"I'm here to keep you safe, Sam. I want to help you.\n"
+	}
+	return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base.\n"
+}
+
+// heapFragmentation returns the ratio of in-use heap spans to live objects
+func heapFragmentation(ms *runtime.MemStats) float64 {
+	if ms.HeapAlloc == 0 {
+		return 0
+	}
+	return float64(ms.HeapInuse) / float64(ms.HeapAlloc)
+}
@@ -0,0 +1,73 @@
+package redeo
+
+import (
+	"runtime"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory", func() {
+	var samples int
+	subject := Memory(&mockMemoryReporter{samples: &samples})
+
+	var call = func(name string, args ...string) interface{} {
+		cmd := resp.NewCommand(name)
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd)
+		v, _ := w.Response()
+		return v
+	}
+
+	It("should serve MEMORY USAGE", func() {
+		Expect(call("MEMORY", "usage", "key")).To(Equal(int64(56)))
+		Expect(samples).To(Equal(DefaultMemorySamples))
+		Expect(call("MEMORY", "usage", "key", "SAMPLES", "0")).To(Equal(int64(56)))
+		Expect(samples).To(Equal(0))
+		Expect(call("MEMORY", "usage", "missing")).To(BeNil())
+
+		Expect(call("MEMORY", "usage", "key", "SAMPLES", "-1")).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(call("MEMORY", "usage", "key", "BAD", "1")).To(MatchError("ERR syntax error"))
+		Expect(call("MEMORY", "usage")).To(MatchError("ERR wrong number of arguments for 'MEMORY usage' command"))
+	})
+
+	It("should serve MEMORY STATS", func() {
+		res := call("MEMORY", "stats")
+		Expect(res).To(HaveLen(20))
+
+		stats := make(map[string]interface{})
+		for i, v := range res.([]interface{}) {
+			if i%2 == 1 {
+				stats[res.([]interface{})[i-1].(string)] = v
+			}
+		}
+		Expect(stats).To(HaveKeyWithValue("keys.count", int64(4)))
+		Expect(stats).To(HaveKeyWithValue("dataset.bytes", int64(1024)))
+		Expect(stats["total.allocated"]).To(BeNumerically(">", 1024))
+		Expect(stats["dataset.percentage"]).To(MatchRegexp(`^\d+(\.\d+)?$`))
+		Expect(call("MEMORY", "stats", "x")).To(MatchError("ERR wrong number of arguments for 'MEMORY stats' command"))
+	})
+
+	It("should serve MEMORY DOCTOR", func() {
+		Expect(call("MEMORY", "doctor")).To(ContainSubstring("Sam"))
+
+		Expect(memoryReport(&runtime.MemStats{HeapAlloc: 1024})).To(ContainSubstring("very little memory"))
+		Expect(memoryReport(&runtime.MemStats{HeapAlloc: 10 << 20, HeapInuse: 11 << 20})).To(ContainSubstring("can't find any memory issue"))
+		Expect(memoryReport(&runtime.MemStats{HeapAlloc: 10 << 20, HeapInuse: 20 << 20})).To(ContainSubstring("High allocator fragmentation"))
+	})
+
+})
+
+type mockMemoryReporter struct{ samples *int }
+
+func (m *mockMemoryReporter) MemoryUsage(key string, samples int) (int64, bool) {
+	*m.samples = samples
+	return 56, key == "key"
+}
+
+func (m *mockMemoryReporter) DatasetSize() (int64, int64) { return 4, 1024 }
@@ -0,0 +1,133 @@
+package redeo
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// ObjectInfo describes the internals of a stored value
+type ObjectInfo struct {
+	// Encoding is the internal representation, e.g. "int", "embstr",
+	// "listpack" or "hashtable"
+	Encoding string
+
+	// RefCount is the number of references to the value.
+	// Default: 1
+	RefCount int64
+
+	// IdleTime is the time since the value was last accessed, negative
+	// if access times are not tracked
+	IdleTime time.Duration
+
+	// Freq is the logarithmic access frequency counter, negative if
+	// access frequencies are not tracked
+	Freq int64
+
+	// SerializedLength is the size of the serialized value in bytes
+	SerializedLength int64
+}
+
+// Introspector is implemented by storage modules which expose
+// the internals of stored values to OBJECT and DEBUG OBJECT
+type Introspector interface {
+	// Inspect returns the info of the value stored at key, or false if
+	// the key does not exist
+	Inspect(key string) (*ObjectInfo, bool)
+}
+
+// IntrospectorFunc is a function which implements Introspector
+type IntrospectorFunc func(key string) (*ObjectInfo, bool)
+
+// Inspect implements Introspector
+func (f IntrospectorFunc) Inspect(key string) (*ObjectInfo, bool) { return f(key) }
+
+// Object returns an OBJECT handler, which supports the ENCODING, REFCOUNT,
+// IDLETIME and FREQ sub-commands.
+// https://redis.io/commands/object
+func Object(in Introspector) Handler {
+	return SubCommands{
+		"encoding": objectHandler(in, func(w resp.ResponseWriter, oi *ObjectInfo) {
+			w.AppendBulkString(oi.Encoding)
+		}),
+		"refcount": objectHandler(in, func(w resp.ResponseWriter, oi *ObjectInfo) {
+			w.AppendInt(refCount(oi))
+		}),
+		"idletime": objectHandler(in, func(w resp.ResponseWriter, oi *ObjectInfo) {
+			if oi.IdleTime < 0 {
+				w.AppendError("ERR An LRU maxmemory policy is not selected, access time not tracked.")
+				return
+			}
+			w.AppendInt(int64(oi.IdleTime / time.Second))
+		}),
+		"freq": objectHandler(in, func(w resp.ResponseWriter, oi *ObjectInfo) {
+			if oi.Freq < 0 {
+				w.AppendError("ERR An LFU maxmemory policy is not selected, access frequency not tracked.")
+				return
+			}
+			w.AppendInt(oi.Freq)
+		}),
+	}
+}
+
+func objectHandler(in Introspector, fn func(resp.ResponseWriter, *ObjectInfo)) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		oi, ok := in.Inspect(c.Arg(0).String())
+		if !ok {
+			w.AppendNil()
+			return
+		}
+		fn(w, oi)
+	})
+}
+
+// DebugObject returns a DEBUG OBJECT handler, for use as a sub-command
+// of DEBUG, e.g.:
+//
+//   srv.Handle("debug", redeo.SubCommands{
+//     "object": redeo.DebugObject(store),
+//   })
+//
+// https://redis.io/commands/debug-object
+func DebugObject(in Introspector) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		oi, ok := in.Inspect(c.Arg(0).String())
+		if !ok {
+			w.AppendError("ERR no such key")
+			return
+		}
+
+		idle := int64(0)
+		if oi.IdleTime > 0 {
+			idle = int64(oi.IdleTime / time.Second)
+		}
+
+		// lru is the 24-bit LRU clock at the time of last access
+		lru := (time.Now().Unix() - idle) & (1<<24 - 1)
+
+		w.AppendInlineString("Value at:0x0" +
+			" refcount:" + strconv.FormatInt(refCount(oi), 10) +
+			" encoding:" + oi.Encoding +
+			" serializedlength:" + strconv.FormatInt(oi.SerializedLength, 10) +
+			" lru:" + strconv.FormatInt(lru, 10) +
+			" lru_seconds_idle:" + strconv.FormatInt(idle, 10))
+	})
+}
+
+func refCount(oi *ObjectInfo) int64 {
+	if oi.RefCount < 1 {
+		return 1
+	}
+	return oi.RefCount
+}
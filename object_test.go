@@ -0,0 +1,57 @@
+package redeo
+
+import (
+	"time"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Object", func() {
+	var in = IntrospectorFunc(func(key string) (*ObjectInfo, bool) {
+		switch key {
+		case "str":
+			return &ObjectInfo{Encoding: "embstr", IdleTime: 10 * time.Second, Freq: 5, SerializedLength: 4}, true
+		case "untracked":
+			return &ObjectInfo{Encoding: "int", RefCount: 3, IdleTime: -1, Freq: -1}, true
+		}
+		return nil, false
+	})
+
+	var call = func(h Handler, name string, args ...string) interface{} {
+		cmd := resp.NewCommand(name)
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		w := redeotest.NewRecorder()
+		h.ServeRedeo(w, cmd)
+		v, _ := w.Response()
+		return v
+	}
+
+	It("should serve OBJECT", func() {
+		subject := Object(in)
+
+		Expect(call(subject, "OBJECT", "encoding", "str")).To(Equal("embstr"))
+		Expect(call(subject, "OBJECT", "refcount", "str")).To(Equal(int64(1)))
+		Expect(call(subject, "OBJECT", "refcount", "untracked")).To(Equal(int64(3)))
+		Expect(call(subject, "OBJECT", "idletime", "str")).To(Equal(int64(10)))
+		Expect(call(subject, "OBJECT", "freq", "str")).To(Equal(int64(5)))
+		Expect(call(subject, "OBJECT", "encoding", "missing")).To(BeNil())
+
+		Expect(call(subject, "OBJECT", "idletime", "untracked")).To(MatchError("ERR An LRU maxmemory policy is not selected, access time not tracked."))
+		Expect(call(subject, "OBJECT", "freq", "untracked")).To(MatchError("ERR An LFU maxmemory policy is not selected, access frequency not tracked."))
+		Expect(call(subject, "OBJECT", "encoding")).To(MatchError("ERR wrong number of arguments for 'OBJECT encoding' command"))
+	})
+
+	It("should serve DEBUG OBJECT", func() {
+		subject := DebugObject(in)
+
+		Expect(call(subject, "DEBUG OBJECT", "str")).To(MatchRegexp(`^Value at:0x0 refcount:1 encoding:embstr serializedlength:4 lru:\d+ lru_seconds_idle:10$`))
+		Expect(call(subject, "DEBUG OBJECT", "untracked")).To(MatchRegexp(`^Value at:0x0 refcount:3 encoding:int serializedlength:0 lru:\d+ lru_seconds_idle:0$`))
+		Expect(call(subject, "DEBUG OBJECT", "missing")).To(MatchError("ERR no such key"))
+	})
+
+})
@@ -0,0 +1,159 @@
+package redeo
+
+import (
+	"context"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Propagator receives the write commands executed by a server, e.g. to
+// feed replicas or an append-only journal
+type Propagator interface {
+	// Propagate is called with every command registered via HandleWrite
+	// after it was executed, or with the commands it was rewritten as,
+	// see Rewrite. Write commands are serialised while propagators are
+	// attached, except while they wait, see Wait, so commands are
+	// propagated in the order they were executed. The command must not be
+	// retained after the call returns.
+	Propagate(cmd *resp.Command)
+}
+
+// WriteChecker can be implemented by propagators to reject write commands
+// before they are executed, e.g. Master rejects writes while not enough
+// replicas are attached
+type WriteChecker interface {
+	// CheckWrite returns an error message to reject cmd with, or an
+	// empty string to accept it
+	CheckWrite(cmd *resp.Command) string
+}
+
+// ctxKeyPropagation holds the *Propagation of a command in its context
+type ctxKeyPropagation struct{}
+
+// Propagation records the rewrites of a command, see Rewrite
+type Propagation struct {
+	rewritten bool
+	cmds      []*resp.Command
+
+	// srv is set for commands which hold the server's write lock,
+	// waiting while they release it, see Wait
+	srv     *Server
+	waiting bool
+}
+
+// WithPropagation returns a copy of ctx in which rewrites are recorded to
+// the returned Propagation. Write commands are served with such contexts
+// while propagators are attached. It can also be used to call the
+// handlers of write commands directly, e.g. when executing transactions,
+// and propagate their rewritten forms.
+func WithPropagation(ctx context.Context) (context.Context, *Propagation) {
+	p := new(Propagation)
+	return context.WithValue(ctx, ctxKeyPropagation{}, p), p
+}
+
+// Commands returns the commands to propagate in place of cmd: cmd
+// itself, unless it was rewritten
+func (p *Propagation) Commands(cmd *resp.Command) []*resp.Command {
+	if p.rewritten {
+		return p.cmds
+	}
+	return []*resp.Command{cmd}
+}
+
+// Rewrite replaces the form in which the command of ctx is propagated to
+// replicas and journals with cmds, so commands which are not deterministic
+// are applied consistently, e.g. SPOP can be propagated as SREM of the
+// popped members or EXPIRE as PEXPIREAT. Without cmds, the command is not
+// propagated at all, repeated calls append further commands. Commands are
+// copied. It reports false and has no effect unless ctx is the context of
+// a command which is propagated, see WithPropagation.
+func Rewrite(ctx context.Context, cmds ...*resp.Command) bool {
+	p, ok := ctx.Value(ctxKeyPropagation{}).(*Propagation)
+	if !ok {
+		return false
+	}
+
+	copies := make([]*resp.Command, 0, len(cmds))
+	for _, cmd := range cmds {
+		args := make([]resp.CommandArgument, 0, len(cmd.Args))
+		for _, arg := range cmd.Args {
+			args = append(args, append(resp.CommandArgument(nil), arg...))
+		}
+		copies = append(copies, resp.NewCommand(cmd.Name, args...))
+	}
+
+	if p.srv != nil {
+		p.srv.dmu.Lock()
+		defer p.srv.dmu.Unlock()
+	}
+	if !p.rewritten {
+		p.rewritten, p.cmds = true, nil
+	}
+	if p.waiting {
+		p.srv.deferred = append(p.srv.deferred, copies...)
+	} else {
+		p.cmds = append(p.cmds, copies...)
+	}
+	return true
+}
+
+// Wait calls fn, which waits for the writes of other clients, e.g. until
+// a key blocked on by BLPOP is written. Write commands are serialised
+// while propagators are attached, so write commands which block must wait
+// via Wait to let the others proceed. Rewrites of the command of ctx
+// recorded while fn runs, e.g. by the write which served it, are
+// propagated after that write, rather than after the command.
+func Wait(ctx context.Context, fn func()) {
+	p, ok := ctx.Value(ctxKeyPropagation{}).(*Propagation)
+	if !ok || p.srv == nil {
+		fn()
+		return
+	}
+
+	p.srv.dmu.Lock()
+	p.waiting = true
+	p.srv.dmu.Unlock()
+	p.srv.wmu.Unlock()
+
+	defer func() {
+		p.srv.wmu.Lock()
+		p.srv.dmu.Lock()
+		p.waiting = false
+		p.srv.dmu.Unlock()
+	}()
+	fn()
+}
+
+// AddPropagator attaches a propagator to the server. Commands executed via
+// Apply, e.g. by replicas, and streaming write handlers are not
+// propagated.
+func (srv *Server) AddPropagator(p Propagator) {
+	srv.mu.Lock()
+	props := make([]Propagator, 0, len(srv.props)+1)
+	srv.props = append(append(props, srv.props...), p)
+	srv.mu.Unlock()
+}
+
+// RemovePropagator detaches a propagator from the server, propagators
+// must be comparable
+func (srv *Server) RemovePropagator(p Propagator) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	props := make([]Propagator, 0, len(srv.props))
+	for _, q := range srv.props {
+		if q != p {
+			props = append(props, q)
+		}
+	}
+	srv.props = props
+}
+
+// appendCmd encodes cmd to w
+func appendCmd(w *resp.RequestWriter, cmd *resp.Command) {
+	_ = w.WriteMultiBulkSize(len(cmd.Args) + 1)
+	w.WriteBulkString(cmd.Name)
+	for _, arg := range cmd.Args {
+		w.WriteBulk(arg)
+	}
+}
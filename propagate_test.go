@@ -0,0 +1,97 @@
+package redeo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingPropagator records propagated commands
+type recordingPropagator struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (p *recordingPropagator) Propagate(cmd *resp.Command) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := cmd.Name
+	for _, arg := range cmd.Args {
+		s += " " + arg.String()
+	}
+	p.cmds = append(p.cmds, s)
+}
+
+func (p *recordingPropagator) Commands() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cmds
+}
+
+var _ = Describe("Propagator", func() {
+	var srv *Server
+	var subject *recordingPropagator
+
+	BeforeEach(func() {
+		srv = NewServer(nil)
+		srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+			Expect(Rewrite(c.Context())).To(BeFalse())
+			w.AppendNil()
+		})
+		srv.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendOK()
+		})
+		srv.HandleWriteFunc("spop", func(w resp.ResponseWriter, c *resp.Command) {
+			member := resp.CommandArgument("m")
+			if !Rewrite(c.Context(), resp.NewCommand("SREM", c.Arg(0), member)) {
+				w.AppendError("ERR not propagated")
+				return
+			}
+			w.AppendBulk(member)
+		})
+		srv.HandleWriteFunc("noop", func(w resp.ResponseWriter, c *resp.Command) {
+			Rewrite(c.Context())
+			w.AppendOK()
+		})
+
+		subject = new(recordingPropagator)
+		srv.AddPropagator(subject)
+	})
+
+	It("should propagate write commands", func() {
+		Expect(redeotest.Transcript(srv, "SET k v\r\n", "GET k\r\n", "SET x y\r\n")).To(Equal([]byte("+OK\r\n$-1\r\n+OK\r\n")))
+		Expect(subject.Commands()).To(Equal([]string{"SET k v", "SET x y"}))
+
+		srv.RemovePropagator(subject)
+		Expect(redeotest.Transcript(srv, "SET k w\r\n")).To(Equal([]byte("+OK\r\n")))
+		Expect(subject.Commands()).To(HaveLen(2))
+	})
+
+	It("should propagate rewritten commands", func() {
+		Expect(redeotest.Transcript(srv, "SPOP s\r\n", "NOOP\r\n", "SET k v\r\n")).To(Equal([]byte("$1\r\nm\r\n+OK\r\n+OK\r\n")))
+		Expect(subject.Commands()).To(Equal([]string{"SREM s m", "SET k v"}))
+	})
+
+	It("should record rewrites", func() {
+		cmd := resp.NewCommand("SPOP", resp.CommandArgument("s"))
+		Expect(Rewrite(cmd.Context())).To(BeFalse())
+
+		ctx, p := WithPropagation(context.Background())
+		Expect(p.Commands(cmd)).To(Equal([]*resp.Command{cmd}))
+
+		arg := resp.CommandArgument("a")
+		Expect(Rewrite(ctx, resp.NewCommand("SREM", arg))).To(BeTrue())
+		Expect(Rewrite(ctx, resp.NewCommand("SREM", resp.CommandArgument("b")))).To(BeTrue())
+		arg[0] = 'x'
+		Expect(p.Commands(cmd)).To(Equal([]*resp.Command{
+			resp.NewCommand("SREM", resp.CommandArgument("a")),
+			resp.NewCommand("SREM", resp.CommandArgument("b")),
+		}))
+	})
+
+})
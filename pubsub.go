@@ -1,55 +1,276 @@
 package redeo
 
 import (
+	"errors"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 
+	"github.com/johntech-o/redeo/info"
 	"github.com/johntech-o/redeo/resp"
 )
 
+// PubSubOverflow is the policy applied when the buffer of a
+// subscriber is full
+type PubSubOverflow int
+
+const (
+	// DropNewest discards the message being published
+	DropNewest PubSubOverflow = iota
+	// DropOldest discards the oldest buffered message
+	DropOldest
+	// Disconnect discards all buffered messages and disconnects
+	// the subscriber
+	Disconnect
+)
+
+// PubSubOptions configure a PubSubBroker
+type PubSubOptions struct {
+	// BufferSize is the maximum number of messages buffered per
+	// subscriber. If positive, messages are delivered asynchronously and
+	// publishers are never blocked by slow subscribers.
+	// Default: 0 (messages are written by the publisher)
+	BufferSize int
+
+	// Overflow is the policy applied when a buffer is full.
+	// Default: DropNewest
+	Overflow PubSubOverflow
+}
+
+func (o *PubSubOptions) norm() {
+	if o.BufferSize < 0 {
+		o.BufferSize = 0
+	}
+}
+
+var errSubscriberGone = errors.New("redeo: subscriber disconnected")
+
 // PubSubBroker can be used to emulate redis'
-// native pub/sub functionality
+// native pub/sub functionality. Shard channels, as used by
+// SSUBSCRIBE and SPUBLISH, live in a separate namespace. Clients which
+// switched to RESP3 via HELLO receive messages as push frames.
 type PubSubBroker struct {
+	opt      PubSubOptions
 	channels map[string]*pubSubChannel
+	shards   map[string]*pubSubChannel
+	queues   map[pubSubSubscriber]*pubSubQueue
 	mu       sync.RWMutex
+
+	dropped *info.IntValue
 }
 
 // NewPubSubBroker inits a new pub-sub broker
 func NewPubSubBroker() *PubSubBroker {
+	return NewPubSubBrokerWithOptions(nil)
+}
+
+// NewPubSubBrokerWithOptions inits a new pub-sub broker with
+// custom options
+func NewPubSubBrokerWithOptions(opt *PubSubOptions) *PubSubBroker {
+	var o PubSubOptions
+	if opt != nil {
+		o = *opt
+	}
+	o.norm()
+
 	return &PubSubBroker{
+		opt:      o,
 		channels: make(map[string]*pubSubChannel),
+		shards:   make(map[string]*pubSubChannel),
+		queues:   make(map[pubSubSubscriber]*pubSubQueue),
+		dropped:  info.NewIntValue(0),
 	}
 }
 
+// Dropped returns the number of messages dropped due to full subscriber
+// buffers. The returned value can be registered with the server info, e.g.:
+//
+//   srv.Info().Fetch("Stats").Register("pubsub_dropped_messages", broker.Dropped())
+func (b *PubSubBroker) Dropped() *info.IntValue { return b.dropped }
+
 // Subscribe returns a subscribe handler
 func (b *PubSubBroker) Subscribe() Handler {
+	return b.subscribeHandler(b.channels, "subscribe")
+}
+
+// SSubscribe returns a shard channel subscribe handler
+// https://redis.io/commands/ssubscribe
+func (b *PubSubBroker) SSubscribe() Handler {
+	return b.subscribeHandler(b.shards, "ssubscribe")
+}
+
+// Publish acts as a publish handler
+func (b *PubSubBroker) Publish() Handler {
+	return publishHandler(b.PublishMessage)
+}
+
+// SPublish acts as a shard channel publish handler
+// https://redis.io/commands/spublish
+func (b *PubSubBroker) SPublish() Handler {
+	return publishHandler(b.PublishShardMessage)
+}
+
+// PubSub returns a PUBSUB handler, which supports the CHANNELS, NUMSUB,
+// NUMPAT, SHARDCHANNELS and SHARDNUMSUB sub-commands. As the broker has no
+// pattern subscriptions, NUMPAT always reports zero.
+// https://redis.io/commands/pubsub
+func (b *PubSubBroker) PubSub() Handler {
+	return SubCommands{
+		"channels": b.channelsHandler(b.channels),
+		"numsub":   b.numSubHandler(b.channels),
+		"numpat": HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+			if c.ArgN() != 0 {
+				w.AppendError(WrongNumberOfArgs(c.Name))
+				return
+			}
+			w.AppendInt(0)
+		}),
+		"shardchannels": b.channelsHandler(b.shards),
+		"shardnumsub":   b.numSubHandler(b.shards),
+	}
+}
+
+// PublishMessage allows to publish a message to the broker
+// outside the command-cycle. Returns the number of subscribers
+func (b *PubSubBroker) PublishMessage(name, msg string) int64 {
+	return b.publish(b.channels, name, msg)
+}
+
+// PublishShardMessage publishes a message to a shard channel
+// outside the command-cycle. Returns the number of subscribers
+func (b *PubSubBroker) PublishShardMessage(name, msg string) int64 {
+	return b.publish(b.shards, name, msg)
+}
+
+// PublishKeyspaceEvent publishes a keyspace notification, e.g. the
+// "expired" or "evicted" event of a key in db, to the __keyspace@<db>__:<key>
+// and __keyevent@<db>__:<event> channels. Returns the number of subscribers.
+// https://redis.io/docs/manual/keyspace-notifications/
+func (b *PubSubBroker) PublishKeyspaceEvent(db int, event, key string) int64 {
+	suffix := "@" + strconv.Itoa(db) + "__:"
+	n := b.publish(b.channels, "__keyspace"+suffix+key, event)
+	n += b.publish(b.channels, "__keyevent"+suffix+event, key)
+	return n
+}
+
+// ResetClient implements ClientResetter and removes all subscriptions of
+// the client. Brokers should be registered via Server.OnDisconnect, so
+// subscriptions are also removed when clients disconnect.
+func (b *PubSubBroker) ResetClient(c *Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ch := range b.channels {
+		ch.Unsubscribe(c)
+	}
+	for _, ch := range b.shards {
+		ch.Unsubscribe(c)
+	}
+	for key, q := range b.queues {
+		if key.c == c {
+			q.Close()
+			delete(b.queues, key)
+		}
+	}
+}
+
+func (b *PubSubBroker) subscribeHandler(channels map[string]*pubSubChannel, kind string) Handler {
 	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
 		if c.ArgN() != 1 {
 			w.AppendError(WrongNumberOfArgs(c.Name))
 			return
 		}
-		b.subscribe(c.Arg(0).String(), w)
+
+		client := GetClient(c.Context())
+		if client != nil {
+			client.markPubSub()
+		}
+		b.subscribe(channels, kind, c.Arg(0).String(), subscription{pubSubSubscriber{w: w, c: client}, nil})
 	})
 }
 
-// Publish acts as a publish handler
-func (b *PubSubBroker) Publish() Handler {
+// removeQueue removes q and the subscriptions delivered through it, once
+// it was closed
+func (b *PubSubBroker) removeQueue(q *pubSubQueue) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.queues[q.sub] == q {
+		delete(b.queues, q.sub)
+	}
+	for _, ch := range b.channels {
+		ch.unsubscribeQueue(q)
+	}
+	for _, ch := range b.shards {
+		ch.unsubscribeQueue(q)
+	}
+}
+
+func publishHandler(fn func(name, msg string) int64) Handler {
 	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
 		if c.ArgN() != 2 {
 			w.AppendError(WrongNumberOfArgs(c.Name))
 			return
 		}
 
-		n := b.PublishMessage(c.Arg(0).String(), c.Arg(1).String())
+		n := fn(c.Arg(0).String(), c.Arg(1).String())
 		w.AppendInt(n)
 	})
 }
 
-// PublishMessage allows to publish a message to the broker
-// outside the command-cycle. Returns the number of subscribers
-func (b *PubSubBroker) PublishMessage(name, msg string) int64 {
+func (b *PubSubBroker) channelsHandler(channels map[string]*pubSubChannel) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() > 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		var pattern string
+		if c.ArgN() == 1 {
+			pattern = c.Arg(0).String()
+		}
+
+		b.mu.RLock()
+		names := make([]string, 0, len(channels))
+		for name, ch := range channels {
+			if ch.Len() != 0 && (pattern == "" || GlobMatch(pattern, name)) {
+				names = append(names, name)
+			}
+		}
+		b.mu.RUnlock()
+		sort.Strings(names)
+
+		w.AppendArrayLen(len(names))
+		for _, name := range names {
+			w.AppendBulkString(name)
+		}
+	})
+}
+
+func (b *PubSubBroker) numSubHandler(channels map[string]*pubSubChannel) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		w.AppendArrayLen(c.ArgN() * 2)
+		for _, arg := range c.Args {
+			name := arg.String()
+
+			b.mu.RLock()
+			ch, ok := channels[name]
+			b.mu.RUnlock()
+
+			w.AppendBulkString(name)
+			if ok {
+				w.AppendInt(int64(ch.Len()))
+			} else {
+				w.AppendInt(0)
+			}
+		}
+	})
+}
+
+func (b *PubSubBroker) publish(channels map[string]*pubSubChannel, name, msg string) int64 {
 	b.mu.RLock()
-	ch, ok := b.channels[name]
+	ch, ok := channels[name]
 	b.mu.RUnlock()
 
 	if ok {
@@ -58,42 +279,114 @@ func (b *PubSubBroker) PublishMessage(name, msg string) int64 {
 	return 0
 }
 
-func (b *PubSubBroker) subscribe(name string, w resp.ResponseWriter) {
+func (b *PubSubBroker) subscribe(channels map[string]*pubSubChannel, kind, name string, sub subscription) {
 	b.mu.RLock()
-	ch, ok := b.channels[name]
+	ch, ok := channels[name]
+	if b.opt.BufferSize > 0 {
+		sub.q = b.queues[sub.pubSubSubscriber]
+	}
 	b.mu.RUnlock()
 
-	if !ok {
+	if !ok || (b.opt.BufferSize > 0 && sub.q == nil) {
 		b.mu.Lock()
-		if ch, ok = b.channels[name]; !ok {
+		if b.opt.BufferSize > 0 {
+			if sub.q = b.queues[sub.pubSubSubscriber]; sub.q == nil {
+				sub.q = newPubSubQueue(sub.pubSubSubscriber, &b.opt, b.dropped, b.removeQueue)
+				b.queues[sub.pubSubSubscriber] = sub.q
+			}
+		}
+		if ch, ok = channels[name]; !ok {
 			ch = &pubSubChannel{
-				subscribers: make(map[int64]resp.ResponseWriter),
+				subscribers: make(map[int64]subscription),
+				message:     "message",
+			}
+			if kind == "ssubscribe" {
+				ch.message = "smessage"
 			}
-			b.channels[name] = ch
+			channels[name] = ch
 		}
 		b.mu.Unlock()
 	}
 
-	ch.Subscribe(w)
-	w.AppendArrayLen(3)
-	w.AppendBulkString("subscribe")
-	w.AppendBulkString(name)
-	w.AppendInt(1)
+	ch.Subscribe(sub)
+	resp.AppendPushLen(sub.w, 3)
+	sub.w.AppendBulkString(kind)
+	sub.w.AppendBulkString(name)
+	sub.w.AppendInt(1)
 }
 
 // --------------------------------------------------------------------
 
+// pubSubSubscriber is subscribed via the writer of a client. If the
+// client is known, messages are pushed via WriteFrame
+type pubSubSubscriber struct {
+	w resp.ResponseWriter
+	c *Client
+}
+
+// subscription delivers through the buffer of the subscriber, if
+// buffering is enabled
+type subscription struct {
+	pubSubSubscriber
+	q *pubSubQueue
+}
+
+// deliver reports whether the message was accepted
+func (s subscription) deliver(fn func(resp.ResponseWriter)) (bool, error) {
+	if s.q != nil {
+		return s.q.Push(fn)
+	}
+	return true, s.push(fn)
+}
+
+func (s pubSubSubscriber) push(fn func(resp.ResponseWriter)) error {
+	if s.c != nil {
+		return s.c.WriteFrame(fn)
+	}
+
+	fn(s.w)
+	return s.w.Flush()
+}
+
 type pubSubChannel struct {
-	subscribers map[int64]resp.ResponseWriter
+	subscribers map[int64]subscription
+	message     string
 	mu          sync.RWMutex
 	nextID      int64
 }
 
-func (c *pubSubChannel) Subscribe(w resp.ResponseWriter) {
+func (c *pubSubChannel) Len() int {
+	c.mu.RLock()
+	n := len(c.subscribers)
+	c.mu.RUnlock()
+	return n
+}
+
+func (c *pubSubChannel) Subscribe(sub subscription) {
 	sid := atomic.AddInt64(&c.nextID, 1)
 
 	c.mu.Lock()
-	c.subscribers[sid] = w
+	c.subscribers[sid] = sub
+	c.mu.Unlock()
+}
+
+func (c *pubSubChannel) Unsubscribe(client *Client) {
+	c.mu.Lock()
+	for sid, sub := range c.subscribers {
+		if sub.c == client {
+			delete(c.subscribers, sid)
+		}
+	}
+	c.mu.Unlock()
+}
+
+func (c *pubSubChannel) unsubscribeQueue(q *pubSubQueue) {
+	c.mu.Lock()
+	for sid, sub := range c.subscribers {
+		if sub.q == q {
+			delete(c.subscribers, sid)
+		}
+	}
 	c.mu.Unlock()
 }
 
@@ -101,15 +394,17 @@ func (c *pubSubChannel) Publish(name, msg string) (n int64) {
 	var failed []int64
 
 	c.mu.RLock()
-	for sid, w := range c.subscribers {
-		w.AppendArrayLen(3)
-		w.AppendBulkString("message")
-		w.AppendBulkString(name)
-		w.AppendBulkString(msg)
+	for sid, sub := range c.subscribers {
+		ok, err := sub.deliver(func(w resp.ResponseWriter) {
+			resp.AppendPushLen(w, 3)
+			w.AppendBulkString(c.message)
+			w.AppendBulkString(name)
+			w.AppendBulkString(msg)
+		})
 
-		if err := w.Flush(); err != nil {
+		if err != nil {
 			failed = append(failed, sid)
-		} else {
+		} else if ok {
 			n++
 		}
 	}
@@ -128,3 +423,108 @@ func (c *pubSubChannel) evict(failed []int64) {
 	}
 	c.mu.Unlock()
 }
+
+// --------------------------------------------------------------------
+
+// pubSubQueue buffers messages of a subscriber, which are written
+// by a separate goroutine. Once closed, e.g. as the subscriber is gone,
+// the goroutine exits and calls done.
+type pubSubQueue struct {
+	sub      pubSubSubscriber
+	size     int
+	overflow PubSubOverflow
+	dropped  *info.IntValue
+	done     func(*pubSubQueue)
+
+	msgs   []func(resp.ResponseWriter)
+	closed bool
+	wake   chan struct{}
+	mu     sync.Mutex
+}
+
+func newPubSubQueue(sub pubSubSubscriber, opt *PubSubOptions, dropped *info.IntValue, done func(*pubSubQueue)) *pubSubQueue {
+	q := &pubSubQueue{
+		sub:      sub,
+		size:     opt.BufferSize,
+		overflow: opt.Overflow,
+		dropped:  dropped,
+		done:     done,
+		wake:     make(chan struct{}, 1),
+	}
+	go q.loop()
+	return q
+}
+
+// Push buffers a message, it reports whether the message was accepted
+// and fails once the subscriber is gone
+func (q *pubSubQueue) Push(fn func(resp.ResponseWriter)) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, errSubscriberGone
+	}
+
+	if len(q.msgs) >= q.size {
+		switch q.overflow {
+		case DropOldest:
+			q.msgs = q.msgs[1:]
+			q.dropped.Inc(1)
+		case Disconnect:
+			q.dropped.Inc(int64(len(q.msgs)) + 1)
+			q.close()
+			if q.sub.c != nil {
+				q.sub.c.kill()
+			}
+			return false, errSubscriberGone
+		default:
+			q.dropped.Inc(1)
+			return false, nil
+		}
+	}
+
+	q.msgs = append(q.msgs, fn)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// Close stops delivery and discards buffered messages
+func (q *pubSubQueue) Close() {
+	q.mu.Lock()
+	q.close()
+	q.mu.Unlock()
+}
+
+func (q *pubSubQueue) close() {
+	if !q.closed {
+		q.closed = true
+		q.msgs = nil
+		close(q.wake)
+	}
+}
+
+func (q *pubSubQueue) loop() {
+	defer q.done(q)
+
+	for range q.wake {
+		for {
+			q.mu.Lock()
+			if len(q.msgs) == 0 {
+				q.mu.Unlock()
+				break
+			}
+			fn := q.msgs[0]
+			q.msgs[0] = nil
+			q.msgs = q.msgs[1:]
+			q.mu.Unlock()
+
+			if err := q.sub.push(fn); err != nil {
+				q.Close()
+				return
+			}
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package redeo
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync/atomic"
+
 	"github.com/johntech-o/redeo/redeotest"
 	"github.com/johntech-o/redeo/resp"
 	. "github.com/onsi/ginkgo"
@@ -63,4 +70,325 @@ var _ = Describe("PubSubBroker", func() {
 		}))
 	})
 
+	It("should publish keyspace events", func() {
+		space := redeotest.NewRecorder()
+		event := redeotest.NewRecorder()
+		subject.Subscribe().ServeRedeo(space, resp.NewCommand("subscribe", resp.CommandArgument("__keyspace@0__:foo")))
+		subject.Subscribe().ServeRedeo(event, resp.NewCommand("subscribe", resp.CommandArgument("__keyevent@0__:expired")))
+
+		Expect(subject.PublishKeyspaceEvent(0, "expired", "foo")).To(Equal(int64(2)))
+		Expect(subject.PublishKeyspaceEvent(0, "evicted", "bar")).To(Equal(int64(0)))
+		Expect(subject.PublishKeyspaceEvent(1, "expired", "foo")).To(Equal(int64(0)))
+
+		Expect(space.Responses()).To(Equal([]interface{}{
+			[]interface{}{"subscribe", "__keyspace@0__:foo", int64(1)},
+			[]interface{}{"message", "__keyspace@0__:foo", "expired"},
+		}))
+		Expect(event.Responses()).To(Equal([]interface{}{
+			[]interface{}{"subscribe", "__keyevent@0__:expired", int64(1)},
+			[]interface{}{"message", "__keyevent@0__:expired", "foo"},
+		}))
+	})
+
+	It("should reset clients", func() {
+		client := newClient(&mockConn{})
+		sub := redeotest.NewRecorder()
+		client.wr = sub
+
+		for _, name := range []string{"chan1", "chan2"} {
+			subc := resp.NewCommand("subscribe", resp.CommandArgument(name))
+			subc.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, client))
+			subject.Subscribe().ServeRedeo(sub, subc)
+		}
+		subject.Subscribe().ServeRedeo(redeotest.NewRecorder(), resp.NewCommand("subscribe", resp.CommandArgument("chan2")))
+
+		Expect(client.clientType()).To(Equal("pubsub"))
+		Expect(publish("chan1", "msg1")).To(Equal(int64(1)))
+		Expect(publish("chan2", "msg2")).To(Equal(int64(2)))
+
+		subject.ResetClient(client)
+		Expect(publish("chan1", "msg3")).To(Equal(int64(0)))
+		Expect(publish("chan2", "msg4")).To(Equal(int64(1)))
+	})
+
+	It("should publish/subscribe shard channels", func() {
+		sub := redeotest.NewRecorder()
+		subject.SSubscribe().ServeRedeo(sub, resp.NewCommand("ssubscribe", resp.CommandArgument("chan")))
+		Expect(subject.shards).To(HaveKey("chan"))
+		Expect(subject.channels).To(BeEmpty())
+
+		Expect(publish("chan", "msg1")).To(Equal(int64(0)))
+
+		w := redeotest.NewRecorder()
+		subject.SPublish().ServeRedeo(w, resp.NewCommand("spublish", resp.CommandArgument("chan"), resp.CommandArgument("msg2")))
+		Expect(w.Response()).To(Equal(int64(1)))
+		Expect(subject.PublishShardMessage("other", "msg3")).To(Equal(int64(0)))
+
+		Expect(sub.Responses()).To(Equal([]interface{}{
+			[]interface{}{"ssubscribe", "chan", int64(1)},
+			[]interface{}{"smessage", "chan", "msg2"},
+		}))
+	})
+
+	It("should serve PUBSUB shard introspection", func() {
+		var call = func(args ...string) interface{} {
+			c := resp.NewCommand("pubsub")
+			for _, arg := range args {
+				c.Args = append(c.Args, resp.CommandArgument(arg))
+			}
+			w := redeotest.NewRecorder()
+			subject.PubSub().ServeRedeo(w, c)
+			v, _ := w.Response()
+			return v
+		}
+
+		for _, name := range []string{"b:1", "a:1", "a:2", "a:2"} {
+			subject.SSubscribe().ServeRedeo(redeotest.NewRecorder(), resp.NewCommand("ssubscribe", resp.CommandArgument(name)))
+		}
+		subject.Subscribe().ServeRedeo(redeotest.NewRecorder(), resp.NewCommand("subscribe", resp.CommandArgument("c:1")))
+
+		Expect(call("shardchannels")).To(Equal([]interface{}{"a:1", "a:2", "b:1"}))
+		Expect(call("shardchannels", "a:*")).To(Equal([]interface{}{"a:1", "a:2"}))
+		Expect(call("shardchannels", "a", "b")).To(MatchError("ERR wrong number of arguments for 'pubsub shardchannels' command"))
+		Expect(call("shardnumsub", "a:2", "c:1")).To(Equal([]interface{}{"a:2", int64(2), "c:1", int64(0)}))
+		Expect(call("shardnumsub")).To(Equal([]interface{}{}))
+	})
+
+	It("should serve PUBSUB introspection", func() {
+		var call = func(args ...string) interface{} {
+			c := resp.NewCommand("pubsub")
+			for _, arg := range args {
+				c.Args = append(c.Args, resp.CommandArgument(arg))
+			}
+			w := redeotest.NewRecorder()
+			subject.PubSub().ServeRedeo(w, c)
+			v, _ := w.Response()
+			return v
+		}
+
+		client := newClient(&mockConn{})
+		client.wr = redeotest.NewRecorder()
+		for _, name := range []string{"news.tech", "news.art", "news.art", "sport"} {
+			subc := resp.NewCommand("subscribe", resp.CommandArgument(name))
+			if name == "sport" {
+				subc.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, client))
+			}
+			subject.Subscribe().ServeRedeo(redeotest.NewRecorder(), subc)
+		}
+
+		Expect(call("channels")).To(Equal([]interface{}{"news.art", "news.tech", "sport"}))
+		Expect(call("CHANNELS", "news.*")).To(Equal([]interface{}{"news.art", "news.tech"}))
+		Expect(call("numsub", "news.art", "sport", "missing")).To(Equal([]interface{}{
+			"news.art", int64(2), "sport", int64(1), "missing", int64(0),
+		}))
+		Expect(call("numpat")).To(Equal(int64(0)))
+		Expect(call("numpat", "x")).To(MatchError("ERR wrong number of arguments for 'pubsub numpat' command"))
+
+		subject.ResetClient(client)
+		Expect(call("channels")).To(Equal([]interface{}{"news.art", "news.tech"}))
+		Expect(call("numsub", "sport")).To(Equal([]interface{}{"sport", int64(0)}))
+	})
+
+	Describe("buffered", func() {
+		var sub *gatedRecorder
+
+		var setup = func(overflow PubSubOverflow) {
+			subject = NewPubSubBrokerWithOptions(&PubSubOptions{BufferSize: 2, Overflow: overflow})
+			sub = newGatedRecorder()
+			subject.Subscribe().ServeRedeo(sub, resp.NewCommand("subscribe", resp.CommandArgument("chan")))
+
+			// block delivery of the first message
+			Expect(publish("chan", "msg1")).To(Equal(int64(1)))
+			Eventually(sub.entered).Should(Receive())
+			Expect(publish("chan", "msg2")).To(Equal(int64(1)))
+			Expect(publish("chan", "msg3")).To(Equal(int64(1)))
+		}
+
+		var delivered = func(n int32) []interface{} {
+			close(sub.gate)
+			Eventually(func() int32 { return atomic.LoadInt32(&sub.flushed) }).Should(Equal(n))
+			Consistently(func() int32 { return atomic.LoadInt32(&sub.flushed) }, "50ms").Should(Equal(n))
+			res, _ := sub.Responses()
+			return res
+		}
+
+		It("should drop newest", func() {
+			setup(DropNewest)
+			Expect(publish("chan", "msg4")).To(Equal(int64(0)))
+			Expect(subject.Dropped().Value()).To(Equal(int64(1)))
+
+			Expect(delivered(3)).To(Equal([]interface{}{
+				[]interface{}{"subscribe", "chan", int64(1)},
+				[]interface{}{"message", "chan", "msg1"},
+				[]interface{}{"message", "chan", "msg2"},
+				[]interface{}{"message", "chan", "msg3"},
+			}))
+		})
+
+		It("should drop oldest", func() {
+			setup(DropOldest)
+			Expect(publish("chan", "msg4")).To(Equal(int64(1)))
+			Expect(subject.Dropped().Value()).To(Equal(int64(1)))
+
+			Expect(delivered(3)).To(Equal([]interface{}{
+				[]interface{}{"subscribe", "chan", int64(1)},
+				[]interface{}{"message", "chan", "msg1"},
+				[]interface{}{"message", "chan", "msg3"},
+				[]interface{}{"message", "chan", "msg4"},
+			}))
+		})
+
+		It("should disconnect", func() {
+			setup(Disconnect)
+			Expect(publish("chan", "msg4")).To(Equal(int64(0)))
+			Expect(subject.Dropped().Value()).To(Equal(int64(3)))
+			Expect(subject.channels["chan"].Len()).To(Equal(0))
+			Expect(publish("chan", "msg5")).To(Equal(int64(0)))
+
+			Expect(delivered(1)).To(Equal([]interface{}{
+				[]interface{}{"subscribe", "chan", int64(1)},
+				[]interface{}{"message", "chan", "msg1"},
+			}))
+		})
+
+		It("should share buffers across channels", func() {
+			subject = NewPubSubBrokerWithOptions(&PubSubOptions{BufferSize: 1})
+			client := newClient(&mockConn{})
+			client.wr = redeotest.NewRecorder()
+
+			for _, name := range []string{"chan1", "chan2"} {
+				subc := resp.NewCommand("subscribe", resp.CommandArgument(name))
+				subc.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, client))
+				subject.Subscribe().ServeRedeo(client.wr, subc)
+			}
+			Expect(subject.queues).To(HaveLen(1))
+
+			subject.ResetClient(client)
+			Expect(subject.queues).To(BeEmpty())
+			Expect(publish("chan1", "msg1")).To(Equal(int64(0)))
+		})
+
+		It("should drop queues of failed subscribers", func() {
+			subject = NewPubSubBrokerWithOptions(&PubSubOptions{BufferSize: 1})
+			subject.Subscribe().ServeRedeo(failingRecorder{redeotest.NewRecorder()}, resp.NewCommand("subscribe", resp.CommandArgument("chan")))
+			Expect(subject.queues).To(HaveLen(1))
+
+			Expect(publish("chan", "msg1")).To(Equal(int64(1)))
+			Eventually(func() int {
+				subject.mu.RLock()
+				defer subject.mu.RUnlock()
+				return len(subject.queues)
+			}).Should(Equal(0))
+			Expect(subject.channels["chan"].Len()).To(Equal(0))
+		})
+
+		It("should drop queues of disconnected subscribers", func() {
+			subject = NewPubSubBrokerWithOptions(&PubSubOptions{BufferSize: 1})
+			srv := NewServer(nil)
+			srv.Handle("subscribe", subject.Subscribe())
+			srv.OnDisconnect(subject)
+
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer lis.Close()
+			go srv.Serve(lis)
+
+			baseline := runtime.NumGoroutine()
+			for i := 0; i < 10; i++ {
+				cn, err := net.Dial("tcp", lis.Addr().String())
+				Expect(err).NotTo(HaveOccurred())
+				_, err = cn.Write([]byte("SUBSCRIBE chan\r\n"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bufio.NewReader(cn).ReadString('\n')).To(Equal("*3\r\n"))
+				Expect(cn.Close()).To(Succeed())
+			}
+
+			Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", baseline))
+			Eventually(subject.channels["chan"].Len).Should(Equal(0))
+			subject.mu.RLock()
+			Expect(subject.queues).To(BeEmpty())
+			subject.mu.RUnlock()
+		})
+	})
+
+	It("should push messages to RESP3 clients", func() {
+		srv := NewServer(nil)
+		srv.Handle("hello", Hello(srv))
+		srv.Handle("subscribe", subject.Subscribe())
+		srv.OnDisconnect(subject)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+		go srv.Serve(lis)
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		rd := bufio.NewReader(cn)
+		_, err = cn.Write([]byte("HELLO 3\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rd.ReadString('\n')).To(Equal("%7\r\n"))
+
+		_, err = cn.Write([]byte("SUBSCRIBE chan\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() int64 { return subject.PublishMessage("chan", "msg") }).Should(Equal(int64(1)))
+
+		for {
+			line, err := rd.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			if line == ">3\r\n" {
+				break
+			}
+		}
+		Expect(rd.ReadString('\n')).To(Equal("$9\r\n"))
+		Expect(rd.ReadString('\n')).To(Equal("subscribe\r\n"))
+		for _, s := range []string{"$4\r\n", "chan\r\n", ":1\r\n", ">3\r\n", "$7\r\n", "message\r\n"} {
+			Expect(rd.ReadString('\n')).To(Equal(s))
+		}
+	})
+
+	It("should serve subscribers", func() {
+		srv := NewServer(nil)
+		srv.Handle("ping", Ping())
+		srv.Handle("subscribe", subject.Subscribe())
+		srv.Handle("pubsub", subject.PubSub())
+
+		redeotest.CheckGolden(GinkgoT(), srv, "testdata/pubsub.golden",
+			"SUBSCRIBE foo\r\n*2\r\n$9\r\nsubscribe\r\n$3\r\nb",
+			"ar\r\n",
+			"PING\r\n",
+			"PUBSUB NUMSUB foo baz\r\n",
+		)
+	})
+
 })
+
+type gatedRecorder struct {
+	*redeotest.ResponseRecorder
+	entered chan struct{}
+	gate    chan struct{}
+	flushed int32
+}
+
+func newGatedRecorder() *gatedRecorder {
+	return &gatedRecorder{
+		ResponseRecorder: redeotest.NewRecorder(),
+		entered:          make(chan struct{}, 10),
+		gate:             make(chan struct{}),
+	}
+}
+
+func (r *gatedRecorder) Flush() error {
+	r.entered <- struct{}{}
+	<-r.gate
+	defer atomic.AddInt32(&r.flushed, 1)
+	return r.ResponseRecorder.Flush()
+}
+
+type failingRecorder struct {
+	*redeotest.ResponseRecorder
+}
+
+func (failingRecorder) Flush() error { return errors.New("failed") }
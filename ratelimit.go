@@ -0,0 +1,210 @@
+package redeo
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo/info"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// RateLimitExceeded is the error message returned to throttled clients
+const RateLimitExceeded = "ERR rate limit exceeded"
+
+// RateLimitOptions configure a RateLimiter
+type RateLimitOptions struct {
+	// Rate is the number of commands per second a single client
+	// (as identified by KeyFunc) may execute.
+	// Default: 0 (unlimited)
+	Rate float64
+
+	// Burst is the maximum number of commands a client may execute at once,
+	// before being throttled to Rate.
+	// Default: Rate, but at least 1
+	Burst int
+
+	// GlobalRate caps the number of commands per second across all clients.
+	// Default: 0 (unlimited)
+	GlobalRate float64
+
+	// GlobalBurst is the global equivalent of Burst.
+	// Default: GlobalRate, but at least 1
+	GlobalBurst int
+
+	// KeyFunc identifies the client a command is accounted to. Clients
+	// with equal keys share a limit.
+	// Default: RateLimitByID
+	KeyFunc func(c *Client) string
+
+	// Clock is used to refill the token buckets.
+	// Default: SystemClock
+	Clock Clock
+}
+
+func (o *RateLimitOptions) norm() {
+	if o.Burst < 1 {
+		o.Burst = int(o.Rate)
+		if o.Burst < 1 {
+			o.Burst = 1
+		}
+	}
+	if o.GlobalBurst < 1 {
+		o.GlobalBurst = int(o.GlobalRate)
+		if o.GlobalBurst < 1 {
+			o.GlobalBurst = 1
+		}
+	}
+	if o.KeyFunc == nil {
+		o.KeyFunc = RateLimitByID
+	}
+	o.Clock = clockOrDefault(o.Clock)
+}
+
+// RateLimitByID accounts commands to individual client connections.
+func RateLimitByID(c *Client) string { return strconv.FormatUint(c.ID(), 10) }
+
+// RateLimitByHost accounts commands to the remote host, all connections
+// from the same IP share a limit.
+func RateLimitByHost(c *Client) string {
+	addr := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// RateLimiter throttles command execution using token buckets, per client
+// and/or globally. Throttled commands are rejected with
+// RateLimitExceeded.
+type RateLimiter struct {
+	opt RateLimitOptions
+
+	global    tokenBucket
+	clients   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+
+	rejected *info.IntValue
+}
+
+// NewRateLimiter inits a new rate limiter.
+func NewRateLimiter(opt *RateLimitOptions) *RateLimiter {
+	var o RateLimitOptions
+	if opt != nil {
+		o = *opt
+	}
+	o.norm()
+
+	l := &RateLimiter{
+		opt:      o,
+		clients:  make(map[string]*tokenBucket),
+		rejected: info.NewIntValue(0),
+	}
+	l.lastSweep = l.opt.Clock.Now()
+	l.global = tokenBucket{tokens: float64(o.GlobalBurst), last: l.lastSweep}
+	return l
+}
+
+// Rejected returns the number of commands rejected so far. The returned
+// value can be registered with the server info, e.g.:
+//
+//   srv.Info().Fetch("Stats").Register("rejected_rate_limit", limiter.Rejected())
+func (l *RateLimiter) Rejected() *info.IntValue { return l.rejected }
+
+// Wrap returns a handler that applies the limits before calling h.
+func (l *RateLimiter) Wrap(h Handler) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if !l.Allow(GetClient(c.Context())) {
+			w.AppendError(RateLimitExceeded)
+			return
+		}
+		h.ServeRedeo(w, c)
+	})
+}
+
+// WrapStream returns a stream handler that applies the limits before
+// calling h.
+func (l *RateLimiter) WrapStream(h StreamHandler) StreamHandler {
+	return StreamHandlerFunc(func(w resp.ResponseWriter, c *resp.CommandStream) {
+		if !l.Allow(GetClient(c.Context())) {
+			w.AppendError(RateLimitExceeded)
+			return
+		}
+		h.ServeRedeoStream(w, c)
+	})
+}
+
+// Allow reports whether the client may execute another command and
+// consumes a token if so. The client may be nil, in which case only the
+// global limit is applied.
+func (l *RateLimiter) Allow(c *Client) bool {
+	now := l.opt.Clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	var b *tokenBucket
+	if c != nil && l.opt.Rate > 0 {
+		key := l.opt.KeyFunc(c)
+		if b = l.clients[key]; b == nil {
+			b = &tokenBucket{tokens: float64(l.opt.Burst), last: now}
+			l.clients[key] = b
+		}
+		if !b.take(now, l.opt.Rate, float64(l.opt.Burst)) {
+			l.rejected.Inc(1)
+			return false
+		}
+	}
+
+	if l.opt.GlobalRate > 0 && !l.global.take(now, l.opt.GlobalRate, float64(l.opt.GlobalBurst)) {
+		if b != nil {
+			b.tokens++ // refund
+		}
+		l.rejected.Inc(1)
+		return false
+	}
+	return true
+}
+
+// sweep drops buckets which have been refilled completely, at most
+// once per minute
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	burst := float64(l.opt.Burst)
+	for key, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.opt.Rate >= burst {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// --------------------------------------------------------------------
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time, rate, burst float64) bool {
+	if d := now.Sub(b.last); d > 0 {
+		b.tokens += d.Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,107 @@
+package redeo
+
+import (
+	"context"
+	"time"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var clock *MockClock
+	var c1, c2, c3 *Client
+
+	var setup = func(opt *RateLimitOptions) *RateLimiter {
+		var o RateLimitOptions
+		if opt != nil {
+			o = *opt
+		}
+		o.Clock = clock
+		return NewRateLimiter(&o)
+	}
+
+	var allowed = func(l *RateLimiter, c *Client, n int) (m int) {
+		for i := 0; i < n; i++ {
+			if l.Allow(c) {
+				m++
+			}
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		clock = NewMockClock(time.Now())
+		c1 = newClient(&mockConn{Port: 10001})
+		c2 = newClient(&mockConn{Port: 10002})
+		c3 = newClient(&mockConn{Port: 10003})
+	})
+
+	It("should not limit by default", func() {
+		subject := setup(nil)
+		Expect(allowed(subject, c1, 1000)).To(Equal(1000))
+		Expect(allowed(subject, nil, 1000)).To(Equal(1000))
+	})
+
+	It("should limit clients", func() {
+		subject := setup(&RateLimitOptions{Rate: 10, Burst: 5})
+		Expect(allowed(subject, c1, 10)).To(Equal(5))
+		Expect(allowed(subject, c2, 10)).To(Equal(5))
+		Expect(allowed(subject, nil, 10)).To(Equal(10))
+		Expect(subject.Rejected().Value()).To(Equal(int64(10)))
+
+		clock.Add(300 * time.Millisecond)
+		Expect(allowed(subject, c1, 10)).To(Equal(3))
+
+		clock.Add(time.Hour)
+		Expect(allowed(subject, c1, 10)).To(Equal(5))
+	})
+
+	It("should limit by host", func() {
+		subject := setup(&RateLimitOptions{Rate: 4, KeyFunc: RateLimitByHost})
+		Expect(allowed(subject, c1, 3)).To(Equal(3))
+		Expect(allowed(subject, c2, 3)).To(Equal(1))
+		Expect(allowed(subject, c3, 3)).To(Equal(0))
+	})
+
+	It("should apply global limits", func() {
+		subject := setup(&RateLimitOptions{Rate: 5, GlobalRate: 8})
+		Expect(allowed(subject, c1, 10)).To(Equal(5))
+		Expect(allowed(subject, c2, 10)).To(Equal(3))
+		Expect(allowed(subject, nil, 10)).To(Equal(0))
+
+		// tokens refunded
+		clock.Add(250 * time.Millisecond)
+		Expect(allowed(subject, c2, 10)).To(Equal(2))
+	})
+
+	It("should sweep idle buckets", func() {
+		subject := setup(&RateLimitOptions{Rate: 5})
+		Expect(allowed(subject, c1, 5)).To(Equal(5))
+		Expect(allowed(subject, c2, 1)).To(Equal(1))
+		Expect(subject.clients).To(HaveLen(2))
+
+		clock.Add(2 * time.Minute)
+		Expect(allowed(subject, c3, 1)).To(Equal(1))
+		Expect(subject.clients).To(HaveLen(1))
+	})
+
+	It("should wrap handlers", func() {
+		subject := setup(&RateLimitOptions{Rate: 1})
+		handler := subject.Wrap(Ping())
+
+		cmd := resp.NewCommand("PING")
+		cmd.SetContext(context.WithValue(cmd.Context(), ctxKeyClient{}, c1))
+
+		w := redeotest.NewRecorder()
+		handler.ServeRedeo(w, cmd)
+		handler.ServeRedeo(w, cmd)
+		Expect(w.Responses()).To(Equal([]interface{}{
+			"PONG",
+			redeotest.ErrorResponse("ERR rate limit exceeded"),
+		}))
+	})
+
+})
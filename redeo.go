@@ -1,9 +1,13 @@
 package redeo
 
 import (
+	"bytes"
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/johntech-o/redeo/info"
 	"github.com/johntech-o/redeo/resp"
 )
 
@@ -17,6 +21,22 @@ func ErrUnknownCommand(cmd string) error {
 	return errors.New(UnknownCommand(cmd))
 }
 
+// ReadOnlyError is the error message returned for write commands sent to
+// read-only servers
+const ReadOnlyError = "READONLY You can't write against a read only replica."
+
+// ReplyTooLargeError is the error message returned instead of replies
+// which exceed Config.MaxReplySize
+const ReplyTooLargeError = "ERR reply exceeds the maximum reply size"
+
+// NoReplicasError is the error message returned for write commands while
+// not enough replicas are attached, see MasterOptions.MinReplicasToWrite
+const NoReplicasError = "NOREPLICAS Not enough good replicas to write."
+
+// errPartialReply is returned when a reply exceeded Config.MaxReplySize
+// after parts of it have been written
+var errPartialReply = errors.New("reply exceeds the maximum reply size")
+
 // WrongNumberOfArgs returns an unknown command error string
 func WrongNumberOfArgs(cmd string) string {
 	return "ERR wrong number of arguments for '" + cmd + "' command"
@@ -24,9 +44,15 @@ func WrongNumberOfArgs(cmd string) string {
 
 // ErrWrongNumberOfArgs returns an unknown command error
 func ErrWrongNumberOfArgs(cmd string) error {
-	return errors.New(WrongNumberOfArgs(cmd))
+	return &wrongNumberOfArgsError{cmd: cmd}
 }
 
+// wrongNumberOfArgsError is returned by ErrWrongNumberOfArgs, so arity
+// errors are noted without parsing, see arityWriter
+type wrongNumberOfArgsError struct{ cmd string }
+
+func (e *wrongNumberOfArgsError) Error() string { return WrongNumberOfArgs(e.cmd) }
+
 // Ping returns a ping handler.
 // https://redis.io/commands/ping
 func Ping() Handler {
@@ -55,18 +81,558 @@ func Echo() Handler {
 	})
 }
 
-// Info returns an info handler.
+// InfoOptions configure an info handler
+type InfoOptions struct {
+	// Maps replies to RESP3 clients with a map of section names to maps
+	// of their fields, instead of the text.
+	// Default: false (all clients receive the text, like in Redis)
+	Maps bool
+}
+
+// Info returns an info handler. Clients may request one or more
+// sections by name, the keywords "default", "all" and "everything"
+// select all sections.
 // https://redis.io/commands/info
 func Info(s *Server) Handler {
+	return InfoWithOptions(s, nil)
+}
+
+// InfoWithOptions returns an info handler with custom options
+func InfoWithOptions(s *Server, opt *InfoOptions) Handler {
+	var o InfoOptions
+	if opt != nil {
+		o = *opt
+	}
+
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		sections := s.Info().Sections()
+		if c.ArgN() != 0 {
+			sections = selectInfoSections(s.Info(), c.Args)
+		}
+
+		if o.Maps && resp.Protocol(w) == resp.RESP3 {
+			appendInfoMap(w, sections)
+			return
+		}
+
+		parts := make([]string, 0, len(sections))
+		for _, sec := range sections {
+			if str := sec.String(); str != "" {
+				parts = append(parts, str)
+			}
+		}
+		resp.AppendVerbatimString(w, "txt", strings.Join(parts, "\n"))
+	})
+}
+
+// selectInfoSections returns the sections requested by args, in order
+func selectInfoSections(si *ServerInfo, args []resp.CommandArgument) []*info.Section {
+	var sections []*info.Section
+	seen := make(map[string]bool, len(args))
+	for _, arg := range args {
+		name := strings.ToLower(arg.String())
+		switch name {
+		case "default", "all", "everything":
+			return si.Sections()
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			if sec := si.Find(name); sec != nil {
+				sections = append(sections, sec)
+			}
+		}
+	}
+	return sections
+}
+
+// appendInfoMap appends sections as a map of section names to maps of
+// their fields, sections without fields are omitted
+func appendInfoMap(w resp.ResponseWriter, sections []*info.Section) {
+	names := make([]string, 0, len(sections))
+	fields := make([][]string, 0, len(sections))
+	for _, sec := range sections {
+		var kvs []string
+		sec.Each(func(name, value string) { kvs = append(kvs, name, value) })
+		if len(kvs) != 0 {
+			names = append(names, sec.Name())
+			fields = append(fields, kvs)
+		}
+	}
+
+	resp.AppendMapLen(w, len(names))
+	for i, name := range names {
+		w.AppendBulkString(name)
+		resp.AppendMapLen(w, len(fields[i])/2)
+		for _, s := range fields[i] {
+			w.AppendBulkString(s)
+		}
+	}
+}
+
+// Lolwut returns a LOLWUT handler, which reports the version of the
+// server, see Config.Version. Version-specific art is not supported.
+// https://redis.io/commands/lolwut
+func Lolwut(s *Server) Handler {
 	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
-		info := s.Info()
-		resp := ""
+		if c.ArgN() >= 2 && strings.EqualFold(c.Arg(0).String(), "version") {
+			if _, err := c.Arg(1).Int(); err != nil {
+				w.AppendError("ERR value is not an integer or out of range")
+				return
+			}
+		}
+		resp.AppendVerbatimString(w, "txt", "Redis ver. "+s.Info().Version()+"\n")
+	})
+}
+
+// ResetStat returns a CONFIG RESETSTAT handler, for use as a sub-command,
+// which resets the server statistics, see ServerInfo.Reset.
+// https://redis.io/commands/config-resetstat
+func ResetStat(s *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		s.Info().Reset()
+		w.AppendOK()
+	})
+}
+
+// ClientResetter is implemented by components that hold per-client state,
+// which must be discarded when a client issues a RESET or disconnects, see
+// Server.OnDisconnect.
+type ClientResetter interface {
+	// ResetClient discards all state held for the client.
+	ResetClient(c *Client)
+}
+
+// Reset returns a RESET handler. It resets the connection state of the
+// calling client and then calls each of the resetters, e.g. a
+// PubSubBroker to drop the client's subscriptions.
+// https://redis.io/commands/reset
+func Reset(resetters ...ClientResetter) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		if client := GetClient(c.Context()); client != nil {
+			client.resetState()
+			for _, r := range resetters {
+				r.ResetClient(client)
+			}
+		}
+		w.AppendInlineString("RESET")
+	})
+}
+
+// Hello returns a HELLO handler, which switches the calling client to
+// the requested protocol version and replies with a summary of the server.
+// RESP3 requires an extended writer, see resp.ExtendedResponseWriter. The
+// SETNAME option is supported, AUTH is rejected.
+// https://redis.io/commands/hello
+func Hello(s *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		client := GetClient(c.Context())
+		proto := resp.Protocol(w)
+
+		var name string
+		var setName bool
+		if c.ArgN() != 0 {
+			v, err := c.Arg(0).Int()
+			if err != nil {
+				w.AppendError("ERR Protocol version is not an integer or out of range")
+				return
+			}
+			if _, ok := resp.Extended(w); v != resp.RESP2 && (v != resp.RESP3 || !ok) {
+				w.AppendError("NOPROTO unsupported protocol version")
+				return
+			}
+			proto = int(v)
+
+			for i := 1; i < c.ArgN(); i++ {
+				switch opt := strings.ToLower(c.Arg(i).String()); {
+				case opt == "auth" && i+2 < c.ArgN():
+					w.AppendError("ERR AUTH is not supported")
+					return
+				case opt == "setname" && i+1 < c.ArgN():
+					i++
+					name, setName = c.Arg(i).String(), true
+				default:
+					w.AppendErrorf("ERR Syntax error in HELLO option '%s'", c.Arg(i).String())
+					return
+				}
+			}
+		}
+
+		var id uint64
+		if client != nil {
+			if setName {
+				if err := client.SetName(name); err != nil {
+					w.AppendError(err.Error())
+					return
+				}
+			}
+			client.SetProtocol(proto)
+			id = client.ID()
+		}
+		resp.SetProtocol(w, proto)
+
+		resp.AppendMapLen(w, 7)
+		w.AppendBulkString("server")
+		w.AppendBulkString("redis")
+		w.AppendBulkString("version")
+		w.AppendBulkString(s.Info().Version())
+		w.AppendBulkString("proto")
+		w.AppendInt(int64(proto))
+		w.AppendBulkString("id")
+		w.AppendInt(int64(id))
+		w.AppendBulkString("mode")
+		w.AppendBulkString("standalone")
+		w.AppendBulkString("role")
+		w.AppendBulkString(s.info.Replication().Role)
+		w.AppendBulkString("modules")
+		w.AppendArrayLen(0)
+	})
+}
+
+// ReadOnly returns a READONLY handler, which revokes the calling client's
+// permission to write to read-only servers.
+// https://redis.io/commands/readonly
+func ReadOnly() Handler {
+	return readWriteHandler(false)
+}
+
+// ReadWrite returns a READWRITE handler, which allows the calling client
+// to write to read-only servers, see Server.SetReadOnly.
+// https://redis.io/commands/readwrite
+func ReadWrite() Handler {
+	return readWriteHandler(true)
+}
+
+func readWriteHandler(on bool) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		if client := GetClient(c.Context()); client != nil {
+			client.SetReadWrite(on)
+		}
+		w.AppendOK()
+	})
+}
+
+// ClientKill returns a CLIENT KILL handler, for use as a sub-command
+// of CLIENT, e.g.:
+//
+//   srv.Handle("client", redeo.SubCommands{
+//     "kill": redeo.ClientKill(srv),
+//   })
+//
+// Both, the legacy CLIENT KILL addr:port form and the filter form are
+// supported. Accepted filters are ID, ADDR, LADDR, TYPE, USER, MAXAGE
+// and SKIPME.
+// https://redis.io/commands/client-kill
+func ClientKill(s *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		self := GetClient(c.Context())
+
+		// legacy form, reply with OK or error
 		if c.ArgN() == 1 {
-			resp = info.Find(c.Args[0].String()).String()
-		} else {
-			resp = info.String()
+			addr := c.Arg(0).String()
+			if n := s.info.clients.Kill(self, func(ci *ClientInfo) bool { return ci.RemoteAddr == addr }); n == 0 {
+				w.AppendError("ERR No such client")
+				return
+			}
+			w.AppendOK()
+			return
+		}
+
+		if c.ArgN() == 0 || c.ArgN()%2 != 0 {
+			w.AppendError("ERR syntax error")
+			return
+		}
+
+		skipMe := true
+		var filters []func(*ClientInfo) bool
+		for i := 0; i < c.ArgN(); i += 2 {
+			val := c.Arg(i + 1).String()
+
+			switch strings.ToLower(c.Arg(i).String()) {
+			case "id":
+				id, err := strconv.ParseUint(val, 10, 64)
+				if err != nil || id == 0 {
+					w.AppendError("ERR client-id should be greater than 0")
+					return
+				}
+				filters = append(filters, func(ci *ClientInfo) bool { return ci.ID == id })
+			case "addr":
+				filters = append(filters, func(ci *ClientInfo) bool { return ci.RemoteAddr == val })
+			case "laddr":
+				filters = append(filters, func(ci *ClientInfo) bool { return ci.LocalAddr == val })
+			case "type":
+				typ := strings.ToLower(val)
+				switch typ {
+				case "slave":
+					typ = "replica"
+				case "normal", "pubsub", "master", "replica":
+				default:
+					w.AppendError("ERR Unknown client type '" + val + "'")
+					return
+				}
+				filters = append(filters, func(ci *ClientInfo) bool { return ci.client.clientType() == typ })
+			case "user":
+				// there is no user management, all clients
+				// are authenticated as the default user
+				if val != "default" {
+					w.AppendError("ERR No such user '" + val + "'")
+					return
+				}
+			case "maxage":
+				secs, err := strconv.ParseInt(val, 10, 64)
+				if err != nil {
+					w.AppendError("ERR syntax error")
+					return
+				}
+				maxAge := time.Duration(secs) * time.Second
+				filters = append(filters, func(ci *ClientInfo) bool { return ci.now().Sub(ci.CreateTime) >= maxAge })
+			case "skipme":
+				switch strings.ToLower(val) {
+				case "yes":
+					skipMe = true
+				case "no":
+					skipMe = false
+				default:
+					w.AppendError("ERR syntax error")
+					return
+				}
+			default:
+				w.AppendError("ERR syntax error")
+				return
+			}
+		}
+
+		n := s.info.clients.Kill(self, func(ci *ClientInfo) bool {
+			if skipMe && ci.client == self {
+				return false
+			}
+			for _, fn := range filters {
+				if !fn(ci) {
+					return false
+				}
+			}
+			return true
+		})
+		w.AppendInt(int64(n))
+	})
+}
+
+// ClientNoEvict returns a CLIENT NO-EVICT handler, for use as a
+// sub-command of CLIENT, e.g.:
+//
+//   srv.Handle("client", redeo.SubCommands{
+//     "no-evict": redeo.ClientNoEvict(),
+//   })
+//
+// See Client.SetNoEvict for details.
+// https://redis.io/commands/client-no-evict
+func ClientNoEvict() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		var on bool
+		switch strings.ToLower(c.Arg(0).String()) {
+		case "on":
+			on = true
+		case "off":
+		default:
+			w.AppendError("ERR syntax error")
+			return
+		}
+
+		if client := GetClient(c.Context()); client != nil {
+			client.SetNoEvict(on)
+		}
+		w.AppendOK()
+	})
+}
+
+// ClientSetName returns a CLIENT SETNAME handler, for use as a
+// sub-command of CLIENT. See Client.SetName for details.
+// https://redis.io/commands/client-setname
+func ClientSetName() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		if client := GetClient(c.Context()); client != nil {
+			if err := client.SetName(c.Arg(0).String()); err != nil {
+				w.AppendError(err.Error())
+				return
+			}
+		}
+		w.AppendOK()
+	})
+}
+
+// ClientGetName returns a CLIENT GETNAME handler, for use as a
+// sub-command of CLIENT.
+// https://redis.io/commands/client-getname
+func ClientGetName() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		var name string
+		if client := GetClient(c.Context()); client != nil {
+			name = client.Name()
+		}
+		if name == "" {
+			w.AppendNil()
+			return
+		}
+		w.AppendBulkString(name)
+	})
+}
+
+// ClientSetInfo returns a CLIENT SETINFO handler, for use as a
+// sub-command of CLIENT. It stores the LIB-NAME and LIB-VER attributes,
+// which client libraries send on connect.
+// https://redis.io/commands/client-setinfo
+func ClientSetInfo() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		attr, val := strings.ToLower(c.Arg(0).String()), c.Arg(1).String()
+		var name, ver *string
+		switch attr {
+		case "lib-name":
+			name = &val
+		case "lib-ver":
+			ver = &val
+		default:
+			w.AppendError("ERR Unrecognized option '" + c.Arg(0).String() + "'")
+			return
+		}
+		if !validClientAttr(val) {
+			w.AppendError("ERR " + attr + " cannot contain spaces, newlines or special characters.")
+			return
+		}
+
+		if client := GetClient(c.Context()); client != nil {
+			client.setLibInfo(name, ver)
+		}
+		w.AppendOK()
+	})
+}
+
+// ClientList returns a CLIENT LIST handler, for use as a sub-command of
+// CLIENT. The TYPE and ID filters are supported.
+// https://redis.io/commands/client-list
+func ClientList(s *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		var filter func(*ClientInfo) bool
+
+		if c.ArgN() != 0 {
+			switch strings.ToLower(c.Arg(0).String()) {
+			case "type":
+				if c.ArgN() != 2 {
+					w.AppendError("ERR syntax error")
+					return
+				}
+				typ := strings.ToLower(c.Arg(1).String())
+				switch typ {
+				case "slave":
+					typ = "replica"
+				case "normal", "pubsub", "master", "replica":
+				default:
+					w.AppendError("ERR Unknown client type '" + c.Arg(1).String() + "'")
+					return
+				}
+				filter = func(ci *ClientInfo) bool { return ci.client.clientType() == typ }
+			case "id":
+				if c.ArgN() < 2 {
+					w.AppendError("ERR syntax error")
+					return
+				}
+				ids := make(map[uint64]struct{}, c.ArgN()-1)
+				for _, arg := range c.Args[1:] {
+					id, err := strconv.ParseUint(arg.String(), 10, 64)
+					if err != nil || id == 0 {
+						w.AppendError("ERR Invalid client ID")
+						return
+					}
+					ids[id] = struct{}{}
+				}
+				filter = func(ci *ClientInfo) bool { _, ok := ids[ci.ID]; return ok }
+			default:
+				w.AppendError("ERR syntax error")
+				return
+			}
+		}
+
+		var buf bytes.Buffer
+		for _, ci := range s.info.ClientInfo() {
+			if filter != nil && !filter(&ci) {
+				continue
+			}
+			buf.WriteString(ci.String())
+			buf.WriteByte('\n')
+		}
+		w.AppendBulk(buf.Bytes())
+	})
+}
+
+// ClientSelf returns a CLIENT INFO handler, for use as a sub-command of
+// CLIENT. It replies with the CLIENT LIST line of the calling client.
+// https://redis.io/commands/client-info
+func ClientSelf() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		client := GetClient(c.Context())
+		if client == nil {
+			w.AppendNil()
+			return
+		}
+		w.AppendBulkString(newClientInfo(client).String() + "\n")
+	})
+}
+
+// ClientID returns a CLIENT ID handler, for use as a sub-command of
+// CLIENT. It replies with the ID of the calling client.
+// https://redis.io/commands/client-id
+func ClientID() Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		client := GetClient(c.Context())
+		if client == nil {
+			w.AppendNil()
+			return
 		}
-		w.AppendBulkString(resp)
+		w.AppendInt(int64(client.ID()))
 	})
 }
 
@@ -79,7 +645,7 @@ func (s CommandDescriptions) ServeRedeo(w resp.ResponseWriter, c *resp.Command)
 
 	for _, cmd := range s {
 		w.AppendArrayLen(6)
-		w.AppendBulkString(strings.ToLower(cmd.Name))
+		w.AppendBulkString(resp.CanonicalName(cmd.Name))
 		w.AppendInt(cmd.Arity)
 		w.AppendArrayLen(len(cmd.Flags))
 		for _, flag := range cmd.Flags {
@@ -103,14 +669,14 @@ func (s SubCommands) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
 	}
 
 	firstArg := c.Arg(0).String()
-	if h, ok := s[strings.ToLower(firstArg)]; ok {
+	if h, ok := s[resp.CanonicalName(firstArg)]; ok {
 		cmd := resp.NewCommand(c.Name+" "+firstArg, c.Args[1:]...)
 		cmd.SetContext(c.Context())
 		h.ServeRedeo(w, cmd)
 		return
 	}
 
-	w.AppendError("ERR Unknown " + strings.ToLower(c.Name) + " subcommand '" + firstArg + "'")
+	w.AppendError("ERR Unknown " + c.CanonicalName() + " subcommand '" + firstArg + "'")
 
 }
 
@@ -2,7 +2,17 @@ package redeo
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"net"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,6 +41,380 @@ var _ = Describe("Ping", func() {
 
 })
 
+var _ = Describe("Info", func() {
+	srv := NewServer(nil)
+	subject := Info(srv)
+
+	var call = func(args ...string) string {
+		cmd := resp.NewCommand("INFO")
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd)
+		v, err := w.Response()
+		Expect(err).NotTo(HaveOccurred())
+		return v.(string)
+	}
+
+	It("should filter sections", func() {
+		all := srv.Info().String()
+		Expect(call()).To(Equal(all))
+		Expect(call("everything")).To(Equal(all))
+		Expect(call("clients", "ALL")).To(Equal(all))
+
+		Expect(call("clients")).To(Equal("# Clients\nconnected_clients:0\n"))
+		Expect(call("clients", "replication", "Clients", "unknown")).To(Equal(
+			"# Clients\nconnected_clients:0\n\n# Replication\nrole:master\nconnected_slaves:0\nmaster_repl_offset:0\n",
+		))
+		Expect(call("unknown")).To(Equal(""))
+	})
+
+	It("should reply text to RESP3 clients by default", func() {
+		w := redeotest.NewRecorder()
+		Expect(resp.SetProtocol(w, resp.RESP3)).To(BeTrue())
+		subject.ServeRedeo(w, resp.NewCommand("INFO", resp.CommandArgument("clients")))
+		Expect(w.String()).To(Equal("=34\r\ntxt:# Clients\nconnected_clients:0\n\r\n"))
+	})
+
+	It("should reply maps to RESP3 clients, if enabled", func() {
+		maps := InfoWithOptions(srv, &InfoOptions{Maps: true})
+
+		w := redeotest.NewRecorder()
+		maps.ServeRedeo(w, resp.NewCommand("INFO", resp.CommandArgument("clients")))
+		Expect(w.String()).To(Equal("$30\r\n# Clients\nconnected_clients:0\n\r\n"))
+
+		w = redeotest.NewRecorder()
+		Expect(resp.SetProtocol(w, resp.RESP3)).To(BeTrue())
+		maps.ServeRedeo(w, resp.NewCommand("INFO", resp.CommandArgument("clients"), resp.CommandArgument("unknown")))
+		Expect(w.String()).To(Equal("%1\r\n$7\r\nClients\r\n%1\r\n$17\r\nconnected_clients\r\n$1\r\n0\r\n"))
+
+		w = redeotest.NewRecorder()
+		resp.SetProtocol(w, resp.RESP3)
+		maps.ServeRedeo(w, resp.NewCommand("INFO"))
+		Expect(w.String()).To(HavePrefix("%"))
+		Expect(w.String()).To(ContainSubstring("$6\r\nServer\r\n%"))
+	})
+
+})
+
+var _ = Describe("Lolwut", func() {
+
+	It("should report the version", func() {
+		subject := Lolwut(NewServer(&Config{Version: "6.2.7"}))
+
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("LOLWUT"))
+		Expect(w.Response()).To(Equal("Redis ver. 6.2.7\n"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("LOLWUT", resp.CommandArgument("VERSION"), resp.CommandArgument("5"), resp.CommandArgument("1")))
+		Expect(w.Response()).To(Equal("Redis ver. 6.2.7\n"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("LOLWUT", resp.CommandArgument("version"), resp.CommandArgument("x")))
+		Expect(w.Response()).To(MatchError("ERR value is not an integer or out of range"))
+	})
+
+})
+
+var _ = Describe("ResetStat", func() {
+
+	It("should reset stats", func() {
+		srv := NewServer(nil)
+		srv.Info().commands.Inc(3)
+		subject := ResetStat(srv)
+
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("CONFIG resetstat"))
+		Expect(w.Response()).To(Equal("OK"))
+		Expect(srv.Info().TotalCommands()).To(Equal(int64(0)))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("CONFIG resetstat", resp.CommandArgument("x")))
+		Expect(w.Response()).To(MatchError("ERR wrong number of arguments for 'CONFIG resetstat' command"))
+	})
+
+})
+
+var _ = Describe("Reset", func() {
+
+	It("should reset client state", func() {
+		client := newClient(&mockConn{})
+		client.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, "custom"))
+		client.markPubSub()
+		client.SetNoEvict(true)
+
+		resetter := new(mockResetter)
+		cmd := resp.NewCommand("RESET")
+		cmd.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, client))
+
+		w := redeotest.NewRecorder()
+		Reset(resetter).ServeRedeo(w, cmd)
+		Expect(w.Response()).To(Equal("RESET"))
+		Expect(client.ctx).To(BeNil())
+		Expect(client.clientType()).To(Equal("normal"))
+		Expect(client.NoEvict()).To(BeFalse())
+		Expect(resetter.clients).To(ConsistOf(client))
+
+		w = redeotest.NewRecorder()
+		Reset(resetter).ServeRedeo(w, resp.NewCommand("RESET", resp.CommandArgument("bad")))
+		Expect(w.Response()).To(MatchError("ERR wrong number of arguments for 'RESET' command"))
+	})
+
+})
+
+var _ = Describe("Hello", func() {
+	var srv *Server
+	var client *Client
+
+	BeforeEach(func() {
+		srv = NewServer(&Config{Version: "6.2.0"})
+		client = newClient(&mockConn{})
+	})
+
+	var hello = func(args ...string) *redeotest.ResponseRecorder {
+		cmd := redeotest.NewCommand("HELLO", args...)
+		cmd.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, client))
+
+		w := redeotest.NewRecorder()
+		Hello(srv).ServeRedeo(w, cmd)
+		return w
+	}
+
+	It("should switch protocols", func() {
+		id := strconv.FormatUint(client.ID(), 10)
+		Expect(hello().Response()).To(Equal([]interface{}{
+			"server", "redis", "version", "6.2.0", "proto", int64(2),
+			"id", int64(client.ID()), "mode", "standalone", "role", "master",
+			"modules", []interface{}{},
+		}))
+		Expect(client.Protocol()).To(Equal(resp.RESP2))
+
+		w := hello("3", "SETNAME", "conn")
+		Expect(w.String()).To(Equal("%7\r\n" +
+			"$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n6.2.0\r\n" +
+			"$5\r\nproto\r\n:3\r\n$2\r\nid\r\n:" + id + "\r\n" +
+			"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n" +
+			"$7\r\nmodules\r\n*0\r\n"))
+		Expect(client.Protocol()).To(Equal(resp.RESP3))
+		Expect(client.Name()).To(Equal("conn"))
+
+		client.resetState()
+		Expect(client.Protocol()).To(Equal(resp.RESP2))
+	})
+
+	It("should reject bad arguments", func() {
+		Expect(hello("x").Response()).To(MatchError("ERR Protocol version is not an integer or out of range"))
+		Expect(hello("4").Response()).To(MatchError("NOPROTO unsupported protocol version"))
+		Expect(hello("3", "AUTH", "user", "pass").Response()).To(MatchError("ERR AUTH is not supported"))
+		Expect(hello("3", "SETNAME").Response()).To(MatchError("ERR Syntax error in HELLO option 'SETNAME'"))
+		Expect(client.Protocol()).To(Equal(resp.RESP2))
+	})
+
+})
+
+var _ = Describe("ClientKill", func() {
+	var srv *Server
+	var subject Handler
+	var conns []*mockConn
+	var self *Client
+
+	BeforeEach(func() {
+		srv = NewServer(nil)
+		subject = ClientKill(srv)
+		conns = []*mockConn{{Port: 10001}, {Port: 10002}, {Port: 10003}}
+
+		for i, cn := range conns {
+			c := newClient(cn)
+			if i == 0 {
+				self = c
+			} else if i == 2 {
+				c.markPubSub()
+			}
+			srv.info.register(c)
+		}
+	})
+
+	var kill = func(args ...string) interface{} {
+		cmd := resp.NewCommand("CLIENT KILL")
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		cmd.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, self))
+
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd)
+		v, err := w.Response()
+		Expect(err).NotTo(HaveOccurred())
+		return v
+	}
+
+	var closed = func() []bool {
+		return []bool{self.closed || conns[0].closed, conns[1].closed, conns[2].closed}
+	}
+
+	It("should kill by address (legacy)", func() {
+		Expect(kill("1.2.3.4:10009")).To(MatchError("ERR No such client"))
+		Expect(kill("1.2.3.4:10002")).To(Equal("OK"))
+		Expect(closed()).To(Equal([]bool{false, true, false}))
+	})
+
+	It("should kill by filters", func() {
+		Expect(kill("ADDR", "1.2.3.4:10009")).To(Equal(int64(0)))
+		Expect(kill("addr", "1.2.3.4:10003")).To(Equal(int64(1)))
+		Expect(closed()).To(Equal([]bool{false, false, true}))
+
+		Expect(kill("LADDR", "127.0.0.1:9736", "TYPE", "normal")).To(Equal(int64(1)))
+		Expect(closed()).To(Equal([]bool{false, true, true}))
+	})
+
+	It("should kill by ID", func() {
+		id := strconv.FormatUint(self.ID()+2, 10)
+		Expect(kill("ID", id, "TYPE", "normal")).To(Equal(int64(0)))
+		Expect(kill("ID", id, "TYPE", "pubsub")).To(Equal(int64(1)))
+		Expect(closed()).To(Equal([]bool{false, false, true}))
+	})
+
+	It("should skip self unless requested", func() {
+		Expect(kill("USER", "default")).To(Equal(int64(2)))
+		Expect(closed()).To(Equal([]bool{false, true, true}))
+
+		Expect(kill("USER", "default", "SKIPME", "no")).To(Equal(int64(3)))
+		Expect(self.closed).To(BeTrue())
+		Expect(conns[0].closed).To(BeFalse())
+	})
+
+	It("should validate filters", func() {
+		Expect(kill()).To(MatchError("ERR syntax error"))
+		Expect(kill("ID", "1", "TYPE")).To(MatchError("ERR syntax error"))
+		Expect(kill("BAD", "1")).To(MatchError("ERR syntax error"))
+		Expect(kill("ID", "x")).To(MatchError("ERR client-id should be greater than 0"))
+		Expect(kill("TYPE", "bad")).To(MatchError("ERR Unknown client type 'bad'"))
+		Expect(kill("USER", "bob")).To(MatchError("ERR No such user 'bob'"))
+		Expect(kill("SKIPME", "maybe")).To(MatchError("ERR syntax error"))
+		Expect(closed()).To(Equal([]bool{false, false, false}))
+	})
+
+})
+
+var _ = Describe("ClientNoEvict", func() {
+	subject := ClientNoEvict()
+
+	It("should toggle protection", func() {
+		client := newClient(&mockConn{})
+		cmd := func(args ...string) *resp.Command {
+			cmd := resp.NewCommand("CLIENT NO-EVICT")
+			for _, arg := range args {
+				cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+			}
+			cmd.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, client))
+			return cmd
+		}
+
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd("on"))
+		Expect(w.Response()).To(Equal("OK"))
+		Expect(client.NoEvict()).To(BeTrue())
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd("OFF"))
+		Expect(w.Response()).To(Equal("OK"))
+		Expect(client.NoEvict()).To(BeFalse())
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd("maybe"))
+		Expect(w.Response()).To(MatchError("ERR syntax error"))
+
+		w = redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd())
+		Expect(w.Response()).To(MatchError("ERR wrong number of arguments for 'CLIENT NO-EVICT' command"))
+	})
+})
+
+var _ = Describe("ClientSetName", func() {
+	var client *Client
+
+	BeforeEach(func() {
+		client = newClient(&mockConn{Port: 10001})
+	})
+
+	var call = func(h Handler, name string, args ...string) interface{} {
+		cmd := resp.NewCommand(name)
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		cmd.SetContext(context.WithValue(context.Background(), ctxKeyClient{}, client))
+
+		w := redeotest.NewRecorder()
+		h.ServeRedeo(w, cmd)
+		v, _ := w.Response()
+		return v
+	}
+
+	It("should set and get names", func() {
+		Expect(call(ClientGetName(), "CLIENT GETNAME")).To(BeNil())
+		Expect(call(ClientSetName(), "CLIENT SETNAME", "conn-1")).To(Equal("OK"))
+		Expect(call(ClientGetName(), "CLIENT GETNAME")).To(Equal("conn-1"))
+		Expect(client.Name()).To(Equal("conn-1"))
+
+		Expect(call(ClientSetName(), "CLIENT SETNAME", "bad name")).To(MatchError("ERR Client names cannot contain spaces, newlines or special characters."))
+		Expect(call(ClientSetName(), "CLIENT SETNAME", "bad\nname")).To(MatchError("ERR Client names cannot contain spaces, newlines or special characters."))
+		Expect(client.Name()).To(Equal("conn-1"))
+
+		Expect(call(ClientSetName(), "CLIENT SETNAME", "")).To(Equal("OK"))
+		Expect(call(ClientGetName(), "CLIENT GETNAME")).To(BeNil())
+
+		Expect(call(ClientSetName(), "CLIENT SETNAME")).To(MatchError("ERR wrong number of arguments for 'CLIENT SETNAME' command"))
+		Expect(call(ClientGetName(), "CLIENT GETNAME", "x")).To(MatchError("ERR wrong number of arguments for 'CLIENT GETNAME' command"))
+	})
+
+	It("should clear names on reset", func() {
+		Expect(client.SetName("conn-1")).To(Succeed())
+		client.resetState()
+		Expect(client.Name()).To(BeEmpty())
+	})
+
+	It("should store library info", func() {
+		Expect(call(ClientSetInfo(), "CLIENT SETINFO", "LIB-NAME", "go-redis")).To(Equal("OK"))
+		Expect(call(ClientSetInfo(), "CLIENT SETINFO", "lib-ver", "9.0.5")).To(Equal("OK"))
+		Expect(client.LibName()).To(Equal("go-redis"))
+		Expect(client.LibVersion()).To(Equal("9.0.5"))
+
+		Expect(call(ClientSetInfo(), "CLIENT SETINFO", "lib-name", "go redis")).To(MatchError("ERR lib-name cannot contain spaces, newlines or special characters."))
+		Expect(call(ClientSetInfo(), "CLIENT SETINFO", "lib-foo", "x")).To(MatchError("ERR Unrecognized option 'lib-foo'"))
+		Expect(call(ClientSetInfo(), "CLIENT SETINFO", "lib-name")).To(MatchError("ERR wrong number of arguments for 'CLIENT SETINFO' command"))
+		Expect(client.LibName()).To(Equal("go-redis"))
+	})
+
+	It("should render CLIENT INFO and LIST", func() {
+		srv := NewServer(nil)
+		srv.info.register(client)
+		other := newClient(&mockConn{Port: 10002})
+		other.markPubSub()
+		srv.info.register(other)
+
+		Expect(client.SetName("conn-1")).To(Succeed())
+		Expect(call(ClientSetInfo(), "CLIENT SETINFO", "lib-name", "go-redis")).To(Equal("OK"))
+
+		Expect(call(ClientID(), "CLIENT ID")).To(Equal(int64(client.ID())))
+		Expect(call(ClientID(), "CLIENT ID", "x")).To(MatchError("ERR wrong number of arguments for 'CLIENT ID' command"))
+		Expect(call(ClientSelf(), "CLIENT INFO")).To(MatchRegexp(`^id=\d+ addr=1\.2\.3\.4:10001 name=conn-1 .* lib-name=go-redis lib-ver=\n$`))
+
+		list := call(ClientList(srv), "CLIENT LIST")
+		Expect(list).To(MatchRegexp(`^id=\d+ addr=1\.2\.3\.4:10001 name=conn-1 .*\nid=\d+ addr=1\.2\.3\.4:10002 name= .*\n$`))
+		Expect(call(ClientList(srv), "CLIENT LIST", "TYPE", "pubsub")).To(MatchRegexp(`^id=\d+ addr=1\.2\.3\.4:10002 [^\n]*\n$`))
+		Expect(call(ClientList(srv), "CLIENT LIST", "TYPE", "replica")).To(Equal(""))
+		atomic.StoreInt32(&other.replica, 1)
+		Expect(call(ClientList(srv), "CLIENT LIST", "TYPE", "slave")).To(MatchRegexp(`^id=\d+ addr=1\.2\.3\.4:10002 [^\n]*\n$`))
+		Expect(call(ClientList(srv), "CLIENT LIST", "ID", strconv.FormatUint(client.ID(), 10), "999999")).To(MatchRegexp(`^id=\d+ addr=1\.2\.3\.4:10001 [^\n]*\n$`))
+
+		Expect(call(ClientList(srv), "CLIENT LIST", "TYPE", "bad")).To(MatchError("ERR Unknown client type 'bad'"))
+		Expect(call(ClientList(srv), "CLIENT LIST", "ID", "x")).To(MatchError("ERR Invalid client ID"))
+		Expect(call(ClientList(srv), "CLIENT LIST", "BAD")).To(MatchError("ERR syntax error"))
+	})
+})
+
 var _ = Describe("CommandDescriptions", func() {
 	subject := CommandDescriptions{
 		{Name: "GeT", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStepCount: 1},
@@ -113,3 +497,28 @@ func (m *mockConn) RemoteAddr() net.Addr {
 func (m *mockConn) SetDeadline(_ time.Time) error      { return nil }
 func (m *mockConn) SetReadDeadline(_ time.Time) error  { return nil }
 func (m *mockConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+type mockResetter struct{ clients []*Client }
+
+func (m *mockResetter) ResetClient(c *Client) { m.clients = append(m.clients, c) }
+
+// --------------------------------------------------------------------
+
+func mkTestCert(commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,344 @@
+// Package redeobench implements a load generator for redis-protocol
+// servers. It mirrors the default redis-benchmark workloads and options, so
+// results are comparable, and can be used in Go benchmarks to catch
+// performance regressions.
+package redeobench
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Workload describes a benchmark test
+type Workload struct {
+	// Name is the test name, as used by redis-benchmark
+	Name string
+
+	write func(w *resp.RequestWriter, key, value []byte)
+}
+
+// Default workloads
+var (
+	Ping = Workload{Name: "PING_MBULK", write: func(w *resp.RequestWriter, _, _ []byte) {
+		w.WriteCmd("PING")
+	}}
+	Set = Workload{Name: "SET", write: func(w *resp.RequestWriter, key, value []byte) {
+		w.WriteCmd("SET", key, value)
+	}}
+	Get = Workload{Name: "GET", write: func(w *resp.RequestWriter, key, _ []byte) {
+		w.WriteCmd("GET", key)
+	}}
+)
+
+// ParseWorkloads parses a comma-separated list of test names, similar
+// to redis-benchmark's -t option, e.g. "ping,set,get".
+func ParseWorkloads(s string) ([]Workload, error) {
+	var res []Workload
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "ping", "ping_mbulk":
+			res = append(res, Ping)
+		case "set":
+			res = append(res, Set)
+		case "get":
+			res = append(res, Get)
+		case "":
+		default:
+			return nil, fmt.Errorf("redeobench: unknown test %q", name)
+		}
+	}
+	return res, nil
+}
+
+// --------------------------------------------------------------------
+
+// Options configure a benchmark run, the defaults match redis-benchmark.
+type Options struct {
+	// Clients is the number of parallel connections.
+	// Default: 50
+	Clients int
+
+	// Requests is the total number of requests.
+	// Default: 100000
+	Requests int
+
+	// Pipeline is the number of requests sent per round-trip.
+	// Default: 1 (no pipelining)
+	Pipeline int
+
+	// DataSize is the size of SET values in bytes.
+	// Default: 3
+	DataSize int
+
+	// KeySpace enables random keys in the range of [0, KeySpace).
+	// Default: 0 (all requests use the same key)
+	KeySpace int
+}
+
+func (o *Options) norm() {
+	if o.Clients < 1 {
+		o.Clients = 50
+	}
+	if o.Requests < 1 {
+		o.Requests = 100000
+	}
+	if o.Pipeline < 1 {
+		o.Pipeline = 1
+	}
+	if o.DataSize < 1 {
+		o.DataSize = 3
+	}
+}
+
+// Result contains the results of a benchmark run
+type Result struct {
+	// Name is the workload name
+	Name string
+	// Requests is the number of completed requests
+	Requests int
+	// Duration is the total duration of the run
+	Duration time.Duration
+
+	latencies []time.Duration
+}
+
+// RPS returns the number of requests per second
+func (r *Result) RPS() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// Percentile returns the request latency at the given percentile (0-100).
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+
+	i := int(float64(len(r.latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(r.latencies) {
+		i = len(r.latencies) - 1
+	}
+	return r.latencies[i]
+}
+
+// String returns a summary, similar to the output of redis-benchmark -q
+func (r *Result) String() string {
+	return fmt.Sprintf("%s: %.2f requests per second, p50=%.3f msec, p99=%.3f msec",
+		r.Name, r.RPS(), msec(r.Percentile(50)), msec(r.Percentile(99)))
+}
+
+func msec(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+// --------------------------------------------------------------------
+
+// Run runs a workload against a server listening on addr.
+func Run(addr string, wl Workload, opt *Options) (*Result, error) {
+	var o Options
+	if opt != nil {
+		o = *opt
+	}
+	o.norm()
+
+	// distribute requests across clients
+	shares := make([]int, o.Clients)
+	for i := range shares {
+		shares[i] = o.Requests / o.Clients
+		if i < o.Requests%o.Clients {
+			shares[i]++
+		}
+	}
+
+	// connect all clients first
+	conns := make([]net.Conn, 0, o.Clients)
+	defer func() {
+		for _, cn := range conns {
+			_ = cn.Close()
+		}
+	}()
+	for range shares {
+		cn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, cn)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		latencies = make([]time.Duration, 0, o.Requests)
+		firstErr  error
+	)
+
+	start := time.Now()
+	for i, cn := range conns {
+		wg.Add(1)
+		go func(cn net.Conn, n int, seed int64) {
+			defer wg.Done()
+
+			lat, err := runClient(cn, wl, &o, n, seed)
+
+			mu.Lock()
+			latencies = append(latencies, lat...)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}(cn, shares[i], int64(i))
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return &Result{
+		Name:      wl.Name,
+		Requests:  len(latencies),
+		Duration:  time.Since(start),
+		latencies: latencies,
+	}, nil
+}
+
+func runClient(cn net.Conn, wl Workload, o *Options, n int, seed int64) ([]time.Duration, error) {
+	rnd := rand.New(rand.NewSource(seed))
+	w := resp.NewRequestWriter(cn)
+	r := resp.NewResponseReader(cn)
+
+	key := []byte("key:__rand_int__")
+	value := []byte(strings.Repeat("x", o.DataSize))
+	latencies := make([]time.Duration, 0, n)
+
+	var buf []byte
+	for n > 0 {
+		batch := o.Pipeline
+		if batch > n {
+			batch = n
+		}
+
+		for i := 0; i < batch; i++ {
+			if o.KeySpace > 0 {
+				key = appendKey(key[:0], rnd.Intn(o.KeySpace))
+			}
+			wl.write(w, key, value)
+		}
+
+		start := time.Now()
+		if err := w.Flush(); err != nil {
+			return latencies, err
+		}
+
+		for i := 0; i < batch; i++ {
+			var err error
+			if buf, err = readReply(r, buf[:0]); err != nil {
+				return latencies, err
+			}
+		}
+
+		// like redis-benchmark, all requests of a pipeline
+		// share the latency of the round-trip
+		rtt := time.Since(start)
+		for i := 0; i < batch; i++ {
+			latencies = append(latencies, rtt)
+		}
+		n -= batch
+	}
+	return latencies, nil
+}
+
+// appendKey appends a key in the format used by redis-benchmark -r
+func appendKey(dst []byte, n int) []byte {
+	dst = append(dst, "key:"...)
+	s := strconv.Itoa(n)
+	for i := len(s); i < 12; i++ {
+		dst = append(dst, '0')
+	}
+	return append(dst, s...)
+}
+
+func readReply(r resp.ResponseReader, buf []byte) ([]byte, error) {
+	t, err := r.PeekType()
+	if err != nil {
+		return buf, err
+	}
+
+	switch t {
+	case resp.TypeInline:
+		_, err = r.ReadInlineString()
+	case resp.TypeBulk:
+		buf, err = r.ReadBulk(buf)
+	case resp.TypeNil:
+		err = r.ReadNil()
+	case resp.TypeInt:
+		_, err = r.ReadInt()
+	case resp.TypeError:
+		var msg string
+		if msg, err = r.ReadError(); err == nil {
+			err = errors.New(msg)
+		}
+	default:
+		err = fmt.Errorf("redeobench: unexpected response type %s", t)
+	}
+	return buf, err
+}
+
+// --------------------------------------------------------------------
+
+// NewServer returns a minimal in-memory server, which supports the
+// PING, SET and GET commands and can serve as a benchmark target.
+func NewServer() *redeo.Server {
+	var (
+		data = make(map[string][]byte)
+		mu   sync.RWMutex
+	)
+
+	srv := redeo.NewServer(nil)
+	srv.Handle("ping", redeo.Ping())
+	srv.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 2 {
+			w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		key := c.Arg(0).String()
+		val := append([]byte(nil), c.Arg(1)...)
+
+		mu.Lock()
+		data[key] = val
+		mu.Unlock()
+
+		w.AppendOK()
+	})
+	srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		mu.RLock()
+		val, ok := data[c.Arg(0).String()]
+		mu.RUnlock()
+
+		if ok {
+			w.AppendBulk(val)
+		} else {
+			w.AppendNil()
+		}
+	})
+	return srv
+}
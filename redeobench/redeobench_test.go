@@ -0,0 +1,108 @@
+package redeobench_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/redeobench"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run", func() {
+	var lis net.Listener
+
+	BeforeEach(func() {
+		var err error
+		lis, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go redeobench.NewServer().Serve(lis)
+	})
+
+	AfterEach(func() {
+		Expect(lis.Close()).To(Succeed())
+	})
+
+	It("should run workloads", func() {
+		for _, wl := range []redeobench.Workload{redeobench.Ping, redeobench.Set, redeobench.Get} {
+			res, err := redeobench.Run(lis.Addr().String(), wl, &redeobench.Options{
+				Clients:  3,
+				Requests: 100,
+				Pipeline: 8,
+				KeySpace: 10,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.Name).To(Equal(wl.Name))
+			Expect(res.Requests).To(Equal(100))
+			Expect(res.RPS()).To(BeNumerically(">", 0))
+			Expect(res.Percentile(50)).To(BeNumerically(">", 0))
+			Expect(res.Percentile(99)).To(BeNumerically(">=", res.Percentile(50)))
+			Expect(res.String()).To(MatchRegexp(`^%s: \d+\.\d\d requests per second, p50=`, wl.Name))
+		}
+	})
+
+	It("should fail on error replies", func() {
+		other, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer other.Close()
+		go redeo.NewServer(nil).Serve(other)
+
+		_, err = redeobench.Run(other.Addr().String(), redeobench.Ping, &redeobench.Options{
+			Clients:  2,
+			Requests: 10,
+		})
+		Expect(err).To(MatchError("ERR unknown command 'PING'"))
+	})
+
+})
+
+var _ = Describe("ParseWorkloads", func() {
+
+	It("should parse", func() {
+		wls, err := redeobench.ParseWorkloads("PING, set,get")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(wls).To(HaveLen(3))
+		Expect(wls[0].Name).To(Equal("PING_MBULK"))
+		Expect(wls[1].Name).To(Equal("SET"))
+		Expect(wls[2].Name).To(Equal("GET"))
+
+		_, err = redeobench.ParseWorkloads("ping,incr")
+		Expect(err).To(MatchError(`redeobench: unknown test "incr"`))
+	})
+
+})
+
+// --------------------------------------------------------------------
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "redeobench")
+}
+
+func BenchmarkPing(b *testing.B)           { benchmark(b, redeobench.Ping, 1) }
+func BenchmarkPing_pipelined(b *testing.B) { benchmark(b, redeobench.Ping, 16) }
+func BenchmarkSet(b *testing.B)            { benchmark(b, redeobench.Set, 1) }
+func BenchmarkSet_pipelined(b *testing.B)  { benchmark(b, redeobench.Set, 16) }
+func BenchmarkGet(b *testing.B)            { benchmark(b, redeobench.Get, 1) }
+func BenchmarkGet_pipelined(b *testing.B)  { benchmark(b, redeobench.Get, 16) }
+
+func benchmark(b *testing.B, wl redeobench.Workload, pipeline int) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer lis.Close()
+
+	go redeobench.NewServer().Serve(lis)
+
+	b.ResetTimer()
+	if _, err := redeobench.Run(lis.Addr().String(), wl, &redeobench.Options{
+		Clients:  4,
+		Requests: b.N,
+		Pipeline: pipeline,
+		KeySpace: 1000,
+	}); err != nil {
+		b.Fatal(err)
+	}
+}
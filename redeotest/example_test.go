@@ -30,3 +30,35 @@ func ExampleResponseRecorder() {
 	// "*3\r\n$3\r\nbob\r\n$11\r\nresponds to\r\n$4\r\ncall\r\n"
 	// [bob responds to call] <nil>
 }
+
+func ExampleResponseRecorder_Replies() {
+	handler := func(w resp.ResponseWriter, c *resp.Command) {
+		w.AppendArrayLen(2)
+		w.AppendInt(int64(c.ArgN()))
+		w.AppendBulkString(c.Arg(0).String())
+		w.AppendNil()
+	}
+
+	w := redeotest.NewRecorder()
+	handler(w, redeotest.NewCommand("call", "bob", "alice"))
+	fmt.Println(w.Replies())
+
+	// Output:
+	// [Array [Int 2 Bulk bob] Nil] <nil>
+}
+
+func ExampleTranscript() {
+	srv := redeo.NewServer(nil)
+	srv.Handle("ping", redeo.Ping())
+	srv.Handle("echo", redeo.Echo())
+
+	out, err := redeotest.Transcript(srv,
+		"PING\r\n",
+		"*2\r\n$4\r\necho\r\n$5\r\nhel",
+		"lo\r\n",
+	)
+	fmt.Printf("%q %v\n", out, err)
+
+	// Output:
+	// "+PONG\r\n$5\r\nhello\r\n" <nil>
+}
@@ -0,0 +1,129 @@
+package redeotest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+)
+
+// ConnServer is implemented by servers which can serve individual
+// connections, such as *redeo.Server.
+type ConnServer interface {
+	ServeConn(cn net.Conn, buffered []byte) error
+}
+
+// TestingT is the subset of testing.TB used by CheckGolden.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// GoldenTimeout is the max time CheckGolden waits for the expected
+// replies.
+var GoldenTimeout = 5 * time.Second
+
+// GoldenSettle is the period of silence after which a reply stream is
+// considered complete.
+var GoldenSettle = 100 * time.Millisecond
+
+// UpdateGoldenEnv is the environment variable which, when set, makes
+// CheckGolden (re)write golden files instead of comparing against them.
+const UpdateGoldenEnv = "REDEO_UPDATE_GOLDEN"
+
+// Transcript feeds raw client frames to srv, via an in-memory
+// connection, and returns the raw reply stream. Frames are written in
+// order, as they are, and may contain partial or multiple requests. The
+// stream is complete once the server has been silent for GoldenSettle.
+func Transcript(srv ConnServer, frames ...string) ([]byte, error) {
+	return transcript(srv, frames, -1)
+}
+
+// CheckGolden runs a Transcript and compares the reply stream byte by
+// byte with the contents of the golden file at path. Set UpdateGoldenEnv
+// to record the golden file instead.
+func CheckGolden(t TestingT, srv ConnServer, path string, frames ...string) {
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		got, err := Transcript(srv, frames...)
+		if err != nil {
+			t.Errorf("redeotest: transcript failed: %v", err)
+			return
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Errorf("redeotest: %v", err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("redeotest: %v", err)
+		return
+	}
+
+	got, err := transcript(srv, frames, len(want))
+	if err != nil {
+		t.Errorf("redeotest: transcript failed: %v", err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("redeotest: replies differ from %s at byte %d\n got: %q\nwant: %q", path, diffOffset(got, want), got, want)
+	}
+}
+
+// transcript reads the reply stream, waiting up to GoldenTimeout for
+// the first n bytes, if n is known
+func transcript(srv ConnServer, frames []string, n int) ([]byte, error) {
+	cn, sn := net.Pipe()
+	defer cn.Close()
+
+	if err := srv.ServeConn(sn, nil); err != nil {
+		return nil, err
+	}
+
+	// write concurrently, as pipes are unbuffered; errors are
+	// ignored since servers may close the connection, e.g. on QUIT
+	go func() {
+		for _, frame := range frames {
+			if _, err := io.WriteString(cn, frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	chunk := make([]byte, 4096)
+	deadline := time.Now().Add(GoldenTimeout)
+	for {
+		if buf.Len() < n {
+			_ = cn.SetReadDeadline(deadline)
+		} else {
+			_ = cn.SetReadDeadline(time.Now().Add(GoldenSettle))
+		}
+
+		m, err := cn.Read(chunk)
+		buf.Write(chunk[:m])
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			break
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			return buf.Bytes(), err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// diffOffset returns the offset of the first differing byte
+func diffOffset(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) < len(b) {
+		return len(a)
+	}
+	return len(b)
+}
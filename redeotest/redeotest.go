@@ -15,6 +15,32 @@ type ErrorResponse string
 // Error implements error interface
 func (e ErrorResponse) Error() string { return string(e) }
 
+// Reply is a typed reply, as recorded by ResponseRecorder. Values are
+// strings for bulk and inline replies, int64 for integers, ErrorResponse
+// for errors, nil for nil replies and []Reply for arrays.
+type Reply struct {
+	Type  resp.ResponseType
+	Value interface{}
+}
+
+// String returns a readable description of the reply, e.g. "Int 3"
+func (r Reply) String() string {
+	if r.Type == resp.TypeNil {
+		return r.Type.String()
+	}
+	return fmt.Sprintf("%s %v", r.Type, r.Value)
+}
+
+// NewCommand creates a new command with string arguments, for passing to
+// handlers in tests.
+func NewCommand(name string, args ...string) *resp.Command {
+	cmd := resp.NewCommand(name)
+	for _, arg := range args {
+		cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+	}
+	return cmd
+}
+
 // ResponseRecorder is an implementation of resp.ResponseWriter that
 // is helpful in tests.
 type ResponseRecorder struct {
@@ -31,6 +57,10 @@ func NewRecorder() *ResponseRecorder {
 	}
 }
 
+// Unwrap returns the wrapped writer, so the RESP3 replies of
+// resp.ExtendedResponseWriter are recorded, see resp.Extended
+func (r *ResponseRecorder) Unwrap() resp.ResponseWriter { return r.ResponseWriter }
+
 // Len returns the raw byte length
 func (r *ResponseRecorder) Len() int {
 	_ = r.ResponseWriter.Flush()
@@ -74,6 +104,49 @@ func (r *ResponseRecorder) Responses() ([]interface{}, error) {
 	return vv, nil
 }
 
+// Replies returns all responses, typed
+func (r *ResponseRecorder) Replies() ([]Reply, error) {
+	_ = r.ResponseWriter.Flush()
+
+	rr := resp.NewResponseReader(bytes.NewReader(r.b.Bytes()))
+	replies := make([]Reply, 0)
+	for {
+		reply, err := parseReply(rr)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return replies, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+func parseReply(rr resp.ResponseReader) (Reply, error) {
+	typ, err := rr.PeekType()
+	if err != nil {
+		return Reply{}, err
+	}
+
+	if typ != resp.TypeArray {
+		v, err := parseResult(rr)
+		return Reply{Type: typ, Value: v}, err
+	}
+
+	sz, err := rr.ReadArrayLen()
+	if err != nil {
+		return Reply{}, err
+	}
+
+	vv := make([]Reply, sz)
+	for i := range vv {
+		if vv[i], err = parseReply(rr); err != nil {
+			return Reply{}, err
+		}
+	}
+	return Reply{Type: typ, Value: vv}, nil
+}
+
 func parseResult(rr resp.ResponseReader) (interface{}, error) {
 	typ, err := rr.PeekType()
 	if err != nil {
@@ -0,0 +1,98 @@
+package redeo
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// serverConfig is the effective configuration of a server
+type serverConfig struct {
+	*Config
+
+	// command timeout overrides, by lower-case name
+	timeouts map[string]time.Duration
+}
+
+func newServerConfig(config *Config) *serverConfig {
+	sc := &serverConfig{Config: config}
+	if len(config.CommandTimeouts) != 0 {
+		sc.timeouts = make(map[string]time.Duration, len(config.CommandTimeouts))
+		for name, d := range config.CommandTimeouts {
+			sc.timeouts[resp.CanonicalName(name)] = d
+		}
+	}
+	return sc
+}
+
+// Reload applies a new configuration at runtime. Timeouts, limits and
+// callbacks take effect with the next request, buffer sizes and
+// keep-alive periods with the next connection. ReadOnly and Version are
+// applied immediately. Acceptors and EventLoop require a restart, they
+// keep their current values and are returned by name if they differ.
+// Invalid configurations are rejected as a whole, see Config.Validate.
+func (srv *Server) Reload(config *Config) (restart []string, err error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	// copy, so callers cannot modify the config in use
+	next := *config
+	current := srv.conf()
+	if next.Acceptors != current.Acceptors {
+		restart = append(restart, "Acceptors")
+		next.Acceptors = current.Acceptors
+	}
+	if next.EventLoop != current.EventLoop {
+		restart = append(restart, "EventLoop")
+		next.EventLoop = current.EventLoop
+	}
+
+	version := next.Version
+	if version == "" {
+		version = DefaultVersion
+	}
+
+	srv.config.Store(newServerConfig(&next))
+	srv.info.version.Set(version)
+	if next.ReadOnly != current.ReadOnly {
+		srv.SetReadOnly(next.ReadOnly)
+	}
+	return restart, nil
+}
+
+// ReloadOnSignal reloads the configuration whenever the process receives
+// one of the given signals, SIGHUP by default. Load is called to obtain
+// the new configuration, e.g. by parsing a file, and report, if not nil,
+// is called with the outcome of each reload, see Reload. It blocks until
+// ctx is done.
+func (srv *Server) ReloadOnSignal(ctx context.Context, load func() (*Config, error), report func(restart []string, err error), sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+
+		config, err := load()
+		var restart []string
+		if err == nil {
+			restart, err = srv.Reload(config)
+		}
+		if report != nil {
+			report(restart, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package redeo
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reload", func() {
+	var subject *Server
+
+	BeforeEach(func() {
+		subject = NewServer(&Config{Timeout: time.Second, Acceptors: 1})
+	})
+
+	It("should apply reloadable settings", func() {
+		config := &Config{
+			Timeout:         5 * time.Second,
+			CommandTimeouts: map[string]time.Duration{"BLPOP": 0},
+			Acceptors:       1,
+			ReadOnly:        true,
+			Version:         "6.2.14",
+		}
+		Expect(subject.Reload(config)).To(BeEmpty())
+		Expect(subject.conf().Timeout).To(Equal(5 * time.Second))
+		Expect(subject.conf().timeouts).To(Equal(map[string]time.Duration{"blpop": 0}))
+		Expect(subject.ReadOnly()).To(BeTrue())
+		Expect(subject.Info().Version()).To(Equal("6.2.14"))
+
+		config.Timeout = time.Minute
+		Expect(subject.conf().Timeout).To(Equal(5 * time.Second))
+
+		Expect(subject.Reload(&Config{Acceptors: 1})).To(BeEmpty())
+		Expect(subject.ReadOnly()).To(BeFalse())
+		Expect(subject.Info().Version()).To(Equal(DefaultVersion))
+	})
+
+	It("should report settings which require a restart", func() {
+		Expect(subject.Reload(&Config{Acceptors: 4, EventLoop: true})).To(Equal([]string{"Acceptors", "EventLoop"}))
+		Expect(subject.conf().Acceptors).To(Equal(1))
+		Expect(subject.conf().EventLoop).To(BeFalse())
+	})
+
+	It("should reject invalid configs", func() {
+		_, err := subject.Reload(&Config{Timeout: -time.Second})
+		Expect(err).To(MatchError("redeo: invalid config: Timeout -1s must not be negative"))
+		Expect(subject.conf().Timeout).To(Equal(time.Second))
+	})
+
+	It("should reload on signals", func() {
+		// keep the default handler from terminating the process
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGHUP)
+		defer signal.Stop(sigs)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		reloads := make(chan error, 10)
+		go func() {
+			defer close(done)
+			subject.ReloadOnSignal(ctx, func() (*Config, error) {
+				return &Config{Timeout: 3 * time.Second, Acceptors: 1}, nil
+			}, func(_ []string, err error) {
+				reloads <- err
+			})
+		}()
+
+		proc, err := os.FindProcess(os.Getpid())
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() int {
+			Expect(proc.Signal(syscall.SIGHUP)).To(Succeed())
+			return len(reloads)
+		}).Should(BeNumerically(">", 0))
+		Expect(<-reloads).NotTo(HaveOccurred())
+		Expect(subject.conf().Timeout).To(Equal(3 * time.Second))
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+
+})
@@ -0,0 +1,356 @@
+package redeo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+var errBadRDB = errors.New("redeo: invalid RDB snapshot")
+
+// ReplicaOptions configure a Replica
+type ReplicaOptions struct {
+	// ListenPort is announced to the master via REPLCONF listening-port.
+	// Default: 0 (not announced)
+	ListenPort int
+
+	// LoadRDB is called with the RDB snapshot sent by the master on full
+	// resynchronisation, e.g. to populate the local store. Unread data is
+	// discarded once LoadRDB returns.
+	// Default: nil (snapshots are validated and discarded)
+	LoadRDB func(r io.Reader) error
+
+	// AckInterval is the interval at which the replication offset is
+	// acknowledged to the master.
+	// Default: 1s
+	AckInterval time.Duration
+
+	// RetryInterval is the delay before the REPLICAOF handler reconnects
+	// after the link to the master was lost.
+	// Default: 1s
+	RetryInterval time.Duration
+}
+
+func (o *ReplicaOptions) norm() {
+	if o.AckInterval <= 0 {
+		o.AckInterval = time.Second
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+}
+
+// Replica replicates from a Redis master. It performs the PSYNC handshake
+// and feeds the master's command stream into the server via Apply.
+// Replicas implement ReplicationProvider.
+type Replica struct {
+	srv  *Server
+	addr string
+	opt  ReplicaOptions
+
+	mu     sync.Mutex
+	state  string
+	replID string
+	offset int64
+}
+
+// NewReplica creates a replica of the master at addr
+func NewReplica(srv *Server, addr string, opt *ReplicaOptions) *Replica {
+	var o ReplicaOptions
+	if opt != nil {
+		o = *opt
+	}
+	o.norm()
+
+	return &Replica{srv: srv, addr: addr, opt: o, state: "connect"}
+}
+
+// Addr returns the master address
+func (r *Replica) Addr() string { return r.addr }
+
+// Offset returns the replication offset
+func (r *Replica) Offset() int64 {
+	r.mu.Lock()
+	n := r.offset
+	r.mu.Unlock()
+	return n
+}
+
+// ReplicationInfo implements ReplicationProvider
+func (r *Replica) ReplicationInfo() *ReplicationInfo {
+	host, sport, _ := net.SplitHostPort(r.addr)
+	port, _ := strconv.Atoi(sport)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &ReplicationInfo{
+		Role:        RoleSlave,
+		Offset:      r.offset,
+		MasterHost:  host,
+		MasterPort:  port,
+		MasterState: r.state,
+	}
+}
+
+// Run connects to the master, synchronises and applies the replicated
+// command stream until ctx is cancelled or the link fails. Run may be
+// called again to reconnect, which attempts a partial resynchronisation.
+func (r *Replica) Run(ctx context.Context) error {
+	r.setState("connecting")
+	defer r.setState("connect")
+
+	var d net.Dialer
+	cn, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		// dials cancelled by ctx fail with an OpError
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	defer cn.Close()
+
+	// unblock reads once ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = cn.Close()
+		case <-done:
+		}
+	}()
+
+	err = r.sync(cn)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (r *Replica) sync(cn net.Conn) error {
+	br := bufio.NewReader(cn)
+	w := resp.NewRequestWriter(cn)
+
+	// handshake
+	if _, err := r.call(w, br, "PING"); err != nil {
+		return err
+	}
+	if r.opt.ListenPort > 0 {
+		if _, err := r.call(w, br, "REPLCONF", "listening-port", strconv.Itoa(r.opt.ListenPort)); err != nil {
+			return err
+		}
+	}
+	if _, err := r.call(w, br, "REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	replID, offset := r.replID, r.offset
+	r.mu.Unlock()
+
+	psync := []string{"PSYNC", "?", "-1"}
+	if replID != "" {
+		psync = []string{"PSYNC", replID, strconv.FormatInt(offset+1, 10)}
+	}
+	reply, err := r.call(w, br, psync...)
+	if err != nil {
+		return err
+	}
+
+	fields := append(strings.Fields(reply), "")
+	switch fields[0] {
+	case "FULLRESYNC":
+		if len(fields) != 4 {
+			return fmt.Errorf("redeo: unexpected PSYNC reply %q", reply)
+		}
+		if offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return fmt.Errorf("redeo: unexpected PSYNC reply %q", reply)
+		}
+
+		r.setState("sync")
+		if err := r.loadRDB(br); err != nil {
+			return err
+		}
+		replID = fields[1]
+	case "CONTINUE":
+		if fields[1] != "" {
+			replID = fields[1]
+		}
+	default:
+		return fmt.Errorf("redeo: unexpected PSYNC reply %q", reply)
+	}
+
+	r.mu.Lock()
+	r.replID, r.offset, r.state = replID, offset, "connected"
+	r.mu.Unlock()
+
+	return r.stream(cn, br, w)
+}
+
+// stream applies the replicated command stream
+func (r *Replica) stream(cn net.Conn, br *bufio.Reader, w *resp.RequestWriter) error {
+	buffered, _ := br.Peek(br.Buffered())
+	cr := &countingReader{r: cn, n: int64(len(buffered))}
+	rd := resp.NewRequestReaderBuffered(cr, buffered)
+
+	var wmu sync.Mutex
+	ack := func(offset int64) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+
+		w.WriteCmdString("REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+		return w.Flush()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(r.opt.AckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if ack(r.Offset()) != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	start := r.Offset()
+	var cmd *resp.Command
+	for {
+		var err error
+		if cmd, err = rd.ReadCmd(cmd); err != nil {
+			return err
+		}
+
+		switch cmd.CanonicalName() {
+		case "replconf":
+			// the offset acknowledged excludes the GETACK itself
+			if cmd.ArgN() != 0 && strings.EqualFold(cmd.Arg(0).String(), "getack") {
+				if err := ack(r.Offset()); err != nil {
+					return err
+				}
+			}
+		case "ping":
+		default:
+			// like Redis, replicas do not report errors to the master
+			_ = r.srv.Apply(cmd)
+		}
+
+		r.mu.Lock()
+		r.offset = start + cr.n - int64(rd.Buffered())
+		r.mu.Unlock()
+	}
+}
+
+// loadRDB reads the RDB snapshot of a full resynchronisation
+func (r *Replica) loadRDB(br *bufio.Reader) error {
+	// skip newlines, sent as keep-alives while the snapshot is generated
+	var line string
+	for line == "" {
+		var err error
+		if line, err = br.ReadString('\n'); err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+	}
+
+	if line[0] != '$' {
+		return fmt.Errorf("redeo: unexpected snapshot header %q", line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 9 {
+		return errBadRDB
+	}
+
+	// check the preamble, i.e. REDIS followed by a 4-digit version
+	lr := io.LimitReader(br, size)
+	magic := make([]byte, 9)
+	if _, err := io.ReadFull(lr, magic); err != nil {
+		return err
+	}
+	if string(magic[:5]) != "REDIS" {
+		return errBadRDB
+	}
+	if _, err := strconv.ParseUint(string(magic[5:9]), 10, 16); err != nil {
+		return errBadRDB
+	}
+
+	rdb := io.MultiReader(bytes.NewReader(magic), lr)
+	if r.opt.LoadRDB != nil {
+		if err := r.opt.LoadRDB(rdb); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(ioutil.Discard, rdb)
+	return err
+}
+
+// call sends a command during the handshake and returns the status reply
+func (r *Replica) call(w *resp.RequestWriter, br *bufio.Reader, args ...string) (string, error) {
+	w.WriteCmdString(args[0], args[1:]...)
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	switch {
+	case strings.HasPrefix(line, "+") && len(line) > 1:
+		return line[1:], nil
+	case strings.HasPrefix(line, "-"):
+		return "", errors.New(line[1:])
+	}
+	return "", fmt.Errorf("redeo: unexpected %s reply %q", args[0], line)
+}
+
+func (r *Replica) setState(s string) {
+	r.mu.Lock()
+	r.state = s
+	r.mu.Unlock()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// --------------------------------------------------------------------
+
+// ReplicaOf returns a REPLICAOF handler, also known as SLAVEOF. REPLICAOF
+// host port turns the server into a read-only replica of the given master
+// and reconnects whenever the link is lost, REPLICAOF NO ONE stops
+// replication and makes the server writable again. See Failover for
+// more control over role transitions.
+// https://redis.io/commands/replicaof
+func ReplicaOf(s *Server, opt *ReplicaOptions) Handler {
+	return NewFailover(s, &FailoverOptions{Replica: opt}).ReplicaOf()
+}
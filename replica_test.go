@@ -0,0 +1,191 @@
+package redeo
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replica", func() {
+	var srv *Server
+	var master net.Listener
+	var data map[string]string
+	var mu sync.Mutex
+
+	// expect reads the next command from the replica
+	var expect = func(rd *resp.RequestReader, args ...string) {
+		cmd, err := rd.ReadCmd(nil)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+		act := []string{cmd.Name}
+		for _, arg := range cmd.Args {
+			act = append(act, arg.String())
+		}
+		ExpectWithOffset(1, act).To(Equal(args))
+	}
+
+	var get = func(key string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return data[key]
+	}
+
+	BeforeEach(func() {
+		data = make(map[string]string)
+		srv = NewServer(nil)
+		srv.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+			mu.Lock()
+			data[c.Arg(0).String()] = c.Arg(1).String()
+			mu.Unlock()
+			w.AppendOK()
+		})
+
+		var err error
+		master, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		master.Close()
+	})
+
+	It("should replicate", func() {
+		var rdb []byte
+		subject := NewReplica(srv, master.Addr().String(), &ReplicaOptions{
+			ListenPort:  6380,
+			AckInterval: time.Hour,
+			LoadRDB: func(r io.Reader) (err error) {
+				rdb, err = ioutil.ReadAll(r)
+				return
+			},
+		})
+
+		// full resync
+		done := make(chan error, 1)
+		go func() { done <- subject.Run(context.Background()) }()
+
+		cn, err := master.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		rd := resp.NewRequestReader(cn)
+
+		expect(rd, "PING")
+		_, _ = cn.Write([]byte("+PONG\r\n"))
+		expect(rd, "REPLCONF", "listening-port", "6380")
+		_, _ = cn.Write([]byte("+OK\r\n"))
+		expect(rd, "REPLCONF", "capa", "psync2")
+		_, _ = cn.Write([]byte("+OK\r\n"))
+		expect(rd, "PSYNC", "?", "-1")
+		_, _ = cn.Write([]byte("+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 100\r\n\n\n$13\r\nREDIS0011\xfa\x00\xff\x00"))
+		_, _ = cn.Write([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"))
+		_, _ = cn.Write([]byte("*3\r\n$8\r\nREPLCONF\r\n$6\r\nGETACK\r\n$1\r\n*\r\n"))
+
+		expect(rd, "REPLCONF", "ACK", "133")
+		Expect(get("key")).To(Equal("value"))
+		Expect(rdb).To(Equal([]byte("REDIS0011\xfa\x00\xff\x00")))
+		Eventually(subject.Offset).Should(Equal(int64(170)))
+		Expect(subject.ReplicationInfo()).To(Equal(&ReplicationInfo{
+			Role:        RoleSlave,
+			Offset:      170,
+			MasterHost:  "127.0.0.1",
+			MasterPort:  master.Addr().(*net.TCPAddr).Port,
+			MasterState: "connected",
+		}))
+
+		// disconnect
+		Expect(cn.Close()).To(Succeed())
+		Eventually(done).Should(Receive(HaveOccurred()))
+		Expect(subject.ReplicationInfo().MasterState).To(Equal("connect"))
+
+		// partial resync
+		go func() { done <- subject.Run(context.Background()) }()
+
+		cn, err = master.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+		rd = resp.NewRequestReader(cn)
+
+		expect(rd, "PING")
+		_, _ = cn.Write([]byte("+PONG\r\n"))
+		expect(rd, "REPLCONF", "listening-port", "6380")
+		_, _ = cn.Write([]byte("+OK\r\n"))
+		expect(rd, "REPLCONF", "capa", "psync2")
+		_, _ = cn.Write([]byte("+OK\r\n"))
+		expect(rd, "PSYNC", "8de1787ba490483314a4d30f1c628bc5025eb761", "171")
+		_, _ = cn.Write([]byte("+CONTINUE\r\n*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nother\r\n"))
+
+		Eventually(func() string { return get("key") }).Should(Equal("other"))
+		Eventually(subject.Offset).Should(Equal(int64(203)))
+	})
+
+	It("should fail on handshake errors", func() {
+		subject := NewReplica(srv, master.Addr().String(), nil)
+
+		done := make(chan error, 1)
+		go func() { done <- subject.Run(context.Background()) }()
+
+		cn, err := master.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		expect(resp.NewRequestReader(cn), "PING")
+		_, _ = cn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+		Eventually(done).Should(Receive(MatchError("NOAUTH Authentication required.")))
+	})
+
+	It("should stop when cancelled", func() {
+		subject := NewReplica(srv, master.Addr().String(), nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- subject.Run(ctx) }()
+
+		cn, err := master.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		cancel()
+		Expect(<-done).To(Equal(context.Canceled))
+	})
+
+})
+
+var _ = Describe("ReplicaOf", func() {
+
+	It("should toggle replication", func() {
+		srv := NewServer(nil)
+		subject := ReplicaOf(srv, &ReplicaOptions{RetryInterval: 10 * time.Millisecond})
+
+		var call = func(args ...string) interface{} {
+			cmd := resp.NewCommand("REPLICAOF")
+			for _, arg := range args {
+				cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+			}
+			w := redeotest.NewRecorder()
+			subject.ServeRedeo(w, cmd)
+			v, _ := w.Response()
+			return v
+		}
+
+		Expect(call("127.0.0.1", "1")).To(Equal("OK"))
+		Expect(srv.ReadOnly()).To(BeTrue())
+		Expect(srv.Info().Replication().Role).To(Equal(RoleSlave))
+		Expect(srv.Info().Replication().MasterPort).To(Equal(1))
+		Expect(call("127.0.0.1", "1")).To(Equal("OK Already connected to specified master"))
+
+		Expect(call("no", "one")).To(Equal("OK"))
+		Expect(srv.ReadOnly()).To(BeFalse())
+		Expect(srv.Info().Replication().Role).To(Equal(RoleMaster))
+
+		Expect(call("127.0.0.1", "x")).To(MatchError("ERR Invalid master port"))
+		Expect(call("127.0.0.1")).To(MatchError("ERR wrong number of arguments for 'REPLICAOF' command"))
+	})
+
+})
@@ -0,0 +1,190 @@
+package redeo
+
+import (
+	"strconv"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Replication roles, as reported by ROLE and INFO
+const (
+	RoleMaster   = "master"
+	RoleSlave    = "slave"
+	RoleSentinel = "sentinel"
+)
+
+// ReplicaInfo describes a replica connected to a master
+type ReplicaInfo struct {
+	// Host and Port identify the replica's listening address
+	Host string
+	Port int
+
+	// State is the replica's state, e.g. "online"
+	State string
+
+	// Offset is the replication offset acknowledged by the replica
+	Offset int64
+
+	// Lag is the number of seconds since the last acknowledgement
+	Lag int64
+}
+
+// ReplicationInfo describes the replication state of a server
+type ReplicationInfo struct {
+	// Role is one of RoleMaster, RoleSlave or RoleSentinel
+	Role string
+
+	// Offset is the server's replication offset
+	Offset int64
+
+	// Replicas lists connected replicas (masters only)
+	Replicas []ReplicaInfo
+
+	// MasterHost and MasterPort identify the master (replicas only)
+	MasterHost string
+	MasterPort int
+
+	// MasterState is the state of the link to the master, i.e. one of
+	// "connect", "connecting", "sync" or "connected" (replicas only)
+	MasterState string
+
+	// Masters lists the names of monitored masters (sentinels only)
+	Masters []string
+
+	// FailoverState is the state of a coordinated failover, i.e. one of
+	// "no-failover" or "failover-in-progress" (masters only, optional)
+	FailoverState string
+
+	// Backlog describes the replication backlog (masters only, optional)
+	Backlog *BacklogInfo
+}
+
+// BacklogInfo describes a replication backlog
+type BacklogInfo struct {
+	// Size is the capacity in bytes
+	Size int64
+
+	// FirstByteOffset is the replication offset of the first byte held
+	FirstByteOffset int64
+
+	// HistLen is the number of bytes held
+	HistLen int64
+}
+
+// ReplicationProvider reports the current replication state of a server
+type ReplicationProvider interface {
+	// ReplicationInfo returns the current state
+	ReplicationInfo() *ReplicationInfo
+}
+
+// ReplicationProviderFunc is a function which implements ReplicationProvider
+type ReplicationProviderFunc func() *ReplicationInfo
+
+// ReplicationInfo implements ReplicationProvider
+func (f ReplicationProviderFunc) ReplicationInfo() *ReplicationInfo { return f() }
+
+// standalone is the default provider, reporting a master without replicas
+var standalone = ReplicationProviderFunc(func() *ReplicationInfo {
+	return &ReplicationInfo{Role: RoleMaster}
+})
+
+// replicationProvider wraps providers for atomic.Value,
+// which requires a consistent concrete type
+type replicationProvider struct{ ReplicationProvider }
+
+// SetReplicationProvider sets the source of the replication state
+// reported by ROLE and INFO. By default, servers report themselves as
+// masters without replicas.
+func (i *ServerInfo) SetReplicationProvider(p ReplicationProvider) {
+	if p == nil {
+		p = standalone
+	}
+	i.replication.Store(replicationProvider{p})
+}
+
+// Replication returns the current replication state
+func (i *ServerInfo) Replication() *ReplicationInfo {
+	if p, ok := i.replication.Load().(replicationProvider); ok {
+		if ri := p.ReplicationInfo(); ri != nil {
+			return ri
+		}
+	}
+	return standalone()
+}
+
+func (i *ServerInfo) writeReplication(emit func(string, string)) {
+	ri := i.Replication()
+
+	emit("role", ri.Role)
+	if ri.Role == RoleSlave {
+		status := "down"
+		if ri.MasterState == "connected" {
+			status = "up"
+		}
+
+		emit("master_host", ri.MasterHost)
+		emit("master_port", strconv.Itoa(ri.MasterPort))
+		emit("master_link_status", status)
+		emit("slave_repl_offset", strconv.FormatInt(ri.Offset, 10))
+	}
+
+	emit("connected_slaves", strconv.Itoa(len(ri.Replicas)))
+	for n, r := range ri.Replicas {
+		emit("slave"+strconv.Itoa(n), "ip="+r.Host+
+			",port="+strconv.Itoa(r.Port)+
+			",state="+r.State+
+			",offset="+strconv.FormatInt(r.Offset, 10)+
+			",lag="+strconv.FormatInt(r.Lag, 10))
+	}
+	if ri.Role == RoleMaster && ri.FailoverState != "" {
+		emit("master_failover_state", ri.FailoverState)
+	}
+	emit("master_repl_offset", strconv.FormatInt(ri.Offset, 10))
+	if b := ri.Backlog; b != nil {
+		emit("repl_backlog_active", "1")
+		emit("repl_backlog_size", strconv.FormatInt(b.Size, 10))
+		emit("repl_backlog_first_byte_offset", strconv.FormatInt(b.FirstByteOffset, 10))
+		emit("repl_backlog_histlen", strconv.FormatInt(b.HistLen, 10))
+	}
+}
+
+// Role returns a ROLE handler, which reports the replication state
+// provided to the server info, see ServerInfo.SetReplicationProvider.
+// https://redis.io/commands/role
+func Role(s *Server) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		ri := s.info.Replication()
+		switch ri.Role {
+		case RoleSlave:
+			w.AppendArrayLen(5)
+			w.AppendBulkString(ri.Role)
+			w.AppendBulkString(ri.MasterHost)
+			w.AppendInt(int64(ri.MasterPort))
+			w.AppendBulkString(ri.MasterState)
+			w.AppendInt(ri.Offset)
+		case RoleSentinel:
+			w.AppendArrayLen(2)
+			w.AppendBulkString(ri.Role)
+			w.AppendArrayLen(len(ri.Masters))
+			for _, name := range ri.Masters {
+				w.AppendBulkString(name)
+			}
+		default:
+			w.AppendArrayLen(3)
+			w.AppendBulkString(ri.Role)
+			w.AppendInt(ri.Offset)
+			w.AppendArrayLen(len(ri.Replicas))
+			for _, r := range ri.Replicas {
+				w.AppendArrayLen(3)
+				w.AppendBulkString(r.Host)
+				w.AppendBulkString(strconv.Itoa(r.Port))
+				w.AppendBulkString(strconv.FormatInt(r.Offset, 10))
+			}
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package redeo
+
+import (
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Role", func() {
+	var srv *Server
+	var subject Handler
+	var state *ReplicationInfo
+
+	BeforeEach(func() {
+		srv = NewServer(nil)
+		subject = Role(srv)
+		state = nil
+	})
+
+	var role = func() interface{} {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("ROLE"))
+		v, err := w.Response()
+		Expect(err).NotTo(HaveOccurred())
+		return v
+	}
+
+	var provide = func(ri *ReplicationInfo) {
+		state = ri
+		srv.Info().SetReplicationProvider(ReplicationProviderFunc(func() *ReplicationInfo { return state }))
+	}
+
+	It("should default to standalone masters", func() {
+		Expect(role()).To(Equal([]interface{}{"master", int64(0), []interface{}{}}))
+		Expect(srv.Info().Find("replication").String()).To(Equal("# Replication\nrole:master\nconnected_slaves:0\nmaster_repl_offset:0\n"))
+	})
+
+	It("should report masters", func() {
+		provide(&ReplicationInfo{Role: RoleMaster, Offset: 3129659, Replicas: []ReplicaInfo{
+			{Host: "127.0.0.1", Port: 9001, State: "online", Offset: 3129242},
+			{Host: "127.0.0.1", Port: 9002, State: "online", Offset: 3129543, Lag: 1},
+		}, Backlog: &BacklogInfo{Size: 1048576, FirstByteOffset: 2081084, HistLen: 1048576}})
+
+		Expect(role()).To(Equal([]interface{}{"master", int64(3129659), []interface{}{
+			[]interface{}{"127.0.0.1", "9001", "3129242"},
+			[]interface{}{"127.0.0.1", "9002", "3129543"},
+		}}))
+		Expect(srv.Info().Find("replication").String()).To(Equal("# Replication\n" +
+			"role:master\n" +
+			"connected_slaves:2\n" +
+			"slave0:ip=127.0.0.1,port=9001,state=online,offset=3129242,lag=0\n" +
+			"slave1:ip=127.0.0.1,port=9002,state=online,offset=3129543,lag=1\n" +
+			"master_repl_offset:3129659\n" +
+			"repl_backlog_active:1\n" +
+			"repl_backlog_size:1048576\n" +
+			"repl_backlog_first_byte_offset:2081084\n" +
+			"repl_backlog_histlen:1048576\n"))
+	})
+
+	It("should report replicas", func() {
+		provide(&ReplicationInfo{Role: RoleSlave, Offset: 3167038, MasterHost: "127.0.0.1", MasterPort: 9000, MasterState: "connected"})
+
+		Expect(role()).To(Equal([]interface{}{"slave", "127.0.0.1", int64(9000), "connected", int64(3167038)}))
+		Expect(srv.Info().Find("replication").String()).To(Equal("# Replication\n" +
+			"role:slave\n" +
+			"master_host:127.0.0.1\n" +
+			"master_port:9000\n" +
+			"master_link_status:up\n" +
+			"slave_repl_offset:3167038\n" +
+			"connected_slaves:0\n" +
+			"master_repl_offset:3167038\n"))
+
+		state.MasterState = "connecting"
+		Expect(srv.Info().Find("replication").String()).To(ContainSubstring("master_link_status:down\n"))
+	})
+
+	It("should report sentinels", func() {
+		provide(&ReplicationInfo{Role: RoleSentinel, Masters: []string{"resque-master", "html-fragments-master"}})
+		Expect(role()).To(Equal([]interface{}{"sentinel", []interface{}{"resque-master", "html-fragments-master"}}))
+	})
+
+	It("should reject arguments", func() {
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, resp.NewCommand("ROLE", resp.CommandArgument("x")))
+		Expect(w.Response()).To(MatchError("ERR wrong number of arguments for 'ROLE' command"))
+	})
+})
@@ -2,8 +2,13 @@ package resp
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
+	"net"
+	"sort"
 	"strconv"
 	"sync"
 )
@@ -13,6 +18,10 @@ type bufioR struct {
 	buf []byte
 
 	r, w int
+
+	// size is the original buffer size, the buffer may
+	// temporarily grow up to max
+	size, max int
 }
 
 // Buffered returns the number of buffered bytes
@@ -34,7 +43,16 @@ func (b *bufioR) PeekType() (t ResponseType, err error) {
 
 	switch b.buf[b.r] {
 	case '*':
-		t = TypeArray
+		if err = b.require(2); err != nil {
+			return
+		}
+		if b.buf[b.r+1] == '-' {
+			t = TypeNil
+		} else {
+			t = TypeArray
+		}
+	case '_':
+		t = TypeNil
 	case '$':
 		if err = b.require(2); err != nil {
 			return
@@ -59,7 +77,11 @@ func (b *bufioR) ReadNil() error {
 	if err != nil {
 		return err
 	}
-	if len(line) < 3 || !bytes.Equal(line[:3], binNIL[:3]) {
+	switch {
+	case len(line) >= 3 && bytes.Equal(line[:3], binNIL[:3]):
+	case len(line) >= 3 && bytes.Equal(line[:3], binNILArray[:3]):
+	case len(line) >= 1 && line[0] == '_':
+	default:
 		return errNotANilMessage
 	}
 	return nil
@@ -101,12 +123,30 @@ func (b *bufioR) ReadArrayLen() (int, error) {
 	return int(sz), nil
 }
 
+// readMultiBulkLen reads the array length of a request
+func (b *bufioR) readMultiBulkLen() (int, error) {
+	n, err := b.ReadArrayLen()
+	if err != nil {
+		return 0, err
+	} else if n > MaxMultiBulkLength {
+		return 0, errInvalidMultiBulkLength
+	}
+	return n, nil
+}
+
 func (b *bufioR) ReadBulkLen() (int64, error) {
 	line, err := b.ReadLine()
 	if err != nil {
 		return 0, err
 	}
-	return line.ParseSize('$', errInvalidBulkLength)
+
+	sz, err := line.ParseSize('$', errInvalidBulkLength)
+	if err != nil {
+		return 0, err
+	} else if sz > MaxBulkSize {
+		return 0, errInvalidBulkLength
+	}
+	return sz, nil
 }
 
 func (b *bufioR) ReadBulk(p []byte) ([]byte, error) {
@@ -115,6 +155,12 @@ func (b *bufioR) ReadBulk(p []byte) ([]byte, error) {
 		return p, err
 	}
 
+	// bulks larger than the buffer are read in chunks, to grow
+	// p only as data arrives rather than as declared
+	if int(sz+2) > len(b.buf) {
+		return b.readLargeBulk(p, sz)
+	}
+
 	if err := b.require(int(sz + 2)); err != nil {
 		return p, err
 	}
@@ -125,6 +171,31 @@ func (b *bufioR) ReadBulk(p []byte) ([]byte, error) {
 	return p, nil
 }
 
+func (b *bufioR) readLargeBulk(p []byte, sz int64) ([]byte, error) {
+	for sz > 0 {
+		if b.Buffered() == 0 {
+			if err := b.require(1); err != nil {
+				return p, err
+			}
+		}
+
+		n := b.Buffered()
+		if int64(n) > sz {
+			n = int(sz)
+		}
+		p = append(p, b.buf[b.r:b.r+n]...)
+		b.r += n
+		sz -= int64(n)
+	}
+
+	if err := b.require(2); err != nil {
+		return p, err
+	}
+	b.r += 2
+
+	return p, nil
+}
+
 func (b *bufioR) StreamBulk() (io.ReadCloser, error) {
 	sz, err := b.ReadBulkLen()
 	if err != nil {
@@ -140,6 +211,11 @@ func (b *bufioR) ReadBulkString() (string, error) {
 		return "", err
 	}
 
+	if int(sz+2) > len(b.buf) {
+		p, err := b.readLargeBulk(nil, sz)
+		return string(p), err
+	}
+
 	if err := b.require(int(sz + 2)); err != nil {
 		return "", err
 	}
@@ -198,30 +274,28 @@ func (b *bufioR) PeekN(offset, n int) ([]byte, error) {
 
 // PeekLine returns the next line until CRLF without reading it
 func (b *bufioR) PeekLine(offset int) (bufioLn, error) {
-	index := -1
+	for {
+		// try to find the end of the line
+		start := b.r + offset
+		if start < b.w {
+			if index := bytes.IndexByte(b.buf[start:b.w], '\r'); index > -1 && start+index+2 <= b.w {
+				return bufioLn(b.buf[start : start+index+2]), nil
+			}
+		}
 
-	// try to find the end of the line
-	start := b.r + offset
-	if start < b.w {
-		index = bytes.IndexByte(b.buf[start:b.w], '\r')
-	}
+		// grow the buffer if it is full, fail if it
+		// already reached its limit
+		b.compact()
+		if b.w == len(b.buf) && !b.grow() {
+			return nil, errInlineRequestTooLong
+		}
 
-	// try to read more data into the buffer if not in the buffer
-	if index < 0 {
+		// try to read more data into the buffer, lines
+		// may be split across multiple packets
 		if err := b.fill(); err != nil {
 			return nil, err
 		}
-		start = b.r + offset
-		if start < b.w {
-			index = bytes.IndexByte(b.buf[start:b.w], '\r')
-		}
-	}
-
-	// fail if still nothing found
-	if index < 0 {
-		return nil, errInlineRequestTooLong
 	}
-	return bufioLn(b.buf[start : start+index+2]), nil
 }
 
 // ReadLine returns the next line until CRLF
@@ -233,7 +307,12 @@ func (b *bufioR) ReadLine() (bufioLn, error) {
 
 // Reset resets the reader with an new interface
 func (b *bufioR) Reset(r io.Reader) {
-	b.reset(b.buf, r)
+	buf, max := b.buf, b.max
+	if b.size > 0 && len(buf) > b.size {
+		buf = make([]byte, b.size)
+	}
+	b.reset(buf, r)
+	b.max = max
 }
 
 // require ensures that sz bytes are buffered
@@ -286,8 +365,43 @@ func (b *bufioR) compact() {
 	}
 }
 
+// prefill copies pre-read data into the buffer, the buffer grows
+// temporarily if p doesn't fit
+func (b *bufioR) prefill(p []byte) {
+	if len(p) > len(b.buf) {
+		b.buf = make([]byte, len(p))
+	}
+	b.r, b.w = 0, copy(b.buf, p)
+}
+
+// grow doubles the buffer, up to max
+func (b *bufioR) grow() bool {
+	if len(b.buf) >= b.max {
+		return false
+	}
+
+	n := 2 * len(b.buf)
+	if n > b.max {
+		n = b.max
+	}
+
+	buf := make([]byte, n)
+	b.w = copy(buf, b.buf[b.r:b.w])
+	b.r = 0
+	b.buf = buf
+	return true
+}
+
+// shrink restores the original buffer size once it has been drained
+func (b *bufioR) shrink() {
+	if len(b.buf) > b.size && b.Buffered() == 0 {
+		b.buf = make([]byte, b.size)
+		b.r, b.w = 0, 0
+	}
+}
+
 func (b *bufioR) reset(buf []byte, rd io.Reader) {
-	*b = bufioR{buf: buf, rd: rd}
+	*b = bufioR{buf: buf, rd: rd, size: len(buf), max: len(buf)}
 }
 
 // --------------------------------------------------------------------
@@ -402,6 +516,10 @@ func (ln bufioLn) ParseMessage(prefix byte) (string, error) {
 	return string(data[1:]), nil
 }
 
+// maxSizeValue is the max parseable size,
+// anything larger is rejected to avoid overflows
+const maxSizeValue = 1 << 53
+
 // ParseSize parses a size with prefix
 func (ln bufioLn) ParseSize(prefix byte, fallback error) (int64, error) {
 	data := ln.Trim()
@@ -417,6 +535,9 @@ func (ln bufioLn) ParseSize(prefix byte, fallback error) (int64, error) {
 	var n int64
 	for _, c := range data[1:] {
 		if c >= '0' && c <= '9' {
+			if n > maxSizeValue/10 {
+				return 0, fallback
+			}
 			n = n*10 + int64(c-'0')
 		} else {
 			return 0, fallback
@@ -430,10 +551,24 @@ func (ln bufioLn) ParseSize(prefix byte, fallback error) (int64, error) {
 
 // --------------------------------------------------------------------
 
+// minVectorSize is the min size of bulks written with writev,
+// bypassing the buffer
+const minVectorSize = 4 * 1024
+
 type bufioW struct {
 	io.Writer
-	buf []byte
-	mu  sync.Mutex
+	buf   []byte
+	err   error
+	proto int
+	mu    sync.Mutex
+
+	// reply accounting, see BeginReply
+	limit, flushed, overSize int64
+	mark                     int
+	over                     bool
+
+	// scratch is used to format values before their size is known
+	scratch [64]byte
 }
 
 // Buffered returns the number of buffered bytes
@@ -448,15 +583,25 @@ func (b *bufioW) Buffered() int {
 func (b *bufioW) AppendArrayLen(n int) {
 	b.mu.Lock()
 	b.appendSize('*', int64(n))
+	b.account()
 	b.mu.Unlock()
 }
 
-// AppendBulk appends bulk bytes to the output buffer
+// AppendBulk appends bulk bytes to the output buffer. Large bulks that
+// would overflow the buffer are written immediately, together with the
+// pending buffer, using a single vectored write.
 func (b *bufioW) AppendBulk(p []byte) {
 	b.mu.Lock()
 	b.appendSize('$', int64(len(p)))
-	b.buf = append(b.buf, p...)
-	b.buf = append(b.buf, binCRLF...)
+	if n := len(p); n >= minVectorSize && len(b.buf)+n+2 > cap(b.buf) {
+		if b.fits(int64(n) + 2) {
+			b.writeVector(p)
+		}
+	} else {
+		b.buf = append(b.buf, p...)
+		b.buf = append(b.buf, binCRLF...)
+	}
+	b.account()
 	b.mu.Unlock()
 }
 
@@ -466,6 +611,7 @@ func (b *bufioW) AppendBulkString(s string) {
 	b.appendSize('$', int64(len(s)))
 	b.buf = append(b.buf, s...)
 	b.buf = append(b.buf, binCRLF...)
+	b.account()
 	b.mu.Unlock()
 }
 
@@ -475,6 +621,7 @@ func (b *bufioW) AppendInline(p []byte) {
 	b.buf = append(b.buf, '+')
 	b.buf = append(b.buf, p...)
 	b.buf = append(b.buf, binCRLF...)
+	b.account()
 	b.mu.Unlock()
 }
 
@@ -484,6 +631,7 @@ func (b *bufioW) AppendInlineString(s string) {
 	b.buf = append(b.buf, '+')
 	b.buf = append(b.buf, s...)
 	b.buf = append(b.buf, binCRLF...)
+	b.account()
 	b.mu.Unlock()
 }
 
@@ -493,6 +641,7 @@ func (b *bufioW) AppendError(msg string) {
 	b.buf = append(b.buf, '-')
 	b.buf = append(b.buf, msg...)
 	b.buf = append(b.buf, binCRLF...)
+	b.account()
 	b.mu.Unlock()
 }
 
@@ -511,16 +660,50 @@ func (b *bufioW) AppendInt(n int64) {
 		b.buf = append(b.buf, binONE...)
 	default:
 		b.buf = append(b.buf, ':')
-		b.buf = append(b.buf, strconv.FormatInt(n, 10)...)
+		b.buf = strconv.AppendInt(b.buf, n, 10)
 		b.buf = append(b.buf, binCRLF...)
 	}
+	b.account()
 	b.mu.Unlock()
 }
 
 // AppendNil appends a nil-value to the output buffer
 func (b *bufioW) AppendNil() {
 	b.mu.Lock()
-	b.buf = append(b.buf, binNIL...)
+	if b.proto == RESP3 {
+		b.buf = append(b.buf, binNULL...)
+	} else {
+		b.buf = append(b.buf, binNIL...)
+	}
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendNilArray appends a nil array to the output buffer
+func (b *bufioW) AppendNilArray() {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.buf = append(b.buf, binNULL...)
+	} else {
+		b.buf = append(b.buf, binNILArray...)
+	}
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendEmptyArray appends an empty array to the output buffer
+func (b *bufioW) AppendEmptyArray() {
+	b.mu.Lock()
+	b.buf = append(b.buf, binEmptyArray...)
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendEmptyBulk appends an empty bulk to the output buffer
+func (b *bufioW) AppendEmptyBulk() {
+	b.mu.Lock()
+	b.buf = append(b.buf, binEmptyBulk...)
+	b.account()
 	b.mu.Unlock()
 }
 
@@ -528,9 +711,134 @@ func (b *bufioW) AppendNil() {
 func (b *bufioW) AppendOK() {
 	b.mu.Lock()
 	b.buf = append(b.buf, binOK...)
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendFloat appends a double to the output buffer
+func (b *bufioW) AppendFloat(f float64) {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.buf = append(b.buf, ',')
+		b.buf = appendFloat(b.buf, f)
+		b.buf = append(b.buf, binCRLF...)
+	} else {
+		b.appendBulkBytes(appendFloat(b.scratch[:0], f))
+	}
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendBool appends a boolean to the output buffer
+func (b *bufioW) AppendBool(v bool) {
+	b.mu.Lock()
+	switch {
+	case b.proto == RESP3 && v:
+		b.buf = append(b.buf, binTRUE...)
+	case b.proto == RESP3:
+		b.buf = append(b.buf, binFALSE...)
+	case v:
+		b.buf = append(b.buf, binONE...)
+	default:
+		b.buf = append(b.buf, binZERO...)
+	}
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendBigInt appends a big number to the output buffer
+func (b *bufioW) AppendBigInt(n *big.Int) {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.buf = append(b.buf, '(')
+		b.buf = n.Append(b.buf, 10)
+		b.buf = append(b.buf, binCRLF...)
+	} else {
+		b.appendBulkBytes(n.Append(b.scratch[:0], 10))
+	}
+	b.account()
 	b.mu.Unlock()
 }
 
+// AppendVerbatimString appends a verbatim string to the output buffer
+func (b *bufioW) AppendVerbatimString(format, s string) {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.appendSize('=', int64(len(format)+1+len(s)))
+		b.buf = append(b.buf, format...)
+		b.buf = append(b.buf, ':')
+	} else {
+		b.appendSize('$', int64(len(s)))
+	}
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, binCRLF...)
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendBulkStream starts a bulk of unknown length
+func (b *bufioW) AppendBulkStream() io.WriteCloser {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.proto != RESP3 {
+		return &bulkStream{b: b}
+	}
+	b.buf = append(b.buf, binStreamedBulk...)
+	b.account()
+	return &bulkStream{b: b, chunked: true}
+}
+
+// AppendMapLen appends a map header to the output buffer
+func (b *bufioW) AppendMapLen(n int) {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.appendSize('%', int64(n))
+	} else {
+		b.appendSize('*', int64(n)*2)
+	}
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendPushLen appends a push header to the output buffer
+func (b *bufioW) AppendPushLen(n int) {
+	b.mu.Lock()
+	if b.proto == RESP3 {
+		b.appendSize('>', int64(n))
+	} else {
+		b.appendSize('*', int64(n))
+	}
+	b.account()
+	b.mu.Unlock()
+}
+
+// AppendAttributes appends an attribute frame to the output buffer
+func (b *bufioW) AppendAttributes(attrs map[string]interface{}) error {
+	if b.Protocol() != RESP3 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b.mu.Lock()
+	b.appendSize('|', int64(len(keys)))
+	b.account()
+	b.mu.Unlock()
+
+	for _, key := range keys {
+		b.AppendBulkString(key)
+		if err := b.Append(attrs[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CopyBulk flushes the existing buffer and read n bytes from the reader directly to
 // the client connection.
 func (b *bufioW) CopyBulk(src io.Reader, n int64) error {
@@ -538,6 +846,10 @@ func (b *bufioW) CopyBulk(src io.Reader, n int64) error {
 	defer b.mu.Unlock()
 
 	b.appendSize('$', n)
+	if !b.fits(n + 2) {
+		b.account()
+		return ErrReplyTooLarge
+	}
 	if start := len(b.buf); int64(cap(b.buf)-start) >= n+2 {
 		b.buf = b.buf[:start+int(n)]
 		if _, err := io.ReadFull(src, b.buf[start:]); err != nil {
@@ -552,8 +864,9 @@ func (b *bufioW) CopyBulk(src io.Reader, n int64) error {
 		return err
 	}
 	b.buf = b.buf[:cap(b.buf)]
-	_, err := io.CopyBuffer(b, io.LimitReader(src, int64(n)), b.buf)
+	m, err := io.CopyBuffer(b, io.LimitReader(src, int64(n)), b.buf)
 	b.buf = b.buf[:0]
+	b.flushed += m
 	if err != nil {
 		return err
 	}
@@ -575,7 +888,25 @@ func (b *bufioW) Reset(w io.Writer) {
 	b.reset(b.buf, w)
 }
 
+// Protocol returns the protocol version
+func (b *bufioW) Protocol() int {
+	b.mu.Lock()
+	v := b.proto
+	b.mu.Unlock()
+	return v
+}
+
+// SetProtocol sets the protocol version
+func (b *bufioW) SetProtocol(v int) {
+	b.mu.Lock()
+	b.proto = v
+	b.mu.Unlock()
+}
+
 func (b *bufioW) flush() error {
+	if b.err != nil {
+		return b.err
+	}
 	if len(b.buf) == 0 {
 		return nil
 	}
@@ -584,16 +915,174 @@ func (b *bufioW) flush() error {
 		return err
 	}
 
-	b.buf = b.buf[:0]
+	b.flushed += int64(len(b.buf) - b.mark)
+	b.buf, b.mark = b.buf[:0], 0
 	return nil
 }
 
+// writeVector writes the buffer, followed by p and a CRLF. Errors are
+// retained and returned by the next flush.
+func (b *bufioW) writeVector(p []byte) {
+	if b.err != nil {
+		return
+	}
+
+	vec := net.Buffers{b.buf, p, binCRLF}
+	if _, err := vec.WriteTo(b.Writer); err != nil {
+		b.err = err
+	}
+	b.flushed += int64(len(b.buf)-b.mark) + int64(len(p)) + 2
+	b.buf, b.mark = b.buf[:0], 0
+}
+
+// BeginReply starts accounting for a new reply
+func (b *bufioW) BeginReply(limit int64) {
+	b.mu.Lock()
+	b.limit, b.mark, b.flushed, b.over = limit, len(b.buf), 0, false
+	b.mu.Unlock()
+}
+
+// EndReply returns the size of the current reply
+func (b *bufioW) EndReply() (size int64, partial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	size, partial = b.replySize(), b.flushed != 0
+	if b.over {
+		size, err = b.overSize, ErrReplyTooLarge
+	}
+	b.limit, b.over = 0, false
+	return
+}
+
+// replySize returns the number of bytes appended since BeginReply
+func (b *bufioW) replySize() int64 {
+	return b.flushed + int64(len(b.buf)-b.mark)
+}
+
+// fits reports whether n more bytes fit into the current reply, replies
+// which would exceed the limit are marked as too large
+func (b *bufioW) fits(n int64) bool {
+	if b.limit <= 0 {
+		return true
+	}
+	if !b.over && b.replySize()+n > b.limit {
+		b.over, b.overSize = true, b.replySize()+n
+	}
+	return !b.over
+}
+
+func (b *bufioW) fitsLocked(n int64) bool {
+	b.mu.Lock()
+	ok := b.fits(n)
+	b.account()
+	b.mu.Unlock()
+	return ok
+}
+
+// account discards the buffered part of the current reply once it
+// exceeds the limit
+func (b *bufioW) account() {
+	if b.fits(0) {
+		return
+	}
+	b.buf = b.buf[:b.mark]
+}
+
+func (b *bufioW) appendBulkBytes(p []byte) {
+	b.appendSize('$', int64(len(p)))
+	b.buf = append(b.buf, p...)
+	b.buf = append(b.buf, binCRLF...)
+}
+
 func (b *bufioW) appendSize(c byte, n int64) {
 	b.buf = append(b.buf, c)
-	b.buf = append(b.buf, strconv.FormatInt(n, 10)...)
+	b.buf = strconv.AppendInt(b.buf, n, 10)
 	b.buf = append(b.buf, binCRLF...)
 }
 
 func (b *bufioW) reset(buf []byte, wr io.Writer) {
-	*b = bufioW{buf: buf[:0], Writer: wr}
+	*b = bufioW{buf: buf[:0], Writer: wr, proto: RESP2}
+}
+
+var errBulkStreamClosed = errors.New("resp: bulk stream already closed")
+
+// bulkStream appends chunks of a streamed bulk, or buffers the data for
+// RESP2
+type bulkStream struct {
+	b       *bufioW
+	buf     []byte
+	chunked bool
+	closed  bool
+}
+
+// Write appends a chunk
+func (s *bulkStream) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errBulkStreamClosed
+	}
+	if !s.chunked {
+		if !s.b.fitsLocked(int64(len(s.buf) + len(p))) {
+			s.buf = nil
+			return 0, ErrReplyTooLarge
+		}
+		s.buf = append(s.buf, p...)
+		return len(p), nil
+	}
+	if len(p) == 0 {
+		return 0, nil // an empty chunk terminates the stream
+	}
+
+	b := s.b
+	b.mu.Lock()
+	b.appendSize(';', int64(len(p)))
+	if n := len(p); n >= minVectorSize && len(b.buf)+n+2 > cap(b.buf) {
+		if b.fits(int64(n) + 2) {
+			b.writeVector(p)
+		}
+	} else {
+		b.buf = append(b.buf, p...)
+		b.buf = append(b.buf, binCRLF...)
+	}
+	b.account()
+	over := b.over
+	b.mu.Unlock()
+
+	if over {
+		return 0, ErrReplyTooLarge
+	}
+	return len(p), nil
+}
+
+// Close terminates the bulk
+func (s *bulkStream) Close() error {
+	if s.closed {
+		return errBulkStreamClosed
+	}
+	s.closed = true
+
+	if !s.chunked {
+		s.b.AppendBulk(s.buf)
+		s.buf = nil
+		return nil
+	}
+
+	s.b.mu.Lock()
+	s.b.buf = append(s.b.buf, binStreamEnd...)
+	s.b.account()
+	s.b.mu.Unlock()
+	return nil
+}
+
+// appendFloat formats f like Redis, using %.17g
+func appendFloat(dst []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(dst, "inf"...)
+	case math.IsInf(f, -1):
+		return append(dst, "-inf"...)
+	case math.IsNaN(f):
+		return append(dst, "nan"...)
+	}
+	return strconv.AppendFloat(dst, f, 'g', 17, 64)
 }
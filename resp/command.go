@@ -29,9 +29,31 @@ func (c CommandArgument) Int() (int64, error) {
 
 // --------------------------------------------------------------------
 
+// CanonicalName returns the name with ASCII letters folded to
+// lower-case, as used to route commands. Unlike strings.ToLower, it
+// leaves all other characters untouched, so non-ASCII names such as
+// "\u212Aeys" (Kelvin sign) cannot be mapped onto registered commands.
+func CanonicalName(name string) string {
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c >= 'A' && c <= 'Z' {
+			b := []byte(name)
+			for j := i; j < len(b); j++ {
+				if c := b[j]; c >= 'A' && c <= 'Z' {
+					b[j] = c + ('a' - 'A')
+				}
+			}
+			return string(b)
+		}
+	}
+	return name
+}
+
+// --------------------------------------------------------------------
+
 // Command instances are parsed by a RequestReader
 type Command struct {
-	// Name refers to the command name
+	// Name refers to the command name, exactly as sent by the client,
+	// see CanonicalName.
 	Name string
 
 	// Args returns arguments
@@ -46,6 +68,9 @@ func NewCommand(name string, args ...CommandArgument) *Command {
 	return &Command{Name: name, Args: args}
 }
 
+// CanonicalName returns the canonical command name, see CanonicalName
+func (c *Command) CanonicalName() string { return CanonicalName(c.Name) }
+
 // Arg returns the Nth argument
 func (c *Command) Arg(n int) CommandArgument {
 	if n > -1 && n < len(c.Args) {
@@ -94,10 +119,11 @@ func (c *Command) grow(n int) {
 
 func (c *Command) readMultiBulk(r *bufioR, name string, nargs int) error {
 	c.Name = name
-	c.grow(nargs)
 
+	// grow args as they are read, nargs is untrusted
 	var err error
 	for i := 0; i < nargs; i++ {
+		c.grow(i + 1)
 		c.Args[i], err = r.ReadBulk(c.Args[i])
 		if err != nil {
 			return err
@@ -133,6 +159,64 @@ func (c *Command) readInline(r *bufioR) (bool, error) {
 	return true, nil
 }
 
+// maxSmallArgs is the max number of arguments (including the command name)
+// parsed by the fast path
+const maxSmallArgs = 5
+
+// readSmall parses small, complete multi-bulk requests directly from the
+// buffer, without line scanning. It returns false without consuming any
+// data if the buffered request is not strictly well-formed, incomplete or
+// has too many arguments, leaving it to the general parser.
+func (c *Command) readSmall(r *bufioR) bool {
+	data := r.buf[r.r:r.w]
+	if len(data) < 4 || data[0] != '*' || data[2] != '\r' || data[3] != '\n' {
+		return false
+	}
+
+	nargs := int(data[1] - '0')
+	if nargs < 1 || nargs > maxSmallArgs {
+		return false
+	}
+
+	// find the bulk offsets first, so nothing is copied
+	// unless the whole request is buffered
+	var offsets [maxSmallArgs * 2]int
+	pos := 4
+	for i := 0; i < nargs; i++ {
+		if pos >= len(data) || data[pos] != '$' {
+			return false
+		}
+		pos++
+
+		n, start := 0, pos
+		for ; pos < len(data); pos++ {
+			x := data[pos]
+			if x < '0' || x > '9' {
+				break
+			}
+			n = n*10 + int(x-'0')
+		}
+		if pos == start || pos-start > 9 || pos+1 >= len(data) || data[pos] != '\r' || data[pos+1] != '\n' {
+			return false
+		}
+		pos += 2
+
+		if end := pos + n; end+1 >= len(data) || data[end] != '\r' || data[end+1] != '\n' {
+			return false
+		}
+		offsets[i*2], offsets[i*2+1] = pos, pos+n
+		pos += n + 2
+	}
+
+	c.Name = string(data[offsets[0]:offsets[1]])
+	c.grow(nargs - 1)
+	for i := 1; i < nargs; i++ {
+		c.Args[i-1] = append(c.Args[i-1], data[offsets[i*2]:offsets[i*2+1]]...)
+	}
+	r.r += pos
+	return true
+}
+
 // --------------------------------------------------------------------
 
 func readCommand(c interface {
@@ -145,7 +229,7 @@ func readCommand(c interface {
 	}
 
 	if x == '*' {
-		sz, err := r.ReadArrayLen()
+		sz, err := r.readMultiBulkLen()
 		if err != nil {
 			return err
 		} else if sz < 1 {
@@ -175,7 +259,8 @@ var errNoMoreArgs = errors.New("resp: no more arguments")
 
 // CommandStream instances are created by a RequestReader
 type CommandStream struct {
-	// Name refers to the command name
+	// Name refers to the command name, exactly as sent by the client,
+	// see CanonicalName.
 	Name string
 
 	ctx context.Context
@@ -190,6 +275,9 @@ type CommandStream struct {
 	rd *bufioR
 }
 
+// CanonicalName returns the canonical command name, see CanonicalName
+func (c *CommandStream) CanonicalName() string { return CanonicalName(c.Name) }
+
 // Reset discards all data and resets all state
 func (c *CommandStream) Reset() {
 	c.inline.Reset()
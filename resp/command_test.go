@@ -0,0 +1,120 @@
+package resp
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Command", func() {
+
+	setup := func(s string) *bufioR {
+		r := new(bufioR)
+		r.reset(mkStdBuffer(), bytes.NewBufferString(s))
+		_ = r.fill()
+		return r
+	}
+
+	DescribeTable("should parse small requests",
+		func(s string, name string, args []string, rest int) {
+			r := setup(s)
+			cmd := new(Command)
+			Expect(cmd.readSmall(r)).To(BeTrue())
+			Expect(cmd.Name).To(Equal(name))
+			Expect(cmd.Args).To(HaveLen(len(args)))
+			for i, arg := range args {
+				Expect(cmd.Args[i].String()).To(Equal(arg))
+			}
+			Expect(r.Buffered()).To(Equal(rest))
+		},
+
+		Entry("no args", "*1\r\n$4\r\nPING\r\n", "PING", nil, 0),
+		Entry("blank name", "*1\r\n$0\r\n\r\n", "", nil, 0),
+		Entry("with args", "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", "SET", []string{"key", "value"}, 0),
+		Entry("binary args", "*2\r\n$4\r\nECHO\r\n$4\r\n\r\n\r\n\r\n", "ECHO", []string{"\r\n\r\n"}, 0),
+		Entry("pipelined", "*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nPING\r\n", "PING", nil, 14),
+	)
+
+	DescribeTable("should fall back on other requests",
+		func(s string) {
+			r := setup(s)
+			Expect(new(Command).readSmall(r)).To(BeFalse())
+			Expect(r.Buffered()).To(Equal(len(s)))
+		},
+
+		Entry("inline", "PING\r\n"),
+		Entry("blank multi-bulk", "*0\r\n"),
+		Entry("too many args", "*6\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n$1\r\ne\r\n$1\r\nf\r\n"),
+		Entry("incomplete", "*2\r\n$4\r\nECHO\r\n$4\r\nsom"),
+		Entry("missing CRLF", "*1\r\n$4\r\nPING"),
+		Entry("inconsistent length", "*1\r\n$4\r\nPING123\r\n"),
+		Entry("bad length", "*1\r\n$x\r\nPING\r\n"),
+		Entry("negative length", "*1\r\n$-1\r\n"),
+		Entry("padded length", "*1\r\n$4 \r\nPING\r\n"),
+		Entry("huge length", "*1\r\n$99999999999999999999\r\nPING\r\n"),
+	)
+
+	It("should be equivalent to the general parser", func() {
+		rnd := rand.New(rand.NewSource(33))
+		for i := 0; i < 20000; i++ {
+			s := fuzzRequest(rnd)
+
+			fast, general := new(Command), new(Command)
+			fr, gr := setup(s), setup(s)
+			if !fast.readSmall(fr) {
+				Expect(fr.Buffered()).To(Equal(len(s)), "input: %q", s)
+				continue
+			}
+
+			Expect(readCommand(general, gr)).To(Succeed(), "input: %q", s)
+			Expect(fast.Name).To(Equal(general.Name), "input: %q", s)
+			Expect(fast.Args).To(Equal(general.Args), "input: %q", s)
+			Expect(fr.Buffered()).To(Equal(gr.Buffered()), "input: %q", s)
+		}
+	})
+
+	DescribeTable("should canonicalize names",
+		func(name, canonical string) {
+			Expect(CanonicalName(name)).To(Equal(canonical))
+			Expect(NewCommand(name).CanonicalName()).To(Equal(canonical))
+		},
+
+		Entry("lower-case", "ping", "ping"),
+		Entry("upper-case", "PING", "ping"),
+		Entry("mixed-case", "cLiEnT-Kill", "client-kill"),
+		Entry("Kelvin sign", "\u212AEYS", "\u212Aeys"),
+		Entry("dotted capital I", "\u0130NFO", "\u0130nfo"),
+		Entry("invalid UTF-8", "\xffGET", "\xffget"),
+	)
+
+})
+
+// fuzzRequest generates a random multi-bulk request, optionally corrupted
+func fuzzRequest(rnd *rand.Rand) string {
+	nargs := rnd.Intn(7)
+	buf := []byte("*" + strconv.Itoa(nargs) + "\r\n")
+	for i := 0; i < nargs; i++ {
+		arg := make([]byte, rnd.Intn(12))
+		for j := range arg {
+			arg[j] = "ab\r\n$*0123"[rnd.Intn(10)]
+		}
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+
+	switch rnd.Intn(4) {
+	case 0: // flip a byte
+		buf[rnd.Intn(len(buf))] = "ab\r\n$*-0123"[rnd.Intn(11)]
+	case 1: // truncate
+		buf = buf[:rnd.Intn(len(buf))]
+	case 2: // insert a byte
+		pos := rnd.Intn(len(buf))
+		buf = append(buf[:pos], append([]byte{"a\r\n$*-1"[rnd.Intn(7)]}, buf[pos:]...)...)
+	}
+	return string(buf)
+}
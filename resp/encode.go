@@ -0,0 +1,95 @@
+package resp
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// ProtoMessage is implemented by protobuf messages, e.g. those generated
+// by gogo/protobuf. Messages which also implement
+//
+//   Size() int
+//   MarshalTo(dst []byte) (int, error)
+//
+// are marshaled into pooled buffers.
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+}
+
+type protoSizer interface {
+	Size() int
+	MarshalTo(dst []byte) (int, error)
+}
+
+// jsonEncoder is a pooled JSON encoder with its buffer
+type jsonEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonEncoders = sync.Pool{New: func() interface{} {
+	e := new(jsonEncoder)
+	e.enc = json.NewEncoder(&e.buf)
+	e.enc.SetEscapeHTML(false)
+	return e
+}}
+
+// protoBuffers holds *[]byte, buffers larger than MaxBufferSize are
+// not pooled
+var protoBuffers sync.Pool
+
+// AppendJSON marshals v to JSON and appends it as a bulk string, HTML
+// characters are not escaped. Nothing is appended if v cannot be
+// marshaled.
+func AppendJSON(w ResponseWriter, v interface{}) error {
+	e := jsonEncoders.Get().(*jsonEncoder)
+	defer func() {
+		if e.buf.Cap() <= MaxBufferSize {
+			jsonEncoders.Put(e)
+		}
+	}()
+
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return err
+	}
+	w.AppendBulk(bytes.TrimSuffix(e.buf.Bytes(), binCRLF[1:]))
+	return nil
+}
+
+// AppendProto marshals a protobuf message and appends it as a bulk
+// string. Nothing is appended if m cannot be marshaled.
+func AppendProto(w ResponseWriter, m ProtoMessage) error {
+	sm, ok := m.(protoSizer)
+	if !ok {
+		p, err := m.Marshal()
+		if err != nil {
+			return err
+		}
+		w.AppendBulk(p)
+		return nil
+	}
+
+	var buf []byte
+	if v := protoBuffers.Get(); v != nil {
+		buf = *(v.(*[]byte))
+	}
+	if sz := sm.Size(); cap(buf) < sz {
+		buf = make([]byte, sz)
+	} else {
+		buf = buf[:sz]
+	}
+	defer func() {
+		if cap(buf) <= MaxBufferSize {
+			protoBuffers.Put(&buf)
+		}
+	}()
+
+	n, err := sm.MarshalTo(buf)
+	if err != nil {
+		return err
+	}
+	w.AppendBulk(buf[:n])
+	return nil
+}
@@ -0,0 +1,170 @@
+package resp
+
+import (
+	"io"
+	"math/big"
+)
+
+// Extended returns the ExtendedResponseWriter of w. Writers which wrap
+// another writer may implement Unwrap() ResponseWriter, so the wrapped
+// writer is returned instead.
+func Extended(w ResponseWriter) (ExtendedResponseWriter, bool) {
+	for {
+		if x, ok := w.(ExtendedResponseWriter); ok {
+			return x, true
+		}
+		u, ok := w.(interface{ Unwrap() ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// Protocol returns the protocol version of w, RESP2 unless w is extended.
+func Protocol(w ResponseWriter) int {
+	if x, ok := Extended(w); ok {
+		return x.Protocol()
+	}
+	return RESP2
+}
+
+// SetProtocol sets the protocol version of w and reports whether it is
+// supported, writers which are not extended only support RESP2.
+func SetProtocol(w ResponseWriter, v int) bool {
+	if x, ok := Extended(w); ok {
+		x.SetProtocol(v)
+		return true
+	}
+	return v == RESP2
+}
+
+// AppendNilArray appends a nil array to w.
+func AppendNilArray(w ResponseWriter) {
+	if x, ok := Extended(w); ok {
+		x.AppendNilArray()
+		return
+	}
+	w.AppendArrayLen(-1)
+}
+
+// AppendEmptyArray appends an array with no elements to w.
+func AppendEmptyArray(w ResponseWriter) {
+	if x, ok := Extended(w); ok {
+		x.AppendEmptyArray()
+		return
+	}
+	w.AppendArrayLen(0)
+}
+
+// AppendEmptyBulk appends a bulk of zero length to w.
+func AppendEmptyBulk(w ResponseWriter) {
+	if x, ok := Extended(w); ok {
+		x.AppendEmptyBulk()
+		return
+	}
+	w.AppendBulkString("")
+}
+
+// AppendFloat appends a double to w.
+func AppendFloat(w ResponseWriter, f float64) {
+	if x, ok := Extended(w); ok {
+		x.AppendFloat(f)
+		return
+	}
+	w.AppendBulk(appendFloat(nil, f))
+}
+
+// AppendBool appends a boolean to w.
+func AppendBool(w ResponseWriter, b bool) {
+	if x, ok := Extended(w); ok {
+		x.AppendBool(b)
+		return
+	}
+	if b {
+		w.AppendInt(1)
+	} else {
+		w.AppendInt(0)
+	}
+}
+
+// AppendBigInt appends a big number to w.
+func AppendBigInt(w ResponseWriter, n *big.Int) {
+	if x, ok := Extended(w); ok {
+		x.AppendBigInt(n)
+		return
+	}
+	w.AppendBulkString(n.String())
+}
+
+// AppendVerbatimString appends a verbatim string with a three letter
+// format to w.
+func AppendVerbatimString(w ResponseWriter, format, s string) {
+	if x, ok := Extended(w); ok {
+		x.AppendVerbatimString(format, s)
+		return
+	}
+	w.AppendBulkString(s)
+}
+
+// AppendBulkStream starts a bulk of unknown length on w, the returned
+// writer appends data until it is closed.
+func AppendBulkStream(w ResponseWriter) io.WriteCloser {
+	if x, ok := Extended(w); ok {
+		return x.AppendBulkStream()
+	}
+	return &bufferedBulk{w: w}
+}
+
+// AppendMapLen appends a map header for n key/value pairs to w.
+func AppendMapLen(w ResponseWriter, n int) {
+	if x, ok := Extended(w); ok {
+		x.AppendMapLen(n)
+		return
+	}
+	w.AppendArrayLen(n * 2)
+}
+
+// AppendPushLen appends a push header for n elements to w.
+func AppendPushLen(w ResponseWriter, n int) {
+	if x, ok := Extended(w); ok {
+		x.AppendPushLen(n)
+		return
+	}
+	w.AppendArrayLen(n)
+}
+
+// AppendAttributes appends an attribute frame to w, it is discarded
+// unless w is extended.
+func AppendAttributes(w ResponseWriter, attrs map[string]interface{}) error {
+	if x, ok := Extended(w); ok {
+		return x.AppendAttributes(attrs)
+	}
+	return nil
+}
+
+// bufferedBulk buffers the data of a bulk stream for writers which are
+// not extended
+type bufferedBulk struct {
+	w      ResponseWriter
+	buf    []byte
+	closed bool
+}
+
+func (s *bufferedBulk) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errBulkStreamClosed
+	}
+	s.buf = append(s.buf, p...)
+	return len(p), nil
+}
+
+func (s *bufferedBulk) Close() error {
+	if s.closed {
+		return errBulkStreamClosed
+	}
+	s.closed = true
+	s.w.AppendBulk(s.buf)
+	s.buf = nil
+	return nil
+}
@@ -12,11 +12,84 @@ type RequestReader struct {
 
 // NewRequestReader wraps any reader interface
 func NewRequestReader(rd io.Reader) *RequestReader {
+	return NewRequestReaderSize(rd, MaxBufferSize)
+}
+
+// NewRequestReaderSize wraps any reader interface using a buffer of the
+// given size. Sizes are capped between MinBufferSize and MaxBufferSize.
+// Inline requests and command names must fit into the buffer, larger
+// arguments are read in chunks.
+func NewRequestReaderSize(rd io.Reader, size int) *RequestReader {
 	r := new(bufioR)
-	r.reset(mkStdBuffer(), rd)
+	r.reset(mkBuffer(size), rd)
 	return &RequestReader{r: r}
 }
 
+// NewRequestReaderBuffered wraps rd, buffered contains data which has
+// already been read from rd (e.g. by a protocol sniffer) and is consumed
+// first.
+func NewRequestReaderBuffered(rd io.Reader, buffered []byte) *RequestReader {
+	r := NewRequestReader(rd)
+	r.r.prefill(buffered)
+	return r
+}
+
+// ParseRequestBytes parses a single request from p and returns the command
+// along with the number of bytes consumed. Incomplete requests return
+// io.ErrUnexpectedEOF.
+func ParseRequestBytes(p []byte) (*Command, int, error) {
+	if len(p) == 0 {
+		return nil, 0, io.EOF
+	}
+
+	// leave spare room, so incomplete lines hit EOF
+	// rather than the buffer limit
+	r := new(bufioR)
+	r.reset(make([]byte, len(p)+2), eofReader{})
+	r.prefill(p)
+
+	cmd := new(Command)
+	if !cmd.readSmall(r) {
+		if err := readCommand(cmd, r); err == io.EOF {
+			return nil, 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, 0, err
+		}
+	}
+	return cmd, len(p) - r.Buffered(), nil
+}
+
+// ParseRequests parses all complete requests buffered in p at once, e.g.
+// to execute a pipeline as a batch. It returns the commands along with
+// the number of bytes consumed, a trailing incomplete request is left
+// unconsumed. On malformed requests, the commands parsed so far are
+// returned with the error.
+func ParseRequests(p []byte) ([]*Command, int, error) {
+	r := new(bufioR)
+	r.reset(make([]byte, len(p)+2), eofReader{})
+	r.prefill(p)
+
+	var cmds []*Command
+	for r.Buffered() != 0 {
+		consumed := len(p) - r.Buffered()
+
+		cmd := new(Command)
+		if !cmd.readSmall(r) {
+			if err := readCommand(cmd, r); err == io.EOF {
+				return cmds, consumed, nil
+			} else if err != nil {
+				return cmds, consumed, err
+			}
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, len(p), nil
+}
+
+type eofReader struct{}
+
+func (eofReader) Read(_ []byte) (int, error) { return 0, io.EOF }
+
 // Buffered returns the number of unread bytes.
 func (r *RequestReader) Buffered() int {
 	return r.r.Buffered()
@@ -27,8 +100,20 @@ func (r *RequestReader) Reset(rd io.Reader) {
 	r.r.Reset(rd)
 }
 
+// SetMaxBufferSize allows the buffer to grow temporarily up to n bytes,
+// to fit inline requests and command names which exceed the original
+// size. The buffer is shrunk back as soon as all buffered data has been
+// consumed. Sizes below the original buffer size disable growth.
+func (r *RequestReader) SetMaxBufferSize(n int) {
+	if n < r.r.size {
+		n = r.r.size
+	}
+	r.r.max = n
+}
+
 // PeekCmd peeks the next command name.
 func (r *RequestReader) PeekCmd() (string, error) {
+	r.r.shrink()
 	return r.peekCmd(0)
 }
 
@@ -39,7 +124,11 @@ func (r *RequestReader) ReadCmd(cmd *Command) (*Command, error) {
 	} else {
 		cmd.Reset()
 	}
+	r.r.shrink()
 
+	if cmd.readSmall(r.r) {
+		return cmd, nil
+	}
 	return cmd, readCommand(cmd, r.r)
 }
 
@@ -50,6 +139,7 @@ func (r *RequestReader) StreamCmd(cmd *CommandStream) (*CommandStream, error) {
 	} else {
 		cmd.Reset()
 	}
+	r.r.shrink()
 
 	return cmd, readCommand(cmd, r.r)
 }
@@ -66,7 +156,7 @@ func (r *RequestReader) SkipCmd() error {
 		return err
 	}
 
-	n, err := r.r.ReadArrayLen()
+	n, err := r.r.readMultiBulkLen()
 	if err != nil {
 		return err
 	}
@@ -98,6 +188,8 @@ func (r *RequestReader) peekCmd(offset int) (string, error) {
 	n, err := line.ParseSize('*', errInvalidMultiBulkLength)
 	if err != nil {
 		return "", err
+	} else if n > MaxMultiBulkLength {
+		return "", errInvalidMultiBulkLength
 	}
 
 	if n < 1 {
@@ -113,6 +205,9 @@ func (r *RequestReader) peekCmd(offset int) (string, error) {
 	n, err = line.ParseSize('$', errInvalidBulkLength)
 	if err != nil {
 		return "", err
+	} else if n > MaxBulkSize || int(n)+offset > r.r.max {
+		// command names must fit into the buffer
+		return "", errInvalidBulkLength
 	}
 
 	data, err := r.r.PeekN(offset, int(n))
@@ -3,7 +3,11 @@ package resp_test
 import (
 	"bytes"
 	"io"
+	"math/rand"
+	"runtime"
 	"strings"
+	"testing"
+	"testing/iotest"
 
 	"github.com/johntech-o/redeo/resp"
 	. "github.com/onsi/ginkgo"
@@ -196,6 +200,184 @@ var _ = Describe("RequestReader", func() {
 		Expect(cmd.Name).To(Equal("PING"))
 	})
 
+	It("should support custom buffer sizes", func() {
+		r := resp.NewRequestReaderSize(bytes.NewBufferString(
+			"*2\r\n$4\r\nECHO\r\n$2000\r\n"+strings.Repeat("x", 2000)+"\r\n"+
+				"ECHO "+strings.Repeat("x", 600)+"\r\n",
+		), 100)
+
+		cmd, err := r.ReadCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Name).To(Equal("ECHO"))
+		Expect(cmd.Arg(0)).To(HaveLen(2000))
+
+		_, err = r.ReadCmd(cmd)
+		Expect(err).To(MatchError("Protocol error: too big inline request"))
+	})
+
+	It("should grow buffers temporarily, if allowed", func() {
+		r := resp.NewRequestReaderSize(bytes.NewBufferString(
+			"ECHO "+strings.Repeat("x", 1000)+"\r\n"+
+				"*1\r\n$1500\r\n"+strings.Repeat("X", 1500)+"\r\n"+
+				"PING\r\n"+
+				"ECHO "+strings.Repeat("x", 3000)+"\r\n",
+		), 512)
+		r.SetMaxBufferSize(2048)
+
+		cmd, err := r.ReadCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Name).To(Equal("ECHO"))
+		Expect(cmd.Arg(0)).To(HaveLen(1000))
+
+		Expect(r.PeekCmd()).To(HaveLen(1500))
+		cmd, err = r.ReadCmd(cmd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Name).To(HaveLen(1500))
+
+		cmd, err = r.ReadCmd(cmd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd).To(MatchCommand("PING"))
+
+		_, err = r.ReadCmd(cmd)
+		Expect(err).To(MatchError("Protocol error: too big inline request"))
+	})
+
+	It("should consume pre-read data first", func() {
+		large := "*2\r\n$4\r\nECHO\r\n$70000\r\n" + strings.Repeat("x", 70000) + "\r\n"
+		r := resp.NewRequestReaderBuffered(bytes.NewBufferString("NG\r\nPING\r\n"), []byte(large+"PI"))
+
+		cmd, err := r.ReadCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Name).To(Equal("ECHO"))
+		Expect(cmd.Arg(0)).To(HaveLen(70000))
+
+		cmd, err = r.ReadCmd(cmd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd).To(MatchCommand("PING"))
+
+		cmd, err = r.ReadCmd(cmd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd).To(MatchCommand("PING"))
+	})
+
+	DescribeTable("should parse requests from bytes",
+		func(s string, name string, args []string, n int) {
+			cmd, consumed, err := resp.ParseRequestBytes([]byte(s))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cmd).To(MatchCommand(append([]string{name}, args...)...))
+			Expect(consumed).To(Equal(n))
+		},
+
+		Entry("inline", "PING\r\n", "PING", nil, 6),
+		Entry("multi-bulk", "*2\r\n$4\r\nECHO\r\n$5\r\nHeLLO\r\n", "ECHO", []string{"HeLLO"}, 25),
+		Entry("pipelined", "PING\r\n*1\r\n$4\r\nPING\r\n", "PING", nil, 6),
+		Entry("large", "*2\r\n$4\r\nECHO\r\n$3000\r\n"+strings.Repeat("x", 3000)+"\r\n", "ECHO", []string{strings.Repeat("x", 3000)}, 3023),
+	)
+
+	DescribeTable("should fail to parse bad requests from bytes",
+		func(s string, exp error) {
+			_, _, err := resp.ParseRequestBytes([]byte(s))
+			Expect(err).To(Equal(exp))
+		},
+
+		Entry("empty", "", io.EOF),
+		Entry("incomplete line", "PIN", io.ErrUnexpectedEOF),
+		Entry("incomplete multi-bulk", "*2\r\n$4\r\nECHO\r\n$5\r\nHeL", io.ErrUnexpectedEOF),
+	)
+
+	It("should parse multiple requests from bytes", func() {
+		cmds, n, err := resp.ParseRequests([]byte("PING\r\n*2\r\n$4\r\nECHO\r\n$5\r\nHeLLO\r\n\r\nPING\r\n*2\r\n$4\r\nECHO\r\n$5\r\nHe"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(39))
+		Expect(cmds).To(HaveLen(3))
+		Expect(cmds[0]).To(MatchCommand("PING"))
+		Expect(cmds[1]).To(MatchCommand("ECHO", "HeLLO"))
+		Expect(cmds[2]).To(MatchCommand("PING"))
+
+		cmds, n, err = resp.ParseRequests([]byte("PING\r\nPING\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(12))
+		Expect(cmds).To(HaveLen(2))
+
+		cmds, n, err = resp.ParseRequests(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(0))
+		Expect(cmds).To(BeEmpty())
+
+		cmds, n, err = resp.ParseRequests([]byte("PING\r\n*x\r\n"))
+		Expect(err).To(MatchError("Protocol error: invalid multibulk length"))
+		Expect(n).To(Equal(6))
+		Expect(cmds).To(HaveLen(1))
+	})
+
+	It("should read requests split across multiple reads", func() {
+		r := resp.NewRequestReader(iotest.OneByteReader(bytes.NewBufferString("*2\r\n$4\r\nECHO\r\n$5\r\nHeLLO\r\nPING\r\n")))
+
+		cmd, err := r.ReadCmd(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd).To(MatchCommand("ECHO", "HeLLO"))
+
+		cmd, err = r.ReadCmd(cmd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd).To(MatchCommand("PING"))
+	})
+
+	It("should not allocate declared lengths upfront", func() {
+		var m1, m2 runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m1)
+
+		_, err := setup("*1000000\r\n$3\r\nSET\r\n$1\r\nx\r\n").ReadCmd(nil)
+		Expect(err).To(MatchError("EOF"))
+		_, err = setup("*2\r\n$3\r\nSET\r\n$500000000\r\nxxx").ReadCmd(nil)
+		Expect(err).To(MatchError("EOF"))
+
+		runtime.ReadMemStats(&m2)
+		Expect(m2.TotalAlloc - m1.TotalAlloc).To(BeNumerically("<", 1024*1024))
+	})
+
+	It("should never panic on malformed input", func() {
+		rnd := rand.New(rand.NewSource(33))
+		alphabet := "*$:+-0123456789\r\n\r\nPING"
+
+		for i := 0; i < 5000; i++ {
+			buf := make([]byte, rnd.Intn(64))
+			for j := range buf {
+				buf[j] = alphabet[rnd.Intn(len(alphabet))]
+			}
+
+			var src io.Reader = bytes.NewReader(buf)
+			if i%2 == 0 {
+				src = iotest.HalfReader(src)
+			}
+
+			r := resp.NewRequestReader(src)
+			Expect(func() {
+				for n := 0; n < 100; n++ {
+					if _, err := r.PeekCmd(); err == io.EOF || err == io.ErrUnexpectedEOF {
+						return
+					} else if err != nil {
+						_ = r.SkipCmd()
+						continue
+					}
+
+					var err error
+					if n%2 == 0 {
+						_, err = r.ReadCmd(nil)
+					} else {
+						var cmd *resp.CommandStream
+						if cmd, err = r.StreamCmd(nil); err == nil {
+							err = cmd.Discard()
+						}
+					}
+					if err == io.EOF || err == io.ErrUnexpectedEOF {
+						return
+					}
+				}
+			}).NotTo(Panic(), "input: %q", buf)
+		}
+	})
+
 	It("should recover inconsistent lengths just like Redis", func() {
 		r := setup("*1\r\n$4\r\nPING123\r\n*1\r\n$4\r\nPING\r\n")
 
@@ -277,6 +459,8 @@ var _ = Describe("RequestReader", func() {
 			r := setup(s)
 			_, err := r.ReadCmd(nil)
 			Expect(err).To(MatchError(msg))
+			Expect(err).To(BeAssignableToTypeOf(resp.ProtocolError("")))
+			Expect(resp.IsProtocolError(err)).To(BeTrue())
 		},
 
 		Entry("blank multi-bulk len", "*\r\n", "Protocol error: invalid multibulk length"),
@@ -284,6 +468,9 @@ var _ = Describe("RequestReader", func() {
 		Entry("inline inside multi-bulk", "*1\r\nPING\r\n", "Protocol error: expected '$', got 'P'"),
 		Entry("bad bulk length", "*1\r\n$x\r\n", "Protocol error: invalid bulk length"),
 		Entry("negative bulk length", "*1\r\n$-1\r\n", "Protocol error: invalid bulk length"),
+		Entry("huge multi-bulk len", "*1048577\r\n", "Protocol error: invalid multibulk length"),
+		Entry("huge bulk length", "*1\r\n$536870913\r\n", "Protocol error: invalid bulk length"),
+		Entry("overflowing bulk length", "*1\r\n$99999999999999999999\r\n", "Protocol error: invalid bulk length"),
 	)
 
 	DescribeTable("should peek commands",
@@ -411,3 +598,27 @@ var _ = Describe("RequestWriter", func() {
 	})
 
 })
+
+// --------------------------------------------------------------------
+
+func BenchmarkRequestReader_ReadCmd(b *testing.B) {
+	req := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+	src := bytes.NewReader(req)
+	r := resp.NewRequestReader(src)
+
+	var cmd *resp.Command
+	var err error
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src.Reset(req)
+		r.Reset(src)
+		if _, err = r.PeekCmd(); err != nil {
+			b.Fatal(err)
+		}
+		if cmd, err = r.ReadCmd(cmd); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -69,28 +69,32 @@ func (s *NullString) ScanResponse(t ResponseType, r ResponseReader) error {
 
 // --------------------------------------------------------------------
 
-type protoError string
+// ProtocolError is returned when malformed data is encountered. After a
+// protocol error, the stream is not guaranteed to be aligned with the
+// next message.
+type ProtocolError string
 
-func (p protoError) Error() string { return string(p) }
+// Error implements the error interface
+func (p ProtocolError) Error() string { return string(p) }
 
 func protoErrorf(m string, args ...interface{}) error {
-	return protoError(fmt.Sprintf(m, args...))
+	return ProtocolError(fmt.Sprintf(m, args...))
 }
 
 // IsProtocolError returns true if the error is a protocol error
 func IsProtocolError(err error) bool {
-	_, ok := err.(protoError)
+	_, ok := err.(ProtocolError)
 	return ok
 }
 
 const (
-	errInvalidMultiBulkLength = protoError("Protocol error: invalid multibulk length")
-	errInvalidBulkLength      = protoError("Protocol error: invalid bulk length")
-	errBlankBulkLength        = protoError("Protocol error: expected '$', got ' '")
-	errInlineRequestTooLong   = protoError("Protocol error: too big inline request")
-	errNotANumber             = protoError("Protocol error: expected a number")
-	errNotANilMessage         = protoError("Protocol error: expected a nil")
-	errBadResponseType        = protoError("Protocol error: bad response type")
+	errInvalidMultiBulkLength = ProtocolError("Protocol error: invalid multibulk length")
+	errInvalidBulkLength      = ProtocolError("Protocol error: invalid bulk length")
+	errBlankBulkLength        = ProtocolError("Protocol error: expected '$', got ' '")
+	errInlineRequestTooLong   = ProtocolError("Protocol error: too big inline request")
+	errNotANumber             = ProtocolError("Protocol error: expected a number")
+	errNotANilMessage         = ProtocolError("Protocol error: expected a nil")
+	errBadResponseType        = ProtocolError("Protocol error: bad response type")
 )
 
 var (
@@ -99,9 +103,41 @@ var (
 	binZERO = []byte(":0\r\n")
 	binONE  = []byte(":1\r\n")
 	binNIL  = []byte("$-1\r\n")
+
+	binNILArray   = []byte("*-1\r\n")
+	binEmptyArray = []byte("*0\r\n")
+	binEmptyBulk  = []byte("$0\r\n\r\n")
+
+	binStreamedBulk = []byte("$?\r\n")
+	binStreamEnd    = []byte(";0\r\n")
+
+	binNULL  = []byte("_\r\n")
+	binTRUE  = []byte("#t\r\n")
+	binFALSE = []byte("#f\r\n")
 )
 
-// MaxBufferSize is the max request/response buffer size
+// MaxBufferSize is the max (and default) request/response buffer size
 const MaxBufferSize = 64 * 1024
 
-func mkStdBuffer() []byte { return make([]byte, MaxBufferSize) }
+// MinBufferSize is the min request/response buffer size
+const MinBufferSize = 512
+
+// Limits for requests, taken from Redis. Requests exceeding these are
+// rejected with a ProtocolError before any data is allocated.
+const (
+	// MaxMultiBulkLength is the max number of arguments per request
+	MaxMultiBulkLength = 1024 * 1024
+	// MaxBulkSize is the max size of a single bulk
+	MaxBulkSize = 512 * 1024 * 1024
+)
+
+func mkStdBuffer() []byte { return mkBuffer(MaxBufferSize) }
+
+func mkBuffer(size int) []byte {
+	if size < 1 || size > MaxBufferSize {
+		size = MaxBufferSize
+	} else if size < MinBufferSize {
+		size = MinBufferSize
+	}
+	return make([]byte, size)
+}
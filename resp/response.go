@@ -1,7 +1,9 @@
 package resp
 
 import (
+	"errors"
 	"io"
+	"math/big"
 )
 
 // CustomResponse values implement custom serialization and can be passed
@@ -11,6 +13,12 @@ type CustomResponse interface {
 	AppendTo(w ResponseWriter)
 }
 
+// Protocol versions, see ResponseWriter.SetProtocol
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
 // ResponseWriter is used by servers to wrap a client connection and send
 // protocol-compatible responses in buffered pipelines.
 type ResponseWriter interface {
@@ -32,7 +40,8 @@ type ResponseWriter interface {
 	AppendErrorf(pattern string, args ...interface{})
 	// AppendInt appends a numeric response to the output buffer.
 	AppendInt(n int64)
-	// AppendNil appends a nil-value to the output buffer.
+	// AppendNil appends a nil-value to the output buffer, a nil bulk for
+	// RESP2 ($-1) or a null for RESP3 (_).
 	AppendNil()
 	// AppendOK appends "OK" to the output buffer.
 	AppendOK()
@@ -44,6 +53,7 @@ type ResponseWriter interface {
 	//   * []byte
 	//   * bool
 	//   * float32, float64
+	//   * *big.Int
 	//   * int, int8, int16, int32, int64
 	//   * uint, uint8, uint16, uint32, uint64
 	//   * CustomResponse instances
@@ -60,11 +70,87 @@ type ResponseWriter interface {
 	Reset(w io.Writer)
 }
 
+// ExtendedResponseWriter is implemented by the writers returned by
+// NewResponseWriter and by the writers redeo servers pass to handlers. It
+// adds the RESP3 protocol and the reply types which are not covered by
+// ResponseWriter. Handlers may type-assert it, but wrapped writers may
+// only expose it via Unwrap, see Extended. The functions of this package
+// of the same names handle both and fall back to RESP2 replies for other
+// writers.
+type ExtendedResponseWriter interface {
+	ResponseWriter
+
+	// AppendNilArray appends a nil array to the output buffer, a nil array
+	// header for RESP2 (*-1) or a null for RESP3 (_).
+	AppendNilArray()
+	// AppendEmptyArray appends an array with no elements to the output buffer.
+	AppendEmptyArray()
+	// AppendEmptyBulk appends a bulk of zero length to the output buffer.
+	AppendEmptyBulk()
+	// AppendFloat appends a double to the output buffer, formatted with
+	// up to 17 significant digits. RESP2 writers append a bulk string.
+	AppendFloat(f float64)
+	// AppendBool appends a boolean to the output buffer. RESP2 writers
+	// append 1 or 0 as an integer.
+	AppendBool(b bool)
+	// AppendBigInt appends a big number to the output buffer. RESP2
+	// writers append a bulk string.
+	AppendBigInt(n *big.Int)
+	// AppendVerbatimString appends a verbatim string with a three letter
+	// format, e.g. "txt" or "mkd". RESP2 writers append a bulk string.
+	AppendVerbatimString(format, s string)
+	// AppendBulkStream starts a bulk of unknown length, the returned writer
+	// appends data until it is closed. RESP3 writers append a streamed
+	// string, each write becomes a chunk. RESP2 writers buffer all data and
+	// append a bulk on Close.
+	AppendBulkStream() io.WriteCloser
+	// AppendMapLen appends a map header for n key/value pairs to the output
+	// buffer. RESP2 writers append an array header of n*2 elements instead.
+	AppendMapLen(n int)
+	// AppendPushLen appends a push header for n elements to the output
+	// buffer, e.g. for pub/sub messages. RESP2 writers append an array
+	// header instead.
+	AppendPushLen(n int)
+	// AppendAttributes appends an attribute frame, which is attached to
+	// the reply that follows. Values are serialized like Append and keys
+	// are sorted. Attributes are RESP3 only, RESP2 writers discard them.
+	AppendAttributes(attrs map[string]interface{}) error
+	// Protocol returns the protocol version, RESP2 by default and after
+	// Reset.
+	Protocol() int
+	// SetProtocol sets the protocol version, either RESP2 or RESP3.
+	SetProtocol(v int)
+}
+
+// ErrReplyTooLarge is returned when a reply exceeds the limit set via
+// ReplyLimiter.BeginReply
+var ErrReplyTooLarge = errors.New("resp: reply too large")
+
+// ReplyLimiter is implemented by the writers returned by
+// NewResponseWriter. It accounts for the size of individual replies and
+// guards against oversized ones.
+type ReplyLimiter interface {
+	// BeginReply starts a new reply. Once the reply exceeds limit bytes,
+	// its buffered part is discarded and further appends are ignored. A
+	// limit <= 0 disables the limit.
+	BeginReply(limit int64)
+	// EndReply returns the size of the reply, in bytes. Replies which
+	// exceeded the limit return ErrReplyTooLarge, in which case partial
+	// reports whether parts of the reply have been written already.
+	EndReply() (size int64, partial bool, err error)
+}
+
 // NewResponseWriter wraps any writer interface, but
 // normally a net.Conn.
 func NewResponseWriter(wr io.Writer) ResponseWriter {
+	return NewResponseWriterSize(wr, MaxBufferSize)
+}
+
+// NewResponseWriterSize wraps any writer interface using a buffer of the
+// initial size. Sizes are capped between MinBufferSize and MaxBufferSize.
+func NewResponseWriterSize(wr io.Writer, size int) ResponseWriter {
 	w := new(bufioW)
-	w.reset(mkStdBuffer(), wr)
+	w.reset(mkBuffer(size), wr)
 	return w
 }
 
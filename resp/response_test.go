@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"testing"
 	"time"
 
 	"github.com/johntech-o/redeo/resp"
@@ -16,12 +20,12 @@ import (
 )
 
 var _ = Describe("ResponseWriter", func() {
-	var subject resp.ResponseWriter
+	var subject resp.ExtendedResponseWriter
 	var buf = new(bytes.Buffer)
 
 	BeforeEach(func() {
 		buf.Reset()
-		subject = resp.NewResponseWriter(buf)
+		subject = resp.NewResponseWriter(buf).(resp.ExtendedResponseWriter)
 	})
 
 	It("should append bulks", func() {
@@ -74,6 +78,26 @@ var _ = Describe("ResponseWriter", func() {
 		Expect(buf.String()).To(Equal(":27\r\n:1\r\n"))
 	})
 
+	It("should format ints and sizes without allocations", func() {
+		w := resp.NewResponseWriter(ioutil.Discard)
+		Expect(testing.AllocsPerRun(1000, func() {
+			w.AppendArrayLen(3)
+			w.AppendInt(1234567890)
+			w.AppendInt(-42)
+			w.AppendBulkString("value")
+			_ = w.Flush()
+		})).To(BeZero())
+	})
+
+	It("should support custom buffer sizes", func() {
+		buf := new(bytes.Buffer)
+		w := resp.NewResponseWriterSize(buf, 600)
+		w.AppendBulkString(strings.Repeat("x", 1000))
+		Expect(w.Buffered()).To(Equal(1009))
+		Expect(w.Flush()).To(Succeed())
+		Expect(buf.Len()).To(Equal(1009))
+	})
+
 	It("should append nils", func() {
 		subject.AppendNil()
 		Expect(buf.String()).To(BeEmpty())
@@ -81,6 +105,24 @@ var _ = Describe("ResponseWriter", func() {
 		Expect(buf.String()).To(Equal("$-1\r\n"))
 	})
 
+	It("should distinguish nil and empty replies", func() {
+		subject.AppendNil()
+		subject.AppendNilArray()
+		subject.AppendEmptyArray()
+		subject.AppendEmptyBulk()
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("$-1\r\n*-1\r\n*0\r\n$0\r\n\r\n"))
+
+		buf.Reset()
+		subject.SetProtocol(resp.RESP3)
+		subject.AppendNil()
+		subject.AppendNilArray()
+		subject.AppendEmptyArray()
+		subject.AppendEmptyBulk()
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("_\r\n_\r\n*0\r\n$0\r\n\r\n"))
+	})
+
 	It("should append OK", func() {
 		subject.AppendOK()
 		Expect(buf.String()).To(BeEmpty())
@@ -88,6 +130,230 @@ var _ = Describe("ResponseWriter", func() {
 		Expect(buf.String()).To(Equal("+OK\r\n"))
 	})
 
+	DescribeTable("should append floats",
+		func(f float64, resp2, resp3 string) {
+			subject.AppendFloat(f)
+			subject.SetProtocol(resp.RESP3)
+			subject.AppendFloat(f)
+			Expect(subject.Flush()).To(Succeed())
+			Expect(buf.String()).To(Equal(resp2 + resp3))
+		},
+		Entry("integral", 3.0, "$1\r\n3\r\n", ",3\r\n"),
+		Entry("fraction", 1.5, "$3\r\n1.5\r\n", ",1.5\r\n"),
+		Entry("17 digits", 0.1, "$19\r\n0.10000000000000001\r\n", ",0.10000000000000001\r\n"),
+		Entry("exponent", 1e20, "$5\r\n1e+20\r\n", ",1e+20\r\n"),
+		Entry("inf", math.Inf(-1), "$4\r\n-inf\r\n", ",-inf\r\n"),
+		Entry("nan", math.NaN(), "$3\r\nnan\r\n", ",nan\r\n"),
+	)
+
+	It("should append bools", func() {
+		subject.AppendBool(true)
+		subject.AppendBool(false)
+		subject.SetProtocol(resp.RESP3)
+		subject.AppendBool(true)
+		subject.AppendBool(false)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal(":1\r\n:0\r\n#t\r\n#f\r\n"))
+	})
+
+	It("should append big numbers", func() {
+		n, _ := new(big.Int).SetString("-3492890328409238509324850943850943825024385", 10)
+		subject.AppendBigInt(n)
+		subject.SetProtocol(resp.RESP3)
+		subject.AppendBigInt(n)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("$44\r\n-3492890328409238509324850943850943825024385\r\n" +
+			"(-3492890328409238509324850943850943825024385\r\n"))
+	})
+
+	It("should append verbatim strings", func() {
+		subject.AppendVerbatimString("txt", "Some string")
+		subject.SetProtocol(resp.RESP3)
+		subject.AppendVerbatimString("mkd", "# Title")
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("$11\r\nSome string\r\n=11\r\nmkd:# Title\r\n"))
+	})
+
+	It("should append bulk streams", func() {
+		w := subject.AppendBulkStream()
+		Expect(fmt.Fprint(w, "Hello ")).To(Equal(6))
+		Expect(fmt.Fprint(w, "world")).To(Equal(5))
+		Expect(w.Close()).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("$11\r\nHello world\r\n"))
+
+		buf.Reset()
+		subject.SetProtocol(resp.RESP3)
+		w = subject.AppendBulkStream()
+		Expect(fmt.Fprint(w, "Hello ")).To(Equal(6))
+		Expect(fmt.Fprint(w, "")).To(Equal(0))
+		Expect(fmt.Fprint(w, "world")).To(Equal(5))
+		Expect(w.Close()).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("$?\r\n;6\r\nHello \r\n;5\r\nworld\r\n;0\r\n"))
+
+		Expect(w.Close()).To(MatchError("resp: bulk stream already closed"))
+		_, err := w.Write([]byte("x"))
+		Expect(err).To(MatchError("resp: bulk stream already closed"))
+	})
+
+	It("should append maps", func() {
+		subject.AppendMapLen(2)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*4\r\n"))
+
+		buf.Reset()
+		subject.SetProtocol(resp.RESP3)
+		subject.AppendMapLen(2)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("%2\r\n"))
+	})
+
+	It("should append push frames", func() {
+		subject.AppendPushLen(3)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*3\r\n"))
+
+		buf.Reset()
+		subject.SetProtocol(resp.RESP3)
+		subject.AppendPushLen(3)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal(">3\r\n"))
+	})
+
+	It("should fall back to RESP2 for plain writers", func() {
+		plain := plainWriter{subject}
+		Expect(resp.SetProtocol(plain, resp.RESP3)).To(BeFalse())
+		Expect(resp.Protocol(plain)).To(Equal(resp.RESP2))
+		Expect(subject.Protocol()).To(Equal(resp.RESP2))
+
+		resp.AppendMapLen(plain, 1)
+		resp.AppendFloat(plain, 1.5)
+		resp.AppendBool(plain, true)
+		resp.AppendNilArray(plain)
+		s := resp.AppendBulkStream(plain)
+		_, _ = s.Write([]byte("ab"))
+		Expect(s.Close()).To(Succeed())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("*2\r\n$3\r\n1.5\r\n:1\r\n*-1\r\n$2\r\nab\r\n"))
+	})
+
+	It("should look through wrapping writers", func() {
+		wrapped := unwrapWriter{plainWriter{subject}}
+		Expect(resp.SetProtocol(wrapped, resp.RESP3)).To(BeTrue())
+		Expect(resp.Protocol(wrapped)).To(Equal(resp.RESP3))
+
+		resp.AppendMapLen(wrapped, 1)
+		resp.AppendPushLen(wrapped, 2)
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("%1\r\n>2\r\n"))
+	})
+
+	It("should append attributes", func() {
+		attrs := map[string]interface{}{"ttl": 3600, "key-popularity": []string{"a"}}
+		Expect(subject.AppendAttributes(attrs)).To(Succeed())
+		subject.AppendOK()
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("+OK\r\n"))
+
+		buf.Reset()
+		subject.SetProtocol(resp.RESP3)
+		Expect(subject.AppendAttributes(attrs)).To(Succeed())
+		subject.AppendOK()
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("|2\r\n$14\r\nkey-popularity\r\n*1\r\n$1\r\na\r\n$3\r\nttl\r\n:3600\r\n+OK\r\n"))
+	})
+
+	It("should reset the protocol version", func() {
+		subject.SetProtocol(resp.RESP3)
+		Expect(subject.Protocol()).To(Equal(resp.RESP3))
+		subject.Reset(buf)
+		Expect(subject.Protocol()).To(Equal(resp.RESP2))
+	})
+
+	It("should append JSON", func() {
+		Expect(resp.AppendJSON(subject, map[string]interface{}{"a": 1, "b": "<c>"})).To(Succeed())
+		Expect(resp.AppendJSON(subject, make(chan int))).To(HaveOccurred())
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("$17\r\n{\"a\":1,\"b\":\"<c>\"}\r\n"))
+	})
+
+	It("should append protobuf messages", func() {
+		Expect(resp.AppendProto(subject, mockProto("plain"))).To(Succeed())
+		Expect(resp.AppendProto(subject, &mockSizedProto{data: "sized"})).To(Succeed())
+		Expect(resp.AppendProto(subject, mockProto(""))).To(MatchError("empty message"))
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("$5\r\nplain\r\n$5\r\nsized\r\n"))
+	})
+
+	It("should account for and limit replies", func() {
+		lim := subject.(resp.ReplyLimiter)
+		subject.AppendOK()
+
+		lim.BeginReply(0)
+		subject.AppendArrayLen(2)
+		subject.AppendBulkString("short")
+		subject.AppendInt(1)
+		size, partial, err := lim.EndReply()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(size).To(Equal(int64(19)))
+		Expect(partial).To(BeFalse())
+
+		lim.BeginReply(10)
+		subject.AppendArrayLen(2)
+		subject.AppendBulkString("short")
+		subject.AppendInt(1)
+		size, partial, err = lim.EndReply()
+		Expect(err).To(Equal(resp.ErrReplyTooLarge))
+		Expect(size).To(Equal(int64(15)))
+		Expect(partial).To(BeFalse())
+
+		subject.AppendBulkString("x")
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("+OK\r\n*2\r\n$5\r\nshort\r\n:1\r\n$1\r\nx\r\n"))
+	})
+
+	It("should report partially written replies", func() {
+		subject = resp.NewResponseWriterSize(buf, resp.MinBufferSize).(resp.ExtendedResponseWriter)
+		lim := subject.(resp.ReplyLimiter)
+		large := bytes.Repeat([]byte{'x'}, 5000)
+
+		lim.BeginReply(8000)
+		subject.AppendArrayLen(2)
+		subject.AppendBulk(large)
+		subject.AppendBulk(large)
+		Expect(subject.CopyBulk(strings.NewReader("data"), 4)).To(MatchError(resp.ErrReplyTooLarge))
+		size, partial, err := lim.EndReply()
+		Expect(err).To(Equal(resp.ErrReplyTooLarge))
+		Expect(size).To(Equal(int64(10022)))
+		Expect(partial).To(BeTrue())
+
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.Len()).To(Equal(5013))
+	})
+
+	It("should write large bulks directly", func() {
+		large := bytes.Repeat([]byte{'x'}, 100000)
+		subject.AppendArrayLen(2)
+		subject.AppendBulk(large)
+		Expect(buf.Len()).To(Equal(100015))
+		Expect(buf.String()).To(HavePrefix("*2\r\n$100000\r\nxxx"))
+		Expect(buf.String()).To(HaveSuffix("xxx\r\n"))
+
+		subject.AppendBulk([]byte("small"))
+		Expect(buf.Len()).To(Equal(100015))
+		Expect(subject.Flush()).To(Succeed())
+		Expect(buf.Len()).To(Equal(100026))
+		Expect(buf.String()).To(HaveSuffix("xxx\r\n$5\r\nsmall\r\n"))
+	})
+
+	It("should retain write errors of large bulks", func() {
+		subject = resp.NewResponseWriter(&failingWriter{}).(resp.ExtendedResponseWriter)
+		subject.AppendBulk(bytes.Repeat([]byte{'x'}, 100000))
+		subject.AppendOK()
+		Expect(subject.Flush()).To(MatchError("write failed"))
+	})
+
 	It("should copy from readers", func() {
 		src := strings.NewReader("this is a streaming data source")
 		subject.AppendArrayLen(1)
@@ -159,6 +425,22 @@ var _ = Describe("ResponseReader", func() {
 		Expect(t).To(Equal(resp.TypeInline))
 	})
 
+	It("should read nil arrays and nulls", func() {
+		buf.WriteString("*-1\r\n_\r\n*0\r\n")
+
+		for i := 0; i < 2; i++ {
+			t, err := subject.PeekType()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t).To(Equal(resp.TypeNil))
+			Expect(subject.ReadNil()).To(Succeed())
+		}
+
+		t, err := subject.PeekType()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(t).To(Equal(resp.TypeArray))
+		Expect(subject.ReadArrayLen()).To(Equal(0))
+	})
+
 	It("should read strings", func() {
 		buf.WriteString("$4\r\nPING\r\n+OK\r\n")
 
@@ -460,3 +742,63 @@ func (r customErrorResponse) AppendTo(w resp.ResponseWriter) {
 func (r customErrorResponse) Error() string {
 	return "WRONG " + string(r)
 }
+
+// --------------------------------------------------------------------
+
+type failingWriter struct{}
+
+type mockProto string
+
+func (m mockProto) Marshal() ([]byte, error) {
+	if m == "" {
+		return nil, errors.New("empty message")
+	}
+	return []byte(m), nil
+}
+
+type mockSizedProto struct{ data string }
+
+func (m *mockSizedProto) Marshal() ([]byte, error)        { return []byte(m.data), nil }
+func (m *mockSizedProto) Size() int                       { return len(m.data) }
+func (m *mockSizedProto) MarshalTo(p []byte) (int, error) { return copy(p, m.data), nil }
+
+func (failingWriter) Write(_ []byte) (int, error) { return 0, errors.New("write failed") }
+
+// --------------------------------------------------------------------
+
+func BenchmarkResponseWriter_AppendInt(b *testing.B) {
+	w := resp.NewResponseWriter(ioutil.Discard)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.AppendInt(int64(i % 5000))
+		w.AppendInt(-1)
+		w.AppendInt(1234567890)
+		if i%1000 == 0 {
+			_ = w.Flush()
+		}
+	}
+}
+
+func BenchmarkResponseWriter_AppendBulkString(b *testing.B) {
+	w := resp.NewResponseWriter(ioutil.Discard)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.AppendArrayLen(2)
+		w.AppendBulkString("many words")
+		w.AppendBulkString("more words, with a long bulk size header")
+		if i%1000 == 0 {
+			_ = w.Flush()
+		}
+	}
+}
+
+// plainWriter hides the extended methods of a writer
+type plainWriter struct{ resp.ResponseWriter }
+
+type unwrapWriter struct{ plainWriter }
+
+func (w unwrapWriter) Unwrap() resp.ResponseWriter { return w.plainWriter.ResponseWriter }
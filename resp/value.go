@@ -2,6 +2,7 @@ package resp
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
@@ -21,11 +22,7 @@ func (w *bufioW) Append(v interface{}) error {
 		}
 		w.AppendError(msg)
 	case bool:
-		if v {
-			w.AppendInt(1)
-		} else {
-			w.AppendInt(0)
-		}
+		w.AppendBool(v)
 	case int:
 		w.AppendInt(int64(v))
 	case int8:
@@ -56,6 +53,8 @@ func (w *bufioW) Append(v interface{}) error {
 		w.AppendInlineString(strconv.FormatFloat(float64(v), 'f', -1, 32))
 	case float64:
 		w.AppendInlineString(strconv.FormatFloat(v, 'f', -1, 64))
+	case *big.Int:
+		w.AppendBigInt(v)
 	default:
 		switch reflect.TypeOf(v).Kind() {
 		case reflect.Slice:
@@ -68,7 +67,7 @@ func (w *bufioW) Append(v interface{}) error {
 		case reflect.Map:
 			s := reflect.ValueOf(v)
 
-			w.AppendArrayLen(s.Len() * 2)
+			w.AppendMapLen(s.Len())
 			for _, key := range s.MapKeys() {
 				w.Append(key.Interface())
 				w.Append(s.MapIndex(key).Interface())
@@ -0,0 +1,29 @@
+//go:build linux && go1.11 && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,go1.11,!mips,!mipsle,!mips64,!mips64le
+
+package redeo
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// soReusePort is SO_REUSEPORT, which syscall does not define on linux.
+// The value differs on mips, which is therefore excluded.
+const soReusePort = 0xf
+
+func listenReusePort(network, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, rc syscall.RawConn) error {
+			var err error
+			if cerr := rc.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	return lc.Listen(context.Background(), network, addr)
+}
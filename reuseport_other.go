@@ -0,0 +1,13 @@
+//go:build !linux || !go1.11 || mips || mipsle || mips64 || mips64le
+// +build !linux !go1.11 mips mipsle mips64 mips64le
+
+package redeo
+
+import (
+	"errors"
+	"net"
+)
+
+func listenReusePort(_, _ string) (net.Listener, error) {
+	return nil, errors.New("redeo: SO_REUSEPORT is not supported on this platform")
+}
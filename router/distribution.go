@@ -0,0 +1,99 @@
+package router
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// HashFunc hashes keys
+type HashFunc func(key string) uint32
+
+// FNV1a hashes keys using 32-bit FNV-1a
+func FNV1a(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// CRC32 hashes keys using the IEEE CRC-32 checksum
+func CRC32(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// Distribution maps keys to backends
+type Distribution interface {
+	// Backend returns the index of the backend responsible for key
+	Backend(key string) int
+}
+
+// Modulo distributes keys by their hash modulo the number of backends.
+// Adding or removing backends remaps most keys.
+func Modulo(n int, hash HashFunc) Distribution {
+	if hash == nil {
+		hash = FNV1a
+	}
+	return modulo{n: uint32(n), hash: hash}
+}
+
+type modulo struct {
+	n    uint32
+	hash HashFunc
+}
+
+func (m modulo) Backend(key string) int { return int(m.hash(key) % m.n) }
+
+// ketamaPoints is the number of points per backend on the ring,
+// as used by twemproxy and libmemcached
+const ketamaPoints = 160
+
+// Ketama distributes keys via a consistent hashing ring, compatible with
+// twemproxy's ketama distribution. Backends are identified by names, e.g.
+// their addresses, so adding or removing a backend only remaps the keys
+// of the adjacent ring segments.
+func Ketama(names []string, hash HashFunc) Distribution {
+	if hash == nil {
+		hash = FNV1a
+	}
+
+	k := &ketama{hash: hash, points: make([]ketamaPoint, 0, len(names)*ketamaPoints)}
+	for i, name := range names {
+		for j := 0; j < ketamaPoints/4; j++ {
+			sum := md5.Sum([]byte(name + "-" + strconv.Itoa(j)))
+			for n := 0; n < 4; n++ {
+				k.points = append(k.points, ketamaPoint{
+					value: binary.LittleEndian.Uint32(sum[n*4:]),
+					index: i,
+				})
+			}
+		}
+	}
+	sort.Slice(k.points, func(i, j int) bool { return k.points[i].value < k.points[j].value })
+	return k
+}
+
+type ketama struct {
+	points []ketamaPoint
+	hash   HashFunc
+}
+
+type ketamaPoint struct {
+	value uint32
+	index int
+}
+
+func (k *ketama) Backend(key string) int {
+	if len(k.points) == 0 {
+		return 0
+	}
+
+	h := k.hash(key)
+	i := sort.Search(len(k.points), func(i int) bool { return k.points[i].value >= h })
+	if i == len(k.points) {
+		i = 0
+	}
+	return k.points[i].index
+}
@@ -0,0 +1,156 @@
+// Package router implements a sharding proxy handler, which routes
+// commands to upstream servers based on their keys, similar to twemproxy.
+package router
+
+import (
+	"fmt"
+
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/client"
+	"github.com/johntech-o/redeo/resp"
+)
+
+// Backend is an upstream server
+type Backend struct {
+	// Name identifies the backend on the hash ring, e.g. its address
+	Name string
+
+	// Pool holds the connections to the backend
+	Pool *client.Pool
+}
+
+// Options configure a Router
+type Options struct {
+	// Distribution maps keys to backends.
+	// Default: Ketama over the backend names, using FNV1a
+	Distribution Distribution
+
+	// HashTags enables hash tags, so only the part of a key between
+	// braces is hashed, see redeo.HashTag.
+	// Default: false
+	HashTags bool
+}
+
+// Router forwards commands to backends, based on the first argument
+// of each command. Multi-key commands are sent to the backend owning the
+// first key, use hash tags to keep related keys together.
+type Router struct {
+	backends []Backend
+	opt      Options
+}
+
+// New inits a router for the given backends
+func New(backends []Backend, opt *Options) *Router {
+	var o Options
+	if opt != nil {
+		o = *opt
+	}
+	if o.Distribution == nil {
+		names := make([]string, len(backends))
+		for i, b := range backends {
+			names[i] = b.Name
+		}
+		o.Distribution = Ketama(names, FNV1a)
+	}
+
+	return &Router{backends: backends, opt: o}
+}
+
+// Backend returns the backend responsible for a key
+func (r *Router) Backend(key string) Backend {
+	if r.opt.HashTags {
+		key = redeo.HashTag(key)
+	}
+	return r.backends[r.opt.Distribution.Backend(key)]
+}
+
+// ServeRedeo implements redeo.Handler
+func (r *Router) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	b := r.Backend(c.Arg(0).String())
+	if err := forward(w, b.Pool, c); err != nil {
+		w.AppendError("ERR backend " + b.Name + ": " + err.Error())
+	}
+}
+
+func forward(w resp.ResponseWriter, pool *client.Pool, c *resp.Command) error {
+	cn, err := pool.Get()
+	if err != nil {
+		return err
+	}
+	defer pool.Put(cn)
+
+	_ = cn.WriteMultiBulkSize(c.ArgN() + 1)
+	cn.WriteBulkString(c.Name)
+	for _, arg := range c.Args {
+		cn.WriteBulk(arg)
+	}
+	if err := cn.Flush(); err != nil {
+		cn.MarkFailed()
+		return err
+	}
+
+	if err := copyReply(w, cn); err != nil {
+		cn.MarkFailed()
+		return err
+	}
+	return nil
+}
+
+// copyReply copies a single reply
+func copyReply(w resp.ResponseWriter, r resp.ResponseParser) error {
+	t, err := r.PeekType()
+	if err != nil {
+		return err
+	}
+
+	switch t {
+	case resp.TypeArray:
+		n, err := r.ReadArrayLen()
+		if err != nil {
+			return err
+		}
+		w.AppendArrayLen(n)
+		for i := 0; i < n; i++ {
+			if err := copyReply(w, r); err != nil {
+				return err
+			}
+		}
+	case resp.TypeBulk:
+		b, err := r.ReadBulk(nil)
+		if err != nil {
+			return err
+		}
+		w.AppendBulk(b)
+	case resp.TypeInline:
+		s, err := r.ReadInlineString()
+		if err != nil {
+			return err
+		}
+		w.AppendInlineString(s)
+	case resp.TypeError:
+		s, err := r.ReadError()
+		if err != nil {
+			return err
+		}
+		w.AppendError(s)
+	case resp.TypeInt:
+		n, err := r.ReadInt()
+		if err != nil {
+			return err
+		}
+		w.AppendInt(n)
+	case resp.TypeNil:
+		if err := r.ReadNil(); err != nil {
+			return err
+		}
+		w.AppendNil()
+	default:
+		return fmt.Errorf("unexpected response type %s", t)
+	}
+	return nil
+}
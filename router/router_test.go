@@ -0,0 +1,187 @@
+package router
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/bsm/pool"
+	"github.com/johntech-o/redeo"
+	"github.com/johntech-o/redeo/client"
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Router", func() {
+	var backends []*mockBackend
+	var subject *Router
+
+	var call = func(name string, args ...string) interface{} {
+		cmd := resp.NewCommand(name)
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		w := redeotest.NewRecorder()
+		subject.ServeRedeo(w, cmd)
+		v, _ := w.Response()
+		return v
+	}
+
+	BeforeEach(func() {
+		backends = []*mockBackend{newMockBackend(), newMockBackend()}
+		subject = New([]Backend{
+			{Name: "a", Pool: backends[0].pool},
+			{Name: "b", Pool: backends[1].pool},
+		}, &Options{Distribution: Modulo(2, CRC32), HashTags: true})
+	})
+
+	AfterEach(func() {
+		for _, b := range backends {
+			b.Close()
+		}
+	})
+
+	It("should route commands", func() {
+		var keys [2][]string
+		for i := 0; i < 10; i++ {
+			key := "key" + strconv.Itoa(i)
+			Expect(call("SET", key, "value")).To(Equal("OK"))
+			Expect(call("GET", key)).To(Equal("value"))
+
+			n := CRC32(key) % 2
+			keys[n] = append(keys[n], key)
+		}
+		Expect(keys[0]).NotTo(BeEmpty())
+		Expect(keys[1]).NotTo(BeEmpty())
+		Expect(backends[0].Keys()).To(ConsistOf(keys[0]))
+		Expect(backends[1].Keys()).To(ConsistOf(keys[1]))
+		Expect(call("GET", "missing")).To(BeNil())
+
+		Expect(subject.Backend(keys[0][0]).Name).To(Equal("a"))
+		Expect(subject.Backend("{" + keys[1][0] + "}.x").Name).To(Equal("b"))
+	})
+
+	It("should relay replies", func() {
+		Expect(call("SET", "key")).To(MatchError("ERR wrong number of arguments for 'SET' command"))
+		Expect(call("MULTI", "key")).To(Equal([]interface{}{"key", int64(1), nil, []interface{}{"nested"}}))
+		Expect(call("SET")).To(MatchError("ERR wrong number of arguments for 'SET' command"))
+	})
+
+	It("should report backend failures", func() {
+		backends[0].Close()
+		Expect(call("GET", "key1")).To(MatchError(HavePrefix("ERR backend a: ")))
+	})
+
+})
+
+var _ = Describe("Distribution", func() {
+
+	It("should distribute by modulo", func() {
+		subject := Modulo(3, nil)
+		Expect(subject.Backend("foo")).To(Equal(int(FNV1a("foo") % 3)))
+	})
+
+	It("should distribute consistently", func() {
+		names := []string{"10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"}
+		subject := Ketama(names, nil)
+
+		counts := make([]int, len(names))
+		for i := 0; i < 3000; i++ {
+			counts[subject.Backend("key"+strconv.Itoa(i))]++
+		}
+		for _, n := range counts {
+			Expect(n).To(BeNumerically("~", 1000, 300))
+		}
+
+		// adding a backend remaps only a fraction of keys
+		grown := Ketama(append(names, "10.0.0.4:6379"), nil)
+		moved := 0
+		for i := 0; i < 3000; i++ {
+			key := "key" + strconv.Itoa(i)
+			if a, b := subject.Backend(key), grown.Backend(key); a != b {
+				Expect(b).To(Equal(3))
+				moved++
+			}
+		}
+		Expect(moved).To(BeNumerically("~", 750, 300))
+
+		Expect(Ketama(nil, nil).Backend("key")).To(Equal(0))
+	})
+
+})
+
+// --------------------------------------------------------------------
+
+type mockBackend struct {
+	lis  net.Listener
+	pool *client.Pool
+	data map[string]string
+	mu   sync.Mutex
+}
+
+func newMockBackend() *mockBackend {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	b := &mockBackend{lis: lis, data: make(map[string]string)}
+	b.pool, err = client.New(&pool.Options{InitialSize: 1}, func() (net.Conn, error) {
+		return net.Dial("tcp", lis.Addr().String())
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	srv := redeo.NewServer(nil)
+	srv.HandleFunc("set", func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 2 {
+			w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+			return
+		}
+		b.mu.Lock()
+		b.data[c.Arg(0).String()] = c.Arg(1).String()
+		b.mu.Unlock()
+		w.AppendOK()
+	})
+	srv.HandleFunc("get", func(w resp.ResponseWriter, c *resp.Command) {
+		b.mu.Lock()
+		v, ok := b.data[c.Arg(0).String()]
+		b.mu.Unlock()
+		if ok {
+			w.AppendBulkString(v)
+		} else {
+			w.AppendNil()
+		}
+	})
+	srv.HandleFunc("multi", func(w resp.ResponseWriter, c *resp.Command) {
+		w.AppendArrayLen(4)
+		w.AppendBulk(c.Arg(0))
+		w.AppendInt(1)
+		w.AppendNil()
+		w.AppendArrayLen(1)
+		w.AppendInlineString("nested")
+	})
+	go srv.Serve(lis)
+	return b
+}
+
+func (b *mockBackend) Keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var keys []string
+	for k := range b.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (b *mockBackend) Close() {
+	_ = b.pool.Close()
+	_ = b.lis.Close()
+}
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "redeo/router")
+}
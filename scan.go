@@ -0,0 +1,258 @@
+package redeo
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// ErrInvalidCursor is returned when SCAN cursors cannot be parsed
+var ErrInvalidCursor = errors.New("ERR invalid cursor")
+
+// DefaultScanCount is the default COUNT of SCAN commands
+const DefaultScanCount = 10
+
+// Scanner is implemented by stores that support resumable iteration.
+// Implementations may return elements at most once per full iteration,
+// but must return all elements which exist for the whole iteration.
+type Scanner interface {
+	// Scan resumes the iteration at cursor, which is 0 for a new
+	// iteration, and calls fn for roughly count elements. Hashes and sorted
+	// sets pass fields and members as elem, and values and scores as
+	// values. Scan returns the cursor to resume from, or 0 if the
+	// iteration is complete.
+	Scan(cursor uint64, count int, fn func(elem string, values ...string)) uint64
+}
+
+// ScannerFunc is a function which implements Scanner
+type ScannerFunc func(cursor uint64, count int, fn func(elem string, values ...string)) uint64
+
+// Scan implements Scanner
+func (f ScannerFunc) Scan(cursor uint64, count int, fn func(elem string, values ...string)) uint64 {
+	return f(cursor, count, fn)
+}
+
+// KeyTyper is implemented by keyspace scanners which support the SCAN
+// TYPE option
+type KeyTyper interface {
+	// KeyType returns the type of the key, e.g. "string" or "hash"
+	KeyType(key string) string
+}
+
+// ScanSlice iterates over a stable slice, using the index as cursor. It
+// can be used to implement Scanner for small or ordered collections.
+func ScanSlice(elems []string, cursor uint64, count int, fn func(elem string, values ...string)) uint64 {
+	if cursor >= uint64(len(elems)) {
+		return 0
+	}
+	if count < 1 {
+		count = DefaultScanCount
+	}
+
+	end := cursor + uint64(count)
+	if end >= uint64(len(elems)) {
+		end = uint64(len(elems))
+	}
+	for _, elem := range elems[cursor:end] {
+		fn(elem)
+	}
+
+	if end == uint64(len(elems)) {
+		return 0
+	}
+	return end
+}
+
+// ParseCursor parses a SCAN cursor
+func ParseCursor(s string) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return n, nil
+}
+
+// FormatCursor formats a SCAN cursor
+func FormatCursor(cursor uint64) string {
+	return strconv.FormatUint(cursor, 10)
+}
+
+// ScanArgs are the arguments of SCAN-like commands
+type ScanArgs struct {
+	// Cursor to resume from
+	Cursor uint64
+
+	// Match is the MATCH glob pattern, empty if not set
+	Match string
+
+	// Count is the COUNT budget.
+	// Default: 10
+	Count int
+
+	// Type is the TYPE filter, empty if not set
+	Type string
+}
+
+// ParseScanArgs parses "cursor [MATCH pattern] [COUNT count] [TYPE type]".
+// The TYPE option is only accepted if allowType is true.
+func ParseScanArgs(args []resp.CommandArgument, allowType bool) (*ScanArgs, error) {
+	if len(args) == 0 {
+		return nil, errors.New("ERR syntax error")
+	}
+
+	cursor, err := ParseCursor(args[0].String())
+	if err != nil {
+		return nil, err
+	}
+
+	sa := &ScanArgs{Cursor: cursor, Count: DefaultScanCount}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errors.New("ERR syntax error")
+		}
+
+		val := args[i+1].String()
+		switch strings.ToLower(args[i].String()) {
+		case "match":
+			sa.Match = val
+		case "count":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, errors.New("ERR value is not an integer or out of range")
+			} else if n < 1 {
+				return nil, errors.New("ERR syntax error")
+			}
+			sa.Count = n
+		case "type":
+			if !allowType {
+				return nil, errors.New("ERR syntax error")
+			}
+			sa.Type = val
+		default:
+			return nil, errors.New("ERR syntax error")
+		}
+	}
+	return sa, nil
+}
+
+// Matches reports whether elem matches the MATCH pattern
+func (a *ScanArgs) Matches(elem string) bool {
+	return a.Match == "" || a.Match == "*" || GlobMatch(a.Match, elem)
+}
+
+// Scan runs a scan step and returns the next cursor with the
+// matching elements, including their values
+func (a *ScanArgs) Scan(s Scanner, filter func(elem string) bool) (uint64, []string) {
+	var res []string
+	next := s.Scan(a.Cursor, a.Count, func(elem string, values ...string) {
+		if !a.Matches(elem) || (filter != nil && !filter(elem)) {
+			return
+		}
+		res = append(res, elem)
+		res = append(res, values...)
+	})
+	return next, res
+}
+
+// Scan returns a SCAN handler for a keyspace. The TYPE option requires
+// the scanner to implement KeyTyper.
+// https://redis.io/commands/scan
+func Scan(s Scanner) Handler {
+	typer, _ := s.(KeyTyper)
+
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() == 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		sa, err := ParseScanArgs(c.Args, true)
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+
+		var filter func(string) bool
+		if sa.Type != "" {
+			if typer == nil {
+				w.AppendError("ERR TYPE filter not supported")
+				return
+			}
+			filter = func(key string) bool { return strings.EqualFold(typer.KeyType(key), sa.Type) }
+		}
+
+		next, elems := sa.Scan(s, filter)
+		appendScan(w, next, elems)
+	})
+}
+
+// Keys returns a KEYS handler for a keyspace, which replies with all keys
+// matching the pattern. As KEYS is a full iteration anyway, the scanner
+// is asked for all keys at once.
+// https://redis.io/commands/keys
+func Keys(s Scanner) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 1 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		sa := &ScanArgs{Match: c.Arg(0).String()}
+		var keys []string
+		for cursor := uint64(0); ; {
+			cursor = s.Scan(cursor, math.MaxInt32, func(key string, _ ...string) {
+				if sa.Matches(key) {
+					keys = append(keys, key)
+				}
+			})
+			if cursor == 0 {
+				break
+			}
+		}
+
+		w.AppendArrayLen(len(keys))
+		for _, key := range keys {
+			w.AppendBulkString(key)
+		}
+	})
+}
+
+// ScanKey returns a handler for SCAN-like commands over the elements of a
+// key, such as SSCAN, HSCAN and ZSCAN. Lookup returns the scanner for the
+// key, or false if the key does not exist.
+// https://redis.io/commands/hscan
+func ScanKey(lookup func(key string) (Scanner, bool)) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() < 2 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		sa, err := ParseScanArgs(c.Args[1:], false)
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+
+		s, ok := lookup(c.Arg(0).String())
+		if !ok {
+			appendScan(w, 0, nil)
+			return
+		}
+
+		next, elems := sa.Scan(s, nil)
+		appendScan(w, next, elems)
+	})
+}
+
+func appendScan(w resp.ResponseWriter, next uint64, elems []string) {
+	w.AppendArrayLen(2)
+	w.AppendBulkString(FormatCursor(next))
+	w.AppendArrayLen(len(elems))
+	for _, elem := range elems {
+		w.AppendBulkString(elem)
+	}
+}
@@ -0,0 +1,135 @@
+package redeo
+
+import (
+	"strconv"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scan", func() {
+	keys := &mockKeyspace{}
+	for i := 0; i < 25; i++ {
+		keys.keys = append(keys.keys, "key:"+strconv.Itoa(i))
+	}
+
+	var call = func(h Handler, name string, args ...string) interface{} {
+		cmd := resp.NewCommand(name)
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		w := redeotest.NewRecorder()
+		h.ServeRedeo(w, cmd)
+		v, _ := w.Response()
+		return v
+	}
+
+	It("should parse arguments", func() {
+		args := func(ss ...string) []resp.CommandArgument {
+			res := make([]resp.CommandArgument, len(ss))
+			for i, s := range ss {
+				res[i] = resp.CommandArgument(s)
+			}
+			return res
+		}
+
+		Expect(ParseScanArgs(args("0"), false)).To(Equal(&ScanArgs{Count: 10}))
+		Expect(ParseScanArgs(args("17", "MATCH", "k*", "count", "5", "TYPE", "hash"), true)).To(Equal(&ScanArgs{
+			Cursor: 17, Match: "k*", Count: 5, Type: "hash",
+		}))
+
+		_, err := ParseScanArgs(args("x"), false)
+		Expect(err).To(Equal(ErrInvalidCursor))
+		_, err = ParseScanArgs(args("0", "TYPE", "hash"), false)
+		Expect(err).To(MatchError("ERR syntax error"))
+		_, err = ParseScanArgs(args("0", "COUNT"), false)
+		Expect(err).To(MatchError("ERR syntax error"))
+		_, err = ParseScanArgs(args("0", "COUNT", "0"), false)
+		Expect(err).To(MatchError("ERR syntax error"))
+		_, err = ParseScanArgs(args("0", "COUNT", "x"), false)
+		Expect(err).To(MatchError("ERR value is not an integer or out of range"))
+	})
+
+	It("should scan slices", func() {
+		var seen []string
+		fn := func(elem string, _ ...string) { seen = append(seen, elem) }
+
+		Expect(ScanSlice(keys.keys, 0, 10, fn)).To(Equal(uint64(10)))
+		Expect(ScanSlice(keys.keys, 10, 10, fn)).To(Equal(uint64(20)))
+		Expect(ScanSlice(keys.keys, 20, 10, fn)).To(Equal(uint64(0)))
+		Expect(seen).To(Equal(keys.keys))
+		Expect(ScanSlice(keys.keys, 99, 10, fn)).To(Equal(uint64(0)))
+		Expect(seen).To(HaveLen(25))
+	})
+
+	It("should serve SCAN", func() {
+		subject := Scan(keys)
+
+		Expect(call(subject, "SCAN", "0")).To(Equal([]interface{}{"10", []interface{}{
+			"key:0", "key:1", "key:2", "key:3", "key:4", "key:5", "key:6", "key:7", "key:8", "key:9",
+		}}))
+		Expect(call(subject, "SCAN", "20", "MATCH", "key:2?")).To(Equal([]interface{}{"0", []interface{}{
+			"key:20", "key:21", "key:22", "key:23", "key:24",
+		}}))
+		Expect(call(subject, "SCAN", "0", "COUNT", "30", "MATCH", "*:1[0-2]")).To(Equal([]interface{}{"0", []interface{}{
+			"key:10", "key:11", "key:12",
+		}}))
+		Expect(call(subject, "SCAN", "0", "COUNT", "4", "TYPE", "HASH")).To(Equal([]interface{}{"4", []interface{}{
+			"key:0", "key:2",
+		}}))
+
+		Expect(call(subject, "SCAN")).To(MatchError("ERR wrong number of arguments for 'SCAN' command"))
+		Expect(call(subject, "SCAN", "-1")).To(MatchError("ERR invalid cursor"))
+		Expect(call(Scan(ScannerFunc(keys.Scan)), "SCAN", "0", "TYPE", "hash")).To(MatchError("ERR TYPE filter not supported"))
+	})
+
+	It("should serve KEYS", func() {
+		subject := Keys(keys)
+
+		Expect(call(subject, "KEYS", "key:1?")).To(Equal([]interface{}{
+			"key:10", "key:11", "key:12", "key:13", "key:14", "key:15", "key:16", "key:17", "key:18", "key:19",
+		}))
+		Expect(call(subject, "KEYS", "x*")).To(Equal([]interface{}{}))
+		Expect(call(subject, "KEYS")).To(MatchError("ERR wrong number of arguments for 'KEYS' command"))
+
+		paged := Keys(ScannerFunc(func(cursor uint64, _ int, fn func(string, ...string)) uint64 {
+			return ScanSlice(keys.keys, cursor, 10, fn)
+		}))
+		Expect(call(paged, "KEYS", "*")).To(HaveLen(25))
+	})
+
+	It("should serve HSCAN", func() {
+		subject := ScanKey(func(key string) (Scanner, bool) {
+			if key != "hash" {
+				return nil, false
+			}
+			return ScannerFunc(func(cursor uint64, count int, fn func(string, ...string)) uint64 {
+				fn("a", "1")
+				fn("b", "2")
+				return 0
+			}), true
+		})
+
+		Expect(call(subject, "HSCAN", "hash", "0")).To(Equal([]interface{}{"0", []interface{}{"a", "1", "b", "2"}}))
+		Expect(call(subject, "HSCAN", "hash", "0", "MATCH", "b")).To(Equal([]interface{}{"0", []interface{}{"b", "2"}}))
+		Expect(call(subject, "HSCAN", "missing", "0")).To(Equal([]interface{}{"0", []interface{}{}}))
+		Expect(call(subject, "HSCAN", "hash", "0", "TYPE", "x")).To(MatchError("ERR syntax error"))
+		Expect(call(subject, "HSCAN", "hash")).To(MatchError("ERR wrong number of arguments for 'HSCAN' command"))
+	})
+
+})
+
+type mockKeyspace struct{ keys []string }
+
+func (m *mockKeyspace) Scan(cursor uint64, count int, fn func(string, ...string)) uint64 {
+	return ScanSlice(m.keys, cursor, count, fn)
+}
+
+func (m *mockKeyspace) KeyType(key string) string {
+	if n, _ := strconv.Atoi(key[4:]); n%2 == 0 {
+		return "hash"
+	}
+	return "string"
+}
@@ -0,0 +1,268 @@
+package redeo
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// SentinelMaster describes a master monitored by a Sentinel
+type SentinelMaster struct {
+	// Name identifies the master
+	Name string
+
+	// Host and Port identify the master's address
+	Host string
+	Port int
+
+	// Quorum is the number of sentinels required to agree on failures.
+	// Default: 1
+	Quorum int
+
+	// Down marks the master as unavailable
+	Down bool
+
+	// Replicas lists the master's replicas
+	Replicas []ReplicaInfo
+}
+
+// Sentinel emulates the sentinel API used by clients to discover masters,
+// it does not monitor masters or perform failovers itself.
+// Sentinels implement ReplicationProvider.
+type Sentinel struct {
+	id      string
+	masters map[string]SentinelMaster
+	mu      sync.RWMutex
+}
+
+// NewSentinel inits a new sentinel
+func NewSentinel() *Sentinel {
+	return &Sentinel{
+		id:      newReplID(),
+		masters: make(map[string]SentinelMaster),
+	}
+}
+
+// ID returns the sentinel's run ID
+func (s *Sentinel) ID() string { return s.id }
+
+// Monitor adds or updates a master, e.g. after a failover
+func (s *Sentinel) Monitor(m SentinelMaster) {
+	if m.Quorum < 1 {
+		m.Quorum = 1
+	}
+
+	s.mu.Lock()
+	s.masters[m.Name] = m
+	s.mu.Unlock()
+}
+
+// Remove removes a master
+func (s *Sentinel) Remove(name string) {
+	s.mu.Lock()
+	delete(s.masters, name)
+	s.mu.Unlock()
+}
+
+// Masters returns all masters, sorted by name
+func (s *Sentinel) Masters() []SentinelMaster {
+	s.mu.RLock()
+	res := make([]SentinelMaster, 0, len(s.masters))
+	for _, m := range s.masters {
+		res = append(res, m)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// ReplicationInfo implements ReplicationProvider
+func (s *Sentinel) ReplicationInfo() *ReplicationInfo {
+	ri := &ReplicationInfo{Role: RoleSentinel}
+	for _, m := range s.Masters() {
+		ri.Masters = append(ri.Masters, m.Name)
+	}
+	return ri
+}
+
+// Handler returns a SENTINEL handler, which supports the
+// GET-MASTER-ADDR-BY-NAME, MASTERS, MASTER, REPLICAS (SLAVES), SENTINELS,
+// MYID and IS-MASTER-DOWN-BY-ADDR sub-commands.
+// https://redis.io/topics/sentinel
+func (s *Sentinel) Handler() Handler {
+	replicas := HandlerFunc(s.serveReplicas)
+	return SubCommands{
+		"get-master-addr-by-name": HandlerFunc(s.serveMasterAddr),
+		"masters":                 HandlerFunc(s.serveMasters),
+		"master":                  HandlerFunc(s.serveMaster),
+		"replicas":                replicas,
+		"slaves":                  replicas,
+		"sentinels":               HandlerFunc(s.serveSentinels),
+		"myid":                    HandlerFunc(s.serveMyID),
+		"is-master-down-by-addr":  HandlerFunc(s.serveIsMasterDown),
+	}
+}
+
+func (s *Sentinel) lookup(name string) (SentinelMaster, bool) {
+	s.mu.RLock()
+	m, ok := s.masters[name]
+	s.mu.RUnlock()
+	return m, ok
+}
+
+func (s *Sentinel) serveMasterAddr(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	m, ok := s.lookup(c.Arg(0).String())
+	if !ok {
+		w.AppendNil()
+		return
+	}
+
+	w.AppendArrayLen(2)
+	w.AppendBulkString(m.Host)
+	w.AppendBulkString(strconv.Itoa(m.Port))
+}
+
+func (s *Sentinel) serveMasters(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 0 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	masters := s.Masters()
+	w.AppendArrayLen(len(masters))
+	for _, m := range masters {
+		appendSentinelMaster(w, m)
+	}
+}
+
+func (s *Sentinel) serveMaster(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	m, ok := s.lookup(c.Arg(0).String())
+	if !ok {
+		w.AppendError("ERR No such master with that name")
+		return
+	}
+	appendSentinelMaster(w, m)
+}
+
+func (s *Sentinel) serveReplicas(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	m, ok := s.lookup(c.Arg(0).String())
+	if !ok {
+		w.AppendError("ERR No such master with that name")
+		return
+	}
+
+	w.AppendArrayLen(len(m.Replicas))
+	for _, r := range m.Replicas {
+		flags := "slave"
+		if r.State != "online" {
+			flags += ",s_down"
+		}
+
+		addr := r.Host + ":" + strconv.Itoa(r.Port)
+		appendFields(w,
+			"name", addr,
+			"ip", r.Host,
+			"port", strconv.Itoa(r.Port),
+			"flags", flags,
+			"master-host", m.Host,
+			"master-port", strconv.Itoa(m.Port),
+			"slave-repl-offset", strconv.FormatInt(r.Offset, 10),
+		)
+	}
+}
+
+func (s *Sentinel) serveSentinels(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	if _, ok := s.lookup(c.Arg(0).String()); !ok {
+		w.AppendError("ERR No such master with that name")
+		return
+	}
+	w.AppendArrayLen(0)
+}
+
+func (s *Sentinel) serveMyID(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 0 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+	w.AppendBulkString(s.id)
+}
+
+// serveIsMasterDown answers the is-master-down-by-addr ip port epoch runid
+// handshake. The sentinel never votes for failover leaders.
+func (s *Sentinel) serveIsMasterDown(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 4 {
+		w.AppendError(WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	host := c.Arg(0).String()
+	port, err := strconv.Atoi(c.Arg(1).String())
+	if err != nil {
+		w.AppendError("ERR value is not an integer or out of range")
+		return
+	}
+	if _, err := strconv.ParseInt(c.Arg(2).String(), 10, 64); err != nil {
+		w.AppendError("ERR value is not an integer or out of range")
+		return
+	}
+
+	var down int64
+	for _, m := range s.Masters() {
+		if m.Host == host && m.Port == port && m.Down {
+			down = 1
+		}
+	}
+
+	w.AppendArrayLen(3)
+	w.AppendInt(down)
+	w.AppendBulkString("*")
+	w.AppendInt(0)
+}
+
+func appendSentinelMaster(w resp.ResponseWriter, m SentinelMaster) {
+	flags := "master"
+	if m.Down {
+		flags += ",s_down,o_down"
+	}
+
+	appendFields(w,
+		"name", m.Name,
+		"ip", m.Host,
+		"port", strconv.Itoa(m.Port),
+		"flags", flags,
+		"num-slaves", strconv.Itoa(len(m.Replicas)),
+		"num-other-sentinels", "0",
+		"quorum", strconv.Itoa(m.Quorum),
+	)
+}
+
+// appendFields appends a flat array of field-value pairs
+func appendFields(w resp.ResponseWriter, kv ...string) {
+	w.AppendArrayLen(len(kv))
+	for _, s := range kv {
+		w.AppendBulkString(s)
+	}
+}
@@ -0,0 +1,79 @@
+package redeo
+
+import (
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sentinel", func() {
+	var subject *Sentinel
+
+	var call = func(args ...string) interface{} {
+		cmd := resp.NewCommand("SENTINEL")
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		w := redeotest.NewRecorder()
+		subject.Handler().ServeRedeo(w, cmd)
+		v, _ := w.Response()
+		return v
+	}
+
+	BeforeEach(func() {
+		subject = NewSentinel()
+		subject.Monitor(SentinelMaster{Name: "mymaster", Host: "10.0.0.1", Port: 6379, Quorum: 2, Replicas: []ReplicaInfo{
+			{Host: "10.0.0.2", Port: 6379, State: "online", Offset: 100},
+		}})
+		subject.Monitor(SentinelMaster{Name: "other", Host: "10.0.0.3", Port: 6380, Down: true})
+	})
+
+	It("should resolve masters", func() {
+		Expect(call("get-master-addr-by-name", "mymaster")).To(Equal([]interface{}{"10.0.0.1", "6379"}))
+		Expect(call("GET-MASTER-ADDR-BY-NAME", "unknown")).To(BeNil())
+		Expect(call("get-master-addr-by-name")).To(MatchError("ERR wrong number of arguments for 'SENTINEL get-master-addr-by-name' command"))
+
+		subject.Monitor(SentinelMaster{Name: "mymaster", Host: "10.0.0.2", Port: 6379})
+		Expect(call("get-master-addr-by-name", "mymaster")).To(Equal([]interface{}{"10.0.0.2", "6379"}))
+
+		subject.Remove("mymaster")
+		Expect(call("get-master-addr-by-name", "mymaster")).To(BeNil())
+	})
+
+	It("should list masters", func() {
+		Expect(call("masters")).To(Equal([]interface{}{
+			[]interface{}{"name", "mymaster", "ip", "10.0.0.1", "port", "6379", "flags", "master", "num-slaves", "1", "num-other-sentinels", "0", "quorum", "2"},
+			[]interface{}{"name", "other", "ip", "10.0.0.3", "port", "6380", "flags", "master,s_down,o_down", "num-slaves", "0", "num-other-sentinels", "0", "quorum", "1"},
+		}))
+		Expect(call("master", "other")).To(Equal(
+			[]interface{}{"name", "other", "ip", "10.0.0.3", "port", "6380", "flags", "master,s_down,o_down", "num-slaves", "0", "num-other-sentinels", "0", "quorum", "1"},
+		))
+		Expect(call("master", "unknown")).To(MatchError("ERR No such master with that name"))
+	})
+
+	It("should list replicas and sentinels", func() {
+		Expect(call("replicas", "mymaster")).To(Equal([]interface{}{
+			[]interface{}{"name", "10.0.0.2:6379", "ip", "10.0.0.2", "port", "6379", "flags", "slave", "master-host", "10.0.0.1", "master-port", "6379", "slave-repl-offset", "100"},
+		}))
+		Expect(call("slaves", "other")).To(Equal([]interface{}{}))
+		Expect(call("sentinels", "mymaster")).To(Equal([]interface{}{}))
+		Expect(call("sentinels", "unknown")).To(MatchError("ERR No such master with that name"))
+		Expect(call("myid")).To(Equal(subject.ID()))
+	})
+
+	It("should answer is-master-down-by-addr", func() {
+		Expect(call("is-master-down-by-addr", "10.0.0.1", "6379", "0", "*")).To(Equal([]interface{}{int64(0), "*", int64(0)}))
+		Expect(call("is-master-down-by-addr", "10.0.0.3", "6380", "1", "abcd")).To(Equal([]interface{}{int64(1), "*", int64(0)}))
+		Expect(call("is-master-down-by-addr", "10.0.0.3", "x", "1", "*")).To(MatchError("ERR value is not an integer or out of range"))
+		Expect(call("bad")).To(MatchError("ERR Unknown sentinel subcommand 'bad'"))
+	})
+
+	It("should report its role", func() {
+		Expect(subject.ReplicationInfo()).To(Equal(&ReplicationInfo{
+			Role:    RoleSentinel,
+			Masters: []string{"mymaster", "other"},
+		}))
+	})
+
+})
@@ -1,21 +1,65 @@
 package redeo
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"net"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/johntech-o/redeo/resp"
 )
 
+var (
+	// ErrServerClosed is returned by Serve and ServeTLS after a call to Shutdown.
+	ErrServerClosed = errors.New("redeo: server closed")
+
+	// ErrDropConn can be returned by Config.OnAccept to close connections
+	// without sending a reply.
+	ErrDropConn = errors.New("redeo: connection dropped")
+)
+
 // Server configuration
 type Server struct {
-	config *Config
+	config atomic.Value // *serverConfig
 	info   *ServerInfo
 
-	cmds map[string]interface{}
-	mu   sync.RWMutex
+	cmds   map[string]interface{}
+	writes map[string]struct{}
+	mu     sync.RWMutex
+
+	readOnly int32
+	redirect atomic.Value
+
+	props   []Propagator
+	wmu     sync.Mutex
+	cluster *Cluster
+
+	// deferred holds the rewrites of commands recorded while they
+	// waited, see Wait
+	deferred []*resp.Command
+	dmu      sync.Mutex
+
+	before, after []func(*CommandEvent)
+	onError       func(ErrorContext)
+	disconnect    []ClientResetter
+
+	listeners map[net.Listener]struct{}
+	clients   map[*Client]struct{}
+	closing   int32
+	wg        sync.WaitGroup
+	lmu       sync.Mutex
+
+	poll *poller
 }
 
 // NewServer creates a new server instance
@@ -24,21 +68,34 @@ func NewServer(config *Config) *Server {
 		config = new(Config)
 	}
 
-	return &Server{
-		config: config,
-		info:   newServerInfo(),
-		cmds:   make(map[string]interface{}),
+	srv := &Server{
+		info:      newServerInfo(clockOrDefault(config.Clock)),
+		cmds:      make(map[string]interface{}),
+		writes:    make(map[string]struct{}),
+		listeners: make(map[net.Listener]struct{}),
+		clients:   make(map[*Client]struct{}),
 	}
+	srv.config.Store(newServerConfig(config))
+	if config.Version != "" {
+		srv.info.version.Set(config.Version)
+	}
+	srv.SetReadOnly(config.ReadOnly)
+	srv.setRedirect("")
+	return srv
 }
 
 // Info returns the server info registry
 func (srv *Server) Info() *ServerInfo { return srv.info }
 
+// RunID returns the run ID, see ServerInfo.RunID
+func (srv *Server) RunID() string { return srv.info.RunID() }
+
+// conf returns the current configuration
+func (srv *Server) conf() *serverConfig { return srv.config.Load().(*serverConfig) }
+
 // Handle registers a handler for a command.
 func (srv *Server) Handle(name string, h Handler) {
-	srv.mu.Lock()
-	srv.cmds[strings.ToLower(name)] = h
-	srv.mu.Unlock()
+	srv.handle(name, h, false)
 }
 
 // HandleFunc registers a handler func for a command.
@@ -46,11 +103,49 @@ func (srv *Server) HandleFunc(name string, fn HandlerFunc) {
 	srv.Handle(name, fn)
 }
 
+// HandleWrite registers a handler for a command which modifies data.
+// Write commands are rejected while the server is read-only and passed to
+// attached propagators once executed, see AddPropagator.
+func (srv *Server) HandleWrite(name string, h Handler) {
+	srv.handle(name, h, true)
+}
+
+// HandleWriteFunc registers a handler func for a command which modifies
+// data.
+func (srv *Server) HandleWriteFunc(name string, fn HandlerFunc) {
+	srv.HandleWrite(name, fn)
+}
+
+// SetReadOnly toggles read-only mode, e.g. for replicas or during
+// maintenance windows. While read-only, commands registered via HandleWrite
+// are rejected with a READONLY error, unless the client has issued
+// READWRITE.
+func (srv *Server) SetReadOnly(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&srv.readOnly, v)
+}
+
+// ReadOnly returns true if the server is read-only
+func (srv *Server) ReadOnly() bool { return atomic.LoadInt32(&srv.readOnly) == 1 }
+
+// IsWrite returns true if the command was registered via HandleWrite
+func (srv *Server) IsWrite(name string) bool {
+	srv.mu.RLock()
+	_, ok := srv.writes[resp.CanonicalName(name)]
+	srv.mu.RUnlock()
+	return ok
+}
+
+// setRedirect sets the address that write commands rejected in
+// read-only mode are redirected to via MOVED errors
+func (srv *Server) setRedirect(addr string) { srv.redirect.Store(addr) }
+
 // HandleStream registers a handler for a streaming command.
 func (srv *Server) HandleStream(name string, h StreamHandler) {
-	srv.mu.Lock()
-	srv.cmds[strings.ToLower(name)] = h
-	srv.mu.Unlock()
+	srv.handle(name, h, false)
 }
 
 // HandleStreamFunc registers a handler func for a command
@@ -58,87 +153,663 @@ func (srv *Server) HandleStreamFunc(name string, fn StreamHandlerFunc) {
 	srv.HandleStream(name, fn)
 }
 
+func (srv *Server) handle(name string, h interface{}, write bool) {
+	name = resp.CanonicalName(name)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.cmds[name] = h
+	if write {
+		srv.writes[name] = struct{}{}
+	} else {
+		delete(srv.writes, name)
+	}
+}
+
 // Serve accepts incoming connections on a listener, creating a
 // new service goroutine for each.
 func (srv *Server) Serve(lis net.Listener) error {
+	return srv.serve(lis, nil)
+}
+
+// ServeTLS accepts incoming connections on a listener and serves them
+// over TLS using the given config, creating a new service goroutine
+// for each. Client certificates can be requested and verified by setting
+// the config's ClientAuth mode, see also Config.TLSAuth.
+func (srv *Server) ServeTLS(lis net.Listener, config *tls.Config) error {
+	return srv.serve(lis, func(cn net.Conn) net.Conn {
+		return tls.Server(cn, config)
+	})
+}
+
+// Shutdown gracefully shuts down the server. It closes all listeners, lets
+// clients complete the commands they are currently executing and then
+// disconnects them. Clients waiting for their next request are woken up
+// immediately rather than after a read timeout. If ctx expires before all
+// clients are disconnected, the remaining connections are closed forcefully
+// and the context's error is returned.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.lmu.Lock()
+	atomic.StoreInt32(&srv.closing, 1)
+	for lis := range srv.listeners {
+		_ = lis.Close()
+		delete(srv.listeners, lis)
+	}
+	for c := range srv.clients {
+		_ = c.cn.SetReadDeadline(time.Now())
+		if c.poll != nil {
+			c.poll.wake(c)
+		}
+	}
+	srv.lmu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		srv.closePoller()
+		return nil
+	case <-ctx.Done():
+		srv.lmu.Lock()
+		for c := range srv.clients {
+			c.kill()
+		}
+		srv.lmu.Unlock()
+		srv.closePoller()
+		return ctx.Err()
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves incoming
+// connections. See Config.Acceptors for parallel accept loops.
+func (srv *Server) ListenAndServe(addr string) error {
+	if err := srv.conf().Validate(); err != nil {
+		return err
+	}
+
+	lis, err := ListenReusePort("tcp", addr, srv.conf().Acceptors)
+	if err != nil {
+		return err
+	}
+	return srv.serveAll(lis)
+}
+
+// ListenReusePort opens n listening sockets on the same address, using
+// SO_REUSEPORT if n is greater than one. If addr does not specify a port,
+// all sockets share the port chosen for the first one. Only supported on
+// Linux.
+func ListenReusePort(network, addr string, n int) ([]net.Listener, error) {
+	if n < 2 {
+		lis, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{lis}, nil
+	}
+
+	lis := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := listenReusePort(network, addr)
+		if err != nil {
+			for _, l := range lis {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			addr = l.Addr().String()
+		}
+		lis = append(lis, l)
+	}
+	return lis, nil
+}
+
+// serveAll serves multiple listeners in parallel, returns the first
+// error and closes all remaining listeners
+func (srv *Server) serveAll(lis []net.Listener) error {
+	if len(lis) == 1 {
+		return srv.Serve(lis[0])
+	}
+
+	errs := make(chan error, len(lis))
+	for _, l := range lis {
+		go func(l net.Listener) { errs <- srv.Serve(l) }(l)
+	}
+
+	err := <-errs
+	for _, l := range lis {
+		_ = l.Close()
+	}
+	for i := 1; i < len(lis); i++ {
+		<-errs
+	}
+	return err
+}
+
+func (srv *Server) serve(lis net.Listener, wrap func(net.Conn) net.Conn) error {
+	if err := srv.conf().Validate(); err != nil {
+		return err
+	}
+	if err := srv.startPoller(); err != nil {
+		return err
+	}
+	if !srv.trackListener(lis) {
+		return ErrServerClosed
+	}
+
 	for {
 		cn, err := lis.Accept()
 		if err != nil {
+			if srv.isClosing() {
+				return ErrServerClosed
+			}
 			return err
 		}
 
-		if ka := srv.config.TCPKeepAlive; ka > 0 {
+		if ka := srv.conf().TCPKeepAlive; ka > 0 {
 			if tc, ok := cn.(*net.TCPConn); ok {
 				tc.SetKeepAlive(true)
 				tc.SetKeepAlivePeriod(ka)
 			}
 		}
 
-		go srv.serveClient(newClient(cn))
+		if wrap != nil {
+			cn = wrap(cn)
+		}
+
+		if !srv.serveConn(cn) {
+			return ErrServerClosed
+		}
+	}
+}
+
+// ServeConn serves a connection which has been accepted elsewhere, e.g. by
+// a protocol multiplexer, in a new service goroutine. Buffered contains
+// data which has already been read from the connection and is consumed
+// first.
+func (srv *Server) ServeConn(cn net.Conn, buffered []byte) error {
+	if err := srv.startPoller(); err != nil {
+		_ = cn.Close()
+		return err
+	}
+
+	if len(buffered) != 0 {
+		cn = &bufferedConn{Conn: cn, buf: append([]byte(nil), buffered...)}
+	}
+	if !srv.serveConn(cn) {
+		return ErrServerClosed
+	}
+	return nil
+}
+
+// serveConn starts serving a connection, returns false if
+// the server is shutting down
+func (srv *Server) serveConn(cn net.Conn) bool {
+	conf := srv.conf()
+	c := newClientSize(cn, srv.info.clock, bufferSize(conf.ReadBufferSize), bufferSize(conf.WriteBufferSize))
+	c.rdMax = conf.MaxReadBufferSize
+	c.rd.SetMaxBufferSize(c.rdMax)
+	if conf.RecordDir != "" {
+		srv.recordSession(c, conf.RecordDir)
+	}
+	if !srv.trackClient(c) {
+		c.release()
+		return false
+	}
+	go srv.serveClient(c)
+	return true
+}
+
+// recordSession starts recording the data received from the client
+func (srv *Server) recordSession(c *Client, dir string) {
+	onError := func(err error) { srv.reportError(ErrorRecord, c, err) }
+	rc, err := newRecordingConn(c.cn, dir, c.id, c.clock, onError)
+	if err != nil {
+		onError(err)
+		return
+	}
+	c.cn = rc
+}
+
+// bufferedConn replays data which has been read ahead
+type bufferedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if len(c.buf) != 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// startPoller starts the event loop on first use, if enabled
+func (srv *Server) startPoller() error {
+	if !srv.conf().EventLoop {
+		return nil
+	}
+
+	srv.lmu.Lock()
+	defer srv.lmu.Unlock()
+
+	if srv.poll != nil || srv.isClosing() {
+		return nil
+	}
+
+	poll, err := newPoller(srv.resumeClient)
+	if err != nil {
+		return err
+	}
+	srv.poll = poll
+	return nil
+}
+
+func (srv *Server) closePoller() {
+	srv.lmu.Lock()
+	poll := srv.poll
+	srv.lmu.Unlock()
+
+	if poll != nil {
+		poll.close()
+	}
+}
+
+// Apply executes a command without a client connection, e.g. to replay a
+// replication stream. Read-only mode does not apply. Replies are
+// discarded, error replies are returned as errors.
+func (srv *Server) Apply(cmd *resp.Command) error {
+	srv.mu.RLock()
+	h, ok := srv.cmds[cmd.CanonicalName()]
+	srv.mu.RUnlock()
+
+	if !ok {
+		return ErrUnknownCommand(cmd.Name)
+	}
+	srv.info.commands.Inc(1)
+
+	f := fetchFrame()
+	defer framePool.Put(f)
+
+	switch handler := h.(type) {
+	case Handler:
+		handler.ServeRedeo(f.wr, cmd)
+
+	case StreamHandler:
+		// re-encode the command, for streaming
+		var buf bytes.Buffer
+		w := resp.NewRequestWriter(&buf)
+		_ = w.WriteMultiBulkSize(len(cmd.Args) + 1)
+		w.WriteBulkString(cmd.Name)
+		for _, arg := range cmd.Args {
+			w.WriteBulk(arg)
+		}
+		_ = w.Flush()
+
+		scmd, err := resp.NewRequestReaderSize(&buf, resp.MinBufferSize).StreamCmd(nil)
+		if err != nil {
+			return err
+		}
+		scmd.SetContext(cmd.Context())
+		handler.ServeRedeoStream(f.wr, scmd)
+		_ = scmd.Discard()
+	}
+
+	if err := f.wr.Flush(); err != nil {
+		return err
+	}
+	if b := f.buf.Bytes(); len(b) != 0 && b[0] == '-' {
+		if i := bytes.IndexByte(b, '\r'); i > -1 {
+			b = b[:i]
+		}
+		return errors.New(string(b[1:]))
 	}
+	return nil
+}
+
+// bufferSize normalises configured buffer sizes
+func bufferSize(n int) int {
+	if n < 1 || n > resp.MaxBufferSize {
+		return resp.MaxBufferSize
+	} else if n < resp.MinBufferSize {
+		return resp.MinBufferSize
+	}
+	return n
+}
+
+func (srv *Server) isClosing() bool {
+	return atomic.LoadInt32(&srv.closing) == 1
+}
+
+func (srv *Server) trackListener(lis net.Listener) bool {
+	srv.lmu.Lock()
+	defer srv.lmu.Unlock()
+
+	if srv.isClosing() {
+		return false
+	}
+	srv.listeners[lis] = struct{}{}
+	return true
+}
+
+func (srv *Server) trackClient(c *Client) bool {
+	srv.lmu.Lock()
+	defer srv.lmu.Unlock()
+
+	if srv.isClosing() {
+		return false
+	}
+	srv.clients[c] = struct{}{}
+	srv.wg.Add(1)
+	return true
+}
+
+func (srv *Server) untrackClient(c *Client) {
+	srv.lmu.Lock()
+	delete(srv.clients, c)
+	srv.lmu.Unlock()
+	srv.wg.Done()
 }
 
 // Starts a new session, serving client
 func (srv *Server) serveClient(c *Client) {
-	// Release client on exit
-	defer c.release()
+	// Apply accept hook
+	if fn := srv.conf().OnAccept; fn != nil {
+		if err := fn(c.cn); err == ErrDropConn {
+			srv.releaseClient(c)
+			return
+		} else if err != nil {
+			c.wr.AppendError(errorReply(err))
+			_ = c.wr.Flush()
+			srv.releaseClient(c)
+			return
+		}
+	}
+
+	// Complete TLS handshake, authenticate
+	if !srv.handshake(c) {
+		srv.releaseClient(c)
+		return
+	}
 
 	// Register client
 	srv.info.register(c)
-	defer srv.info.deregister(c.id)
 
-	// Create perform callback
-	perform := func(name string) error {
-		return srv.perform(c, name)
+	// Hand idle client over to the event loop
+	if srv.poll != nil && srv.poll.add(c) {
+		srv.parkClient(c)
+		return
 	}
 
-	// Init request/response loop
-	for !c.closed {
-		// set deadline
-		if d := srv.config.Timeout; d > 0 {
-			c.cn.SetDeadline(time.Now().Add(d))
+	// Release client on exit
+	defer srv.closeClient(c)
+
+	// Init request/response loop, yield when the
+	// command budget of a pipeline is spent
+	for !c.closed && srv.serveOnce(c) {
+		if c.rd.Buffered() != 0 {
+			runtime.Gosched()
 		}
+	}
+}
 
-		// perform pipeline
-		if err := c.pipeline(perform); err != nil {
-			c.wr.AppendError("ERR " + err.Error())
+// Resumes a client parked by the event loop
+func (srv *Server) resumeClient(c *Client) {
+	c.acquireBuffers()
 
-			if !resp.IsProtocolError(err) {
-				_ = c.wr.Flush()
-				return
-			}
+	// serve until all buffered requests are processed,
+	// clients may have been closed by their own commands
+	for {
+		if c.closed || !srv.serveOnce(c) || c.closed {
+			srv.closeClient(c)
+			return
+		}
+		if c.rd.Buffered() == 0 {
+			break
 		}
+		runtime.Gosched()
+	}
+	srv.parkClient(c)
+}
 
-		// flush buffer, return on errors
-		if err := c.wr.Flush(); err != nil {
-			return
+// Parks an idle client, releasing its buffers
+func (srv *Server) parkClient(c *Client) {
+	c.releaseBuffers()
+
+	if err := srv.poll.park(c); err != nil {
+		c.acquireBuffers()
+		srv.closeClient(c)
+	}
+}
+
+// Deregisters and releases a client
+func (srv *Server) closeClient(c *Client) {
+	srv.info.deregister(c)
+
+	srv.mu.RLock()
+	resetters := srv.disconnect
+	srv.mu.RUnlock()
+	for _, r := range resetters {
+		r.ResetClient(c)
+	}
+	srv.releaseClient(c)
+}
+
+// Releases a client
+func (srv *Server) releaseClient(c *Client) {
+	c.release()
+	srv.untrackClient(c)
+}
+
+// Serves a single pipeline, returns false if the
+// client should be disconnected
+func (srv *Server) serveOnce(c *Client) bool {
+	// set deadline, protected clients may idle
+	timeout := srv.conf().Timeout
+	if timeout > 0 {
+		if c.NoEvict() {
+			c.deadline = time.Time{}
+		} else {
+			c.deadline = time.Now().Add(timeout)
 		}
+		c.cn.SetDeadline(c.deadline)
+	}
+
+	// stop on shutdown, checked after deadlines
+	// are set to avoid overriding the wake-up call
+	if srv.isClosing() {
+		return false
 	}
+
+	// perform pipeline, then limit the time protected
+	// clients may take to receive the replies
+	err := c.pipeline(srv)
+	if timeout > 0 && c.NoEvict() {
+		c.cn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	if err != nil {
+		if srv.isClosing() {
+			_ = c.flush()
+			return false
+		}
+
+		srv.reportError(errorKind(err), c, err)
+		c.wr.AppendError("ERR " + err.Error())
+
+		if !resp.IsProtocolError(err) || srv.conf().CloseOnProtocolError {
+			_ = c.flush()
+			return false
+		}
+	}
+
+	// flush buffer, return on errors
+	if err := c.flush(); err != nil {
+		srv.reportError(ErrorWrite, c, err)
+		return false
+	}
+	return true
+}
+
+// Completes TLS handshakes and applies Config.TLSAuth, returns false if
+// the client should be disconnected
+func (srv *Server) handshake(c *Client) bool {
+	tc, ok := c.tlsConn()
+	if !ok {
+		return true
+	}
+
+	if d := srv.conf().Timeout; d > 0 {
+		tc.SetDeadline(time.Now().Add(d))
+	}
+
+	// check after the deadline is set, so a concurrent
+	// shutdown's wake-up call cannot be overridden
+	if srv.isClosing() {
+		return false
+	}
+	if err := tc.Handshake(); err != nil {
+		srv.reportError(ErrorHandshake, c, err)
+		return false
+	}
+
+	if fn := srv.conf().TLSAuth; fn != nil {
+		var cert *x509.Certificate
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) != 0 {
+			cert = certs[0]
+		}
+
+		if err := fn(cert); err != nil {
+			c.wr.AppendError(errorReply(err))
+			_ = c.wr.Flush()
+			return false
+		}
+	}
+	return true
+}
+
+// errorReply converts an error returned by a callback into an error reply.
+// Messages starting with an upper-case error code, e.g. "NOAUTH ...", are
+// kept as they are, all others are prefixed with "ERR".
+func errorReply(err error) string {
+	msg := err.Error()
+
+	code := msg
+	if i := strings.IndexByte(msg, ' '); i > -1 {
+		code = msg[:i]
+	}
+	if code == "" {
+		return "ERR " + msg
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return "ERR " + msg
+		}
+	}
+
+	// single words are messages, not codes
+	if len(code) == len(msg) {
+		return "ERR " + msg
+	}
+	return msg
 }
 
 func (srv *Server) perform(c *Client, name string) (err error) {
-	norm := strings.ToLower(name)
+	c.begin()
+	norm := resp.CanonicalName(name)
 
 	// find handler
 	srv.mu.RLock()
 	h, ok := srv.cmds[norm]
+	_, write := srv.writes[norm]
+	props, cl := srv.props, srv.cluster
+	before, after := srv.before, srv.after
+	hooks := len(before)+len(after) != 0
 	srv.mu.RUnlock()
 
 	if !ok {
+		srv.info.errors.UnknownCommand(norm)
+		c.rejected++
 		c.wr.AppendError(UnknownCommand(name))
 		_ = c.rd.SkipCmd()
 		return
 	}
+	if srv.ReadOnly() && !c.ReadWrite() && write {
+		if addr := srv.redirect.Load().(string); addr != "" {
+			if c.cmd, err = c.readCmd(c.cmd); err != nil {
+				return
+			}
+
+			var slot uint16
+			if c.cmd.ArgN() != 0 {
+				slot = KeySlot(c.cmd.Arg(0).String())
+			}
+			c.rejected++
+			c.wr.AppendError("MOVED " + strconv.Itoa(int(slot)) + " " + addr)
+			c.endCmd()
+			return
+		}
+
+		c.rejected++
+		c.wr.AppendError(ReadOnlyError)
+		_ = c.rd.SkipCmd()
+		return
+	}
 
 	// register call
-	srv.info.command(c.id, norm)
+	srv.info.command(c, norm)
+
+	var asking bool
+	if cl != nil {
+		asking = cl.begin(&c.cluster, norm)
+	}
 
+	// apply command timeout overrides
+	if timeout, ok := srv.conf().timeouts[norm]; ok && !c.NoEvict() {
+		c.deadline = time.Time{}
+		if timeout > 0 {
+			c.deadline = time.Now().Add(timeout)
+		}
+		c.cn.SetDeadline(c.deadline)
+	}
+
+	var ev *CommandEvent
+	c.aw.reset(c.wr)
+	lim, _ := c.wr.(resp.ReplyLimiter)
+	if lim != nil {
+		lim.BeginReply(int64(srv.conf().MaxReplySize))
+	}
 	switch handler := h.(type) {
 	case Handler:
 		if c.cmd, err = c.readCmd(c.cmd); err != nil {
 			return
 		}
-		handler.ServeRedeo(c.wr, c.cmd)
+		if cl != nil {
+			if msg := cl.redirect(&c.cluster, asking, norm, c.cmd); msg != "" {
+				c.rejected++
+				c.wr.AppendError(msg)
+				break
+			}
+		}
+		if hooks {
+			ev = beginCommand(c, c.cmd, norm, before)
+		}
+
+		if srv.conf().ProfilerLabels {
+			pprof.Do(c.cmd.Context(), pprof.Labels("command", norm), func(ctx context.Context) {
+				c.cmd.SetContext(ctx)
+				srv.serveCmd(handler, props, write, c, &c.aw)
+			})
+		} else {
+			srv.serveCmd(handler, props, write, c, &c.aw)
+		}
 
 	case StreamHandler:
 		if c.scmd, err = c.streamCmd(c.scmd); err != nil {
@@ -146,12 +817,92 @@ func (srv *Server) perform(c *Client, name string) (err error) {
 		}
 		defer c.scmd.Discard()
 
-		handler.ServeRedeoStream(c.wr, c.scmd)
+		if hooks {
+			ev = beginCommand(c, nil, norm, before)
+		}
+		if srv.conf().ProfilerLabels {
+			pprof.Do(c.scmd.Context(), pprof.Labels("command", norm), func(ctx context.Context) {
+				c.scmd.SetContext(ctx)
+				handler.ServeRedeoStream(&c.aw, c.scmd)
+			})
+		} else {
+			handler.ServeRedeoStream(&c.aw, c.scmd)
+		}
+	}
+	c.endCmd()
+	if c.aw.failed != "" {
+		srv.info.errors.ArityError(c.aw.failed)
+	}
+	if lim != nil {
+		err = srv.endReply(c, lim, norm)
 	}
 
 	// flush when buffer is large enough
-	if n := c.wr.Buffered(); n > resp.MaxBufferSize/2 {
+	if n := c.wr.Buffered(); err == nil && n > c.wrSize/2 {
 		err = c.wr.Flush()
 	}
+	if ev != nil {
+		endCommand(ev, err, after)
+	}
 	return
 }
+
+// endReply replaces replies which exceed Config.MaxReplySize with an
+// error, it returns errPartialReply if they cannot be replaced
+func (srv *Server) endReply(c *Client, lim resp.ReplyLimiter, name string) error {
+	size, partial, err := lim.EndReply()
+	if err == nil {
+		return nil
+	}
+	if partial {
+		return errPartialReply
+	}
+
+	srv.reportError(ErrorReply, c, fmt.Errorf("reply to %s of at least %d bytes exceeds MaxReplySize", name, size))
+	c.wr.AppendError(ReplyTooLargeError)
+	return nil
+}
+
+// serveCmd executes a command, write commands are checked, serialised and
+// propagated while propagators are attached, see Rewrite and WriteChecker
+func (srv *Server) serveCmd(h Handler, props []Propagator, write bool, c *Client, w resp.ResponseWriter) {
+	if !write || len(props) == 0 {
+		h.ServeRedeo(w, c.cmd)
+		return
+	}
+	for _, p := range props {
+		if wc, ok := p.(WriteChecker); ok {
+			if msg := wc.CheckWrite(c.cmd); msg != "" {
+				c.rejected++
+				w.AppendError(msg)
+				return
+			}
+		}
+	}
+
+	ctx, prop := WithPropagation(c.cmd.Context())
+	prop.srv = srv
+	c.cmd.SetContext(ctx)
+
+	srv.wmu.Lock()
+	defer srv.wmu.Unlock()
+
+	h.ServeRedeo(w, c.cmd)
+	for _, cmd := range prop.Commands(c.cmd) {
+		for _, p := range props {
+			p.Propagate(cmd)
+		}
+	}
+
+	// propagate the commands served by this one while they waited
+	srv.dmu.Lock()
+	deferred := srv.deferred
+	srv.deferred = nil
+	srv.dmu.Unlock()
+
+	for _, cmd := range deferred {
+		for _, p := range props {
+			p.Propagate(cmd)
+		}
+	}
+}
@@ -1,15 +1,24 @@
 package redeo
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"runtime"
+	"runtime/pprof"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/johntech-o/redeo/redeotest"
 	"github.com/johntech-o/redeo/resp"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -127,6 +136,14 @@ var _ = Describe("Server", func() {
 			Expect(info.TotalCommands()).To(Equal(int64(2)))
 			Expect(info.TotalConnections()).To(Equal(int64(1)))
 			Expect(info.ClientInfo()[0].LastCmd).To(Equal("echo"))
+
+			ci := info.ClientInfo()[0]
+			Expect(ci.Commands).To(Equal(int64(2)))
+			Expect(ci.BytesRead).To(Equal(int64(14 + 10024)))
+			Eventually(func() int64 {
+				return info.ClientInfo()[0].BytesWritten
+			}).Should(Equal(int64(7 + 10010)))
+			Expect(info.String()).To(ContainSubstring("total_net_input_bytes:10038\n"))
 		})
 	})
 
@@ -141,6 +158,55 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	It("should pass extended writers to handlers", func() {
+		subject = NewServer(nil)
+		subject.HandleFunc("ext", func(w resp.ResponseWriter, c *resp.Command) {
+			x, ok := w.(resp.ExtendedResponseWriter)
+			if !ok {
+				w.AppendError("ERR not extended")
+				return
+			}
+			if c.ArgN() != 0 {
+				x.SetProtocol(resp.RESP3)
+			}
+			x.AppendMapLen(1)
+			x.AppendBulkString("ok")
+			x.AppendBool(true)
+		})
+
+		Expect(redeotest.Transcript(subject, "EXT\r\n", "EXT 3\r\n")).To(Equal([]byte(
+			"*2\r\n$2\r\nok\r\n:1\r\n" +
+				"%1\r\n$2\r\nok\r\n#t\r\n",
+		)))
+	})
+
+	It("should count arity errors", func() {
+		subject = NewServer(nil)
+		subject.Handle("wrapped", WrapperFunc(func(c *resp.Command) interface{} {
+			if c.ArgN() != 0 {
+				return ErrWrongNumberOfArgs(c.Name)
+			}
+			return errors.New("wrong number of arguments for 'wrapped' command")
+		}))
+		subject.HandleFunc("echo", echo)
+		subject.HandleFunc("other", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendError(WrongNumberOfArgs("echo") + ", but not really")
+		})
+
+		Expect(redeotest.Transcript(subject,
+			"WRAPPED x\r\n",
+			"WRAPPED\r\n",
+			"ECHO\r\n",
+			"OTHER\r\n",
+		)).To(Equal([]byte(
+			"-ERR wrong number of arguments for 'WRAPPED' command\r\n" +
+				"-ERR wrong number of arguments for 'wrapped' command\r\n" +
+				"-ERR wrong number of arguments for 'ECHO' command\r\n" +
+				"-ERR wrong number of arguments for 'echo' command, but not really\r\n",
+		)))
+		Expect(subject.Info().ArityErrors()).To(Equal(map[string]int64{"wrapped": 2, "echo": 1}))
+	})
+
 	It("should handle pipelines", func() {
 		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
 			cw.WriteCmd("PING")
@@ -164,6 +230,7 @@ var _ = Describe("Server", func() {
 			s, err := cr.ReadError()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(s).To(Equal("ERR unknown command 'nOOp'"))
+			Expect(subject.Info().UnknownCommands()).To(Equal(map[string]int64{"noop": 1}))
 
 			// connection should still be open
 			cw.WriteCmd("PING")
@@ -174,6 +241,24 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	It("should only fold ASCII letters in command names", func() {
+		subject.Handle("keys", Ping())
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("\u212AEYS")
+			cw.WriteCmd("KeYs")
+			Expect(cw.Flush()).To(Succeed())
+
+			s, err := cr.ReadError()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(Equal("ERR unknown command '\u212AEYS'"))
+
+			s, err = cr.ReadInlineString()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(Equal("PONG"))
+		})
+	})
+
 	It("should handle invalid commands in pipelines", func() {
 		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
 			cw.WriteCmd("PING")
@@ -274,6 +359,230 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	It("should support custom buffer sizes", func() {
+		subject.conf().ReadBufferSize = 1024
+		subject.conf().WriteBufferSize = 1024
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			large := strings.Repeat("x", 10000)
+			cw.WriteCmdString("ECHO", large)
+			cw.WriteCmdString("ECHO", "small")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadBulkString()).To(Equal(large))
+			Expect(cr.ReadBulkString()).To(Equal("small"))
+		})
+	})
+
+	It("should grow read buffers for large inline requests", func() {
+		subject.conf().ReadBufferSize = 512
+		subject.conf().MaxReadBufferSize = 4096
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			_, err := cn.Write([]byte("ECHO " + strings.Repeat("x", 2000) + "\r\nPING\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cr.ReadBulkString()).To(HaveLen(2000))
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		})
+	})
+
+	It("should serve handed-off connections", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer lis.Close()
+
+		// sniff the first bytes, then hand the connection over
+		go func() {
+			defer GinkgoRecover()
+
+			cn, err := lis.Accept()
+			Expect(err).NotTo(HaveOccurred())
+
+			buf := make([]byte, 3)
+			_, err = io.ReadFull(cn, buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.ServeConn(cn, buf)).To(Succeed())
+		}()
+
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer cn.Close()
+
+		_, err = cn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.NewResponseReader(cn).ReadInlineString()).To(Equal("PONG"))
+	})
+
+	It("should not time out protected clients", func() {
+		subject.Handle("client", SubCommands{"no-evict": ClientNoEvict()})
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("CLIENT", "NO-EVICT", "on")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+			// idle beyond the 100ms timeout
+			time.Sleep(250 * time.Millisecond)
+
+			cw.WriteCmd("PING")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		})
+	})
+
+	It("should reject write commands in read-only mode", func() {
+		subject.HandleWriteFunc("set", func(w resp.ResponseWriter, c *resp.Command) { w.AppendOK() })
+		subject.Handle("readonly", ReadOnly())
+		subject.Handle("readwrite", ReadWrite())
+		subject.SetReadOnly(true)
+		Expect(subject.ReadOnly()).To(BeTrue())
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmdString("SET", "key", "value")
+			cw.WriteCmd("PING")
+			cw.WriteCmd("READWRITE")
+			cw.WriteCmdString("SET", "key", "value")
+			cw.WriteCmd("READONLY")
+			cw.WriteCmdString("SET", "key", "value")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadError()).To(Equal("READONLY You can't write against a read only replica."))
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(cr.ReadError()).To(Equal("READONLY You can't write against a read only replica."))
+
+			subject.SetReadOnly(false)
+			cw.WriteCmdString("SET", "key", "value")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+		})
+	})
+
+	It("should flush and yield once the command budget is spent", func() {
+		subject.conf().CommandBudget = 2
+		subject.HandleFunc("written", func(w resp.ResponseWriter, c *resp.Command) {
+			w.AppendInt(GetClient(c.Context()).BytesWritten())
+		})
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			for i := 0; i < 5; i++ {
+				cw.WriteCmd("WRITTEN")
+			}
+			Expect(cw.Flush()).To(Succeed())
+
+			var written []int64
+			for i := 0; i < 5; i++ {
+				n, err := cr.ReadInt()
+				Expect(err).NotTo(HaveOccurred())
+				written = append(written, n)
+			}
+			Expect(written[:2]).To(Equal([]int64{0, 0}))
+			Expect(written[2]).To(BeNumerically(">", 0))
+			Expect(written[4]).To(BeNumerically(">", written[2]))
+		})
+	})
+
+	It("should expose deadlines to handlers", func() {
+		var ctxs []context.Context
+		subject.HandleFunc("deadline", func(w resp.ResponseWriter, c *resp.Command) {
+			ctxs = append(ctxs, c.Context())
+			if c.ArgN() != 0 {
+				GetClient(c.Context()).SetNoEvict(true)
+			}
+			w.AppendOK()
+		})
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			start := time.Now()
+			cw.WriteCmd("DEADLINE")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+			cw.WriteCmdString("DEADLINE", "protect")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+			cw.WriteCmd("DEADLINE")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+			Expect(ctxs).To(HaveLen(3))
+
+			deadline, ok := ctxs[0].Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(deadline).To(BeTemporally("~", start.Add(100*time.Millisecond), 50*time.Millisecond))
+			Expect(ctxs[0].Err()).To(Equal(context.Canceled))
+
+			_, ok = ctxs[2].Deadline()
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	It("should apply command timeout overrides", func() {
+		subject = NewServer(&Config{
+			Timeout:         100 * time.Millisecond,
+			CommandTimeouts: map[string]time.Duration{"BLOCK": 300 * time.Millisecond},
+		})
+		subject.HandleFunc("ping", pong)
+		subject.HandleFunc("block", func(w resp.ResponseWriter, c *resp.Command) {
+			deadline, _ := c.Context().Deadline()
+			time.Sleep(150 * time.Millisecond)
+			w.AppendInt(int64(time.Until(deadline) / time.Millisecond))
+		})
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("BLOCK")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInt()).To(BeNumerically("~", 150, 50))
+
+			cw.WriteCmd("PING")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+		})
+	})
+
+	It("should attach request IDs and profiler labels", func() {
+		subject.conf().ProfilerLabels = true
+
+		var ids []uint64
+		subject.HandleFunc("trace", func(w resp.ResponseWriter, c *resp.Command) {
+			label, _ := pprof.Label(c.Context(), "command")
+			ids = append(ids, RequestID(c.Context()))
+			Expect(GetClient(c.Context())).NotTo(BeNil())
+			w.AppendInlineString(label)
+		})
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("TRACE")
+			cw.WriteCmd("TRACE")
+			Expect(cw.Flush()).To(Succeed())
+
+			Expect(cr.ReadInlineString()).To(Equal("trace"))
+			Expect(cr.ReadInlineString()).To(Equal("trace"))
+			Expect(ids).To(HaveLen(2))
+			Expect(ids[0]).NotTo(BeZero())
+			Expect(ids[1]).To(BeNumerically(">", ids[0]))
+		})
+		Expect(RequestID(context.Background())).To(BeZero())
+	})
+
+	It("should optionally close connections on protocol errors", func() {
+		subject.conf().CloseOnProtocolError = true
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			_, err := cn.Write([]byte("*1\r\n$4\r\nPING\r\n*1\r\n$-3\r\nPING\r\n*1\r\n$4\r\nPING\r\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			s, err := cr.ReadInlineString()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(Equal("PONG"))
+
+			s, err = cr.ReadError()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(Equal("ERR Protocol error: invalid bulk length"))
+
+			// connection should be closed
+			_, err = cr.PeekType()
+			Expect(err).To(MatchError("EOF"))
+		})
+	})
+
 	It("should close connections on EOF errors", func() {
 		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
 			_, err := cn.Write([]byte("*1\r\n$4\r\nPI"))
@@ -285,6 +594,81 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	It("should not interleave frames with replies", func() {
+		clients := make(chan *Client, 1)
+		subject.HandleFunc("push", func(w resp.ResponseWriter, cmd *resp.Command) {
+			client := GetClient(cmd.Context())
+			done := make(chan error)
+			go func() {
+				done <- client.WriteFrame(func(w resp.ResponseWriter) {
+					w.AppendBulkString("pushed")
+				})
+			}()
+
+			w.AppendArrayLen(2)
+			Expect(<-done).To(Succeed())
+			w.AppendBulkString("a")
+			w.AppendBulkString("b")
+			clients <- client
+		})
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("PUSH")
+			Expect(cw.Flush()).To(Succeed())
+
+			var reply []string
+			Expect(cr.Scan(&reply)).To(Succeed())
+			Expect(reply).To(Equal([]string{"a", "b"}))
+
+			s, err := cr.ReadBulkString()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(Equal("pushed"))
+
+			// push while idle
+			client := <-clients
+			Expect(client.WriteFrame(func(w resp.ResponseWriter) {
+				w.AppendInlineString("idle")
+			})).To(Succeed())
+
+			s, err = cr.ReadInlineString()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s).To(Equal("idle"))
+		})
+	})
+
+	It("should reject connections on accept", func() {
+		subject.conf().OnAccept = func(cn net.Conn) error {
+			if subject.Info().NumClients() > 0 {
+				return errors.New("max number of clients reached")
+			}
+			return nil
+		}
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			cw.WriteCmd("PING")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+
+			cn2, err := net.Dial("tcp", cn.RemoteAddr().String())
+			Expect(err).NotTo(HaveOccurred())
+			defer cn2.Close()
+
+			cr2 := resp.NewResponseReader(cn2)
+			Expect(cr2.ReadError()).To(Equal("ERR max number of clients reached"))
+			_, err = cr2.PeekType()
+			Expect(err).To(MatchError("EOF"))
+		})
+	})
+
+	It("should drop connections on accept", func() {
+		subject.conf().OnAccept = func(cn net.Conn) error { return ErrDropConn }
+
+		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+			_, err := cr.PeekType()
+			Expect(err).To(MatchError("EOF"))
+		})
+	})
+
 	It("should allow user to close connections", func() {
 		runServer(subject, func(cn net.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
 			cw.WriteCmd("QUIT")
@@ -303,8 +687,393 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	Describe("Shutdown", func() {
+		var lis net.Listener
+		var served chan error
+
+		var dial = func() (net.Conn, *resp.RequestWriter, resp.ResponseReader) {
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			return cn, resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		}
+
+		BeforeEach(func() {
+			var err error
+			lis, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			// no timeouts, shutdown must not wait for them
+			subject.conf().Timeout = 0
+
+			srv, l, errs := subject, lis, make(chan error, 1)
+			go func() { errs <- srv.Serve(l) }()
+			served = errs
+		})
+
+		AfterEach(func() {
+			lis.Close()
+		})
+
+		It("should disconnect idle clients promptly", func() {
+			cn, cw, cr := dial()
+			defer cn.Close()
+
+			cw.WriteCmd("PING")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+
+			start := time.Now()
+			Expect(subject.Shutdown(context.Background())).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(<-served).To(Equal(ErrServerClosed))
+			Expect(subject.Serve(lis)).To(Equal(ErrServerClosed))
+
+			_, err := cr.PeekType()
+			Expect(err).To(MatchError("EOF"))
+		})
+
+		It("should let clients complete running commands", func() {
+			running := make(chan struct{})
+			subject.HandleFunc("slow", func(w resp.ResponseWriter, _ *resp.Command) {
+				close(running)
+				time.Sleep(50 * time.Millisecond)
+				w.AppendOK()
+			})
+
+			cn, cw, cr := dial()
+			defer cn.Close()
+
+			cw.WriteCmd("SLOW")
+			Expect(cw.Flush()).To(Succeed())
+			<-running
+
+			Expect(subject.Shutdown(context.Background())).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+			_, err := cr.PeekType()
+			Expect(err).To(MatchError("EOF"))
+		})
+
+		It("should force-close connections when context expires", func() {
+			running, release := make(chan struct{}), make(chan struct{})
+			defer close(release)
+
+			subject.HandleFunc("block", func(w resp.ResponseWriter, _ *resp.Command) {
+				close(running)
+				<-release
+				w.AppendOK()
+			})
+
+			cn, cw, cr := dial()
+			defer cn.Close()
+
+			cw.WriteCmd("BLOCK")
+			Expect(cw.Flush()).To(Succeed())
+			<-running
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			Expect(subject.Shutdown(ctx)).To(Equal(context.DeadlineExceeded))
+
+			_, err := cr.PeekType()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("event loop", func() {
+		var lis net.Listener
+		var clients chan *Client
+
+		var dial = func() (net.Conn, *resp.RequestWriter, resp.ResponseReader) {
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			return cn, resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+		}
+
+		BeforeEach(func() {
+			if runtime.GOOS != "linux" {
+				Skip("event loop is only supported on linux")
+			}
+
+			subject = NewServer(&Config{EventLoop: true})
+
+			clients = make(chan *Client, 1)
+			subject.HandleFunc("ping", pong)
+			subject.HandleFunc("echo", echo)
+			subject.HandleFunc("quit", quit)
+			subject.HandleFunc("whoami", func(w resp.ResponseWriter, c *resp.Command) {
+				clients <- GetClient(c.Context())
+				w.AppendOK()
+			})
+
+			var err error
+			lis, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			srv, l := subject, lis
+			go srv.Serve(l)
+		})
+
+		AfterEach(func() {
+			lis.Close()
+			Expect(subject.Shutdown(context.Background())).To(Succeed())
+		})
+
+		It("should serve parked clients", func() {
+			cn, cw, cr := dial()
+			defer cn.Close()
+
+			cw.WriteCmd("PING")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+
+			time.Sleep(10 * time.Millisecond)
+			cw.WriteCmdString("ECHO", "HELLO")
+			cw.WriteCmdString("ECHO", "WORLD")
+			cw.WriteCmd("QUIT")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadBulkString()).To(Equal("HELLO"))
+			Expect(cr.ReadBulkString()).To(Equal("WORLD"))
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+			_, err := cr.PeekType()
+			Expect(err).To(MatchError("EOF"))
+			Eventually(func() int { return subject.Info().NumClients() }).Should(Equal(0))
+		})
+
+		It("should not block goroutines for idle clients", func() {
+			before := runtime.NumGoroutine()
+
+			for i := 0; i < 50; i++ {
+				cn, cw, cr := dial()
+				defer cn.Close()
+
+				cw.WriteCmd("PING")
+				Expect(cw.Flush()).To(Succeed())
+				Expect(cr.ReadInlineString()).To(Equal("PONG"))
+			}
+
+			Expect(subject.Info().NumClients()).To(Equal(50))
+			Eventually(runtime.NumGoroutine).Should(BeNumerically("<", before+10))
+		})
+
+		It("should deliver frames to parked clients", func() {
+			cn, cw, cr := dial()
+			defer cn.Close()
+
+			cw.WriteCmd("WHOAMI")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+			c := <-clients
+			Expect(c.WriteFrame(func(w resp.ResponseWriter) { w.AppendInlineString("PUSHED") })).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("PUSHED"))
+		})
+
+		It("should disconnect parked clients", func() {
+			cn, cw, cr := dial()
+			defer cn.Close()
+
+			cw.WriteCmd("WHOAMI")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("OK"))
+
+			(<-clients).kill()
+			_, err := cr.PeekType()
+			Expect(err).To(MatchError("EOF"))
+			Eventually(func() int { return subject.Info().NumClients() }).Should(Equal(0))
+		})
+
+		It("should shut down parked clients", func() {
+			cn, cw, cr := dial()
+			defer cn.Close()
+
+			cw.WriteCmd("PING")
+			Expect(cw.Flush()).To(Succeed())
+			Expect(cr.ReadInlineString()).To(Equal("PONG"))
+
+			Expect(subject.Shutdown(context.Background())).To(Succeed())
+			_, err := cr.PeekType()
+			Expect(err).To(MatchError("EOF"))
+		})
+	})
+
+	Describe("multiple acceptors", func() {
+		BeforeEach(func() {
+			if runtime.GOOS != "linux" {
+				Skip("SO_REUSEPORT is only supported on linux")
+			}
+		})
+
+		It("should open listeners on the same address", func() {
+			lis, err := ListenReusePort("tcp", "127.0.0.1:0", 4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lis).To(HaveLen(4))
+			for _, l := range lis {
+				Expect(l.Addr().String()).To(Equal(lis[0].Addr().String()))
+				Expect(l.Close()).To(Succeed())
+			}
+		})
+
+		It("should serve all listeners", func() {
+			lis, err := ListenReusePort("tcp", "127.0.0.1:0", 4)
+			Expect(err).NotTo(HaveOccurred())
+
+			subject.HandleFunc("ping", pong)
+			done := make(chan error, 1)
+			go func() { done <- subject.serveAll(lis) }()
+
+			for i := 0; i < 20; i++ {
+				cn, err := net.Dial("tcp", lis[0].Addr().String())
+				Expect(err).NotTo(HaveOccurred())
+
+				cw, cr := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+				cw.WriteCmd("PING")
+				Expect(cw.Flush()).To(Succeed())
+				Expect(cr.ReadInlineString()).To(Equal("PONG"))
+				Expect(cn.Close()).To(Succeed())
+			}
+
+			Expect(subject.Shutdown(context.Background())).To(Succeed())
+			Eventually(done).Should(Receive(Equal(ErrServerClosed)))
+		})
+	})
+
+	Describe("TLS", func() {
+		var runTLSServer = func(srv *Server, cert *tls.Certificate, fn func(*tls.Conn, *resp.RequestWriter, resp.ResponseReader)) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer lis.Close()
+
+			go srv.ServeTLS(lis, &tls.Config{
+				Certificates: []tls.Certificate{mkTestCert("server.test")},
+				ClientAuth:   tls.RequestClientCert,
+			})
+
+			config := &tls.Config{InsecureSkipVerify: true}
+			if cert != nil {
+				config.Certificates = []tls.Certificate{*cert}
+			}
+			cn, err := tls.Dial("tcp", lis.Addr().String(), config)
+			Expect(err).NotTo(HaveOccurred())
+			defer cn.Close()
+
+			fn(cn, resp.NewRequestWriter(cn), resp.NewResponseReader(cn))
+		}
+
+		var whoami = func(w resp.ResponseWriter, cmd *resp.Command) {
+			client := GetClient(cmd.Context())
+			if client == nil {
+				w.AppendNil()
+				return
+			}
+
+			certs := client.PeerCertificates()
+			if len(certs) == 0 {
+				w.AppendNil()
+				return
+			}
+			w.AppendBulkString(certs[0].Subject.CommonName)
+		}
+
+		BeforeEach(func() {
+			subject.HandleFunc("whoami", whoami)
+		})
+
+		It("should serve", func() {
+			cert := mkTestCert("alice")
+			runTLSServer(subject, &cert, func(cn *tls.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+				cw.WriteCmd("PING")
+				cw.WriteCmd("WHOAMI")
+				Expect(cw.Flush()).To(Succeed())
+
+				s, err := cr.ReadInlineString()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s).To(Equal("PONG"))
+
+				s, err = cr.ReadBulkString()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s).To(Equal("alice"))
+			})
+		})
+
+		It("should not wait for pending handshakes on shutdown", func() {
+			subject.conf().Timeout = time.Minute
+
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer lis.Close()
+
+			srv, l := subject, lis
+			go srv.ServeTLS(l, &tls.Config{Certificates: []tls.Certificate{mkTestCert("server.test")}})
+
+			// connect, but never start the handshake
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			defer cn.Close()
+			Eventually(func() int {
+				subject.lmu.Lock()
+				defer subject.lmu.Unlock()
+				return len(subject.clients)
+			}).Should(Equal(1))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			Expect(subject.Shutdown(ctx)).To(Succeed())
+		})
+
+		It("should authenticate clients by certificate", func() {
+			subject.conf().TLSAuth = func(cert *x509.Certificate) error {
+				if cert == nil {
+					return errors.New("NOAUTH client certificate required")
+				} else if cert.Subject.CommonName != "alice" {
+					return errors.New("unknown client " + cert.Subject.CommonName)
+				}
+				return nil
+			}
+
+			cert := mkTestCert("alice")
+			runTLSServer(subject, &cert, func(cn *tls.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+				cw.WriteCmd("WHOAMI")
+				Expect(cw.Flush()).To(Succeed())
+
+				s, err := cr.ReadBulkString()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s).To(Equal("alice"))
+			})
+
+			cert = mkTestCert("bob")
+			runTLSServer(subject, &cert, func(cn *tls.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+				s, err := cr.ReadError()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s).To(Equal("ERR unknown client bob"))
+
+				_, err = cr.PeekType()
+				Expect(err).To(MatchError("EOF"))
+			})
+
+			runTLSServer(subject, nil, func(cn *tls.Conn, cw *resp.RequestWriter, cr resp.ResponseReader) {
+				s, err := cr.ReadError()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s).To(Equal("NOAUTH client certificate required"))
+			})
+		})
+	})
+
 })
 
+var _ = DescribeTable("errorReply",
+	func(msg, exp string) {
+		Expect(errorReply(errors.New(msg))).To(Equal(exp))
+	},
+
+	Entry("plain", "unknown client", "ERR unknown client"),
+	Entry("error code", "NOAUTH certificate required", "NOAUTH certificate required"),
+	Entry("single word", "DENIED", "ERR DENIED"),
+	Entry("mixed case", "NoAuth required", "ERR NoAuth required"),
+	Entry("blank", "", "ERR "),
+)
+
 // --------------------------------------------------------------------
 
 func BenchmarkServer_inline(b *testing.B) {
@@ -0,0 +1,178 @@
+package redeo
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Session files consist of one record per chunk of data received from
+// the client, each starting with a 12 byte header: the offset since the
+// start of the session in nanoseconds (8 bytes) and the length of the
+// data (4 bytes), both big-endian.
+const sessionHeaderSize = 12
+
+var errNoSyscallConn = errors.New("redeo: connection does not support syscalls")
+
+// SessionFrame is a chunk of data received from a client, as recorded
+// with Config.RecordDir.
+type SessionFrame struct {
+	// Offset is the time since the start of the session
+	Offset time.Duration
+
+	// Data is the raw data, as received
+	Data []byte
+}
+
+// ReadSession reads a session file, recorded with Config.RecordDir.
+func ReadSession(path string) ([]SessionFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var frames []SessionFrame
+	rd := bufio.NewReader(f)
+	head := make([]byte, sessionHeaderSize)
+	for {
+		if _, err := io.ReadFull(rd, head); err == io.EOF {
+			return frames, nil
+		} else if err != nil {
+			return frames, fmt.Errorf("redeo: truncated session file %s", path)
+		}
+
+		frame := SessionFrame{
+			Offset: time.Duration(binary.BigEndian.Uint64(head)),
+			Data:   make([]byte, binary.BigEndian.Uint32(head[8:])),
+		}
+		if _, err := io.ReadFull(rd, frame.Data); err != nil {
+			return frames, fmt.Errorf("redeo: truncated session file %s", path)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// ReplaySession sends recorded frames over cn, e.g. a connection to a
+// test server. Frames are delayed to reproduce the original timing,
+// accelerated by speed: 1 replays at original speed, 10 ten times as
+// fast. Speeds <= 0 send all frames without delay. Replies are read and
+// discarded until cn is closed. It returns early when ctx is done.
+func ReplaySession(ctx context.Context, cn net.Conn, frames []SessionFrame, speed float64) error {
+	go func() { _, _ = io.Copy(ioutil.Discard, cn) }()
+
+	start := time.Now()
+	for _, frame := range frames {
+		if speed > 0 {
+			delay := time.Duration(float64(frame.Offset)/speed) - time.Since(start)
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := cn.Write(frame.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------
+
+// recordingConn records the data read from the connection to a session file
+type recordingConn struct {
+	net.Conn
+
+	clock   Clock
+	start   time.Time
+	onError func(error)
+
+	mu   sync.Mutex
+	file *os.File
+	head []byte
+}
+
+// newRecordingConn creates a new session file in dir
+func newRecordingConn(cn net.Conn, dir string, id uint64, clock Clock, onError func(error)) (*recordingConn, error) {
+	start := clock.Now()
+	name := fmt.Sprintf("session-%d-%d.rec", start.Unix(), id)
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingConn{
+		Conn:    cn,
+		clock:   clock,
+		start:   start,
+		onError: onError,
+		file:    f,
+		head:    make([]byte, sessionHeaderSize),
+	}, nil
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(p[:n])
+	}
+	return n, err
+}
+
+// SyscallConn exposes the underlying connection to the event loop
+func (c *recordingConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := c.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, errNoSyscallConn
+}
+
+func (c *recordingConn) Close() error {
+	err := c.Conn.Close()
+
+	c.mu.Lock()
+	if c.file != nil {
+		_ = c.file.Close()
+		c.file = nil
+	}
+	c.mu.Unlock()
+
+	return err
+}
+
+// record appends a frame, recording stops on the first error
+func (c *recordingConn) record(p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return
+	}
+
+	binary.BigEndian.PutUint64(c.head, uint64(c.clock.Now().Sub(c.start)))
+	binary.BigEndian.PutUint32(c.head[8:], uint32(len(p)))
+	if _, err := c.file.Write(append(c.head, p...)); err != nil {
+		_ = c.file.Close()
+		c.file = nil
+		c.onError(err)
+	}
+}
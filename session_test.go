@@ -0,0 +1,79 @@
+package redeo
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session recording", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "redeo-session")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should record and replay sessions", func() {
+		srv := NewServer(&Config{RecordDir: dir, Clock: NewMockClock(time.Unix(1600000000, 0))})
+		srv.Handle("ping", Ping())
+		Expect(redeotest.Transcript(srv, "PING\r\n", "*1\r\n$4\r\nPING\r\n")).To(Equal([]byte("+PONG\r\n+PONG\r\n")))
+
+		files, err := filepath.Glob(filepath.Join(dir, "session-1600000000-*.rec"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+
+		frames, err := ReadSession(files[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(frames).To(Equal([]SessionFrame{
+			{Data: []byte("PING\r\n")},
+			{Data: []byte("*1\r\n$4\r\nPING\r\n")},
+		}))
+
+		pings := make(chan struct{}, 10)
+		target := NewServer(nil)
+		target.HandleFunc("ping", func(w resp.ResponseWriter, _ *resp.Command) {
+			pings <- struct{}{}
+			w.AppendInlineString("PONG")
+		})
+
+		cn, sn := net.Pipe()
+		defer cn.Close()
+		Expect(target.ServeConn(sn, nil)).To(Succeed())
+		Expect(ReplaySession(context.Background(), cn, frames, 0)).To(Succeed())
+		Eventually(pings).Should(HaveLen(2))
+	})
+
+	It("should reject truncated files", func() {
+		path := filepath.Join(dir, "truncated.rec")
+		Expect(ioutil.WriteFile(path, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 9, 'P'}, 0600)).To(Succeed())
+		_, err := ReadSession(path)
+		Expect(err).To(MatchError("redeo: truncated session file " + path))
+	})
+
+	It("should respect the original timing", func() {
+		frames := []SessionFrame{{Data: []byte("PING\r\n")}, {Offset: time.Hour, Data: []byte("PING\r\n")}}
+		cn, sn := net.Pipe()
+		defer cn.Close()
+		defer sn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		go func() { _, _ = ioutil.ReadAll(sn) }()
+		Expect(ReplaySession(ctx, cn, frames, 1)).To(Equal(context.DeadlineExceeded))
+	})
+
+})
@@ -0,0 +1,96 @@
+package redeo
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader loads a TLS certificate/key pair from disk and reloads it
+// whenever either file is modified, without affecting established
+// connections. Assign its GetCertificate method to the tls.Config passed
+// to ServeTLS:
+//
+//   config := &tls.Config{GetCertificate: reloader.GetCertificate}
+type CertReloader struct {
+	certFile, keyFile string
+
+	cert            *tls.Certificate
+	certMod, keyMod time.Time
+	mu              sync.RWMutex
+}
+
+// NewCertReloader inits a new reloader and loads the initial certificate.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload (re-)loads the certificate from disk. On errors, the
+// previously loaded certificate is retained.
+func (r *CertReloader) Reload() error {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// record the attempt even if it failed, broken files
+	// are not retried until they are modified again
+	r.certMod = certMod
+	r.keyMod = keyMod
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	return nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if
+// the files have changed since they were last loaded. It can be used as
+// tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if r.changed() {
+		// keep serving the old certificate if the new one is
+		// incomplete or invalid, e.g. while files are being replaced
+		_ = r.Reload()
+	}
+
+	r.mu.RLock()
+	cert := r.cert
+	r.mu.RUnlock()
+	return cert, nil
+}
+
+func (r *CertReloader) changed() bool {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)
+}
+
+func (r *CertReloader) modTimes() (certMod, keyMod time.Time, err error) {
+	fi, err := os.Stat(r.certFile)
+	if err != nil {
+		return
+	}
+	certMod = fi.ModTime()
+
+	if fi, err = os.Stat(r.keyFile); err != nil {
+		return
+	}
+	keyMod = fi.ModTime()
+	return
+}
@@ -0,0 +1,92 @@
+package redeo
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CertReloader", func() {
+	var dir, certFile, keyFile string
+
+	var writeCert = func(commonName string, mtime time.Time) {
+		cert := mkTestCert(commonName)
+		key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)).To(Succeed())
+		Expect(os.Chtimes(certFile, mtime, mtime)).To(Succeed())
+		Expect(os.Chtimes(keyFile, mtime, mtime)).To(Succeed())
+	}
+
+	var commonName = func(r *CertReloader) string {
+		cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+		Expect(err).NotTo(HaveOccurred())
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		return leaf.Subject.CommonName
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "redeo-tls")
+		Expect(err).NotTo(HaveOccurred())
+
+		certFile = filepath.Join(dir, "cert.pem")
+		keyFile = filepath.Join(dir, "key.pem")
+		writeCert("v1.test", time.Now().Add(-time.Minute))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should fail on invalid files", func() {
+		_, err := NewCertReloader(certFile, filepath.Join(dir, "missing.pem"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reload modified certificates", func() {
+		subject, err := NewCertReloader(certFile, keyFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commonName(subject)).To(Equal("v1.test"))
+
+		writeCert("v2.test", time.Now())
+		Expect(commonName(subject)).To(Equal("v2.test"))
+	})
+
+	It("should retain certificates on reload errors", func() {
+		subject, err := NewCertReloader(certFile, keyFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(keyFile, []byte("garbage"), 0600)).To(Succeed())
+		Expect(subject.Reload()).NotTo(Succeed())
+		Expect(commonName(subject)).To(Equal("v1.test"))
+	})
+
+	It("should not retry broken files until they are modified", func() {
+		subject, err := NewCertReloader(certFile, keyFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		mtime := time.Now()
+		Expect(ioutil.WriteFile(keyFile, []byte("garbage"), 0600)).To(Succeed())
+		Expect(os.Chtimes(keyFile, mtime, mtime)).To(Succeed())
+		Expect(commonName(subject)).To(Equal("v1.test"))
+		Expect(subject.changed()).To(BeFalse())
+
+		writeCert("v2.test", mtime.Add(time.Second))
+		Expect(subject.changed()).To(BeTrue())
+		Expect(commonName(subject)).To(Equal("v2.test"))
+	})
+
+})
@@ -0,0 +1,226 @@
+package redeo
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/johntech-o/redeo/resp"
+)
+
+// SlotRange is an inclusive range of hash slots
+type SlotRange struct {
+	Start, End uint16
+}
+
+// ClusterNode describes a node of a cluster
+type ClusterNode struct {
+	// ID is the node ID
+	ID string
+
+	// Host and Port identify the node's address
+	Host string
+	Port int
+
+	// BusPort is reported as the port of the cluster bus.
+	// Default: Port + 10000
+	BusPort int
+
+	// Master is the ID of the node's master, empty for masters
+	Master string
+
+	// Slots lists the slots served by the node (masters only)
+	Slots []SlotRange
+
+	// Epoch is the node's configuration epoch
+	Epoch int64
+
+	// Fail marks nodes which are unreachable
+	Fail bool
+}
+
+// Addr returns the node's address
+func (n *ClusterNode) Addr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// ClusterTopology provides the nodes of a cluster, which are reported to
+// clients via CLUSTER SLOTS and CLUSTER NODES. Topologies replace the
+// gossip bus of Redis cluster: they can be static, see StaticTopology, or
+// backed by external sources like etcd or consul, see
+// ClusterTopologyFunc.
+type ClusterTopology interface {
+	// ClusterNodes returns the nodes of the cluster
+	ClusterNodes() []ClusterNode
+}
+
+// ClusterTopologyFunc is a function which implements ClusterTopology,
+// e.g. to look up the nodes in a service registry
+type ClusterTopologyFunc func() []ClusterNode
+
+// ClusterNodes implements ClusterTopology
+func (f ClusterTopologyFunc) ClusterNodes() []ClusterNode { return f() }
+
+// StaticTopology is a fixed list of nodes, which implements
+// ClusterTopology
+type StaticTopology []ClusterNode
+
+// ClusterNodes implements ClusterTopology
+func (t StaticTopology) ClusterNodes() []ClusterNode { return t }
+
+// ClusterSlots returns a CLUSTER SLOTS handler, for use as a sub-command,
+// which reports the slot ranges of the masters of a topology along with
+// their replicas.
+// https://redis.io/commands/cluster-slots
+func ClusterSlots(t ClusterTopology) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		type slotOwner struct {
+			SlotRange
+			master   ClusterNode
+			replicas []ClusterNode
+		}
+
+		nodes := t.ClusterNodes()
+		replicas := make(map[string][]ClusterNode)
+		for _, n := range nodes {
+			if n.Master != "" {
+				replicas[n.Master] = append(replicas[n.Master], n)
+			}
+		}
+
+		var owners []slotOwner
+		for _, n := range nodes {
+			for _, r := range n.Slots {
+				owners = append(owners, slotOwner{SlotRange: r, master: n, replicas: replicas[n.ID]})
+			}
+		}
+		sort.Slice(owners, func(i, j int) bool { return owners[i].Start < owners[j].Start })
+
+		w.AppendArrayLen(len(owners))
+		for _, o := range owners {
+			w.AppendArrayLen(3 + len(o.replicas))
+			w.AppendInt(int64(o.Start))
+			w.AppendInt(int64(o.End))
+			appendSlotNode(w, o.master)
+			for _, r := range o.replicas {
+				appendSlotNode(w, r)
+			}
+		}
+	})
+}
+
+func appendSlotNode(w resp.ResponseWriter, n ClusterNode) {
+	w.AppendArrayLen(3)
+	w.AppendBulkString(n.Host)
+	w.AppendInt(int64(n.Port))
+	w.AppendBulkString(n.ID)
+}
+
+// slotMigrator is implemented by topologies which report the slots
+// migrating from and importing to the local node, i.e. Cluster
+type slotMigrator interface {
+	migrations() (migrating, importing map[uint16]string)
+}
+
+// ClusterNodes returns a CLUSTER NODES handler, for use as a sub-command,
+// which describes the nodes of a topology in the format of the cluster
+// spec. The node with ID myID is flagged as myself.
+// https://redis.io/commands/cluster-nodes
+func ClusterNodes(t ClusterTopology, myID string) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+
+		var migrating, importing map[uint16]string
+		if m, ok := t.(slotMigrator); ok {
+			migrating, importing = m.migrations()
+		}
+
+		var buf bytes.Buffer
+		for _, n := range t.ClusterNodes() {
+			appendNodeLine(&buf, n, n.ID == myID)
+			if n.ID == myID {
+				appendMigrations(&buf, migrating, "->-")
+				appendMigrations(&buf, importing, "-<-")
+			}
+			buf.WriteByte('\n')
+		}
+		w.AppendBulk(buf.Bytes())
+	})
+}
+
+// ClusterMyID returns a CLUSTER MYID handler, for use as a sub-command,
+// which replies with the node ID
+// https://redis.io/commands/cluster-myid
+func ClusterMyID(myID string) Handler {
+	return HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		if c.ArgN() != 0 {
+			w.AppendError(WrongNumberOfArgs(c.Name))
+			return
+		}
+		w.AppendBulkString(myID)
+	})
+}
+
+// appendNodeLine appends a CLUSTER NODES line without the line break:
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv>
+// <config-epoch> <link-state> <slot> ... <slot>
+func appendNodeLine(buf *bytes.Buffer, n ClusterNode, myself bool) {
+	busPort := n.BusPort
+	if busPort == 0 {
+		busPort = n.Port + 10000
+	}
+
+	flags := "master"
+	if n.Master != "" {
+		flags = "slave"
+	}
+	if myself {
+		flags = "myself," + flags
+	}
+	if n.Fail {
+		flags += ",fail"
+	}
+
+	master := n.Master
+	if master == "" {
+		master = "-"
+	}
+
+	link := "connected"
+	if n.Fail {
+		link = "disconnected"
+	}
+
+	buf.WriteString(n.ID + " " + n.Addr() + "@" + strconv.Itoa(busPort) + " " + flags + " " + master)
+	buf.WriteString(" 0 0 " + strconv.FormatInt(n.Epoch, 10) + " " + link)
+	for _, r := range n.Slots {
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Itoa(int(r.Start)))
+		if r.End != r.Start {
+			buf.WriteString("-" + strconv.Itoa(int(r.End)))
+		}
+	}
+}
+
+// appendMigrations appends the slots being migrated as [slot->-id] or
+// [slot-<-id], sorted by slot
+func appendMigrations(buf *bytes.Buffer, m map[uint16]string, arrow string) {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, int(slot))
+	}
+	sort.Ints(slots)
+
+	for _, slot := range slots {
+		buf.WriteString(" [" + strconv.Itoa(slot) + arrow + m[uint16(slot)] + "]")
+	}
+}
@@ -0,0 +1,69 @@
+package redeo
+
+import (
+	"github.com/johntech-o/redeo/redeotest"
+	"github.com/johntech-o/redeo/resp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClusterTopology", func() {
+	var topology StaticTopology
+
+	var call = func(args ...string) interface{} {
+		cmd := resp.NewCommand("CLUSTER slots")
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, resp.CommandArgument(arg))
+		}
+		w := redeotest.NewRecorder()
+		ClusterSlots(topology).ServeRedeo(w, cmd)
+		v, _ := w.Response()
+		return v
+	}
+
+	BeforeEach(func() {
+		topology = StaticTopology{
+			{ID: "b", Host: "10.0.0.2", Port: 6379, Slots: []SlotRange{{Start: 0, End: 5460}, {Start: 10923, End: 16383}}},
+			{ID: "a", Host: "10.0.0.1", Port: 6379, Slots: []SlotRange{{Start: 5461, End: 10922}}},
+			{ID: "c", Host: "10.0.0.3", Port: 6380, Master: "b"},
+		}
+	})
+
+	It("should report slot ranges", func() {
+		Expect(call()).To(Equal([]interface{}{
+			[]interface{}{int64(0), int64(5460), []interface{}{"10.0.0.2", int64(6379), "b"}, []interface{}{"10.0.0.3", int64(6380), "c"}},
+			[]interface{}{int64(5461), int64(10922), []interface{}{"10.0.0.1", int64(6379), "a"}},
+			[]interface{}{int64(10923), int64(16383), []interface{}{"10.0.0.2", int64(6379), "b"}, []interface{}{"10.0.0.3", int64(6380), "c"}},
+		}))
+		Expect(call("x")).To(MatchError("ERR wrong number of arguments for 'CLUSTER slots' command"))
+	})
+
+	It("should accept callbacks", func() {
+		n := 0
+		topology := ClusterTopologyFunc(func() []ClusterNode {
+			n++
+			return []ClusterNode{{ID: "a", Host: "::1", Port: 7000}}
+		})
+		Expect(topology.ClusterNodes()).To(HaveLen(1))
+		Expect(topology.ClusterNodes()[0].Addr()).To(Equal("[::1]:7000"))
+		Expect(n).To(Equal(2))
+	})
+
+	It("should describe nodes", func() {
+		topology[1].Epoch = 2
+		topology[2].Fail = true
+		topology[2].BusPort = 16380
+
+		w := redeotest.NewRecorder()
+		ClusterNodes(topology, "a").ServeRedeo(w, resp.NewCommand("CLUSTER nodes"))
+		Expect(w.Response()).To(Equal("" +
+			"b 10.0.0.2:6379@16379 master - 0 0 0 connected 0-5460 10923-16383\n" +
+			"a 10.0.0.1:6379@16379 myself,master - 0 0 2 connected 5461-10922\n" +
+			"c 10.0.0.3:6380@16380 slave,fail b 0 0 0 disconnected\n"))
+
+		w = redeotest.NewRecorder()
+		ClusterMyID("a").ServeRedeo(w, resp.NewCommand("CLUSTER myid"))
+		Expect(w.Response()).To(Equal("a"))
+	})
+
+})